@@ -0,0 +1,255 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ACLRequest struct {
+	By        string
+	Tags      []string
+	Prefix    string
+	Summarize bool
+}
+
+func aclRequestFromQuery(c *gin.Context) (ACLRequest, error) {
+	req := ACLRequest{
+		By:        strings.ToLower(strings.TrimSpace(c.Query("by"))),
+		Prefix:    strings.TrimSpace(c.Query("prefix")),
+		Summarize: parseBool(c.Query("summarize")),
+	}
+	switch req.By {
+	case "":
+		req.By = "vrf"
+	case "vrf", "tag":
+	default:
+		return req, fmt.Errorf("unknown grouping %q (use vrf or tag)", req.By)
+	}
+	if req.Prefix == "" {
+		req.Prefix = "SUBNETIO"
+	}
+	for _, raw := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(raw, ",") {
+			if tag = normalizePolicyTag(tag); tag != "" {
+				req.Tags = append(req.Tags, tag)
+			}
+		}
+	}
+	return req, nil
+}
+
+// ACLObject is one prefix-list / object-group.
+type ACLObject struct {
+	Name     string   `json:"name"`
+	By       string   `json:"by"`
+	Key      string   `json:"key"`
+	Segments int      `json:"segments"`
+	IPv4     []string `json:"ipv4"`
+	IPv6     []string `json:"ipv6"`
+}
+
+// buildACLObjects groups the allocated segments by VRF or by tag.
+func buildACLObjects(segs []Segment, req ACLRequest) []ACLObject {
+	wanted := map[string]bool{}
+	for _, tag := range req.Tags {
+		wanted[tag] = true
+	}
+	type group struct {
+		key      string
+		segments int
+		v4, v6   []netip.Prefix
+	}
+	groups := map[string]*group{}
+	for _, s := range segs {
+		tags := segmentTagSet(s)
+		if len(wanted) > 0 {
+			match := false
+			for tag := range tags {
+				match = match || wanted[tag]
+			}
+			if !match {
+				continue
+			}
+		}
+		v4 := segmentPrefixesV4(s)
+		v6, ok := segmentFamilyPrefix(s, "ipv6")
+		if len(v4) == 0 && !ok {
+			continue
+		}
+		var keys []string
+		if req.By == "tag" {
+			for tag := range tags {
+				if len(wanted) == 0 || wanted[tag] {
+					keys = append(keys, tag)
+				}
+			}
+		} else {
+			keys = []string{strings.TrimSpace(s.VRF)}
+		}
+		for _, key := range keys {
+			g := groups[key]
+			if g == nil {
+				g = &group{key: key}
+				groups[key] = g
+			}
+			g.segments++
+			for _, p := range v4 {
+				g.v4 = append(g.v4, p.Masked())
+			}
+			if ok {
+				g.v6 = append(g.v6, v6)
+			}
+		}
+	}
+	out := []ACLObject{}
+	for _, g := range groups {
+		obj := ACLObject{Name: aclObjectName(req.Prefix, g.key), By: req.By, Key: g.key, Segments: g.segments, IPv4: []string{}, IPv6: []string{}}
+		for _, p := range aclPrefixes(g.v4, req.Summarize) {
+			obj.IPv4 = append(obj.IPv4, p.String())
+		}
+		for _, p := range aclPrefixes(g.v6, req.Summarize) {
+			obj.IPv6 = append(obj.IPv6, p.String())
+		}
+		out = append(out, obj)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// aclObjectName is prefix-key in upper case with anything but letters,
+// digits, '-' and '_' replaced, which every target syntax accepts.
+func aclObjectName(prefix, key string) string {
+	name := strings.ToUpper(prefix + "-" + key)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// aclPrefixes sorts and dedups the prefixes, dropping those inside another
+// one.
+func aclPrefixes(prefixes []netip.Prefix, summarize bool) []netip.Prefix {
+	sortPrefixes := func(list []netip.Prefix) {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Addr() != list[j].Addr() {
+				return list[i].Addr().Less(list[j].Addr())
+			}
+			return list[i].Bits() < list[j].Bits()
+		})
+	}
+	list := append([]netip.Prefix(nil), prefixes...)
+	for {
+		sortPrefixes(list)
+		var out []netip.Prefix
+		for _, p := range list {
+			if n := len(out); n > 0 && prefixWithin(out[n-1], p) {
+				continue
+			}
+			out = append(out, p)
+		}
+		merged := false
+		if summarize {
+			for i := 0; i+1 < len(out); i++ {
+				a, b := out[i], out[i+1]
+				if a.Bits() == 0 || a.Bits() != b.Bits() {
+					continue
+				}
+				parent := netip.PrefixFrom(a.Addr(), a.Bits()-1).Masked()
+				if parent == netip.PrefixFrom(b.Addr(), b.Bits()-1).Masked() {
+					out[i], out[i+1] = parent, parent
+					merged = true
+					i++
+				}
+			}
+		}
+		list = out
+		if !merged {
+			return list
+		}
+	}
+}
+
+// renderACLObjects writes the objects in a vendor syntax or as JSON.
+func renderACLObjects(objs []ACLObject, format string) (string, string, error) {
+	var b strings.Builder
+	comment := func(mark string, o ACLObject) {
+		fmt.Fprintf(&b, "%s %s %s: %d segments\n", mark, strings.ToUpper(o.By), o.Key, o.Segments)
+	}
+	switch format {
+	case "", "cisco":
+		for _, o := range objs {
+			comment("!", o)
+			for i, p := range o.IPv4 {
+				fmt.Fprintf(&b, "ip prefix-list %s seq %d permit %s\n", o.Name, (i+1)*5, p)
+			}
+			for i, p := range o.IPv6 {
+				fmt.Fprintf(&b, "ipv6 prefix-list %s seq %d permit %s\n", o.Name, (i+1)*5, p)
+			}
+			b.WriteString("!\n")
+		}
+		return b.String(), "cfg", nil
+	case "asa":
+		for _, o := range objs {
+			fmt.Fprintf(&b, "object-group network %s\n", o.Name)
+			fmt.Fprintf(&b, " description Subnetio %s %s\n", o.By, o.Key)
+			for _, raw := range o.IPv4 {
+				p := netip.MustParsePrefix(raw)
+				fmt.Fprintf(&b, " network-object %s %s\n", p.Addr(), u32ToIPv4(ipv4Mask(p.Bits())))
+			}
+			for _, p := range o.IPv6 {
+				fmt.Fprintf(&b, " network-object %s\n", p)
+			}
+		}
+		return b.String(), "cfg", nil
+	case "juniper":
+		for _, o := range objs {
+			comment("#", o)
+			for _, p := range append(append([]string(nil), o.IPv4...), o.IPv6...) {
+				fmt.Fprintf(&b, "set policy-options prefix-list %s %s\n", o.Name, p)
+			}
+		}
+		return b.String(), "set", nil
+	case "ipset":
+		for _, o := range objs {
+			comment("#", o)
+			for _, set := range []struct {
+				suffix, family string
+				prefixes       []string
+			}{{"-V4", "inet", o.IPv4}, {"-V6", "inet6", o.IPv6}} {
+				if len(set.prefixes) == 0 {
+					continue
+				}
+				name := ipsetName(o.Name, set.suffix)
+				fmt.Fprintf(&b, "create %s hash:net family %s -exist\n", name, set.family)
+				for _, p := range set.prefixes {
+					fmt.Fprintf(&b, "add %s %s -exist\n", name, p)
+				}
+			}
+		}
+		return b.String(), "ipset", nil
+	case "json":
+		out, err := json.MarshalIndent(objs, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		return string(out) + "\n", "json", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q (use cisco, asa, juniper, ipset or json)", format)
+}
+
+// ipsetName keeps set names within the 31 characters ipset allows.
+func ipsetName(name, suffix string) string {
+	if max := 31 - len(suffix); len(name) > max {
+		name = name[:max]
+	}
+	return name + suffix
+}
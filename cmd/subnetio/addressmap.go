@@ -0,0 +1,259 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"math"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+type AddressMap struct {
+	ProjectID int64     `json:"project_id"`
+	Project   string    `json:"project"`
+	Sites     []MapSite `json:"sites"`
+}
+
+type MapSite struct {
+	ID     int64     `json:"id"`
+	Name   string    `json:"name"`
+	Region string    `json:"region,omitempty"`
+	Color  string    `json:"color"`
+	Pools  []MapPool `json:"pools"`
+}
+
+type MapPool struct {
+	ID          int64      `json:"id"`
+	CIDR        string     `json:"cidr"`
+	Family      string     `json:"family"`
+	Tier        string     `json:"tier,omitempty"`
+	Addresses   string     `json:"addresses"`
+	Used        string     `json:"used"`
+	Utilization float64    `json:"utilization"`
+	Blocks      []MapBlock `json:"blocks"`
+}
+
+type MapBlock struct {
+	SegmentID int64   `json:"segment_id"`
+	Name      string  `json:"name"`
+	VRF       string  `json:"vrf"`
+	VLAN      int     `json:"vlan"`
+	CIDR      string  `json:"cidr"`
+	Addresses string  `json:"addresses"`
+	Offset    float64 `json:"offset"`
+	Fraction  float64 `json:"fraction"`
+	Status    string  `json:"status"`
+}
+
+var regionPalette = []string{"#3b6ea5", "#7a4ea3", "#2f8f83", "#b5651d", "#a23b5a", "#4d7c0f", "#5b6b7a", "#8a6d1f"}
+
+func regionColor(region string) string {
+	region = strings.ToLower(strings.TrimSpace(region))
+	if region == "" {
+		return "#6c757d"
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(region))
+	return regionPalette[int(h.Sum32())%len(regionPalette)]
+}
+
+func buildAddressMap(project Project, sites []Site, pools []Pool, views []SegmentView) AddressMap {
+	out := AddressMap{ProjectID: project.ID, Project: project.Name}
+	poolsBySite := map[int64][]Pool{}
+	for _, p := range pools {
+		poolsBySite[p.SiteID] = append(poolsBySite[p.SiteID], p)
+	}
+	viewsBySite := map[int64][]SegmentView{}
+	for _, v := range views {
+		viewsBySite[v.SiteID] = append(viewsBySite[v.SiteID], v)
+	}
+
+	for _, site := range sites {
+		mapSite := MapSite{
+			ID:     site.ID,
+			Name:   site.Name,
+			Region: nullString(site.Region),
+			Color:  regionColor(nullString(site.Region)),
+		}
+		sitePools := poolsBySite[site.ID]
+		sort.SliceStable(sitePools, func(i, j int) bool {
+			fi, fj := normalizePoolFamily(sitePools[i].Family), normalizePoolFamily(sitePools[j].Family)
+			if fi != fj {
+				return fi < fj
+			}
+			return sitePools[i].CIDR < sitePools[j].CIDR
+		})
+		for _, pool := range sitePools {
+			prefix, err := netip.ParsePrefix(pool.CIDR)
+			if err != nil {
+				continue
+			}
+			mapSite.Pools = append(mapSite.Pools, buildMapPool(pool, prefix.Masked(), viewsBySite[site.ID]))
+		}
+		out.Sites = append(out.Sites, mapSite)
+	}
+	return out
+}
+
+func buildMapPool(pool Pool, prefix netip.Prefix, views []SegmentView) MapPool {
+	size := prefixSize(prefix)
+	start := addrToBig(prefix.Addr())
+	sizeF, _ := new(big.Float).SetInt(size).Float64()
+	used := new(big.Int)
+	mp := MapPool{
+		ID:        pool.ID,
+		CIDR:      prefix.String(),
+		Family:    normalizePoolFamily(pool.Family),
+		Tier:      nullString(pool.Tier),
+		Addresses: size.String(),
+	}
+	for _, v := range views {
+		var cidrs []string
+		if prefix.Addr().Is6() {
+			cidrs = []string{v.CIDRV6}
+		} else {
+			cidrs = []string{v.CIDR}
+			for _, sn := range v.Secondary {
+				cidrs = append(cidrs, sn.CIDR)
+			}
+		}
+		for _, cidr := range cidrs {
+			if cidr == "" {
+				continue
+			}
+			segPrefix, err := netip.ParsePrefix(cidr)
+			if err != nil || !prefixWithin(prefix, segPrefix.Masked()) {
+				continue
+			}
+			mp.addBlock(v, segPrefix.Masked(), start, sizeF, used)
+		}
+	}
+	sort.Slice(mp.Blocks, func(i, j int) bool { return mp.Blocks[i].Offset < mp.Blocks[j].Offset })
+	mp.Used = used.String()
+	usedF, _ := new(big.Float).SetInt(used).Float64()
+	mp.Utilization = math.Round(usedF/sizeF*1000) / 10
+	return mp
+}
+
+func (mp *MapPool) addBlock(v SegmentView, segPrefix netip.Prefix, start *big.Int, sizeF float64, used *big.Int) {
+	segSize := prefixSize(segPrefix)
+	offset := new(big.Int).Sub(addrToBig(segPrefix.Addr()), start)
+	offsetF, _ := new(big.Float).SetInt(offset).Float64()
+	segSizeF, _ := new(big.Float).SetInt(segSize).Float64()
+	used.Add(used, segSize)
+	mp.Blocks = append(mp.Blocks, MapBlock{
+		SegmentID: v.ID,
+		Name:      v.Name,
+		VRF:       v.VRF,
+		VLAN:      v.VLAN,
+		CIDR:      segPrefix.String(),
+		Addresses: segSize.String(),
+		Offset:    offsetF / sizeF,
+		Fraction:  segSizeF / sizeF,
+		Status:    v.StatusLabel,
+	})
+}
+
+func mapStatusColor(status string) string {
+	switch status {
+	case statusConflict.Label():
+		return "#d9534f"
+	case statusWarning.Label():
+		return "#f0ad4e"
+	}
+	return "#5cb85c"
+}
+
+const (
+	mapWidth      = 960.0
+	mapLabelWidth = 160.0
+	mapRowHeight  = 44.0
+	mapRowGap     = 8.0
+	mapSiteGap    = 18.0
+	mapMinPoolPct = 0.06
+)
+
+func renderAddressMapSVG(m AddressMap) []byte {
+	var body bytes.Buffer
+	y := 10.0
+	for _, site := range m.Sites {
+		families := map[string][]MapPool{}
+		var order []string
+		for _, p := range site.Pools {
+			if _, ok := families[p.Family]; !ok {
+				order = append(order, p.Family)
+			}
+			families[p.Family] = append(families[p.Family], p)
+		}
+		label := site.Name
+		if site.Region != "" {
+			label += " · " + site.Region
+		}
+		fmt.Fprintf(&body, `<rect x="0" y="%.1f" width="6" height="%.1f" fill="%s"/>`, y, math.Max(float64(len(order)), 1)*(mapRowHeight+mapRowGap)-mapRowGap, site.Color)
+		fmt.Fprintf(&body, `<text x="12" y="%.1f" class="site">%s</text>`, y+16, html.EscapeString(label))
+		if len(order) == 0 {
+			fmt.Fprintf(&body, `<text x="%.1f" y="%.1f" class="muted">no pools</text>`, mapLabelWidth, y+16)
+			y += mapRowHeight + mapSiteGap
+			continue
+		}
+		for _, family := range order {
+			renderMapRow(&body, families[family], site.Color, y)
+			y += mapRowHeight + mapRowGap
+		}
+		y += mapSiteGap - mapRowGap
+	}
+	if len(m.Sites) == 0 {
+		fmt.Fprintf(&body, `<text x="12" y="%.1f" class="muted">no sites</text>`, y+16)
+		y += mapRowHeight
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" viewBox="0 0 %.0f %.0f" font-family="sans-serif">`, mapWidth, y+10, mapWidth, y+10)
+	out.WriteString(`<style>.site{font-size:13px;font-weight:600;fill:#212529}.muted{font-size:12px;fill:#6c757d}.pool{font-size:11px;fill:#343a40}</style>`)
+	out.Write(body.Bytes())
+	out.WriteString(`</svg>`)
+	return out.Bytes()
+}
+
+func renderMapRow(buf *bytes.Buffer, pools []MapPool, color string, y float64) {
+	width := mapWidth - mapLabelWidth - 10
+	weights := make([]float64, len(pools))
+	total := 0.0
+	for i, p := range pools {
+		if n, ok := new(big.Float).SetString(p.Addresses); ok {
+			weights[i], _ = n.Float64()
+		}
+		total += weights[i]
+	}
+	norm := 0.0
+	for i := range weights {
+		share := 1.0 / float64(len(weights))
+		if total > 0 {
+			share = weights[i] / total
+		}
+		weights[i] = math.Max(share, mapMinPoolPct)
+		norm += weights[i]
+	}
+
+	x := mapLabelWidth
+	for i, p := range pools {
+		w := weights[i] / norm * width
+		fmt.Fprintf(buf, `<g><title>%s (%s) · %.1f%% used</title>`, html.EscapeString(p.CIDR), p.Family, p.Utilization)
+		fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#eef1f5" stroke="%s" stroke-width="1.5"/>`, x, y, math.Max(w-2, 1), mapRowHeight, color)
+		for _, b := range p.Blocks {
+			bx := x + b.Offset*(w-2)
+			bw := math.Max(b.Fraction*(w-2), 1)
+			fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" stroke="#ffffff" stroke-width="0.5"><title>%s · %s vlan %d · %s · %s</title></rect>`,
+				bx, y+14, bw, mapRowHeight-16, mapStatusColor(b.Status),
+				html.EscapeString(b.Name), html.EscapeString(b.VRF), b.VLAN, b.CIDR, html.EscapeString(b.Status))
+		}
+		fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" class="pool">%s</text></g>`, x+4, y+11, html.EscapeString(p.CIDR))
+		x += w
+	}
+}
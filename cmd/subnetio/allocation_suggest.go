@@ -0,0 +1,192 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// AllocationAlternative is one way around an ALLOCATE_FAIL.
+type AllocationAlternative struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+const (
+	altSmallerPrefix = "smaller_prefix"
+	altMultiPrefix   = "multi_prefix"
+	altOtherSite     = "other_site"
+	altFreeSpace     = "free_space"
+
+	// suggestWindowLimit bounds how many candidate blocks per pool are
+	// scanned for the free-space suggestion.
+	suggestWindowLimit = 4096
+)
+
+// AllocationError is returned by allocateProject when a segment does not
+// fit; Error keeps the plain message, Alternatives the suggestions.
+type AllocationError struct {
+	SiteID       int64
+	Segment      Segment
+	Family       string
+	Want         int
+	Detail       string
+	Alternatives []AllocationAlternative
+}
+
+func (e *AllocationError) Error() string { return e.Detail }
+
+func allocateFailConflict(items []poolItem, s Segment, want int, used []netip.Prefix, rules ProjectRules, family string) Conflict {
+	return Conflict{
+		Kind:         "ALLOCATE_FAIL",
+		Detail:       "segment " + s.Name + " could not be allocated (" + family + ")",
+		Level:        statusWarning.Label(),
+		Alternatives: suggestAllocationAlternatives(items, s, want, used, rules, family),
+		segmentID:    s.ID,
+		key:          segmentConflictKey(s) + " " + family,
+	}
+}
+
+func segmentInList(segs []Segment, id int64) (Segment, bool) {
+	for _, s := range segs {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Segment{}, false
+}
+
+// suggestAllocationAlternatives looks for alternatives within the pools the
+// segment was allowed to use.
+func suggestAllocationAlternatives(items []poolItem, s Segment, want int, used []netip.Prefix, rules ProjectRules, family string) []AllocationAlternative {
+	var out []AllocationAlternative
+	maxBits := 30
+	if family == "ipv6" {
+		maxBits = 128
+	}
+	for bits := want + 1; bits <= maxBits; bits++ {
+		found := false
+		for _, pool := range items {
+			p, ok := allocateSegmentInPool(pool.Prefix, s, bits, used, rules, family)
+			if !ok {
+				continue
+			}
+			detail := "largest free block is /" + itoa(bits) + " (" + p.String() + " in pool " + pool.Prefix.String()
+			if family == "ipv4" {
+				detail += ", " + itoa64(usableHostsIPv4(bits)) + " hosts"
+			}
+			out = append(out, AllocationAlternative{Kind: altSmallerPrefix, Detail: detail + ") instead of /" + itoa(want)})
+			found = true
+			break
+		}
+		if found {
+			break
+		}
+	}
+	if family == "ipv4" && !s.MultiPrefix && !s.Prefix.Valid && s.Hosts.Valid {
+		multi := s
+		multi.MultiPrefix = true
+		if parts, ok := allocateMultiPrefix(items, multi, used, rules, family); ok {
+			out = append(out, AllocationAlternative{
+				Kind:   altMultiPrefix,
+				Detail: "enable multiple prefixes to split it over " + joinSecondaryCIDRs(parts),
+			})
+		}
+	}
+	if alt, ok := leastOccupiedBlock(items, want, used); ok {
+		out = append(out, alt)
+	}
+	return out
+}
+
+// leastOccupiedBlock finds the aligned /want block with the fewest addresses
+// in use, i.e. the smallest amount of space that has to be freed.
+func leastOccupiedBlock(items []poolItem, want int, used []netip.Prefix) (AllocationAlternative, bool) {
+	var best netip.Prefix
+	var bestUsed *big.Int
+	var bestPool netip.Prefix
+	for _, pool := range items {
+		pool := pool.Prefix.Masked()
+		width := addrBitLen(pool.Addr())
+		if want < pool.Bits() || want > width {
+			continue
+		}
+		step := new(big.Int).Lsh(big.NewInt(1), uint(width-want))
+		start := addrToBig(pool.Addr())
+		end := new(big.Int).Add(start, prefixSize(pool))
+		ranges := buildUsedRangesBig(pool, used)
+		for i, cur := 0, start; i < suggestWindowLimit && cur.Cmp(end) < 0; i, cur = i+1, new(big.Int).Add(cur, step) {
+			last := new(big.Int).Sub(new(big.Int).Add(cur, step), big.NewInt(1))
+			taken := big.NewInt(0)
+			for _, r := range ranges {
+				lo, hi := r.start, r.end
+				if hi.Cmp(cur) < 0 || lo.Cmp(last) > 0 {
+					continue
+				}
+				if lo.Cmp(cur) < 0 {
+					lo = cur
+				}
+				if hi.Cmp(last) > 0 {
+					hi = last
+				}
+				taken.Add(taken, new(big.Int).Add(new(big.Int).Sub(hi, lo), big.NewInt(1)))
+			}
+			if bestUsed == nil || taken.Cmp(bestUsed) < 0 {
+				addr, ok := bigToAddr(cur, width)
+				if !ok {
+					break
+				}
+				best, bestUsed, bestPool = netip.PrefixFrom(addr, want), taken, pool
+			}
+		}
+	}
+	if bestUsed == nil || bestUsed.Sign() == 0 {
+		return AllocationAlternative{}, false
+	}
+	return AllocationAlternative{
+		Kind:   altFreeSpace,
+		Detail: "free " + formatBigInt(bestUsed) + " of " + formatBigInt(prefixSize(best)) + " addresses in " + best.String() + " (pool " + bestPool.String() + ")",
+	}, true
+}
+
+// otherSiteAlternatives lists other sites whose pools currently have a free
+// block of the requested size.
+func otherSiteAlternatives(siteID int64, want int, family string, sites map[int64]string, pools map[int64][]Pool, segs map[int64][]Segment, reserved map[int64][]netip.Prefix) []AllocationAlternative {
+	ids := make([]int64, 0, len(pools))
+	for id := range pools {
+		if id != siteID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return sites[ids[i]] < sites[ids[j]] })
+	var out []AllocationAlternative
+	for _, id := range ids {
+		var used []netip.Prefix
+		for _, s := range segs[id] {
+			if family == "ipv4" {
+				used = append(used, segmentPrefixesV4(s)...)
+			} else if s.CIDRV6.Valid {
+				if p, err := netip.ParsePrefix(strings.TrimSpace(s.CIDRV6.String)); err == nil {
+					used = append(used, p)
+				}
+			}
+		}
+		used = append(used, reserved[id]...)
+		for _, pool := range poolItemsForFamily(pools[id], family) {
+			if isDrainingPool(pool.Pool) {
+				continue
+			}
+			if p, ok := allocateInPool(pool.Prefix, want, used); ok {
+				out = append(out, AllocationAlternative{
+					Kind:   altOtherSite,
+					Detail: "site " + sites[id] + " has room: " + p.String() + " in pool " + pool.Prefix.String(),
+				})
+				break
+			}
+		}
+	}
+	return out
+}
@@ -61,10 +61,12 @@ func segmentTierValue(s Segment) string {
 	return ""
 }
 
+// poolItemsForFamily returns the segment pools of a family in allocation
+// order. Loopback and NAT pools are left out.
 func poolItemsForFamily(pools []Pool, family string) []poolItem {
 	items := make([]poolItem, 0, len(pools))
 	for _, p := range pools {
-		if normalizePoolFamily(p.Family) != family {
+		if normalizePoolFamily(p.Family) != family || !poolHoldsSegments(p) {
 			continue
 		}
 		prefix, err := netip.ParsePrefix(strings.TrimSpace(p.CIDR))
@@ -91,13 +93,25 @@ func poolItemsForFamily(pools []Pool, family string) []poolItem {
 	return items
 }
 
-func allocateProject(db *sql.DB, projectID int64) error {
+// allocationCheck inspects the segments before and after a planned allocation,
+// before anything is written; returning an error cancels the allocation.
+type allocationCheck func(before, after []Segment) error
+
+func allocateProject(db *sql.DB, projectID int64, check allocationCheck) error {
 	sites, err := listSites(db, projectID)
 	if err != nil {
 		return err
 	}
 	rules, _ := getProjectRules(db, projectID)
 
+	type siteInput struct {
+		site       Site
+		pools      []Pool
+		segs       []Segment
+		reservedV4 []netip.Prefix
+		reservedV6 []netip.Prefix
+	}
+	var inputs []siteInput
 	for _, site := range sites {
 		pools, err := poolsBySite(db, site.ID)
 		if err != nil {
@@ -113,34 +127,131 @@ func allocateProject(db *sql.DB, projectID int64) error {
 		}
 
 		reservedV4, reservedV6, _ := reservedRangesBySite(db, site.ID)
+		inputs = append(inputs, siteInput{site: site, pools: pools, segs: segs, reservedV4: reservedV4, reservedV6: reservedV6})
+	}
+	var before []Segment
+	if check != nil {
+		if before, err = listSegments(db, projectID); err != nil {
+			return err
+		}
+	}
+	var derivedBefore []derivedSegment
+	if rules.PersistDerived {
+		if derivedBefore, err = loadDerivedSegments(db, projectID); err != nil {
+			return err
+		}
+	}
 
-		tx, err := db.Begin()
-		if err != nil {
+	quotaBefore, err := projectQuotaUsage(db, projectID)
+	if err != nil {
+		return err
+	}
+
+	otherSites := func(err error) error {
+		var allocErr *AllocationError
+		if !errors.As(err, &allocErr) || allocErr.Want == 0 {
+			return err
+		}
+		names := map[int64]string{}
+		pools := map[int64][]Pool{}
+		segs := map[int64][]Segment{}
+		reserved := map[int64][]netip.Prefix{}
+		for _, in := range inputs {
+			names[in.site.ID] = in.site.Name
+			pools[in.site.ID] = in.pools
+			segs[in.site.ID] = in.segs
+			reserved[in.site.ID] = in.reservedV4
+			if allocErr.Family == "ipv6" {
+				reserved[in.site.ID] = in.reservedV6
+			}
+		}
+		allocErr.Alternatives = append(allocErr.Alternatives, otherSiteAlternatives(allocErr.SiteID, allocErr.Want, allocErr.Family, names, pools, segs, reserved)...)
+		return allocErr
+	}
+	var plans []*familyAllocation
+	for _, in := range inputs {
+		for _, family := range []string{"ipv4", "ipv6"} {
+			reserved := in.reservedV4
+			if family == "ipv6" {
+				reserved = in.reservedV6
+			}
+			plan, err := planFamilyAllocation(in.site.ID, in.segs, in.pools, reserved, rules, family)
+			if err != nil {
+				return otherSites(err)
+			}
+			if plan != nil {
+				plans = append(plans, plan)
+			}
+		}
+	}
+	if check != nil {
+		after := make([]Segment, len(before))
+		copy(after, before)
+		for _, plan := range plans {
+			plan.apply(after)
+		}
+		if err := check(before, after); err != nil {
+			return err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, plan := range plans {
+		if err := plan.write(tx); err != nil {
+			_ = tx.Rollback()
 			return err
 		}
-		if err := allocateFamily(tx, site.ID, segs, pools, reservedV4, rules, "ipv4"); err != nil {
+	}
+	if err := assignLoopbacks(tx, projectID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if rules.PersistDerived {
+		if err := persistDerivedMeta(tx, projectID, derivedBefore); err != nil {
 			_ = tx.Rollback()
 			return err
 		}
-		if err := allocateFamily(tx, site.ID, segs, pools, reservedV6, rules, "ipv6"); err != nil {
+	}
+	if quota, ok := getProjectQuota(tx, projectID); ok {
+		after, err := projectQuotaUsage(tx, projectID)
+		if err != nil {
 			_ = tx.Rollback()
 			return err
 		}
-		if err := tx.Commit(); err != nil {
+		if err := quota.checkGrowth(quotaBefore, after); err != nil {
+			_ = tx.Rollback()
 			return err
 		}
 	}
+	return tx.Commit()
+}
 
-	return nil
+// familyAllocation is the planned allocation of one address family at a site.
+type familyAllocation struct {
+	siteID      int64
+	family      string
+	allocations map[int64]netip.Prefix
+	secondaries map[int64][]netip.Prefix
 }
 
-func allocateFamily(execer sqlExecer, siteID int64, segs []Segment, pools []Pool, reserved []netip.Prefix, rules ProjectRules, family string) error {
+// planFamilyAllocation returns nil when the site has nothing to allocate in
+// the family.
+func planFamilyAllocation(siteID int64, segs []Segment, pools []Pool, reserved []netip.Prefix, rules ProjectRules, family string) (*familyAllocation, error) {
 	items := poolItemsForFamily(pools, family)
 	if len(items) == 0 {
-		return nil
+		return nil, nil
 	}
+	items, draining := splitDrainingPools(items)
+	items, linkItems := splitLinkPools(items)
+	held := heldInDrainingPools(segs, draining, family)
 
 	var used []netip.Prefix
+	for _, p := range held {
+		used = append(used, p)
+	}
 	for _, s := range segs {
 		if !s.Locked {
 			continue
@@ -153,12 +264,15 @@ func allocateFamily(execer sqlExecer, siteID int64, segs []Segment, pools []Pool
 		if err == nil {
 			used = append(used, p)
 		}
+		if family == "ipv4" {
+			used = append(used, segmentSecondaryPrefixes(s)...)
+		}
 	}
 	used = append(used, reserved...)
 
 	candidates := make([]Segment, 0, len(segs))
 	for _, s := range segs {
-		if s.Locked {
+		if _, ok := held[s.ID]; s.Locked || ok {
 			continue
 		}
 		want := desiredPrefixByFamily(s, family)
@@ -167,42 +281,111 @@ func allocateFamily(execer sqlExecer, siteID int64, segs []Segment, pools []Pool
 		}
 		candidates = append(candidates, s)
 	}
+	var links []Segment
+	if len(linkItems) > 0 {
+		candidates, links = splitLinkSegments(candidates)
+	}
 
-	if len(candidates) == 0 {
-		return nil
+	if len(candidates) == 0 && len(links) == 0 {
+		return nil, nil
 	}
 
 	sort.SliceStable(candidates, func(i, j int) bool {
 		return desiredPrefixByFamily(candidates[i], family) < desiredPrefixByFamily(candidates[j], family)
 	})
 
+	ledger := newVRFShareLedger(items, segs, family)
 	allocations := map[int64]netip.Prefix{}
+	var secondaries map[int64][]netip.Prefix
 	var conflicts []Conflict
 	switch rules.PoolStrategy {
 	case PoolStrategyContig:
-		allocations, conflicts = allocateContiguous(items, candidates, used, rules, family, true)
+		allocations, secondaries, conflicts = allocateContiguous(items, candidates, used, ledger, rules, family, true)
 	case PoolStrategyTiered:
-		allocations, conflicts = allocateSpillover(items, candidates, used, rules, family, true)
+		allocations, secondaries, conflicts = allocateSpillover(items, candidates, used, ledger, rules, family, true)
 	default:
-		allocations, conflicts = allocateSpillover(items, candidates, used, rules, family, true)
+		allocations, secondaries, conflicts = allocateSpillover(items, candidates, used, ledger, rules, family, true)
+	}
+	if len(conflicts) == 0 && len(links) > 0 {
+		for _, p := range allocations {
+			used = append(used, p)
+		}
+		for _, extra := range secondaries {
+			used = append(used, extra...)
+		}
+		var linkAlloc map[int64]netip.Prefix
+		linkAlloc, conflicts = allocateLinks(linkItems, links, used, family, true)
+		for id, p := range linkAlloc {
+			allocations[id] = p
+		}
 	}
 	if len(conflicts) > 0 {
-		return errors.New(conflicts[0].Detail)
+		failed, _ := segmentInList(segs, conflicts[0].segmentID)
+		return nil, &AllocationError{
+			SiteID:       siteID,
+			Segment:      failed,
+			Family:       family,
+			Want:         desiredPrefixByFamily(failed, family),
+			Detail:       conflicts[0].Detail,
+			Alternatives: conflicts[0].Alternatives,
+		}
 	}
 
-	if err := clearCIDRsByFamily(execer, siteID, family); err != nil {
+	for id, p := range held {
+		allocations[id] = p
+	}
+	return &familyAllocation{siteID: siteID, family: family, allocations: allocations, secondaries: secondaries}, nil
+}
+
+func (a *familyAllocation) write(execer sqlExecer) error {
+	if err := clearCIDRsByFamily(execer, a.siteID, a.family); err != nil {
 		return err
 	}
-	for id, p := range allocations {
-		if err := updateSegmentCIDRByFamily(execer, id, family, p.String()); err != nil {
+	for id, p := range a.allocations {
+		if err := updateSegmentCIDRByFamily(execer, id, a.family, p.String()); err != nil {
+			return err
+		}
+	}
+	for id, extra := range a.secondaries {
+		if _, err := execer.Exec(`UPDATE segments SET secondary_cidrs=? WHERE id=?`, joinSecondaryCIDRs(extra), id); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func allocateSpillover(items []poolItem, segments []Segment, used []netip.Prefix, rules ProjectRules, family string, strict bool) (map[int64]netip.Prefix, []Conflict) {
+// apply changes segs the way write changes the segments table.
+func (a *familyAllocation) apply(segs []Segment) {
+	for i := range segs {
+		s := &segs[i]
+		if s.SiteID != a.siteID || s.Locked {
+			continue
+		}
+		if a.family == "ipv6" {
+			s.CIDRV6 = sql.NullString{}
+		} else {
+			s.CIDR = sql.NullString{}
+			s.SecondaryCIDRs = sql.NullString{}
+		}
+	}
+	for i := range segs {
+		s := &segs[i]
+		if p, ok := a.allocations[s.ID]; ok {
+			if a.family == "ipv6" {
+				s.CIDRV6 = sql.NullString{String: p.String(), Valid: true}
+			} else {
+				s.CIDR = sql.NullString{String: p.String(), Valid: true}
+			}
+		}
+		if extra, ok := a.secondaries[s.ID]; ok {
+			s.SecondaryCIDRs = sql.NullString{String: joinSecondaryCIDRs(extra), Valid: true}
+		}
+	}
+}
+
+func allocateSpillover(items []poolItem, segments []Segment, used []netip.Prefix, ledger *vrfShareLedger, rules ProjectRules, family string, strict bool) (map[int64]netip.Prefix, map[int64][]netip.Prefix, []Conflict) {
 	alloc := map[int64]netip.Prefix{}
+	secondaries := map[int64][]netip.Prefix{}
 	var conflicts []Conflict
 	for _, s := range segments {
 		want := desiredPrefixByFamily(s, family)
@@ -215,20 +398,31 @@ func allocateSpillover(items []poolItem, segments []Segment, used []netip.Prefix
 			poolList = filterPoolsByTier(items, tier, rules.PoolTierFallback)
 		}
 		var allocated *netip.Prefix
+		var blocked []poolItem
 		for _, pool := range poolList {
-			p, ok := allocateInPool(pool.Prefix, want, used)
+			p, ok := allocateSegmentInPool(pool.Prefix, s, want, used, rules, family)
+			if ok && !ledger.fits(pool, s.VRF, p) {
+				blocked = append(blocked, pool)
+				continue
+			}
 			if ok {
 				allocated = &p
 				used = append(used, p)
+				ledger.take(pool, s.VRF, p)
 				break
 			}
 		}
 		if allocated == nil {
-			conflicts = append(conflicts, Conflict{
-				Kind:   "ALLOCATE_FAIL",
-				Detail: "segment " + s.Name + " could not be allocated (" + family + ")",
-				Level:  statusWarning.Label(),
-			})
+			if parts, ok := allocateMultiPrefix(poolList, s, used, rules, family); ok && ledger.fitsAll(poolList, s.VRF, parts) {
+				used = append(used, parts...)
+				ledger.takeAll(poolList, s.VRF, parts)
+				alloc[s.ID] = parts[0]
+				secondaries[s.ID] = parts[1:]
+				continue
+			}
+			conflict := allocateFailConflict(poolList, s, want, used, rules, family)
+			conflict.Detail += ledger.shareDetail(s.VRF, blocked)
+			conflicts = append(conflicts, conflict)
 			if strict {
 				break
 			}
@@ -236,14 +430,16 @@ func allocateSpillover(items []poolItem, segments []Segment, used []netip.Prefix
 		}
 		alloc[s.ID] = *allocated
 	}
-	return alloc, conflicts
+	return alloc, secondaries, conflicts
 }
 
-func allocateContiguous(items []poolItem, segments []Segment, used []netip.Prefix, rules ProjectRules, family string, strict bool) (map[int64]netip.Prefix, []Conflict) {
+func allocateContiguous(items []poolItem, segments []Segment, used []netip.Prefix, ledger *vrfShareLedger, rules ProjectRules, family string, strict bool) (map[int64]netip.Prefix, map[int64][]netip.Prefix, []Conflict) {
 	alloc := map[int64]netip.Prefix{}
+	secondaries := map[int64][]netip.Prefix{}
 	var conflicts []Conflict
 	pending := make([]Segment, 0, len(segments))
 	pending = append(pending, segments...)
+	blocked := map[int64][]poolItem{}
 	for _, pool := range items {
 		if len(pending) == 0 {
 			break
@@ -261,9 +457,14 @@ func allocateContiguous(items []poolItem, segments []Segment, used []netip.Prefi
 					continue
 				}
 			}
-			p, ok := allocateInPool(pool.Prefix, want, used)
+			p, ok := allocateSegmentInPool(pool.Prefix, s, want, used, rules, family)
+			if ok && !ledger.fits(pool, s.VRF, p) {
+				blocked[s.ID] = append(blocked[s.ID], pool)
+				ok = false
+			}
 			if ok {
 				used = append(used, p)
+				ledger.take(pool, s.VRF, p)
 				alloc[s.ID] = p
 				continue
 			}
@@ -271,19 +472,26 @@ func allocateContiguous(items []poolItem, segments []Segment, used []netip.Prefi
 		}
 		pending = nextPending
 	}
-	if len(pending) > 0 {
-		for _, s := range pending {
-			conflicts = append(conflicts, Conflict{
-				Kind:   "ALLOCATE_FAIL",
-				Detail: "segment " + s.Name + " could not be allocated (" + family + ")",
-				Level:  statusWarning.Label(),
-			})
-			if strict {
-				break
-			}
+	for _, s := range pending {
+		poolList := items
+		if rules.PoolStrategy == PoolStrategyTiered {
+			poolList = filterPoolsByTier(items, segmentTierValue(s), rules.PoolTierFallback)
+		}
+		if parts, ok := allocateMultiPrefix(poolList, s, used, rules, family); ok && ledger.fitsAll(poolList, s.VRF, parts) {
+			used = append(used, parts...)
+			ledger.takeAll(poolList, s.VRF, parts)
+			alloc[s.ID] = parts[0]
+			secondaries[s.ID] = parts[1:]
+			continue
+		}
+		conflict := allocateFailConflict(poolList, s, desiredPrefixByFamily(s, family), used, rules, family)
+		conflict.Detail += ledger.shareDetail(s.VRF, blocked[s.ID])
+		conflicts = append(conflicts, conflict)
+		if strict {
+			break
 		}
 	}
-	return alloc, conflicts
+	return alloc, secondaries, conflicts
 }
 
 func poolTierMatches(pool poolItem, tier string, fallback bool) bool {
@@ -447,7 +655,7 @@ func clearCIDRsByFamily(execer sqlExecer, siteID int64, family string) error {
 		_, err := execer.Exec(`UPDATE segments SET cidr_v6=NULL WHERE site_id=? AND locked=0`, siteID)
 		return err
 	}
-	_, err := execer.Exec(`UPDATE segments SET cidr=NULL WHERE site_id=? AND locked=0`, siteID)
+	_, err := execer.Exec(`UPDATE segments SET cidr=NULL, secondary_cidrs=NULL WHERE site_id=? AND locked=0`, siteID)
 	return err
 }
 
@@ -470,6 +678,13 @@ func planAllocations(segs []Segment, pools []Pool, reservedV4 map[int64][]netip.
 		poolsBySite[p.SiteID] = append(poolsBySite[p.SiteID], p)
 	}
 
+	siteNames := map[int64]string{}
+	for _, p := range pools {
+		siteNames[p.SiteID] = p.Site
+	}
+	for _, s := range segs {
+		siteNames[s.SiteID] = s.Site
+	}
 	planV4 := map[int64]netip.Prefix{}
 	planV6 := map[int64]netip.Prefix{}
 	var conflicts []Conflict
@@ -479,6 +694,20 @@ func planAllocations(segs []Segment, pools []Pool, reservedV4 map[int64][]netip.
 
 		allocV4, cfV4 := planAllocateFamily(siteSegs, sitePools, reservedV4[siteID], rules, "ipv4")
 		allocV6, cfV6 := planAllocateFamily(siteSegs, sitePools, reservedV6[siteID], rules, "ipv6")
+		for _, fc := range []struct {
+			family    string
+			conflicts []Conflict
+			reserved  map[int64][]netip.Prefix
+		}{{"ipv4", cfV4, reservedV4}, {"ipv6", cfV6, reservedV6}} {
+			for i, c := range fc.conflicts {
+				if c.Kind != "ALLOCATE_FAIL" {
+					continue
+				}
+				if s, ok := segmentInList(siteSegs, c.segmentID); ok {
+					fc.conflicts[i].Alternatives = append(c.Alternatives, otherSiteAlternatives(siteID, desiredPrefixByFamily(s, fc.family), fc.family, siteNames, poolsBySite, segmentsBySite, fc.reserved)...)
+				}
+			}
+		}
 		for id, p := range allocV4 {
 			planV4[id] = p
 		}
@@ -502,11 +731,17 @@ func planAllocateFamily(segs []Segment, pools []Pool, reserved []netip.Prefix, r
 			Kind:   "POOL_MISSING",
 			Detail: "no pools for family " + family,
 			Level:  statusWarning.Label(),
+			key:    siteConflictKey(segs[0].Site) + " " + family,
 		}}
 	}
+	items, draining := splitDrainingPools(items)
+	items, linkItems := splitLinkPools(items)
 
 	var used []netip.Prefix
-	plan := map[int64]netip.Prefix{}
+	plan := heldInDrainingPools(segs, draining, family)
+	for _, p := range plan {
+		used = append(used, p)
+	}
 	var conflicts []Conflict
 
 	for _, s := range segs {
@@ -519,6 +754,7 @@ func planAllocateFamily(segs []Segment, pools []Pool, reserved []netip.Prefix, r
 				Kind:   "LOCKED_NO_CIDR",
 				Detail: "segment " + s.Name + " is locked without CIDR (" + family + ")",
 				Level:  statusWarning.Label(),
+				key:    segmentConflictKey(s) + " " + family,
 			})
 			continue
 		}
@@ -526,12 +762,15 @@ func planAllocateFamily(segs []Segment, pools []Pool, reserved []netip.Prefix, r
 			used = append(used, p)
 			plan[s.ID] = p
 		}
+		if family == "ipv4" {
+			used = append(used, segmentSecondaryPrefixes(s)...)
+		}
 	}
 	used = append(used, reserved...)
 
 	var candidates []Segment
 	for _, s := range segs {
-		if s.Locked {
+		if _, ok := plan[s.ID]; s.Locked || ok {
 			continue
 		}
 		want := desiredPrefixByFamily(s, family)
@@ -540,29 +779,43 @@ func planAllocateFamily(segs []Segment, pools []Pool, reserved []netip.Prefix, r
 				Kind:   "SIZE_MISSING",
 				Detail: "segment " + s.Name + " has no size request (" + family + ")",
 				Level:  statusWarning.Label(),
+				key:    segmentConflictKey(s) + " " + family,
 			})
 			continue
 		}
 		candidates = append(candidates, s)
 	}
+	var links []Segment
+	if len(linkItems) > 0 {
+		candidates, links = splitLinkSegments(candidates)
+	}
 
 	sort.SliceStable(candidates, func(i, j int) bool {
 		return desiredPrefixByFamily(candidates[i], family) < desiredPrefixByFamily(candidates[j], family)
 	})
 
+	ledger := newVRFShareLedger(items, segs, family)
 	var alloc map[int64]netip.Prefix
 	var cf []Conflict
 	switch rules.PoolStrategy {
 	case PoolStrategyContig:
-		alloc, cf = allocateContiguous(items, candidates, used, rules, family, false)
+		alloc, _, cf = allocateContiguous(items, candidates, used, ledger, rules, family, false)
 	case PoolStrategyTiered:
-		alloc, cf = allocateSpillover(items, candidates, used, rules, family, false)
+		alloc, _, cf = allocateSpillover(items, candidates, used, ledger, rules, family, false)
 	default:
-		alloc, cf = allocateSpillover(items, candidates, used, rules, family, false)
+		alloc, _, cf = allocateSpillover(items, candidates, used, ledger, rules, family, false)
 	}
 	conflicts = append(conflicts, cf...)
 	for id, p := range alloc {
 		plan[id] = p
+		used = append(used, p)
+	}
+	if len(links) > 0 {
+		linkAlloc, linkConflicts := allocateLinks(linkItems, links, used, family, false)
+		conflicts = append(conflicts, linkConflicts...)
+		for id, p := range linkAlloc {
+			plan[id] = p
+		}
 	}
 	return plan, conflicts
 }
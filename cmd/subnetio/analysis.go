@@ -10,6 +10,7 @@ import (
 	"net/netip"
 	"sort"
 	"strings"
+	"time"
 )
 
 type SegmentView struct {
@@ -30,6 +31,18 @@ type SegmentView struct {
 	StatusClass  string
 	StatusDetail string
 	Reservations string
+	DhcpPolicies []DHCPPolicy
+	Lifetime     string
+	Secondary    []SecondaryNetwork
+}
+
+// SecondaryNetwork is an additional IPv4 prefix of a multi-prefix segment.
+type SecondaryNetwork struct {
+	CIDR      string `json:"cidr"`
+	Mask      string `json:"mask"`
+	Network   string `json:"network"`
+	Broadcast string `json:"broadcast"`
+	Gateway   string `json:"gateway"`
 }
 
 type SegmentStatus struct {
@@ -79,6 +92,9 @@ func buildPoolIndex(pools []Pool) (map[int64][]netip.Prefix, map[int64][]netip.P
 	outV4 := make(map[int64][]netip.Prefix)
 	outV6 := make(map[int64][]netip.Prefix)
 	for _, p := range pools {
+		if !poolHoldsSegments(p) {
+			continue
+		}
 		prefix, err := netip.ParsePrefix(p.CIDR)
 		if err != nil {
 			continue
@@ -174,6 +190,7 @@ func buildReservedIndex(sites []Site) (map[int64][]netip.Prefix, map[int64][]net
 					Kind:   "RESERVED_PARSE",
 					Detail: "site=" + s.Name + " bad reserved range: " + part,
 					Level:  statusWarning.Label(),
+					key:    siteConflictKey(s.Name) + " range=" + part,
 				})
 				continue
 			}
@@ -194,7 +211,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 	var conflicts []Conflict
 
 	segByID := map[int64]Segment{}
-	prefixByID := map[int64]netip.Prefix{}
+	prefixesByID := map[int64][]netip.Prefix{}
 	prefixByIDV6 := map[int64]netip.Prefix{}
 	prefixOK := map[int64]bool{}
 	prefixOKV6 := map[int64]bool{}
@@ -220,29 +237,42 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 					Kind:   "CIDR_PARSE",
 					Detail: "segment " + s.Name + " site=" + s.Site + " cidr=" + s.CIDR.String + " parse error",
 					Level:  statusConflict.Label(),
+					key:    segmentConflictKey(s),
 				})
 			} else {
-				prefixByID[s.ID] = p
 				prefixOK[s.ID] = true
+				secondary, err := parseSecondaryCIDRs(nullString(s.SecondaryCIDRs))
+				if err != nil {
+					addStatus(statuses, s.ID, statusConflict, "invalid secondary CIDR")
+					conflicts = append(conflicts, Conflict{
+						Kind:   "CIDR_PARSE",
+						Detail: "segment " + s.Name + " site=" + s.Site + ": " + err.Error(),
+						Level:  statusConflict.Label(),
+						key:    segmentConflictKey(s) + " secondary",
+					})
+				}
+				prefixesByID[s.ID] = append([]netip.Prefix{p}, secondary...)
 
 				pools := poolsV4[s.SiteID]
 				if len(pools) == 0 {
 					addStatus(statuses, s.ID, statusWarning, "no pool defined for site")
-				} else if !prefixInAnyPool(p, pools) {
-					level := statusWarning
-					if rules.RequireInPool {
-						level = statusConflict
-					}
-					addStatus(statuses, s.ID, level, "out of pool")
-					conflicts = append(conflicts, Conflict{
-						Kind:   "OUT_OF_POOL",
-						Detail: "segment " + s.Name + " site=" + s.Site + " cidr=" + p.String() + " outside pools: " + joinPrefixes(pools),
-						Level:  level.Label(),
-					})
 				}
+				for _, p := range prefixesByID[s.ID] {
+					if len(pools) > 0 && !prefixInAnyPool(p, pools) {
+						level := statusWarning
+						if rules.RequireInPool {
+							level = statusConflict
+						}
+						addStatus(statuses, s.ID, level, "out of pool")
+						conflicts = append(conflicts, Conflict{
+							Kind:   "OUT_OF_POOL",
+							Detail: "segment " + s.Name + " site=" + s.Site + " cidr=" + p.String() + " outside pools: " + joinPrefixes(pools),
+							Level:  level.Label(),
+							key:    segmentConflictKey(s) + " cidr=" + p.String(),
+						})
+					}
 
-				if ranges := reservedV4[s.SiteID]; len(ranges) > 0 {
-					for _, r := range ranges {
+					for _, r := range reservedV4[s.SiteID] {
 						if prefixesOverlap(r, p) {
 							level := statusWarning
 							if !rules.AllowReservedOverlap {
@@ -253,6 +283,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 								Kind:   "RESERVED_OVERLAP",
 								Detail: "segment " + s.Name + " site=" + s.Site + " cidr=" + p.String() + " overlaps reserved " + r.String(),
 								Level:  level.Label(),
+								key:    segmentConflictKey(s) + " cidr=" + p.String() + " reserved=" + r.String(),
 							})
 							break
 						}
@@ -269,6 +300,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 					Kind:   "CIDR6_PARSE",
 					Detail: "segment " + s.Name + " site=" + s.Site + " cidr_v6=" + s.CIDRV6.String + " parse error",
 					Level:  statusConflict.Label(),
+					key:    segmentConflictKey(s),
 				})
 			} else {
 				prefixByIDV6[s.ID] = p6
@@ -287,6 +319,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 						Kind:   "OUT_OF_POOL_V6",
 						Detail: "segment " + s.Name + " site=" + s.Site + " cidr_v6=" + p6.String() + " outside v6 pools: " + joinPrefixes(pools),
 						Level:  level.Label(),
+						key:    segmentConflictKey(s),
 					})
 				}
 
@@ -302,6 +335,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 								Kind:   "RESERVED_OVERLAP_V6",
 								Detail: "segment " + s.Name + " site=" + s.Site + " cidr_v6=" + p6.String() + " overlaps reserved " + r.String(),
 								Level:  level.Label(),
+								key:    segmentConflictKey(s) + " reserved=" + r.String(),
 							})
 							break
 						}
@@ -324,9 +358,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 			for j := i + 1; j < len(ids); j++ {
 				a := ids[i]
 				b := ids[j]
-				p1 := prefixByID[a]
-				p2 := prefixByID[b]
-				if prefixesOverlap(p1, p2) {
+				if p1, p2, ok := firstOverlap(prefixesByID[a], prefixesByID[b]); ok {
 					s1 := segByID[a]
 					s2 := segByID[b]
 					addStatus(statuses, s1.ID, statusConflict, "overlap with "+s2.Name)
@@ -335,6 +367,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 						Kind:   "OVERLAP",
 						Detail: "site=" + k.site + " vrf=" + k.vrf + ": " + s1.Name + " " + p1.String() + " overlaps " + s2.Name + " " + p2.String(),
 						Level:  statusConflict.Label(),
+						key:    segmentConflictKey(s1, s2),
 					})
 				}
 			}
@@ -364,6 +397,7 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 						Kind:   "OVERLAP_V6",
 						Detail: "site=" + k.site + " vrf=" + k.vrf + ": " + s1.Name + " " + p1.String() + " overlaps " + s2.Name + " " + p2.String(),
 						Level:  statusConflict.Label(),
+						key:    segmentConflictKey(s1, s2),
 					})
 				}
 			}
@@ -377,10 +411,15 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 			first := segByID[firstID]
 			addStatus(statuses, s.ID, statusConflict, "duplicate VLAN")
 			addStatus(statuses, firstID, statusConflict, "duplicate VLAN")
+			scope := "site=" + s.Site
+			if first.Site != s.Site {
+				scope = "vlan_domain=" + s.VLANDomain + " sites=" + first.Site + "," + s.Site
+			}
 			conflicts = append(conflicts, Conflict{
 				Kind:   "VLAN_DUP",
-				Detail: "site=" + s.Site + " vrf=" + s.VRF + " vlan=" + itoa(s.VLAN) + " duplicated: " + first.Name + ", " + s.Name,
+				Detail: scope + " vrf=" + s.VRF + " vlan=" + itoa(s.VLAN) + " duplicated: " + first.Name + ", " + s.Name,
 				Level:  statusConflict.Label(),
+				key:    segmentConflictKey(first, s),
 			})
 		} else {
 			seenVLAN[k] = s.ID
@@ -399,8 +438,20 @@ func analyzeSegments(segs []Segment, poolsV4 map[int64][]netip.Prefix, poolsV6 m
 	return out, conflicts
 }
 
+func firstOverlap(a, b []netip.Prefix) (netip.Prefix, netip.Prefix, bool) {
+	for _, p1 := range a {
+		for _, p2 := range b {
+			if prefixesOverlap(p1, p2) {
+				return p1, p2, true
+			}
+		}
+	}
+	return netip.Prefix{}, netip.Prefix{}, false
+}
+
 func buildSegmentViews(segs []Segment, statuses map[int64]SegmentStatus, pools []Pool) []SegmentView {
 	poolIndex := buildPoolRefs(pools)
+	now := time.Now()
 	out := make([]SegmentView, 0, len(segs))
 	for _, s := range segs {
 		view := SegmentView{Segment: s}
@@ -411,6 +462,7 @@ func buildSegmentViews(segs []Segment, statuses map[int64]SegmentStatus, pools [
 		view.StatusLabel = status.Level.Label()
 		view.StatusClass = status.Level.Class()
 		view.StatusDetail = strings.Join(status.Details, "; ")
+		view.Lifetime = segmentLifetime(s, now)
 
 		view.CIDR = cidrString(s.CIDR)
 		view.CIDRV6 = cidrString(s.CIDRV6)
@@ -426,6 +478,14 @@ func buildSegmentViews(segs []Segment, statuses map[int64]SegmentStatus, pools [
 				view.PoolLabel = poolLabelForPrefix(p, poolIndex[s.SiteID])
 			}
 		}
+		for _, p := range segmentSecondaryPrefixes(s) {
+			if details, ok := prefixDetailsIPv4(p); ok {
+				view.Secondary = append(view.Secondary, SecondaryNetwork{
+					CIDR: p.String(), Mask: details.Mask, Network: details.Network,
+					Broadcast: details.Broadcast, Gateway: details.FirstUsable,
+				})
+			}
+		}
 		if s.CIDRV6.Valid {
 			if p, err := netip.ParsePrefix(s.CIDRV6.String); err == nil {
 				view.GatewayV6 = segmentGatewayV6(s, p)
@@ -590,7 +650,11 @@ func analyzeAll(segs []Segment, pools []Pool, sites []Site, rules ProjectRules)
 	poolsBySiteV4, poolsBySiteV6 := buildPoolIndex(pools)
 	reservedV4, reservedV6, reservedConflicts := buildReservedIndex(sites)
 	statuses, conflicts := analyzeSegments(segs, poolsBySiteV4, poolsBySiteV6, reservedV4, reservedV6, rules)
-	hints := analyzeEfficiency(segs, poolsBySiteV4, poolsBySiteV6, reservedV4, reservedV6, rules)
+	hints := analyzeEfficiency(segs, pools, poolsBySiteV4, poolsBySiteV6, reservedV4, reservedV6, rules)
+	conflicts = append(conflicts, analyzeIPv6Scheme(segs, poolsBySiteV6, rules, statuses)...)
+	conflicts = append(conflicts, analyzeExpiry(segs, statuses, time.Now())...)
+	conflicts = append(conflicts, analyzeIPv6Modes(segs, statuses)...)
+	conflicts = append(conflicts, analyzeNAT(segs, pools, sites)...)
 	conflicts = append(reservedConflicts, conflicts...)
 	conflicts = append(conflicts, hints...)
 	return statuses, conflicts
@@ -601,8 +665,12 @@ type ipv4Range struct {
 	end   uint32
 }
 
-func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, poolsBySiteV6 map[int64][]netip.Prefix, reservedV4 map[int64][]netip.Prefix, reservedV6 map[int64][]netip.Prefix, rules ProjectRules) []Conflict {
+func analyzeEfficiency(segs []Segment, pools []Pool, poolsBySiteV4 map[int64][]netip.Prefix, poolsBySiteV6 map[int64][]netip.Prefix, reservedV4 map[int64][]netip.Prefix, reservedV6 map[int64][]netip.Prefix, rules ProjectRules) []Conflict {
 	var out []Conflict
+	siteNames := map[int64]string{}
+	for _, p := range pools {
+		siteNames[p.SiteID] = p.Site
+	}
 
 	segmentsBySite := map[int64][]Segment{}
 	for _, s := range segs {
@@ -615,25 +683,15 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 			if !pool.Addr().Is4() {
 				continue
 			}
-			used := buildUsedRanges(pool, segments, reservedV4[siteID])
-			gaps := freeRanges(pool, used)
+			gaps, totalFree, fragScore := poolGapsV4(pool, segments, reservedV4[siteID])
 			if len(gaps) == 0 {
 				continue
 			}
-			totalFree := uint64(0)
-			largest := uint64(0)
-			for _, g := range gaps {
-				size := uint64(g.end-g.start) + 1
-				totalFree += size
-				if size > largest {
-					largest = size
-				}
-			}
-			fragScore := fragmentationScore(totalFree, largest)
 			out = append(out, Conflict{
 				Kind:   "POOL_FRAGMENTATION",
 				Detail: "pool " + pool.String() + ": free " + itoa64(int64(totalFree)) + " addrs, gaps=" + itoa(len(gaps)) + ", fragmentation=" + itoa(fragScore) + "%",
 				Level:  statusWarning.Label(),
+				key:    poolConflictKey(siteNames[siteID], pool),
 			})
 			limit := 3
 			for _, g := range gaps {
@@ -648,6 +706,7 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 						Kind:   "POOL_GAP",
 						Detail: "pool " + pool.String() + " free block " + p.String(),
 						Level:  statusWarning.Label(),
+						key:    poolConflictKey(siteNames[siteID], pool) + " block=" + p.String(),
 					})
 					limit--
 				}
@@ -661,33 +720,17 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 			if !pool.Addr().Is6() {
 				continue
 			}
-			usedPrefixes := collectUsedPrefixesV6(segments, reservedV6[siteID])
-			used := buildUsedRangesBig(pool, usedPrefixes)
-			gaps := freeRangesBig(pool, used)
+			gaps, totalFree, fragScore := poolGapsV6(pool, segments, reservedV6[siteID])
 			if len(gaps) == 0 {
 				continue
 			}
-			totalFree := big.NewInt(0)
-			largest := big.NewInt(0)
-			for _, g := range gaps {
-				size := bigRangeSize(g)
-				totalFree.Add(totalFree, size)
-				if size.Cmp(largest) > 0 {
-					largest = size
-				}
-			}
-			unitPrefix := 64
-			if pool.Bits() > unitPrefix {
-				unitPrefix = pool.Bits()
-			}
-			unitSize := new(big.Int).Lsh(big.NewInt(1), uint(128-unitPrefix))
-			totalUnits := new(big.Int).Div(totalFree, unitSize)
-			largestUnits := new(big.Int).Div(largest, unitSize)
-			fragScore := fragmentationScoreBig(totalUnits, largestUnits)
+			unitPrefix := poolGapUnitV6(pool)
+			totalUnits := new(big.Int).Rsh(totalFree, uint(128-unitPrefix))
 			out = append(out, Conflict{
 				Kind:   "POOL_FRAGMENTATION_V6",
 				Detail: "pool " + pool.String() + ": free " + formatBigInt(totalUnits) + " /" + itoa(unitPrefix) + " blocks, gaps=" + itoa(len(gaps)) + ", fragmentation=" + itoa(fragScore) + "%",
 				Level:  statusWarning.Label(),
+				key:    poolConflictKey(siteNames[siteID], pool),
 			})
 			limit := 3
 			for _, g := range gaps {
@@ -702,6 +745,7 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 						Kind:   "POOL_GAP_V6",
 						Detail: "pool " + pool.String() + " free block " + p.String(),
 						Level:  statusWarning.Label(),
+						key:    poolConflictKey(siteNames[siteID], pool) + " block=" + p.String(),
 					})
 					limit--
 				}
@@ -732,6 +776,7 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 				Kind:   "OVERSIZED",
 				Detail: "segment " + s.Name + " site=" + s.Site + " " + prefix.String() + " exceeds hosts by " + itoa(unusedPct) + "% (need /" + itoa(required) + ")",
 				Level:  statusWarning.Label(),
+				key:    segmentConflictKey(s),
 			})
 		}
 	}
@@ -759,6 +804,7 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 				Kind:   "OVERSIZED_V6",
 				Detail: "segment " + s.Name + " site=" + s.Site + " " + prefix.String() + " exceeds v6 request by " + itoa(unusedPct) + "% (need /" + itoa(requested) + ")",
 				Level:  statusWarning.Label(),
+				key:    segmentConflictKey(s),
 			})
 		}
 	}
@@ -769,15 +815,10 @@ func analyzeEfficiency(segs []Segment, poolsBySiteV4 map[int64][]netip.Prefix, p
 func buildUsedRanges(pool netip.Prefix, segs []Segment, reserved []netip.Prefix) []ipv4Range {
 	var ranges []ipv4Range
 	for _, s := range segs {
-		if !s.CIDR.Valid {
-			continue
-		}
-		p, err := netip.ParsePrefix(s.CIDR.String)
-		if err != nil || !p.Addr().Is4() {
-			continue
-		}
-		if r, ok := prefixRangeWithin(pool, p); ok {
-			ranges = append(ranges, r)
+		for _, p := range segmentPrefixesV4(s) {
+			if r, ok := prefixRangeWithin(pool, p); ok {
+				ranges = append(ranges, r)
+			}
 		}
 	}
 	for _, r := range reserved {
@@ -933,6 +974,80 @@ func bigRangeToPrefixes(r bigRange, unitPrefix int, limit int) []netip.Prefix {
 	return out
 }
 
+// bigRangeBlocks splits r into the largest aligned prefixes, none smaller
+// than /unitPrefix; what is left of a partial unit is skipped.
+func bigRangeBlocks(r bigRange, unitPrefix int) []netip.Prefix {
+	if unitPrefix <= 0 || unitPrefix > 128 {
+		return nil
+	}
+	unit := new(big.Int).Lsh(big.NewInt(1), uint(128-unitPrefix))
+	start := alignUp(new(big.Int).Set(r.start), unit)
+	var out []netip.Prefix
+	for {
+		remaining := new(big.Int).Sub(r.end, start)
+		remaining.Add(remaining, big.NewInt(1))
+		if remaining.Cmp(unit) < 0 {
+			break
+		}
+		size := remaining.BitLen() - 1
+		if tz := int(start.TrailingZeroBits()); start.Sign() != 0 && tz < size {
+			size = tz
+		}
+		addr, ok := bigToAddr(start, 128)
+		if !ok {
+			break
+		}
+		out = append(out, netip.PrefixFrom(addr, 128-size))
+		start.Add(start, new(big.Int).Lsh(big.NewInt(1), uint(size)))
+	}
+	return out
+}
+
+// poolGapsV4 returns the free ranges of an IPv4 pool, the free addresses
+// and the fragmentation score: the share of free space outside the largest
+// free block.
+func poolGapsV4(pool netip.Prefix, segs []Segment, reserved []netip.Prefix) ([]ipv4Range, uint64, int) {
+	gaps := freeRanges(pool, buildUsedRanges(pool, segs, reserved))
+	totalFree := uint64(0)
+	largest := uint64(0)
+	for _, g := range gaps {
+		size := uint64(g.end-g.start) + 1
+		totalFree += size
+		if size > largest {
+			largest = size
+		}
+	}
+	return gaps, totalFree, fragmentationScore(totalFree, largest)
+}
+
+// poolGapUnitV6 is the block size IPv6 fragmentation is counted in: /64,
+// or the pool itself when it is smaller.
+func poolGapUnitV6(pool netip.Prefix) int {
+	if pool.Bits() > 64 {
+		return pool.Bits()
+	}
+	return 64
+}
+
+// poolGapsV6 is poolGapsV4 for an IPv6 pool, scored in poolGapUnitV6
+// blocks.
+func poolGapsV6(pool netip.Prefix, segs []Segment, reserved []netip.Prefix) ([]bigRange, *big.Int, int) {
+	gaps := freeRangesBig(pool, buildUsedRangesBig(pool, collectUsedPrefixesV6(segs, reserved)))
+	totalFree := big.NewInt(0)
+	largest := big.NewInt(0)
+	for _, g := range gaps {
+		size := bigRangeSize(g)
+		totalFree.Add(totalFree, size)
+		if size.Cmp(largest) > 0 {
+			largest = size
+		}
+	}
+	unit := uint(128 - poolGapUnitV6(pool))
+	totalUnits := new(big.Int).Rsh(totalFree, unit)
+	largestUnits := new(big.Int).Rsh(largest, unit)
+	return gaps, totalFree, fragmentationScoreBig(totalUnits, largestUnits)
+}
+
 func fragmentationScore(total, largest uint64) int {
 	if total == 0 {
 		return 0
@@ -0,0 +1,178 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var archiveExemptPaths = map[string]bool{
+	"/projects":                   true,
+	"/projects/archive":           true,
+	"/maintenance":                true,
+	"/whatif":                     true,
+	"/whatif/pool":                true,
+	"/pools/rdap":                 true,
+	"/integrity/cleanup":          true,
+	"/import/legacy/preview":      true,
+	"/segments/quick-add/preview": true,
+	"/generate/device-diff":       true,
+	"/routes":                     true,
+	"/generate/noise-filter":      true,
+	"/filters/save":               true,
+	"/filters/delete":             true,
+	"/filters/default":            true,
+	"/export/columns":             true,
+	"/api/filters":                true,
+	"/templates/upload":           true,
+	"/templates/fixtures":         true,
+	"/templates/fixtures/delete":  true,
+	"/templates/delete":           true,
+	"/templates/reload":           true,
+	"/api/v1/generate":            true,
+	"/api/v1/whatif":              true,
+	"/api/v1/routes/reconcile":    true,
+	"/api/v1/chatops":             true,
+}
+
+func projectArchived(db *sql.DB, projectID int64) bool {
+	if projectID <= 0 {
+		return false
+	}
+	var archived bool
+	if err := db.QueryRow(`SELECT archived FROM projects WHERE id=?`, projectID).Scan(&archived); err != nil {
+		return false
+	}
+	return archived
+}
+
+func setProjectArchived(db *sql.DB, projectID int64, archived bool) error {
+	if projectID <= 0 {
+		return fmt.Errorf("project is required")
+	}
+	var archivedAt any
+	if archived {
+		archivedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := db.Exec(`UPDATE projects SET archived=?, archived_at=? WHERE id=?`, boolToInt(archived), archivedAt, projectID)
+	return err
+}
+
+type archiveTarget struct {
+	field string
+	kind  string
+}
+
+// archiveRouteTargets names, per write route, the form fields identifying the
+// entity it changes, in order of preference. Other routes act on the active
+// project, so the guard never parses their bodies (uploads in particular).
+var archiveRouteTargets = map[string][]archiveTarget{
+	"/projects/meta":               {{"project_id", "project"}},
+	"/projects/sandbox":            {{"project_id", "project"}},
+	"/projects/routing-domain":     {{"project_id", "project"}},
+	"/projects/audit-reads":        {{"project_id", "project"}},
+	"/projects/delete":             {{"project_id", "project"}},
+	"/dhcp-policies":               {{"project_id", "project"}},
+	"/dhcp-policies/delete":        {{"project_id", "project"}},
+	"/allocation-validator":        {{"project_id", "project"}},
+	"/allocation-validator/delete": {{"project_id", "project"}},
+	"/project-quota":               {{"project_id", "project"}},
+	"/ticketing":                   {{"project_id", "project"}},
+	"/ticketing/delete":            {{"project_id", "project"}},
+	"/notifications/routes":        {{"project_id", "project"}},
+	"/sites":                       {{"project_id", "project"}},
+	"/sites/delete":                {{"site_id", "site"}},
+	"/pools":                       {{"site_id", "site"}},
+	"/pools/update":                {{"pool_id", "pool"}},
+	"/pools/delete":                {{"pool_id", "pool"}},
+	"/pools/state":                 {{"pool_id", "pool"}},
+	"/pools/migrate":               {{"pool_id", "pool"}},
+	"/tiers":                       {{"project_id", "project"}},
+	"/tiers/move":                  {{"id", "tier"}},
+	"/tiers/delete":                {{"id", "tier"}},
+	"/segments":                    {{"site_id", "site"}, {"project_id", "project"}},
+	"/segments/update":             {{"segment_id", "segment"}},
+	"/segments/delete":             {{"segment_id", "segment"}},
+	"/segments/bulk-delete":        {{"project_id", "project"}},
+	"/segments/bulk-dhcp":          {{"project_id", "project"}},
+	"/macs":                        {{"segment_id", "segment"}},
+	"/macs/delete":                 {{"id", "mac"}},
+	"/devices":                     {{"site_id", "site"}},
+	"/devices/delete":              {{"id", "device"}},
+	"/nat":                         {{"segment_id", "segment"}},
+	"/nat/delete":                  {{"id", "nat"}},
+	"/conflicts/ticket":            {{"project_id", "project"}},
+	"/conflicts/notify":            {{"project_id", "project"}},
+	"/generate/deployed/save":      {{"project_id", "project"}},
+	"/generate/deployed/delete":    {{"project_id", "project"}},
+	"/generate/schedules":          {{"project_id", "project"}},
+	"/generate/schedules/run":      {{"id", "schedule"}},
+	"/generate/schedules/delete":   {{"id", "schedule"}},
+	"/generate/storage/retention":  {{"project_id", "project"}},
+	"/generate/storage/prune":      {{"project_id", "project"}},
+	"/generate/vars":               {{"site_id", "site"}, {"project_id", "project"}},
+	"/federation":                  {{"project_id", "project"}},
+	"/federation/delete":           {{"project_id", "project"}},
+	"/federation/pull":             {{"project_id", "project"}},
+	"/integrity/database":          {{"project_id", "project"}},
+	"/rules/delete":                {{"project_id", "project"}},
+}
+
+var archiveEntityProjectQueries = map[string]string{
+	"site":     `SELECT project_id FROM project_sites WHERE site_id=?`,
+	"pool":     `SELECT ps.project_id FROM pools p JOIN project_sites ps ON ps.site_id=p.site_id WHERE p.id=?`,
+	"segment":  `SELECT ps.project_id FROM segments s JOIN project_sites ps ON ps.site_id=s.site_id WHERE s.id=?`,
+	"device":   `SELECT ps.project_id FROM devices d JOIN project_sites ps ON ps.site_id=d.site_id WHERE d.id=?`,
+	"mac":      `SELECT ps.project_id FROM mac_reservations m JOIN segments s ON s.id=m.segment_id JOIN project_sites ps ON ps.site_id=s.site_id WHERE m.id=?`,
+	"nat":      `SELECT ps.project_id FROM nat_mappings n JOIN segments s ON s.id=n.segment_id JOIN project_sites ps ON ps.site_id=s.site_id WHERE n.id=?`,
+	"tier":     `SELECT project_id FROM pool_tiers WHERE id=?`,
+	"schedule": `SELECT project_id FROM generation_schedules WHERE id=?`,
+}
+
+func writeTargetProjectID(c *gin.Context, db *sql.DB, defaultProjectID int64) int64 {
+	for _, target := range archiveRouteTargets[c.FullPath()] {
+		id := parseProjectID(c.PostForm(target.field))
+		if id <= 0 {
+			continue
+		}
+		if target.kind == "project" {
+			return id
+		}
+		var projectID int64
+		if err := db.QueryRow(archiveEntityProjectQueries[target.kind], id).Scan(&projectID); err == nil {
+			return projectID
+		}
+		break
+	}
+	return resolveActiveProjectID(c, db, defaultProjectID)
+}
+
+func archiveGuard(db *sql.DB, defaultProjectID int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !isMutatingMethod(c.Request.Method) || archiveExemptPaths[path] || strings.HasPrefix(path, "/api/filters/") {
+			c.Next()
+			return
+		}
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		if !projectArchived(db, projectID) {
+			c.Next()
+			return
+		}
+		name := itoa64(projectID)
+		if p, ok := projectByID(db, projectID); ok {
+			name = p.Name
+		}
+		abortWithError(c, http.StatusConflict, archivedError(name))
+	}
+}
+
+func archivedError(name string) error {
+	return fmt.Errorf("project %q is archived (read-only); unarchive it to make changes", name)
+}
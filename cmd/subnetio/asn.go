@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const maxASN = 4294967295
+
+// parseASN accepts plain ("65001"), prefixed ("AS65001") and asdot ("1.10")
+// notation.
+func parseASN(raw string) (sql.NullInt64, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return sql.NullInt64{}, nil
+	}
+	value := strings.TrimPrefix(strings.ToUpper(raw), "AS")
+	var asn uint64
+	if high, low, ok := strings.Cut(value, "."); ok {
+		h, err1 := strconv.ParseUint(high, 10, 16)
+		l, err2 := strconv.ParseUint(low, 10, 16)
+		if err1 != nil || err2 != nil {
+			return sql.NullInt64{}, fmt.Errorf("invalid asn %q", raw)
+		}
+		asn = h<<16 | l
+	} else {
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return sql.NullInt64{}, fmt.Errorf("invalid asn %q", raw)
+		}
+		asn = n
+	}
+	if asn == 0 || asn > maxASN {
+		return sql.NullInt64{}, fmt.Errorf("invalid asn %q", raw)
+	}
+	return sql.NullInt64{Int64: int64(asn), Valid: true}, nil
+}
+
+func asnString(asn sql.NullInt64) string {
+	if !asn.Valid {
+		return ""
+	}
+	return strconv.FormatInt(asn.Int64, 10)
+}
+
+func normalizeVLANDomain(raw string) string {
+	return strings.ToLower(strings.TrimSpace(raw))
+}
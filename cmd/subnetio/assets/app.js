@@ -99,13 +99,267 @@
     });
   };
 
+  const attachInlineEdit = () => {
+    const flash = (node, className, message) => {
+      node.classList.remove('is-saved', 'is-invalid');
+      node.classList.add(className);
+      node.title = message || '';
+      if (className === 'is-saved') {
+        window.setTimeout(() => node.classList.remove('is-saved'), 1200);
+      }
+    };
+
+    const displayValue = (field, segment) => {
+      switch (field) {
+        case 'hosts':
+          if (segment.prefix) {
+            return `/${segment.prefix}`;
+          }
+          return segment.hosts ? `${segment.hosts} hosts` : '-';
+        case 'tags':
+          return segment.tags || '—';
+        default:
+          return String(segment[field] ?? '');
+      }
+    };
+
+    const patch = async (row, body) => {
+      const response = await fetch(row.dataset.segmentUrl, {
+        method: 'PATCH',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+      });
+      const payload = await response.json().catch(() => ({}));
+      if (!response.ok) {
+        throw new Error(payload.error || `HTTP ${response.status}`);
+      }
+      return payload;
+    };
+
+    const encode = (field, raw) => {
+      const value = raw.trim();
+      if (field === 'vlan') {
+        return Number.parseInt(value, 10);
+      }
+      if (field === 'hosts') {
+        return value === '' ? null : Number.parseInt(value, 10);
+      }
+      return value;
+    };
+
+    document.addEventListener('dblclick', (event) => {
+      const cell = event.target instanceof Element ? event.target.closest('[data-inline]:not(input)') : null;
+      const row = cell?.closest('[data-segment-url]');
+      if (!cell || !row || cell.querySelector('input')) {
+        return;
+      }
+      const field = cell.dataset.inline;
+      const original = cell.innerHTML;
+      const input = document.createElement('input');
+      input.className = 'form-control form-control-sm';
+      input.type = field === 'vlan' || field === 'hosts' ? 'number' : 'text';
+      input.value = cell.dataset.value || '';
+      cell.textContent = '';
+      cell.appendChild(input);
+      input.focus();
+      input.select();
+
+      let done = false;
+      const cancel = () => {
+        done = true;
+        cell.innerHTML = original;
+      };
+      const save = async () => {
+        if (done) {
+          return;
+        }
+        done = true;
+        if (input.value === (cell.dataset.value || '')) {
+          cell.innerHTML = original;
+          return;
+        }
+        try {
+          const segment = await patch(row, { [field]: encode(field, input.value) });
+          cell.dataset.value = field === 'hosts' ? String(segment.hosts ?? '') : String(segment[field] ?? '');
+          cell.textContent = displayValue(field, segment);
+          flash(cell, 'is-saved');
+        } catch (err) {
+          cell.innerHTML = original;
+          flash(cell, 'is-invalid', err.message);
+        }
+      };
+      input.addEventListener('keydown', (keyEvent) => {
+        if (keyEvent.key === 'Enter') {
+          keyEvent.preventDefault();
+          save();
+        } else if (keyEvent.key === 'Escape') {
+          cancel();
+        }
+      });
+      input.addEventListener('blur', save);
+    });
+
+    document.addEventListener('change', async (event) => {
+      const box = event.target;
+      if (!(box instanceof HTMLInputElement) || box.dataset.inline !== 'locked') {
+        return;
+      }
+      const row = box.closest('[data-segment-url]');
+      if (!row) {
+        return;
+      }
+      try {
+        const segment = await patch(row, { locked: box.checked });
+        box.checked = Boolean(segment.locked);
+        flash(box, 'is-saved');
+      } catch (err) {
+        box.checked = !box.checked;
+        flash(box, 'is-invalid', err.message);
+      }
+    });
+  };
+
+  const attachSelectAll = () => {
+    document.addEventListener('change', (event) => {
+      const toggle = event.target;
+      if (!(toggle instanceof HTMLInputElement) || !toggle.dataset.selectAll) {
+        return;
+      }
+      document.querySelectorAll(`input[type="checkbox"][name="${toggle.dataset.selectAll}"]`).forEach((box) => {
+        box.checked = toggle.checked;
+      });
+    });
+  };
+
+  // Quick add is meant to be driven from the keyboard: Q jumps to the paste
+  // box, Tab types a column separator there (Escape leaves the box first),
+  // and Ctrl+Enter previews the rows or, once an unchanged preview is ready,
+  // adds them.
+  const attachQuickAdd = () => {
+    const box = document.querySelector('textarea[data-quick-add]');
+    if (!box) {
+      return;
+    }
+    document.addEventListener('keydown', (event) => {
+      if (event.key !== 'q' || event.ctrlKey || event.metaKey || event.altKey) {
+        return;
+      }
+      const active = document.activeElement;
+      if (active instanceof HTMLElement && (active.isContentEditable || active.matches('input, select, textarea'))) {
+        return;
+      }
+      event.preventDefault();
+      box.focus();
+    });
+    box.addEventListener('keydown', (event) => {
+      if (event.key === 'Tab' && !event.shiftKey) {
+        event.preventDefault();
+        box.setRangeText('\t', box.selectionStart, box.selectionEnd, 'end');
+      } else if (event.key === 'Escape') {
+        box.blur();
+      } else if (event.key === 'Enter' && (event.ctrlKey || event.metaKey)) {
+        event.preventDefault();
+        const apply = box.form.querySelector('button[formaction]');
+        if (apply && box.value === box.defaultValue) {
+          box.form.requestSubmit(apply);
+        } else {
+          box.form.requestSubmit();
+        }
+      }
+    });
+  };
+
+  const attachLiveUpdates = () => {
+    const source = document.querySelector('[data-live-events]');
+    if (!source || typeof EventSource === 'undefined') {
+      return;
+    }
+    const notice = document.querySelector('[data-live-notice]');
+    const noticeText = notice?.querySelector('[data-live-notice-text]');
+    let timer = null;
+    let latest = null;
+
+    // Swapping the table would drop an open editor, a half-typed value or
+    // the bulk-delete selection; offer a reload instead.
+    const busy = () => {
+      const plan = document.querySelector('[data-live-region="segments-plan"]');
+      if (!plan) {
+        return false;
+      }
+      if (plan.querySelector('[data-inline] input, details[open], input[name="segment_ids"]:checked')) {
+        return true;
+      }
+      const active = document.activeElement;
+      return active instanceof HTMLElement && plan.contains(active) && active.matches('input, select, textarea');
+    };
+
+    const describe = (event) => {
+      if (!event) {
+        return 'The plan was changed.';
+      }
+      const what = [event.action, event.entity_type, event.entity_label].filter(Boolean).join(' ');
+      return `The plan was changed by ${event.actor || 'someone'} (${what}).`;
+    };
+
+    const refresh = async () => {
+      timer = null;
+      if (busy()) {
+        if (notice && noticeText) {
+          noticeText.textContent = describe(latest);
+          notice.hidden = false;
+        }
+        return;
+      }
+      try {
+        const response = await fetch(window.location.href, { headers: { Accept: 'text/html' } });
+        if (!response.ok) {
+          return;
+        }
+        const doc = new DOMParser().parseFromString(await response.text(), 'text/html');
+        document.querySelectorAll('[data-live-region]').forEach((node) => {
+          const fresh = doc.querySelector(`[data-live-region="${node.dataset.liveRegion}"]`);
+          if (fresh) {
+            node.replaceWith(fresh);
+          }
+        });
+        if (notice) {
+          notice.hidden = true;
+        }
+      } catch (err) {
+        // The next event tries again.
+      }
+    };
+
+    const stream = new EventSource(source.dataset.liveEvents);
+    stream.addEventListener('audit', (event) => {
+      try {
+        latest = JSON.parse(event.data);
+      } catch (err) {
+        latest = null;
+      }
+      // Imports and allocations write many entries at once; refresh once.
+      if (!timer) {
+        timer = window.setTimeout(refresh, 750);
+      }
+    });
+    window.addEventListener('pagehide', () => stream.close());
+  };
+
   if (document.readyState === 'loading') {
     document.addEventListener('DOMContentLoaded', () => {
       attachConfirm();
+      attachInlineEdit();
+      attachSelectAll();
+      attachQuickAdd();
+      attachLiveUpdates();
       applyReveal();
     }, { once: true });
   } else {
     attachConfirm();
+    attachInlineEdit();
+    attachSelectAll();
+    attachQuickAdd();
+    attachLiveUpdates();
     applyReveal();
   }
 })();
@@ -24,6 +24,8 @@ type AuditEntry struct {
 	BeforeJSON sql.NullString
 	AfterJSON  sql.NullString
 	CreatedAt  string
+	PrevHash   string
+	Hash       string
 }
 
 type auditRecord struct {
@@ -39,9 +41,13 @@ type auditRecord struct {
 }
 
 type auditProjectSnapshot struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description,omitempty"`
+	Archived      bool   `json:"archived,omitempty"`
+	Sandbox       bool   `json:"sandbox,omitempty"`
+	RoutingDomain string `json:"routing_domain,omitempty"`
+	AuditReads    bool   `json:"audit_reads,omitempty"`
 }
 
 type auditProjectMetaSnapshot struct {
@@ -60,6 +66,19 @@ type auditProjectMetaSnapshot struct {
 	GrowthMonths   *int     `json:"growth_months,omitempty"`
 }
 
+type auditDHCPPolicySnapshot struct {
+	ID             int64    `json:"id"`
+	Tag            string   `json:"tag"`
+	Priority       int      `json:"priority"`
+	DhcpSearch     string   `json:"dhcp_search,omitempty"`
+	DhcpLeaseTime  *int     `json:"dhcp_lease_time,omitempty"`
+	DhcpRenewTime  *int     `json:"dhcp_renew_time,omitempty"`
+	DhcpRebindTime *int     `json:"dhcp_rebind_time,omitempty"`
+	DhcpBootFile   string   `json:"dhcp_boot_file,omitempty"`
+	DhcpNextServer string   `json:"dhcp_next_server,omitempty"`
+	DhcpVendorOpts []string `json:"dhcp_vendor_options,omitempty"`
+}
+
 type auditRulesSnapshot struct {
 	VLANScope            string `json:"vlan_scope"`
 	RequireInPool        bool   `json:"require_in_pool"`
@@ -67,6 +86,12 @@ type auditRulesSnapshot struct {
 	OversizeThreshold    int    `json:"oversize_threshold"`
 	PoolStrategy         string `json:"pool_strategy"`
 	PoolTierFallback     bool   `json:"pool_tier_fallback"`
+	IPv6Scheme           string `json:"ipv6_scheme,omitempty"`
+	VLANRanges           string `json:"vlan_ranges,omitempty"`
+	DefaultVRF           string `json:"default_vrf,omitempty"`
+	SegmentCheck         string `json:"segment_check,omitempty"`
+	PersistDerived       bool   `json:"persist_derived,omitempty"`
+	Uniqueness           string `json:"uniqueness,omitempty"`
 }
 
 type auditSiteSnapshot struct {
@@ -85,6 +110,12 @@ type auditSiteSnapshot struct {
 	DhcpBootFile   string `json:"dhcp_boot_file,omitempty"`
 	DhcpNextServer string `json:"dhcp_next_server,omitempty"`
 	DhcpVendorOpts []string `json:"dhcp_vendor_options,omitempty"`
+	BGPASN         *int64   `json:"bgp_asn,omitempty"`
+	VLANDomain     string   `json:"vlan_domain,omitempty"`
+	DhcpPrimary    string   `json:"dhcp_failover_primary,omitempty"`
+	DhcpSecondary  string   `json:"dhcp_failover_secondary,omitempty"`
+	OwnerTeam      string   `json:"owner_team,omitempty"`
+	OwnerEmail     string   `json:"owner_email,omitempty"`
 }
 
 type auditPoolSnapshot struct {
@@ -94,6 +125,9 @@ type auditPoolSnapshot struct {
 	Family   string `json:"family"`
 	Tier     string `json:"tier,omitempty"`
 	Priority int    `json:"priority,omitempty"`
+	Shares   string `json:"vrf_shares,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+	State    string `json:"state,omitempty"`
 }
 
 type auditSegmentSnapshot struct {
@@ -116,6 +150,18 @@ type auditSegmentSnapshot struct {
 	Tags             string `json:"tags,omitempty"`
 	Notes            string `json:"notes,omitempty"`
 	PoolTier         string `json:"pool_tier,omitempty"`
+	IPv6Mode         string `json:"ipv6_mode,omitempty"`
+	IPv6DNS          string `json:"ipv6_dns,omitempty"`
+	OwnerTeam        string `json:"owner_team,omitempty"`
+	OwnerEmail       string `json:"owner_email,omitempty"`
+	ExpiresAt        string `json:"expires_at,omitempty"`
+	ExpiryAction     string `json:"expiry_action,omitempty"`
+	ExpiredAt        string `json:"expired_at,omitempty"`
+	MultiPrefix      bool   `json:"multi_prefix,omitempty"`
+	SecondaryCIDRs   string `json:"secondary_cidrs,omitempty"`
+	Kind             string `json:"kind,omitempty"`
+	LinkA            string `json:"link_a,omitempty"`
+	LinkB            string `json:"link_b,omitempty"`
 }
 
 type auditAllocationChange struct {
@@ -142,12 +188,24 @@ type auditTemplateSnapshot struct {
 	Size     int    `json:"size"`
 }
 
+type auditTemplateFixtureSnapshot struct {
+	Template         string `json:"template"`
+	Name             string `json:"name"`
+	ContextChecksum  string `json:"context_checksum"`
+	ExpectedChecksum string `json:"expected_checksum"`
+}
+
 type auditImportSummary struct {
 	Source        string   `json:"source"`
 	ProjectsAdded int      `json:"projects_added,omitempty"`
 	SitesAdded    int      `json:"sites_added,omitempty"`
 	PoolsAdded    int      `json:"pools_added,omitempty"`
 	SegmentsAdded int      `json:"segments_added,omitempty"`
+	Updated       int      `json:"updated,omitempty"`
+	Skipped       int      `json:"skipped,omitempty"`
+	Deleted       int      `json:"deleted,omitempty"`
+	Policy        string   `json:"policy,omitempty"`
+	ImportID      int64    `json:"import_id,omitempty"`
 	Warnings      []string `json:"warnings,omitempty"`
 	Errors        []string `json:"errors,omitempty"`
 }
@@ -156,10 +214,17 @@ type auditDefaultsImportSummary struct {
 	Source         string   `json:"source"`
 	ProjectUpdated bool     `json:"project_updated"`
 	SitesUpdated   int      `json:"sites_updated,omitempty"`
+	ImportID       int64    `json:"import_id,omitempty"`
 	Warnings       []string `json:"warnings,omitempty"`
 	Errors         []string `json:"errors,omitempty"`
 }
 
+type auditDefaultsRollback struct {
+	ImportID int64    `json:"import_id"`
+	Restored int      `json:"restored"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
 func auditActor(c *gin.Context) string {
 	actor := strings.TrimSpace(c.GetHeader("X-Actor"))
 	if actor == "" {
@@ -204,6 +269,7 @@ func writeAudit(db *sql.DB, c *gin.Context, record auditRecord) {
 }
 
 func insertAuditRecord(db *sql.DB, record auditRecord) error {
+	invalidateConflictCounts(db, record.ProjectID)
 	before, err := marshalAuditPayload(record.Before)
 	if err != nil {
 		return err
@@ -212,28 +278,28 @@ func insertAuditRecord(db *sql.DB, record auditRecord) error {
 	if err != nil {
 		return err
 	}
-	createdAt := time.Now().UTC().Format(time.RFC3339)
-	_, err = db.Exec(`
-		INSERT INTO audit_log(
-			project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at
-		) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		nullInt64ToAny(record.ProjectID),
-		record.Actor,
-		record.Action,
-		record.EntityType,
-		nullInt64ToAny(record.EntityID),
-		nullStringToAny(record.EntityLabel.String),
-		nullStringToAny(record.Reason.String),
-		nullStringToAny(before),
-		nullStringToAny(after),
-		createdAt,
-	)
+	err = appendAuditRow(db, auditChainRow{
+		ProjectID:   record.ProjectID,
+		CreatedAt:   time.Now().UTC().Format(time.RFC3339),
+		Actor:       record.Actor,
+		Action:      record.Action,
+		EntityType:  record.EntityType,
+		EntityID:    record.EntityID,
+		EntityLabel: record.EntityLabel.String,
+		Reason:      record.Reason.String,
+		Before:      before,
+		After:       after,
+	})
+	if err == nil {
+		auditEvents.notify()
+	}
 	return err
 }
 
 func listAuditEntries(db *sql.DB, projectID int64) ([]AuditEntry, error) {
 	query := `
-		SELECT id, project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at
+		SELECT id, project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at,
+			COALESCE(prev_hash, ''), COALESCE(hash, '')
 		FROM audit_log
 	`
 	var args []any
@@ -262,6 +328,65 @@ func listAuditEntries(db *sql.DB, projectID int64) ([]AuditEntry, error) {
 			&entry.BeforeJSON,
 			&entry.AfterJSON,
 			&entry.CreatedAt,
+			&entry.PrevHash,
+			&entry.Hash,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, entry)
+	}
+	return out, rows.Err()
+}
+
+type AuditCursor struct {
+	SinceID   int64
+	SinceTime string
+	Limit     int
+}
+
+func listAuditEntriesSince(db *sql.DB, projectID int64, cursor AuditCursor) ([]AuditEntry, error) {
+	query := `
+		SELECT id, project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at,
+			COALESCE(prev_hash, ''), COALESCE(hash, '')
+		FROM audit_log
+		WHERE id > ?
+	`
+	args := []any{cursor.SinceID}
+	if projectID > 0 {
+		query += " AND project_id=?"
+		args = append(args, projectID)
+	}
+	if cursor.SinceTime != "" {
+		query += " AND created_at >= ?"
+		args = append(args, cursor.SinceTime)
+	}
+	query += " ORDER BY id ASC"
+	if cursor.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, cursor.Limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AuditEntry
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ProjectID,
+			&entry.Actor,
+			&entry.Action,
+			&entry.EntityType,
+			&entry.EntityID,
+			&entry.EntityLabel,
+			&entry.Reason,
+			&entry.BeforeJSON,
+			&entry.AfterJSON,
+			&entry.CreatedAt,
+			&entry.PrevHash,
+			&entry.Hash,
 		); err != nil {
 			return nil, err
 		}
@@ -283,8 +408,12 @@ func marshalAuditPayload(value any) (string, error) {
 
 func snapshotProject(p Project) auditProjectSnapshot {
 	out := auditProjectSnapshot{
-		ID:   p.ID,
-		Name: strings.TrimSpace(p.Name),
+		ID:            p.ID,
+		Name:          strings.TrimSpace(p.Name),
+		Archived:      p.Archived,
+		Sandbox:       p.Sandbox,
+		RoutingDomain: p.RoutingDomain,
+		AuditReads:    p.AuditReads,
 	}
 	if p.Description.Valid {
 		out.Description = strings.TrimSpace(p.Description.String)
@@ -313,6 +442,24 @@ func snapshotProjectMeta(meta ProjectMeta) auditProjectMetaSnapshot {
 	return out
 }
 
+func snapshotDHCPPolicy(p DHCPPolicy) auditDHCPPolicySnapshot {
+	out := auditDHCPPolicySnapshot{
+		ID:             p.ID,
+		Tag:            p.Tag,
+		Priority:       p.Priority,
+		DhcpSearch:     strings.TrimSpace(nullString(p.DhcpSearch)),
+		DhcpBootFile:   strings.TrimSpace(nullString(p.DhcpBootFile)),
+		DhcpNextServer: strings.TrimSpace(nullString(p.DhcpNextServer)),
+	}
+	if p.DhcpVendorOpts.Valid {
+		out.DhcpVendorOpts = parseLines(p.DhcpVendorOpts.String)
+	}
+	out.DhcpLeaseTime = nullIntPtr(p.DhcpLeaseTime)
+	out.DhcpRenewTime = nullIntPtr(p.DhcpRenewTime)
+	out.DhcpRebindTime = nullIntPtr(p.DhcpRebindTime)
+	return out
+}
+
 func snapshotRules(rules ProjectRules) auditRulesSnapshot {
 	return auditRulesSnapshot{
 		VLANScope:            rules.VLANScope,
@@ -321,6 +468,12 @@ func snapshotRules(rules ProjectRules) auditRulesSnapshot {
 		OversizeThreshold:    rules.OversizeThreshold,
 		PoolStrategy:         rules.PoolStrategy,
 		PoolTierFallback:     rules.PoolTierFallback,
+		IPv6Scheme:           rules.IPv6Scheme,
+		VLANRanges:           rules.VLANRanges,
+		DefaultVRF:           rules.DefaultVRF,
+		SegmentCheck:         rules.SegmentCheck,
+		PersistDerived:       rules.PersistDerived,
+		Uniqueness:           rules.Uniqueness,
 	}
 }
 
@@ -337,6 +490,15 @@ func snapshotSite(site Site) auditSiteSnapshot {
 		DhcpSearch:     strings.TrimSpace(nullString(site.DhcpSearch)),
 		DhcpBootFile:   strings.TrimSpace(nullString(site.DhcpBootFile)),
 		DhcpNextServer: strings.TrimSpace(nullString(site.DhcpNextServer)),
+		VLANDomain:     strings.TrimSpace(nullString(site.VLANDomain)),
+		DhcpPrimary:    strings.TrimSpace(nullString(site.DhcpFailoverPrimary)),
+		DhcpSecondary:  strings.TrimSpace(nullString(site.DhcpFailoverSecondary)),
+		OwnerTeam:      strings.TrimSpace(nullString(site.OwnerTeam)),
+		OwnerEmail:     strings.TrimSpace(nullString(site.OwnerEmail)),
+	}
+	if site.BGPASN.Valid {
+		asn := site.BGPASN.Int64
+		out.BGPASN = &asn
 	}
 	if site.DhcpVendorOpts.Valid {
 		out.DhcpVendorOpts = splitCSV(site.DhcpVendorOpts.String)
@@ -358,6 +520,15 @@ func snapshotPool(pool Pool) auditPoolSnapshot {
 	if pool.Tier.Valid {
 		out.Tier = strings.TrimSpace(pool.Tier.String)
 	}
+	if pool.VRFShares.Valid {
+		out.Shares = strings.TrimSpace(pool.VRFShares.String)
+	}
+	if pool.Kind != PoolKindLAN {
+		out.Kind = pool.Kind
+	}
+	if isDrainingPool(pool) {
+		out.State = pool.State
+	}
 	return out
 }
 
@@ -382,6 +553,20 @@ func snapshotSegment(seg Segment) auditSegmentSnapshot {
 		Tags:             strings.TrimSpace(nullString(seg.Tags)),
 		Notes:            strings.TrimSpace(nullString(seg.Notes)),
 		PoolTier:         strings.TrimSpace(nullString(seg.PoolTier)),
+		IPv6Mode:         nullString(seg.IPv6Mode),
+		IPv6DNS:          strings.TrimSpace(nullString(seg.IPv6DNS)),
+		OwnerTeam:        strings.TrimSpace(nullString(seg.OwnerTeam)),
+		OwnerEmail:       strings.TrimSpace(nullString(seg.OwnerEmail)),
+		ExpiresAt:        nullString(seg.ExpiresAt),
+		ExpiryAction:     nullString(seg.ExpiryAction),
+		ExpiredAt:        nullString(seg.ExpiredAt),
+		MultiPrefix:      seg.MultiPrefix,
+		SecondaryCIDRs:   nullString(seg.SecondaryCIDRs),
+		LinkA:            strings.TrimSpace(nullString(seg.LinkA)),
+		LinkB:            strings.TrimSpace(nullString(seg.LinkB)),
+	}
+	if isLinkSegment(seg) {
+		out.Kind = SegmentKindLink
 	}
 	return out
 }
@@ -407,6 +592,15 @@ func snapshotTemplate(name, source string, content []byte) auditTemplateSnapshot
 	}
 }
 
+func snapshotTemplateFixture(f TemplateFixture) auditTemplateFixtureSnapshot {
+	return auditTemplateFixtureSnapshot{
+		Template:         f.Template,
+		Name:             f.Name,
+		ContextChecksum:  checksumSHA256(f.Context),
+		ExpectedChecksum: checksumSHA256(f.Expected),
+	}
+}
+
 func nullFloatPtr(v sql.NullFloat64) *float64 {
 	if !v.Valid {
 		return nil
@@ -474,7 +668,9 @@ func siteByID(db *sql.DB, siteID int64) (Site, bool) {
 		SELECT s.id, s.name, p.name,
 			m.region, m.dns, m.ntp, m.gateway_policy, m.reserved_ranges,
 			m.dhcp_search, m.dhcp_lease_time, m.dhcp_renew_time, m.dhcp_rebind_time,
-			m.dhcp_boot_file, m.dhcp_next_server, m.dhcp_vendor_options
+			m.dhcp_boot_file, m.dhcp_next_server, m.dhcp_vendor_options,
+			m.bgp_asn, m.vlan_domain, m.dhcp_failover_primary, m.dhcp_failover_secondary,
+			m.owner_team, m.owner_email
 		FROM sites s
 		LEFT JOIN project_sites ps ON ps.site_id = s.id
 		LEFT JOIN projects p ON p.id = ps.project_id
@@ -485,6 +681,8 @@ func siteByID(db *sql.DB, siteID int64) (Site, bool) {
 		&site.Region, &site.DNS, &site.NTP, &site.GatewayPolicy, &site.ReservedRanges,
 		&site.DhcpSearch, &site.DhcpLeaseTime, &site.DhcpRenewTime, &site.DhcpRebindTime,
 		&site.DhcpBootFile, &site.DhcpNextServer, &site.DhcpVendorOpts,
+		&site.BGPASN, &site.VLANDomain, &site.DhcpFailoverPrimary, &site.DhcpFailoverSecondary,
+		&site.OwnerTeam, &site.OwnerEmail,
 	); err != nil {
 		return Site{}, false
 	}
@@ -498,11 +696,11 @@ func poolByID(db *sql.DB, poolID int64) (Pool, bool) {
 	var pool Pool
 	row := db.QueryRow(`
 		SELECT p.id, p.site_id, s.name, p.cidr,
-			COALESCE(p.family, 'ipv4'), p.tier, COALESCE(p.priority, 0)
+			COALESCE(p.family, 'ipv4'), p.tier, COALESCE(p.priority, 0), p.vrf_shares, p.kind, p.state
 		FROM pools p
 		JOIN sites s ON s.id = p.site_id
 		WHERE p.id=?`, poolID)
-	if err := row.Scan(&pool.ID, &pool.SiteID, &pool.Site, &pool.CIDR, &pool.Family, &pool.Tier, &pool.Priority); err != nil {
+	if err := row.Scan(&pool.ID, &pool.SiteID, &pool.Site, &pool.CIDR, &pool.Family, &pool.Tier, &pool.Priority, &pool.VRFShares, &pool.Kind, &pool.State); err != nil {
 		return Pool{}, false
 	}
 	return pool, true
@@ -517,8 +715,11 @@ func segmentByID(db *sql.DB, segmentID int64) (Segment, bool) {
 	row := db.QueryRow(`
 		SELECT s.id, s.site_id, si.name, s.vrf, s.vlan, s.name, s.hosts, s.prefix, s.cidr,
 			s.prefix_v6, s.cidr_v6, s.locked,
-			sm.dhcp_enabled, sm.dhcp_range, sm.dhcp_reservations, sm.gateway, sm.gateway_v6,
-			sm.notes, sm.tags, sm.pool_tier
+			COALESCE(sm.dhcp_enabled, 0), sm.dhcp_range, sm.dhcp_reservations, sm.gateway, sm.gateway_v6,
+			sm.notes, sm.tags, sm.pool_tier, sm.ipv6_mode, sm.ipv6_dns,
+			sm.owner_team, sm.owner_email,
+			s.expires_at, s.expiry_action, s.expired_at,
+			s.multi_prefix, s.secondary_cidrs, s.kind, s.link_a, s.link_b
 		FROM segments s
 		JOIN sites si ON si.id = s.site_id
 		LEFT JOIN segment_meta sm ON sm.segment_id = s.id
@@ -527,7 +728,10 @@ func segmentByID(db *sql.DB, segmentID int64) (Segment, bool) {
 		&seg.ID, &seg.SiteID, &seg.Site, &seg.VRF, &seg.VLAN, &seg.Name,
 		&seg.Hosts, &seg.Prefix, &seg.CIDR, &seg.PrefixV6, &seg.CIDRV6, &locked,
 		&seg.DhcpEnabled, &seg.DhcpRange, &seg.DhcpReservations, &seg.Gateway, &seg.GatewayV6,
-		&seg.Notes, &seg.Tags, &seg.PoolTier,
+		&seg.Notes, &seg.Tags, &seg.PoolTier, &seg.IPv6Mode, &seg.IPv6DNS,
+		&seg.OwnerTeam, &seg.OwnerEmail,
+		&seg.ExpiresAt, &seg.ExpiryAction, &seg.ExpiredAt,
+		&seg.MultiPrefix, &seg.SecondaryCIDRs, &seg.Kind, &seg.LinkA, &seg.LinkB,
 	); err != nil {
 		return Segment{}, false
 	}
@@ -0,0 +1,522 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every project has its own audit hash chain; rows without a project form one
+// more chain. The server and CLI commands may append to the same database, so
+// the chain head is read and extended under SQLite's write lock rather than
+// an in-process mutex.
+
+// auditChainConn adapts a dedicated connection to sqlConn.
+type auditChainConn struct {
+	ctx  context.Context
+	conn *sql.Conn
+}
+
+func (c auditChainConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.conn.ExecContext(c.ctx, query, args...)
+}
+
+func (c auditChainConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.conn.QueryContext(c.ctx, query, args...)
+}
+
+func (c auditChainConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.conn.QueryRowContext(c.ctx, query, args...)
+}
+
+// withAuditChain runs fn in a BEGIN IMMEDIATE transaction, so no other
+// connection or process can extend a chain between fn reading its head and
+// inserting after it.
+func withAuditChain(db *sql.DB, fn func(sqlConn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA busy_timeout = 5000`); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+		return err
+	}
+	if err := fn(auditChainConn{ctx: ctx, conn: conn}); err != nil {
+		_, _ = conn.ExecContext(ctx, `ROLLBACK`)
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `COMMIT`); err != nil {
+		_, _ = conn.ExecContext(ctx, `ROLLBACK`)
+		return err
+	}
+	return nil
+}
+
+type auditChainRow struct {
+	ProjectID   int64
+	CreatedAt   string
+	Actor       string
+	Action      string
+	EntityType  string
+	EntityID    sql.NullInt64
+	EntityLabel string
+	Reason      string
+	Before      string
+	After       string
+	PrevHash    string
+	Hash        string
+}
+
+func auditRowHash(r auditChainRow) string {
+	var entityID any
+	if r.EntityID.Valid {
+		entityID = r.EntityID.Int64
+	}
+	payload, _ := json.Marshal([]any{r.PrevHash, r.CreatedAt, r.Actor, r.Action, r.EntityType, entityID, r.EntityLabel, r.Reason, r.Before, r.After})
+	return checksumSHA256(string(payload))
+}
+
+func auditChainHead(db sqlConn, projectID int64, beforeID int64) (string, error) {
+	var head string
+	err := db.QueryRow(`SELECT COALESCE(hash, '') FROM audit_log WHERE project_id IS ? AND id < ? ORDER BY id DESC LIMIT 1`,
+		nullInt64ToAny(projectID), beforeID).Scan(&head)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return head, err
+}
+
+// sealAuditChain seals rows written before the chain existed. It runs at
+// startup and before the chain is read, not on every append.
+func sealAuditChain(db *sql.DB) error {
+	var pending int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE hash IS NULL`).Scan(&pending); err != nil || pending == 0 {
+		return err
+	}
+	return withAuditChain(db, sealAuditLog)
+}
+
+// sealAuditLog hashes rows written before the chain existed, oldest first.
+func sealAuditLog(db sqlConn) error {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(project_id, 0), actor, action, entity_type, entity_id, COALESCE(entity_label, ''), COALESCE(reason, ''),
+			COALESCE(before_json, ''), COALESCE(after_json, ''), created_at
+		FROM audit_log WHERE hash IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	type pending struct {
+		id  int64
+		row auditChainRow
+	}
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.row.ProjectID, &p.row.Actor, &p.row.Action, &p.row.EntityType, &p.row.EntityID,
+			&p.row.EntityLabel, &p.row.Reason, &p.row.Before, &p.row.After, &p.row.CreatedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		list = append(list, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, p := range list {
+		prev, err := auditChainHead(db, p.row.ProjectID, p.id)
+		if err != nil {
+			return err
+		}
+		p.row.PrevHash = prev
+		if _, err := db.Exec(`UPDATE audit_log SET prev_hash=?, hash=? WHERE id=?`, nullStringToAny(prev), auditRowHash(p.row), p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendAuditRow links row to the head of its project's chain and stores it.
+func appendAuditRow(db *sql.DB, row auditChainRow) error {
+	return withAuditChain(db, func(tx sqlConn) error {
+		return insertAuditChainRow(tx, row)
+	})
+}
+
+func insertAuditChainRow(db sqlConn, row auditChainRow) error {
+	prev, err := auditChainHead(db, row.ProjectID, int64(^uint64(0)>>1))
+	if err != nil {
+		return err
+	}
+	row.PrevHash = prev
+	row.Hash = auditRowHash(row)
+	_, err = db.Exec(`
+		INSERT INTO audit_log(
+			project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at, prev_hash, hash
+		) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		nullInt64ToAny(row.ProjectID),
+		row.Actor,
+		row.Action,
+		row.EntityType,
+		nullInt64ToAny(row.EntityID),
+		nullStringToAny(row.EntityLabel),
+		nullStringToAny(row.Reason),
+		nullStringToAny(row.Before),
+		nullStringToAny(row.After),
+		row.CreatedAt,
+		nullStringToAny(row.PrevHash),
+		row.Hash,
+	)
+	return err
+}
+
+type AuditChainReport struct {
+	ProjectID int64  `json:"project_id"`
+	Rows      int    `json:"rows"`
+	Head      string `json:"head,omitempty"`
+	Partial   bool   `json:"partial,omitempty"`
+}
+
+type AuditVerifyResult struct {
+	Valid    bool               `json:"valid"`
+	Rows     int                `json:"rows"`
+	Chains   []AuditChainReport `json:"chains"`
+	Problems []string           `json:"problems,omitempty"`
+}
+
+// verifyAuditRows checks hashes and links of rows given in any order.
+func verifyAuditRows(rows []auditChainRow) (AuditVerifyResult, map[int64][]auditChainRow) {
+	result := AuditVerifyResult{Rows: len(rows), Chains: []AuditChainReport{}}
+	byProject := map[int64][]auditChainRow{}
+	for _, r := range rows {
+		byProject[r.ProjectID] = append(byProject[r.ProjectID], r)
+	}
+	projectIDs := make([]int64, 0, len(byProject))
+	for id := range byProject {
+		projectIDs = append(projectIDs, id)
+	}
+	sort.Slice(projectIDs, func(i, j int) bool { return projectIDs[i] < projectIDs[j] })
+
+	ordered := map[int64][]auditChainRow{}
+	for _, pid := range projectIDs {
+		list := byProject[pid]
+		label := "project " + itoa64(pid)
+		if pid == 0 {
+			label = "entries without project"
+		}
+		hashes := map[string]bool{}
+		next := map[string]auditChainRow{}
+		var starts []auditChainRow
+		for i, r := range list {
+			if r.Hash == "" {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: entry %s %s/%s has no hash", label, r.CreatedAt, r.Action, r.EntityType))
+				continue
+			}
+			if got := auditRowHash(r); got != r.Hash {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: entry %s %s/%s was modified (hash %s, content hashes to %s)", label, r.CreatedAt, r.Action, r.EntityType, auditHashLabel(r.Hash), auditHashLabel(got)))
+			}
+			if hashes[r.Hash] {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: entry %s appears twice", label, auditHashLabel(r.Hash)))
+				continue
+			}
+			hashes[r.Hash] = true
+			if other, ok := next[r.PrevHash]; ok {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: entries %s and %s both follow %s", label, auditHashLabel(other.Hash), auditHashLabel(r.Hash), auditHashLabel(r.PrevHash)))
+				continue
+			}
+			next[r.PrevHash] = list[i]
+		}
+		for _, r := range next {
+			if !hashes[r.PrevHash] {
+				starts = append(starts, r)
+			}
+		}
+		if len(starts) != 1 {
+			if len(next) > 0 {
+				result.Problems = append(result.Problems, fmt.Sprintf("%s: chain has %d starting points, entries are missing or reordered", label, len(starts)))
+			}
+			continue
+		}
+		chain := []auditChainRow{starts[0]}
+		for {
+			r, ok := next[chain[len(chain)-1].Hash]
+			if !ok {
+				break
+			}
+			chain = append(chain, r)
+		}
+		if len(chain) != len(next) {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s: %d of %d entries are not linked into the chain", label, len(next)-len(chain), len(next)))
+		}
+		report := AuditChainReport{ProjectID: pid, Rows: len(chain), Head: chain[len(chain)-1].Hash, Partial: chain[0].PrevHash != ""}
+		result.Chains = append(result.Chains, report)
+		ordered[pid] = chain
+	}
+	result.Valid = len(result.Problems) == 0
+	return result, ordered
+}
+
+func auditHashLabel(h string) string {
+	if h == "" {
+		return "(start)"
+	}
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+// loadAuditChainRows reads the stored chain, sealing old rows first.
+func loadAuditChainRows(db *sql.DB, projectID int64) ([]auditChainRow, error) {
+	if err := sealAuditChain(db); err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT COALESCE(project_id, 0), actor, action, entity_type, entity_id, COALESCE(entity_label, ''), COALESCE(reason, ''),
+			COALESCE(before_json, ''), COALESCE(after_json, ''), created_at, COALESCE(prev_hash, ''), COALESCE(hash, '')
+		FROM audit_log`
+	var args []any
+	if projectID > 0 {
+		query += " WHERE project_id=?"
+		args = append(args, projectID)
+	}
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []auditChainRow
+	for rows.Next() {
+		var r auditChainRow
+		if err := rows.Scan(&r.ProjectID, &r.Actor, &r.Action, &r.EntityType, &r.EntityID, &r.EntityLabel, &r.Reason,
+			&r.Before, &r.After, &r.CreatedAt, &r.PrevHash, &r.Hash); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func verifyAuditLog(db *sql.DB, projectID int64) (AuditVerifyResult, error) {
+	rows, err := loadAuditChainRows(db, projectID)
+	if err != nil {
+		return AuditVerifyResult{}, err
+	}
+	result, _ := verifyAuditRows(rows)
+	for _, chain := range result.Chains {
+		if chain.Partial {
+			result.Problems = append(result.Problems, fmt.Sprintf("project %d: the oldest stored entry links to an entry that no longer exists", chain.ProjectID))
+			result.Valid = false
+		}
+	}
+	return result, nil
+}
+
+// parseAuditExport reads the CSV, JSON or NDJSON audit exports.
+func parseAuditExport(raw []byte) ([]auditChainRow, error) {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n\ufeff")
+	switch {
+	case len(trimmed) == 0:
+		return nil, fmt.Errorf("audit file is empty")
+	case trimmed[0] == '[':
+		var entries []AuditEntry
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, fmt.Errorf("audit json: %w", err)
+		}
+		out := make([]auditChainRow, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, auditEntryChainRow(e))
+		}
+		return out, nil
+	case trimmed[0] == '{':
+		var out []auditChainRow
+		sc := bufio.NewScanner(bytes.NewReader(trimmed))
+		sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for line := 1; sc.Scan(); line++ {
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			var rec auditStreamRecord
+			if err := json.Unmarshal([]byte(text), &rec); err != nil {
+				return nil, fmt.Errorf("audit ndjson line %d: %w", line, err)
+			}
+			out = append(out, auditStreamChainRow(rec))
+		}
+		return out, sc.Err()
+	}
+	return parseAuditCSV(trimmed)
+}
+
+func parseAuditCSV(raw []byte) ([]auditChainRow, error) {
+	r, _, err := newCSVReader(bytes.NewReader(raw), CSVOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("audit csv: %w", err)
+	}
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("audit csv: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.TrimSpace(h)] = i
+	}
+	for _, need := range []string{"created_at", "actor", "action", "entity_type", "hash"} {
+		if _, ok := col[need]; !ok {
+			return nil, fmt.Errorf("audit csv: missing column %q", need)
+		}
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+	var out []auditChainRow
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("audit csv: %w", err)
+		}
+		row := auditChainRow{
+			CreatedAt:   get(rec, "created_at"),
+			Actor:       get(rec, "actor"),
+			Action:      get(rec, "action"),
+			EntityType:  get(rec, "entity_type"),
+			EntityLabel: get(rec, "entity_label"),
+			Reason:      get(rec, "reason"),
+			Before:      get(rec, "before_json"),
+			After:       get(rec, "after_json"),
+			PrevHash:    get(rec, "prev_hash"),
+			Hash:        get(rec, "hash"),
+		}
+		if v, err := strconv.ParseInt(get(rec, "entity_id"), 10, 64); err == nil {
+			row.EntityID = sql.NullInt64{Int64: v, Valid: true}
+		}
+		row.ProjectID, _ = strconv.ParseInt(get(rec, "project_id"), 10, 64)
+		out = append(out, row)
+	}
+}
+
+func auditEntryChainRow(e AuditEntry) auditChainRow {
+	return auditChainRow{
+		ProjectID:   e.ProjectID.Int64,
+		CreatedAt:   e.CreatedAt,
+		Actor:       e.Actor,
+		Action:      e.Action,
+		EntityType:  e.EntityType,
+		EntityID:    e.EntityID,
+		EntityLabel: nullString(e.EntityLabel),
+		Reason:      nullString(e.Reason),
+		Before:      nullString(e.BeforeJSON),
+		After:       nullString(e.AfterJSON),
+		PrevHash:    e.PrevHash,
+		Hash:        e.Hash,
+	}
+}
+
+func auditStreamChainRow(rec auditStreamRecord) auditChainRow {
+	row := auditChainRow{
+		CreatedAt:   rec.CreatedAt,
+		Actor:       rec.Actor,
+		Action:      rec.Action,
+		EntityType:  rec.EntityType,
+		EntityLabel: rec.EntityLabel,
+		Reason:      rec.Reason,
+		Before:      string(rec.Before),
+		After:       string(rec.After),
+		PrevHash:    rec.PrevHash,
+		Hash:        rec.Hash,
+	}
+	if rec.ProjectID != nil {
+		row.ProjectID = int64(*rec.ProjectID)
+	}
+	if rec.EntityID != nil {
+		row.EntityID = sql.NullInt64{Int64: int64(*rec.EntityID), Valid: true}
+	}
+	return row
+}
+
+// restoreAuditRows verifies an exported trail and appends the entries the
+// project does not have yet.
+func restoreAuditRows(db *sql.DB, projectID int64, rows []auditChainRow) (added, skipped int, err error) {
+	result, chains := verifyAuditRows(rows)
+	if !result.Valid {
+		return 0, 0, fmt.Errorf("audit trail failed verification: %s", strings.Join(result.Problems, "; "))
+	}
+	if len(chains) != 1 {
+		return 0, 0, fmt.Errorf("audit trail contains %d project chains; restore one project at a time", len(chains))
+	}
+	var chain []auditChainRow
+	for _, c := range chains {
+		chain = c
+	}
+
+	err = withAuditChain(db, func(tx sqlConn) error {
+		if err := sealAuditLog(tx); err != nil {
+			return err
+		}
+		existing := map[string]bool{}
+		hashRows, err := tx.Query(`SELECT hash FROM audit_log WHERE project_id=? AND hash IS NOT NULL`, projectID)
+		if err != nil {
+			return err
+		}
+		for hashRows.Next() {
+			var h string
+			if err := hashRows.Scan(&h); err != nil {
+				hashRows.Close()
+				return err
+			}
+			existing[h] = true
+		}
+		hashRows.Close()
+
+		head, err := auditChainHead(tx, projectID, int64(^uint64(0)>>1))
+		if err != nil {
+			return err
+		}
+		for _, r := range chain {
+			if existing[r.Hash] {
+				skipped++
+				continue
+			}
+			if r.PrevHash != head {
+				return fmt.Errorf("entry %s links to %s but the project's chain ends at %s", auditHashLabel(r.Hash), auditHashLabel(r.PrevHash), auditHashLabel(head))
+			}
+			_, err := tx.Exec(`
+				INSERT INTO audit_log(
+					project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at, prev_hash, hash
+				) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				projectID, r.Actor, r.Action, r.EntityType, nullInt64ToAny(r.EntityID),
+				nullStringToAny(r.EntityLabel), nullStringToAny(r.Reason), nullStringToAny(r.Before), nullStringToAny(r.After),
+				r.CreatedAt, nullStringToAny(r.PrevHash), r.Hash)
+			if err != nil {
+				return err
+			}
+			head = r.Hash
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return added, skipped, nil
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chatOpsMaxSkew is how old a signed Slack request may be.
+const chatOpsMaxSkew = 5 * time.Minute
+
+const chatOpsMaxCount = 20
+
+var errChatOpsDisabled = errors.New("chatops is not configured: set CHATOPS_SIGNING_SECRET or CHATOPS_TOKEN")
+
+// ChatOpsReply is the slash-command response body.
+type ChatOpsReply struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// verifyChatOps checks a slash-command request.
+func verifyChatOps(header func(string) string, body []byte, now time.Time) error {
+	if ref := mustEnv("CHATOPS_SIGNING_SECRET", ""); ref != "" {
+		secret, err := resolveSecret(ref)
+		if err != nil {
+			return err
+		}
+		ts, err := strconv.ParseInt(header("X-Slack-Request-Timestamp"), 10, 64)
+		if err != nil {
+			return errors.New("missing or invalid X-Slack-Request-Timestamp")
+		}
+		if skew := now.Sub(time.Unix(ts, 0)); skew > chatOpsMaxSkew || skew < -chatOpsMaxSkew {
+			return errors.New("request timestamp is too far from now")
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		fmt.Fprintf(mac, "v0:%d:", ts)
+		mac.Write(body)
+		want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(want), []byte(header("X-Slack-Signature"))) {
+			return errors.New("invalid request signature")
+		}
+		return nil
+	}
+	if ref := mustEnv("CHATOPS_TOKEN", ""); ref != "" {
+		token, err := resolveSecret(ref)
+		if err != nil {
+			return err
+		}
+		form, _ := url.ParseQuery(string(body))
+		if subtle.ConstantTimeCompare([]byte(token), []byte(form.Get("token"))) != 1 {
+			return errors.New("invalid verification token")
+		}
+		return nil
+	}
+	return errChatOpsDisabled
+}
+
+const chatOpsUsage = "Usage:\n" +
+	"• `whois <address or prefix>`: the segments, MAC reservations and pools holding it\n" +
+	"• `free site=<site> /<prefix> [project=<project>] [count=<n>]`: free blocks of that size in the site's pools"
+
+// runChatOpsCommand answers the text after the slash command.
+func runChatOpsCommand(db *sql.DB, text string) ChatOpsReply {
+	fields := strings.Fields(text)
+	reply := ChatOpsReply{ResponseType: "ephemeral"}
+	if len(fields) == 0 {
+		reply.Text = chatOpsUsage
+		return reply
+	}
+	var err error
+	switch strings.ToLower(fields[0]) {
+	case "whois":
+		reply.Text, err = chatOpsWhois(db, fields[1:])
+	case "free":
+		reply.Text, err = chatOpsFree(db, fields[1:])
+	case "help":
+		reply.Text = chatOpsUsage
+	default:
+		err = fmt.Errorf("unknown command %q", fields[0])
+	}
+	if err != nil {
+		reply.Text = "Error: " + err.Error() + "\n" + chatOpsUsage
+	}
+	return reply
+}
+
+// chatOpsWhois lists what holds an address, or what overlaps a prefix.
+func chatOpsWhois(db *sql.DB, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("whois takes one address or prefix")
+	}
+	query, err := parseAddrOrPrefix(args[0])
+	if err != nil {
+		return "", err
+	}
+	projects, err := listProjects(db)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, p := range projects {
+		segs, err := listSegments(db, p.ID)
+		if err != nil {
+			return "", err
+		}
+		holding := map[int64]bool{}
+		for _, s := range segs {
+			for _, prefix := range segmentPrefixes(s) {
+				if !prefixesOverlap(prefix, query) {
+					continue
+				}
+				holding[s.ID] = true
+				line := fmt.Sprintf("• Segment *%s*: %s / %s, VRF `%s`, VLAN %d, `%s`", s.Name, p.Name, s.Site, s.VRF, s.VLAN, prefix)
+				if role := addrRole(prefix, query, s); role != "" {
+					line += " (" + role + ")"
+				}
+				if s.Locked {
+					line += ", locked"
+				}
+				lines = append(lines, line)
+			}
+		}
+		if query.IsSingleIP() {
+			macs, err := listMACReservations(db, p.ID)
+			if err != nil {
+				return "", err
+			}
+			for _, m := range macs {
+				if addr, err := netip.ParseAddr(m.IP); err == nil && addr == query.Addr() {
+					line := fmt.Sprintf("• MAC reservation `%s` in %s", m.MAC, m.SegmentName)
+					if m.Hostname != "" {
+						line += ", host " + m.Hostname
+					}
+					lines = append(lines, line)
+				}
+			}
+		}
+		if len(holding) > 0 {
+			continue
+		}
+		pools, err := listPools(db, p.ID)
+		if err != nil {
+			return "", err
+		}
+		for _, pool := range pools {
+			prefix, err := netip.ParsePrefix(pool.CIDR)
+			if err == nil && prefixesOverlap(prefix, query) {
+				lines = append(lines, fmt.Sprintf("• Pool `%s`: %s / %s, not assigned to a segment", prefix, p.Name, pool.Site))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("Nothing in Subnetio holds `%s`.", args[0]), nil
+	}
+	return fmt.Sprintf("*%s*\n%s", args[0], strings.Join(lines, "\n")), nil
+}
+
+// parseAddrOrPrefix reads an address as a single-address prefix.
+func parseAddrOrPrefix(raw string) (netip.Prefix, error) {
+	if strings.Contains(raw, "/") {
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%q is not an address or prefix", raw)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(raw)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("%q is not an address or prefix", raw)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// addrRole names what a single address is within a segment prefix.
+func addrRole(prefix, query netip.Prefix, s Segment) string {
+	if !query.IsSingleIP() {
+		return ""
+	}
+	addr := query.Addr()
+	switch {
+	case (s.Gateway.Valid && s.Gateway.String == addr.String()) || (s.GatewayV6.Valid && s.GatewayV6.String == addr.String()):
+		return "gateway"
+	case addr == prefix.Masked().Addr() && prefix.Addr().Is4() && prefix.Bits() < 31:
+		return "network address"
+	case addr.Is4() && prefix.Bits() < 31 && ipv4ToU32(addr) == ipv4ToU32(prefix.Masked().Addr())+uint32(1<<(32-prefix.Bits()))-1:
+		return "broadcast address"
+	}
+	return ""
+}
+
+// chatOpsFree lists free blocks of one size in the pools of a site.
+func chatOpsFree(db *sql.DB, args []string) (string, error) {
+	var site, project string
+	want, count := 0, 5
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		switch {
+		case ok && key == "site":
+			site = value
+		case ok && key == "project":
+			project = value
+		case ok && key == "count":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > chatOpsMaxCount {
+				return "", fmt.Errorf("count must be from 1 to %d", chatOpsMaxCount)
+			}
+			count = n
+		case !ok:
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "/"))
+			if err != nil || n < 1 || n > 128 {
+				return "", fmt.Errorf("%q is not a prefix length such as /26", arg)
+			}
+			want = n
+		default:
+			return "", fmt.Errorf("unknown option %q", key)
+		}
+	}
+	if site == "" || want == 0 {
+		return "", errors.New("free needs site=<site> and a prefix length")
+	}
+	projects, err := listProjects(db)
+	if err != nil {
+		return "", err
+	}
+	var sections []string
+	for _, p := range projects {
+		if project != "" && !strings.EqualFold(p.Name, project) && itoa64(p.ID) != project {
+			continue
+		}
+		sites, err := listSites(db, p.ID)
+		if err != nil {
+			return "", err
+		}
+		var siteID int64
+		for _, s := range sites {
+			if strings.EqualFold(s.Name, site) {
+				siteID = s.ID
+			}
+		}
+		if siteID == 0 {
+			continue
+		}
+		pools, err := listPools(db, p.ID)
+		if err != nil {
+			return "", err
+		}
+		segs, err := listSegments(db, p.ID)
+		if err != nil {
+			return "", err
+		}
+		var siteSegs []Segment
+		for _, s := range segs {
+			if s.SiteID == siteID {
+				siteSegs = append(siteSegs, s)
+			}
+		}
+		reservedV4, reservedV6, _ := buildReservedIndex(sites)
+		var lines []string
+		for _, pool := range pools {
+			prefix, err := netip.ParsePrefix(pool.CIDR)
+			if err != nil || pool.SiteID != siteID || isLinkPool(pool) || want < prefix.Bits() {
+				continue
+			}
+			var blocks []netip.Prefix
+			var total string
+			switch {
+			case prefix.Addr().Is4() && want <= 32:
+				var n uint64
+				blocks, n = freeBlocksV4(prefix, siteSegs, reservedV4[siteID], want, count)
+				total = strconv.FormatUint(n, 10)
+			case prefix.Addr().Is6() && want > 32:
+				gaps, _, _ := poolGapsV6(prefix, siteSegs, reservedV6[siteID])
+				for _, g := range gaps {
+					blocks = append(blocks, bigRangeToPrefixes(g, want, count-len(blocks))...)
+				}
+			default:
+				continue
+			}
+			line := fmt.Sprintf("• Pool `%s`: ", prefix)
+			if len(blocks) == 0 {
+				line += "no free /" + itoa(want)
+			} else {
+				parts := make([]string, len(blocks))
+				for i, b := range blocks {
+					parts[i] = "`" + b.String() + "`"
+				}
+				line += strings.Join(parts, ", ")
+				if total != "" {
+					line += fmt.Sprintf(" (%s free /%d in total)", total, want)
+				}
+			}
+			lines = append(lines, line)
+		}
+		if len(lines) == 0 {
+			lines = append(lines, fmt.Sprintf("• No pool of this family can hold a /%d", want))
+		}
+		sections = append(sections, fmt.Sprintf("*Free /%d at %s* (%s)\n%s", want, site, p.Name, strings.Join(lines, "\n")))
+	}
+	if len(sections) == 0 {
+		return fmt.Sprintf("No site %q found.", site), nil
+	}
+	return strings.Join(sections, "\n"), nil
+}
+
+// freeBlocksV4 returns up to limit free aligned blocks of length want in an
+// IPv4 pool, and how many there are in all.
+func freeBlocksV4(pool netip.Prefix, segs []Segment, reserved []netip.Prefix, want, limit int) ([]netip.Prefix, uint64) {
+	gaps, _, _ := poolGapsV4(pool, segs, reserved)
+	step := uint64(1) << (32 - want)
+	var out []netip.Prefix
+	var total uint64
+	for _, g := range gaps {
+		start := (uint64(g.start) + step - 1) / step * step
+		end := uint64(g.end) + 1
+		if start+step > end {
+			continue
+		}
+		n := (end - start) / step
+		total += n
+		for i := uint64(0); i < n && len(out) < limit; i++ {
+			out = append(out, netip.PrefixFrom(u32ToIPv4(uint32(start+i*step)), want))
+		}
+	}
+	return out, total
+}
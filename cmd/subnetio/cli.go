@@ -0,0 +1,759 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cliUsage = `usage: subnetio [--config FILE] [command] [flags]
+
+commands:
+  serve    [--migrate-only] [--skip-migrations]
+                                 run the web UI (default)
+  migrate  [--status] [--down N] [--force N]
+  export   --project P --format yaml|json|csv [--output FILE]
+  import   FILE [--project P] [--format yaml|json|csv] [--dry-run] [--respect-deletions]
+           [--csv-delimiter D] [--csv-encoding E] [--patch]
+  allocate --project P
+  notify   [--project P] [--dry-run]
+                                 send new conflicts and capacity warnings to their owners
+  generate --template T [--project P] [--site S] [--vrf V] [--segment N] [--output FILE]
+  template-test [--template T]   render the template fixtures and diff against their expected output
+  audit verify [--project P] [FILE]
+                                 check the audit hash chain in the database or an export
+  audit import --project P FILE  restore an exported audit trail
+  config                         print the effective settings and where they come from
+
+The database is taken from DB_PATH (default ./subnetio.sqlite). Settings come
+from environment variables, then from the YAML file given by --config or
+CONFIG_FILE.
+`
+
+// runCLI dispatches subcommands so CI pipelines can work on the database
+// without going through HTTP.
+func runCLI(args []string, out io.Writer) error {
+	configPath, args, err := splitConfigFlag(args)
+	if err != nil {
+		return err
+	}
+	if err := loadConfig(configPath); err != nil {
+		return err
+	}
+	secretBackend, err := loadSecretBackend()
+	if err != nil {
+		return err
+	}
+	setSecretBackend(secretBackend)
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help") {
+		return runServeCmd(args, out)
+	}
+	cmd, rest := args[0], args[1:]
+	switch cmd {
+	case "serve":
+		return runServeCmd(rest, out)
+	case "migrate":
+		return runMigrateCmd(rest, out)
+	case "export":
+		return runExportCmd(rest, out)
+	case "import":
+		return runImportCmd(rest, out)
+	case "allocate":
+		return runAllocateCmd(rest, out)
+	case "notify":
+		return runNotifyCmd(rest, out)
+	case "generate":
+		return runGenerateCmd(rest, out)
+	case "template-test":
+		return runTemplateTestCmd(rest, out)
+	case "audit":
+		return runAuditCmd(rest, out)
+	case "config":
+		writeEffectiveConfig(out)
+		return nil
+	case "help", "-h", "--help":
+		fmt.Fprint(out, cliUsage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", cmd, cliUsage)
+}
+
+func runServeCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(out)
+	migrateOnly := fs.Bool("migrate-only", false, "apply pending migrations and exit")
+	skipMigrations := fs.Bool("skip-migrations", false, "do not migrate; refuse to start unless the schema is current")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *migrateOnly && *skipMigrations {
+		return fmt.Errorf("--migrate-only and --skip-migrations are mutually exclusive")
+	}
+	if *migrateOnly {
+		return runMigrateCmd(nil, out)
+	}
+	runServer(*skipMigrations)
+	return nil
+}
+
+func runMigrateCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	status := fs.Bool("status", false, "print the schema version and exit")
+	down := fs.Int("down", -1, "roll back to this version")
+	force := fs.Int("force", -1, "mark this version as applied and clean without running SQL")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", sqliteDSN(mustEnv("DB_PATH", "./subnetio.sqlite")))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	switch {
+	case *status:
+	case *force >= 0:
+		err = forceMigrationVersion(db, *force)
+	case *down >= 0:
+		err = migrateDown(db, *down)
+	default:
+		err = migrate(db)
+	}
+	if err != nil {
+		return err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	files, err := listMigrationFiles()
+	if err != nil {
+		return err
+	}
+	latest, err := latestMigrationVersion(files)
+	if err != nil {
+		return err
+	}
+	current, err := currentMigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	dirty, err := dirtyMigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "schema version %d of %d", current, latest)
+	if dirty > 0 {
+		fmt.Fprintf(out, " (dirty at %d)", dirty)
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+func cliOpenDB() (*sql.DB, int64, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(mustEnv("DB_PATH", "./subnetio.sqlite")))
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+	defaultProjectID, err := ensureDefaultProject(db)
+	if err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+	if err := sealAuditChain(db); err != nil {
+		db.Close()
+		return nil, 0, err
+	}
+	return db, defaultProjectID, nil
+}
+
+// cliProject resolves --project given as a name or numeric id; empty means
+// the default project.
+func cliProject(db *sql.DB, defaultProjectID int64, ref string) (Project, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		ref = itoa64(defaultProjectID)
+	}
+	projects, err := listProjects(db)
+	if err != nil {
+		return Project{}, err
+	}
+	id, _ := strconv.ParseInt(ref, 10, 64)
+	for _, p := range projects {
+		if p.ID == id || strings.EqualFold(p.Name, ref) {
+			return p, nil
+		}
+	}
+	return Project{}, fmt.Errorf("project %q not found", ref)
+}
+
+// cliCheckWritable refuses a change to project that the web UI would refuse:
+// in maintenance mode or when the project is archived.
+func cliCheckWritable(db *sql.DB, project Project) error {
+	if err := maintenanceError(db); err != nil {
+		return err
+	}
+	if project.Archived {
+		return archivedError(project.Name)
+	}
+	return nil
+}
+
+// cliOutput returns out, or a created file when path is set.
+func cliOutput(out io.Writer, path string) (io.Writer, func() error, error) {
+	if strings.TrimSpace(path) == "" {
+		return out, func() error { return nil }, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// parseInterspersed parses flags while allowing positional arguments between
+// them, e.g. `import plan.yaml --dry-run`.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func runExportCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id")
+	format := fs.String("format", "yaml", "yaml, json or csv")
+	output := fs.String("output", "", "write to file instead of stdout")
+	site := fs.String("site", "", "export only this site")
+	vrf := fs.String("vrf", "", "export only this VRF")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	project, err := cliProject(db, defaultProjectID, *projectRef)
+	if err != nil {
+		return err
+	}
+	if project.Sandbox {
+		return fmt.Errorf("project %q is a sandbox; sandbox projects are not exported", project.Name)
+	}
+	bundle, err := buildScopedPlanBundle(db, project.ID, PlanScope{Site: strings.TrimSpace(*site), VRF: strings.TrimSpace(*vrf)})
+	if err != nil {
+		return err
+	}
+	stampPlanExport(&bundle, time.Now())
+	w, closeFn, err := cliOutput(out, *output)
+	if err != nil {
+		return err
+	}
+	if err := writePlanBundle(w, bundle, strings.ToLower(*format)); err != nil {
+		closeFn()
+		return err
+	}
+	return closeFn()
+}
+
+func runImportCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id for rows without a project")
+	format := fs.String("format", "", "yaml, json or csv (default: from file extension)")
+	dryRun := fs.Bool("dry-run", false, "validate and report without saving")
+	onConflict := fs.String("on-conflict", "", "skip, overwrite or fail rows that differ from existing data (e.g. skip,segment=fail)")
+	forceLocked := fs.String("force-locked", "", "reason for changing locked segments; without it such rows fail")
+	respectDeletions := fs.Bool("respect-deletions", false, "skip segments deleted after the file was exported")
+	csvDelimiter := fs.String("csv-delimiter", "auto", "CSV delimiter: comma, semicolon, tab, pipe or auto")
+	csvEncoding := fs.String("csv-encoding", "auto", "CSV encoding, e.g. utf-8, utf-16le, windows-1251, or auto")
+	patch := fs.Bool("patch", false, "the file is a patch: changed rows only, each with an op (add, update or delete); all or nothing")
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("import needs exactly one plan file")
+	}
+	policy, err := parseImportPolicy(*onConflict)
+	if err != nil {
+		return err
+	}
+	policy.ForceLocked = strings.TrimSpace(*forceLocked) != ""
+	policy.RespectDeletions = *respectDeletions
+	policy.Patch = *patch
+	if policy.CSV, err = parseCSVOptions(*csvDelimiter, *csvEncoding); err != nil {
+		return err
+	}
+	path := files[0]
+	kind := strings.ToLower(strings.TrimSpace(*format))
+	if kind == "" {
+		kind = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if kind == "yml" {
+			kind = "yaml"
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	project, err := cliProject(db, defaultProjectID, *projectRef)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := cliCheckWritable(db, project); err != nil {
+			return err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	report := importPlanStream(tx, file, kind, project.ID, policy)
+	report.FileName = filepath.Base(path)
+	rolledBack := policy.Patch && len(report.Errors) > 0
+	if rolledBack {
+		report.rollBackPatch()
+	}
+	if !*dryRun && !rolledBack {
+		if err := recordImportProvenance(tx, project.ID, kind, "cli", report); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if *dryRun || rolledBack {
+		if err := tx.Rollback(); err != nil {
+			return err
+		}
+	} else if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	mode := "imported"
+	if *dryRun {
+		mode = "dry-run"
+	} else if rolledBack {
+		mode = "rolled back"
+	}
+	fmt.Fprintf(out, "%s %s: projects=%d sites=%d pools=%d segments=%d updated=%d skipped=%d\n", mode, path,
+		report.ProjectsAdded, report.SitesAdded, report.PoolsAdded, report.SegmentsAdded, report.Updated, report.Skipped)
+	if report.Deleted > 0 {
+		fmt.Fprintf(out, "deleted=%d\n", report.Deleted)
+	}
+	if report.CSV != "" {
+		fmt.Fprintf(out, "read as %s\n", report.CSV)
+	}
+	if report.ImportID > 0 {
+		fmt.Fprintf(out, "recorded as import #%d\n", report.ImportID)
+	}
+	for _, w := range report.Warnings {
+		fmt.Fprintf(out, "warning: %s\n", w)
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(out, "error: %s\n", e)
+	}
+	if !*dryRun && !rolledBack {
+		var reason sql.NullString
+		if policy.ForceLocked {
+			reason = segmentForce{Force: true, Reason: *forceLocked}.auditReason()
+		}
+		_ = insertAuditRecord(db, auditRecord{
+			ProjectID:   project.ID,
+			Actor:       "cli",
+			Action:      "import",
+			Reason:      reason,
+			EntityType:  "plan",
+			EntityID:    sql.NullInt64{Int64: project.ID, Valid: true},
+			EntityLabel: sql.NullString{String: project.Name, Valid: true},
+			After: auditImportSummary{
+				Source:        kind,
+				ProjectsAdded: report.ProjectsAdded,
+				SitesAdded:    report.SitesAdded,
+				PoolsAdded:    report.PoolsAdded,
+				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Deleted:       report.Deleted,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
+				Warnings:      report.Warnings,
+				Errors:        report.Errors,
+			},
+		})
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("import finished with %d error(s)", len(report.Errors))
+	}
+	return nil
+}
+
+func runAllocateCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("allocate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	project, err := cliProject(db, defaultProjectID, *projectRef)
+	if err != nil {
+		return err
+	}
+	if err := cliCheckWritable(db, project); err != nil {
+		return err
+	}
+
+	before, _ := listSegments(db, project.ID)
+	var check allocationCheck
+	if v, ok := getAllocationValidator(db, project.ID); ok {
+		check = allocationValidatorCheck(v, project, "cli")
+	}
+	check = chainAllocationChecks(check, allocationHookCheck(project, "cli"))
+	if err := allocateProject(db, project.ID, check); err != nil {
+		if isValidatorError(err) || isQuotaError(err) {
+			_ = insertAuditRecord(db, auditRecord{
+				ProjectID:   project.ID,
+				Actor:       "cli",
+				Action:      "allocate_rejected",
+				EntityType:  "allocation",
+				EntityID:    sql.NullInt64{Int64: project.ID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After:       map[string]string{"error": err.Error()},
+			})
+		}
+		var allocErr *AllocationError
+		if errors.As(err, &allocErr) {
+			for _, alt := range allocErr.Alternatives {
+				fmt.Fprintf(out, "  alternative: %s\n", alt.Detail)
+			}
+		}
+		return fmt.Errorf("allocate error: %w", err)
+	}
+	after, _ := listSegments(db, project.ID)
+	summary := buildAllocationSummary(before, after)
+	if err := insertAuditRecord(db, auditRecord{
+		ProjectID:   project.ID,
+		Actor:       "cli",
+		Action:      "allocate",
+		EntityType:  "allocation",
+		EntityID:    sql.NullInt64{Int64: project.ID, Valid: true},
+		EntityLabel: sql.NullString{String: project.Name, Valid: true},
+		After:       summary,
+	}); err != nil {
+		return err
+	}
+	notifyAllocationHook(project, "cli", summary)
+	for _, ch := range summary.Changes {
+		fmt.Fprintf(out, "%s/%s/%d %s: %s -> %s\n", ch.Site, ch.VRF, ch.VLAN, ch.Name,
+			dashIfEmpty(ch.CIDRBefore), dashIfEmpty(ch.CIDRAfter))
+	}
+	fmt.Fprintf(out, "allocated project %s: %d segment(s) changed of %d\n", project.Name, len(summary.Changes), summary.TotalSegments)
+	return nil
+}
+
+func runNotifyCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("notify", flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id")
+	dryRun := fs.Bool("dry-run", false, "print where notifications would go without sending them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	project, err := cliProject(db, defaultProjectID, *projectRef)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		if err := cliCheckWritable(db, project); err != nil {
+			return err
+		}
+	}
+	result, err := sendProjectNotifications(db, project, *dryRun, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, d := range result.Deliveries {
+		fmt.Fprintf(out, "  %s\n", d)
+	}
+	if result.Sent > 0 || len(result.Errors) > 0 {
+		if err := insertAuditRecord(db, auditRecord{
+			ProjectID:   project.ID,
+			Actor:       "cli",
+			Action:      "notify",
+			EntityType:  "notification",
+			EntityID:    sql.NullInt64{Int64: project.ID, Valid: true},
+			EntityLabel: sql.NullString{String: project.Name, Valid: true},
+			After:       map[string]any{"sent": result.Sent, "deliveries": result.Deliveries, "errors": result.Errors},
+		}); err != nil {
+			return err
+		}
+	}
+	summary := notifySummary(result)
+	if *dryRun {
+		summary += " (dry run, nothing sent)"
+	}
+	fmt.Fprintf(out, "project %s: %s\n", project.Name, summary)
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
+
+func runGenerateCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id")
+	template := fs.String("template", "", "template name, e.g. cisco")
+	site := fs.String("site", "", "only this site")
+	vrf := fs.String("vrf", "", "only this VRF")
+	segment := fs.String("segment", "", "only segments matching this name")
+	domain := fs.String("domain", "", "override the DHCP domain name")
+	dhcpRole := fs.String("dhcp-role", "", "DHCP failover role (primary or secondary)")
+	family := fs.String("family", "ipv4", "address family to render (ipv4, ipv6 or dual)")
+	noVRF := fs.Bool("no-vrf", false, "omit VRF configuration")
+	noVLAN := fs.Bool("no-vlan", false, "omit VLAN configuration")
+	noDHCP := fs.Bool("no-dhcp", false, "omit DHCP configuration")
+	output := fs.String("output", "", "write to file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts := GenerateOptions{
+		Template:       strings.ToLower(strings.TrimSpace(*template)),
+		IncludeVRF:     !*noVRF,
+		IncludeVLAN:    !*noVLAN,
+		IncludeDHCP:    !*noDHCP,
+		SiteFilter:     strings.TrimSpace(*site),
+		VRFFilter:      strings.TrimSpace(*vrf),
+		SegmentFilter:  strings.TrimSpace(*segment),
+		DomainOverride: strings.TrimSpace(*domain),
+		DHCPRole:       normalizeDHCPRole(*dhcpRole),
+		Family:         normalizeGenerateFamily(*family),
+	}
+	if opts.Template == "" {
+		return fmt.Errorf("--template is required")
+	}
+
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	project, err := cliProject(db, defaultProjectID, *projectRef)
+	if err != nil {
+		return err
+	}
+	result, err := generateForProject(db, project.ID, opts)
+	if err != nil {
+		return err
+	}
+	w, closeFn, err := cliOutput(out, *output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, strings.TrimRight(result.Output, "\n")+"\n"); err != nil {
+		closeFn()
+		return err
+	}
+	return closeFn()
+}
+
+// runTemplateTestCmd fails when any fixture does not render its expected
+// output, so a pipeline can gate template changes on it.
+func runTemplateTestCmd(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("template-test", flag.ContinueOnError)
+	fs.SetOutput(out)
+	only := fs.String("template", "", "only this template")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	db, _, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	name := ""
+	if strings.TrimSpace(*only) != "" {
+		if name, err = normalizeTemplateName(*only); err != nil {
+			return err
+		}
+	}
+	fixtures, err := listTemplateFixtures(db, name)
+	if err != nil {
+		return err
+	}
+	var templates []string
+	for _, f := range fixtures {
+		if len(templates) == 0 || templates[len(templates)-1] != f.Template {
+			templates = append(templates, f.Template)
+		}
+	}
+	total, failed := 0, 0
+	for _, tmpl := range templates {
+		results, err := runStoredTemplateFixtures(db, tmpl)
+		if err != nil {
+			return fmt.Errorf("template %s: %v", tmpl, err)
+		}
+		for _, r := range results {
+			total++
+			if r.Passed {
+				fmt.Fprintf(out, "ok   %s/%s\n", tmpl, r.Fixture)
+				continue
+			}
+			failed++
+			fmt.Fprintf(out, "FAIL %s/%s\n", tmpl, r.Fixture)
+			if r.Error != "" {
+				fmt.Fprintf(out, "  %s\n", r.Error)
+			}
+			if r.Diff != "" {
+				fmt.Fprintln(out, strings.TrimRight(r.Diff, "\n"))
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d template fixtures failed", failed, total)
+	}
+	fmt.Fprintf(out, "%d template fixtures passed\n", total)
+	return nil
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func runAuditCmd(args []string, out io.Writer) error {
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "import") {
+		return fmt.Errorf("audit needs verify or import\n%s", cliUsage)
+	}
+	sub := args[0]
+	fs := flag.NewFlagSet("audit "+sub, flag.ContinueOnError)
+	fs.SetOutput(out)
+	projectRef := fs.String("project", "", "project name or id")
+	files, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(files) > 1 || (sub == "import" && len(files) != 1) {
+		return fmt.Errorf("audit %s takes one export file", sub)
+	}
+	var rows []auditChainRow
+	if len(files) == 1 {
+		raw, err := os.ReadFile(files[0])
+		if err != nil {
+			return err
+		}
+		if rows, err = parseAuditExport(raw); err != nil {
+			return err
+		}
+	}
+
+	db, defaultProjectID, err := cliOpenDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var project Project
+	if *projectRef != "" || sub == "import" {
+		if project, err = cliProject(db, defaultProjectID, *projectRef); err != nil {
+			return err
+		}
+	}
+
+	if sub == "import" {
+		if err := cliCheckWritable(db, project); err != nil {
+			return err
+		}
+		added, skipped, err := restoreAuditRows(db, project.ID, rows)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "restored %d audit entries into %s (%d already present)\n", added, project.Name, skipped)
+		if added > 0 {
+			_ = insertAuditRecord(db, auditRecord{
+				ProjectID:   project.ID,
+				Actor:       "cli",
+				Action:      "import",
+				EntityType:  "audit_log",
+				EntityID:    sql.NullInt64{Int64: project.ID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After:       map[string]any{"source": files[0], "added": added, "skipped": skipped},
+			})
+		}
+		return nil
+	}
+
+	var result AuditVerifyResult
+	source := "database"
+	if len(files) == 1 {
+		source = files[0]
+		if project.ID > 0 {
+			kept := rows[:0]
+			for _, r := range rows {
+				if r.ProjectID == 0 || r.ProjectID == project.ID {
+					kept = append(kept, r)
+				}
+			}
+			rows = kept
+		}
+		result, _ = verifyAuditRows(rows)
+	} else if result, err = verifyAuditLog(db, project.ID); err != nil {
+		return err
+	}
+	for _, chain := range result.Chains {
+		note := ""
+		if chain.Partial {
+			note = " (starts after an entry not in the input)"
+		}
+		fmt.Fprintf(out, "project %d: %d entries, head %s%s\n", chain.ProjectID, chain.Rows, chain.Head, note)
+	}
+	for _, p := range result.Problems {
+		fmt.Fprintf(out, "problem: %s\n", p)
+	}
+	if !result.Valid {
+		return fmt.Errorf("audit chain in %s is not intact (%d problem(s))", source, len(result.Problems))
+	}
+	fmt.Fprintf(out, "audit chain in %s verified: %d entries\n", source, result.Rows)
+	return nil
+}
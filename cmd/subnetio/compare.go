@@ -0,0 +1,315 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A comparison lines up a reference project (left) with another one (right) by
+// site and segment name, e.g. a branch against the reference design.
+const (
+	compareSame      = "same"
+	compareDifferent = "different"
+	compareMissing   = "missing"
+	compareExtra     = "extra"
+)
+
+type ProjectComparison struct {
+	Left      summaryProject   `json:"left"`
+	Right     summaryProject   `json:"right"`
+	LeftSite  string           `json:"left_site,omitempty"`
+	RightSite string           `json:"right_site,omitempty"`
+	Sites     []SiteComparison `json:"sites"`
+	Counts    map[string]int   `json:"counts"`
+}
+
+type SiteComparison struct {
+	Name      string              `json:"name"`
+	RightName string              `json:"right_name,omitempty"`
+	Status    string              `json:"status"`
+	Segments  []SegmentComparison `json:"segments"`
+}
+
+type SegmentComparison struct {
+	Name   string          `json:"name"`
+	Status string          `json:"status"`
+	Diffs  []string        `json:"diffs,omitempty"`
+	Left   *compareSegment `json:"left,omitempty"`
+	Right  *compareSegment `json:"right,omitempty"`
+}
+
+// compareSegment is the structure of a segment that a comparison looks at;
+// addresses differ between sites by design and are left out.
+type compareSegment struct {
+	VRF     string `json:"vrf"`
+	VLAN    int    `json:"vlan"`
+	Size    string `json:"size,omitempty"`
+	SizeV6  string `json:"size_v6,omitempty"`
+	Request string `json:"request,omitempty"`
+	Kind    string `json:"kind"`
+	name    string
+	diffs   []string
+}
+
+func (s SiteComparison) Differences() int {
+	n := 0
+	for _, seg := range s.Segments {
+		if seg.Status != compareSame {
+			n++
+		}
+	}
+	return n
+}
+
+// Mark is the class of a field that differs from the other side.
+func (s *compareSegment) Mark(field string) string {
+	for _, d := range s.diffs {
+		if d == field {
+			return "fw-bold"
+		}
+	}
+	return ""
+}
+
+func (s *compareSegment) LAN() bool {
+	return s.Kind == SegmentKindLAN
+}
+
+func newCompareSegment(s Segment) *compareSegment {
+	out := &compareSegment{VRF: s.VRF, VLAN: s.VLAN, Kind: normalizeSegmentKind(s.Kind), name: s.Name}
+	if p := desiredPrefix(s); p > 0 {
+		out.Size = fmt.Sprintf("/%d", p)
+	}
+	if p := desiredPrefixByFamily(s, "ipv6"); p > 0 {
+		out.SizeV6 = fmt.Sprintf("/%d", p)
+	}
+	switch {
+	case s.Hosts.Valid:
+		out.Request = fmt.Sprintf("%d hosts", s.Hosts.Int64)
+	case s.Prefix.Valid:
+		out.Request = fmt.Sprintf("/%d", s.Prefix.Int64)
+	}
+	return out
+}
+
+func compareSegmentDiffs(a, b *compareSegment) []string {
+	var out []string
+	if !strings.EqualFold(a.VRF, b.VRF) {
+		out = append(out, "vrf")
+	}
+	if a.VLAN != b.VLAN {
+		out = append(out, "vlan")
+	}
+	if a.Size != b.Size {
+		out = append(out, "size")
+	}
+	if a.SizeV6 != b.SizeV6 {
+		out = append(out, "size_v6")
+	}
+	if a.Kind != b.Kind {
+		out = append(out, "kind")
+	}
+	return out
+}
+
+// compareSiteSegments pairs the segments of two sites by name.
+func compareSiteSegments(left, right []Segment) []SegmentComparison {
+	byName := func(segs []Segment) (map[string][]*compareSegment, []string) {
+		out := map[string][]*compareSegment{}
+		var order []string
+		for _, s := range segs {
+			key := strings.ToLower(strings.TrimSpace(s.Name))
+			if _, ok := out[key]; !ok {
+				order = append(order, key)
+			}
+			out[key] = append(out[key], newCompareSegment(s))
+		}
+		return out, order
+	}
+	leftBy, leftOrder := byName(left)
+	rightBy, rightOrder := byName(right)
+
+	var out []SegmentComparison
+	pair := func(key string) {
+		ls, rs := leftBy[key], rightBy[key]
+		used := make([]bool, len(rs))
+		var unmatched []*compareSegment
+		for _, l := range ls {
+			match := -1
+			for i, r := range rs {
+				if !used[i] && strings.EqualFold(l.VRF, r.VRF) {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				unmatched = append(unmatched, l)
+				continue
+			}
+			used[match] = true
+			out = append(out, pairedSegment(l, rs[match]))
+		}
+		for _, l := range unmatched {
+			match := -1
+			for i := range rs {
+				if !used[i] {
+					match = i
+					break
+				}
+			}
+			if match < 0 {
+				out = append(out, SegmentComparison{Name: l.name, Status: compareMissing, Left: l})
+				continue
+			}
+			used[match] = true
+			out = append(out, pairedSegment(l, rs[match]))
+		}
+		for i, r := range rs {
+			if !used[i] {
+				out = append(out, SegmentComparison{Name: r.name, Status: compareExtra, Right: r})
+			}
+		}
+	}
+	for _, key := range leftOrder {
+		pair(key)
+	}
+	for _, key := range rightOrder {
+		if _, ok := leftBy[key]; !ok {
+			pair(key)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.vlan() != b.vlan() {
+			return a.vlan() < b.vlan()
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+	return out
+}
+
+func pairedSegment(l, r *compareSegment) SegmentComparison {
+	out := SegmentComparison{Name: l.name, Status: compareSame, Left: l, Right: r}
+	if out.Diffs = compareSegmentDiffs(l, r); len(out.Diffs) > 0 {
+		out.Status = compareDifferent
+		l.diffs, r.diffs = out.Diffs, out.Diffs
+	}
+	return out
+}
+
+func (s SegmentComparison) vlan() int {
+	if s.Left != nil {
+		return s.Left.VLAN
+	}
+	return s.Right.VLAN
+}
+
+// buildProjectComparison compares left with right.
+func buildProjectComparison(db *sql.DB, left, right Project, leftSite, rightSite string) (ProjectComparison, error) {
+	out := ProjectComparison{
+		Left:      summaryProject{ID: left.ID, Name: left.Name, Archived: left.Archived, Sandbox: left.Sandbox},
+		Right:     summaryProject{ID: right.ID, Name: right.Name, Archived: right.Archived, Sandbox: right.Sandbox},
+		LeftSite:  strings.TrimSpace(leftSite),
+		RightSite: strings.TrimSpace(rightSite),
+		Sites:     []SiteComparison{},
+		Counts:    map[string]int{compareSame: 0, compareDifferent: 0, compareMissing: 0, compareExtra: 0},
+	}
+	if (out.LeftSite == "") != (out.RightSite == "") {
+		return out, fmt.Errorf("name both sites to compare sites")
+	}
+	load := func(p Project) ([]Site, map[string][]Segment, error) {
+		sites, err := listSites(db, p.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		segs, err := listSegments(db, p.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		bySite := map[string][]Segment{}
+		for _, s := range segs {
+			key := strings.ToLower(s.Site)
+			bySite[key] = append(bySite[key], s)
+		}
+		return sites, bySite, nil
+	}
+	leftSites, leftSegs, err := load(left)
+	if err != nil {
+		return out, err
+	}
+	rightSites, rightSegs, err := load(right)
+	if err != nil {
+		return out, err
+	}
+
+	var sites []SiteComparison
+	if out.LeftSite != "" {
+		l, lok := findSiteName(leftSites, out.LeftSite)
+		r, rok := findSiteName(rightSites, out.RightSite)
+		if !lok {
+			return out, fmt.Errorf("site %q not found in project %s", out.LeftSite, left.Name)
+		}
+		if !rok {
+			return out, fmt.Errorf("site %q not found in project %s", out.RightSite, right.Name)
+		}
+		sites = append(sites, SiteComparison{
+			Name:      l,
+			RightName: r,
+			Status:    compareSame,
+			Segments:  compareSiteSegments(leftSegs[strings.ToLower(l)], rightSegs[strings.ToLower(r)]),
+		})
+	} else {
+		rightNames := map[string]string{}
+		for _, s := range rightSites {
+			rightNames[strings.ToLower(s.Name)] = s.Name
+		}
+		seen := map[string]bool{}
+		for _, s := range leftSites {
+			key := strings.ToLower(s.Name)
+			seen[key] = true
+			site := SiteComparison{Name: s.Name, Status: compareSame}
+			if r, ok := rightNames[key]; ok {
+				site.RightName = r
+			} else {
+				site.Status = compareMissing
+			}
+			site.Segments = compareSiteSegments(leftSegs[key], rightSegs[key])
+			sites = append(sites, site)
+		}
+		for _, s := range rightSites {
+			key := strings.ToLower(s.Name)
+			if seen[key] {
+				continue
+			}
+			sites = append(sites, SiteComparison{
+				Name:      s.Name,
+				RightName: s.Name,
+				Status:    compareExtra,
+				Segments:  compareSiteSegments(nil, rightSegs[key]),
+			})
+		}
+	}
+	for i, site := range sites {
+		if site.Status == compareSame && site.Differences() > 0 {
+			sites[i].Status = compareDifferent
+		}
+		for _, seg := range site.Segments {
+			out.Counts[seg.Status]++
+		}
+	}
+	out.Sites = sites
+	return out, nil
+}
+
+func findSiteName(sites []Site, name string) (string, bool) {
+	for _, s := range sites {
+		if strings.EqualFold(s.Name, strings.TrimSpace(name)) {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
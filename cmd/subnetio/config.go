@@ -0,0 +1,371 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are read by environment variable name through mustEnv.
+const defaultConfigEnv = "CONFIG_FILE"
+
+type configKind int
+
+const (
+	configString configKind = iota
+	configPath
+	configAddr
+	configDuration
+	configInt
+	configBool
+	configList
+	configURL
+	configSecret
+)
+
+// configSetting maps a dotted config file key to its environment variable.
+type configSetting struct {
+	Key  string
+	Env  string
+	Kind configKind
+}
+
+var configSettings = []configSetting{
+	{"database.path", "DB_PATH", configPath},
+	{"database.maintenance_interval", "DB_MAINTENANCE_INTERVAL", configDuration},
+	{"server.listen_addr", "LISTEN_ADDR", configAddr},
+	{"server.base_path", "BASE_PATH", configString},
+	{"server.trusted_proxies", "TRUSTED_PROXIES", configList},
+	{"server.idempotency_ttl", "IDEMPOTENCY_TTL", configDuration},
+	{"sandbox.idle_ttl", "SANDBOX_IDLE_TTL", configDuration},
+	{"hooks.pre_allocate", "ALLOCATION_HOOK_PRE", configString},
+	{"hooks.post_allocate", "ALLOCATION_HOOK_POST", configString},
+	{"hooks.timeout", "ALLOCATION_HOOK_TIMEOUT", configDuration},
+	{"templates.dir", "TEMPLATE_DIR", configPath},
+	{"tls.cert_file", "TLS_CERT_FILE", configPath},
+	{"tls.key_file", "TLS_KEY_FILE", configPath},
+	{"tls.acme_domains", "ACME_DOMAINS", configList},
+	{"tls.acme_email", "ACME_EMAIL", configString},
+	{"tls.acme_cache_dir", "ACME_CACHE_DIR", configPath},
+	{"tls.http_redirect_addr", "HTTP_REDIRECT_ADDR", configAddr},
+	{"tls.hsts_max_age", "HSTS_MAX_AGE", configInt},
+	{"secrets.backend", "SECRETS_BACKEND", configString},
+	{"secrets.env_prefix", "SECRETS_ENV_PREFIX", configString},
+	{"secrets.dir", "SECRETS_DIR", configPath},
+	{"secrets.vault.addr", "VAULT_ADDR", configURL},
+	{"secrets.vault.token", "VAULT_TOKEN", configSecret},
+	{"secrets.vault.token_file", "VAULT_TOKEN_FILE", configPath},
+	{"secrets.vault.cache_ttl", "VAULT_CACHE_TTL", configDuration},
+	{"secrets.vault.namespace", "VAULT_NAMESPACE", configString},
+	{"secrets.vault.kv_mount", "VAULT_KV_MOUNT", configString},
+	{"rdap.base_url", "RDAP_BASE_URL", configURL},
+	{"rdap.org", "RDAP_ORG", configList},
+	{"phpipam.url", "PHPIPAM_URL", configURL},
+	{"phpipam.app_id", "PHPIPAM_APP_ID", configString},
+	{"phpipam.token", "PHPIPAM_TOKEN", configString},
+	{"chatops.signing_secret", "CHATOPS_SIGNING_SECRET", configSecret},
+	{"chatops.token", "CHATOPS_TOKEN", configSecret},
+	{"notify.smtp_addr", "SMTP_ADDR", configAddr},
+	{"notify.smtp_from", "SMTP_FROM", configString},
+	{"notify.smtp_username", "SMTP_USERNAME", configString},
+	{"notify.smtp_password", "SMTP_PASSWORD", configSecret},
+	{"macs.oui_file", "OUI_FILE", configPath},
+	{"maintenance.enabled", "MAINTENANCE_MODE", configBool},
+	{"maintenance.message", "MAINTENANCE_MESSAGE", configString},
+}
+
+// configValue is a setting read from the config file, with its line for
+// error messages.
+type configValue struct {
+	Value string
+	Line  int
+}
+
+var fileConfig = struct {
+	Path   string
+	Values map[string]configValue
+}{Values: map[string]configValue{}}
+
+// lookupSetting returns the value of an environment variable, falling back to
+// the config file.
+func lookupSetting(env string) (string, string) {
+	if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+		return v, "env"
+	}
+	if v, ok := fileConfig.Values[env]; ok && v.Value != "" {
+		return v.Value, "file"
+	}
+	return "", ""
+}
+
+func configSettingByKey(key string) (configSetting, bool) {
+	for _, s := range configSettings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return configSetting{}, false
+}
+
+// parseConfigFile reads a YAML config file into values keyed by environment
+// variable.
+func parseConfigFile(path string, raw []byte) (map[string]configValue, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	out := map[string]configValue{}
+	if len(doc.Content) == 0 {
+		return out, nil
+	}
+	var errs []error
+	var walk func(prefix string, node *yaml.Node)
+	walk = func(prefix string, node *yaml.Node) {
+		if node.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Errorf("%s:%d: %s must be a mapping", path, node.Line, configKeyLabel(prefix)))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			key := keyNode.Value
+			if prefix != "" {
+				key = prefix + "." + keyNode.Value
+			}
+			setting, ok := configSettingByKey(key)
+			if !ok {
+				if configSectionKnown(key) {
+					walk(key, valueNode)
+					continue
+				}
+				msg := fmt.Sprintf("%s:%d: unknown setting %s", path, keyNode.Line, key)
+				if hint := closestConfigKey(key); hint != "" {
+					msg += " (did you mean " + hint + "?)"
+				}
+				errs = append(errs, errors.New(msg))
+				continue
+			}
+			value, err := configNodeValue(setting, valueNode)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %s: %v", path, valueNode.Line, key, err))
+				continue
+			}
+			out[setting.Env] = configValue{Value: value, Line: valueNode.Line}
+		}
+	}
+	walk("", doc.Content[0])
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+func configKeyLabel(key string) string {
+	if key == "" {
+		return "the config file"
+	}
+	return key
+}
+
+func configSectionKnown(prefix string) bool {
+	for _, s := range configSettings {
+		if strings.HasPrefix(s.Key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configNodeValue flattens a scalar, or a list for list settings, to the
+// string the environment variable would hold.
+func configNodeValue(setting configSetting, node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return strings.TrimSpace(node.Value), nil
+	case yaml.SequenceNode:
+		if setting.Kind != configList {
+			return "", errors.New("expected a single value, not a list")
+		}
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", errors.New("list items must be plain values")
+			}
+			items = append(items, strings.TrimSpace(item.Value))
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", errors.New("expected a value")
+}
+
+// closestConfigKey suggests the known key with the smallest edit distance,
+// if it is close enough to be a typo.
+func closestConfigKey(key string) string {
+	best, bestDist := "", 4
+	for _, s := range configSettings {
+		if d := editDistance(key, s.Key); d < bestDist {
+			best, bestDist = s.Key, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// validateSetting checks one effective value against its kind.
+func validateSetting(setting configSetting, value string) error {
+	switch setting.Kind {
+	case configAddr:
+		if _, port, err := net.SplitHostPort(value); err != nil {
+			return fmt.Errorf("invalid address %q (use host:port)", value)
+		} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			return fmt.Errorf("invalid port in %q", value)
+		}
+	case configDuration:
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid duration %q (e.g. 30s, 5m, 24h)", value)
+		}
+	case configInt:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("invalid number %q", value)
+		}
+	case configBool:
+		if _, ok := parseStrictBool(value); !ok {
+			return fmt.Errorf("invalid boolean %q (use true or false)", value)
+		}
+	case configURL:
+		if !strings.HasPrefix(value, "http://") && !strings.HasPrefix(value, "https://") {
+			return fmt.Errorf("invalid URL %q (must start with http:// or https://)", value)
+		}
+	}
+	if (setting.Env == "ALLOCATION_HOOK_PRE" || setting.Env == "ALLOCATION_HOOK_POST") && !isHookURL(value) {
+		if _, err := hookCommand(value); err != nil {
+			return err
+		}
+	}
+	if setting.Env == "SECRETS_BACKEND" {
+		switch strings.ToLower(value) {
+		case "env", "file", "vault":
+		default:
+			return fmt.Errorf("unknown backend %q (env, file, vault)", value)
+		}
+	}
+	return nil
+}
+
+// validateSettings checks every effective setting, from the environment or
+// the config file, and names where a bad value came from.
+func validateSettings() error {
+	var errs []error
+	for _, setting := range configSettings {
+		value, source := lookupSetting(setting.Env)
+		if value == "" {
+			continue
+		}
+		if err := validateSetting(setting, value); err != nil {
+			where := "environment variable " + setting.Env
+			if source == "file" {
+				where = fmt.Sprintf("%s:%d: %s", fileConfig.Path, fileConfig.Values[setting.Env].Line, setting.Key)
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", where, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadConfig reads the config file, if one is given, and validates the
+// resulting settings.
+func loadConfig(path string) error {
+	if path == "" {
+		path = strings.TrimSpace(os.Getenv(defaultConfigEnv))
+	}
+	fileConfig.Path = path
+	fileConfig.Values = map[string]configValue{}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+		values, err := parseConfigFile(path, raw)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+		fileConfig.Values = values
+	}
+	if err := validateSettings(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	return nil
+}
+
+// splitConfigFlag removes a leading --config FILE (or --config=FILE) from
+// the command line.
+func splitConfigFlag(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", args, nil
+	}
+	for _, name := range []string{"--config", "-config"} {
+		if args[0] == name {
+			if len(args) < 2 {
+				return "", nil, fmt.Errorf("%s needs a file", name)
+			}
+			return args[1], args[2:], nil
+		}
+		if v, ok := strings.CutPrefix(args[0], name+"="); ok {
+			return v, args[1:], nil
+		}
+	}
+	return "", args, nil
+}
+
+// writeEffectiveConfig lists every setting with its value and source for
+// `subnetio config`.
+func writeEffectiveConfig(out io.Writer) {
+	if fileConfig.Path != "" {
+		fmt.Fprintf(out, "# config file: %s\n", fileConfig.Path)
+	}
+	settings := make([]configSetting, len(configSettings))
+	copy(settings, configSettings)
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	for _, s := range settings {
+		value, source := lookupSetting(s.Env)
+		if source == "" {
+			fmt.Fprintf(out, "%-28s %-22s (default)\n", s.Key, s.Env)
+			continue
+		}
+		if s.Kind == configSecret {
+			value = "********"
+		}
+		fmt.Fprintf(out, "%-28s %-22s %s (%s)\n", s.Key, s.Env, value, source)
+	}
+}
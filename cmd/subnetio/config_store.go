@@ -0,0 +1,358 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	configBlobGzipMin  = 1024
+	maxConfigRetention = 10000
+)
+
+// putConfigBlob stores content unless a blob with the same hash exists and
+// returns the hash.
+func putConfigBlob(db sqlConn, content string) (string, error) {
+	hash := checksumSHA256(content)
+	data, encoding := []byte(content), "identity"
+	if len(data) >= configBlobGzipMin {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		if buf.Len() < len(data) {
+			data, encoding = buf.Bytes(), "gzip"
+		}
+	}
+	_, err := db.Exec(`
+		INSERT INTO config_blobs(hash, size, stored_size, encoding, data, created_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(hash) DO NOTHING`,
+		hash, len(content), len(data), encoding, data, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+func decodeConfigBlob(data []byte, encoding string) (string, error) {
+	switch encoding {
+	case "", "identity":
+		return string(data), nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		out, err := io.ReadAll(zr)
+		return string(out), err
+	}
+	return "", fmt.Errorf("unknown config blob encoding %q", encoding)
+}
+
+// storedConfigContent returns the content of a row: the blob when the row
+// references one, else the inline content of rows written before blobs.
+func storedConfigContent(inline string, hash sql.NullString, data []byte, encoding sql.NullString) (string, error) {
+	if !hash.Valid || hash.String == "" {
+		return inline, nil
+	}
+	if data == nil {
+		return "", fmt.Errorf("config blob %s is missing", hash.String)
+	}
+	return decodeConfigBlob(data, encoding.String)
+}
+
+// gcConfigBlobs deletes blobs no generation or deployed config references.
+func gcConfigBlobs(db sqlConn) (int64, error) {
+	res, err := db.Exec(`
+		DELETE FROM config_blobs
+		WHERE hash NOT IN (SELECT content_hash FROM generations WHERE content_hash IS NOT NULL)
+		  AND hash NOT IN (SELECT content_hash FROM deployed_configs WHERE content_hash IS NOT NULL)`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// moveInlineConfigs moves content stored inline by older versions into blobs.
+func moveInlineConfigs(db *sql.DB) error {
+	for _, table := range []string{"generations", "deployed_configs"} {
+		for {
+			moved, err := moveInlineConfigBatch(db, table, 100)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			if moved == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func moveInlineConfigBatch(db *sql.DB, table string, limit int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rows, err := tx.Query(`SELECT rowid, content FROM `+table+` WHERE content_hash IS NULL LIMIT ?`, limit)
+	if err != nil {
+		return 0, err
+	}
+	type inline struct {
+		id      int64
+		content string
+	}
+	var list []inline
+	for rows.Next() {
+		var row inline
+		if err := rows.Scan(&row.id, &row.content); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, row := range list {
+		hash, err := putConfigBlob(tx, row.content)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE `+table+` SET content='', content_hash=? WHERE rowid=?`, hash, row.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(list), tx.Commit()
+}
+
+// ConfigRetention bounds the generation history of a project.
+type ConfigRetention struct {
+	ProjectID    int64
+	KeepPerScope int
+	MaxAgeDays   int
+	UpdatedAt    string
+}
+
+func (r ConfigRetention) Enabled() bool {
+	return r.KeepPerScope > 0 || r.MaxAgeDays > 0
+}
+
+func configRetention(db *sql.DB, projectID int64) (ConfigRetention, error) {
+	r := ConfigRetention{ProjectID: projectID}
+	err := db.QueryRow(`SELECT keep_per_scope, max_age_days, updated_at FROM config_retention WHERE project_id=?`, projectID).
+		Scan(&r.KeepPerScope, &r.MaxAgeDays, &r.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return r, nil
+	}
+	return r, err
+}
+
+func saveConfigRetention(db *sql.DB, r ConfigRetention) error {
+	if r.ProjectID <= 0 {
+		return fmt.Errorf("project is required")
+	}
+	if r.KeepPerScope < 0 || r.KeepPerScope > maxConfigRetention {
+		return fmt.Errorf("keep per scope must be between 0 and %d", maxConfigRetention)
+	}
+	if r.MaxAgeDays < 0 || r.MaxAgeDays > maxConfigRetention {
+		return fmt.Errorf("max age must be between 0 and %d days", maxConfigRetention)
+	}
+	_, err := db.Exec(`
+		INSERT INTO config_retention(project_id, keep_per_scope, max_age_days, updated_at)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET
+			keep_per_scope=excluded.keep_per_scope,
+			max_age_days=excluded.max_age_days,
+			updated_at=excluded.updated_at`,
+		r.ProjectID, r.KeepPerScope, r.MaxAgeDays, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// applyConfigRetention deletes the project's history entries outside its
+// retention and returns how many went.
+func applyConfigRetention(db *sql.DB, r ConfigRetention, now time.Time) (int64, error) {
+	if !r.Enabled() {
+		return 0, nil
+	}
+	cutoff := ""
+	if r.MaxAgeDays > 0 {
+		cutoff = now.UTC().AddDate(0, 0, -r.MaxAgeDays).Format(time.RFC3339)
+	}
+	res, err := db.Exec(`
+		DELETE FROM generations WHERE id IN (
+			SELECT id FROM (
+				SELECT id, created_at, ROW_NUMBER() OVER (PARTITION BY template, scope_key ORDER BY id DESC) AS n
+				FROM generations
+				WHERE project_id=?1 AND schedule_id IS NULL
+			)
+			WHERE (?2 > 0 AND n > ?2) OR (?3 <> '' AND n > 1 AND created_at < ?3)
+		)`, r.ProjectID, r.KeepPerScope, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// pruneConfigStorage applies every project's retention and collects
+// unreferenced blobs.
+func pruneConfigStorage(db *sql.DB, now time.Time) {
+	rows, err := db.Query(`SELECT project_id FROM config_retention WHERE keep_per_scope > 0 OR max_age_days > 0`)
+	if err != nil {
+		log.Printf("config retention: %v", err)
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		r, err := configRetention(db, id)
+		if err == nil {
+			_, err = applyConfigRetention(db, r, now)
+		}
+		if err != nil {
+			log.Printf("config retention (project %d): %v", id, err)
+		}
+	}
+	if _, err := gcConfigBlobs(db); err != nil {
+		log.Printf("config blobs: %v", err)
+	}
+}
+
+// ConfigStorageRow is one template and scope of a project's stored configs.
+type ConfigStorageRow struct {
+	Kind       string `json:"kind"`
+	Template   string `json:"template"`
+	ScopeKey   string `json:"scope"`
+	Entries    int    `json:"entries"`
+	Distinct   int    `json:"distinct"`
+	Logical    int64  `json:"logical_bytes"`
+	Stored     int64  `json:"stored_bytes"`
+	LatestAt   string `json:"latest_at"`
+	ScheduleID int64  `json:"schedule_id,omitempty"`
+}
+
+// ConfigStorageReport sums a project's stored configs.
+type ConfigStorageReport struct {
+	ProjectID    int64              `json:"project_id"`
+	Entries      int                `json:"entries"`
+	Distinct     int                `json:"distinct"`
+	Logical      int64              `json:"logical_bytes"`
+	Stored       int64              `json:"stored_bytes"`
+	Rows         []ConfigStorageRow `json:"scopes"`
+	Retention    ConfigRetention    `json:"-"`
+	AllBlobs     int                `json:"all_blobs"`
+	AllStored    int64              `json:"all_stored_bytes"`
+	Unreferenced int                `json:"unreferenced_blobs"`
+}
+
+// Saved is the share of the logical size not stored, in percent.
+func (r ConfigStorageReport) Saved() int {
+	if r.Logical <= 0 || r.Stored >= r.Logical {
+		return 0
+	}
+	return int((r.Logical - r.Stored) * 100 / r.Logical)
+}
+
+func configStorageReport(db *sql.DB, projectID int64) (ConfigStorageReport, error) {
+	report := ConfigStorageReport{ProjectID: projectID, Rows: []ConfigStorageRow{}}
+	rows, err := db.Query(`
+		WITH refs AS (
+			SELECT CASE WHEN g.schedule_id IS NULL THEN 'history' ELSE 'scheduled' END AS kind,
+				g.template, g.scope_key, COALESCE(g.schedule_id, 0) AS schedule_id, g.created_at AS at,
+				COALESCE(g.content_hash, 'inline:' || g.id) AS hash,
+				COALESCE(b.size, LENGTH(CAST(g.content AS BLOB))) AS size,
+				COALESCE(b.stored_size, LENGTH(CAST(g.content AS BLOB))) AS stored_size
+			FROM generations g LEFT JOIN config_blobs b ON b.hash=g.content_hash
+			WHERE g.project_id=?1
+			UNION ALL
+			SELECT 'deployed', d.template, d.scope_key, 0, d.updated_at,
+				COALESCE(d.content_hash, 'inline:d' || d.rowid),
+				COALESCE(b.size, LENGTH(CAST(d.content AS BLOB))),
+				COALESCE(b.stored_size, LENGTH(CAST(d.content AS BLOB)))
+			FROM deployed_configs d LEFT JOIN config_blobs b ON b.hash=d.content_hash
+			WHERE d.project_id=?1
+		)
+		SELECT kind, template, scope_key, schedule_id, COUNT(*), COUNT(DISTINCT hash), SUM(size),
+			(SELECT COALESCE(SUM(stored_size), 0) FROM (SELECT DISTINCT r2.hash, r2.stored_size FROM refs r2
+				WHERE r2.kind=refs.kind AND r2.template=refs.template AND r2.scope_key=refs.scope_key AND r2.schedule_id=refs.schedule_id)),
+			MAX(at)
+		FROM refs
+		GROUP BY kind, template, scope_key, schedule_id
+		ORDER BY template, scope_key, kind`, projectID)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var row ConfigStorageRow
+		if err := rows.Scan(&row.Kind, &row.Template, &row.ScopeKey, &row.ScheduleID, &row.Entries, &row.Distinct,
+			&row.Logical, &row.Stored, &row.LatestAt); err != nil {
+			rows.Close()
+			return report, err
+		}
+		report.Entries += row.Entries
+		report.Logical += row.Logical
+		report.Rows = append(report.Rows, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+	err = db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(b.stored_size), 0) FROM config_blobs b
+		WHERE b.hash IN (SELECT content_hash FROM generations WHERE project_id=?1)
+		   OR b.hash IN (SELECT content_hash FROM deployed_configs WHERE project_id=?1)`, projectID).
+		Scan(&report.Distinct, &report.Stored)
+	if err != nil {
+		return report, err
+	}
+	err = db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(stored_size), 0),
+			COALESCE(SUM(CASE WHEN hash NOT IN (SELECT content_hash FROM generations WHERE content_hash IS NOT NULL)
+				AND hash NOT IN (SELECT content_hash FROM deployed_configs WHERE content_hash IS NOT NULL) THEN 1 ELSE 0 END), 0)
+		FROM config_blobs`).Scan(&report.AllBlobs, &report.AllStored, &report.Unreferenced)
+	if err != nil {
+		return report, err
+	}
+	report.Retention, err = configRetention(db, projectID)
+	return report, err
+}
+
+func (r ConfigStorageRow) LogicalSize() string { return byteSize(r.Logical) }
+func (r ConfigStorageRow) StoredSize() string  { return byteSize(r.Stored) }
+
+func (r ConfigStorageReport) LogicalSize() string   { return byteSize(r.Logical) }
+func (r ConfigStorageReport) StoredSize() string    { return byteSize(r.Stored) }
+func (r ConfigStorageReport) AllStoredSize() string { return byteSize(r.AllStored) }
+
+// byteSize formats n as B, KiB or MiB for the storage page.
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) + " MiB"
+	case n >= 1<<10:
+		return strconv.FormatFloat(float64(n)/(1<<10), 'f', 1, 64) + " KiB"
+	}
+	return strconv.FormatInt(n, 10) + " B"
+}
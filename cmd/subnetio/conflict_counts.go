@@ -0,0 +1,97 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const conflictCountTTL = time.Minute
+
+// ConflictCounts is the number of conflicts and warnings the analysis
+// reports for a project, shown as a badge in the navigation.
+type ConflictCounts struct {
+	Conflicts int
+	Warnings  int
+}
+
+type conflictCountKey struct {
+	db        *sql.DB
+	projectID int64
+}
+
+type conflictCountEntry struct {
+	counts ConflictCounts
+	at     time.Time
+}
+
+var conflictCountCache = struct {
+	sync.Mutex
+	entries map[conflictCountKey]conflictCountEntry
+}{entries: map[conflictCountKey]conflictCountEntry{}}
+
+func countConflicts(conflicts []Conflict) ConflictCounts {
+	var out ConflictCounts
+	for _, c := range conflicts {
+		switch c.Level {
+		case statusConflict.Label():
+			out.Conflicts++
+		case statusWarning.Label():
+			out.Warnings++
+		}
+	}
+	return out
+}
+
+// projectConflictCounts returns the cached counts for the project, running
+// the analysis when there are none or they are stale.
+func projectConflictCounts(db *sql.DB, projectID int64) ConflictCounts {
+	if projectID <= 0 {
+		return ConflictCounts{}
+	}
+	key := conflictCountKey{db: db, projectID: projectID}
+	conflictCountCache.Lock()
+	entry, ok := conflictCountCache.entries[key]
+	conflictCountCache.Unlock()
+	if ok && time.Since(entry.at) < conflictCountTTL {
+		return entry.counts
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return ConflictCounts{}
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return ConflictCounts{}
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return ConflictCounts{}
+	}
+	rules, _ := getProjectRules(db, projectID)
+	_, conflicts := analyzeAll(segs, pools, sites, rules)
+	return storeConflictCounts(db, projectID, conflicts)
+}
+
+// storeConflictCounts caches the counts of an analysis a page has already
+// run, so the badge matches what the page shows.
+func storeConflictCounts(db *sql.DB, projectID int64, conflicts []Conflict) ConflictCounts {
+	counts := countConflicts(conflicts)
+	conflictCountCache.Lock()
+	conflictCountCache.entries[conflictCountKey{db: db, projectID: projectID}] = conflictCountEntry{counts: counts, at: time.Now()}
+	conflictCountCache.Unlock()
+	return counts
+}
+
+// invalidateConflictCounts with projectID 0 drops every project's counts.
+func invalidateConflictCounts(db *sql.DB, projectID int64) {
+	conflictCountCache.Lock()
+	defer conflictCountCache.Unlock()
+	for key := range conflictCountCache.entries {
+		if key.db == db && (projectID == 0 || key.projectID == projectID) {
+			delete(conflictCountCache.entries, key)
+		}
+	}
+}
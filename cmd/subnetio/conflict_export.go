@@ -0,0 +1,119 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ID identifies a conflict across validator runs.
+func (c Conflict) ID() string {
+	key := c.key
+	if key == "" {
+		key = strings.TrimSpace(c.Detail)
+	}
+	sum := sha256.Sum256([]byte(c.Kind + "\x00" + key))
+	return "C-" + hex.EncodeToString(sum[:6])
+}
+
+// Conflict keys use names and addresses rather than row IDs, so the same
+// problem has the same ID in every copy of a plan.
+
+// segmentConflictKey identifies segments by site, VRF, VLAN and CIDR; a
+// segment without an address yet is identified by its name.
+func segmentConflictKey(segs ...Segment) string {
+	parts := make([]string, len(segs))
+	for i, s := range segs {
+		addr := s.CIDR.String
+		if addr == "" {
+			addr = s.CIDRV6.String
+		}
+		if addr == "" {
+			addr = "name:" + s.Name
+		}
+		parts[i] = s.Site + "/" + s.VRF + "/" + itoa(s.VLAN) + "/" + addr
+	}
+	sort.Strings(parts)
+	return "segment=" + strings.Join(parts, ",")
+}
+
+func siteConflictKey(site string) string {
+	return "site=" + site
+}
+
+func poolConflictKey(site string, pool netip.Prefix) string {
+	return siteConflictKey(site) + " pool=" + pool.String()
+}
+
+// sortConflicts orders conflicts by kind and ID; the validator itself walks
+// maps and does not guarantee an order.
+func sortConflicts(conflicts []Conflict) []Conflict {
+	out := append([]Conflict(nil), conflicts...)
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].ID() < out[j].ID()
+	})
+	return out
+}
+
+func projectConflicts(db *sql.DB, projectID int64) ([]ExportConflict, error) {
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	rules, _ := getProjectRules(db, projectID)
+	_, conflicts := analyzeAll(segs, pools, sites, rules)
+	return exportConflicts(conflicts), nil
+}
+
+func exportConflictsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
+	rows, err := projectConflicts(db, projectID)
+	if err != nil {
+		return err
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=subnetio_conflicts.csv")
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write([]string{"id", "severity", "kind", "detail"}); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		_ = w.Write([]string{row.ID, row.Level, row.Kind, row.Detail})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func exportConflictsJSON(c *gin.Context, db *sql.DB, projectID int64) error {
+	rows, err := projectConflicts(db, projectID)
+	if err != nil {
+		return err
+	}
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=subnetio_conflicts.json")
+	out, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	c.String(200, string(out))
+	return nil
+}
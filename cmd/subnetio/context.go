@@ -13,9 +13,13 @@ func baseData(c *gin.Context, db *sql.DB, defaultProjectID int64) (gin.H, int64)
 	activeProjectID := resolveActiveProjectID(c, db, defaultProjectID)
 	projects, _ := listProjects(db)
 	activeName := "Default"
+	activeArchived := false
+	activeSandbox := false
 	for _, p := range projects {
 		if p.ID == activeProjectID {
 			activeName = p.Name
+			activeArchived = p.Archived
+			activeSandbox = p.Sandbox
 			break
 		}
 	}
@@ -23,7 +27,16 @@ func baseData(c *gin.Context, db *sql.DB, defaultProjectID int64) (gin.H, int64)
 		"Projects":          projects,
 		"ActiveProjectID":   activeProjectID,
 		"ActiveProjectName": activeName,
+		"ActiveArchived":    activeArchived,
+		"ActiveSandbox":     activeSandbox,
 		"CurrentPath":       c.Request.URL.Path,
+		"Maintenance":       getMaintenance(db),
+		"ConflictCounts":    projectConflictCounts(db, activeProjectID),
+	}
+	if activeSandbox {
+		if last, err := sandboxLastActivity(db, activeProjectID); err == nil && !last.IsZero() {
+			data["SandboxPurgeAt"] = last.Add(sandboxIdleTTL()).UTC().Format("2006-01-02 15:04 UTC")
+		}
 	}
 	return data, activeProjectID
 }
@@ -31,7 +44,7 @@ func baseData(c *gin.Context, db *sql.DB, defaultProjectID int64) (gin.H, int64)
 func resolveActiveProjectID(c *gin.Context, db *sql.DB, defaultProjectID int64) int64 {
 	if id := parseProjectID(c.Query("project_id")); id > 0 {
 		if projectExists(db, id) {
-			c.SetCookie("active_project_id", itoa64(id), 3600*24*365, "/", "", false, true)
+			c.SetCookie("active_project_id", itoa64(id), 3600*24*365, cookiePath(), "", false, true)
 			return id
 		}
 	}
@@ -59,7 +72,7 @@ func parseProjectID(raw string) int64 {
 	return id
 }
 
-func projectExists(db *sql.DB, id int64) bool {
+func projectExists(db sqlConn, id int64) bool {
 	if id <= 0 {
 		return false
 	}
@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// CSVOptions chooses how a CSV file is read.
+type CSVOptions struct {
+	Delimiter rune
+	Encoding  string
+}
+
+// csvEncodings are the encodings that can be chosen, by canonical name.
+var csvEncodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"windows-1250": charmap.Windows1250,
+	"windows-1251": charmap.Windows1251,
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-2":   charmap.ISO8859_2,
+	"iso-8859-5":   charmap.ISO8859_5,
+	"iso-8859-15":  charmap.ISO8859_15,
+	"koi8-r":       charmap.KOI8R,
+	"cp866":        charmap.CodePage866,
+}
+
+var csvEncodingAliases = map[string]string{
+	"utf8": "utf-8", "utf-16": "utf-16le", "utf16": "utf-16le", "unicode": "utf-16le",
+	"cp1250": "windows-1250", "cp1251": "windows-1251", "cp1252": "windows-1252",
+	"latin1": "iso-8859-1", "latin-1": "iso-8859-1", "latin9": "iso-8859-15", "koi8r": "koi8-r", "ibm866": "cp866",
+}
+
+func csvEncodingNames() []string {
+	var names []string
+	for name := range csvEncodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCSVOptions reads a delimiter (",", ";", "tab", "|" or "auto") and an
+// encoding name (or "auto").
+func parseCSVOptions(delimiter, enc string) (CSVOptions, error) {
+	var opts CSVOptions
+	switch d := strings.ToLower(strings.TrimSpace(delimiter)); d {
+	case "", "auto":
+	case ",", "comma":
+		opts.Delimiter = ','
+	case ";", "semicolon":
+		opts.Delimiter = ';'
+	case "tab", "\\t":
+		opts.Delimiter = '\t'
+	case "|", "pipe":
+		opts.Delimiter = '|'
+	default:
+		return opts, fmt.Errorf("unsupported CSV delimiter %q (use comma, semicolon, tab, pipe or auto)", delimiter)
+	}
+	name := strings.ToLower(strings.TrimSpace(enc))
+	if alias, ok := csvEncodingAliases[name]; ok {
+		name = alias
+	}
+	switch {
+	case name == "" || name == "auto":
+	case csvEncodings[name] != nil:
+		opts.Encoding = name
+	default:
+		return opts, fmt.Errorf("unsupported CSV encoding %q (use auto or one of %s)", enc, strings.Join(csvEncodingNames(), ", "))
+	}
+	return opts, nil
+}
+
+func csvOptionsFromForm(c *gin.Context) (CSVOptions, error) {
+	return parseCSVOptions(c.PostForm("csv_delimiter"), c.PostForm("csv_encoding"))
+}
+
+// DelimiterName is the delimiter as parseCSVOptions accepts it.
+func (o CSVOptions) DelimiterName() string {
+	switch o.Delimiter {
+	case ',':
+		return "comma"
+	case ';':
+		return "semicolon"
+	case '\t':
+		return "tab"
+	case '|':
+		return "pipe"
+	}
+	return ""
+}
+
+// String describes the options as used, e.g. "windows-1251, semicolon".
+func (o CSVOptions) String() string {
+	return o.Encoding + ", " + o.DelimiterName()
+}
+
+// detectCSVEncoding guesses the encoding of the start of a file.
+func detectCSVEncoding(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+	if len(head) >= 4 {
+		var even, odd int
+		for i, b := range head {
+			if b == 0 {
+				if i%2 == 0 {
+					even++
+				} else {
+					odd++
+				}
+			}
+		}
+		if odd > len(head)/4 && even*8 < odd {
+			return "utf-16le"
+		}
+		if even > len(head)/4 && odd*8 < even {
+			return "utf-16be"
+		}
+	}
+	valid := head
+	for i := 0; i < utf8.UTFMax && len(valid) > 0 && !utf8.Valid(valid); i++ {
+		valid = valid[:len(valid)-1] // a rune cut off at the end of the sample
+	}
+	if utf8.Valid(valid) {
+		return "utf-8"
+	}
+	var runs, single int
+	for i := 0; i < len(head); i++ {
+		if head[i] < 0xC0 {
+			continue
+		}
+		j := i
+		for j < len(head) && head[j] >= 0xC0 {
+			j++
+		}
+		if j-i > 1 {
+			runs += j - i
+		} else {
+			single++
+		}
+		i = j
+	}
+	if runs > single {
+		return "windows-1251"
+	}
+	return "windows-1252"
+}
+
+// detectCSVDelimiter picks the separator that occurs most often outside
+// quotes in the first line; a tie keeps the comma.
+func detectCSVDelimiter(line string) rune {
+	counts := map[rune]int{}
+	quoted := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == ',' || r == ';' || r == '\t' || r == '|'):
+			counts[r]++
+		}
+	}
+	best := ','
+	for _, r := range []rune{';', '\t', '|'} {
+		if counts[r] > counts[best] {
+			best = r
+		}
+	}
+	return best
+}
+
+// csvSniffSize is how much of a file is looked at to detect its format.
+const csvSniffSize = 64 * 1024
+
+// newCSVReader returns a csv.Reader over r converted to UTF-8, with the BOM
+// removed, and the options actually used.
+func newCSVReader(r io.Reader, opts CSVOptions) (*csv.Reader, CSVOptions, error) {
+	raw := bufio.NewReaderSize(r, csvSniffSize)
+	head, err := raw.Peek(csvSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, opts, err
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = detectCSVEncoding(head)
+	}
+	enc := csvEncodings[opts.Encoding]
+	if enc == nil {
+		return nil, opts, fmt.Errorf("unsupported CSV encoding %q", opts.Encoding)
+	}
+	decoded := bufio.NewReaderSize(transform.NewReader(raw, unicode.BOMOverride(enc.NewDecoder())), csvSniffSize)
+	if opts.Delimiter == 0 {
+		text, err := decoded.Peek(csvSniffSize)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, opts, err
+		}
+		line, _, _ := strings.Cut(string(text), "\n")
+		opts.Delimiter = detectCSVDelimiter(line)
+	}
+	reader := csv.NewReader(decoded)
+	reader.Comma = opts.Delimiter
+	return reader, opts, nil
+}
+
+// decodeCSVText converts a whole CSV file to UTF-8 text, for imports that
+// keep the file in memory.
+func decodeCSVText(raw []byte, opts CSVOptions) (string, CSVOptions, error) {
+	if opts.Encoding == "" {
+		opts.Encoding = detectCSVEncoding(raw[:min(len(raw), csvSniffSize)])
+	}
+	enc := csvEncodings[opts.Encoding]
+	if enc == nil {
+		return "", opts, fmt.Errorf("unsupported CSV encoding %q", opts.Encoding)
+	}
+	out, _, err := transform.Bytes(unicode.BOMOverride(enc.NewDecoder()), raw)
+	if err != nil {
+		return "", opts, fmt.Errorf("decode %s: %w", opts.Encoding, err)
+	}
+	return string(out), opts, nil
+}
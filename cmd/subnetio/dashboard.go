@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+)
+
+const dashboardRecentLimit = 20
+
+// DashboardProject is one row of the landing page.
+type DashboardProject struct {
+	ProjectSummary
+	LastChange *DashboardEvent
+	Health     *HealthPoint
+}
+
+// DashboardEvent is an audit entry with its project name resolved.
+type DashboardEvent struct {
+	At          string
+	Actor       string
+	Action      string
+	EntityType  string
+	EntityLabel string
+	ProjectID   int64
+	Project     string
+}
+
+type Dashboard struct {
+	Projects  []DashboardProject
+	Recent    []DashboardEvent
+	Segments  int
+	Conflicts int
+}
+
+// buildDashboard summarizes every project; archived ones go last and
+// sandboxes after them, left out of the totals and recent changes.
+func buildDashboard(db *sql.DB) (Dashboard, error) {
+	projects, err := listProjects(db)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	health, err := latestProjectHealth(db)
+	if err != nil {
+		return Dashboard{}, err
+	}
+	var out Dashboard
+	var archived, sandboxes []DashboardProject
+	for _, p := range projects {
+		summary, err := buildProjectSummary(db, p)
+		if err != nil {
+			return Dashboard{}, err
+		}
+		row := DashboardProject{ProjectSummary: summary}
+		if h, ok := health[p.ID]; ok {
+			row.Health = &h
+		}
+		if row.LastChange, err = lastProjectChange(db, p.ID); err != nil {
+			return Dashboard{}, err
+		}
+		if p.Sandbox {
+			sandboxes = append(sandboxes, row)
+			continue
+		}
+		out.Segments += summary.Segments.Total
+		out.Conflicts += summary.Conflicts.Total
+		if p.Archived {
+			archived = append(archived, row)
+			continue
+		}
+		out.Projects = append(out.Projects, row)
+	}
+	out.Projects = append(out.Projects, archived...)
+	out.Projects = append(out.Projects, sandboxes...)
+	if out.Recent, err = recentAuditEvents(db, dashboardRecentLimit); err != nil {
+		return Dashboard{}, err
+	}
+	return out, nil
+}
+
+const dashboardEventQuery = `
+	SELECT a.created_at, a.actor, a.action, a.entity_type, COALESCE(a.entity_label, ''),
+		COALESCE(a.project_id, 0), COALESCE(p.name, '')
+	FROM audit_log a
+	LEFT JOIN projects p ON p.id = a.project_id`
+
+func scanDashboardEvent(row interface{ Scan(...any) error }) (DashboardEvent, error) {
+	var ev DashboardEvent
+	err := row.Scan(&ev.At, &ev.Actor, &ev.Action, &ev.EntityType, &ev.EntityLabel, &ev.ProjectID, &ev.Project)
+	return ev, err
+}
+
+func lastProjectChange(db *sql.DB, projectID int64) (*DashboardEvent, error) {
+	ev, err := scanDashboardEvent(db.QueryRow(dashboardEventQuery+` WHERE a.project_id=? AND a.action<>? ORDER BY a.id DESC LIMIT 1`, projectID, auditActionRead))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func recentAuditEvents(db *sql.DB, limit int) ([]DashboardEvent, error) {
+	rows, err := db.Query(dashboardEventQuery+` WHERE COALESCE(p.sandbox, 0) = 0 AND a.action<>? ORDER BY a.id DESC LIMIT ?`, auditActionRead, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DashboardEvent
+	for rows.Next() {
+		ev, err := scanDashboardEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
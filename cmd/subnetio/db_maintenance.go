@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dbMaintenanceCheckInterval = time.Hour
+	dbMaintenanceKeepRuns      = 20
+	dbMaintenanceIntegrityRows = 20
+)
+
+// dbMaintenanceMu keeps runs from overlapping.
+var dbMaintenanceMu sync.Mutex
+
+var errDBMaintenanceRunning = errors.New("database maintenance is already running")
+
+// DBMaintenanceRun is the result of one run.
+type DBMaintenanceRun struct {
+	ID         int64  `json:"id"`
+	Trigger    string `json:"trigger"`
+	StartedAt  string `json:"started_at"`
+	DurationMS int64  `json:"duration_ms"`
+	SizeBefore int64  `json:"size_before"`
+	SizeAfter  int64  `json:"size_after"`
+	FreeBefore int64  `json:"free_before"`
+	Integrity  string `json:"integrity"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (r DBMaintenanceRun) OK() bool {
+	return r.Integrity == "ok" && r.Error == ""
+}
+
+func (r DBMaintenanceRun) SizeBeforeText() string { return byteSize(r.SizeBefore) }
+func (r DBMaintenanceRun) SizeAfterText() string  { return byteSize(r.SizeAfter) }
+func (r DBMaintenanceRun) FreeBeforeText() string { return byteSize(r.FreeBefore) }
+
+// Summary describes the run in one line.
+func (r DBMaintenanceRun) Summary() string {
+	if r.Error != "" {
+		return "failed: " + r.Error
+	}
+	if r.Integrity != "ok" {
+		return "integrity check failed, VACUUM skipped"
+	}
+	return fmt.Sprintf("integrity ok, %s → %s in %d ms", byteSize(r.SizeBefore), byteSize(r.SizeAfter), r.DurationMS)
+}
+
+// dbMaintenanceInterval is how often the scheduler runs maintenance; zero
+// when DB_MAINTENANCE_INTERVAL is unset.
+func dbMaintenanceInterval() time.Duration {
+	raw := mustEnv("DB_MAINTENANCE_INTERVAL", "")
+	if raw == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		log.Printf("invalid DB_MAINTENANCE_INTERVAL %q, scheduled maintenance is off", raw)
+		return 0
+	}
+	return d
+}
+
+// dbFileSize returns the size of the database file and of its free pages.
+func dbFileSize(db *sql.DB) (int64, int64, error) {
+	var pages, free, pageSize int64
+	if err := db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRow(`PRAGMA freelist_count`).Scan(&free); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, 0, err
+	}
+	return pages * pageSize, free * pageSize, nil
+}
+
+// dbIntegrityCheck returns "ok" or the problems found, one per line.
+func dbIntegrityCheck(db *sql.DB) (string, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA integrity_check(%d)`, dbMaintenanceIntegrityRows))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// runDBMaintenance runs the checks and VACUUM and records the result.
+func runDBMaintenance(db *sql.DB, trigger string, now time.Time) (DBMaintenanceRun, error) {
+	run := DBMaintenanceRun{Trigger: trigger, StartedAt: now.UTC().Format(time.RFC3339)}
+	if !dbMaintenanceMu.TryLock() {
+		return run, errDBMaintenanceRunning
+	}
+	defer dbMaintenanceMu.Unlock()
+	started := time.Now()
+	if err := dbMaintenanceSteps(db, &run); err != nil {
+		run.Error = err.Error()
+	}
+	run.DurationMS = time.Since(started).Milliseconds()
+	if run.Integrity == "" {
+		run.Integrity = "not checked"
+	}
+	res, err := db.Exec(`
+		INSERT INTO db_maintenance_runs(kind, started_at, duration_ms, size_before, size_after, free_before, integrity, error)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		run.Trigger, run.StartedAt, run.DurationMS, run.SizeBefore, run.SizeAfter, run.FreeBefore, run.Integrity, nullStringToAny(run.Error))
+	if err != nil {
+		return run, err
+	}
+	run.ID, _ = res.LastInsertId()
+	_, err = db.Exec(`DELETE FROM db_maintenance_runs WHERE id NOT IN (SELECT id FROM db_maintenance_runs ORDER BY id DESC LIMIT ?)`, dbMaintenanceKeepRuns)
+	return run, err
+}
+
+// dbMaintenanceSteps checks the file and, when it is intact, analyzes and
+// vacuums it.
+func dbMaintenanceSteps(db *sql.DB, run *DBMaintenanceRun) error {
+	var err error
+	if run.SizeBefore, run.FreeBefore, err = dbFileSize(db); err != nil {
+		return err
+	}
+	run.SizeAfter = run.SizeBefore
+	if run.Integrity, err = dbIntegrityCheck(db); err != nil {
+		return fmt.Errorf("integrity_check: %v", err)
+	}
+	if run.Integrity != "ok" {
+		return nil
+	}
+	for _, stmt := range []string{`ANALYZE`, `VACUUM`, `PRAGMA optimize`} {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	run.SizeAfter, _, err = dbFileSize(db)
+	return err
+}
+
+// listDBMaintenanceRuns returns the kept runs, newest first.
+func listDBMaintenanceRuns(db *sql.DB) ([]DBMaintenanceRun, error) {
+	rows, err := db.Query(`
+		SELECT id, kind, started_at, duration_ms, size_before, size_after, free_before, integrity, COALESCE(error, '')
+		FROM db_maintenance_runs ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DBMaintenanceRun
+	for rows.Next() {
+		var r DBMaintenanceRun
+		if err := rows.Scan(&r.ID, &r.Trigger, &r.StartedAt, &r.DurationMS, &r.SizeBefore, &r.SizeAfter, &r.FreeBefore, &r.Integrity, &r.Error); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// dbMaintenanceDue reports whether the last run is older than interval.
+func dbMaintenanceDue(db *sql.DB, interval time.Duration, now time.Time) (bool, error) {
+	var last sql.NullString
+	if err := db.QueryRow(`SELECT MAX(started_at) FROM db_maintenance_runs`).Scan(&last); err != nil {
+		return false, err
+	}
+	if !last.Valid {
+		return true, nil
+	}
+	t, err := time.Parse(time.RFC3339, last.String)
+	if err != nil {
+		return true, nil
+	}
+	return now.Sub(t) >= interval, nil
+}
+
+// auditDBMaintenance records a run in the global audit log.
+func auditDBMaintenance(run DBMaintenanceRun) auditRecord {
+	return auditRecord{
+		Action:      "maintenance",
+		EntityType:  "database",
+		EntityID:    sql.NullInt64{Int64: run.ID, Valid: run.ID > 0},
+		EntityLabel: sql.NullString{String: run.Trigger, Valid: true},
+		After:       run,
+	}
+}
+
+// startDBMaintenance runs maintenance every DB_MAINTENANCE_INTERVAL,
+// checked once an hour; it does nothing when the interval is unset.
+func startDBMaintenance(db *sql.DB) {
+	interval := dbMaintenanceInterval()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(dbMaintenanceCheckInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			due, err := dbMaintenanceDue(db, interval, now)
+			if err != nil {
+				log.Printf("database maintenance: %v", err)
+				continue
+			}
+			if !due {
+				continue
+			}
+			run, err := runDBMaintenance(db, "scheduled", now)
+			if err != nil {
+				log.Printf("database maintenance: %v", err)
+				continue
+			}
+			if !run.OK() {
+				log.Printf("database maintenance: %s", run.Summary())
+			}
+			record := auditDBMaintenance(run)
+			record.Actor = "scheduler"
+			if err := insertAuditRecord(db, record); err != nil {
+				log.Printf("database maintenance audit: %v", err)
+			}
+		}
+	}()
+}
@@ -27,9 +27,15 @@ type DefaultsProject struct {
 }
 
 type DefaultsSite struct {
-	Site    string       `json:"site" yaml:"site"`
-	Project string       `json:"project,omitempty" yaml:"project,omitempty"`
-	DHCP    DefaultsDHCP `json:"dhcp" yaml:"dhcp"`
+	Site     string            `json:"site" yaml:"site"`
+	Project  string            `json:"project,omitempty" yaml:"project,omitempty"`
+	DHCP     DefaultsDHCP      `json:"dhcp" yaml:"dhcp"`
+	Failover *DefaultsFailover `json:"dhcp_failover,omitempty" yaml:"dhcp_failover,omitempty"`
+}
+
+type DefaultsFailover struct {
+	Primary   string `json:"primary" yaml:"primary"`
+	Secondary string `json:"secondary" yaml:"secondary"`
 }
 
 type DefaultsDHCP struct {
@@ -47,6 +53,12 @@ type DefaultsImportReport struct {
 	SitesUpdated   int
 	Warnings       []string
 	Errors         []string
+	Format         string
+	DryRun         bool
+	Content        string
+	CSV            CSVOptions
+	Changes        []DefaultsChange
+	SnapshotID     int64
 }
 
 func exportDefaultsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
@@ -68,6 +80,8 @@ func exportDefaultsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 		"dhcp_boot_file",
 		"dhcp_next_server",
 		"dhcp_vendor_options",
+		"dhcp_failover_primary",
+		"dhcp_failover_secondary",
 	})
 	project := bundle.Project
 	_ = w.Write([]string{
@@ -81,8 +95,14 @@ func exportDefaultsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 		project.DHCP.BootFile,
 		project.DHCP.NextServer,
 		strings.Join(project.DHCP.VendorOptions, "\n"),
+		"",
+		"",
 	})
 	for _, site := range bundle.Sites {
+		failover := DefaultsFailover{}
+		if site.Failover != nil {
+			failover = *site.Failover
+		}
 		_ = w.Write([]string{
 			site.Project,
 			site.Site,
@@ -94,6 +114,8 @@ func exportDefaultsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 			site.DHCP.BootFile,
 			site.DHCP.NextServer,
 			strings.Join(site.DHCP.VendorOptions, "\n"),
+			failover.Primary,
+			failover.Secondary,
 		})
 	}
 	w.Flush()
@@ -162,11 +184,18 @@ func buildDefaultsProject(project Project, meta ProjectMeta) DefaultsProject {
 func buildDefaultsSites(sites []Site) []DefaultsSite {
 	out := make([]DefaultsSite, 0, len(sites))
 	for _, site := range sites {
-		out = append(out, DefaultsSite{
+		entry := DefaultsSite{
 			Site:    site.Name,
 			Project: nullString(site.Project),
 			DHCP:    defaultsDHCPFromSite(site),
-		})
+		}
+		if site.DhcpFailoverPrimary.Valid || site.DhcpFailoverSecondary.Valid {
+			entry.Failover = &DefaultsFailover{
+				Primary:   nullString(site.DhcpFailoverPrimary),
+				Secondary: nullString(site.DhcpFailoverSecondary),
+			}
+		}
+		out = append(out, entry)
 	}
 	return out
 }
@@ -196,31 +225,105 @@ func defaultsDHCPFromSite(site Site) DefaultsDHCP {
 }
 
 func importDefaultsCSV(c *gin.Context, db *sql.DB, activeProjectID int64) *DefaultsImportReport {
-	report := &DefaultsImportReport{}
-	fileHeader, err := c.FormFile("file")
+	return importDefaultsUpload(c, db, activeProjectID, "csv")
+}
+
+func importDefaultsJSON(c *gin.Context, db *sql.DB, activeProjectID int64) *DefaultsImportReport {
+	return importDefaultsUpload(c, db, activeProjectID, "json")
+}
+
+func importDefaultsYAML(c *gin.Context, db *sql.DB, activeProjectID int64) *DefaultsImportReport {
+	return importDefaultsUpload(c, db, activeProjectID, "yaml")
+}
+
+// importDefaultsUpload reads the uploaded file, or the content echoed back by
+// the preview form, and applies it. dry_run=1 only reports the changes.
+func importDefaultsUpload(c *gin.Context, db *sql.DB, activeProjectID int64, format string) *DefaultsImportReport {
+	report := &DefaultsImportReport{Format: format, DryRun: parseBool(c.PostForm("dry_run"))}
+	raw, err := readDefaultsUpload(c)
 	if err != nil {
-		report.Errors = append(report.Errors, "upload failed: "+err.Error())
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	report.Content = string(raw)
+
+	var apply func(conn sqlConn)
+	switch format {
+	case "csv":
+		// The preview echoes the content back as UTF-8 for the apply step.
+		opts, err := csvOptionsFromForm(c)
+		if err == nil {
+			report.Content, opts, err = decodeCSVText(raw, opts)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			return report
+		}
+		report.CSV = opts
+		apply = func(conn sqlConn) {
+			importDefaultsCSVRows(conn, report, strings.NewReader(report.Content), activeProjectID)
+		}
+	case "json", "yaml":
+		var bundle DefaultsBundle
+		if format == "json" {
+			err = json.Unmarshal(raw, &bundle)
+		} else {
+			err = yaml.Unmarshal(raw, &bundle)
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, "parse "+format+": "+err.Error())
+			return report
+		}
+		apply = func(conn sqlConn) {
+			applyDefaultsBundle(conn, report, bundle, activeProjectID)
+		}
+	default:
+		report.Errors = append(report.Errors, "unsupported format")
 		return report
 	}
+	runDefaultsImport(db, report, activeProjectID, apply)
+	return report
+}
+
+func readDefaultsUpload(c *gin.Context) ([]byte, error) {
+	if content := c.PostForm("content"); content != "" {
+		return []byte(content), nil
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
 	file, err := fileHeader.Open()
 	if err != nil {
-		report.Errors = append(report.Errors, "open file: "+err.Error())
-		return report
+		return nil, fmt.Errorf("open file: %w", err)
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.TrimLeadingSpace = true
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return raw, nil
+}
+
+func importDefaultsCSVRows(conn sqlConn, report *DefaultsImportReport, r io.Reader, activeProjectID int64) {
+	reader, used, err := newCSVReader(r, CSVOptions{Delimiter: report.CSV.Delimiter, Encoding: "utf-8"})
+	if err != nil {
+		report.Errors = append(report.Errors, "read CSV: "+err.Error())
+		return
+	}
+	report.CSV = used
+	reader.TrimLeadingSpace = used.Delimiter != '\t' // leading tabs are empty fields
 	reader.FieldsPerRecord = -1
 
 	first, err := reader.Read()
 	if err == io.EOF {
 		report.Errors = append(report.Errors, "empty CSV file")
-		return report
+		return
 	}
 	if err != nil {
 		report.Errors = append(report.Errors, "read CSV: "+err.Error())
-		return report
+		return
 	}
 
 	columns := defaultDefaultsColumns()
@@ -228,7 +331,7 @@ func importDefaultsCSV(c *gin.Context, db *sql.DB, activeProjectID int64) *Defau
 	if looksLikeHeader(first) {
 		columns = mapDefaultsColumns(first)
 	} else {
-		processDefaultsRow(db, report, columns, first, rowIndex, activeProjectID)
+		processDefaultsRow(conn, report, columns, first, rowIndex, activeProjectID)
 	}
 
 	for {
@@ -241,59 +344,8 @@ func importDefaultsCSV(c *gin.Context, db *sql.DB, activeProjectID int64) *Defau
 			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
 			continue
 		}
-		processDefaultsRow(db, report, columns, row, rowIndex, activeProjectID)
+		processDefaultsRow(conn, report, columns, row, rowIndex, activeProjectID)
 	}
-
-	return report
-}
-
-func importDefaultsJSON(c *gin.Context, db *sql.DB, activeProjectID int64) *DefaultsImportReport {
-	return importDefaultsBundle(c, db, activeProjectID, "json")
-}
-
-func importDefaultsYAML(c *gin.Context, db *sql.DB, activeProjectID int64) *DefaultsImportReport {
-	return importDefaultsBundle(c, db, activeProjectID, "yaml")
-}
-
-func importDefaultsBundle(c *gin.Context, db *sql.DB, activeProjectID int64, format string) *DefaultsImportReport {
-	report := &DefaultsImportReport{}
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		report.Errors = append(report.Errors, "upload failed: "+err.Error())
-		return report
-	}
-	file, err := fileHeader.Open()
-	if err != nil {
-		report.Errors = append(report.Errors, "open file: "+err.Error())
-		return report
-	}
-	defer file.Close()
-
-	raw, err := io.ReadAll(file)
-	if err != nil {
-		report.Errors = append(report.Errors, "read file: "+err.Error())
-		return report
-	}
-
-	var bundle DefaultsBundle
-	switch format {
-	case "json":
-		if err := json.Unmarshal(raw, &bundle); err != nil {
-			report.Errors = append(report.Errors, "parse json: "+err.Error())
-			return report
-		}
-	case "yaml":
-		if err := yaml.Unmarshal(raw, &bundle); err != nil {
-			report.Errors = append(report.Errors, "parse yaml: "+err.Error())
-			return report
-		}
-	default:
-		report.Errors = append(report.Errors, "unsupported format")
-		return report
-	}
-
-	applyDefaultsBundle(db, report, bundle, activeProjectID)
-	return report
 }
 
 type defaultsColumns struct {
@@ -307,6 +359,8 @@ type defaultsColumns struct {
 	DhcpBootFile   int
 	DhcpNextServer int
 	DhcpVendorOpts int
+	FailoverPri    int
+	FailoverSec    int
 }
 
 func defaultDefaultsColumns() defaultsColumns {
@@ -321,6 +375,8 @@ func defaultDefaultsColumns() defaultsColumns {
 		DhcpBootFile:   7,
 		DhcpNextServer: 8,
 		DhcpVendorOpts: 9,
+		FailoverPri:    10,
+		FailoverSec:    11,
 	}
 }
 
@@ -336,6 +392,8 @@ func mapDefaultsColumns(header []string) defaultsColumns {
 		DhcpBootFile:   -1,
 		DhcpNextServer: -1,
 		DhcpVendorOpts: -1,
+		FailoverPri:    -1,
+		FailoverSec:    -1,
 	}
 	for i, raw := range header {
 		name := normalizeHeader(raw)
@@ -360,12 +418,16 @@ func mapDefaultsColumns(header []string) defaultsColumns {
 			cols.DhcpNextServer = i
 		case "dhcpvendoroptions", "dhcpvendor", "vendoroptions":
 			cols.DhcpVendorOpts = i
+		case "dhcpfailoverprimary", "failoverprimary", "dhcpprimary":
+			cols.FailoverPri = i
+		case "dhcpfailoversecondary", "failoversecondary", "dhcpsecondary":
+			cols.FailoverSec = i
 		}
 	}
 	return cols
 }
 
-func processDefaultsRow(db *sql.DB, report *DefaultsImportReport, cols defaultsColumns, row []string, rowIndex int, activeProjectID int64) {
+func processDefaultsRow(db sqlConn, report *DefaultsImportReport, cols defaultsColumns, row []string, rowIndex int, activeProjectID int64) {
 	get := func(idx int) string {
 		if idx < 0 || idx >= len(row) {
 			return ""
@@ -418,7 +480,7 @@ func processDefaultsRow(db *sql.DB, report *DefaultsImportReport, cols defaultsC
 		return
 	}
 
-	siteID, _, err := getOrCreateSiteID(db, siteName)
+	siteID, _, err := getOrCreateProjectSiteID(db, projectID, siteName)
 	if err != nil {
 		report.Errors = append(report.Errors, fmt.Sprintf("row %d: site error: %v", rowIndex, err))
 		return
@@ -442,10 +504,16 @@ func processDefaultsRow(db *sql.DB, report *DefaultsImportReport, cols defaultsC
 		report.Errors = append(report.Errors, fmt.Sprintf("row %d: site meta error: %v", rowIndex, err))
 		return
 	}
+	if cols.FailoverPri >= 0 || cols.FailoverSec >= 0 {
+		if err := saveSiteFailover(db, siteID, get(cols.FailoverPri), get(cols.FailoverSec)); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
+			return
+		}
+	}
 	report.SitesUpdated++
 }
 
-func applyDefaultsBundle(db *sql.DB, report *DefaultsImportReport, bundle DefaultsBundle, activeProjectID int64) {
+func applyDefaultsBundle(db sqlConn, report *DefaultsImportReport, bundle DefaultsBundle, activeProjectID int64) {
 	projectID := activeProjectID
 	if bundle.Project.ID > 0 {
 		if projectExists(db, bundle.Project.ID) {
@@ -488,7 +556,7 @@ func applyDefaultsBundle(db *sql.DB, report *DefaultsImportReport, bundle Defaul
 				continue
 			}
 		}
-		siteID, _, err := getOrCreateSiteID(db, siteName)
+		siteID, _, err := getOrCreateProjectSiteID(db, siteProjectID, siteName)
 		if err != nil {
 			report.Errors = append(report.Errors, "site error: "+err.Error())
 			continue
@@ -503,6 +571,12 @@ func applyDefaultsBundle(db *sql.DB, report *DefaultsImportReport, bundle Defaul
 			report.Errors = append(report.Errors, "site meta error: "+err.Error())
 			continue
 		}
+		if site.Failover != nil {
+			if err := saveSiteFailover(db, siteID, site.Failover.Primary, site.Failover.Secondary); err != nil {
+				report.Errors = append(report.Errors, "site "+siteName+": "+err.Error())
+				continue
+			}
+		}
 		report.SitesUpdated++
 	}
 }
@@ -540,7 +614,7 @@ func hasProjectDefaults(project DefaultsProject) bool {
 	return false
 }
 
-func saveSiteDefaults(db *sql.DB, siteID int64, dhcp DefaultsDHCP) error {
+func saveSiteDefaults(db sqlConn, siteID int64, dhcp DefaultsDHCP) error {
 	if siteID <= 0 {
 		return nil
 	}
@@ -572,7 +646,7 @@ func saveSiteDefaults(db *sql.DB, siteID int64, dhcp DefaultsDHCP) error {
 	return err
 }
 
-func saveProjectMetaPartial(db *sql.DB, meta ProjectMeta) error {
+func saveProjectMetaPartial(db sqlConn, meta ProjectMeta) error {
 	if meta.ProjectID <= 0 {
 		return nil
 	}
@@ -644,7 +718,7 @@ func nullInt(v sql.NullInt64) int {
 	return 0
 }
 
-func ensureProjectID(db *sql.DB, name string) (int64, error) {
+func ensureProjectID(db sqlConn, name string) (int64, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, fmt.Errorf("project name required")
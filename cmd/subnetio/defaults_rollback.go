@@ -0,0 +1,411 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sqlConn is satisfied by *sql.DB and *sql.Tx, so defaults imports can run
+// inside a transaction that a dry run rolls back.
+type sqlConn interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+var (
+	projectDefaultsFields = []string{
+		"domain_name", "dhcp_search", "dhcp_lease_time", "dhcp_renew_time", "dhcp_rebind_time",
+		"dhcp_boot_file", "dhcp_next_server", "dhcp_vendor_options",
+	}
+	siteDefaultsFields = []string{
+		"dhcp_search", "dhcp_lease_time", "dhcp_renew_time", "dhcp_rebind_time",
+		"dhcp_boot_file", "dhcp_next_server", "dhcp_vendor_options",
+		"dhcp_failover_primary", "dhcp_failover_secondary",
+	}
+)
+
+// defaultsEntity is the import-relevant state of one project or site.
+type defaultsEntity struct {
+	Kind   string            `json:"kind"`
+	ID     int64             `json:"id"`
+	Label  string            `json:"label"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (e defaultsEntity) key() string { return e.Kind + ":" + itoa64(e.ID) }
+
+type defaultsState map[string]defaultsEntity
+
+type DefaultsChange struct {
+	Kind    string
+	Label   string
+	Field   string
+	Old     string
+	New     string
+	Created bool
+}
+
+type DefaultsImportRecord struct {
+	ID           int64
+	Source       string
+	Entities     int
+	CreatedAt    string
+	RolledBackAt sql.NullString
+}
+
+func captureDefaultsState(conn sqlConn) (defaultsState, error) {
+	state := defaultsState{}
+	cols := make([]string, 0, len(projectDefaultsFields))
+	for _, f := range projectDefaultsFields {
+		cols = append(cols, "CAST(m."+f+" AS TEXT)")
+	}
+	rows, err := conn.Query(`
+		SELECT p.id, p.name, ` + strings.Join(cols, ", ") + `
+		FROM projects p
+		LEFT JOIN project_meta m ON m.project_id=p.id`)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanDefaultsEntities(rows, "project", projectDefaultsFields, state); err != nil {
+		return nil, err
+	}
+
+	cols = cols[:0]
+	for _, f := range siteDefaultsFields {
+		cols = append(cols, "CAST(m."+f+" AS TEXT)")
+	}
+	rows, err = conn.Query(`
+		SELECT s.id, s.name, ` + strings.Join(cols, ", ") + `, CAST(ps.project_id AS TEXT)
+		FROM sites s
+		LEFT JOIN site_meta m ON m.site_id=s.id
+		LEFT JOIN project_sites ps ON ps.site_id=s.id`)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanDefaultsEntities(rows, "site", append(append([]string{}, siteDefaultsFields...), "project_id"), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func scanDefaultsEntities(rows *sql.Rows, kind string, fields []string, state defaultsState) error {
+	defer rows.Close()
+	for rows.Next() {
+		entity := defaultsEntity{Kind: kind, Fields: map[string]string{}}
+		values := make([]sql.NullString, len(fields))
+		dest := []any{&entity.ID, &entity.Label}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		for i, f := range fields {
+			if values[i].Valid {
+				entity.Fields[f] = values[i].String
+			}
+		}
+		state[entity.key()] = entity
+	}
+	return rows.Err()
+}
+
+func (s defaultsState) sorted() []defaultsEntity {
+	out := make([]defaultsEntity, 0, len(s))
+	for _, e := range s {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind == "project"
+		}
+		return out[i].Label < out[j].Label
+	})
+	return out
+}
+
+func changedDefaultsFields(before, after map[string]string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, fields := range []map[string]string{after, before} {
+		for f := range fields {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			b, bok := before[f]
+			a, aok := after[f]
+			if b != a || bok != aok {
+				out = append(out, f)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// diffDefaultsState returns the entities that differ plus per-field changes
+// for display.
+func diffDefaultsState(before, after defaultsState) (defaultsState, defaultsState, []DefaultsChange) {
+	changedBefore := defaultsState{}
+	changedAfter := defaultsState{}
+	var changes []DefaultsChange
+	projectName := func(state defaultsState, raw string) string {
+		if raw == "" {
+			return ""
+		}
+		if p, ok := state["project:"+raw]; ok {
+			return p.Label
+		}
+		return "#" + raw
+	}
+	for _, a := range after.sorted() {
+		b, existed := before[a.key()]
+		fields := changedDefaultsFields(b.Fields, a.Fields)
+		if existed && len(fields) == 0 {
+			continue
+		}
+		changedAfter[a.key()] = a
+		if existed {
+			changedBefore[a.key()] = b
+		} else {
+			changes = append(changes, DefaultsChange{Kind: a.Kind, Label: a.Label, Created: true})
+		}
+		for _, f := range fields {
+			old, cur := b.Fields[f], a.Fields[f]
+			if f == "project_id" {
+				f, old, cur = "project", projectName(before, old), projectName(after, cur)
+			}
+			changes = append(changes, DefaultsChange{Kind: a.Kind, Label: a.Label, Field: f, Old: old, New: cur})
+		}
+	}
+	return changedBefore, changedAfter, changes
+}
+
+// runDefaultsImport applies a parsed defaults file in one transaction and
+// records the per-field changes.
+func runDefaultsImport(db *sql.DB, report *DefaultsImportReport, projectID int64, apply func(conn sqlConn)) {
+	before, err := captureDefaultsState(db)
+	if err != nil {
+		report.Errors = append(report.Errors, "read current defaults: "+err.Error())
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		report.Errors = append(report.Errors, "begin: "+err.Error())
+		return
+	}
+	apply(tx)
+	after, err := captureDefaultsState(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		report.Errors = append(report.Errors, "read imported defaults: "+err.Error())
+		return
+	}
+	changedBefore, changedAfter, changes := diffDefaultsState(before, after)
+	report.Changes = changes
+	if report.DryRun {
+		_ = tx.Rollback()
+		return
+	}
+	if len(changedAfter) > 0 {
+		id, err := saveDefaultsImportSnapshot(tx, projectID, report.Format, changedBefore, changedAfter)
+		if err != nil {
+			_ = tx.Rollback()
+			report.Errors = append(report.Errors, "save rollback snapshot: "+err.Error())
+			return
+		}
+		report.SnapshotID = id
+	}
+	if err := tx.Commit(); err != nil {
+		report.Errors = append(report.Errors, "commit: "+err.Error())
+	}
+}
+
+func saveDefaultsImportSnapshot(conn sqlConn, projectID int64, source string, before, after defaultsState) (int64, error) {
+	beforeJSON, err := json.Marshal(before.sorted())
+	if err != nil {
+		return 0, err
+	}
+	afterJSON, err := json.Marshal(after.sorted())
+	if err != nil {
+		return 0, err
+	}
+	res, err := conn.Exec(`
+		INSERT INTO defaults_imports(project_id, source, before_state, after_state, created_at)
+		VALUES(?, ?, ?, ?, ?)
+	`, projectID, source, string(beforeJSON), string(afterJSON), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func listDefaultsImports(db *sql.DB, projectID int64, limit int) ([]DefaultsImportRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, source, after_state, created_at, rolled_back_at
+		FROM defaults_imports
+		WHERE project_id=?
+		ORDER BY id DESC
+		LIMIT ?
+	`, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DefaultsImportRecord
+	for rows.Next() {
+		var rec DefaultsImportRecord
+		var after string
+		if err := rows.Scan(&rec.ID, &rec.Source, &after, &rec.CreatedAt, &rec.RolledBackAt); err != nil {
+			return nil, err
+		}
+		var entities []defaultsEntity
+		_ = json.Unmarshal([]byte(after), &entities)
+		rec.Entities = len(entities)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// rollbackDefaultsImport restores the captured before state.
+func rollbackDefaultsImport(db *sql.DB, projectID, importID int64) (int, []string, error) {
+	var beforeJSON, afterJSON string
+	var rolledBack sql.NullString
+	err := db.QueryRow(`
+		SELECT before_state, after_state, rolled_back_at FROM defaults_imports WHERE id=? AND project_id=?
+	`, importID, projectID).Scan(&beforeJSON, &afterJSON, &rolledBack)
+	if err == sql.ErrNoRows {
+		return 0, nil, fmt.Errorf("defaults import %d not found", importID)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if rolledBack.Valid {
+		return 0, nil, fmt.Errorf("defaults import %d was already rolled back at %s", importID, rolledBack.String)
+	}
+	var beforeList, afterList []defaultsEntity
+	if err := json.Unmarshal([]byte(beforeJSON), &beforeList); err != nil {
+		return 0, nil, err
+	}
+	if err := json.Unmarshal([]byte(afterJSON), &afterList); err != nil {
+		return 0, nil, err
+	}
+	before := defaultsState{}
+	for _, e := range beforeList {
+		before[e.key()] = e
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	current, err := captureDefaultsState(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, nil, err
+	}
+	restored := 0
+	var warnings []string
+	for i := len(afterList) - 1; i >= 0; i-- {
+		a := afterList[i]
+		cur, ok := current[a.key()]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s %s no longer exists", a.Kind, a.Label))
+			continue
+		}
+		b, existed := before[a.key()]
+		if !existed {
+			if err := removeCreatedDefaultsEntity(tx, a); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s %s was created by the import and kept: %v", a.Kind, a.Label, err))
+				continue
+			}
+			restored++
+			continue
+		}
+		for _, f := range changedDefaultsFields(b.Fields, a.Fields) {
+			if cur.Fields[f] != a.Fields[f] {
+				warnings = append(warnings, fmt.Sprintf("%s %s: %s changed after the import, kept %q", a.Kind, a.Label, f, cur.Fields[f]))
+				continue
+			}
+			value, ok := b.Fields[f]
+			if err := restoreDefaultsField(tx, a, f, value, ok); err != nil {
+				_ = tx.Rollback()
+				return 0, nil, err
+			}
+			restored++
+		}
+	}
+	if _, err := tx.Exec(`UPDATE defaults_imports SET rolled_back_at=? WHERE id=?`, time.Now().UTC().Format(time.RFC3339), importID); err != nil {
+		_ = tx.Rollback()
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return restored, warnings, nil
+}
+
+func restoreDefaultsField(tx *sql.Tx, entity defaultsEntity, field, value string, valid bool) error {
+	var arg any
+	if valid {
+		arg = value
+	}
+	switch {
+	case entity.Kind == "site" && field == "project_id":
+		if !valid {
+			_, err := tx.Exec(`DELETE FROM project_sites WHERE site_id=?`, entity.ID)
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO project_sites(project_id, site_id)
+			VALUES(?, ?)
+			ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, arg, entity.ID)
+		return err
+	case entity.Kind == "site" && slices.Contains(siteDefaultsFields, field):
+		_, err := tx.Exec(`UPDATE site_meta SET `+field+`=? WHERE site_id=?`, arg, entity.ID)
+		return err
+	case entity.Kind == "project" && slices.Contains(projectDefaultsFields, field):
+		_, err := tx.Exec(`UPDATE project_meta SET `+field+`=? WHERE project_id=?`, arg, entity.ID)
+		return err
+	}
+	return fmt.Errorf("unknown %s field %q", entity.Kind, field)
+}
+
+func removeCreatedDefaultsEntity(tx *sql.Tx, entity defaultsEntity) error {
+	switch entity.Kind {
+	case "site":
+		var used int
+		if err := tx.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM segments WHERE site_id=?) + (SELECT COUNT(*) FROM pools WHERE site_id=?)
+		`, entity.ID, entity.ID).Scan(&used); err != nil {
+			return err
+		}
+		if used > 0 {
+			return fmt.Errorf("it has pools or segments")
+		}
+		return deleteSiteTx(tx, entity.ID)
+	case "project":
+		var sites int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM project_sites WHERE project_id=?`, entity.ID).Scan(&sites); err != nil {
+			return err
+		}
+		if sites > 0 {
+			return fmt.Errorf("it has sites")
+		}
+		if _, err := tx.Exec(`DELETE FROM project_meta WHERE project_id=?`, entity.ID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`DELETE FROM projects WHERE id=?`, entity.ID)
+		return err
+	}
+	return fmt.Errorf("unknown kind %q", entity.Kind)
+}
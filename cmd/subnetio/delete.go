@@ -12,51 +12,119 @@ func deleteProject(db *sql.DB, projectID int64, defaultProjectID int64) error {
 	if err != nil {
 		return err
 	}
+	if err := deleteProjectTx(tx, projectID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteProjectTx(tx sqlConn, projectID int64) error {
 	siteIDs := []int64{}
 	rows, err := tx.Query(`SELECT site_id FROM project_sites WHERE project_id=?`, projectID)
 	if err != nil {
-		_ = tx.Rollback()
 		return err
 	}
 	for rows.Next() {
 		var id int64
 		if err := rows.Scan(&id); err != nil {
 			rows.Close()
-			_ = tx.Rollback()
 			return err
 		}
 		siteIDs = append(siteIDs, id)
 	}
 	if err := rows.Err(); err != nil {
 		rows.Close()
-		_ = tx.Rollback()
 		return err
 	}
 	_ = rows.Close()
 
 	for _, siteID := range siteIDs {
 		if err := deleteSiteTx(tx, siteID); err != nil {
-			_ = tx.Rollback()
 			return err
 		}
 	}
 	if _, err := tx.Exec(`DELETE FROM deployed_configs WHERE project_id=?`, projectID); err != nil {
-		_ = tx.Rollback()
 		return err
 	}
 	if _, err := tx.Exec(`DELETE FROM project_rules WHERE project_id=?`, projectID); err != nil {
-		_ = tx.Rollback()
 		return err
 	}
 	if _, err := tx.Exec(`DELETE FROM project_meta WHERE project_id=?`, projectID); err != nil {
-		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM generations WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM generation_schedules WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM config_retention WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM template_vars WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_routes WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM notifications_sent WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM dhcp_tag_policies WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM allocation_validators WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM project_quotas WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM segment_tombstones WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM project_health WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM ticket_integrations WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM conflict_tickets WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM federation_prefixes WHERE source_id IN (SELECT id FROM federation_sources WHERE project_id=?)`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM federation_sources WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM filter_preset_defaults
+		WHERE project_id=? OR preset_id IN (SELECT id FROM filter_presets WHERE project_id=?)`, projectID, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM filter_presets WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM defaults_imports WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM export_columns WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM pool_tiers WHERE project_id=?`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM import_provenance WHERE import_id IN (SELECT id FROM plan_imports WHERE project_id=?)`, projectID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM plan_imports WHERE project_id=?`, projectID); err != nil {
 		return err
 	}
 	if _, err := tx.Exec(`DELETE FROM projects WHERE id=?`, projectID); err != nil {
-		_ = tx.Rollback()
 		return err
 	}
-	return tx.Commit()
+	return nil
 }
 
 func deleteSite(db *sql.DB, siteID int64) error {
@@ -74,16 +142,38 @@ func deleteSite(db *sql.DB, siteID int64) error {
 	return tx.Commit()
 }
 
-func deleteSiteTx(tx *sql.Tx, siteID int64) error {
+func deleteSiteTx(tx sqlConn, siteID int64) error {
+	if err := recordSegmentTombstones(tx, "s.site_id=?", siteID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mac_reservations WHERE segment_id IN (SELECT id FROM segments WHERE site_id=?)`, siteID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM nat_mappings WHERE segment_id IN (SELECT id FROM segments WHERE site_id=?)`, siteID); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM segment_meta WHERE segment_id IN (SELECT id FROM segments WHERE site_id=?)`, siteID); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(`
+		DELETE FROM import_provenance
+		WHERE (entity_type='segment' AND entity_id IN (SELECT id FROM segments WHERE site_id=?))
+			OR (entity_type='pool' AND entity_id IN (SELECT id FROM pools WHERE site_id=?))
+			OR (entity_type='site' AND entity_id=?)`, siteID, siteID, siteID); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM segments WHERE site_id=?`, siteID); err != nil {
 		return err
 	}
 	if _, err := tx.Exec(`DELETE FROM pools WHERE site_id=?`, siteID); err != nil {
 		return err
 	}
+	if _, err := tx.Exec(`DELETE FROM devices WHERE site_id=?`, siteID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM template_vars WHERE site_id=?`, siteID); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM site_meta WHERE site_id=?`, siteID); err != nil {
 		return err
 	}
@@ -104,15 +194,33 @@ func deleteSegment(db *sql.DB, segmentID int64) error {
 	if err != nil {
 		return err
 	}
-	if _, err := tx.Exec(`DELETE FROM segment_meta WHERE segment_id=?`, segmentID); err != nil {
+	if err := deleteSegmentTx(tx, segmentID); err != nil {
 		_ = tx.Rollback()
 		return err
 	}
+	return tx.Commit()
+}
+
+func deleteSegmentTx(tx sqlConn, segmentID int64) error {
+	if err := recordSegmentTombstones(tx, "s.id=?", segmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM mac_reservations WHERE segment_id=?`, segmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM nat_mappings WHERE segment_id=?`, segmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM segment_meta WHERE segment_id=?`, segmentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM import_provenance WHERE entity_type='segment' AND entity_id=?`, segmentID); err != nil {
+		return err
+	}
 	if _, err := tx.Exec(`DELETE FROM segments WHERE id=?`, segmentID); err != nil {
-		_ = tx.Rollback()
 		return err
 	}
-	return tx.Commit()
+	return nil
 }
 
 func deleteProjectRules(db *sql.DB, projectID int64) error {
@@ -67,16 +67,25 @@ func getDeployedConfig(db *sql.DB, projectID int64, template, scopeKey string) (
 	cfg.ProjectID = projectID
 	cfg.Template = template
 	cfg.ScopeKey = scopeKey
+	var inline string
+	var hash, encoding sql.NullString
+	var data []byte
 	row := db.QueryRow(`
-		SELECT content, updated_at
-		FROM deployed_configs
-		WHERE project_id=? AND template=? AND scope_key=?`, projectID, template, scopeKey)
-	if err := row.Scan(&cfg.Content, &cfg.UpdatedAt); err != nil {
+		SELECT d.content, d.content_hash, b.data, b.encoding, d.updated_at
+		FROM deployed_configs d
+		LEFT JOIN config_blobs b ON b.hash=d.content_hash
+		WHERE d.project_id=? AND d.template=? AND d.scope_key=?`, projectID, template, scopeKey)
+	if err := row.Scan(&inline, &hash, &data, &encoding, &cfg.UpdatedAt); err != nil {
 		if err == sql.ErrNoRows {
 			return DeployedConfig{}, false, nil
 		}
 		return DeployedConfig{}, false, err
 	}
+	content, err := storedConfigContent(inline, hash, data, encoding)
+	if err != nil {
+		return DeployedConfig{}, false, err
+	}
+	cfg.Content = content
 	return cfg, true, nil
 }
 
@@ -87,14 +96,26 @@ func saveDeployedConfig(db *sql.DB, projectID int64, template, scopeKey, content
 	content = strings.ReplaceAll(content, "\r\n", "\n")
 	content = strings.ReplaceAll(content, "\r", "\n")
 	updated := time.Now().UTC().Format(time.RFC3339)
-	_, err := db.Exec(`
-		INSERT INTO deployed_configs(project_id, template, scope_key, content, updated_at)
-		VALUES(?, ?, ?, ?, ?)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	hash, err := putConfigBlob(tx, content)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO deployed_configs(project_id, template, scope_key, content, content_hash, updated_at)
+		VALUES(?, ?, ?, '', ?, ?)
 		ON CONFLICT(project_id, template, scope_key) DO UPDATE SET
 			content=excluded.content,
+			content_hash=excluded.content_hash,
 			updated_at=excluded.updated_at`,
-		projectID, template, scopeKey, content, updated)
-	return err
+		projectID, template, scopeKey, hash, updated); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func deleteDeployedConfig(db *sql.DB, projectID int64, template, scopeKey string) error {
@@ -0,0 +1,125 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// derivedMeta holds the segment meta values Subnetio computes when they are
+// not set: the IPv4 and IPv6 gateways and the DHCP range.
+type derivedMeta struct {
+	Gateway   string
+	GatewayV6 string
+	DhcpRange string
+}
+
+type derivedSegment struct {
+	ID     int64
+	CIDR   string
+	CIDRV6 string
+	DHCP   bool
+	Stored derivedMeta
+}
+
+func loadDerivedSegments(db sqlConn, projectID int64) ([]derivedSegment, error) {
+	rows, err := db.Query(`
+		SELECT s.id, COALESCE(s.cidr, ''), COALESCE(s.cidr_v6, ''), COALESCE(sm.dhcp_enabled, 0),
+			COALESCE(sm.gateway, ''), COALESCE(sm.gateway_v6, ''), COALESCE(sm.dhcp_range, '')
+		FROM segments s
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		LEFT JOIN segment_meta sm ON sm.segment_id = s.id
+		WHERE ps.project_id=?
+		ORDER BY s.id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []derivedSegment
+	for rows.Next() {
+		var s derivedSegment
+		var dhcp int
+		if err := rows.Scan(&s.ID, &s.CIDR, &s.CIDRV6, &dhcp, &s.Stored.Gateway, &s.Stored.GatewayV6, &s.Stored.DhcpRange); err != nil {
+			return nil, err
+		}
+		s.DHCP = dhcp != 0
+		s.Stored.Gateway = strings.TrimSpace(s.Stored.Gateway)
+		s.Stored.GatewayV6 = strings.TrimSpace(s.Stored.GatewayV6)
+		s.Stored.DhcpRange = strings.TrimSpace(s.Stored.DhcpRange)
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// deriveMeta computes the gateway and DHCP range of a segment without stored
+// meta.
+func deriveMeta(cidr, cidrV6 string, dhcp bool, gateway string) derivedMeta {
+	var out derivedMeta
+	if p, err := netip.ParsePrefix(cidr); err == nil {
+		if details, ok := prefixDetailsIPv4(p); ok {
+			out.Gateway = details.FirstUsable
+			if gateway == "" {
+				gateway = out.Gateway
+			}
+			if dhcp {
+				if start, end := autoDhcpRangeFromPrefix(p, gateway); start != "" {
+					out.DhcpRange = start + "-" + end
+				}
+			}
+		}
+	}
+	if p, err := netip.ParsePrefix(cidrV6); err == nil {
+		out.GatewayV6 = segmentGatewayV6(Segment{}, p)
+	}
+	return out
+}
+
+func keepOrDerive(stored, before, after string) string {
+	if stored != "" && stored != before {
+		return stored
+	}
+	return after
+}
+
+// persistDerivedMeta writes derived gateways and DHCP ranges into segment_meta
+// after allocation.
+func persistDerivedMeta(db sqlConn, projectID int64, before []derivedSegment) error {
+	prev := make(map[int64]derivedSegment, len(before))
+	for _, s := range before {
+		prev[s.ID] = s
+	}
+	after, err := loadDerivedSegments(db, projectID)
+	if err != nil {
+		return err
+	}
+	for _, s := range after {
+		var old derivedMeta
+		if p, ok := prev[s.ID]; ok {
+			old = deriveMeta(p.CIDR, p.CIDRV6, p.DHCP, p.Stored.Gateway)
+		}
+		want := deriveMeta(s.CIDR, s.CIDRV6, s.DHCP, "")
+		next := derivedMeta{
+			Gateway:   keepOrDerive(s.Stored.Gateway, old.Gateway, want.Gateway),
+			GatewayV6: keepOrDerive(s.Stored.GatewayV6, old.GatewayV6, want.GatewayV6),
+		}
+		if next.Gateway != want.Gateway {
+			want.DhcpRange = deriveMeta(s.CIDR, "", s.DHCP, next.Gateway).DhcpRange
+		}
+		next.DhcpRange = keepOrDerive(s.Stored.DhcpRange, old.DhcpRange, want.DhcpRange)
+		if next == s.Stored {
+			continue
+		}
+		if _, err := db.Exec(`
+			INSERT INTO segment_meta(segment_id, gateway, gateway_v6, dhcp_range)
+			VALUES(?, ?, ?, ?)
+			ON CONFLICT(segment_id) DO UPDATE SET
+				gateway=excluded.gateway,
+				gateway_v6=excluded.gateway_v6,
+				dhcp_range=excluded.dhcp_range`,
+			s.ID, nullStringToAny(next.Gateway), nullStringToAny(next.GatewayV6), nullStringToAny(next.DhcpRange)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const maxDeviceConfigSize = 1 << 20
+
+// defaultNoisePatterns drop banner and timestamp lines that devices add to
+// their running config but templates never render.
+var defaultNoisePatterns = map[string]string{
+	"cisco":    "^Building configuration\n^Current configuration\\s*:\n^! (Last configuration change|NVRAM config last updated)\n^ntp clock-period\n^end$",
+	"juniper":  "^## Last (commit|changed):\n^version ",
+	"mikrotik": "^# \\S+ \\d{2}:\\d{2}:\\d{2} by RouterOS\n^# software id",
+	"vyos":     "^// (Warning|vyos-config-version|Release version)",
+}
+
+type NoiseFilter struct {
+	Template         string `json:"template"`
+	StripComments    bool   `json:"strip_comments"`
+	IgnoreWhitespace bool   `json:"ignore_whitespace"`
+	Patterns         string `json:"ignore_patterns,omitempty"`
+	UpdatedAt        string `json:"updated_at,omitempty"`
+	Custom           bool   `json:"custom"`
+}
+
+type DeviceDiffResult struct {
+	Content         string
+	Diff            string
+	DroppedDevice   int
+	DroppedRendered int
+	Error           string
+}
+
+func defaultNoiseFilter(template string) NoiseFilter {
+	return NoiseFilter{
+		Template:         template,
+		StripComments:    true,
+		IgnoreWhitespace: true,
+		Patterns:         defaultNoisePatterns[template],
+	}
+}
+
+func getNoiseFilter(db *sql.DB, template string) NoiseFilter {
+	f := defaultNoiseFilter(template)
+	var strip, ws int
+	var patterns, updated sql.NullString
+	err := db.QueryRow(`
+		SELECT strip_comments, ignore_whitespace, ignore_patterns, updated_at
+		FROM template_noise_filters WHERE template=?`, template,
+	).Scan(&strip, &ws, &patterns, &updated)
+	if err != nil {
+		return f
+	}
+	f.StripComments = strip != 0
+	f.IgnoreWhitespace = ws != 0
+	f.Patterns = patterns.String
+	f.UpdatedAt = updated.String
+	f.Custom = true
+	return f
+}
+
+func saveNoiseFilter(db *sql.DB, f NoiseFilter) error {
+	if _, err := compileNoisePatterns(f.Patterns); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO template_noise_filters(template, strip_comments, ignore_whitespace, ignore_patterns, updated_at)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(template) DO UPDATE SET
+			strip_comments=excluded.strip_comments,
+			ignore_whitespace=excluded.ignore_whitespace,
+			ignore_patterns=excluded.ignore_patterns,
+			updated_at=excluded.updated_at`,
+		f.Template, boolToInt(f.StripComments), boolToInt(f.IgnoreWhitespace),
+		nullStringToAny(strings.TrimSpace(f.Patterns)), time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func deleteNoiseFilter(db *sql.DB, template string) error {
+	_, err := db.Exec(`DELETE FROM template_noise_filters WHERE template=?`, template)
+	return err
+}
+
+func compileNoisePatterns(raw string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, line := range parseLines(raw) {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %v", line, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+// apply returns content without noise lines and how many lines were dropped.
+func (f NoiseFilter) apply(content string, patterns []*regexp.Regexp) (string, int) {
+	prefix := templateCommentPrefix(f.Template)
+	var kept []string
+	dropped := 0
+lines:
+	for _, line := range splitLines(content) {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if f.StripComments && strings.HasPrefix(trimmed, prefix) {
+			dropped++
+			continue
+		}
+		for _, re := range patterns {
+			if re.MatchString(line) || re.MatchString(trimmed) {
+				dropped++
+				continue lines
+			}
+		}
+		if f.IgnoreWhitespace {
+			line = strings.Join(strings.Fields(line), " ")
+		} else {
+			line = strings.TrimRight(line, " \t")
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n"), dropped
+}
+
+func diffDeviceConfig(f NoiseFilter, device, rendered string) DeviceDiffResult {
+	result := DeviceDiffResult{Content: device}
+	patterns, err := compileNoisePatterns(f.Patterns)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	left, droppedDevice := f.apply(device, patterns)
+	right, droppedRendered := f.apply(rendered, patterns)
+	result.DroppedDevice = droppedDevice
+	result.DroppedRendered = droppedRendered
+	result.Diff = unifiedDiffLabeled("device", "rendered", left, right)
+	return result
+}
@@ -0,0 +1,393 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PoolKindLoopback pools hold device loopbacks, one /32 or /128 per device.
+const PoolKindLoopback = "loopback"
+
+func parsePoolKind(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", PoolKindLAN:
+		return PoolKindLAN, nil
+	case PoolKindLink:
+		return PoolKindLink, nil
+	case PoolKindLoopback:
+		return PoolKindLoopback, nil
+	case PoolKindNAT:
+		return PoolKindNAT, nil
+	}
+	return "", fmt.Errorf("invalid kind: %s (use lan, link, loopback or nat)", raw)
+}
+
+func normalizePoolKind(raw string) string {
+	kind, err := parsePoolKind(raw)
+	if err != nil {
+		return PoolKindLAN
+	}
+	return kind
+}
+
+func isLoopbackPool(p Pool) bool {
+	return p.Kind == PoolKindLoopback
+}
+
+// poolHoldsSegments reports whether segments allocate from p: loopback and
+// NAT pools hold addresses for devices and NAT mappings instead.
+func poolHoldsSegments(p Pool) bool {
+	return !isLoopbackPool(p) && !isNATPool(p)
+}
+
+// Device is a router or switch of a site.
+type Device struct {
+	ID         int64
+	SiteID     int64
+	Site       string
+	Name       string
+	Role       string
+	Loopback   sql.NullString
+	LoopbackV6 sql.NullString
+	Notes      string
+	CreatedAt  string
+}
+
+func listDevices(db sqlConn, projectID int64) ([]Device, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.site_id, s.name, d.name, d.role, d.loopback, d.loopback_v6, d.notes, d.created_at
+		FROM devices d
+		JOIN sites s ON s.id = d.site_id
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE ps.project_id=?
+		ORDER BY s.name, d.name`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Device
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.SiteID, &d.Site, &d.Name, &d.Role, &d.Loopback, &d.LoopbackV6, &d.Notes, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// attachSiteDevices fills in the devices of each site.
+func attachSiteDevices(db sqlConn, sites []Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(sites))
+	for i, site := range sites {
+		index[site.ID] = i
+	}
+	rows, err := db.Query(`SELECT id, site_id, name, role, loopback, loopback_v6, notes, created_at FROM devices ORDER BY name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Device
+		if err := rows.Scan(&d.ID, &d.SiteID, &d.Name, &d.Role, &d.Loopback, &d.LoopbackV6, &d.Notes, &d.CreatedAt); err != nil {
+			return err
+		}
+		i, ok := index[d.SiteID]
+		if !ok {
+			continue
+		}
+		d.Site = sites[i].Name
+		sites[i].Devices = append(sites[i].Devices, d)
+	}
+	return rows.Err()
+}
+
+func deviceByID(db sqlConn, id int64) (Device, bool) {
+	var d Device
+	err := db.QueryRow(`
+		SELECT d.id, d.site_id, s.name, d.name, d.role, d.loopback, d.loopback_v6, d.notes, d.created_at
+		FROM devices d
+		JOIN sites s ON s.id = d.site_id
+		WHERE d.id=?`, id).
+		Scan(&d.ID, &d.SiteID, &d.Site, &d.Name, &d.Role, &d.Loopback, &d.LoopbackV6, &d.Notes, &d.CreatedAt)
+	return d, err == nil
+}
+
+// saveDevice adds a device or updates the role and notes of the device with
+// the same name at the site, then assigns loopbacks to the project's devices.
+func saveDevice(db *sql.DB, projectID int64, d Device) (int64, error) {
+	name := strings.TrimSpace(d.Name)
+	if name == "" {
+		return 0, errors.New("device name is required")
+	}
+	if strings.ContainsAny(name, " \t:") {
+		return 0, fmt.Errorf("invalid device name %q", name)
+	}
+	var siteName string
+	if err := db.QueryRow(`
+		SELECT s.name FROM sites s
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE s.id=? AND ps.project_id=?`, d.SiteID, projectID).Scan(&siteName); err != nil {
+		return 0, errors.New("site not found")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO devices(site_id, name, role, notes, created_at)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(site_id, name) DO UPDATE SET role=excluded.role, notes=excluded.notes
+		RETURNING id`,
+		d.SiteID, name, strings.TrimSpace(d.Role), strings.TrimSpace(d.Notes), time.Now().UTC().Format(time.RFC3339),
+	).Scan(&id)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := assignLoopbacks(tx, projectID); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func deleteDevice(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM devices WHERE id=?`, id)
+	return err
+}
+
+type loopbackPool struct {
+	SiteID   int64
+	Prefix   netip.Prefix
+	Priority int
+}
+
+func loopbackPoolsByFamily(db sqlConn, projectID int64, family string) (map[int64][]netip.Prefix, error) {
+	rows, err := db.Query(`
+		SELECT p.site_id, p.cidr, COALESCE(p.family, 'ipv4'), COALESCE(p.priority, 0)
+		FROM pools p
+		JOIN project_sites ps ON ps.site_id = p.site_id
+		WHERE ps.project_id=? AND p.kind=?`, projectID, PoolKindLoopback)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var pools []loopbackPool
+	for rows.Next() {
+		var lp loopbackPool
+		var cidr, poolFamily string
+		if err := rows.Scan(&lp.SiteID, &cidr, &poolFamily, &lp.Priority); err != nil {
+			return nil, err
+		}
+		if normalizePoolFamily(poolFamily) != family {
+			continue
+		}
+		p, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil || p.Addr().Is4() != (family == "ipv4") {
+			continue
+		}
+		lp.Prefix = p.Masked()
+		pools = append(pools, lp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(pools, func(i, j int) bool {
+		if pools[i].Priority != pools[j].Priority {
+			return pools[i].Priority < pools[j].Priority
+		}
+		return pools[i].Prefix.String() < pools[j].Prefix.String()
+	})
+	out := map[int64][]netip.Prefix{}
+	for _, lp := range pools {
+		out[lp.SiteID] = append(out[lp.SiteID], lp.Prefix)
+	}
+	return out, nil
+}
+
+// firstFreeLoopback returns the lowest address of p not in used.
+func firstFreeLoopback(p netip.Prefix, used map[netip.Addr]bool) (netip.Addr, bool) {
+	a := p.Addr()
+	hostBits := a.BitLen() - p.Bits()
+	var last netip.Addr
+	if hostBits >= 2 {
+		a = a.Next()
+		if a.Is4() {
+			last, _ = prefixLastAddr(p)
+		}
+	}
+	for ; a.IsValid() && p.Contains(a); a = a.Next() {
+		if a == last {
+			break
+		}
+		if !used[a] {
+			return a, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// assignLoopbacks gives every device of the project a loopback from its site's
+// loopback pools of each family.
+func assignLoopbacks(db sqlConn, projectID int64) error {
+	devices, err := listDevices(db, projectID)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	for _, family := range []string{"ipv4", "ipv6"} {
+		pools, err := loopbackPoolsByFamily(db, projectID, family)
+		if err != nil {
+			return err
+		}
+		column := "loopback"
+		current := func(d Device) string { return d.Loopback.String }
+		if family == "ipv6" {
+			column = "loopback_v6"
+			current = func(d Device) string { return d.LoopbackV6.String }
+		}
+		used := map[netip.Addr]bool{}
+		next := make([]string, len(devices))
+		for i, d := range devices {
+			addr, err := netip.ParseAddr(current(d))
+			if err != nil || used[addr] {
+				continue
+			}
+			for _, p := range pools[d.SiteID] {
+				if p.Contains(addr) {
+					used[addr] = true
+					next[i] = addr.String()
+					break
+				}
+			}
+		}
+		for i, d := range devices {
+			if next[i] != "" || len(pools[d.SiteID]) == 0 {
+				continue
+			}
+			for _, p := range pools[d.SiteID] {
+				if addr, ok := firstFreeLoopback(p, used); ok {
+					used[addr] = true
+					next[i] = addr.String()
+					break
+				}
+			}
+			if next[i] == "" {
+				return fmt.Errorf("loopback pools of site %s are full (%s): no address for %s", d.Site, family, d.Name)
+			}
+		}
+		for i, d := range devices {
+			if next[i] == current(d) {
+				continue
+			}
+			if _, err := db.Exec(`UPDATE devices SET `+column+`=? WHERE id=?`, nullStringToAny(next[i]), d.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderDevice is a device as templates see it.
+type renderDevice struct {
+	Name        string
+	Site        string
+	Role        string
+	ASN         int64
+	Loopback    string
+	LoopbackV6  string
+	RouterID    string
+	BGPSource   string
+	BGPSourceV6 string
+	Vars        map[string]string
+}
+
+// buildRenderDevices returns the devices of sites with a loopback of a
+// generated family, honouring the site filter.
+func buildRenderDevices(opts GenerateOptions, sites []Site) []renderDevice {
+	var out []renderDevice
+	for _, site := range sites {
+		if opts.SiteFilter != "" && opts.SiteFilter != site.Name {
+			continue
+		}
+		for _, d := range site.Devices {
+			rd := renderDevice{Name: d.Name, Site: site.Name, Role: d.Role, ASN: site.BGPASN.Int64}
+			if opts.WantIPv4() && d.Loopback.Valid {
+				rd.Loopback = d.Loopback.String
+				rd.RouterID = rd.Loopback
+				rd.BGPSource = rd.Loopback
+			}
+			if opts.WantIPv6() && d.LoopbackV6.Valid {
+				rd.LoopbackV6 = d.LoopbackV6.String
+				rd.BGPSourceV6 = rd.LoopbackV6
+			}
+			if rd.Loopback == "" && rd.LoopbackV6 == "" {
+				continue
+			}
+			out = append(out, rd)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Site != out[j].Site {
+			return out[i].Site < out[j].Site
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+type deviceRecord struct {
+	Site       string `json:"site"`
+	Name       string `json:"name"`
+	Role       string `json:"role,omitempty"`
+	Loopback   string `json:"loopback,omitempty"`
+	LoopbackV6 string `json:"loopback_v6,omitempty"`
+	Notes      string `json:"notes,omitempty"`
+}
+
+// renderDeviceInventory writes the device inventory as csv or json.
+func renderDeviceInventory(devices []Device, format string) (string, string, error) {
+	records := make([]deviceRecord, 0, len(devices))
+	for _, d := range devices {
+		records = append(records, deviceRecord{
+			Site:       d.Site,
+			Name:       d.Name,
+			Role:       d.Role,
+			Loopback:   d.Loopback.String,
+			LoopbackV6: d.LoopbackV6.String,
+			Notes:      d.Notes,
+		})
+	}
+	switch format {
+	case "", "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		_ = w.Write([]string{"site", "name", "role", "loopback", "loopback_v6", "notes"})
+		for _, r := range records {
+			_ = w.Write([]string{r.Site, r.Name, r.Role, r.Loopback, r.LoopbackV6, r.Notes})
+		}
+		w.Flush()
+		return b.String(), "csv", w.Error()
+	case "json":
+		out, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		return string(out) + "\n", "json", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q (use csv or json)", format)
+}
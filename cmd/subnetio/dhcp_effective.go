@@ -0,0 +1,292 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// DHCP settings are merged in layers: project defaults, then the site's
+// overrides, then the DHCP policies of a segment's tags in ascending priority.
+const (
+	dhcpSourceProject = "project"
+	dhcpSourceSite    = "site"
+	dhcpSourceSegment = "segment"
+	dhcpSourceDomain  = "domain"
+	dhcpSourceAuto    = "auto"
+	dhcpSourcePolicy  = "policy:"
+)
+
+// DHCPSettingLayer is a value one level sets for a field.
+type DHCPSettingLayer struct {
+	Source string `json:"source"`
+	Value  string `json:"value"`
+}
+
+// DHCPEffectiveSetting is the merged value of one field, the level it comes
+// from, and every level that sets it, lowest precedence first.
+type DHCPEffectiveSetting struct {
+	Field  string             `json:"field"`
+	Value  string             `json:"value"`
+	Source string             `json:"source"`
+	Layers []DHCPSettingLayer `json:"layers"`
+}
+
+// Overridden reports whether a later level replaced a value set below it.
+func (s DHCPEffectiveSetting) Overridden() bool {
+	for _, l := range s.Layers {
+		if !s.From(l.Source) {
+			return true
+		}
+	}
+	return false
+}
+
+// From reports whether the effective value comes from source, alone or
+// with other levels (vendor option lines accumulate).
+func (s DHCPEffectiveSetting) From(source string) bool {
+	for _, part := range strings.Split(s.Source, ", ") {
+		if part == source {
+			return true
+		}
+	}
+	return false
+}
+
+func (s DHCPEffectiveSetting) LayerValue(source string) (string, bool) {
+	for _, l := range s.Layers {
+		if l.Source == source {
+			return l.Value, true
+		}
+	}
+	return "", false
+}
+
+type dhcpSettingBuilder struct {
+	settings []DHCPEffectiveSetting
+	index    map[string]int
+}
+
+func newDHCPSettingBuilder(fields ...string) *dhcpSettingBuilder {
+	b := &dhcpSettingBuilder{index: map[string]int{}}
+	for _, f := range fields {
+		b.index[f] = len(b.settings)
+		b.settings = append(b.settings, DHCPEffectiveSetting{Field: f, Layers: []DHCPSettingLayer{}})
+	}
+	return b
+}
+
+func (b *dhcpSettingBuilder) set(field, source, value string) {
+	s := &b.settings[b.index[field]]
+	s.Layers = append(s.Layers, DHCPSettingLayer{Source: source, Value: value})
+	s.Value = value
+	s.Source = source
+}
+
+func (b *dhcpSettingBuilder) add(field, source, value string) {
+	s := &b.settings[b.index[field]]
+	s.Layers = append(s.Layers, DHCPSettingLayer{Source: source, Value: value})
+	lines := parseLines(s.Value)
+	lines = append(lines, parseLines(value)...)
+	s.Value = strings.Join(lines, "\n")
+	if s.Source == "" {
+		s.Source = source
+	} else {
+		s.Source += ", " + source
+	}
+}
+
+func (b *dhcpSettingBuilder) value(field string) string {
+	return b.settings[b.index[field]].Value
+}
+
+var dhcpEffectiveFields = []string{"search", "lease_time", "renew_time", "rebind_time", "boot_file", "next_server", "vendor_options", "dns", "ntp"}
+
+// dhcpLevel is the part of a project, site or policy row that takes part
+// in the merge.
+type dhcpLevel struct {
+	Source     string
+	Search     sql.NullString
+	Lease      sql.NullInt64
+	Renew      sql.NullInt64
+	Rebind     sql.NullInt64
+	BootFile   sql.NullString
+	NextServer sql.NullString
+	Vendor     sql.NullString
+	DNS        sql.NullString
+	NTP        sql.NullString
+}
+
+func (b *dhcpSettingBuilder) apply(l dhcpLevel, appendVendor bool) {
+	for _, f := range []struct {
+		field string
+		value sql.NullString
+	}{{"search", l.Search}, {"boot_file", l.BootFile}, {"next_server", l.NextServer}} {
+		if f.value.Valid {
+			b.set(f.field, l.Source, strings.TrimSpace(f.value.String))
+		}
+	}
+	for _, f := range []struct {
+		field string
+		value sql.NullInt64
+	}{{"lease_time", l.Lease}, {"renew_time", l.Renew}, {"rebind_time", l.Rebind}} {
+		if f.value.Valid {
+			b.set(f.field, l.Source, itoa64(f.value.Int64))
+		}
+	}
+	if l.Vendor.Valid {
+		raw := strings.Join(parseLines(l.Vendor.String), "\n")
+		if appendVendor {
+			b.add("vendor_options", l.Source, raw)
+		} else {
+			b.set("vendor_options", l.Source, raw)
+		}
+	}
+	if l.DNS.Valid {
+		b.set("dns", l.Source, strings.Join(parseList(l.DNS), ","))
+	}
+	if l.NTP.Valid {
+		b.set("ntp", l.Source, strings.Join(parseList(l.NTP), ","))
+	}
+}
+
+func projectDHCPLevel(meta ProjectMeta) dhcpLevel {
+	return dhcpLevel{Source: dhcpSourceProject, Search: meta.DhcpSearch, Lease: meta.DhcpLeaseTime, Renew: meta.DhcpRenewTime,
+		Rebind: meta.DhcpRebindTime, BootFile: meta.DhcpBootFile, NextServer: meta.DhcpNextServer, Vendor: meta.DhcpVendorOpts,
+		DNS: meta.DNS, NTP: meta.NTP}
+}
+
+func siteDHCPLevel(site Site) dhcpLevel {
+	return dhcpLevel{Source: dhcpSourceSite, Search: site.DhcpSearch, Lease: site.DhcpLeaseTime, Renew: site.DhcpRenewTime,
+		Rebind: site.DhcpRebindTime, BootFile: site.DhcpBootFile, NextServer: site.DhcpNextServer, Vendor: site.DhcpVendorOpts,
+		DNS: site.DNS, NTP: site.NTP}
+}
+
+func policyDHCPLevel(p DHCPPolicy) dhcpLevel {
+	return dhcpLevel{Source: dhcpSourcePolicy + p.Tag, Search: p.DhcpSearch, Lease: p.DhcpLeaseTime, Renew: p.DhcpRenewTime,
+		Rebind: p.DhcpRebindTime, BootFile: p.DhcpBootFile, NextServer: p.DhcpNextServer, Vendor: p.DhcpVendorOpts}
+}
+
+// effectiveDHCPSettings merges the DHCP levels, keeping track of where each
+// value comes from.
+func effectiveDHCPSettings(meta ProjectMeta, site *Site, policies []DHCPPolicy, domain string) []DHCPEffectiveSetting {
+	b := newDHCPSettingBuilder(dhcpEffectiveFields...)
+	b.apply(projectDHCPLevel(meta), false)
+	if site != nil {
+		b.apply(siteDHCPLevel(*site), false)
+	}
+	for _, p := range policies {
+		b.apply(policyDHCPLevel(p), true)
+	}
+	if len(parseCSV(b.value("search"))) == 0 && strings.TrimSpace(domain) != "" {
+		b.set("search", dhcpSourceDomain, domain)
+	}
+	return b.settings
+}
+
+// DHCPSegmentSettings adds the segment's own DHCP fields to the merged
+// settings.
+type DHCPSegmentSettings struct {
+	ID       int64                  `json:"id"`
+	Name     string                 `json:"name"`
+	VRF      string                 `json:"vrf"`
+	VLAN     int                    `json:"vlan"`
+	Enabled  bool                   `json:"dhcp_enabled"`
+	Settings []DHCPEffectiveSetting `json:"settings"`
+}
+
+type DHCPSiteSettings struct {
+	ID       int64                  `json:"id"`
+	Name     string                 `json:"name"`
+	Settings []DHCPEffectiveSetting `json:"settings"`
+	Segments []DHCPSegmentSettings  `json:"segments,omitempty"`
+}
+
+type DHCPSettingsReport struct {
+	ProjectID int64                  `json:"project_id"`
+	Domain    string                 `json:"domain,omitempty"`
+	Project   []DHCPEffectiveSetting `json:"project"`
+	Sites     []DHCPSiteSettings     `json:"sites"`
+}
+
+// segmentDHCPSettings merges the settings for a segment and adds its range
+// and gateway, which are either set on the segment or computed.
+func segmentDHCPSettings(meta ProjectMeta, site Site, v SegmentView, domain string) DHCPSegmentSettings {
+	settings := effectiveDHCPSettings(meta, &site, v.DhcpPolicies, domain)
+	for _, f := range []struct {
+		field  string
+		stored sql.NullString
+		auto   string
+	}{{"dhcp_range", v.Segment.DhcpRange, v.DhcpRange}, {"gateway", v.Segment.Gateway, v.Gateway}} {
+		s := DHCPEffectiveSetting{Field: f.field, Layers: []DHCPSettingLayer{}}
+		if f.stored.Valid && strings.TrimSpace(f.stored.String) != "" {
+			s.Value = strings.TrimSpace(f.stored.String)
+			s.Source = dhcpSourceSegment
+			s.Layers = append(s.Layers, DHCPSettingLayer{Source: dhcpSourceSegment, Value: s.Value})
+		} else if f.auto != "" {
+			s.Value = f.auto
+			s.Source = dhcpSourceAuto
+		}
+		settings = append(settings, s)
+	}
+	return DHCPSegmentSettings{ID: v.ID, Name: v.Name, VRF: v.VRF, VLAN: v.VLAN, Enabled: v.DhcpEnabled, Settings: settings}
+}
+
+// buildDHCPSettingsReport lists the merged DHCP settings of the project and its
+// sites.
+func buildDHCPSettingsReport(db *sql.DB, projectID, siteID, segmentID int64, withSegments bool) (DHCPSettingsReport, error) {
+	meta, err := getProjectMeta(db, projectID)
+	if err != nil {
+		return DHCPSettingsReport{}, err
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return DHCPSettingsReport{}, err
+	}
+	domain := resolveDomain(GenerateOptions{}, meta)
+	report := DHCPSettingsReport{
+		ProjectID: projectID,
+		Domain:    domain,
+		Project:   effectiveDHCPSettings(meta, nil, nil, domain),
+		Sites:     []DHCPSiteSettings{},
+	}
+
+	var views []SegmentView
+	if withSegments || segmentID > 0 {
+		segs, err := listSegments(db, projectID)
+		if err != nil {
+			return DHCPSettingsReport{}, err
+		}
+		pools, _ := listPools(db, projectID)
+		views = buildSegmentViews(segs, map[int64]SegmentStatus{}, pools)
+		policies, _ := listDHCPPolicies(db, projectID)
+		views = attachDHCPPolicies(views, policies)
+	}
+	found := segmentID == 0
+	for _, site := range sites {
+		if siteID > 0 && site.ID != siteID {
+			continue
+		}
+		entry := DHCPSiteSettings{ID: site.ID, Name: site.Name, Settings: effectiveDHCPSettings(meta, &site, nil, domain)}
+		for _, v := range views {
+			if v.SiteID != site.ID || (segmentID > 0 && v.ID != segmentID) || isLinkSegment(v.Segment) {
+				continue
+			}
+			entry.Segments = append(entry.Segments, segmentDHCPSettings(meta, site, v, domain))
+			found = true
+		}
+		if segmentID > 0 && len(entry.Segments) == 0 {
+			continue
+		}
+		report.Sites = append(report.Sites, entry)
+	}
+	if siteID > 0 && len(report.Sites) == 0 {
+		return DHCPSettingsReport{}, errors.New("site not found")
+	}
+	if !found {
+		return DHCPSettingsReport{}, errors.New("segment not found")
+	}
+	return report, nil
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DHCPScope is one row of /export/dhcp.
+type DHCPScope struct {
+	Site            string           `json:"site"`
+	VRF             string           `json:"vrf"`
+	VLAN            int              `json:"vlan"`
+	Name            string           `json:"name"`
+	CIDR            string           `json:"cidr"`
+	Network         string           `json:"network"`
+	Mask            string           `json:"mask"`
+	Gateway         string           `json:"gateway"`
+	RangeStart      string           `json:"range_start"`
+	RangeEnd        string           `json:"range_end"`
+	Reservations    string           `json:"reservations,omitempty"`
+	MACReservations []DHCPScopeHost  `json:"mac_reservations"`
+	DNS             []string         `json:"dns"`
+	NTP             []string         `json:"ntp"`
+	Domain          string           `json:"domain,omitempty"`
+	Options         DHCPScopeOptions `json:"options"`
+	Policies        []string         `json:"policies"`
+	FailoverPeer    string           `json:"failover_peer,omitempty"`
+}
+
+type DHCPScopeHost struct {
+	MAC      string `json:"mac"`
+	IP       string `json:"ip"`
+	Hostname string `json:"hostname,omitempty"`
+}
+
+type DHCPScopeOptions struct {
+	Search        []string `json:"search"`
+	LeaseTime     int      `json:"lease_time,omitempty"`
+	RenewTime     int      `json:"renew_time,omitempty"`
+	RebindTime    int      `json:"rebind_time,omitempty"`
+	BootFile      string   `json:"boot_file,omitempty"`
+	NextServer    string   `json:"next_server,omitempty"`
+	VendorOptions []string `json:"vendor_options"`
+}
+
+// buildDHCPScopes lists the DHCP scopes of the project, limited to scope,
+// in site, VRF, VLAN order.
+func buildDHCPScopes(db *sql.DB, projectID int64, scope PlanScope) ([]DHCPScope, error) {
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	macs, err := listMACReservations(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	rules, _ := getProjectRules(db, projectID)
+	meta, _ := getProjectMeta(db, projectID)
+	statuses, _ := analyzeAll(segs, pools, sites, rules)
+	sites, _, segs = scope.filter(sites, nil, segs)
+	views := buildSegmentViews(segs, statuses, pools)
+	policies, _ := listDHCPPolicies(db, projectID)
+	views = attachDHCPPolicies(views, policies)
+
+	opts := GenerateOptions{IncludeDHCP: true, Family: generateFamilyIPv4}
+	domain := resolveDomain(opts, meta)
+	dhcpBySite := buildDHCPBySite(sites, projectDHCPDefaults(meta, domain), domain)
+	rendered := buildRenderSegments(opts, views, sites, domain, dhcpBySite, buildSiteDefaults(sites, meta))
+
+	hosts := map[int64][]DHCPScopeHost{}
+	for _, m := range macs {
+		if m.IP == "" {
+			continue
+		}
+		hosts[m.SegmentID] = append(hosts[m.SegmentID], DHCPScopeHost{MAC: m.MAC, IP: m.IP, Hostname: m.Hostname})
+	}
+	viewByID := make(map[int64]SegmentView, len(views))
+	for _, v := range views {
+		viewByID[v.ID] = v
+	}
+
+	out := []DHCPScope{}
+	for _, s := range rendered {
+		if !s.HasIPv4 || !s.DhcpEnabled {
+			continue
+		}
+		v := viewByID[s.ID]
+		row := DHCPScope{
+			Site:            s.Site,
+			VRF:             s.VRF,
+			VLAN:            s.VLAN,
+			Name:            s.Name,
+			CIDR:            s.Prefix.String(),
+			Network:         s.Network,
+			Mask:            s.Mask,
+			Gateway:         s.Gateway,
+			RangeStart:      s.DhcpStart,
+			RangeEnd:        s.DhcpEnd,
+			Reservations:    v.Reservations,
+			MACReservations: hosts[s.ID],
+			DNS:             filterIPv4Addrs(s.DNS),
+			NTP:             s.NTP,
+			Domain:          s.Domain,
+			Options: DHCPScopeOptions{
+				Search:        s.DHCP.Search,
+				LeaseTime:     s.DHCP.LeaseTime,
+				RenewTime:     s.DHCP.RenewTime,
+				RebindTime:    s.DHCP.RebindTime,
+				BootFile:      s.DHCP.BootFile,
+				NextServer:    s.DHCP.NextServer,
+				VendorOptions: s.DHCP.VendorOptions,
+			},
+			Policies:     dhcpPolicyTags(v.DhcpPolicies),
+			FailoverPeer: s.FailoverPeer,
+		}
+		if row.MACReservations == nil {
+			row.MACReservations = []DHCPScopeHost{}
+		}
+		for _, list := range []*[]string{&row.DNS, &row.NTP, &row.Options.Search, &row.Options.VendorOptions} {
+			if *list == nil {
+				*list = []string{}
+			}
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+// filterIPv4Addrs drops IPv6 resolvers from a mixed DNS list; DHCPv4 can only
+// hand out IPv4 servers.
+func filterIPv4Addrs(values []string) []string {
+	var out []string
+	for _, value := range values {
+		if strings.Contains(value, ":") {
+			continue
+		}
+		out = append(out, value)
+	}
+	return out
+}
+
+// renderDHCPScopes writes the scopes as CSV or JSON.
+func renderDHCPScopes(scopes []DHCPScope, format string) (string, string, error) {
+	switch format {
+	case "", "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		_ = w.Write([]string{"site", "vrf", "vlan", "name", "cidr", "network", "mask", "gateway", "range_start", "range_end",
+			"reservations", "mac_reservations", "dns", "ntp", "domain", "search", "lease_time", "renew_time", "rebind_time",
+			"boot_file", "next_server", "vendor_options", "policies", "failover_peer"})
+		for _, s := range scopes {
+			var hosts []string
+			for _, h := range s.MACReservations {
+				hosts = append(hosts, strings.TrimSpace(h.MAC+" "+h.IP+" "+h.Hostname))
+			}
+			_ = w.Write([]string{s.Site, s.VRF, itoa(s.VLAN), s.Name, s.CIDR, s.Network, s.Mask, s.Gateway, s.RangeStart, s.RangeEnd,
+				s.Reservations, strings.Join(hosts, "\n"), strings.Join(s.DNS, ","), strings.Join(s.NTP, ","), s.Domain,
+				strings.Join(s.Options.Search, ","), optionalSeconds(s.Options.LeaseTime), optionalSeconds(s.Options.RenewTime),
+				optionalSeconds(s.Options.RebindTime), s.Options.BootFile, s.Options.NextServer,
+				strings.Join(s.Options.VendorOptions, "\n"), strings.Join(s.Policies, ","), s.FailoverPeer})
+		}
+		w.Flush()
+		return b.String(), "csv", w.Error()
+	case "json":
+		out, err := json.MarshalIndent(scopes, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		return string(out) + "\n", "json", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q (use csv or json)", format)
+}
+
+func optionalSeconds(v int) string {
+	if v <= 0 {
+		return ""
+	}
+	return itoa(v)
+}
@@ -0,0 +1,261 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	dhcpRolePrimary   = "primary"
+	dhcpRoleSecondary = "secondary"
+)
+
+// DHCPFailoverPeer is one site's primary/secondary pair as seen from the
+// server the config is generated for (Self) and its partner (Peer).
+type DHCPFailoverPeer struct {
+	Name          string
+	Site          string
+	Role          string
+	Primary       string
+	Secondary     string
+	PrimaryName   string
+	SecondaryName string
+	Self          string
+	Peer          string
+	SelfName      string
+}
+
+func normalizeDHCPRole(raw string) string {
+	if strings.ToLower(strings.TrimSpace(raw)) == dhcpRoleSecondary {
+		return dhcpRoleSecondary
+	}
+	return dhcpRolePrimary
+}
+
+func validateDHCPFailover(primary, secondary string) error {
+	primary = strings.TrimSpace(primary)
+	secondary = strings.TrimSpace(secondary)
+	if primary == "" && secondary == "" {
+		return nil
+	}
+	if primary == "" || secondary == "" {
+		return fmt.Errorf("dhcp failover needs both primary and secondary servers")
+	}
+	for _, raw := range []string{primary, secondary} {
+		if _, err := netip.ParseAddr(raw); err != nil {
+			return fmt.Errorf("invalid dhcp failover server %q", raw)
+		}
+	}
+	if primary == secondary {
+		return fmt.Errorf("dhcp failover servers must differ")
+	}
+	return nil
+}
+
+func siteFailoverPeer(site Site, role string) (DHCPFailoverPeer, bool) {
+	primary := strings.TrimSpace(nullString(site.DhcpFailoverPrimary))
+	secondary := strings.TrimSpace(nullString(site.DhcpFailoverSecondary))
+	if primary == "" || secondary == "" {
+		return DHCPFailoverPeer{}, false
+	}
+	base := safeName(site.Name)
+	peer := DHCPFailoverPeer{
+		Name:          "subnetio-" + base,
+		Site:          site.Name,
+		Role:          normalizeDHCPRole(role),
+		Primary:       primary,
+		Secondary:     secondary,
+		PrimaryName:   base + "-primary",
+		SecondaryName: base + "-secondary",
+	}
+	if peer.Role == dhcpRoleSecondary {
+		peer.Self, peer.Peer, peer.SelfName = secondary, primary, peer.SecondaryName
+	} else {
+		peer.Self, peer.Peer, peer.SelfName = primary, secondary, peer.PrimaryName
+	}
+	return peer, true
+}
+
+// buildFailoverPeers lists the pairs of sites that have DHCP-enabled segments
+// in the output, in segment order.
+func buildFailoverPeers(sites []Site, segments []renderSegment, role string) []DHCPFailoverPeer {
+	bySite := map[string]Site{}
+	for _, s := range sites {
+		bySite[s.Name] = s
+	}
+	seen := map[string]bool{}
+	var out []DHCPFailoverPeer
+	for _, seg := range segments {
+		if !seg.DhcpEnabled || seen[seg.Site] {
+			continue
+		}
+		seen[seg.Site] = true
+		if peer, ok := siteFailoverPeer(bySite[seg.Site], role); ok {
+			out = append(out, peer)
+		}
+	}
+	return out
+}
+
+type keaConfig struct {
+	Dhcp4 keaDhcp4 `json:"Dhcp4"`
+}
+
+type keaDhcp4 struct {
+	InterfacesConfig keaInterfaces `json:"interfaces-config"`
+	LeaseDatabase    keaLeaseDB    `json:"lease-database"`
+	HooksLibraries   []keaHook     `json:"hooks-libraries,omitempty"`
+	Subnet4          []keaSubnet   `json:"subnet4"`
+}
+
+type keaInterfaces struct {
+	Interfaces []string `json:"interfaces"`
+}
+
+type keaLeaseDB struct {
+	Type    string `json:"type"`
+	Persist bool   `json:"persist"`
+	Name    string `json:"name"`
+}
+
+type keaHook struct {
+	Library    string         `json:"library"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+type keaHARelationship struct {
+	ThisServerName    string    `json:"this-server-name"`
+	Mode              string    `json:"mode"`
+	HeartbeatDelay    int       `json:"heartbeat-delay"`
+	MaxResponseDelay  int       `json:"max-response-delay"`
+	MaxAckDelay       int       `json:"max-ack-delay"`
+	MaxUnackedClients int       `json:"max-unacked-clients"`
+	Peers             []keaPeer `json:"peers"`
+}
+
+type keaPeer struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	Role         string `json:"role"`
+	AutoFailover bool   `json:"auto-failover"`
+}
+
+type keaSubnet struct {
+	ID            int64          `json:"id"`
+	Subnet        string         `json:"subnet"`
+	Pools         []keaPool      `json:"pools,omitempty"`
+	OptionData    []keaOption    `json:"option-data,omitempty"`
+	ValidLifetime int            `json:"valid-lifetime,omitempty"`
+	RenewTimer    int            `json:"renew-timer,omitempty"`
+	RebindTimer   int            `json:"rebind-timer,omitempty"`
+	NextServer    string         `json:"next-server,omitempty"`
+	BootFileName  string         `json:"boot-file-name,omitempty"`
+	UserContext   map[string]any `json:"user-context,omitempty"`
+}
+
+type keaPool struct {
+	Pool string `json:"pool"`
+}
+
+type keaOption struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+func keaPeerURL(addr string) string {
+	if a, err := netip.ParseAddr(addr); err == nil && a.Is6() {
+		addr = "[" + addr + "]"
+	}
+	return "http://" + addr + ":8000/"
+}
+
+// renderKeaDhcp4 builds a Kea DHCPv4 config for the DHCP-enabled segments,
+// with one HA relationship per site failover pair.
+func renderKeaDhcp4(ctx TemplateContext) (string, error) {
+	cfg := keaConfig{Dhcp4: keaDhcp4{
+		InterfacesConfig: keaInterfaces{Interfaces: []string{"*"}},
+		LeaseDatabase:    keaLeaseDB{Type: "memfile", Persist: true, Name: "/var/lib/kea/kea-leases4.csv"},
+		Subnet4:          []keaSubnet{},
+	}}
+	peers := map[string]DHCPFailoverPeer{}
+	if len(ctx.Failover) > 0 {
+		var relationships []keaHARelationship
+		for _, p := range ctx.Failover {
+			peers[p.Name] = p
+			relationships = append(relationships, keaHARelationship{
+				ThisServerName:    p.SelfName,
+				Mode:              "load-balancing",
+				HeartbeatDelay:    10000,
+				MaxResponseDelay:  60000,
+				MaxAckDelay:       5000,
+				MaxUnackedClients: 5,
+				Peers: []keaPeer{
+					{Name: p.PrimaryName, URL: keaPeerURL(p.Primary), Role: dhcpRolePrimary, AutoFailover: true},
+					{Name: p.SecondaryName, URL: keaPeerURL(p.Secondary), Role: dhcpRoleSecondary, AutoFailover: true},
+				},
+			})
+		}
+		cfg.Dhcp4.HooksLibraries = []keaHook{
+			{Library: "/usr/lib/kea/hooks/libdhcp_lease_cmds.so"},
+			{Library: "/usr/lib/kea/hooks/libdhcp_ha.so", Parameters: map[string]any{"high-availability": relationships}},
+		}
+	}
+	for _, s := range ctx.Segments {
+		if !s.DhcpEnabled {
+			continue
+		}
+		subnet := keaSubnet{
+			ID:            s.ID,
+			Subnet:        fmt.Sprintf("%s/%d", s.Network, s.PrefixBits),
+			ValidLifetime: s.DHCP.LeaseTime,
+			RenewTimer:    s.DHCP.RenewTime,
+			RebindTimer:   s.DHCP.RebindTime,
+			NextServer:    s.DHCP.NextServer,
+			BootFileName:  s.DHCP.BootFile,
+		}
+		if s.DhcpStart != "" && s.DhcpEnd != "" {
+			subnet.Pools = []keaPool{{Pool: s.DhcpStart + " - " + s.DhcpEnd}}
+		}
+		subnet.OptionData = append(subnet.OptionData, keaOption{Name: "routers", Data: s.Gateway})
+		if len(s.DNS) > 0 {
+			subnet.OptionData = append(subnet.OptionData, keaOption{Name: "domain-name-servers", Data: strings.Join(s.DNS, ", ")})
+		}
+		if s.Domain != "" {
+			subnet.OptionData = append(subnet.OptionData, keaOption{Name: "domain-name", Data: s.Domain})
+		}
+		if len(s.NTP) > 0 {
+			subnet.OptionData = append(subnet.OptionData, keaOption{Name: "ntp-servers", Data: strings.Join(s.NTP, ", ")})
+		}
+		if len(s.DHCP.Search) > 0 {
+			subnet.OptionData = append(subnet.OptionData, keaOption{Name: "domain-search", Data: strings.Join(s.DHCP.Search, ", ")})
+		}
+		if p, ok := peers[s.FailoverPeer]; ok && len(peers) > 1 {
+			subnet.UserContext = map[string]any{"ha-server-name": p.SelfName}
+		}
+		cfg.Dhcp4.Subnet4 = append(cfg.Dhcp4.Subnet4, subnet)
+	}
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func saveSiteFailover(db sqlConn, siteID int64, primary, secondary string) error {
+	if err := validateDHCPFailover(primary, secondary); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO site_meta(site_id, dhcp_failover_primary, dhcp_failover_secondary)
+		VALUES(?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET
+			dhcp_failover_primary=excluded.dhcp_failover_primary,
+			dhcp_failover_secondary=excluded.dhcp_failover_secondary`,
+		siteID, nullStringToAny(strings.TrimSpace(primary)), nullStringToAny(strings.TrimSpace(secondary)),
+	)
+	return err
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type DHCPPolicy struct {
+	ID             int64
+	ProjectID      int64
+	Tag            string
+	Priority       int
+	DhcpSearch     sql.NullString
+	DhcpLeaseTime  sql.NullInt64
+	DhcpRenewTime  sql.NullInt64
+	DhcpRebindTime sql.NullInt64
+	DhcpBootFile   sql.NullString
+	DhcpNextServer sql.NullString
+	DhcpVendorOpts sql.NullString
+}
+
+func normalizePolicyTag(raw string) string {
+	return strings.ToLower(strings.TrimSpace(raw))
+}
+
+func listDHCPPolicies(db *sql.DB, projectID int64) ([]DHCPPolicy, error) {
+	rows, err := db.Query(`
+		SELECT id, project_id, tag, priority, dhcp_search, dhcp_lease_time, dhcp_renew_time, dhcp_rebind_time,
+			dhcp_boot_file, dhcp_next_server, dhcp_vendor_options
+		FROM dhcp_tag_policies
+		WHERE project_id=?
+		ORDER BY priority, tag`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []DHCPPolicy
+	for rows.Next() {
+		var p DHCPPolicy
+		if err := rows.Scan(&p.ID, &p.ProjectID, &p.Tag, &p.Priority, &p.DhcpSearch, &p.DhcpLeaseTime, &p.DhcpRenewTime, &p.DhcpRebindTime,
+			&p.DhcpBootFile, &p.DhcpNextServer, &p.DhcpVendorOpts); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func dhcpPolicyByID(db *sql.DB, id int64) (DHCPPolicy, bool) {
+	var p DHCPPolicy
+	err := db.QueryRow(`
+		SELECT id, project_id, tag, priority, dhcp_search, dhcp_lease_time, dhcp_renew_time, dhcp_rebind_time,
+			dhcp_boot_file, dhcp_next_server, dhcp_vendor_options
+		FROM dhcp_tag_policies WHERE id=?`, id).Scan(&p.ID, &p.ProjectID, &p.Tag, &p.Priority, &p.DhcpSearch, &p.DhcpLeaseTime, &p.DhcpRenewTime, &p.DhcpRebindTime,
+		&p.DhcpBootFile, &p.DhcpNextServer, &p.DhcpVendorOpts)
+	if err != nil {
+		return DHCPPolicy{}, false
+	}
+	return p, true
+}
+
+func dhcpPolicyByTag(db *sql.DB, projectID int64, tag string) (DHCPPolicy, bool) {
+	var id int64
+	if err := db.QueryRow(`SELECT id FROM dhcp_tag_policies WHERE project_id=? AND tag=?`, projectID, normalizePolicyTag(tag)).Scan(&id); err != nil {
+		return DHCPPolicy{}, false
+	}
+	return dhcpPolicyByID(db, id)
+}
+
+func saveDHCPPolicy(db *sql.DB, p DHCPPolicy) (int64, error) {
+	p.Tag = normalizePolicyTag(p.Tag)
+	if p.ProjectID <= 0 {
+		return 0, errors.New("project id required")
+	}
+	if p.Tag == "" {
+		return 0, errors.New("tag is required")
+	}
+	_, err := db.Exec(`
+		INSERT INTO dhcp_tag_policies(project_id, tag, priority, dhcp_search, dhcp_lease_time, dhcp_renew_time, dhcp_rebind_time,
+			dhcp_boot_file, dhcp_next_server, dhcp_vendor_options)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id, tag) DO UPDATE SET
+			priority=excluded.priority,
+			dhcp_search=excluded.dhcp_search,
+			dhcp_lease_time=excluded.dhcp_lease_time,
+			dhcp_renew_time=excluded.dhcp_renew_time,
+			dhcp_rebind_time=excluded.dhcp_rebind_time,
+			dhcp_boot_file=excluded.dhcp_boot_file,
+			dhcp_next_server=excluded.dhcp_next_server,
+			dhcp_vendor_options=excluded.dhcp_vendor_options`,
+		p.ProjectID,
+		p.Tag,
+		p.Priority,
+		nullStringToAny(strings.TrimSpace(p.DhcpSearch.String)),
+		nullIntToAny(p.DhcpLeaseTime),
+		nullIntToAny(p.DhcpRenewTime),
+		nullIntToAny(p.DhcpRebindTime),
+		nullStringToAny(strings.TrimSpace(p.DhcpBootFile.String)),
+		nullStringToAny(strings.TrimSpace(p.DhcpNextServer.String)),
+		nullStringToAny(strings.TrimSpace(p.DhcpVendorOpts.String)),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = db.QueryRow(`SELECT id FROM dhcp_tag_policies WHERE project_id=? AND tag=?`, p.ProjectID, p.Tag).Scan(&id)
+	return id, err
+}
+
+func segmentTagSet(s Segment) map[string]bool {
+	out := map[string]bool{}
+	if !s.Tags.Valid {
+		return out
+	}
+	for _, tag := range strings.Split(s.Tags.String, ",") {
+		if tag = normalizePolicyTag(tag); tag != "" {
+			out[tag] = true
+		}
+	}
+	return out
+}
+
+func matchDHCPPolicies(s Segment, policies []DHCPPolicy) []DHCPPolicy {
+	if len(policies) == 0 {
+		return nil
+	}
+	tags := segmentTagSet(s)
+	var out []DHCPPolicy
+	for _, p := range policies {
+		if tags[p.Tag] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func attachDHCPPolicies(views []SegmentView, policies []DHCPPolicy) []SegmentView {
+	for i := range views {
+		views[i].DhcpPolicies = matchDHCPPolicies(views[i].Segment, policies)
+	}
+	return views
+}
+
+// Policies are applied in ascending priority, so the highest priority wins for
+// scalar options; vendor option lines accumulate on top of the site defaults.
+func applyDHCPPolicies(base DHCPOptions, policies []DHCPPolicy, domain string) DHCPOptions {
+	if len(policies) == 0 {
+		return base
+	}
+	opts := base
+	opts.VendorOptions = append([]string(nil), base.VendorOptions...)
+	for _, p := range policies {
+		if p.DhcpSearch.Valid {
+			raw := strings.TrimSpace(p.DhcpSearch.String)
+			opts.SearchRaw = raw
+			opts.Search = parseCSV(raw)
+		}
+		if p.DhcpLeaseTime.Valid {
+			opts.LeaseTime = int(p.DhcpLeaseTime.Int64)
+		}
+		if p.DhcpRenewTime.Valid {
+			opts.RenewTime = int(p.DhcpRenewTime.Int64)
+		}
+		if p.DhcpRebindTime.Valid {
+			opts.RebindTime = int(p.DhcpRebindTime.Int64)
+		}
+		if p.DhcpBootFile.Valid {
+			opts.BootFile = strings.TrimSpace(p.DhcpBootFile.String)
+		}
+		if p.DhcpNextServer.Valid {
+			opts.NextServer = strings.TrimSpace(p.DhcpNextServer.String)
+		}
+		if p.DhcpVendorOpts.Valid {
+			opts.VendorOptions = append(opts.VendorOptions, parseLines(p.DhcpVendorOpts.String)...)
+		}
+	}
+	opts.VendorOptionsRaw = strings.Join(opts.VendorOptions, "\n")
+	return normalizeDHCPOptions(opts, domain)
+}
+
+func dhcpPolicyTags(policies []DHCPPolicy) []string {
+	out := make([]string, 0, len(policies))
+	for _, p := range policies {
+		out = append(out, p.Tag)
+	}
+	return out
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// DiagramSite is a site of the plan diagram with its segments grouped by VRF.
+type DiagramSite struct {
+	Name string
+	VRFs []DiagramVRF
+}
+
+type DiagramVRF struct {
+	Name     string
+	Segments []DiagramSegment
+}
+
+type DiagramSegment struct {
+	UID    string
+	Name   string
+	VLAN   int
+	CIDRs  []string
+	Locked bool
+}
+
+// Label lists the name, the VLAN and every prefix, one per line.
+func (s DiagramSegment) Label() []string {
+	lines := []string{s.Name, "VLAN " + itoa(s.VLAN)}
+	if len(s.CIDRs) == 0 {
+		return append(lines, "not allocated")
+	}
+	return append(lines, s.CIDRs...)
+}
+
+// buildNetworkDiagram groups the segments by site and VRF.
+func buildNetworkDiagram(projectName string, sites []Site, segs []Segment) []DiagramSite {
+	bySite := map[int64]map[string][]DiagramSegment{}
+	for _, s := range segs {
+		vrfs := bySite[s.SiteID]
+		if vrfs == nil {
+			vrfs = map[string][]DiagramSegment{}
+			bySite[s.SiteID] = vrfs
+		}
+		var cidrs []string
+		if s.CIDR.Valid && strings.TrimSpace(s.CIDR.String) != "" {
+			cidrs = append(cidrs, strings.TrimSpace(s.CIDR.String))
+		}
+		for _, p := range segmentSecondaryPrefixes(s) {
+			cidrs = append(cidrs, p.String())
+		}
+		if s.CIDRV6.Valid && strings.TrimSpace(s.CIDRV6.String) != "" {
+			cidrs = append(cidrs, strings.TrimSpace(s.CIDRV6.String))
+		}
+		vrf := strings.TrimSpace(s.VRF)
+		vrfs[vrf] = append(vrfs[vrf], DiagramSegment{
+			UID:    stableID(planRowSegment, projectName, s.Site, s.VRF, itoa(s.VLAN), s.Name),
+			Name:   s.Name,
+			VLAN:   s.VLAN,
+			CIDRs:  cidrs,
+			Locked: s.Locked,
+		})
+	}
+	out := make([]DiagramSite, 0, len(sites))
+	for _, site := range sites {
+		ds := DiagramSite{Name: site.Name}
+		names := make([]string, 0, len(bySite[site.ID]))
+		for vrf := range bySite[site.ID] {
+			names = append(names, vrf)
+		}
+		sort.Strings(names)
+		for _, vrf := range names {
+			members := bySite[site.ID][vrf]
+			sort.SliceStable(members, func(i, j int) bool {
+				if members[i].VLAN != members[j].VLAN {
+					return members[i].VLAN < members[j].VLAN
+				}
+				return members[i].Name < members[j].Name
+			})
+			ds.VRFs = append(ds.VRFs, DiagramVRF{Name: vrf, Segments: members})
+		}
+		out = append(out, ds)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func diagramVRFLabel(name string) string {
+	if name == "" {
+		return "VRF default"
+	}
+	return "VRF " + name
+}
+
+type drawioFile struct {
+	XMLName xml.Name      `xml:"mxfile"`
+	Host    string        `xml:"host,attr"`
+	Diagram drawioDiagram `xml:"diagram"`
+}
+
+type drawioDiagram struct {
+	ID    string           `xml:"id,attr"`
+	Name  string           `xml:"name,attr"`
+	Model drawioGraphModel `xml:"mxGraphModel"`
+}
+
+type drawioGraphModel struct {
+	Cells []drawioCell `xml:"root>mxCell"`
+}
+
+type drawioCell struct {
+	ID       string          `xml:"id,attr"`
+	Value    string          `xml:"value,attr,omitempty"`
+	Style    string          `xml:"style,attr,omitempty"`
+	Vertex   string          `xml:"vertex,attr,omitempty"`
+	Parent   string          `xml:"parent,attr,omitempty"`
+	Geometry *drawioGeometry `xml:"mxGeometry,omitempty"`
+}
+
+type drawioGeometry struct {
+	X      int    `xml:"x,attr"`
+	Y      int    `xml:"y,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	As     string `xml:"as,attr"`
+}
+
+// Layout of the draw.io diagram: sites side by side, VRFs stacked inside a
+// site and segments stacked inside a VRF.
+const (
+	drawioHeader   = 30
+	drawioPad      = 10
+	drawioNodeW    = 200
+	drawioNodeH    = 60
+	drawioSiteGap  = 40
+	drawioVRFWidth = drawioNodeW + 2*drawioPad
+)
+
+const (
+	drawioSiteStyle    = "swimlane;startSize=30;fillColor=#dae8fc;strokeColor=#6c8ebf;fontStyle=1;"
+	drawioVRFStyle     = "swimlane;startSize=30;fillColor=#f5f5f5;strokeColor=#666666;"
+	drawioSegmentStyle = "rounded=1;whiteSpace=wrap;html=1;fillColor=#ffffff;"
+	drawioLockedStyle  = "rounded=1;whiteSpace=wrap;html=1;fillColor=#fff2cc;strokeColor=#d6b656;"
+)
+
+func drawioNodeHeight(s DiagramSegment) int {
+	if extra := len(s.Label()) - 3; extra > 0 {
+		return drawioNodeH + 15*extra
+	}
+	return drawioNodeH
+}
+
+// renderDrawio writes the diagram as an uncompressed draw.io file.
+func renderDrawio(projectName string, sites []DiagramSite) ([]byte, error) {
+	cells := []drawioCell{{ID: "0"}, {ID: "1", Parent: "0"}}
+	x := 0
+	for _, site := range sites {
+		siteID := stableID(planRowSite, projectName, site.Name)
+		siteIndex := len(cells)
+		cells = append(cells, drawioCell{ID: siteID, Value: site.Name, Style: drawioSiteStyle, Vertex: "1", Parent: "1"})
+		y := drawioHeader + drawioPad
+		for _, vrf := range site.VRFs {
+			vrfID := siteID + "/" + stableID("vrf", vrf.Name)
+			vrfIndex := len(cells)
+			cells = append(cells, drawioCell{ID: vrfID, Value: diagramVRFLabel(vrf.Name), Style: drawioVRFStyle, Vertex: "1", Parent: siteID})
+			segY := drawioHeader + drawioPad
+			for _, seg := range vrf.Segments {
+				lines := seg.Label()
+				for i := range lines {
+					lines[i] = html.EscapeString(lines[i])
+				}
+				lines[0] = "<b>" + lines[0] + "</b>"
+				style := drawioSegmentStyle
+				if seg.Locked {
+					style = drawioLockedStyle
+				}
+				h := drawioNodeHeight(seg)
+				cells = append(cells, drawioCell{
+					ID: seg.UID, Value: strings.Join(lines, "<br>"), Style: style, Vertex: "1", Parent: vrfID,
+					Geometry: &drawioGeometry{X: drawioPad, Y: segY, Width: drawioNodeW, Height: h, As: "geometry"},
+				})
+				segY += h + drawioPad
+			}
+			cells[vrfIndex].Geometry = &drawioGeometry{X: drawioPad, Y: y, Width: drawioVRFWidth, Height: segY, As: "geometry"}
+			y += segY + drawioPad
+		}
+		width := drawioVRFWidth + 2*drawioPad
+		cells[siteIndex].Geometry = &drawioGeometry{X: x, Y: 0, Width: width, Height: y, As: "geometry"}
+		x += width + drawioSiteGap
+	}
+	file := drawioFile{
+		Host: "subnetio",
+		Diagram: drawioDiagram{
+			ID:    stableID("diagram", projectName),
+			Name:  projectName,
+			Model: drawioGraphModel{Cells: cells},
+		},
+	}
+	out, err := xml.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// renderDOT writes the diagram as Graphviz DOT with sites and VRFs as
+// clusters.
+func renderDOT(projectName string, sites []DiagramSite) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(projectName))
+	b.WriteString("  graph [rankdir=LR, fontname=\"Helvetica\"];\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"white\", fontname=\"Helvetica\"];\n")
+	for _, site := range sites {
+		siteID := stableID(planRowSite, projectName, site.Name)
+		fmt.Fprintf(&b, "  subgraph %s {\n", dotQuote("cluster_"+siteID))
+		fmt.Fprintf(&b, "    label=%s;\n    style=\"filled\";\n    fillcolor=\"#dae8fc\";\n", dotQuote(site.Name))
+		if len(site.VRFs) == 0 {
+			fmt.Fprintf(&b, "    %s [label=\"no segments\", style=\"dashed\"];\n", dotQuote(siteID+"_empty"))
+		}
+		for _, vrf := range site.VRFs {
+			fmt.Fprintf(&b, "    subgraph %s {\n", dotQuote("cluster_"+siteID+"/"+stableID("vrf", vrf.Name)))
+			fmt.Fprintf(&b, "      label=%s;\n      fillcolor=\"#f5f5f5\";\n", dotQuote(diagramVRFLabel(vrf.Name)))
+			for _, seg := range vrf.Segments {
+				attrs := ""
+				if seg.Locked {
+					attrs = ", fillcolor=\"#fff2cc\""
+				}
+				fmt.Fprintf(&b, "      %s [label=%s%s];\n", dotQuote(seg.UID), dotQuote(strings.Join(seg.Label(), "\n")), attrs)
+			}
+			b.WriteString("    }\n")
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dataVersionEpoch changes on every start, so versions counted in memory
+// never repeat an earlier tag.
+var dataVersionEpoch = func() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}()
+
+type dataVersionKey struct {
+	db        *sql.DB
+	projectID int64
+}
+
+// dataWrites counts successful write requests per project; 0 stands for
+// writes that named no project.
+var dataWrites = struct {
+	sync.Mutex
+	counts map[dataVersionKey]int64
+}{counts: map[dataVersionKey]int64{}}
+
+func bumpDataVersion(db *sql.DB, projectID int64) {
+	dataWrites.Lock()
+	dataWrites.counts[dataVersionKey{db: db, projectID: projectID}]++
+	dataWrites.Unlock()
+}
+
+func projectDataVersion(db *sql.DB, projectID int64, reads bool) (string, error) {
+	query := `SELECT COALESCE(MAX(id), 0) FROM audit_log WHERE project_id=? AND action<>'read'`
+	if reads {
+		query = `SELECT COALESCE(MAX(id), 0) FROM audit_log WHERE project_id=?`
+	}
+	var project, global int64
+	if err := db.QueryRow(query, projectID).Scan(&project); err != nil {
+		return "", err
+	}
+	if err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM audit_log WHERE project_id IS NULL AND action<>'read'`).Scan(&global); err != nil {
+		return "", err
+	}
+	dataWrites.Lock()
+	writes := dataWrites.counts[dataVersionKey{db: db, projectID: projectID}]
+	anyWrites := dataWrites.counts[dataVersionKey{db: db}]
+	dataWrites.Unlock()
+	return fmt.Sprintf("%s/%d/%d/%d/%d/%s", dataVersionEpoch, project, global, writes, anyWrites, time.Now().UTC().Format("2006-01-02")), nil
+}
+
+// exportETag is a weak tag: the same data renders the same content, but
+// not necessarily the same bytes (timestamps, archive metadata).
+func exportETag(version string, req *http.Request, projectID int64) string {
+	sum := sha256.Sum256([]byte(version + "\n" + itoa64(projectID) + "\n" + req.URL.Path + "?" + req.URL.Query().Encode()))
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches uses the weak comparison RFC 9110 prescribes for GET.
+func etagMatches(header, etag string) bool {
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter adds the ETag header to successful responses only, so errors
+// are never revalidated.
+type etagWriter struct {
+	gin.ResponseWriter
+	etag string
+}
+
+func (w *etagWriter) tag() {
+	if !w.Written() && w.Status() >= 200 && w.Status() < 300 {
+		w.Header().Set("ETag", w.etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+}
+
+func (w *etagWriter) WriteHeaderNow() {
+	w.tag()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	w.tag()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	w.tag()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *etagWriter) Flush() {
+	w.tag()
+	w.ResponseWriter.Flush()
+}
+
+// dataVersionMiddleware counts successful writes per project and answers
+// conditional export downloads with 304 Not Modified.
+func dataVersionMiddleware(db *sql.DB, defaultProjectID int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isMutatingMethod(c.Request.Method) {
+			c.Next()
+			if status := c.Writer.Status(); status >= 200 && status < 400 {
+				projectID := parseProjectID(c.PostForm("project_id"))
+				if projectID <= 0 {
+					projectID = parseProjectID(c.Query("project_id"))
+				}
+				bumpDataVersion(db, projectID)
+			}
+			return
+		}
+		path := c.Request.URL.Path
+		if c.Request.Method != http.MethodGet || !strings.HasPrefix(path, "/export/") || c.FullPath() == "" {
+			c.Next()
+			return
+		}
+		projectID := resolveActiveProjectID(c, db, defaultProjectID)
+		version, err := projectDataVersion(db, projectID, strings.HasPrefix(path, "/export/audit/"))
+		if err != nil {
+			c.Next()
+			return
+		}
+		etag := exportETag(version, c.Request, projectID)
+		if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+			c.Header("ETag", etag)
+			c.Header("Cache-Control", "private, no-cache")
+			c.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+		c.Writer = &etagWriter{ResponseWriter: c.Writer, etag: etag}
+		c.Next()
+	}
+}
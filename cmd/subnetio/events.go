@@ -0,0 +1,135 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// liveEventsPoll is how often a stream checks the audit log without being
+	// woken.
+	liveEventsPoll = 5 * time.Second
+	// liveEventsHeartbeat keeps idle streams open through proxies.
+	liveEventsHeartbeat = 25 * time.Second
+	liveEventsBatch     = 100
+)
+
+// auditBroadcast wakes every waiting stream when an audit entry is written.
+type auditBroadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+var auditEvents = &auditBroadcast{ch: make(chan struct{})}
+
+func (b *auditBroadcast) wait() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ch
+}
+
+func (b *auditBroadcast) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+// LiveEvent is one audit entry pushed to open pages of the project.
+type LiveEvent struct {
+	ID          int64  `json:"id"`
+	At          string `json:"at"`
+	Actor       string `json:"actor"`
+	Action      string `json:"action"`
+	EntityType  string `json:"entity_type"`
+	EntityLabel string `json:"entity_label,omitempty"`
+}
+
+func latestAuditID(db *sql.DB, projectID int64) (int64, error) {
+	var id int64
+	err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM audit_log WHERE project_id=?`, projectID).Scan(&id)
+	return id, err
+}
+
+func auditEventsSince(db *sql.DB, projectID, afterID int64, limit int) ([]LiveEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, created_at, actor, action, entity_type, COALESCE(entity_label, '')
+		FROM audit_log
+		WHERE project_id=? AND id>?
+		ORDER BY id
+		LIMIT ?`, projectID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []LiveEvent
+	for rows.Next() {
+		var ev LiveEvent
+		if err := rows.Scan(&ev.ID, &ev.At, &ev.Actor, &ev.Action, &ev.EntityType, &ev.EntityLabel); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+// streamProjectEvents serves the project's audit entries as server-sent events
+// (event "audit", id = audit entry id) until the client goes away.
+func streamProjectEvents(c *gin.Context, db *sql.DB, projectID int64) {
+	lastID, err := strconv.ParseInt(strings.TrimSpace(c.GetHeader("Last-Event-ID")), 10, 64)
+	if err != nil || lastID < 0 {
+		if lastID, err = latestAuditID(db, projectID); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	w := c.Writer
+	fmt.Fprint(w, "retry: 5000\n\n")
+	w.Flush()
+
+	poll := time.NewTicker(liveEventsPoll)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(liveEventsHeartbeat)
+	defer heartbeat.Stop()
+	ctx := c.Request.Context()
+	for {
+		wake := auditEvents.wait()
+		events, err := auditEventsSince(db, projectID, lastID, liveEventsBatch)
+		if err != nil {
+			return
+		}
+		for _, ev := range events {
+			payload, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: audit\ndata: %s\n\n", ev.ID, payload)
+			lastID = ev.ID
+		}
+		if len(events) > 0 {
+			w.Flush()
+		}
+		if len(events) == liveEventsBatch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-poll.C:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			w.Flush()
+		}
+	}
+}
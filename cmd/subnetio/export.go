@@ -6,7 +6,11 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/xuri/excelize/v2"
@@ -34,6 +38,8 @@ type ExportSite struct {
 	NTP            string `json:"ntp" yaml:"ntp"`
 	GatewayPolicy  string `json:"gateway_policy" yaml:"gateway_policy"`
 	ReservedRanges string `json:"reserved_ranges" yaml:"reserved_ranges"`
+	BGPASN         string `json:"bgp_asn,omitempty" yaml:"bgp_asn,omitempty"`
+	VLANDomain     string `json:"vlan_domain,omitempty" yaml:"vlan_domain,omitempty"`
 }
 
 type ExportPool struct {
@@ -54,6 +60,7 @@ type ExportSegment struct {
 	CIDR          string `json:"cidr" yaml:"cidr"`
 	PrefixV6      string `json:"prefix_v6" yaml:"prefix_v6"`
 	CIDRV6        string `json:"cidr_v6" yaml:"cidr_v6"`
+	Secondary     string `json:"secondary_cidrs,omitempty" yaml:"secondary_cidrs,omitempty"`
 	Mask          string `json:"mask" yaml:"mask"`
 	Network       string `json:"network" yaml:"network"`
 	Broadcast     string `json:"broadcast" yaml:"broadcast"`
@@ -79,9 +86,11 @@ type ExportDHCP struct {
 	Gateway      string `json:"gateway" yaml:"gateway"`
 	DhcpRange    string `json:"dhcp_range" yaml:"dhcp_range"`
 	Reservations string `json:"dhcp_reservations" yaml:"dhcp_reservations"`
+	Policies     string `json:"dhcp_policies,omitempty" yaml:"dhcp_policies,omitempty"`
 }
 
 type ExportConflict struct {
+	ID     string `json:"id" yaml:"id"`
 	Level  string `json:"level" yaml:"level"`
 	Kind   string `json:"kind" yaml:"kind"`
 	Detail string `json:"detail" yaml:"detail"`
@@ -149,6 +158,9 @@ func exportAuditCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 		"reason",
 		"before_json",
 		"after_json",
+		"project_id",
+		"prev_hash",
+		"hash",
 	}); err != nil {
 		return err
 	}
@@ -157,6 +169,10 @@ func exportAuditCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 		if row.EntityID.Valid {
 			entityID = itoa64(row.EntityID.Int64)
 		}
+		projectID := ""
+		if row.ProjectID.Valid {
+			projectID = itoa64(row.ProjectID.Int64)
+		}
 		_ = w.Write([]string{
 			row.CreatedAt,
 			row.Actor,
@@ -167,6 +183,9 @@ func exportAuditCSV(c *gin.Context, db *sql.DB, projectID int64) error {
 			nullString(row.Reason),
 			nullString(row.BeforeJSON),
 			nullString(row.AfterJSON),
+			projectID,
+			row.PrevHash,
+			row.Hash,
 		})
 	}
 	w.Flush()
@@ -188,6 +207,101 @@ func exportAuditJSON(c *gin.Context, db *sql.DB, projectID int64) error {
 	return nil
 }
 
+type auditStreamRecord struct {
+	ID          int64           `json:"id"`
+	ProjectID   *int            `json:"project_id,omitempty"`
+	Actor       string          `json:"actor"`
+	Action      string          `json:"action"`
+	EntityType  string          `json:"entity_type"`
+	EntityID    *int            `json:"entity_id,omitempty"`
+	EntityLabel string          `json:"entity_label,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+	PrevHash    string          `json:"prev_hash,omitempty"`
+	Hash        string          `json:"hash,omitempty"`
+}
+
+const (
+	auditStreamDefaultLimit = 1000
+	auditStreamMaxLimit     = 10000
+)
+
+func parseAuditCursor(c *gin.Context) (AuditCursor, error) {
+	cursor := AuditCursor{Limit: auditStreamDefaultLimit}
+	if raw := strings.TrimSpace(c.Query("since_id")); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 0 {
+			return cursor, fmt.Errorf("invalid since_id")
+		}
+		cursor.SinceID = id
+	}
+	if raw := strings.TrimSpace(c.Query("since_time")); raw != "" {
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return cursor, fmt.Errorf("invalid since_time, expected RFC3339")
+		}
+		cursor.SinceTime = ts.UTC().Format(time.RFC3339)
+	}
+	if raw := strings.TrimSpace(c.Query("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return cursor, fmt.Errorf("invalid limit")
+		}
+		if limit > auditStreamMaxLimit {
+			limit = auditStreamMaxLimit
+		}
+		cursor.Limit = limit
+	}
+	return cursor, nil
+}
+
+func exportAuditNDJSON(c *gin.Context, db *sql.DB, projectID int64, cursor AuditCursor) error {
+	rows, err := listAuditEntriesSince(db, projectID, cursor)
+	if err != nil {
+		return err
+	}
+	next := cursor.SinceID
+	if len(rows) > 0 {
+		next = rows[len(rows)-1].ID
+	}
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("X-Audit-Next-Since-Id", itoa64(next))
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	for _, row := range rows {
+		if err := enc.Encode(auditStreamRow(row)); err != nil {
+			return err
+		}
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func auditStreamRow(entry AuditEntry) auditStreamRecord {
+	out := auditStreamRecord{
+		ID:          entry.ID,
+		ProjectID:   nullIntPtr(entry.ProjectID),
+		Actor:       entry.Actor,
+		Action:      entry.Action,
+		EntityType:  entry.EntityType,
+		EntityID:    nullIntPtr(entry.EntityID),
+		EntityLabel: nullString(entry.EntityLabel),
+		Reason:      nullString(entry.Reason),
+		CreatedAt:   entry.CreatedAt,
+		PrevHash:    entry.PrevHash,
+		Hash:        entry.Hash,
+	}
+	if entry.BeforeJSON.Valid && json.Valid([]byte(entry.BeforeJSON.String)) {
+		out.Before = json.RawMessage(entry.BeforeJSON.String)
+	}
+	if entry.AfterJSON.Valid && json.Valid([]byte(entry.AfterJSON.String)) {
+		out.After = json.RawMessage(entry.AfterJSON.String)
+	}
+	return out
+}
+
 func buildExportBundle(db *sql.DB, projectID int64) (ExportBundle, error) {
 	project := ExportProject{ID: projectID, Name: "Default"}
 	if p, ok := projectByID(db, projectID); ok {
@@ -208,6 +322,8 @@ func buildExportBundle(db *sql.DB, projectID int64) (ExportBundle, error) {
 	rules, _ := getProjectRules(db, projectID)
 	statuses, conflicts := analyzeAll(segments, pools, sites, rules)
 	views := buildSegmentViews(segments, statuses, pools)
+	policies, _ := listDHCPPolicies(db, projectID)
+	views = attachDHCPPolicies(views, policies)
 
 	bundle := ExportBundle{
 		Project:   project,
@@ -220,12 +336,12 @@ func buildExportBundle(db *sql.DB, projectID int64) (ExportBundle, error) {
 	return bundle, nil
 }
 
-func projectByID(db *sql.DB, id int64) (Project, bool) {
+func projectByID(db sqlConn, id int64) (Project, bool) {
 	if id <= 0 {
 		return Project{}, false
 	}
 	var p Project
-	if err := db.QueryRow(`SELECT id, name, description FROM projects WHERE id=?`, id).Scan(&p.ID, &p.Name, &p.Description); err != nil {
+	if err := db.QueryRow(`SELECT id, name, description, archived, sandbox, COALESCE(routing_domain, ''), audit_reads FROM projects WHERE id=?`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Archived, &p.Sandbox, &p.RoutingDomain, &p.AuditReads); err != nil {
 		return Project{}, false
 	}
 	return p, true
@@ -242,6 +358,8 @@ func exportSites(sites []Site) []ExportSite {
 			NTP:            nullString(s.NTP),
 			GatewayPolicy:  nullString(s.GatewayPolicy),
 			ReservedRanges: nullString(s.ReservedRanges),
+			BGPASN:         asnString(s.BGPASN),
+			VLANDomain:     nullString(s.VLANDomain),
 		})
 	}
 	return out
@@ -274,6 +392,7 @@ func exportSegments(views []SegmentView) []ExportSegment {
 			CIDR:          v.CIDR,
 			PrefixV6:      nullIntString(v.PrefixV6),
 			CIDRV6:        v.CIDRV6,
+			Secondary:     nullString(v.SecondaryCIDRs),
 			Mask:          v.Mask,
 			Network:       v.Network,
 			Broadcast:     v.Broadcast,
@@ -308,6 +427,7 @@ func exportDHCP(views []SegmentView) []ExportDHCP {
 			Gateway:      v.Gateway,
 			DhcpRange:    v.DhcpRange,
 			Reservations: v.Reservations,
+			Policies:     strings.Join(dhcpPolicyTags(v.DhcpPolicies), ","),
 		})
 	}
 	return out
@@ -315,40 +435,40 @@ func exportDHCP(views []SegmentView) []ExportDHCP {
 
 func exportConflicts(conflicts []Conflict) []ExportConflict {
 	out := make([]ExportConflict, 0, len(conflicts))
-	for _, c := range conflicts {
-		out = append(out, ExportConflict{Level: c.Level, Kind: c.Kind, Detail: c.Detail})
+	for _, c := range sortConflicts(conflicts) {
+		out = append(out, ExportConflict{ID: c.ID(), Level: c.Level, Kind: c.Kind, Detail: c.Detail})
 	}
 	return out
 }
 
 func buildSitesSheet(rows []ExportSite) [][]interface{} {
-	out := [][]interface{}{{"project", "site", "region", "dns", "ntp", "gateway_policy", "reserved_ranges"}}
+	out := [][]interface{}{{"project", "site", "region", "dns", "ntp", "gateway_policy", "reserved_ranges", "bgp_asn", "vlan_domain"}}
 	for _, r := range rows {
-		out = append(out, []interface{}{r.Project, r.Name, r.Region, r.DNS, r.NTP, r.GatewayPolicy, r.ReservedRanges})
+		out = append(out, []interface{}{r.Project, r.Name, r.Region, r.DNS, r.NTP, r.GatewayPolicy, r.ReservedRanges, r.BGPASN, r.VLANDomain})
 	}
 	return out
 }
 
 func buildSegmentsSheet(rows []ExportSegment) [][]interface{} {
-	out := [][]interface{}{{"site", "vrf", "vlan", "name", "hosts", "prefix", "cidr", "prefix_v6", "cidr_v6", "mask", "network", "broadcast", "gateway", "gateway_v6", "dhcp_enabled", "dhcp_range", "reservations", "tags", "pool_tier", "notes", "locked", "status", "status_details"}}
+	out := [][]interface{}{{"site", "vrf", "vlan", "name", "hosts", "prefix", "cidr", "prefix_v6", "cidr_v6", "secondary_cidrs", "mask", "network", "broadcast", "gateway", "gateway_v6", "dhcp_enabled", "dhcp_range", "reservations", "tags", "pool_tier", "notes", "locked", "status", "status_details"}}
 	for _, r := range rows {
-		out = append(out, []interface{}{r.Site, r.VRF, r.VLAN, r.Name, r.Hosts, r.Prefix, r.CIDR, r.PrefixV6, r.CIDRV6, r.Mask, r.Network, r.Broadcast, r.Gateway, r.GatewayV6, r.DhcpEnabled, r.DhcpRange, r.Reservations, r.Tags, r.PoolTier, r.Notes, r.Locked, r.Status, r.StatusDetails})
+		out = append(out, []interface{}{r.Site, r.VRF, r.VLAN, r.Name, r.Hosts, r.Prefix, r.CIDR, r.PrefixV6, r.CIDRV6, r.Secondary, r.Mask, r.Network, r.Broadcast, r.Gateway, r.GatewayV6, r.DhcpEnabled, r.DhcpRange, r.Reservations, r.Tags, r.PoolTier, r.Notes, r.Locked, r.Status, r.StatusDetails})
 	}
 	return out
 }
 
 func buildDhcpSheet(rows []ExportDHCP) [][]interface{} {
-	out := [][]interface{}{{"site", "vrf", "vlan", "name", "cidr", "gateway", "dhcp_range", "reservations"}}
+	out := [][]interface{}{{"site", "vrf", "vlan", "name", "cidr", "gateway", "dhcp_range", "reservations", "dhcp_policies"}}
 	for _, r := range rows {
-		out = append(out, []interface{}{r.Site, r.VRF, r.VLAN, r.Name, r.CIDR, r.Gateway, r.DhcpRange, r.Reservations})
+		out = append(out, []interface{}{r.Site, r.VRF, r.VLAN, r.Name, r.CIDR, r.Gateway, r.DhcpRange, r.Reservations, r.Policies})
 	}
 	return out
 }
 
 func buildConflictsSheet(rows []ExportConflict) [][]interface{} {
-	out := [][]interface{}{{"severity", "kind", "detail"}}
+	out := [][]interface{}{{"id", "severity", "kind", "detail"}}
 	for _, r := range rows {
-		out = append(out, []interface{}{r.Level, r.Kind, r.Detail})
+		out = append(out, []interface{}{r.ID, r.Level, r.Kind, r.Detail})
 	}
 	return out
 }
@@ -0,0 +1,187 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportColumn struct {
+	Key   string
+	Label string
+	value func(v SegmentView) string
+}
+
+// segmentExportColumns lists every column the segment CSV can carry, in output
+// order.
+var segmentExportColumns = []ExportColumn{
+	{"site", "Site", func(v SegmentView) string { return v.Site }},
+	{"vlan_domain", "VLAN domain", func(v SegmentView) string { return v.VLANDomain }},
+	{"vrf", "VRF", func(v SegmentView) string { return v.VRF }},
+	{"vlan", "VLAN", func(v SegmentView) string { return itoa(v.VLAN) }},
+	{"name", "Name", func(v SegmentView) string { return v.Name }},
+	{"hosts", "Hosts", func(v SegmentView) string { return nullIntString(v.Hosts) }},
+	{"prefix", "Prefix", func(v SegmentView) string { return nullIntString(v.Prefix) }},
+	{"request", "Request", func(v SegmentView) string { return v.Request }},
+	{"cidr", "CIDR", func(v SegmentView) string { return v.CIDR }},
+	{"secondary_cidrs", "Secondary CIDRs", func(v SegmentView) string { return nullString(v.SecondaryCIDRs) }},
+	{"mask", "Mask", func(v SegmentView) string { return v.Mask }},
+	{"network", "Network", func(v SegmentView) string { return v.Network }},
+	{"broadcast", "Broadcast", func(v SegmentView) string { return v.Broadcast }},
+	{"gateway", "Gateway", func(v SegmentView) string { return v.Gateway }},
+	{"pool_label", "Pool", func(v SegmentView) string { return v.PoolLabel }},
+	{"prefix_v6", "Prefix v6", func(v SegmentView) string { return nullIntString(v.PrefixV6) }},
+	{"cidr_v6", "CIDR v6", func(v SegmentView) string { return v.CIDRV6 }},
+	{"gateway_v6", "Gateway v6", func(v SegmentView) string { return v.GatewayV6 }},
+	{"ipv6_mode", "IPv6 mode", func(v SegmentView) string { return nullString(v.IPv6Mode) }},
+	{"ipv6_dns", "IPv6 DNS", func(v SegmentView) string { return nullString(v.IPv6DNS) }},
+	{"pool_label_v6", "Pool v6", func(v SegmentView) string { return v.PoolLabelV6 }},
+	{"dhcp_enabled", "DHCP", func(v SegmentView) string { return boolString(v.DhcpEnabled) }},
+	{"dhcp_range", "DHCP range", func(v SegmentView) string { return v.DhcpRange }},
+	{"dhcp_reservations", "DHCP reservations", func(v SegmentView) string { return v.Reservations }},
+	{"dhcp_policies", "DHCP policies", func(v SegmentView) string { return strings.Join(dhcpPolicyTags(v.DhcpPolicies), ",") }},
+	{"tags", "Tags", func(v SegmentView) string { return nullString(v.Tags) }},
+	{"pool_tier", "Pool tier", func(v SegmentView) string { return nullString(v.PoolTier) }},
+	{"notes", "Notes", func(v SegmentView) string { return nullString(v.Notes) }},
+	{"locked", "Locked", func(v SegmentView) string { return boolString(v.Locked) }},
+	{"status", "Status", func(v SegmentView) string { return v.StatusLabel }},
+	{"status_details", "Status details", func(v SegmentView) string { return v.StatusDetail }},
+}
+
+var defaultSegmentExportColumns = []string{"site", "vrf", "vlan", "name", "cidr", "mask", "gateway", "dhcp_enabled", "status"}
+
+type ExportColumnChoice struct {
+	ExportColumn
+	Selected bool
+}
+
+func exportColumnChoices(selected []string) []ExportColumnChoice {
+	on := map[string]bool{}
+	for _, key := range selected {
+		on[key] = true
+	}
+	out := make([]ExportColumnChoice, 0, len(segmentExportColumns))
+	for _, col := range segmentExportColumns {
+		out = append(out, ExportColumnChoice{ExportColumn: col, Selected: on[col.Key]})
+	}
+	return out
+}
+
+func exportColumnByKey(key string) (ExportColumn, bool) {
+	for _, col := range segmentExportColumns {
+		if col.Key == key {
+			return col, true
+		}
+	}
+	return ExportColumn{}, false
+}
+
+// parseExportColumns accepts comma-separated keys, possibly split over
+// several values, and keeps the order given with duplicates removed.
+func parseExportColumns(values []string) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, raw := range values {
+		for _, part := range strings.Split(raw, ",") {
+			key := strings.ToLower(strings.TrimSpace(part))
+			if key == "" || seen[key] {
+				continue
+			}
+			if _, ok := exportColumnByKey(key); !ok {
+				return nil, fmt.Errorf("unknown export column %q", key)
+			}
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+func getExportColumns(db *sql.DB, projectID int64) []string {
+	var raw string
+	if err := db.QueryRow(`SELECT columns FROM export_columns WHERE project_id=?`, projectID).Scan(&raw); err != nil {
+		return defaultSegmentExportColumns
+	}
+	cols, err := parseExportColumns([]string{raw})
+	if err != nil || len(cols) == 0 {
+		return defaultSegmentExportColumns
+	}
+	return cols
+}
+
+func saveExportColumns(db *sql.DB, projectID int64, cols []string) error {
+	if len(cols) == 0 {
+		_, err := db.Exec(`DELETE FROM export_columns WHERE project_id=?`, projectID)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO export_columns(project_id, columns, updated_at)
+		VALUES(?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET columns=excluded.columns, updated_at=excluded.updated_at`,
+		projectID, strings.Join(cols, ","), time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// exportSegmentsCSV writes one row per segment with the requested columns,
+// falling back to the project's saved selection; as_of exports a past plan.
+func exportSegmentsCSV(c *gin.Context, db *sql.DB, projectID int64) error {
+	cols, err := parseExportColumns(c.QueryArray("columns"))
+	if err != nil {
+		respondError(c, 400, err)
+		return nil
+	}
+	if len(cols) == 0 {
+		cols = getExportColumns(db, projectID)
+	}
+	asOf, err := parseAsOf(c.Query("as_of"))
+	if err != nil {
+		respondError(c, 400, err)
+		return nil
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return err
+	}
+	segments, err := PlanScope{AsOf: asOf}.segments(db, projectID)
+	if err != nil {
+		return err
+	}
+	rules, _ := getProjectRules(db, projectID)
+	statuses, _ := analyzeAll(segments, pools, sites, rules)
+	views := buildSegmentViews(segments, statuses, pools)
+	policies, _ := listDHCPPolicies(db, projectID)
+	views = attachDHCPPolicies(views, policies)
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=subnetio_segments"+PlanScope{AsOf: asOf}.fileSuffix()+".csv")
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write(cols)
+	row := make([]string, len(cols))
+	for _, v := range views {
+		for i, key := range cols {
+			col, _ := exportColumnByKey(key)
+			row[i] = col.value(v)
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func boolString(v bool) string {
+	if v {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const maxFederationPlanSize = 64 << 20
+
+type FederationSource struct {
+	ID              int64  `json:"id"`
+	ProjectID       int64  `json:"project_id"`
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	RemoteProjectID int64  `json:"remote_project_id,omitempty"`
+	TokenRef        string `json:"token_ref,omitempty"`
+	PulledAt        string `json:"pulled_at,omitempty"`
+	RemoteProject   string `json:"remote_project,omitempty"`
+	PullError       string `json:"pull_error,omitempty"`
+	Pools           int    `json:"pools"`
+	Segments        int    `json:"segments"`
+}
+
+// FederatedPrefix is one cached prefix of a remote pool or segment.
+type FederatedPrefix struct {
+	Kind string
+	Site string
+	VRF  string
+	Name string
+	CIDR string
+}
+
+func listFederationSources(db *sql.DB, projectID int64) ([]FederationSource, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.project_id, s.name, s.url, s.remote_project_id, s.token_ref, s.pulled_at, s.remote_project, s.pull_error,
+			(SELECT COUNT(*) FROM federation_prefixes p WHERE p.source_id=s.id AND p.kind='pool'),
+			(SELECT COUNT(*) FROM federation_prefixes p WHERE p.source_id=s.id AND p.kind='segment')
+		FROM federation_sources s WHERE s.project_id=?
+		ORDER BY s.name`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FederationSource
+	for rows.Next() {
+		var s FederationSource
+		var remoteID sql.NullInt64
+		var token, pulledAt, remoteProject, pullError sql.NullString
+		if err := rows.Scan(&s.ID, &s.ProjectID, &s.Name, &s.URL, &remoteID, &token, &pulledAt, &remoteProject, &pullError,
+			&s.Pools, &s.Segments); err != nil {
+			return nil, err
+		}
+		s.RemoteProjectID = remoteID.Int64
+		s.TokenRef = token.String
+		s.PulledAt = pulledAt.String
+		s.RemoteProject = remoteProject.String
+		s.PullError = pullError.String
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func federationSourceByID(db *sql.DB, projectID, id int64) (FederationSource, bool) {
+	sources, err := listFederationSources(db, projectID)
+	if err != nil {
+		return FederationSource{}, false
+	}
+	for _, s := range sources {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return FederationSource{}, false
+}
+
+func validateFederationSource(s FederationSource) error {
+	if s.Name == "" {
+		return errors.New("source name is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid source url %q", s.URL)
+	}
+	if s.RemoteProjectID < 0 {
+		return errors.New("remote project id must be positive")
+	}
+	return validateSecretField("source token", s.TokenRef)
+}
+
+// saveFederationSource creates the source, or updates it when s.ID is set.
+func saveFederationSource(db *sql.DB, s FederationSource) (int64, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	s.URL = strings.TrimRight(strings.TrimSpace(s.URL), "/")
+	s.TokenRef = strings.TrimSpace(s.TokenRef)
+	if err := validateFederationSource(s); err != nil {
+		return 0, err
+	}
+	remoteID := sql.NullInt64{Int64: s.RemoteProjectID, Valid: s.RemoteProjectID > 0}
+	if s.ID > 0 {
+		_, err := db.Exec(`UPDATE federation_sources SET name=?, url=?, remote_project_id=?, token_ref=? WHERE id=? AND project_id=?`,
+			s.Name, s.URL, remoteID, nullStringToAny(s.TokenRef), s.ID, s.ProjectID)
+		return s.ID, err
+	}
+	res, err := db.Exec(`INSERT INTO federation_sources(project_id, name, url, remote_project_id, token_ref) VALUES(?, ?, ?, ?, ?)`,
+		s.ProjectID, s.Name, s.URL, remoteID, nullStringToAny(s.TokenRef))
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+			return 0, fmt.Errorf("source %q already exists", s.Name)
+		}
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func deleteFederationSource(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM federation_prefixes WHERE source_id=?`, id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM federation_sources WHERE id=?`, id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// fetchFederatedPlan reads the JSON plan export of the remote project and
+// keeps only the pool and segment prefixes.
+func fetchFederatedPlan(client *http.Client, s FederationSource) (string, []FederatedPrefix, error) {
+	endpoint := s.URL + "/export/json"
+	if s.RemoteProjectID > 0 {
+		endpoint += "?project_id=" + itoa64(s.RemoteProjectID)
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.TokenRef != "" {
+		token, err := resolveSecret(s.TokenRef)
+		if err != nil {
+			return "", nil, fmt.Errorf("source token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return "", nil, fmt.Errorf("%s: status %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	rows, err := newPlanRowReader(io.LimitReader(resp.Body, maxFederationPlanSize), "json")
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.close()
+	if _, err := rows.header(); err != nil {
+		return "", nil, fmt.Errorf("%s: not a Subnetio plan: %w", endpoint, err)
+	}
+	project := ""
+	var out []FederatedPrefix
+	add := func(kind string, row PlanRow, raw string) {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(raw)); err == nil {
+			out = append(out, FederatedPrefix{Kind: kind, Site: row.Site, VRF: row.VRF, Name: row.Name, CIDR: p.Masked().String()})
+		}
+	}
+	for {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr planRowError
+		if errors.As(err, &rowErr) {
+			continue
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", endpoint, err)
+		}
+		switch row.RowType {
+		case planRowMeta:
+			project = row.Project
+		case planRowPool:
+			add("pool", row, row.Pool)
+		case planRowSegment:
+			add("segment", row, row.CIDR)
+			add("segment", row, row.CIDRV6)
+			for _, raw := range strings.Split(row.SecondaryCIDRs, ",") {
+				add("segment", row, raw)
+			}
+		}
+	}
+	return project, out, nil
+}
+
+// pullFederationSource refreshes the cached prefixes of a source.
+func pullFederationSource(db *sql.DB, client *http.Client, s FederationSource) error {
+	project, prefixes, err := fetchFederatedPlan(client, s)
+	if err != nil {
+		_, _ = db.Exec(`UPDATE federation_sources SET pull_error=? WHERE id=?`, err.Error(), s.ID)
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM federation_prefixes WHERE source_id=?`, s.ID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, p := range prefixes {
+		if _, err := tx.Exec(`INSERT INTO federation_prefixes(source_id, kind, site, vrf, name, cidr) VALUES(?, ?, ?, ?, ?, ?)`,
+			s.ID, p.Kind, p.Site, nullStringToAny(p.VRF), nullStringToAny(p.Name), p.CIDR); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE federation_sources SET pulled_at=?, remote_project=?, pull_error=NULL WHERE id=?`,
+		time.Now().UTC().Format(time.RFC3339), nullStringToAny(project), s.ID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func listFederatedPrefixes(db *sql.DB, sourceID int64) ([]FederatedPrefix, error) {
+	rows, err := db.Query(`SELECT kind, site, vrf, name, cidr FROM federation_prefixes WHERE source_id=? ORDER BY id`, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []FederatedPrefix
+	for rows.Next() {
+		var p FederatedPrefix
+		var vrf, name sql.NullString
+		if err := rows.Scan(&p.Kind, &p.Site, &vrf, &name, &p.CIDR); err != nil {
+			return nil, err
+		}
+		p.VRF, p.Name = vrf.String, name.String
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// buildFederatedOverlaps checks the project against the cached prefixes of its
+// sources.
+func buildFederatedOverlaps(db *sql.DB, project Project) ([]CrossProjectOverlap, error) {
+	sources, err := listFederationSources(db, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := []CrossProjectOverlap{}
+	if len(sources) == 0 {
+		return out, nil
+	}
+	segsByVRF := map[string][]overlapEntry{}
+	var pools []overlapEntry
+	segs, err := listSegments(db, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segs {
+		vrf := strings.ToLower(strings.TrimSpace(s.VRF))
+		for _, prefix := range segmentPrefixes(s) {
+			segsByVRF[vrf] = append(segsByVRF[vrf], overlapEntry{
+				side:   OverlapSide{ProjectID: project.ID, Project: project.Name, Site: s.Site, Kind: "segment", ID: s.ID, Name: s.Name, CIDR: prefix.String()},
+				prefix: prefix,
+			})
+		}
+	}
+	localPools, err := listPools(db, project.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pool := range localPools {
+		if prefix, err := netip.ParsePrefix(strings.TrimSpace(pool.CIDR)); err == nil {
+			pools = append(pools, overlapEntry{
+				side:   OverlapSide{ProjectID: project.ID, Project: project.Name, Site: pool.Site, Kind: "pool", ID: pool.ID, Name: pool.Site, CIDR: prefix.Masked().String()},
+				prefix: prefix.Masked(),
+			})
+		}
+	}
+	for _, src := range sources {
+		prefixes, err := listFederatedPrefixes(db, src.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range prefixes {
+			prefix, err := netip.ParsePrefix(p.CIDR)
+			if err != nil {
+				continue
+			}
+			entry := overlapEntry{
+				side:   OverlapSide{Source: src.Name, Project: src.RemoteProject, Site: p.Site, Kind: p.Kind, Name: p.Name, CIDR: p.CIDR},
+				prefix: prefix,
+			}
+			if p.Kind == "pool" {
+				entry.side.Name = p.Site
+				pools = append(pools, entry)
+				continue
+			}
+			vrf := strings.ToLower(strings.TrimSpace(p.VRF))
+			segsByVRF[vrf] = append(segsByVRF[vrf], entry)
+		}
+	}
+	report := func(vrf, level string) func(a, b overlapEntry) {
+		return func(a, b overlapEntry) {
+			if a.side.Source != "" {
+				a, b = b, a
+			}
+			out = append(out, CrossProjectOverlap{VRF: vrf, Level: level, A: a.side, B: b.side})
+		}
+	}
+	vrfs := make([]string, 0, len(segsByVRF))
+	for vrf := range segsByVRF {
+		vrfs = append(vrfs, vrf)
+	}
+	sort.Strings(vrfs)
+	for _, vrf := range vrfs {
+		findPrefixOverlaps(segsByVRF[vrf], report(vrf, statusConflict.Label()))
+	}
+	findPrefixOverlaps(pools, report("", statusWarning.Label()))
+	return out, nil
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
@@ -12,13 +13,23 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	filterScopeProject = "project"
+	filterScopeGlobal  = "global"
+)
+
+// FilterPreset is a saved view. Project presets are visible in their project
+// only; global presets are shared with every project.
 type FilterPreset struct {
-	ID        int64
-	ProjectID int64
-	Page      string
-	Name      string
-	Query     string
-	CreatedAt string
+	ID          int64
+	ProjectID   int64
+	Page        string
+	Name        string
+	Description string
+	Scope       string
+	Query       string
+	IsDefault   bool
+	CreatedAt   string
 }
 
 type SegmentFilters struct {
@@ -29,16 +40,46 @@ type SegmentFilters struct {
 	Name   string
 }
 
+func normalizeFilterScope(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case filterScopeGlobal, "shared":
+		return filterScopeGlobal
+	}
+	return filterScopeProject
+}
+
+// URL is the deep link that opens the page with the preset applied in
+// the given project.
+func (p FilterPreset) URL(projectID int64) string {
+	values := url.Values{}
+	if projectID > 0 {
+		values.Set("project_id", itoa64(projectID))
+	}
+	values.Set("preset", itoa64(p.ID))
+	return "/" + p.Page + "?" + values.Encode()
+}
+
+const filterPresetColumns = `p.id, p.project_id, p.page, p.name, p.description, p.scope, p.query, p.created_at,
+	CASE WHEN d.preset_id IS NULL THEN 0 ELSE 1 END`
+
+func scanFilterPreset(row interface{ Scan(...any) error }) (FilterPreset, error) {
+	var preset FilterPreset
+	err := row.Scan(&preset.ID, &preset.ProjectID, &preset.Page, &preset.Name, &preset.Description,
+		&preset.Scope, &preset.Query, &preset.CreatedAt, &preset.IsDefault)
+	return preset, err
+}
+
 func listFilterPresets(db *sql.DB, projectID int64, page string) ([]FilterPreset, error) {
 	if projectID <= 0 || strings.TrimSpace(page) == "" {
 		return nil, nil
 	}
 	rows, err := db.Query(`
-		SELECT id, project_id, page, name, query, created_at
-		FROM filter_presets
-		WHERE project_id=? AND page=?
-		ORDER BY created_at DESC, id DESC
-	`, projectID, page)
+		SELECT `+filterPresetColumns+`
+		FROM filter_presets p
+		LEFT JOIN filter_preset_defaults d ON d.preset_id=p.id AND d.project_id=? AND d.page=p.page
+		WHERE p.page=? AND (p.project_id=? OR p.scope=?)
+		ORDER BY p.created_at DESC, p.id DESC
+	`, projectID, page, projectID, filterScopeGlobal)
 	if err != nil {
 		return nil, err
 	}
@@ -46,8 +87,8 @@ func listFilterPresets(db *sql.DB, projectID int64, page string) ([]FilterPreset
 
 	var out []FilterPreset
 	for rows.Next() {
-		var preset FilterPreset
-		if err := rows.Scan(&preset.ID, &preset.ProjectID, &preset.Page, &preset.Name, &preset.Query, &preset.CreatedAt); err != nil {
+		preset, err := scanFilterPreset(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, preset)
@@ -58,14 +99,69 @@ func listFilterPresets(db *sql.DB, projectID int64, page string) ([]FilterPreset
 	return out, nil
 }
 
-func saveFilterPreset(db *sql.DB, projectID int64, page, name, query string) error {
-	if projectID <= 0 {
+// filterPresetByID returns a preset visible from projectID.
+func filterPresetByID(db *sql.DB, projectID, presetID int64) (FilterPreset, bool) {
+	if projectID <= 0 || presetID <= 0 {
+		return FilterPreset{}, false
+	}
+	row := db.QueryRow(`
+		SELECT `+filterPresetColumns+`
+		FROM filter_presets p
+		LEFT JOIN filter_preset_defaults d ON d.preset_id=p.id AND d.project_id=? AND d.page=p.page
+		WHERE p.id=? AND (p.project_id=? OR p.scope=?)
+	`, projectID, presetID, projectID, filterScopeGlobal)
+	preset, err := scanFilterPreset(row)
+	if err != nil {
+		return FilterPreset{}, false
+	}
+	return preset, true
+}
+
+func defaultFilterPreset(db *sql.DB, projectID int64, page string) (FilterPreset, bool) {
+	var presetID int64
+	if err := db.QueryRow(`SELECT preset_id FROM filter_preset_defaults WHERE project_id=? AND page=?`, projectID, page).Scan(&presetID); err != nil {
+		return FilterPreset{}, false
+	}
+	return filterPresetByID(db, projectID, presetID)
+}
+
+func saveFilterPreset(db *sql.DB, preset FilterPreset) (int64, error) {
+	if preset.ProjectID <= 0 {
+		return 0, nil
+	}
+	res, err := db.Exec(`
+		INSERT INTO filter_presets(project_id, page, name, description, scope, query, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+	`, preset.ProjectID, preset.Page, preset.Name, preset.Description, normalizeFilterScope(preset.Scope),
+		preset.Query, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func updateFilterPreset(db *sql.DB, preset FilterPreset) error {
+	_, err := db.Exec(`
+		UPDATE filter_presets SET name=?, description=?, scope=?, query=? WHERE id=?
+	`, preset.Name, preset.Description, normalizeFilterScope(preset.Scope), preset.Query, preset.ID)
+	return err
+}
+
+// setDefaultFilterPreset makes presetID the project's default for page;
+// presetID 0 clears it.
+func setDefaultFilterPreset(db *sql.DB, projectID int64, page string, presetID int64) error {
+	if projectID <= 0 || strings.TrimSpace(page) == "" {
 		return nil
 	}
+	if presetID <= 0 {
+		_, err := db.Exec(`DELETE FROM filter_preset_defaults WHERE project_id=? AND page=?`, projectID, page)
+		return err
+	}
 	_, err := db.Exec(`
-		INSERT INTO filter_presets(project_id, page, name, query, created_at)
-		VALUES(?, ?, ?, ?, ?)
-	`, projectID, page, name, query, time.Now().UTC().Format(time.RFC3339))
+		INSERT INTO filter_preset_defaults(project_id, page, preset_id)
+		VALUES(?, ?, ?)
+		ON CONFLICT(project_id, page) DO UPDATE SET preset_id=excluded.preset_id
+	`, projectID, page, presetID)
 	return err
 }
 
@@ -73,8 +169,105 @@ func deleteFilterPreset(db *sql.DB, projectID int64, presetID int64, page string
 	if projectID <= 0 || presetID <= 0 || strings.TrimSpace(page) == "" {
 		return nil
 	}
-	_, err := db.Exec(`DELETE FROM filter_presets WHERE id=? AND project_id=? AND page=?`, presetID, projectID, page)
-	return err
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM filter_preset_defaults WHERE preset_id IN (
+			SELECT id FROM filter_presets WHERE id=? AND page=? AND (project_id=? OR scope=?)
+		)`, presetID, page, projectID, filterScopeGlobal); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM filter_presets WHERE id=? AND page=? AND (project_id=? OR scope=?)`,
+		presetID, page, projectID, filterScopeGlobal); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// filterPresetRequest is the body of POST/PUT /api/filters. Omitted fields
+// keep their current value on update.
+type filterPresetRequest struct {
+	ProjectID   int64   `json:"project_id"`
+	Page        string  `json:"page"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Scope       *string `json:"scope"`
+	Query       *string `json:"query"`
+	Default     *bool   `json:"default"`
+}
+
+func (r filterPresetRequest) apply(preset *FilterPreset) error {
+	if page := strings.TrimSpace(r.Page); page != "" && page != preset.Page {
+		if preset.ID > 0 || page != "segments" {
+			return fmt.Errorf("unsupported page %q", page)
+		}
+	}
+	if r.Name != nil {
+		preset.Name = strings.TrimSpace(*r.Name)
+	}
+	if r.Description != nil {
+		preset.Description = strings.TrimSpace(*r.Description)
+	}
+	if r.Scope != nil {
+		scope := strings.ToLower(strings.TrimSpace(*r.Scope))
+		if scope != filterScopeProject && scope != filterScopeGlobal && scope != "shared" {
+			return fmt.Errorf("scope must be %q or %q", filterScopeProject, filterScopeGlobal)
+		}
+		preset.Scope = normalizeFilterScope(scope)
+	}
+	if r.Query != nil {
+		preset.Query = normalizeSegmentFilterQuery(*r.Query)
+	}
+	if preset.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if preset.Query == "" {
+		return fmt.Errorf("query has no segment filters")
+	}
+	return nil
+}
+
+func filterPresetJSON(preset FilterPreset, projectID int64) gin.H {
+	return gin.H{
+		"id":          preset.ID,
+		"project_id":  preset.ProjectID,
+		"page":        preset.Page,
+		"name":        preset.Name,
+		"description": preset.Description,
+		"scope":       preset.Scope,
+		"query":       preset.Query,
+		"default":     preset.IsDefault,
+		"created_at":  preset.CreatedAt,
+		"url":         withBase(preset.URL(projectID)),
+	}
+}
+
+// resolveSegmentFilters reads filters from the request. ?preset=<id> applies a
+// saved preset; a bare /segments applies the project's default preset unless
+// ?preset=none is given.
+func resolveSegmentFilters(c *gin.Context, db *sql.DB, projectID int64) (SegmentFilters, *FilterPreset) {
+	values := c.Request.URL.Query()
+	raw := strings.TrimSpace(values.Get("preset"))
+	var preset FilterPreset
+	var ok bool
+	switch {
+	case raw == "none":
+	case raw != "":
+		if id, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			preset, ok = filterPresetByID(db, projectID, id)
+		}
+	case !filtersActive(segmentFiltersFromValues(values)):
+		preset, ok = defaultFilterPreset(db, projectID, "segments")
+	}
+	if !ok {
+		return segmentFiltersFromValues(values), nil
+	}
+	parsed, _ := url.ParseQuery(preset.Query)
+	return segmentFiltersFromValues(parsed), &preset
 }
 
 func parseSegmentFilters(c *gin.Context) SegmentFilters {
@@ -0,0 +1,234 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FormState struct {
+	Name   string
+	ID     int64
+	Values url.Values
+	Errors map[string]string
+}
+
+func newFormState(c *gin.Context, name string, id int64) *FormState {
+	if c.Request.PostForm == nil {
+		_ = c.Request.ParseForm()
+	}
+	return &FormState{Name: name, ID: id, Values: c.Request.PostForm, Errors: map[string]string{}}
+}
+
+// Fail records the first error for field.
+func (f *FormState) Fail(field, format string, args ...any) {
+	if _, ok := f.Errors[field]; !ok {
+		f.Errors[field] = fmt.Sprintf(format, args...)
+	}
+}
+
+func (f *FormState) Failed() bool {
+	return f != nil && len(f.Errors) > 0
+}
+
+func (f *FormState) Of(name string) *FormState {
+	if f == nil || f.Name != name {
+		return nil
+	}
+	return f
+}
+
+func (f *FormState) For(id int64) *FormState {
+	if f == nil || f.ID != id {
+		return nil
+	}
+	return f
+}
+
+func (f *FormState) Value(field string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Values.Get(field)
+}
+
+// Or is the submitted value of field, or fallback when this form was not the
+// one submitted.
+func (f *FormState) Or(field, fallback string) string {
+	if f == nil {
+		return fallback
+	}
+	return f.Values.Get(field)
+}
+
+// Checked reports whether a checkbox was on; edit forms send a hidden "off"
+// before the box, so any "on" counts.
+func (f *FormState) Checked(field string) bool {
+	if f == nil {
+		return false
+	}
+	for _, v := range f.Values[field] {
+		if v == "on" {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FormState) Selected(field, value string) bool {
+	return f != nil && f.Values.Get(field) == value
+}
+
+func (f *FormState) Error(field string) string {
+	if f == nil {
+		return ""
+	}
+	return f.Errors[field]
+}
+
+// Invalid is the class suffix for a rejected input.
+func (f *FormState) Invalid(field string) string {
+	if f.Error(field) == "" {
+		return ""
+	}
+	return " is-invalid"
+}
+
+func (f *FormState) trimmed(field string) string {
+	return strings.TrimSpace(f.Value(field))
+}
+
+func (f *FormState) require(field, label string) {
+	if f.trimmed(field) == "" {
+		f.Fail(field, "%s is required.", label)
+	}
+}
+
+// checkInt accepts an empty value or an integer within [lo, hi].
+func (f *FormState) checkInt(field, label string, lo, hi int64) {
+	raw := f.trimmed(field)
+	if raw == "" {
+		return
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || v < lo || v > hi {
+		f.Fail(field, "%s must be a whole number from %d to %d.", label, lo, hi)
+	}
+}
+
+// checkFunc records the error of parse, if any, against field.
+func (f *FormState) checkFunc(field string, parse func(string) error) {
+	if err := parse(f.Value(field)); err != nil {
+		f.Fail(field, "%s", err.Error())
+	}
+}
+
+const maxFormInt = 1<<31 - 1
+
+// validateSegmentForm checks the add and edit segment forms.
+func validateSegmentForm(f *FormState, create bool, defaultVRF string) {
+	if create && parseProjectID(f.Value("site_id")) <= 0 {
+		f.Fail("site_id", "Choose a site.")
+	}
+	if !create || defaultVRF == "" {
+		f.require("vrf", "VRF")
+	}
+	if f.trimmed("vlan") == "" {
+		f.require("vlan", "VLAN")
+	} else {
+		f.checkInt("vlan", "VLAN", minVLAN, maxVLAN)
+	}
+	f.require("name", "Name")
+	f.checkInt("hosts", "Hosts", 1, maxFormInt)
+	f.checkInt("prefix", "Prefix", 1, 32)
+	f.checkInt("prefix_v6", "IPv6 prefix", 1, 128)
+	f.checkFunc("ipv6_mode", func(raw string) error {
+		_, err := normalizeIPv6Mode(raw)
+		return err
+	})
+	f.checkFunc("expires_at", func(raw string) error {
+		_, err := parseSegmentExpiry(raw)
+		return err
+	})
+	f.checkFunc("owner_email", validateOwnerEmail)
+}
+
+func validatePoolForm(f *FormState, create bool) {
+	if create && parseProjectID(f.Value("site_id")) <= 0 {
+		f.Fail("site_id", "Choose a site.")
+	}
+	if f.trimmed("cidr") == "" {
+		f.require("cidr", "CIDR")
+	} else if _, err := netip.ParsePrefix(f.trimmed("cidr")); err != nil {
+		f.Fail("cidr", "%q is not a CIDR prefix such as 10.30.0.0/16.", f.trimmed("cidr"))
+	}
+	f.checkInt("priority", "Priority", -maxFormInt, maxFormInt)
+	f.checkFunc("vrf_shares", func(raw string) error {
+		_, err := vrfSharesValue(raw)
+		return err
+	})
+}
+
+func validateSiteForm(f *FormState) {
+	f.require("name", "Name")
+	f.checkFunc("bgp_asn", func(raw string) error {
+		_, err := parseASN(raw)
+		return err
+	})
+	f.checkInt("dhcp_lease_time", "Lease time", 0, maxFormInt)
+	f.checkInt("dhcp_renew_time", "Renew time", 0, maxFormInt)
+	f.checkInt("dhcp_rebind_time", "Rebind time", 0, maxFormInt)
+	f.checkFunc("owner_email", validateOwnerEmail)
+	if raw := f.trimmed("dhcp_next_server"); raw != "" {
+		if _, err := netip.ParseAddr(raw); err != nil {
+			f.Fail("dhcp_next_server", "Next server must be an IP address.")
+		}
+	}
+	if err := validateDHCPFailover(f.Value("dhcp_failover_primary"), f.Value("dhcp_failover_secondary")); err != nil {
+		field := "dhcp_failover_secondary"
+		if _, perr := netip.ParseAddr(f.trimmed("dhcp_failover_primary")); perr != nil {
+			field = "dhcp_failover_primary"
+		}
+		f.Fail(field, "%s", err.Error())
+	}
+}
+
+func validateRulesForm(f *FormState) {
+	switch f.trimmed("vlan_scope") {
+	case VlanScopeSiteVRF, VlanScopeSite, VlanScopeGlobal:
+	default:
+		f.Fail("vlan_scope", "Choose a VLAN duplication scope.")
+	}
+	switch f.trimmed("pool_strategy") {
+	case PoolStrategySpillover, PoolStrategyContig, PoolStrategyTiered:
+	default:
+		f.Fail("pool_strategy", "Choose a pool strategy.")
+	}
+	f.checkInt("oversize_threshold", "Oversize threshold", 10, 95)
+	f.checkFunc("vlan_ranges", func(raw string) error {
+		_, err := parseVLANRanges(raw)
+		return err
+	})
+	f.checkFunc("ipv6_scheme", func(raw string) error {
+		_, _, err := parseIPv6Scheme(raw)
+		return err
+	})
+}
+
+// linkSizeField names the field a linkSizes error is about.
+func linkSizeField(hosts, prefix sql.NullInt64) string {
+	switch {
+	case hosts.Valid:
+		return "hosts"
+	case prefix.Valid && prefix.Int64 != 30 && prefix.Int64 != 31:
+		return "prefix"
+	}
+	return "prefix_v6"
+}
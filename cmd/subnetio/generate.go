@@ -25,7 +25,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-const customTemplateDir = "data/templates"
+// customTemplateDir holds template overrides (TEMPLATE_DIR, default
+// data/templates).
+func customTemplateDir() string {
+	return mustEnv("TEMPLATE_DIR", "data/templates")
+}
 
 //go:embed templates/*.tmpl
 var genTemplateFS embed.FS
@@ -35,6 +39,8 @@ var defaultTemplateVersions = map[string]string{
 	"cisco":    "v1",
 	"juniper":  "v1",
 	"mikrotik": "v1",
+	"isc-dhcp": "v1",
+	"kea":      "v1",
 }
 
 var templateCommentPrefixes = map[string]string{
@@ -49,6 +55,8 @@ var templateExamples = map[string]string{
 	"cisco":    "! Example (Cisco v1)\nvlan 10\n name users\ninterface Vlan10\n description users\n ip address 10.30.10.1 255.255.255.0\n no shutdown\n",
 	"juniper":  "# Example (JunOS v1)\nset vlans vlan10 vlan-id 10\nset interfaces irb unit 10 family inet address 10.30.10.1/24\n",
 	"mikrotik": "# Example (Mikrotik v1)\n/interface vlan add name=vlan10 vlan-id=10 interface=bridge1\n/ip address add address=10.30.10.1/24 interface=vlan10\n",
+	"isc-dhcp": "# Example (ISC dhcpd v1)\nfailover peer \"subnetio-ala\" {\n  primary;\n  address 10.30.0.2;\n  peer address 10.30.0.3;\n}\nsubnet 10.30.10.0 netmask 255.255.255.0 {\n  option routers 10.30.10.1;\n  pool {\n    failover peer \"subnetio-ala\";\n    range 10.30.10.10 10.30.10.250;\n  }\n}\n",
+	"kea":      "# Example (Kea DHCPv4 v1)\n{\n  \"Dhcp4\": {\n    \"subnet4\": [\n      {\"id\": 1, \"subnet\": \"10.30.10.0/24\", \"pools\": [{\"pool\": \"10.30.10.10 - 10.30.10.250\"}]}\n    ]\n  }\n}\n",
 }
 
 type DHCPOptions struct {
@@ -73,6 +81,33 @@ type GenerateOptions struct {
 	SegmentFilter  string
 	DomainOverride string
 	ShowDiff       bool
+	DHCPRole       string
+	Family         string
+}
+
+const (
+	generateFamilyIPv4 = "ipv4"
+	generateFamilyIPv6 = "ipv6"
+	generateFamilyDual = "dual"
+)
+
+func normalizeGenerateFamily(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "ipv6", "v6", "6":
+		return generateFamilyIPv6
+	case "dual", "dual-stack", "dualstack", "both":
+		return generateFamilyDual
+	default:
+		return generateFamilyIPv4
+	}
+}
+
+func (o GenerateOptions) WantIPv4() bool {
+	return normalizeGenerateFamily(o.Family) != generateFamilyIPv6
+}
+
+func (o GenerateOptions) WantIPv6() bool {
+	return normalizeGenerateFamily(o.Family) != generateFamilyIPv4
 }
 
 type TemplateInfo struct {
@@ -93,6 +128,8 @@ func parseGenerateOptions(c *gin.Context) GenerateOptions {
 	opts.SegmentFilter = strings.TrimSpace(c.Query("filter_segment"))
 	opts.DomainOverride = strings.TrimSpace(c.Query("domain_name"))
 	opts.ShowDiff = c.Query("show_diff") != ""
+	opts.DHCPRole = normalizeDHCPRole(c.Query("dhcp_role"))
+	opts.Family = normalizeGenerateFamily(c.Query("family"))
 	if opts.Template != "" {
 		opts.IncludeVRF = c.Query("include_vrf") != ""
 		opts.IncludeVLAN = c.Query("include_vlan") != ""
@@ -133,26 +170,60 @@ func (o GenerateOptions) QueryString(projectID int64) string {
 	if o.ShowDiff {
 		v.Set("show_diff", "on")
 	}
+	if o.DHCPRole == dhcpRoleSecondary {
+		v.Set("dhcp_role", o.DHCPRole)
+	}
+	if family := normalizeGenerateFamily(o.Family); family != generateFamilyIPv4 {
+		v.Set("family", family)
+	}
 	return v.Encode()
 }
 
 type renderSegment struct {
-	Site        string
-	VRF         string
-	VLAN        int
-	Name        string
-	Prefix      netip.Prefix
-	PrefixBits  int
-	Network     string
-	Mask        string
-	Gateway     string
-	DhcpEnabled bool
-	DhcpStart   string
-	DhcpEnd     string
-	DNS         []string
-	NTP         []string
-	Domain      string
-	DHCP        DHCPOptions
+	ID           int64
+	FailoverPeer string
+	Site         string
+	ASN          int64
+	VLANDomain   string
+	VRF          string
+	VLAN         int
+	Name         string
+	Prefix       netip.Prefix
+	PrefixBits   int
+	Network      string
+	Mask         string
+	Gateway      string
+	DhcpEnabled  bool
+	DhcpStart    string
+	DhcpEnd      string
+	DNS          []string
+	NTP          []string
+	Domain       string
+	DHCP         DHCPOptions
+	Secondary    []renderSecondary
+	HasIPv4      bool
+	IPv6         *renderIPv6
+	Vars         map[string]string
+}
+
+// renderIPv6 carries the IPv6 side of a segment; nil when the segment has no
+// IPv6 prefix or the family selection excludes it. DNS is the segment's RDNSS
+// list, falling back to the IPv6 resolvers of the site.
+type renderIPv6 struct {
+	Prefix     netip.Prefix
+	Network    string
+	PrefixBits int
+	Gateway    string
+	DNS        []string
+	Mode       string
+	raFlags
+}
+
+type renderSecondary struct {
+	Network    string
+	Mask       string
+	PrefixBits int
+	Gateway    string
 }
 
 type SiteDefaults struct {
@@ -167,6 +238,9 @@ type renderVLAN struct {
 	Gateway    string
 	Mask       string
 	PrefixBits int
+	Secondary  []renderSecondary
+	HasIPv4    bool
+	IPv6       *renderIPv6
 }
 
 type GenerateMetadata struct {
@@ -184,14 +258,20 @@ type GenerateMetadata struct {
 	VRFCount        int               `json:"vrf_count" yaml:"vrf_count"`
 	VLANCount       int               `json:"vlan_count" yaml:"vlan_count"`
 	DHCPCount       int               `json:"dhcp_count" yaml:"dhcp_count"`
+	LinkCount       int               `json:"link_count,omitempty" yaml:"link_count,omitempty"`
+	DeviceCount     int               `json:"device_count,omitempty" yaml:"device_count,omitempty"`
+	NATCount        int               `json:"nat_count,omitempty" yaml:"nat_count,omitempty"`
 	Checksum        string            `json:"checksum,omitempty" yaml:"checksum,omitempty"`
 }
 
 type segmentGroup struct {
-	Site     string
-	VRF      string
-	Segments []renderSegment
-	VLANs    []renderVLAN
+	Site       string
+	ASN        int64
+	VLANDomain string
+	VRF        string
+	Vars       map[string]string
+	Segments   []renderSegment
+	VLANs      []renderVLAN
 }
 
 type TemplateContext struct {
@@ -201,6 +281,12 @@ type TemplateContext struct {
 	Groups   []segmentGroup
 	Segments []renderSegment
 	Defaults DHCPOptions
+	Failover []DHCPFailoverPeer
+	Links    []renderLink
+	Devices  []renderDevice
+	NAT      []renderNAT
+	// Vars are the template variables, see template_vars.go.
+	Vars map[string]string
 }
 
 type GenerateResult struct {
@@ -215,6 +301,36 @@ type templateSource struct {
 	Source  string
 }
 
+// generateForProject loads a project's plan and renders opts against it,
+// the same way the Generate page does.
+func generateForProject(db *sql.DB, projectID int64, opts GenerateOptions) (GenerateResult, error) {
+	views, sites, project, meta := loadGenerateInputs(db, projectID)
+	return generateConfig(opts, views, sites, project, meta)
+}
+
+func loadGenerateInputs(db *sql.DB, projectID int64) ([]SegmentView, []Site, Project, ProjectMeta) {
+	segs, _ := listSegments(db, projectID)
+	return generateInputsFor(db, projectID, segs)
+}
+
+// generateInputsFor is loadGenerateInputs for segments that may differ from
+// the stored ones, such as a renumbered copy of the plan.
+func generateInputsFor(db *sql.DB, projectID int64, segs []Segment) ([]SegmentView, []Site, Project, ProjectMeta) {
+	sites, _ := listSites(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	statuses, _ := analyzeAll(segs, pools, sites, rules)
+	views := buildSegmentViews(segs, statuses, pools)
+	dhcpPolicies, _ := listDHCPPolicies(db, projectID)
+	views = attachDHCPPolicies(views, dhcpPolicies)
+	project := Project{ID: projectID}
+	if p, ok := projectByID(db, projectID); ok {
+		project = p
+	}
+	meta, _ := getProjectMeta(db, projectID)
+	return views, sites, project, meta
+}
+
 func generateConfig(opts GenerateOptions, views []SegmentView, sites []Site, project Project, meta ProjectMeta) (GenerateResult, error) {
 	if strings.TrimSpace(opts.Template) == "" {
 		return GenerateResult{}, nil
@@ -229,35 +345,71 @@ func generateConfig(opts GenerateOptions, views []SegmentView, sites []Site, pro
 		return GenerateResult{}, err
 	}
 	opts.Template = name
+	return generateFromSource(opts, source, views, sites, project, meta)
+}
 
+// generateFromSource renders source, which need not be a stored template, as
+// template opts.Template.
+func generateFromSource(opts GenerateOptions, source templateSource, views []SegmentView, sites []Site, project Project, meta ProjectMeta) (GenerateResult, error) {
+	name := opts.Template
+	ctx, ok := buildTemplateContext(opts, source, views, sites, project, meta)
+	if !ok {
+		msg := templateCommentPrefix(name) + " no allocated segments"
+		output := strings.TrimSpace(ctx.Header + msg)
+		return GenerateResult{Output: output, Metadata: ctx.Meta, TemplateSource: source.Source}, nil
+	}
+	out, err := renderTemplate(name, source.Content, ctx)
+	if err != nil {
+		return GenerateResult{}, err
+	}
+	return GenerateResult{Output: out, Metadata: ctx.Meta, TemplateSource: source.Source}, nil
+}
+
+// buildTemplateContext is what template opts.Template is rendered with. It
+// reports false, with only Meta and Header set, when there is nothing to
+// render.
+func buildTemplateContext(opts GenerateOptions, source templateSource, views []SegmentView, sites []Site, project Project, meta ProjectMeta) (TemplateContext, bool) {
 	domain := resolveDomain(opts, meta)
 	defaults := projectDHCPDefaults(meta, domain)
 	siteDefaults := buildSiteDefaults(sites, meta)
 	dhcpBySite := buildDHCPBySite(sites, defaults, domain)
 	segments := buildRenderSegments(opts, views, sites, domain, dhcpBySite, siteDefaults)
+	links := buildRenderLinks(opts, views)
 	metadata := buildMetadata(opts, project, domain, segments, defaults, source.Version, source.Source)
-	prefix := templateCommentPrefix(name)
-	header := metadataHeader(metadata, prefix)
-
-	if len(segments) == 0 {
-		msg := prefix + " no allocated segments"
-		output := strings.TrimSpace(header + msg)
-		return GenerateResult{Output: output, Metadata: metadata, TemplateSource: source.Source}, nil
+	devices := buildRenderDevices(opts, sites)
+	nat := buildRenderNAT(opts, views, sites)
+	siteVars := buildSiteVars(sites, meta)
+	for i := range segments {
+		segments[i].Vars = siteVars[segments[i].Site]
+	}
+	for i := range devices {
+		devices[i].Vars = siteVars[devices[i].Site]
+	}
+	vars := meta.Vars
+	if v, ok := siteVars[opts.SiteFilter]; ok {
+		vars = v
 	}
+	metadata.LinkCount = len(links)
+	metadata.DeviceCount = len(devices)
+	metadata.NATCount = len(nat)
+	header := metadataHeader(metadata, templateCommentPrefix(opts.Template))
 
-	ctx := TemplateContext{
+	if len(segments) == 0 && len(links) == 0 && len(devices) == 0 {
+		return TemplateContext{Meta: metadata, Header: header}, false
+	}
+	return TemplateContext{
 		Meta:     metadata,
 		Header:   header,
 		Options:  opts,
 		Groups:   groupSegments(segments),
 		Segments: segments,
 		Defaults: defaults,
-	}
-	out, err := renderTemplate(name, source.Content, ctx)
-	if err != nil {
-		return GenerateResult{}, err
-	}
-	return GenerateResult{Output: out, Metadata: metadata, TemplateSource: source.Source}, nil
+		Failover: buildFailoverPeers(sites, segments, opts.DHCPRole),
+		Links:    links,
+		Devices:  devices,
+		NAT:      nat,
+		Vars:     vars,
+	}, true
 }
 
 func resolveDomain(opts GenerateOptions, meta ProjectMeta) string {
@@ -394,7 +546,7 @@ func buildRenderSegments(opts GenerateOptions, views []SegmentView, sites []Site
 
 	out := make([]renderSegment, 0, len(views))
 	for _, v := range views {
-		if v.CIDR == "" {
+		if isLinkSegment(v.Segment) {
 			continue
 		}
 		if opts.SiteFilter != "" && opts.SiteFilter != v.Site {
@@ -406,39 +558,75 @@ func buildRenderSegments(opts GenerateOptions, views []SegmentView, sites []Site
 		if opts.SegmentFilter != "" && !segmentFilterMatch(opts.SegmentFilter, v) {
 			continue
 		}
-		p, err := netip.ParsePrefix(v.CIDR)
-		if err != nil || !p.Addr().Is4() {
-			continue
+		defaults := siteDefaults[v.SiteID]
+		site := siteMap[v.SiteID]
+		seg := renderSegment{
+			ID:         v.ID,
+			Site:       v.Site,
+			ASN:        site.BGPASN.Int64,
+			VLANDomain: nullString(site.VLANDomain),
+			VRF:        v.VRF,
+			VLAN:       v.VLAN,
+			Name:       v.Name,
+			DNS:        defaults.DNS,
+			NTP:        defaults.NTP,
+			Domain:     domain,
 		}
-		details, ok := prefixDetailsIPv4(p)
-		if !ok {
-			continue
+		if opts.WantIPv4() && v.CIDR != "" {
+			if p, err := netip.ParsePrefix(v.CIDR); err == nil && p.Addr().Is4() {
+				if details, ok := prefixDetailsIPv4(p); ok {
+					gw := strings.TrimSpace(v.Gateway)
+					if gw == "" {
+						gw = details.FirstUsable
+					}
+					seg.HasIPv4 = true
+					seg.Prefix = p
+					seg.PrefixBits = p.Bits()
+					seg.Network = details.Network
+					seg.Mask = details.Mask
+					seg.Gateway = gw
+					seg.DhcpEnabled = v.DhcpEnabled
+					seg.DhcpStart, seg.DhcpEnd = dhcpRangeForTemplate(v, p, gw)
+					seg.DHCP = applyDHCPPolicies(dhcpBySite[v.SiteID], v.DhcpPolicies, domain)
+					if peer, ok := siteFailoverPeer(site, opts.DHCPRole); ok && v.DhcpEnabled {
+						seg.FailoverPeer = peer.Name
+					}
+					for _, sn := range v.Secondary {
+						sp, err := netip.ParsePrefix(sn.CIDR)
+						if err != nil {
+							continue
+						}
+						seg.Secondary = append(seg.Secondary, renderSecondary{Network: sn.Network, Mask: sn.Mask, PrefixBits: sp.Bits(), Gateway: sn.Gateway})
+					}
+				}
+			}
 		}
-		gw := strings.TrimSpace(v.Gateway)
-		if gw == "" {
-			gw = details.FirstUsable
+		if opts.WantIPv6() && v.CIDRV6 != "" {
+			if p, err := netip.ParsePrefix(v.CIDRV6); err == nil && p.Addr().Is6() && strings.TrimSpace(v.GatewayV6) != "" {
+				p = p.Masked()
+				mode, _ := normalizeIPv6Mode(nullString(v.IPv6Mode))
+				if mode == "" {
+					mode = ipv6ModeSLAAC
+				}
+				dns, _ := parseIPv6DNS(nullString(v.IPv6DNS))
+				if len(dns) == 0 {
+					dns = filterIPv6Addrs(defaults.DNS)
+				}
+				seg.IPv6 = &renderIPv6{
+					Prefix:     p,
+					Network:    p.Addr().String(),
+					PrefixBits: p.Bits(),
+					Gateway:    strings.TrimSpace(v.GatewayV6),
+					DNS:        dns,
+					Mode:       mode,
+					raFlags:    ipv6ModeFlags(mode),
+				}
+			}
 		}
-		dhcpStart, dhcpEnd := dhcpRangeForTemplate(v, p, gw)
-		dhcp := dhcpBySite[v.SiteID]
-		defaults := siteDefaults[v.SiteID]
-		out = append(out, renderSegment{
-			Site:        v.Site,
-			VRF:         v.VRF,
-			VLAN:        v.VLAN,
-			Name:        v.Name,
-			Prefix:      p,
-			PrefixBits:  p.Bits(),
-			Network:     details.Network,
-			Mask:        details.Mask,
-			Gateway:     gw,
-			DhcpEnabled: v.DhcpEnabled,
-			DhcpStart:   dhcpStart,
-			DhcpEnd:     dhcpEnd,
-			DNS:         defaults.DNS,
-			NTP:         defaults.NTP,
-			Domain:      domain,
-			DHCP:        dhcp,
-		})
+		if !seg.HasIPv4 && seg.IPv6 == nil {
+			continue
+		}
+		out = append(out, seg)
 	}
 	sort.Slice(out, func(i, j int) bool {
 		if out[i].Site != out[j].Site {
@@ -455,6 +643,18 @@ func buildRenderSegments(opts GenerateOptions, views []SegmentView, sites []Site
 	return out
 }
 
+// filterIPv6Addrs keeps the IPv6 resolvers from a mixed DNS list; they are
+// what router advertisements and DHCPv6 can hand out.
+func filterIPv6Addrs(values []string) []string {
+	var out []string
+	for _, value := range values {
+		if a, err := netip.ParseAddr(value); err == nil && a.Is6() {
+			out = append(out, a.String())
+		}
+	}
+	return out
+}
+
 func segmentFilterMatch(filter string, v SegmentView) bool {
 	filter = strings.TrimSpace(filter)
 	if filter == "" {
@@ -523,6 +723,12 @@ func buildMetadata(opts GenerateOptions, project Project, domain string, segment
 	if len(defaults.VendorOptions) > 0 {
 		options["dhcp_vendor_options"] = strings.Join(defaults.VendorOptions, " | ")
 	}
+	if opts.DHCPRole == dhcpRoleSecondary {
+		options["dhcp_role"] = opts.DHCPRole
+	}
+	if family := normalizeGenerateFamily(opts.Family); family != generateFamilyIPv4 {
+		options["family"] = family
+	}
 
 	filters := map[string]string{}
 	if opts.SiteFilter != "" {
@@ -575,12 +781,12 @@ func groupSegments(segments []renderSegment) []segmentGroup {
 		return nil
 	}
 	var groups []segmentGroup
-	cur := segmentGroup{Site: segments[0].Site, VRF: segments[0].VRF}
+	cur := segmentGroup{Site: segments[0].Site, ASN: segments[0].ASN, VLANDomain: segments[0].VLANDomain, VRF: segments[0].VRF, Vars: segments[0].Vars}
 	seenVLAN := map[int]bool{}
 	for _, s := range segments {
 		if s.Site != cur.Site || s.VRF != cur.VRF {
 			groups = append(groups, cur)
-			cur = segmentGroup{Site: s.Site, VRF: s.VRF}
+			cur = segmentGroup{Site: s.Site, ASN: s.ASN, VLANDomain: s.VLANDomain, VRF: s.VRF, Vars: s.Vars}
 			seenVLAN = map[int]bool{}
 		}
 		cur.Segments = append(cur.Segments, s)
@@ -591,6 +797,9 @@ func groupSegments(segments []renderSegment) []segmentGroup {
 				Gateway:    s.Gateway,
 				Mask:       s.Mask,
 				PrefixBits: s.PrefixBits,
+				Secondary:  s.Secondary,
+				HasIPv4:    s.HasIPv4,
+				IPv6:       s.IPv6,
 			})
 			seenVLAN[s.VLAN] = true
 		}
@@ -617,6 +826,15 @@ func metadataHeader(meta GenerateMetadata, prefix string) string {
 	lines = append(lines, fmt.Sprintf("%s vrfs: %d", prefix, meta.VRFCount))
 	lines = append(lines, fmt.Sprintf("%s vlans: %d", prefix, meta.VLANCount))
 	lines = append(lines, fmt.Sprintf("%s dhcp_scopes: %d", prefix, meta.DHCPCount))
+	if meta.LinkCount > 0 {
+		lines = append(lines, fmt.Sprintf("%s links: %d", prefix, meta.LinkCount))
+	}
+	if meta.DeviceCount > 0 {
+		lines = append(lines, fmt.Sprintf("%s devices: %d", prefix, meta.DeviceCount))
+	}
+	if meta.NATCount > 0 {
+		lines = append(lines, fmt.Sprintf("%s nat mappings: %d", prefix, meta.NATCount))
+	}
 	if len(meta.Options) > 0 {
 		keys := make([]string, 0, len(meta.Options))
 		for k := range meta.Options {
@@ -759,15 +977,29 @@ func normalizeTemplateName(raw string) (string, error) {
 	return name, nil
 }
 
+// loadTemplateSource reads name from the override directory or the embedded
+// templates through templateSources; only overrides cost a stat per call.
 func loadTemplateSource(name string) (templateSource, error) {
-	customPath := filepath.Join(customTemplateDir, name+".tmpl")
-	if data, err := os.ReadFile(customPath); err == nil {
+	customPath := filepath.Join(customTemplateDir(), name+".tmpl")
+	if info, err := os.Stat(customPath); err == nil {
+		if source, ok := templateSources.get(name, "override", info.ModTime(), info.Size()); ok {
+			return source, nil
+		}
+		data, err := os.ReadFile(customPath)
+		if err != nil {
+			return templateSource{}, err
+		}
 		version := "custom-" + shortHash(data)
-		return templateSource{Content: string(data), Version: version, Source: "override"}, nil
+		source := templateSource{Content: string(data), Version: version, Source: "override"}
+		templateSources.put(name, source, info.ModTime(), info.Size())
+		return source, nil
 	} else if !os.IsNotExist(err) {
 		return templateSource{}, err
 	}
 
+	if source, ok := templateSources.get(name, "embedded", time.Time{}, 0); ok {
+		return source, nil
+	}
 	data, err := genTemplateFS.ReadFile("templates/" + name + ".tmpl")
 	if err != nil {
 		return templateSource{}, errors.New("unknown template")
@@ -776,7 +1008,9 @@ func loadTemplateSource(name string) (templateSource, error) {
 	if version == "" {
 		version = "v1"
 	}
-	return templateSource{Content: string(data), Version: version, Source: "embedded"}, nil
+	source := templateSource{Content: string(data), Version: version, Source: "embedded"}
+	templateSources.put(name, source, time.Time{}, 0)
+	return source, nil
 }
 
 func listTemplateCatalog() []TemplateInfo {
@@ -784,7 +1018,7 @@ func listTemplateCatalog() []TemplateInfo {
 	for name := range defaultTemplateVersions {
 		names[name] = true
 	}
-	if entries, err := os.ReadDir(customTemplateDir); err == nil {
+	if entries, err := os.ReadDir(customTemplateDir()); err == nil {
 		for _, entry := range entries {
 			if entry.IsDir() {
 				continue
@@ -826,7 +1060,7 @@ func renderTemplate(name, body string, ctx TemplateContext) (string, error) {
 }
 
 func templateFuncs() template.FuncMap {
-	return template.FuncMap{
+	funcs := template.FuncMap{
 		"itoa":              itoa,
 		"safeName":          safeName,
 		"groupLabel":        groupLabel,
@@ -836,8 +1070,15 @@ func templateFuncs() template.FuncMap {
 		"ciscoLease":        formatCiscoLease,
 		"ciscoDomainSearch": formatCiscoDomainSearch,
 		"firstVLAN":         firstVLAN,
+		"hasIPv4":           hasIPv4Segments,
+		"hasIPv6":           hasIPv6Segments,
 		"mikrotikDhcpLine":  mikrotikDhcpLine,
+		"keaDhcp4":          renderKeaDhcp4,
 	}
+	for name, fn := range templateIPFuncs() {
+		funcs[name] = fn
+	}
+	return funcs
 }
 
 func firstVLAN(vlans []renderVLAN) int {
@@ -847,6 +1088,24 @@ func firstVLAN(vlans []renderVLAN) int {
 	return vlans[0].VLAN
 }
 
+func hasIPv4Segments(segments []renderSegment) bool {
+	for _, s := range segments {
+		if s.HasIPv4 {
+			return true
+		}
+	}
+	return false
+}
+
+func hasIPv6Segments(segments []renderSegment) bool {
+	for _, s := range segments {
+		if s.IPv6 != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func mikrotikDhcpLine(s renderSegment, opts DHCPOptions) string {
 	line := fmt.Sprintf("/ip dhcp-server network add address=%s/%d gateway=%s", s.Network, s.PrefixBits, s.Gateway)
 	if len(s.DNS) > 0 {
@@ -947,8 +1206,12 @@ func checksumSHA256(text string) string {
 }
 
 func unifiedDiff(fullScope, scoped string) string {
-	left := splitLines(fullScope)
-	right := splitLines(scoped)
+	return unifiedDiffLabeled("full-scope", "filtered-scope", fullScope, scoped)
+}
+
+func unifiedDiffLabeled(leftLabel, rightLabel, leftText, rightText string) string {
+	left := splitLines(leftText)
+	right := splitLines(rightText)
 	if len(left) == 0 && len(right) == 0 {
 		return ""
 	}
@@ -1011,8 +1274,8 @@ func unifiedDiff(fullScope, scoped string) string {
 	}
 
 	var b strings.Builder
-	b.WriteString("--- full-scope\n")
-	b.WriteString("+++ filtered-scope\n")
+	b.WriteString("--- " + leftLabel + "\n")
+	b.WriteString("+++ " + rightLabel + "\n")
 	for _, line := range lines {
 		b.WriteString(line.prefix)
 		b.WriteString(line.text)
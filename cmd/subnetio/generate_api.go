@@ -0,0 +1,113 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const generationAPI = "api"
+
+// maxInlineTemplate bounds template_body, matching the override upload limit.
+const maxInlineTemplate = 1 << 20
+
+// generateAPIRequest is the body of POST /api/v1/generate.
+type generateAPIRequest struct {
+	ProjectID    int64  `json:"project_id"`
+	Template     string `json:"template"`
+	TemplateBody string `json:"template_body"`
+	Options      struct {
+		IncludeVRF  *bool  `json:"include_vrf"`
+		IncludeVLAN *bool  `json:"include_vlan"`
+		IncludeDHCP *bool  `json:"include_dhcp"`
+		DomainName  string `json:"domain_name"`
+		DHCPRole    string `json:"dhcp_role"`
+		Family      string `json:"family"`
+	} `json:"options"`
+	Filters struct {
+		Site    string `json:"site"`
+		VRF     string `json:"vrf"`
+		Segment string `json:"segment"`
+	} `json:"filters"`
+}
+
+type generateAPIResponse struct {
+	Output       string           `json:"output"`
+	Checksum     string           `json:"checksum"`
+	Metadata     GenerateMetadata `json:"metadata"`
+	GenerationID int64            `json:"generation_id,omitempty"`
+}
+
+func boolOption(v *bool) bool {
+	return v == nil || *v
+}
+
+func (r generateAPIRequest) generateOptions() GenerateOptions {
+	return GenerateOptions{
+		Template:       strings.ToLower(strings.TrimSpace(r.Template)),
+		IncludeVRF:     boolOption(r.Options.IncludeVRF),
+		IncludeVLAN:    boolOption(r.Options.IncludeVLAN),
+		IncludeDHCP:    boolOption(r.Options.IncludeDHCP),
+		SiteFilter:     strings.TrimSpace(r.Filters.Site),
+		VRFFilter:      strings.TrimSpace(r.Filters.VRF),
+		SegmentFilter:  strings.TrimSpace(r.Filters.Segment),
+		DomainOverride: strings.TrimSpace(r.Options.DomainName),
+		DHCPRole:       normalizeDHCPRole(r.Options.DHCPRole),
+		Family:         normalizeGenerateFamily(r.Options.Family),
+	}
+}
+
+// generateAPI renders the request against the project.
+func generateAPI(db *sql.DB, c *gin.Context, projectID int64, req generateAPIRequest) (generateAPIResponse, error) {
+	opts := req.generateOptions()
+	body := req.TemplateBody
+	if strings.TrimSpace(body) == "" && opts.Template == "" {
+		return generateAPIResponse{}, errors.New("template or template_body is required")
+	}
+	if len(body) > maxInlineTemplate {
+		return generateAPIResponse{}, errors.New("template_body is too large (max 1MB)")
+	}
+
+	sites, _ := listSites(db, projectID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	statuses, _ := analyzeAll(segs, pools, sites, rules)
+	views := buildSegmentViews(segs, statuses, pools)
+	dhcpPolicies, _ := listDHCPPolicies(db, projectID)
+	views = attachDHCPPolicies(views, dhcpPolicies)
+	project := Project{ID: projectID}
+	if p, ok := projectByID(db, projectID); ok {
+		project = p
+	}
+	meta, _ := getProjectMeta(db, projectID)
+
+	var result GenerateResult
+	var err error
+	if strings.TrimSpace(body) != "" {
+		if opts.Template == "" {
+			opts.Template = "inline"
+		}
+		if opts.Template, err = normalizeTemplateName(opts.Template); err != nil {
+			return generateAPIResponse{}, err
+		}
+		data := []byte(body)
+		source := templateSource{Content: body, Version: "inline-" + shortHash(data), Source: "inline"}
+		result, err = generateFromSource(opts, source, views, sites, project, meta)
+	} else {
+		result, err = generateConfig(opts, views, sites, project, meta)
+	}
+	if err != nil {
+		return generateAPIResponse{}, err
+	}
+	out := generateAPIResponse{Output: result.Output, Checksum: checksumSHA256(result.Output), Metadata: result.Metadata}
+	out.Metadata.Checksum = out.Checksum
+	if result.TemplateSource != "inline" {
+		out.GenerationID, _ = recordGeneration(db, c, projectID, generationAPI, opts, result)
+	}
+	return out, nil
+}
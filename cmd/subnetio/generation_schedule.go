@@ -0,0 +1,440 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	generationScheduled         = "scheduled"
+	generationSchedulerInterval = time.Minute
+	defaultScheduleRetention    = 10
+	maxScheduleRetention        = 1000
+	cronSearchLimit             = 366 * 24 * 60
+)
+
+type GenerationSchedule struct {
+	ID             int64
+	ProjectID      int64
+	Template       string
+	SiteFilter     string
+	VRFFilter      string
+	SegmentFilter  string
+	Cron           string
+	Retention      int
+	Enabled        bool
+	LastRunAt      string
+	NextRunAt      string
+	LastError      string
+	CreatedAt      string
+	LatestID       int64
+	LatestChecksum string
+	LatestAt       string
+}
+
+func (s GenerationSchedule) Options() GenerateOptions {
+	return GenerateOptions{
+		Template:      s.Template,
+		IncludeVRF:    true,
+		IncludeVLAN:   true,
+		IncludeDHCP:   true,
+		SiteFilter:    s.SiteFilter,
+		VRFFilter:     s.VRFFilter,
+		SegmentFilter: s.SegmentFilter,
+	}
+}
+
+func (s GenerationSchedule) ScopeKey() string {
+	return buildScopeKey(s.Options())
+}
+
+// cronSpec is a standard five-field cron expression evaluated in UTC.
+type cronSpec struct {
+	minute, hour, dom, month, dow []bool
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+func parseCron(expr string) (cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return cronSpec{}, fmt.Errorf("cron needs 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+	var spec cronSpec
+	var err error
+	if spec.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return cronSpec{}, fmt.Errorf("minute: %w", err)
+	}
+	if spec.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return cronSpec{}, fmt.Errorf("hour: %w", err)
+	}
+	if spec.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return cronSpec{}, fmt.Errorf("day of month: %w", err)
+	}
+	if spec.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return cronSpec{}, fmt.Errorf("month: %w", err)
+	}
+	if spec.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return cronSpec{}, fmt.Errorf("day of week: %w", err)
+	}
+	if spec.dow[7] {
+		spec.dow[0] = true
+	}
+	spec.domAny = fields[2] == "*"
+	spec.dowAny = fields[4] == "*"
+	return spec, nil
+}
+
+// parseCronField accepts *, n, a-b and lists of those, each with an
+// optional /step.
+func parseCronField(field string, min, max int) ([]bool, error) {
+	out := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if base, raw, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step %q", raw)
+			}
+			part, step = base, n
+		}
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			a, b, _ := strings.Cut(part, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil || lo > hi {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			out[v] = true
+		}
+	}
+	return out, nil
+}
+
+func (s cronSpec) matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := s.dom[t.Day()], s.dow[int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute strictly after t.
+func (s cronSpec) next(t time.Time) (time.Time, bool) {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	for i := 0; i < cronSearchLimit; i++ {
+		if s.matches(t) {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
+
+func nextScheduleRun(expr string, after time.Time) (string, error) {
+	spec, err := parseCron(expr)
+	if err != nil {
+		return "", err
+	}
+	next, ok := spec.next(after)
+	if !ok {
+		return "", fmt.Errorf("cron %q never fires", expr)
+	}
+	return next.Format(time.RFC3339), nil
+}
+
+func saveGenerationSchedule(db *sql.DB, s GenerationSchedule) (int64, error) {
+	if s.ProjectID <= 0 {
+		return 0, fmt.Errorf("project is required")
+	}
+	name, err := normalizeTemplateName(s.Template)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := loadTemplateSource(name); err != nil {
+		return 0, err
+	}
+	s.Cron = strings.Join(strings.Fields(s.Cron), " ")
+	next, err := nextScheduleRun(s.Cron, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if s.Retention <= 0 {
+		s.Retention = defaultScheduleRetention
+	}
+	if s.Retention > maxScheduleRetention {
+		return 0, fmt.Errorf("retention must be at most %d", maxScheduleRetention)
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO generation_schedules(project_id, template, site_filter, vrf_filter, segment_filter, cron, retention, enabled, next_run_at, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id, template, site_filter, vrf_filter, segment_filter) DO UPDATE SET
+			cron=excluded.cron, retention=excluded.retention, enabled=excluded.enabled, next_run_at=excluded.next_run_at
+		RETURNING id`,
+		s.ProjectID, name, strings.TrimSpace(s.SiteFilter), strings.TrimSpace(s.VRFFilter), strings.TrimSpace(s.SegmentFilter),
+		s.Cron, s.Retention, boolToInt(s.Enabled), next, time.Now().UTC().Format(time.RFC3339),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, pruneScheduleArtifacts(db, id, s.Retention)
+}
+
+const generationScheduleColumns = `
+	s.id, s.project_id, s.template, s.site_filter, s.vrf_filter, s.segment_filter, s.cron, s.retention, s.enabled,
+	COALESCE(s.last_run_at, ''), COALESCE(s.next_run_at, ''), COALESCE(s.last_error, ''), s.created_at,
+	COALESCE(g.id, 0), COALESCE(g.checksum, ''), COALESCE(g.created_at, '')
+	FROM generation_schedules s
+	LEFT JOIN generations g ON g.id = (SELECT MAX(id) FROM generations WHERE schedule_id = s.id)`
+
+func scanGenerationSchedule(row interface{ Scan(...any) error }) (GenerationSchedule, error) {
+	var s GenerationSchedule
+	err := row.Scan(&s.ID, &s.ProjectID, &s.Template, &s.SiteFilter, &s.VRFFilter, &s.SegmentFilter, &s.Cron, &s.Retention, &s.Enabled,
+		&s.LastRunAt, &s.NextRunAt, &s.LastError, &s.CreatedAt, &s.LatestID, &s.LatestChecksum, &s.LatestAt)
+	return s, err
+}
+
+func listGenerationSchedules(db *sql.DB, projectID int64) ([]GenerationSchedule, error) {
+	rows, err := db.Query(`SELECT `+generationScheduleColumns+` WHERE s.project_id=? ORDER BY s.template, s.id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []GenerationSchedule
+	for rows.Next() {
+		s, err := scanGenerationSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func generationScheduleByID(db *sql.DB, id int64) (GenerationSchedule, bool) {
+	s, err := scanGenerationSchedule(db.QueryRow(`SELECT `+generationScheduleColumns+` WHERE s.id=?`, id))
+	if err != nil {
+		return GenerationSchedule{}, false
+	}
+	return s, true
+}
+
+// deleteGenerationSchedule removes the schedule together with its artifacts.
+func deleteGenerationSchedule(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM generations WHERE schedule_id=?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM generation_schedules WHERE id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func listScheduleArtifacts(db *sql.DB, scheduleID int64) ([]Generation, error) {
+	rows, err := db.Query(`
+		SELECT id, project_id, kind, template, COALESCE(template_version, ''), scope_key, checksum, COALESCE(actor, ''), created_at
+		FROM generations
+		WHERE schedule_id=?
+		ORDER BY id DESC`, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Generation
+	for rows.Next() {
+		var g Generation
+		if err := rows.Scan(&g.ID, &g.ProjectID, &g.Kind, &g.Template, &g.TemplateVersion, &g.ScopeKey, &g.Checksum, &g.Actor, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func pruneScheduleArtifacts(db *sql.DB, scheduleID int64, keep int) error {
+	_, err := db.Exec(`
+		DELETE FROM generations
+		WHERE schedule_id=? AND id NOT IN (
+			SELECT id FROM generations WHERE schedule_id=? ORDER BY id DESC LIMIT ?
+		)`, scheduleID, scheduleID, keep)
+	return err
+}
+
+// runGenerationSchedule renders one schedule and stores the output as an
+// artifact.
+func runGenerationSchedule(db *sql.DB, s GenerationSchedule, now time.Time) (int64, error) {
+	next, nextErr := nextScheduleRun(s.Cron, now)
+	id, runErr := storeScheduledGeneration(db, s, now)
+	lastError := ""
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	if _, err := db.Exec(`UPDATE generation_schedules SET last_run_at=?, next_run_at=?, last_error=? WHERE id=?`,
+		now.UTC().Format(time.RFC3339), nullStringToAny(next), nullStringToAny(lastError), s.ID); err != nil {
+		return id, err
+	}
+	if runErr != nil {
+		return 0, runErr
+	}
+	return id, nextErr
+}
+
+func storeScheduledGeneration(db *sql.DB, s GenerationSchedule, now time.Time) (int64, error) {
+	opts := s.Options()
+	result, err := generateForProject(db, s.ProjectID, opts)
+	if err != nil {
+		return 0, err
+	}
+	checksum := checksumSHA256(result.Output)
+	if s.LatestChecksum == checksum {
+		return 0, nil
+	}
+	metadata := result.Metadata
+	metadata.Checksum = checksum
+	metaBytes, err := encodeMetadataJSON(metadata)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	hash, err := putConfigBlob(tx, result.Output)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO generations(project_id, kind, template, template_version, scope_key, checksum, actor, content, content_hash, metadata, created_at, schedule_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?, ?)`,
+		s.ProjectID, generationScheduled, s.Template, nullStringToAny(metadata.TemplateVersion), s.ScopeKey(), checksum,
+		"scheduler", hash, string(metaBytes), now.UTC().Format(time.RFC3339), s.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, pruneScheduleArtifacts(db, s.ID, s.Retention)
+}
+
+// runDueGenerationSchedules runs every enabled schedule whose next run is at
+// or before now and returns how many ran.
+func runDueGenerationSchedules(db *sql.DB, now time.Time) int {
+	rows, err := db.Query(`SELECT s.id FROM generation_schedules s
+		WHERE s.enabled=1 AND s.next_run_at IS NOT NULL AND s.next_run_at<=?
+		ORDER BY s.next_run_at`, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("generation scheduler: %v", err)
+		return 0
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	ran := 0
+	for _, id := range ids {
+		s, ok := generationScheduleByID(db, id)
+		if !ok {
+			continue
+		}
+		if _, err := runGenerationSchedule(db, s, now); err != nil {
+			log.Printf("generation schedule %d (%s %s): %v", s.ID, s.Template, s.ScopeKey(), err)
+		}
+		ran++
+	}
+	return ran
+}
+
+func scheduleJSON(s GenerationSchedule) map[string]any {
+	out := map[string]any{
+		"id":          s.ID,
+		"project_id":  s.ProjectID,
+		"template":    s.Template,
+		"scope":       s.ScopeKey(),
+		"cron":        s.Cron,
+		"retention":   s.Retention,
+		"enabled":     s.Enabled,
+		"last_run_at": s.LastRunAt,
+		"next_run_at": s.NextRunAt,
+		"last_error":  s.LastError,
+	}
+	if s.LatestID > 0 {
+		out["latest"] = map[string]any{
+			"id":         s.LatestID,
+			"checksum":   s.LatestChecksum,
+			"created_at": s.LatestAt,
+			"url":        basePath + "/api/artifacts/" + itoa64(s.ID) + "/latest",
+		}
+	}
+	return out
+}
+
+func startGenerationScheduler(db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(generationSchedulerInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			runDueGenerationSchedules(db, now)
+			pruneConfigStorage(db, now)
+		}
+	}()
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	generationPreview  = "preview"
+	generationDownload = "download"
+	generationBundle   = "bundle"
+)
+
+type Generation struct {
+	ID              int64
+	ProjectID       int64
+	Kind            string
+	Template        string
+	TemplateVersion string
+	ScopeKey        string
+	Checksum        string
+	Actor           string
+	Content         string
+	Metadata        string
+	CreatedAt       string
+}
+
+func recordGeneration(db *sql.DB, c *gin.Context, projectID int64, kind string, opts GenerateOptions, result GenerateResult) (int64, error) {
+	checksum := checksumSHA256(result.Output)
+	scopeKey := buildScopeKey(opts)
+	if kind == generationPreview {
+		var last string
+		err := db.QueryRow(`
+			SELECT checksum FROM generations
+			WHERE project_id=? AND template=? AND scope_key=?
+			ORDER BY id DESC LIMIT 1`, projectID, opts.Template, scopeKey).Scan(&last)
+		if err == nil && last == checksum {
+			return 0, nil
+		}
+	}
+	metadata := result.Metadata
+	metadata.Checksum = checksum
+	metaBytes, err := encodeMetadataJSON(metadata)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	hash, err := putConfigBlob(tx, result.Output)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`
+		INSERT INTO generations(project_id, kind, template, template_version, scope_key, checksum, actor, content, content_hash, metadata, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, '', ?, ?, ?)`,
+		projectID,
+		kind,
+		opts.Template,
+		nullStringToAny(metadata.TemplateVersion),
+		scopeKey,
+		checksum,
+		nullStringToAny(auditActor(c)),
+		hash,
+		string(metaBytes),
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if r, err := configRetention(db, projectID); err == nil && r.KeepPerScope > 0 {
+		if _, err := applyConfigRetention(db, r, time.Now()); err != nil {
+			log.Printf("config retention: %v", err)
+		}
+	}
+	return id, nil
+}
+
+func recordGenerationAudit(db *sql.DB, c *gin.Context, projectID int64, kind string, opts GenerateOptions, result GenerateResult) {
+	id, err := recordGeneration(db, c, projectID, kind, opts, result)
+	if err != nil {
+		log.Printf("generation history error: %v", err)
+		return
+	}
+	writeAudit(db, c, auditRecord{
+		ProjectID:   projectID,
+		Action:      kind,
+		EntityType:  "generation",
+		EntityID:    sql.NullInt64{Int64: id, Valid: true},
+		EntityLabel: sql.NullString{String: opts.Template + " " + buildScopeKey(opts), Valid: true},
+		After: map[string]string{
+			"template": opts.Template,
+			"scope":    buildScopeKey(opts),
+			"checksum": checksumSHA256(result.Output),
+		},
+	})
+}
+
+func listGenerations(db *sql.DB, projectID int64, limit int) ([]Generation, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	rows, err := db.Query(`
+		SELECT id, project_id, kind, template, COALESCE(template_version, ''), scope_key, checksum, COALESCE(actor, ''), created_at
+		FROM generations
+		WHERE project_id=?
+		ORDER BY id DESC
+		LIMIT ?`, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Generation
+	for rows.Next() {
+		var g Generation
+		if err := rows.Scan(&g.ID, &g.ProjectID, &g.Kind, &g.Template, &g.TemplateVersion, &g.ScopeKey, &g.Checksum, &g.Actor, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func generationByID(db *sql.DB, id int64) (Generation, bool) {
+	var g Generation
+	var inline string
+	var hash, encoding sql.NullString
+	var data []byte
+	err := db.QueryRow(`
+		SELECT g.id, g.project_id, g.kind, g.template, COALESCE(g.template_version, ''), g.scope_key, g.checksum, COALESCE(g.actor, ''),
+			g.content, g.content_hash, b.data, b.encoding, COALESCE(g.metadata, ''), g.created_at
+		FROM generations g
+		LEFT JOIN config_blobs b ON b.hash=g.content_hash
+		WHERE g.id=?`, id).Scan(&g.ID, &g.ProjectID, &g.Kind, &g.Template, &g.TemplateVersion, &g.ScopeKey, &g.Checksum, &g.Actor,
+		&inline, &hash, &data, &encoding, &g.Metadata, &g.CreatedAt)
+	if err != nil {
+		return Generation{}, false
+	}
+	if g.Content, err = storedConfigContent(inline, hash, data, encoding); err != nil {
+		log.Printf("generation %d: %v", id, err)
+		return Generation{}, false
+	}
+	return g, true
+}
+
+func buildConfigBundle(template, output string, metaBytes []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	configFile, err := zw.Create("subnetio_" + template + "." + templateExtension(template))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := configFile.Write([]byte(output)); err != nil {
+		return nil, err
+	}
+	metaFile, err := zw.Create("metadata.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := metaFile.Write(metaBytes); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BatchMetadata is metadata.json of a bundle holding every template of the
+// catalog rendered for one scope.
+type BatchMetadata struct {
+	GeneratedAt string             `json:"generated_at"`
+	ProjectID   int64              `json:"project_id"`
+	ProjectName string             `json:"project_name"`
+	Options     map[string]string  `json:"options"`
+	Filters     map[string]string  `json:"filters"`
+	Templates   []GenerateMetadata `json:"templates"`
+	Failed      map[string]string  `json:"failed,omitempty"`
+}
+
+type batchOutput struct {
+	Template string
+	Result   GenerateResult
+}
+
+// generateBatch renders every template of the catalog with opts; only the
+// template differs between the outputs.
+func generateBatch(opts GenerateOptions, views []SegmentView, sites []Site, project Project, meta ProjectMeta) ([]batchOutput, BatchMetadata) {
+	batch := BatchMetadata{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		ProjectID:   project.ID,
+		ProjectName: project.Name,
+		Templates:   []GenerateMetadata{},
+	}
+	var outputs []batchOutput
+	for _, info := range listTemplateCatalog() {
+		opts.Template = info.Name
+		result, err := generateConfig(opts, views, sites, project, meta)
+		if err != nil {
+			if batch.Failed == nil {
+				batch.Failed = map[string]string{}
+			}
+			batch.Failed[info.Name] = err.Error()
+			continue
+		}
+		result.Metadata.Checksum = checksumSHA256(result.Output)
+		if batch.Options == nil {
+			batch.Options = result.Metadata.Options
+			batch.Filters = result.Metadata.Filters
+			batch.ProjectName = result.Metadata.ProjectName
+		}
+		batch.Templates = append(batch.Templates, result.Metadata)
+		outputs = append(outputs, batchOutput{Template: info.Name, Result: result})
+	}
+	return outputs, batch
+}
+
+func buildBatchBundle(outputs []batchOutput, batch BatchMetadata) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, out := range outputs {
+		f, err := zw.Create("subnetio_" + out.Template + "." + templateExtension(out.Template))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(out.Result.Output)); err != nil {
+			return nil, err
+		}
+	}
+	metaBytes, err := json.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	metaFile, err := zw.Create("metadata.json")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := metaFile.Write(metaBytes); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,397 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A project's health score is the weighted mean of component scores from 0
+// (bad) to 100 (good).
+const (
+	healthConflicts     = "conflicts"
+	healthFragmentation = "fragmentation"
+	healthOversize      = "oversize"
+	healthStaleness     = "staleness"
+	healthUtilization   = "utilization"
+
+	healthSnapshotInterval = time.Hour
+	healthTrendDays        = 30
+	healthDayLayout        = "2006-01-02"
+
+	// Data untouched for healthFreshAge scores full marks, for
+	// healthStaleAge nothing.
+	healthFreshAge = 30 * 24 * time.Hour
+	healthStaleAge = 365 * 24 * time.Hour
+
+	// Pools up to healthUtilizationSafe used score full marks, from
+	// healthUtilizationFull nothing.
+	healthUtilizationSafe = 0.70
+	healthUtilizationFull = 0.95
+)
+
+var healthComponentInfo = []struct {
+	Key    string
+	Title  string
+	Weight int
+}{
+	{healthConflicts, "Conflict density", 30},
+	{healthUtilization, "Utilization risk", 25},
+	{healthFragmentation, "Fragmentation", 15},
+	{healthOversize, "Oversized segments", 15},
+	{healthStaleness, "Stale data", 15},
+}
+
+// healthHintKinds are analysis findings that are efficiency hints rather
+// than conflicts; they feed the fragmentation and oversize components.
+var healthHintKinds = map[string]bool{
+	"POOL_FRAGMENTATION": true, "POOL_FRAGMENTATION_V6": true,
+	"POOL_GAP": true, "POOL_GAP_V6": true,
+	"OVERSIZED": true, "OVERSIZED_V6": true,
+}
+
+type HealthComponent struct {
+	Key        string `json:"key"`
+	Title      string `json:"title"`
+	Weight     int    `json:"weight"`
+	Score      int    `json:"score"`
+	Applicable bool   `json:"applicable"`
+	Detail     string `json:"detail"`
+}
+
+type ProjectHealth struct {
+	Project    summaryProject    `json:"project"`
+	ComputedAt string            `json:"computed_at"`
+	Score      int               `json:"score"`
+	Grade      string            `json:"grade"`
+	Components []HealthComponent `json:"components"`
+}
+
+// HealthPoint is one day of a project's health history: the last score
+// recorded that day.
+type HealthPoint struct {
+	Day        string         `json:"day"`
+	Score      int            `json:"score"`
+	Components map[string]int `json:"components"`
+}
+
+func healthGrade(score int) string {
+	switch {
+	case score >= 80:
+		return "good"
+	case score >= 60:
+		return "fair"
+	default:
+		return "poor"
+	}
+}
+
+// healthGradeClass is the Bootstrap color of a grade.
+func healthGradeClass(grade string) string {
+	switch grade {
+	case "good":
+		return "success"
+	case "fair":
+		return "warning"
+	default:
+		return "danger"
+	}
+}
+
+func (h ProjectHealth) GradeClass() string { return healthGradeClass(h.Grade) }
+func (p HealthPoint) GradeClass() string   { return healthGradeClass(healthGrade(p.Score)) }
+
+// ComponentText is the day's score of a component, empty when it did not
+// apply.
+func (p HealthPoint) ComponentText(key string) string {
+	if v, ok := p.Components[key]; ok {
+		return itoa(v)
+	}
+	return ""
+}
+
+// healthInput is what the score is computed from.
+type healthInput struct {
+	Segments   []Segment
+	Pools      []Pool
+	Sites      []Site
+	Conflicts  []Conflict
+	LastChange time.Time
+}
+
+// linearScore maps v to 100 at good or better and 0 at bad or worse.
+func linearScore(v, good, bad float64) int {
+	if v <= good {
+		return 100
+	}
+	if v >= bad {
+		return 0
+	}
+	return int(math.Round(100 * (bad - v) / (bad - good)))
+}
+
+func scoreProjectHealth(project Project, in healthInput, now time.Time) ProjectHealth {
+	components := map[string]*HealthComponent{}
+	h := ProjectHealth{
+		Project:    summaryProject{ID: project.ID, Name: project.Name, Archived: project.Archived, Sandbox: project.Sandbox},
+		ComputedAt: now.UTC().Format(time.RFC3339),
+	}
+	for _, info := range healthComponentInfo {
+		h.Components = append(h.Components, HealthComponent{Key: info.Key, Title: info.Title, Weight: info.Weight})
+	}
+	for i := range h.Components {
+		components[h.Components[i].Key] = &h.Components[i]
+	}
+
+	var conflicts, warnings, oversized int
+	for _, c := range in.Conflicts {
+		if strings.HasPrefix(c.Kind, "OVERSIZED") {
+			oversized++
+		}
+		if healthHintKinds[c.Kind] {
+			continue
+		}
+		switch c.Level {
+		case statusConflict.Label():
+			conflicts++
+		case statusWarning.Label():
+			warnings++
+		}
+	}
+	if c := components[healthConflicts]; len(in.Segments) > 0 || conflicts+warnings > 0 {
+		density := (float64(conflicts) + float64(warnings)/4) / math.Max(1, float64(len(in.Segments)))
+		c.Applicable = true
+		c.Score = linearScore(density, 0, 0.5)
+		c.Detail = fmt.Sprintf("%d conflicts and %d warnings across %d segments", conflicts, warnings, len(in.Segments))
+	}
+
+	allocated := 0
+	for _, s := range in.Segments {
+		if strings.TrimSpace(s.CIDR.String) != "" || strings.TrimSpace(s.CIDRV6.String) != "" {
+			allocated++
+		}
+	}
+	if c := components[healthOversize]; allocated > 0 {
+		c.Applicable = true
+		c.Score = linearScore(float64(oversized)/float64(allocated), 0, 0.5)
+		c.Detail = fmt.Sprintf("%d of %d allocated segments oversized", oversized, allocated)
+	}
+
+	reservedV4, reservedV6, _ := buildReservedIndex(in.Sites)
+	segmentsBySite := map[int64][]Segment{}
+	for _, s := range in.Segments {
+		segmentsBySite[s.SiteID] = append(segmentsBySite[s.SiteID], s)
+	}
+	var fragSum, utilSum, pools int
+	var worstFrag, worstUtil string
+	var worstFragScore, worstUtilRatio float64 = -1, -1
+	for _, p := range in.Pools {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(p.CIDR))
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+		var free *big.Int
+		var frag int
+		if prefix.Addr().Is4() {
+			var freeV4 uint64
+			_, freeV4, frag = poolGapsV4(prefix, segmentsBySite[p.SiteID], reservedV4[p.SiteID])
+			free = new(big.Int).SetUint64(freeV4)
+		} else {
+			_, free, frag = poolGapsV6(prefix, segmentsBySite[p.SiteID], reservedV6[p.SiteID])
+		}
+		total := prefixSize(prefix)
+		ratio, _ := new(big.Rat).SetFrac(new(big.Int).Sub(total, free), total).Float64()
+		pools++
+		fragSum += 100 - frag
+		utilSum += linearScore(ratio, healthUtilizationSafe, healthUtilizationFull)
+		label := p.Site + " " + prefix.String()
+		if float64(frag) > worstFragScore {
+			worstFragScore, worstFrag = float64(frag), label
+		}
+		if ratio > worstUtilRatio {
+			worstUtilRatio, worstUtil = ratio, label
+		}
+	}
+	if pools > 0 {
+		c := components[healthFragmentation]
+		c.Applicable = true
+		c.Score = int(math.Round(float64(fragSum) / float64(pools)))
+		c.Detail = fmt.Sprintf("%d pool(s); most fragmented %s (%d%%)", pools, worstFrag, int(worstFragScore))
+		c = components[healthUtilization]
+		c.Applicable = true
+		c.Score = int(math.Round(float64(utilSum) / float64(pools)))
+		c.Detail = fmt.Sprintf("%d pool(s); fullest %s (%.1f%% used)", pools, worstUtil, worstUtilRatio*100)
+	}
+
+	if c := components[healthStaleness]; !in.LastChange.IsZero() {
+		age := now.Sub(in.LastChange)
+		c.Applicable = true
+		c.Score = linearScore(age.Hours(), healthFreshAge.Hours(), healthStaleAge.Hours())
+		c.Detail = fmt.Sprintf("last change %d days ago", int(age.Hours()/24))
+	}
+
+	weighted, weights := 0, 0
+	for _, c := range h.Components {
+		if c.Applicable {
+			weighted += c.Score * c.Weight
+			weights += c.Weight
+		}
+	}
+	h.Score = 100
+	if weights > 0 {
+		h.Score = int(math.Round(float64(weighted) / float64(weights)))
+	}
+	h.Grade = healthGrade(h.Score)
+	return h
+}
+
+func buildProjectHealth(db *sql.DB, project Project, now time.Time) (ProjectHealth, error) {
+	sites, err := listSites(db, project.ID)
+	if err != nil {
+		return ProjectHealth{}, err
+	}
+	segs, err := listSegments(db, project.ID)
+	if err != nil {
+		return ProjectHealth{}, err
+	}
+	pools, err := listPools(db, project.ID)
+	if err != nil {
+		return ProjectHealth{}, err
+	}
+	rules, _ := getProjectRules(db, project.ID)
+	_, conflicts := analyzeAll(segs, pools, sites, rules)
+	in := healthInput{Segments: segs, Pools: pools, Sites: sites, Conflicts: conflicts}
+	last, err := lastProjectChange(db, project.ID)
+	if err != nil {
+		return ProjectHealth{}, err
+	}
+	if last != nil {
+		in.LastChange, _ = time.Parse(time.RFC3339, last.At)
+	}
+	return scoreProjectHealth(project, in, now), nil
+}
+
+// recordProjectHealth keeps the score as the project's value for the day;
+// later scores the same day replace it.
+func recordProjectHealth(db *sql.DB, h ProjectHealth) error {
+	at, err := time.Parse(time.RFC3339, h.ComputedAt)
+	if err != nil {
+		return err
+	}
+	components := map[string]int{}
+	for _, c := range h.Components {
+		if c.Applicable {
+			components[c.Key] = c.Score
+		}
+	}
+	raw, err := json.Marshal(components)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO project_health(project_id, day, score, components, recorded_at)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(project_id, day) DO UPDATE SET
+			score=excluded.score, components=excluded.components, recorded_at=excluded.recorded_at`,
+		h.Project.ID, at.Format(healthDayLayout), h.Score, string(raw), h.ComputedAt)
+	return err
+}
+
+// projectHealthTrend returns the recorded days of the last days days,
+// oldest first.
+func projectHealthTrend(db *sql.DB, projectID int64, days int, now time.Time) ([]HealthPoint, error) {
+	if days <= 0 {
+		days = healthTrendDays
+	}
+	since := now.UTC().AddDate(0, 0, -days+1).Format(healthDayLayout)
+	rows, err := db.Query(`SELECT day, score, components FROM project_health WHERE project_id=? AND day>=? ORDER BY day`, projectID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []HealthPoint{}
+	for rows.Next() {
+		var p HealthPoint
+		var raw string
+		if err := rows.Scan(&p.Day, &p.Score, &raw); err != nil {
+			return nil, err
+		}
+		p.Components = map[string]int{}
+		_ = json.Unmarshal([]byte(raw), &p.Components)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// latestProjectHealth returns the last recorded score of every project, for
+// the dashboard.
+func latestProjectHealth(db *sql.DB) (map[int64]HealthPoint, error) {
+	rows, err := db.Query(`
+		SELECT h.project_id, h.day, h.score
+		FROM project_health h
+		WHERE h.day = (SELECT MAX(day) FROM project_health WHERE project_id = h.project_id)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]HealthPoint{}
+	for rows.Next() {
+		var id int64
+		var p HealthPoint
+		if err := rows.Scan(&id, &p.Day, &p.Score); err != nil {
+			return nil, err
+		}
+		out[id] = p
+	}
+	return out, rows.Err()
+}
+
+// buildAllProjectHealth scores every project that is neither archived nor a
+// sandbox, worst first, and records the scores.
+func buildAllProjectHealth(db *sql.DB, now time.Time) ([]ProjectHealth, error) {
+	projects, err := listProjects(db)
+	if err != nil {
+		return nil, err
+	}
+	out := []ProjectHealth{}
+	for _, p := range projects {
+		if p.Archived || p.Sandbox {
+			continue
+		}
+		h, err := buildProjectHealth(db, p, now)
+		if err != nil {
+			return nil, err
+		}
+		if err := recordProjectHealth(db, h); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score < out[j].Score })
+	return out, nil
+}
+
+// startHealthSnapshots records every project's score once an hour, so the
+// trend has a point per day even when nobody looks at it.
+func startHealthSnapshots(db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(healthSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			if _, err := buildAllProjectHealth(db, time.Now()); err != nil {
+				log.Printf("health snapshot: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
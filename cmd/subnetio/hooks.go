@@ -0,0 +1,215 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Allocation hooks let an instance add its own policies around allocation
+// without changing the allocator.
+const (
+	allocationHookPre  = "pre"
+	allocationHookPost = "post"
+
+	defaultAllocationHookTimeout = 10 * time.Second
+)
+
+type allocationHookRequest struct {
+	Stage     string                 `json:"stage"`
+	ProjectID int64                  `json:"project_id"`
+	Project   string                 `json:"project"`
+	Actor     string                 `json:"actor"`
+	Proposal  auditAllocationSummary `json:"proposal"`
+}
+
+func allocationHook(stage string) string {
+	if stage == allocationHookPre {
+		return strings.TrimSpace(mustEnv("ALLOCATION_HOOK_PRE", ""))
+	}
+	return strings.TrimSpace(mustEnv("ALLOCATION_HOOK_POST", ""))
+}
+
+func allocationHookTimeout() time.Duration {
+	raw := mustEnv("ALLOCATION_HOOK_TIMEOUT", "")
+	if raw == "" {
+		return defaultAllocationHookTimeout
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		log.Printf("invalid ALLOCATION_HOOK_TIMEOUT %q, using %s", raw, defaultAllocationHookTimeout)
+		return defaultAllocationHookTimeout
+	}
+	return timeout
+}
+
+func isHookURL(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+// hookCommand is the argv of a command hook: a JSON array such as
+// ["/opt/hooks/check guest", "--strict"], or words split on whitespace
+// without any quoting.
+func hookCommand(hook string) ([]string, error) {
+	var args []string
+	if strings.HasPrefix(hook, "[") {
+		if err := json.Unmarshal([]byte(hook), &args); err != nil {
+			return nil, fmt.Errorf("invalid hook command %q: %v", hook, err)
+		}
+	} else {
+		args = strings.Fields(hook)
+	}
+	if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+		return nil, fmt.Errorf("hook %q names no command", hook)
+	}
+	return args, nil
+}
+
+// runAllocationHook sends payload to hook.
+func runAllocationHook(hook string, timeout time.Duration, payload allocationHookRequest) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out []byte
+	if isHookURL(hook) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Subnetio-Hook", payload.Stage)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("hook unreachable: %v", err)
+		}
+		defer resp.Body.Close()
+		out, _ = io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return hookMessage(out, "status "+itoa(resp.StatusCode))
+		}
+	} else {
+		args, err := hookCommand(hook)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(body)
+		cmd.Env = append(os.Environ(), "SUBNETIO_HOOK_STAGE="+payload.Stage)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("hook timed out after %s", timeout)
+			}
+			if stderr.Len() > 0 {
+				return hookMessage(stderr.Bytes(), err.Error())
+			}
+			return hookMessage(stdout.Bytes(), err.Error())
+		}
+		out = stdout.Bytes()
+	}
+	var verdict validatorResponse
+	if json.Unmarshal(out, &verdict) == nil && verdict.Allowed != nil && !*verdict.Allowed {
+		return hookMessage(out, "allocation not allowed")
+	}
+	return nil
+}
+
+// hookMessage is the message of a hook's JSON answer, its raw output, or
+// fallback.
+func hookMessage(raw []byte, fallback string) error {
+	var verdict validatorResponse
+	message := ""
+	if json.Unmarshal(raw, &verdict) == nil {
+		message = strings.TrimSpace(verdict.Message)
+	} else {
+		message = strings.TrimSpace(string(raw))
+	}
+	if message == "" {
+		message = fallback
+	}
+	return errors.New(message)
+}
+
+// allocationHookCheck runs the pre hook on a proposed allocation, or returns
+// nil when none is configured.
+func allocationHookCheck(project Project, actor string) allocationCheck {
+	hook := allocationHook(allocationHookPre)
+	if hook == "" {
+		return nil
+	}
+	timeout := allocationHookTimeout()
+	return func(before, after []Segment) error {
+		summary := buildAllocationSummary(before, after)
+		if len(summary.Changes) == 0 {
+			return nil
+		}
+		err := runAllocationHook(hook, timeout, allocationHookRequest{
+			Stage:     allocationHookPre,
+			ProjectID: project.ID,
+			Project:   project.Name,
+			Actor:     actor,
+			Proposal:  summary,
+		})
+		if err != nil {
+			return &validatorError{Message: "rejected by pre-allocation hook: " + err.Error()}
+		}
+		return nil
+	}
+}
+
+// notifyAllocationHook runs the post hook on a committed allocation. The
+// server calls it in its own goroutine so the hook never delays a response.
+func notifyAllocationHook(project Project, actor string, summary auditAllocationSummary) {
+	hook := allocationHook(allocationHookPost)
+	if hook == "" || len(summary.Changes) == 0 {
+		return
+	}
+	err := runAllocationHook(hook, allocationHookTimeout(), allocationHookRequest{
+		Stage:     allocationHookPost,
+		ProjectID: project.ID,
+		Project:   project.Name,
+		Actor:     actor,
+		Proposal:  summary,
+	})
+	if err != nil {
+		log.Printf("post-allocation hook for project %s: %v", project.Name, err)
+	}
+}
+
+// chainAllocationChecks runs checks in order and stops at the first error.
+func chainAllocationChecks(checks ...allocationCheck) allocationCheck {
+	var active []allocationCheck
+	for _, check := range checks {
+		if check != nil {
+			active = append(active, check)
+		}
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	return func(before, after []Segment) error {
+		for _, check := range active {
+			if err := check(before, after); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// maxIdempotentBody caps the request body buffered to hash a keyed request.
+const maxIdempotentBody = 64 << 20
+
+type idempotentResponse struct {
+	RequestHash string
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+type captureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func idempotencyTTL() time.Duration {
+	raw := mustEnv("IDEMPOTENCY_TTL", "")
+	if raw == "" {
+		return defaultIdempotencyTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid IDEMPOTENCY_TTL %q, using %s", raw, defaultIdempotencyTTL)
+		return defaultIdempotencyTTL
+	}
+	return ttl
+}
+
+// idempotencyLocks serializes the requests that share a key, so concurrent
+// retries do not both run the handler while other keys proceed.
+type idempotencyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*idempotencyLock
+}
+
+type idempotencyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *idempotencyLocks) lock(key string) func() {
+	l.mu.Lock()
+	k := l.locks[key]
+	if k == nil {
+		k = &idempotencyLock{}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+	k.Lock()
+	return func() {
+		k.Unlock()
+		l.mu.Lock()
+		k.refs--
+		if k.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func idempotencyMiddleware(db *sql.DB, ttl time.Duration) gin.HandlerFunc {
+	locks := &idempotencyLocks{locks: map[string]*idempotencyLock{}}
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(idempotencyHeader))
+		if key == "" || !isMutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			abortWithProblem(c, 400, fmt.Errorf("idempotency key too long"))
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			raw, err := io.ReadAll(io.LimitReader(c.Request.Body, maxIdempotentBody+1))
+			if err != nil {
+				abortWithProblem(c, 400, fmt.Errorf("read body: %v", err))
+				return
+			}
+			if len(raw) > maxIdempotentBody {
+				abortWithProblem(c, 413, fmt.Errorf("request body over %d MiB cannot be used with %s", maxIdempotentBody>>20, idempotencyHeader))
+				return
+			}
+			body = raw
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		hash := idempotencyRequestHash(c.Request, body)
+
+		defer locks.lock(key)()
+
+		_ = purgeIdempotencyKeys(db, time.Now().Add(-ttl))
+		stored, ok, err := getIdempotentResponse(db, key)
+		if err != nil {
+			abortWithProblem(c, 500, err)
+			return
+		}
+		if ok {
+			if stored.RequestHash != hash {
+				abortWithProblem(c, 422, fmt.Errorf("idempotency key reused with a different request"))
+				return
+			}
+			replayIdempotentResponse(c, stored)
+			return
+		}
+
+		writer := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		status := writer.Status()
+		if status >= 500 {
+			return
+		}
+		resp := idempotentResponse{
+			RequestHash: hash,
+			Status:      status,
+			ContentType: writer.Header().Get("Content-Type"),
+			Location:    writer.Header().Get("Location"),
+			Body:        writer.body.Bytes(),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if err := saveIdempotentResponse(db, key, resp); err != nil {
+			log.Printf("idempotency save %s: %v", key, err)
+		}
+	}
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func idempotencyRequestHash(req *http.Request, body []byte) string {
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteString("\n")
+	b.WriteString(req.URL.Path)
+	b.WriteString("\n")
+	b.WriteString(req.URL.RawQuery)
+	b.WriteString("\n")
+	b.Write(body)
+	return checksumSHA256(b.String())
+}
+
+func replayIdempotentResponse(c *gin.Context, resp idempotentResponse) {
+	if resp.Location != "" {
+		c.Header("Location", resp.Location)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(resp.Status, resp.ContentType, resp.Body)
+	c.Abort()
+}
+
+func getIdempotentResponse(db *sql.DB, key string) (idempotentResponse, bool, error) {
+	var resp idempotentResponse
+	var contentType, location sql.NullString
+	var createdAt string
+	err := db.QueryRow(`
+		SELECT request_hash, status, content_type, location, body, created_at
+		FROM idempotency_keys
+		WHERE key=?
+	`, key).Scan(&resp.RequestHash, &resp.Status, &contentType, &location, &resp.Body, &createdAt)
+	if err == sql.ErrNoRows {
+		return resp, false, nil
+	}
+	if err != nil {
+		return resp, false, err
+	}
+	resp.ContentType = contentType.String
+	resp.Location = location.String
+	resp.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	return resp, true, nil
+}
+
+func saveIdempotentResponse(db *sql.DB, key string, resp idempotentResponse) error {
+	_, err := db.Exec(`
+		INSERT INTO idempotency_keys(key, request_hash, status, content_type, location, body, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO NOTHING
+	`, key, resp.RequestHash, resp.Status,
+		nullStringToAny(resp.ContentType),
+		nullStringToAny(resp.Location),
+		resp.Body,
+		resp.CreatedAt.Format(time.RFC3339),
+	)
+	return err
+}
+
+func purgeIdempotencyKeys(db *sql.DB, before time.Time) error {
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, before.UTC().Format(time.RFC3339))
+	return err
+}
@@ -16,8 +16,20 @@ type ImportReport struct {
 	SitesAdded    int
 	PoolsAdded    int
 	SegmentsAdded int
+	PresetsSaved  int
+	Updated       int
+	Skipped       int
+	Deleted       int
+	Policy        string
+	CSV           string // encoding and delimiter a CSV file was read with
+	Rows          []ImportRowResult
 	Warnings      []string
 	Errors        []string
+	// FileName and ImportID identify the import in provenance records;
+	// ImportID is set once recordImportProvenance has stored it.
+	FileName string
+	ImportID int64
+	touched  []importTouch
 }
 
 type csvColumns struct {
@@ -438,7 +450,7 @@ func boolAny(provided bool, value bool) any {
 	return boolToInt(value)
 }
 
-func getOrCreateProjectID(db *sql.DB, name string) (int64, bool, error) {
+func getOrCreateProjectID(db sqlConn, name string) (int64, bool, error) {
 	var id int64
 	err := db.QueryRow(`SELECT id FROM projects WHERE name=?`, name).Scan(&id)
 	if err == nil {
@@ -458,7 +470,7 @@ func getOrCreateProjectID(db *sql.DB, name string) (int64, bool, error) {
 	return id, true, nil
 }
 
-func getOrCreateSiteID(db *sql.DB, name string) (int64, bool, error) {
+func getOrCreateSiteID(db sqlConn, name string) (int64, bool, error) {
 	var id int64
 	err := db.QueryRow(`SELECT id FROM sites WHERE name=?`, name).Scan(&id)
 	if err == nil {
@@ -478,7 +490,7 @@ func getOrCreateSiteID(db *sql.DB, name string) (int64, bool, error) {
 	return id, true, nil
 }
 
-func poolExists(db *sql.DB, siteID int64, cidr string) bool {
+func poolExists(db sqlConn, siteID int64, cidr string) bool {
 	var id int64
 	if err := db.QueryRow(`SELECT id FROM pools WHERE site_id=? AND cidr=?`, siteID, cidr).Scan(&id); err != nil {
 		return false
@@ -486,7 +498,7 @@ func poolExists(db *sql.DB, siteID int64, cidr string) bool {
 	return true
 }
 
-func findSegmentID(db *sql.DB, siteID int64, vrf string, vlan int, name string) (int64, bool, error) {
+func findSegmentID(db sqlConn, siteID int64, vrf string, vlan int, name string) (int64, bool, error) {
 	var id int64
 	err := db.QueryRow(`SELECT id FROM segments WHERE site_id=? AND vrf=? AND vlan=? AND name=?`, siteID, vrf, vlan, name).Scan(&id)
 	if err == nil {
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	importPolicyOverwrite = "overwrite"
+	importPolicySkip      = "skip"
+	importPolicyFail      = "fail"
+)
+
+// ImportPolicy decides what a plan import does with a site, pool or segment
+// row that differs from the stored one.
+type ImportPolicy struct {
+	Default          string
+	Site             string
+	Pool             string
+	Segment          string
+	ForceLocked      bool
+	RespectDeletions bool
+	Patch            bool
+	CSV              CSVOptions
+}
+
+// ImportRowResult records how a row that differed from existing data was
+// handled.
+type ImportRowResult struct {
+	Row     int
+	RowType string
+	Key     string
+	Outcome string
+	Fields  []string
+}
+
+func normalizeImportPolicy(raw string) (string, error) {
+	switch v := strings.ToLower(strings.TrimSpace(raw)); v {
+	case "":
+		return "", nil
+	case importPolicyOverwrite, importPolicySkip, importPolicyFail:
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid conflict policy: %s (use skip, overwrite or fail)", raw)
+	}
+}
+
+// parseImportPolicy reads "skip" or "overwrite,segment=fail": a bare value
+// sets the default and entity=value overrides one entity.
+func parseImportPolicy(spec string) (ImportPolicy, error) {
+	var p ImportPolicy
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		entity, value, found := strings.Cut(part, "=")
+		if !found {
+			entity, value = "", part
+		}
+		if err := p.set(strings.TrimSpace(entity), value); err != nil {
+			return ImportPolicy{}, err
+		}
+	}
+	return p, nil
+}
+
+func importPolicyFromForm(c *gin.Context) (ImportPolicy, error) {
+	var p ImportPolicy
+	for _, entity := range []string{"", planRowSite, planRowPool, planRowSegment} {
+		key := "conflict_policy"
+		if entity != "" {
+			key += "_" + entity
+		}
+		if err := p.set(entity, c.PostForm(key)); err != nil {
+			return ImportPolicy{}, err
+		}
+	}
+	force := segmentForceFromRequest(c)
+	if err := force.validate(); err != nil {
+		return ImportPolicy{}, err
+	}
+	p.ForceLocked = force.Force
+	p.RespectDeletions = parseBool(c.PostForm("respect_deletions"))
+	p.Patch = parseBool(c.PostForm("patch"))
+	csvOpts, err := csvOptionsFromForm(c)
+	if err != nil {
+		return ImportPolicy{}, err
+	}
+	p.CSV = csvOpts
+	return p, nil
+}
+
+func (p *ImportPolicy) set(entity, raw string) error {
+	value, err := normalizeImportPolicy(raw)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(entity) {
+	case "":
+		p.Default = value
+	case planRowSite:
+		p.Site = value
+	case planRowPool:
+		p.Pool = value
+	case planRowSegment:
+		p.Segment = value
+	default:
+		return fmt.Errorf("invalid conflict policy entity: %s (use site, pool or segment)", entity)
+	}
+	return nil
+}
+
+func (p ImportPolicy) forRow(rowType string) string {
+	value := ""
+	switch rowType {
+	case planRowSite:
+		value = p.Site
+	case planRowPool:
+		value = p.Pool
+	case planRowSegment:
+		value = p.Segment
+	}
+	if value == "" {
+		value = p.Default
+	}
+	if value == "" {
+		value = importPolicyOverwrite
+	}
+	return value
+}
+
+func (p ImportPolicy) String() string {
+	parts := []string{p.forRow("")}
+	for _, entity := range []string{planRowSite, planRowPool, planRowSegment} {
+		if v := p.forRow(entity); v != parts[0] {
+			parts = append(parts, entity+"="+v)
+		}
+	}
+	if p.ForceLocked {
+		parts = append(parts, "force-locked")
+	}
+	if p.RespectDeletions {
+		parts = append(parts, "respect-deletions")
+	}
+	if p.Patch {
+		parts = append(parts, "patch")
+	}
+	return strings.Join(parts, ",")
+}
+
+// resolveImportConflict applies the policy to a row whose stored entity
+// differs in fields.
+func resolveImportConflict(report *ImportReport, state *planImportState, rowIndex int, rowType, key string, fields []string) (bool, error) {
+	if len(fields) == 0 {
+		return true, nil
+	}
+	result := ImportRowResult{Row: rowIndex, RowType: rowType, Key: key, Fields: fields}
+	diff := strings.Join(fields, ", ")
+	switch state.policy.forRow(rowType) {
+	case importPolicySkip:
+		result.Outcome = "skipped"
+		report.Skipped++
+		report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: %s %s differs (%s), skipped", rowIndex, rowType, key, diff))
+		report.Rows = append(report.Rows, result)
+		return false, nil
+	case importPolicyFail:
+		result.Outcome = "failed"
+		report.Rows = append(report.Rows, result)
+		return false, fmt.Errorf("%s %s differs from existing data (%s)", rowType, key, diff)
+	default:
+		result.Outcome = "overwritten"
+		report.Updated++
+		report.Rows = append(report.Rows, result)
+		return true, nil
+	}
+}
+
+// storedDiff runs query, which must select one row of len(want) columns, and
+// returns the names of the columns whose stored value differs from want.
+func storedDiff(db sqlConn, query string, args []any, names []string, want []any) ([]string, error) {
+	got := make([]sql.NullString, len(want))
+	dest := make([]any, len(want))
+	for i := range got {
+		dest[i] = &got[i]
+	}
+	if err := db.QueryRow(query, args...).Scan(dest...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var diff []string
+	for i, w := range want {
+		value := ""
+		if w != nil {
+			value = strings.TrimSpace(fmt.Sprint(w))
+		}
+		if strings.TrimSpace(got[i].String) != value {
+			diff = append(diff, names[i])
+		}
+	}
+	return diff, nil
+}
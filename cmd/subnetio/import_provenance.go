@@ -0,0 +1,124 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	provenanceCreated = "created"
+	provenanceUpdated = "updated"
+)
+
+// importTouch is a site, pool or segment that an import created or changed.
+type importTouch struct {
+	EntityType string
+	EntityID   int64
+	UID        string
+	Action     string
+}
+
+// EntityProvenance is the latest import that created or changed an entity.
+type EntityProvenance struct {
+	ImportID  int64
+	Source    string
+	FileName  string
+	RowUID    string
+	Action    string
+	CreatedAt string
+}
+
+func (p EntityProvenance) Label() string {
+	name := p.FileName
+	if name == "" {
+		name = p.Source
+	}
+	return name + ", " + p.Action + " " + p.CreatedAt
+}
+
+func (r *ImportReport) touch(entityType string, id int64, uid, action string) {
+	if id <= 0 {
+		return
+	}
+	r.touched = append(r.touched, importTouch{EntityType: entityType, EntityID: id, UID: uid, Action: action})
+}
+
+// recordImportProvenance stores the import and every entity it created or
+// changed, and sets report.ImportID.
+func recordImportProvenance(db sqlConn, projectID int64, source, actor string, report *ImportReport) error {
+	if len(report.touched) == 0 {
+		return nil
+	}
+	res, err := db.Exec(`
+		INSERT INTO plan_imports(project_id, source, file_name, actor, created_at)
+		VALUES(?, ?, ?, ?, ?)`,
+		projectID, source, nullStringToAny(report.FileName), nullStringToAny(actor), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	importID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, t := range report.touched {
+		if _, err := db.Exec(`
+			INSERT INTO import_provenance(import_id, entity_type, entity_id, row_uid, action)
+			VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(import_id, entity_type, entity_id) DO NOTHING`,
+			importID, t.EntityType, t.EntityID, nullStringToAny(t.UID), t.Action); err != nil {
+			return err
+		}
+	}
+	report.ImportID = importID
+	return nil
+}
+
+// provenanceByEntity maps entity id to its latest import for one entity type.
+func provenanceByEntity(db *sql.DB, entityType string) (map[int64]EntityProvenance, error) {
+	rows, err := db.Query(`
+		SELECT p.entity_id, i.id, i.source, COALESCE(i.file_name, ''), COALESCE(p.row_uid, ''), p.action, i.created_at
+		FROM import_provenance p
+		JOIN plan_imports i ON i.id = p.import_id
+		WHERE p.entity_type=?
+		ORDER BY p.import_id`, entityType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int64]EntityProvenance{}
+	for rows.Next() {
+		var id int64
+		var p EntityProvenance
+		if err := rows.Scan(&id, &p.ImportID, &p.Source, &p.FileName, &p.RowUID, &p.Action, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out[id] = p
+	}
+	return out, rows.Err()
+}
+
+// importedSegmentIDs lists the segments an import created that still exist.
+func importedSegmentIDs(db *sql.DB, projectID, importID int64) ([]int64, error) {
+	rows, err := db.Query(`
+		SELECT s.id
+		FROM import_provenance p
+		JOIN plan_imports i ON i.id = p.import_id
+		JOIN segments s ON s.id = p.entity_id
+		WHERE i.id=? AND i.project_id=? AND p.entity_type=? AND p.action=?
+		ORDER BY s.id`, importID, projectID, planRowSegment, provenanceCreated)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
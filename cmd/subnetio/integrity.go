@@ -0,0 +1,301 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type IntegrityIssue struct {
+	Kind       string `json:"kind"`
+	EntityType string `json:"entity_type"`
+	EntityID   int64  `json:"entity_id"`
+	Label      string `json:"label"`
+	Detail     string `json:"detail"`
+}
+
+type IntegrityGroup struct {
+	Kind    string           `json:"kind"`
+	Title   string           `json:"title"`
+	Cleanup string           `json:"cleanup"`
+	Issues  []IntegrityIssue `json:"issues"`
+}
+
+// integrityCheck finds rows by query (id, label) and removes a single row with
+// cleanup.
+type integrityCheck struct {
+	kind       string
+	title      string
+	entityType string
+	detail     string
+	cleanup    string
+	query      string
+	remove     func(tx *sql.Tx, id int64) error
+}
+
+func execAll(tx *sql.Tx, id int64, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var integrityChecks = []integrityCheck{
+	{
+		kind:       "site_without_project",
+		title:      "Sites without a project",
+		entityType: "site",
+		detail:     "site is not assigned to any project",
+		cleanup:    "delete site with its pools and segments",
+		query:      `SELECT s.id, s.name FROM sites s WHERE NOT EXISTS (SELECT 1 FROM project_sites ps JOIN projects p ON p.id=ps.project_id WHERE ps.site_id=s.id) ORDER BY s.name`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return deleteSiteTx(tx, id)
+		},
+	},
+	{
+		kind:       "project_site_dangling",
+		title:      "Project links to missing sites or projects",
+		entityType: "project_site",
+		detail:     "project_sites row points to a deleted site or project",
+		cleanup:    "delete link",
+		query:      `SELECT ps.site_id, 'project ' || ps.project_id || ' / site ' || ps.site_id FROM project_sites ps WHERE NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=ps.site_id) OR NOT EXISTS (SELECT 1 FROM projects p WHERE p.id=ps.project_id)`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM project_sites WHERE site_id=?`)
+		},
+	},
+	{
+		kind:       "segment_without_site",
+		title:      "Segments on missing sites",
+		entityType: "segment",
+		detail:     "segment references a deleted site",
+		cleanup:    "delete segment",
+		query:      `SELECT g.id, g.name || ' (vlan ' || g.vlan || ')' FROM segments g WHERE NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=g.site_id) ORDER BY g.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM mac_reservations WHERE segment_id=?`, `DELETE FROM nat_mappings WHERE segment_id=?`, `DELETE FROM segment_meta WHERE segment_id=?`, `DELETE FROM import_provenance WHERE entity_type='segment' AND entity_id=?`, `DELETE FROM segments WHERE id=?`)
+		},
+	},
+	{
+		kind:       "segment_meta_orphan",
+		title:      "Segment metadata without segments",
+		entityType: "segment_meta",
+		detail:     "segment_meta row has no segment",
+		cleanup:    "delete metadata",
+		query:      `SELECT m.segment_id, 'segment ' || m.segment_id FROM segment_meta m WHERE NOT EXISTS (SELECT 1 FROM segments g WHERE g.id=m.segment_id) ORDER BY m.segment_id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM segment_meta WHERE segment_id=?`)
+		},
+	},
+	{
+		kind:       "mac_reservation_orphan",
+		title:      "MAC reservations without segments",
+		entityType: "mac_reservation",
+		detail:     "MAC reservation points to a deleted segment",
+		cleanup:    "delete reservation",
+		query:      `SELECT m.id, m.mac || ' (segment ' || m.segment_id || ')' FROM mac_reservations m WHERE NOT EXISTS (SELECT 1 FROM segments g WHERE g.id=m.segment_id) ORDER BY m.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM mac_reservations WHERE id=?`)
+		},
+	},
+	{
+		kind:       "nat_mapping_orphan",
+		title:      "NAT mappings without segments",
+		entityType: "nat_mapping",
+		detail:     "NAT mapping points to a deleted segment",
+		cleanup:    "delete mapping",
+		query:      `SELECT n.id, n.type || ' ' || n.public_cidr || ' (segment ' || n.segment_id || ')' FROM nat_mappings n WHERE NOT EXISTS (SELECT 1 FROM segments g WHERE g.id=n.segment_id) ORDER BY n.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM nat_mappings WHERE id=?`)
+		},
+	},
+	{
+		kind:       "device_without_site",
+		title:      "Devices on missing sites",
+		entityType: "device",
+		detail:     "device references a deleted site",
+		cleanup:    "delete device",
+		query:      `SELECT d.id, d.name FROM devices d WHERE NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=d.site_id) ORDER BY d.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM devices WHERE id=?`)
+		},
+	},
+	{
+		kind:       "template_var_without_site",
+		title:      "Template variables of missing sites",
+		entityType: "template_var",
+		detail:     "site variable references a deleted site",
+		cleanup:    "delete variable",
+		query:      `SELECT v.id, v.name FROM template_vars v WHERE v.site_id<>0 AND NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=v.site_id) ORDER BY v.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM template_vars WHERE id=?`)
+		},
+	},
+	{
+		kind:       "site_meta_orphan",
+		title:      "Site metadata without sites",
+		entityType: "site_meta",
+		detail:     "site_meta row has no site",
+		cleanup:    "delete metadata",
+		query:      `SELECT m.site_id, 'site ' || m.site_id FROM site_meta m WHERE NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=m.site_id) ORDER BY m.site_id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM site_meta WHERE site_id=?`)
+		},
+	},
+	{
+		kind:       "pool_without_site",
+		title:      "Pools on missing sites",
+		entityType: "pool",
+		detail:     "pool references a deleted site",
+		cleanup:    "delete pool",
+		query:      `SELECT p.id, p.cidr FROM pools p WHERE NOT EXISTS (SELECT 1 FROM sites s WHERE s.id=p.site_id) ORDER BY p.id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM import_provenance WHERE entity_type='pool' AND entity_id=?`, `DELETE FROM pools WHERE id=?`)
+		},
+	},
+	{
+		kind:       "pool_unused",
+		title:      "Pools on sites without segments",
+		entityType: "pool",
+		detail:     "site has pools but no segments",
+		cleanup:    "delete pool",
+		query:      `SELECT p.id, s.name || ' ' || p.cidr FROM pools p JOIN sites s ON s.id=p.site_id WHERE p.kind NOT IN ('loopback', 'nat') AND NOT EXISTS (SELECT 1 FROM segments g WHERE g.site_id=p.site_id) ORDER BY s.name, p.cidr`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id, `DELETE FROM import_provenance WHERE entity_type='pool' AND entity_id=?`, `DELETE FROM pools WHERE id=?`)
+		},
+	},
+	{
+		kind:       "project_data_orphan",
+		title:      "Project settings for missing projects",
+		entityType: "project",
+		detail:     "rules, defaults, DHCP policies or history remain for a deleted project",
+		cleanup:    "delete leftover project data",
+		query: `SELECT project_id, 'project ' || project_id FROM (
+			SELECT project_id FROM project_rules
+			UNION SELECT project_id FROM project_meta
+			UNION SELECT project_id FROM dhcp_tag_policies
+			UNION SELECT project_id FROM generations
+			UNION SELECT project_id FROM deployed_configs
+			UNION SELECT project_id FROM allocation_validators
+			UNION SELECT project_id FROM project_quotas
+			UNION SELECT project_id FROM segment_tombstones
+			UNION SELECT project_id FROM project_health
+			UNION SELECT project_id FROM defaults_imports
+			UNION SELECT project_id FROM export_columns
+			UNION SELECT project_id FROM pool_tiers
+			UNION SELECT project_id FROM generation_schedules
+			UNION SELECT project_id FROM config_retention
+			UNION SELECT project_id FROM template_vars
+			UNION SELECT project_id FROM notification_routes
+			UNION SELECT project_id FROM notifications_sent
+			UNION SELECT project_id FROM ticket_integrations
+			UNION SELECT project_id FROM conflict_tickets
+			UNION SELECT project_id FROM federation_sources
+			UNION SELECT project_id FROM plan_imports
+		) WHERE project_id NOT IN (SELECT id FROM projects) ORDER BY project_id`,
+		remove: func(tx *sql.Tx, id int64) error {
+			return execAll(tx, id,
+				`DELETE FROM project_rules WHERE project_id=?`,
+				`DELETE FROM project_meta WHERE project_id=?`,
+				`DELETE FROM dhcp_tag_policies WHERE project_id=?`,
+				`DELETE FROM generations WHERE project_id=?`,
+				`DELETE FROM deployed_configs WHERE project_id=?`,
+				`DELETE FROM allocation_validators WHERE project_id=?`,
+				`DELETE FROM project_quotas WHERE project_id=?`,
+				`DELETE FROM segment_tombstones WHERE project_id=?`,
+				`DELETE FROM project_health WHERE project_id=?`,
+				`DELETE FROM defaults_imports WHERE project_id=?`,
+				`DELETE FROM export_columns WHERE project_id=?`,
+				`DELETE FROM pool_tiers WHERE project_id=?`,
+				`DELETE FROM generation_schedules WHERE project_id=?`,
+				`DELETE FROM config_retention WHERE project_id=?`,
+				`DELETE FROM template_vars WHERE project_id=?`,
+				`DELETE FROM notification_routes WHERE project_id=?`,
+				`DELETE FROM notifications_sent WHERE project_id=?`,
+				`DELETE FROM ticket_integrations WHERE project_id=?`,
+				`DELETE FROM conflict_tickets WHERE project_id=?`,
+				`DELETE FROM federation_prefixes WHERE source_id IN (SELECT id FROM federation_sources WHERE project_id=?)`,
+				`DELETE FROM federation_sources WHERE project_id=?`,
+				`DELETE FROM import_provenance WHERE import_id IN (SELECT id FROM plan_imports WHERE project_id=?)`,
+				`DELETE FROM plan_imports WHERE project_id=?`,
+			)
+		},
+	},
+}
+
+func integrityCheckByKind(kind string) (integrityCheck, bool) {
+	for _, check := range integrityChecks {
+		if check.kind == kind {
+			return check, true
+		}
+	}
+	return integrityCheck{}, false
+}
+
+func runIntegrityCheck(db *sql.DB, check integrityCheck) ([]IntegrityIssue, error) {
+	rows, err := db.Query(check.query)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", check.kind, err)
+	}
+	defer rows.Close()
+	var out []IntegrityIssue
+	for rows.Next() {
+		issue := IntegrityIssue{Kind: check.kind, EntityType: check.entityType, Detail: check.detail}
+		var label sql.NullString
+		if err := rows.Scan(&issue.EntityID, &label); err != nil {
+			return nil, err
+		}
+		issue.Label = label.String
+		out = append(out, issue)
+	}
+	return out, rows.Err()
+}
+
+func checkIntegrity(db *sql.DB) ([]IntegrityGroup, error) {
+	var groups []IntegrityGroup
+	for _, check := range integrityChecks {
+		issues, err := runIntegrityCheck(db, check)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, IntegrityGroup{Kind: check.kind, Title: check.title, Cleanup: check.cleanup, Issues: issues})
+	}
+	return groups, nil
+}
+
+// cleanupIntegrity removes the issue with id, or every issue of the kind when
+// id is zero, and returns what was removed so each item can be audited.
+func cleanupIntegrity(db *sql.DB, kind string, id int64) ([]IntegrityIssue, error) {
+	check, ok := integrityCheckByKind(kind)
+	if !ok {
+		return nil, fmt.Errorf("unknown integrity check %q", kind)
+	}
+	issues, err := runIntegrityCheck(db, check)
+	if err != nil {
+		return nil, err
+	}
+	var targets []IntegrityIssue
+	for _, issue := range issues {
+		if id == 0 || issue.EntityID == id {
+			targets = append(targets, issue)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	for _, issue := range targets {
+		if err := check.remove(tx, issue.EntityID); err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
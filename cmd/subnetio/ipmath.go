@@ -81,3 +81,15 @@ func alignUp(n, step *big.Int) *big.Int {
 	}
 	return q.Mul(q, step)
 }
+
+// translateAddr moves addr from one prefix to another of the same size,
+// keeping its offset: 10.1.2.7 in 10.1.2.0/24 becomes 10.9.0.7 in
+// 10.9.0.0/24.
+func translateAddr(addr netip.Addr, from, to netip.Prefix) (netip.Addr, bool) {
+	from, to = from.Masked(), to.Masked()
+	if !from.Contains(addr) || from.Bits() != to.Bits() || addrBitLen(from.Addr()) != addrBitLen(to.Addr()) {
+		return netip.Addr{}, false
+	}
+	offset := new(big.Int).Sub(addrToBig(addr), addrToBig(from.Addr()))
+	return bigToAddr(offset.Add(offset, addrToBig(to.Addr())), addrBitLen(to.Addr()))
+}
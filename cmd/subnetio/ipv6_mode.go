@@ -0,0 +1,116 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// IPv6 addressing modes of a segment.
+const (
+	ipv6ModeSLAAC     = "slaac"
+	ipv6ModeStateless = "dhcpv6-stateless"
+	ipv6ModeStateful  = "dhcpv6-stateful"
+)
+
+var ipv6Modes = []string{ipv6ModeSLAAC, ipv6ModeStateless, ipv6ModeStateful}
+
+func normalizeIPv6Mode(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return "", nil
+	case "slaac":
+		return ipv6ModeSLAAC, nil
+	case "dhcpv6-stateless", "stateless":
+		return ipv6ModeStateless, nil
+	case "dhcpv6-stateful", "stateful":
+		return ipv6ModeStateful, nil
+	}
+	return "", fmt.Errorf("invalid ipv6_mode %q: use %s", raw, strings.Join(ipv6Modes, ", "))
+}
+
+// ipv6ModeField stores the canonical mode name; an unknown mode clears it.
+func ipv6ModeField(f optionalField[string]) optionalField[string] {
+	if f.Value == nil {
+		return f
+	}
+	mode, _ := normalizeIPv6Mode(*f.Value)
+	return optionalString(mode, true)
+}
+
+// raFlags are the router advertisement flags for an IPv6 mode.
+type raFlags struct {
+	Managed     bool
+	OtherConfig bool
+	Autonomous  bool
+}
+
+func ipv6ModeFlags(mode string) raFlags {
+	switch mode {
+	case ipv6ModeStateless:
+		return raFlags{OtherConfig: true, Autonomous: true}
+	case ipv6ModeStateful:
+		return raFlags{Managed: true, OtherConfig: true}
+	default:
+		return raFlags{Autonomous: true}
+	}
+}
+
+// parseIPv6DNS parses the per-segment RDNSS list (comma or space separated).
+func parseIPv6DNS(raw string) ([]string, error) {
+	var out []string
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == ';' }) {
+		a, err := netip.ParseAddr(part)
+		if err != nil || !a.Is6() || a.Is4In6() {
+			return nil, fmt.Errorf("invalid IPv6 DNS server %q", part)
+		}
+		out = append(out, a.String())
+	}
+	return out, nil
+}
+
+// analyzeIPv6Modes checks that the IPv6 mode, prefix and RDNSS settings of
+// each segment fit together.
+func analyzeIPv6Modes(segs []Segment, statuses map[int64]SegmentStatus) []Conflict {
+	var out []Conflict
+	flag := func(s Segment, level statusLevel, detail string) {
+		out = append(out, Conflict{Kind: "IPV6_MODE", Detail: "segment " + s.Name + " site=" + s.Site + ": " + detail, Level: level.Label(), key: segmentConflictKey(s) + " " + detail})
+		st := statuses[s.ID]
+		if st.Level < level {
+			st.Level = level
+		}
+		st.Details = append(st.Details, detail)
+		statuses[s.ID] = st
+	}
+	for _, s := range segs {
+		rawMode := strings.TrimSpace(nullString(s.IPv6Mode))
+		rawDNS := strings.TrimSpace(nullString(s.IPv6DNS))
+		if rawMode == "" && rawDNS == "" {
+			continue
+		}
+		mode, err := normalizeIPv6Mode(rawMode)
+		if err != nil {
+			flag(s, statusConflict, err.Error())
+			continue
+		}
+		if _, err := parseIPv6DNS(rawDNS); err != nil {
+			flag(s, statusConflict, err.Error())
+		}
+		if !s.CIDRV6.Valid && !s.PrefixV6.Valid {
+			flag(s, statusWarning, "IPv6 settings without an IPv6 prefix")
+			continue
+		}
+		bits := int(s.PrefixV6.Int64)
+		if s.CIDRV6.Valid {
+			if p, err := netip.ParsePrefix(s.CIDRV6.String); err == nil {
+				bits = p.Bits()
+			}
+		}
+		if ipv6ModeFlags(mode).Autonomous && rawMode != "" && bits != 64 {
+			flag(s, statusConflict, fmt.Sprintf("%s needs a /64, segment has /%d", mode, bits))
+		}
+	}
+	return out
+}
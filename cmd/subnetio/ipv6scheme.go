@@ -0,0 +1,297 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IPv6Scheme encodes site and VRF codes into fixed bit ranges of IPv6 segment
+// prefixes.
+type IPv6Scheme struct {
+	Site  bitField
+	VRF   bitField
+	Sites map[string]uint64
+	VRFs  map[string]uint64
+}
+
+type bitField struct {
+	Start int
+	End   int
+}
+
+func (f bitField) enabled() bool {
+	return f.End >= f.Start && f.End > 0
+}
+
+func (f bitField) width() int {
+	return f.End - f.Start + 1
+}
+
+func (f bitField) String() string {
+	return itoa(f.Start) + "-" + itoa(f.End)
+}
+
+func parseIPv6Scheme(raw string) (IPv6Scheme, bool, error) {
+	scheme := IPv6Scheme{Sites: map[string]uint64{}, VRFs: map[string]uint64{}}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return scheme, false, nil
+	}
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ';' || r == '\n' }) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return scheme, false, fmt.Errorf("ipv6 scheme: expected key=value, got %q", part)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "site":
+			scheme.Site, err = parseBitField(value)
+		case "vrf":
+			scheme.VRF, err = parseBitField(value)
+		case "sites":
+			scheme.Sites, err = parseSchemeCodes(value)
+		case "vrfs":
+			scheme.VRFs, err = parseSchemeCodes(value)
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err != nil {
+			return scheme, false, fmt.Errorf("ipv6 scheme: %s: %w", key, err)
+		}
+	}
+	if !scheme.Site.enabled() && !scheme.VRF.enabled() {
+		return scheme, false, fmt.Errorf("ipv6 scheme: site or vrf bits are required")
+	}
+	if scheme.Site.enabled() && scheme.VRF.enabled() && scheme.Site.Start <= scheme.VRF.End && scheme.VRF.Start <= scheme.Site.End {
+		return scheme, false, fmt.Errorf("ipv6 scheme: site bits %s overlap vrf bits %s", scheme.Site, scheme.VRF)
+	}
+	for name, code := range scheme.Sites {
+		if scheme.Site.enabled() && !codeFits(code, scheme.Site) {
+			return scheme, false, fmt.Errorf("ipv6 scheme: site %s code %d does not fit bits %s", name, code, scheme.Site)
+		}
+	}
+	for name, code := range scheme.VRFs {
+		if scheme.VRF.enabled() && !codeFits(code, scheme.VRF) {
+			return scheme, false, fmt.Errorf("ipv6 scheme: vrf %s code %d does not fit bits %s", name, code, scheme.VRF)
+		}
+	}
+	return scheme, true, nil
+}
+
+func parseBitField(value string) (bitField, error) {
+	startRaw, endRaw, ok := strings.Cut(value, "-")
+	if !ok {
+		endRaw = startRaw
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(startRaw))
+	if err != nil {
+		return bitField{}, fmt.Errorf("invalid bit range %q", value)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endRaw))
+	if err != nil {
+		return bitField{}, fmt.Errorf("invalid bit range %q", value)
+	}
+	if start < 1 || end > 127 || start > end {
+		return bitField{}, fmt.Errorf("bit range %q must be within 1-127", value)
+	}
+	if end-start+1 > 32 {
+		return bitField{}, fmt.Errorf("bit range %q is wider than 32 bits", value)
+	}
+	return bitField{Start: start, End: end}, nil
+}
+
+func parseSchemeCodes(value string) (map[string]uint64, error) {
+	out := map[string]uint64{}
+	for _, item := range splitCSV(value) {
+		name, codeRaw, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("expected name:code, got %q", item)
+		}
+		code, err := strconv.ParseUint(strings.TrimSpace(codeRaw), 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid code in %q", item)
+		}
+		out[strings.ToLower(strings.TrimSpace(name))] = code
+	}
+	return out, nil
+}
+
+func codeFits(code uint64, f bitField) bool {
+	return code < uint64(1)<<uint(f.width())
+}
+
+func (s IPv6Scheme) String() string {
+	var parts []string
+	if s.Site.enabled() {
+		parts = append(parts, "site="+s.Site.String())
+	}
+	if s.VRF.enabled() {
+		parts = append(parts, "vrf="+s.VRF.String())
+	}
+	if len(s.Sites) > 0 {
+		parts = append(parts, "sites="+formatSchemeCodes(s.Sites))
+	}
+	if len(s.VRFs) > 0 {
+		parts = append(parts, "vrfs="+formatSchemeCodes(s.VRFs))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatSchemeCodes(codes map[string]uint64) string {
+	names := make([]string, 0, len(codes))
+	for name := range codes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, name+":"+strconv.FormatUint(codes[name], 10))
+	}
+	return strings.Join(out, ",")
+}
+
+func (s IPv6Scheme) firstBit() int {
+	if s.Site.enabled() && (!s.VRF.enabled() || s.Site.Start < s.VRF.Start) {
+		return s.Site.Start
+	}
+	return s.VRF.Start
+}
+
+func (s IPv6Scheme) blockBits() int {
+	if s.Site.End > s.VRF.End {
+		return s.Site.End + 1
+	}
+	return s.VRF.End + 1
+}
+
+func (s IPv6Scheme) siteCode(seg Segment) (uint64, error) {
+	code, ok := s.Sites[strings.ToLower(strings.TrimSpace(seg.Site))]
+	if !ok {
+		code = uint64(seg.SiteID)
+	}
+	if !codeFits(code, s.Site) {
+		return 0, fmt.Errorf("site %s code %d does not fit bits %s", seg.Site, code, s.Site)
+	}
+	return code, nil
+}
+
+func (s IPv6Scheme) vrfCode(seg Segment) (uint64, error) {
+	code, ok := s.VRFs[strings.ToLower(strings.TrimSpace(seg.VRF))]
+	if !ok {
+		return 0, fmt.Errorf("vrf %s has no code in ipv6 scheme", seg.VRF)
+	}
+	return code, nil
+}
+
+// blockFor returns the sub-prefix of pool reserved for the segment's site and
+// VRF.
+func (s IPv6Scheme) blockFor(pool netip.Prefix, seg Segment) (netip.Prefix, error) {
+	pool = pool.Masked()
+	if !pool.Addr().Is6() {
+		return netip.Prefix{}, fmt.Errorf("pool %s is not ipv6", pool)
+	}
+	if pool.Bits() > s.firstBit() {
+		return netip.Prefix{}, fmt.Errorf("pool %s is longer than scheme bit %d", pool, s.firstBit())
+	}
+	value := addrToBig(pool.Addr())
+	if s.Site.enabled() {
+		code, err := s.siteCode(seg)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		value.Or(value, new(big.Int).Lsh(new(big.Int).SetUint64(code), uint(127-s.Site.End)))
+	}
+	if s.VRF.enabled() {
+		code, err := s.vrfCode(seg)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		value.Or(value, new(big.Int).Lsh(new(big.Int).SetUint64(code), uint(127-s.VRF.End)))
+	}
+	addr, ok := bigToAddr(value, 128)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("pool %s: scheme block out of range", pool)
+	}
+	return netip.PrefixFrom(addr, s.blockBits()).Masked(), nil
+}
+
+func allocateSegmentInPool(pool netip.Prefix, s Segment, want int, used []netip.Prefix, rules ProjectRules, family string) (netip.Prefix, bool) {
+	if family == "ipv6" {
+		if scheme, ok, err := parseIPv6Scheme(rules.IPv6Scheme); err == nil && ok {
+			block, err := scheme.blockFor(pool, s)
+			if err != nil || want < block.Bits() {
+				return netip.Prefix{}, false
+			}
+			pool = block
+		}
+	}
+	return allocateInPool(pool, want, used)
+}
+
+func analyzeIPv6Scheme(segs []Segment, poolsBySiteV6 map[int64][]netip.Prefix, rules ProjectRules, statuses map[int64]SegmentStatus) []Conflict {
+	scheme, ok, err := parseIPv6Scheme(rules.IPv6Scheme)
+	if err != nil {
+		return []Conflict{{Kind: "IPV6_SCHEME", Detail: err.Error(), Level: statusWarning.Label(), key: "scheme=" + rules.IPv6Scheme}}
+	}
+	if !ok {
+		return nil
+	}
+	var out []Conflict
+	flag := func(s Segment, detail string) {
+		out = append(out, Conflict{Kind: "IPV6_SCHEME", Detail: "segment " + s.Name + ": " + detail, Level: statusWarning.Label(), key: segmentConflictKey(s) + " " + detail})
+		st := statuses[s.ID]
+		if st.Level < statusWarning {
+			st.Level = statusWarning
+		}
+		st.Details = append(st.Details, "IPv6 scheme: "+detail)
+		statuses[s.ID] = st
+	}
+	for _, s := range segs {
+		if !s.CIDRV6.Valid && !s.PrefixV6.Valid {
+			continue
+		}
+		if scheme.VRF.enabled() {
+			if _, err := scheme.vrfCode(s); err != nil {
+				flag(s, err.Error())
+				continue
+			}
+		}
+		if !s.CIDRV6.Valid {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s.CIDRV6.String)
+		if err != nil || !prefix.Addr().Is6() {
+			continue
+		}
+		prefix = prefix.Masked()
+		if prefix.Bits() < scheme.blockBits() {
+			flag(s, prefix.String()+" is shorter than scheme block /"+itoa(scheme.blockBits()))
+			continue
+		}
+		for _, pool := range poolsBySiteV6[s.SiteID] {
+			if !prefixWithin(pool, prefix) {
+				continue
+			}
+			block, err := scheme.blockFor(pool, s)
+			if err != nil {
+				flag(s, err.Error())
+			} else if !prefixWithin(block, prefix) {
+				flag(s, prefix.String()+" is outside scheme block "+block.String())
+			}
+			break
+		}
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonSchemaDocs are the file formats served at /schemas/<name>.json.
+var jsonSchemaDocs = []struct {
+	Name  string
+	Title string
+	Type  reflect.Type
+}{
+	{"plan", "Subnetio plan bundle", reflect.TypeOf(PlanBundle{})},
+	{"defaults", "Subnetio defaults bundle", reflect.TypeOf(DefaultsBundle{})},
+	{"export", "Subnetio export bundle", reflect.TypeOf(ExportBundle{})},
+}
+
+// jsonSchemaFieldRules narrows fields beyond their Go type, keyed by
+// "Type.json_name".
+var jsonSchemaFieldRules = map[string]map[string]any{
+	"PlanBundle.schema_version": {"enum": []string{"1", planSchemaVersion}},
+	"PlanRow.schema_version":    {"enum": []string{"1", planSchemaVersion}},
+	"PlanRow.row_type":          {"enum": []string{planRowMeta, planRowRules, planRowSite, planRowPool, planRowSegment, planRowRulePreset}},
+	"PlanRow.pool_kind":         {"enum": []string{PoolKindLAN, PoolKindLink, PoolKindLoopback, PoolKindNAT}},
+	"PlanRow.pool_state":        {"enum": []string{PoolStateActive, PoolStateDraining}},
+	"PlanRow.segment_kind":      {"enum": []string{SegmentKindLAN, SegmentKindLink}},
+	"PlanRow.pool_strategy":     {"enum": []string{PoolStrategySpillover, PoolStrategyContig, PoolStrategyTiered}},
+	"PlanRow.vlan":              {"minimum": 1, "maximum": 4094},
+	"PlanRow.prefix":            {"minimum": 1, "maximum": 32},
+	"PlanRow.prefix_v6":         {"minimum": 1, "maximum": 128},
+	"PlanRow.hosts":             {"minimum": 1},
+	"PlanRow.pool_priority":     {"minimum": 0},
+}
+
+func jsonSchemaDocByName(name string) (string, reflect.Type, bool) {
+	for _, doc := range jsonSchemaDocs {
+		if doc.Name == name {
+			return doc.Title, doc.Type, true
+		}
+	}
+	return "", nil, false
+}
+
+// requestBaseURL is the scheme and host the request came in on, for the
+// absolute $id of a schema.
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// buildJSONSchema describes t as a JSON Schema (draft 2020-12).
+func buildJSONSchema(id, title string, t reflect.Type) map[string]any {
+	defs := map[string]any{}
+	root := jsonSchemaFor(t, defs)
+	out := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     id,
+		"title":   title,
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+	return out
+}
+
+func jsonSchemaFor(t reflect.Type, defs map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		inner := jsonSchemaFor(t.Elem(), defs)
+		if typ, ok := inner["type"].(string); ok {
+			inner["type"] = []string{typ, "null"}
+		}
+		return inner
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": []string{"array", "null"}, "items": jsonSchemaFor(t.Elem(), defs)}
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaFor(t.Elem(), defs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaFor(t.Elem(), defs)}
+	case reflect.Struct:
+		name := t.Name()
+		ref := map[string]any{"$ref": "#/$defs/" + name}
+		if _, ok := defs[name]; ok {
+			return ref
+		}
+		defs[name] = map[string]any{}
+		properties := map[string]any{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			jsonName, opts, _ := strings.Cut(tag, ",")
+			if jsonName == "" {
+				jsonName = field.Name
+			}
+			prop := jsonSchemaFor(field.Type, defs)
+			for k, v := range jsonSchemaFieldRules[name+"."+jsonName] {
+				prop[k] = v
+			}
+			properties[jsonName] = prop
+			if !strings.Contains(","+opts+",", ",omitempty,") {
+				required = append(required, jsonName)
+			}
+		}
+		def := map[string]any{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			def["required"] = required
+		}
+		defs[name] = def
+		return ref
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,235 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// JustificationReport is the utilization justification behind
+// /export/justification.
+type JustificationReport struct {
+	Project     Project
+	GeneratedAt string
+	GrowthRate  float64
+	Months      int
+	Blocks      []JustificationBlock
+	Totals      []JustificationTotal
+}
+
+type JustificationBlock struct {
+	Site                 string
+	Family               string
+	CIDR                 string
+	Size                 string
+	Used                 string
+	Utilization          string
+	Projected            string
+	ProjectedUtilization string
+}
+
+// JustificationTotal sums a family.
+type JustificationTotal struct {
+	Family               string
+	Blocks               int
+	Size                 string
+	Used                 string
+	Utilization          string
+	Projected            string
+	ProjectedUtilization string
+	Shortfall            string
+	Request              string
+}
+
+// projectedUse grows used by rate percent a month for months, rounded up.
+func projectedUse(used *big.Int, rate float64, months int) *big.Int {
+	if used == nil {
+		return big.NewInt(0)
+	}
+	if rate <= 0 || months <= 0 {
+		return new(big.Int).Set(used)
+	}
+	f := new(big.Float).SetInt(used)
+	f.Mul(f, big.NewFloat(math.Pow(1+rate/100, float64(months))))
+	out, acc := f.Int(nil)
+	if acc == big.Below {
+		out.Add(out, big.NewInt(1))
+	}
+	return out
+}
+
+// requestPrefix is the smallest prefix of the family holding n addresses.
+func requestPrefix(family string, n *big.Int) string {
+	if n == nil || n.Sign() <= 0 {
+		return ""
+	}
+	bits := 32
+	if family == "ipv6" {
+		bits = 128
+	}
+	size := new(big.Int).Sub(n, big.NewInt(1)).BitLen()
+	if size > bits {
+		return ""
+	}
+	return "/" + strconv.Itoa(bits-size)
+}
+
+func buildJustificationReport(project Project, capacity CapacityReport, now time.Time) JustificationReport {
+	report := JustificationReport{
+		Project:     project,
+		GeneratedAt: now.UTC().Format("2006-01-02 15:04 UTC"),
+		GrowthRate:  capacity.GrowthRate,
+		Months:      capacity.Months,
+	}
+	type sums struct {
+		blocks                int
+		size, used, projected *big.Int
+	}
+	totals := map[string]*sums{}
+	for _, p := range capacity.Pools {
+		if p.total == nil {
+			continue
+		}
+		projected := projectedUse(p.used, capacity.GrowthRate, capacity.Months)
+		report.Blocks = append(report.Blocks, JustificationBlock{
+			Site:                 p.Site,
+			Family:               p.Family,
+			CIDR:                 p.CIDR,
+			Size:                 formatBigInt(p.total),
+			Used:                 formatBigInt(p.used),
+			Utilization:          p.Utilization,
+			Projected:            formatBigInt(projected),
+			ProjectedUtilization: ratioPercent(projected, p.total),
+		})
+		t, ok := totals[p.Family]
+		if !ok {
+			t = &sums{size: new(big.Int), used: new(big.Int), projected: new(big.Int)}
+			totals[p.Family] = t
+		}
+		t.blocks++
+		t.size.Add(t.size, p.total)
+		t.used.Add(t.used, p.used)
+		t.projected.Add(t.projected, projected)
+	}
+	for _, family := range []string{"ipv4", "ipv6"} {
+		t, ok := totals[family]
+		if !ok {
+			continue
+		}
+		total := JustificationTotal{
+			Family:               family,
+			Blocks:               t.blocks,
+			Size:                 formatBigInt(t.size),
+			Used:                 formatBigInt(t.used),
+			Utilization:          ratioPercent(t.used, t.size),
+			Projected:            formatBigInt(t.projected),
+			ProjectedUtilization: ratioPercent(t.projected, t.size),
+		}
+		if shortfall := new(big.Int).Sub(t.projected, t.size); shortfall.Sign() > 0 {
+			total.Shortfall = formatBigInt(shortfall)
+			total.Request = requestPrefix(family, shortfall)
+		}
+		report.Totals = append(report.Totals, total)
+	}
+	return report
+}
+
+func (r JustificationReport) horizon() string {
+	return fmt.Sprintf("%.1f%% growth per month over %d months", r.GrowthRate, r.Months)
+}
+
+// writeJustificationText writes the report as a plain-text form to paste
+// into a request ticket or template.
+func writeJustificationText(w io.Writer, r JustificationReport) error {
+	fmt.Fprintf(w, "Utilization justification: %s\n", r.Project.Name)
+	fmt.Fprintf(w, "Generated: %s\n", r.GeneratedAt)
+	fmt.Fprintf(w, "Projection: %s\n\n", r.horizon())
+
+	fmt.Fprintln(w, "Current blocks")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Block\tSite\tSize\tIn use\tUtilization\tIn use +%dm\tUtilization +%dm\n", r.Months, r.Months)
+	for _, b := range r.Blocks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", b.CIDR, b.Site, b.Size, b.Used, b.Utilization, b.Projected, b.ProjectedUtilization)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Blocks) == 0 {
+		fmt.Fprintln(w, "No pools.")
+	}
+
+	for _, t := range r.Totals {
+		fmt.Fprintf(w, "\n%s summary\n", strings.ToUpper(t.Family[:2])+t.Family[2:])
+		fmt.Fprintf(w, "  Blocks held:          %d (%s addresses)\n", t.Blocks, t.Size)
+		fmt.Fprintf(w, "  In use now:           %s (%s)\n", t.Used, t.Utilization)
+		fmt.Fprintf(w, "  In use in %d months:  %s (%s)\n", r.Months, t.Projected, t.ProjectedUtilization)
+		if t.Shortfall == "" {
+			fmt.Fprintln(w, "  Additional need:      none, current blocks suffice")
+			continue
+		}
+		fmt.Fprintf(w, "  Additional need:      %s addresses\n", t.Shortfall)
+		if t.Request != "" {
+			fmt.Fprintf(w, "  Requested block:      %s\n", t.Request)
+		}
+	}
+	return nil
+}
+
+func writeJustificationCSV(w io.Writer, r JustificationReport) error {
+	cw := csv.NewWriter(w)
+	months := strconv.Itoa(r.Months)
+	_ = cw.Write([]string{"row", "family", "block", "site", "size", "in_use", "utilization", "in_use_" + months + "m", "utilization_" + months + "m", "shortfall", "request"})
+	for _, b := range r.Blocks {
+		_ = cw.Write([]string{"block", b.Family, b.CIDR, b.Site, b.Size, b.Used, b.Utilization, b.Projected, b.ProjectedUtilization, "", ""})
+	}
+	for _, t := range r.Totals {
+		_ = cw.Write([]string{"total", t.Family, itoa(t.Blocks) + " blocks", "", t.Size, t.Used, t.Utilization, t.Projected, t.ProjectedUtilization, t.Shortfall, t.Request})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func renderJustificationPDF(r JustificationReport) []byte {
+	doc := newPDFDoc()
+	doc.heading(1, "Utilization justification: "+r.Project.Name)
+	doc.text("Generated " + r.GeneratedAt)
+	doc.text("Projection: " + r.horizon())
+
+	doc.heading(2, "Current blocks")
+	rows := make([][]string, 0, len(r.Blocks))
+	for _, b := range r.Blocks {
+		rows = append(rows, []string{b.CIDR, b.Site, b.Size, b.Used, b.Utilization, b.Projected, b.ProjectedUtilization})
+	}
+	if len(rows) == 0 {
+		doc.text("No pools.")
+	} else {
+		m := strconv.Itoa(r.Months) + "m"
+		doc.table([]string{"Block", "Site", "Size", "In use", "Util", "In use +" + m, "Util +" + m}, []int{22, 14, 16, 14, 8, 16, 10}, rows)
+	}
+
+	for _, t := range r.Totals {
+		need := "none, current blocks suffice"
+		if t.Shortfall != "" {
+			need = t.Shortfall + " addresses"
+			if t.Request != "" {
+				need += ", requested block " + t.Request
+			}
+		}
+		doc.heading(2, strings.ToUpper(t.Family[:2])+t.Family[2:]+" summary")
+		doc.table([]string{"Item", "Value"}, []int{40, 60}, [][]string{
+			{"Blocks held", fmt.Sprintf("%d (%s addresses)", t.Blocks, t.Size)},
+			{"In use now", fmt.Sprintf("%s (%s)", t.Used, t.Utilization)},
+			{fmt.Sprintf("In use in %d months", r.Months), fmt.Sprintf("%s (%s)", t.Projected, t.ProjectedUtilization)},
+			{"Additional need", need},
+		})
+	}
+	return doc.bytes()
+}
@@ -0,0 +1,469 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	legacyRoleCIDR    = "cidr"
+	legacyRoleVLAN    = "vlan"
+	legacyRoleName    = "name"
+	legacyRoleVRF     = "vrf"
+	legacyRoleSite    = "site"
+	legacyRoleGateway = "gateway"
+
+	legacyHeaderScan    = 10
+	legacyMinConfidence = 0.6
+	legacyDefaultVRF    = "default"
+)
+
+var legacyHeaderKeywords = map[string][]string{
+	legacyRoleCIDR:    {"cidr", "subnet", "network", "prefix", "сеть", "подсеть", "ip"},
+	legacyRoleVLAN:    {"vlan", "vid", "влан"},
+	legacyRoleName:    {"name", "description", "desc", "purpose", "назначение", "описание", "имя"},
+	legacyRoleVRF:     {"vrf", "vpn", "routing"},
+	legacyRoleSite:    {"site", "location", "площадка", "локация", "филиал"},
+	legacyRoleGateway: {"gateway", "gw", "шлюз"},
+}
+
+type LegacyColumn struct {
+	Role       string
+	Index      int
+	Letter     string
+	Header     string
+	Confidence float64
+}
+
+type LegacySheet struct {
+	Name      string
+	HeaderRow int
+	Columns   []LegacyColumn
+	SiteFrom  string
+	Skipped   int
+}
+
+type LegacyRow struct {
+	Sheet      string
+	Row        int
+	Site       string
+	VRF        string
+	VLAN       int
+	Name       string
+	CIDR       string
+	Gateway    string
+	Confidence float64
+	Issues     []string
+	Include    bool
+}
+
+type LegacyPreview struct {
+	FileName string
+	Sheets   []LegacySheet
+	Rows     []LegacyRow
+	Errors   []string
+}
+
+func (c LegacyColumn) Percent() int { return int(c.Confidence*100 + 0.5) }
+
+func (r LegacyRow) Percent() int { return int(r.Confidence*100 + 0.5) }
+
+func (s LegacySheet) HeaderLine() int { return s.HeaderRow + 1 }
+
+func previewLegacyUpload(c *gin.Context) *LegacyPreview {
+	preview := &LegacyPreview{}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		preview.Errors = append(preview.Errors, "upload failed: "+err.Error())
+		return preview
+	}
+	preview.FileName = fileHeader.Filename
+	file, err := fileHeader.Open()
+	if err != nil {
+		preview.Errors = append(preview.Errors, "open file: "+err.Error())
+		return preview
+	}
+	defer file.Close()
+	sheets, err := readLegacyWorkbook(file)
+	if err != nil {
+		preview.Errors = append(preview.Errors, "read workbook: "+err.Error())
+		return preview
+	}
+	names := make([]string, 0, len(sheets))
+	for name := range sheets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sheet, rows := analyzeLegacySheet(name, sheets[name])
+		if sheet == nil {
+			continue
+		}
+		preview.Sheets = append(preview.Sheets, *sheet)
+		preview.Rows = append(preview.Rows, rows...)
+	}
+	if len(preview.Rows) == 0 {
+		preview.Errors = append(preview.Errors, "no sheet with a recognizable CIDR column")
+	}
+	return preview
+}
+
+func readLegacyWorkbook(r io.Reader) (map[string][][]string, error) {
+	f, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out := map[string][][]string{}
+	for _, name := range f.GetSheetList() {
+		rows, err := f.GetRows(name)
+		if err != nil {
+			return nil, fmt.Errorf("sheet %s: %w", name, err)
+		}
+		out[name] = rows
+	}
+	return out, nil
+}
+
+// parseLegacyCIDR accepts "10.0.0.0/24", "10.0.0.0/255.255.255.0" and
+// "10.0.0.0 255.255.255.0".
+func parseLegacyCIDR(raw string) (netip.Prefix, bool, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return netip.Prefix{}, false, false
+	}
+	addrPart, maskPart, ok := strings.Cut(raw, "/")
+	if !ok {
+		fields := strings.Fields(raw)
+		if len(fields) != 2 {
+			return netip.Prefix{}, false, false
+		}
+		addrPart, maskPart = fields[0], fields[1]
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(addrPart))
+	if err != nil {
+		return netip.Prefix{}, false, false
+	}
+	maskPart = strings.TrimSpace(maskPart)
+	bits, err := strconv.Atoi(maskPart)
+	if err != nil {
+		mask, err := netip.ParseAddr(maskPart)
+		if err != nil || !mask.Is4() || !addr.Is4() {
+			return netip.Prefix{}, false, false
+		}
+		m := uint32(addrToBig(mask).Uint64())
+		bits = 0
+		for bits < 32 && m&(1<<uint(31-bits)) != 0 {
+			bits++
+		}
+		if m != ^uint32(0)<<uint(32-bits) {
+			return netip.Prefix{}, false, false
+		}
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}, false, false
+	}
+	return prefix, prefix.Addr() != addr, true
+}
+
+func parseLegacyVLAN(raw string) (int, bool) {
+	raw = strings.TrimSpace(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(raw)), "vlan"))
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 1 || v > 4094 {
+		return 0, false
+	}
+	return v, true
+}
+
+func legacyHeaderRole(cell string) string {
+	cell = strings.ToLower(strings.TrimSpace(cell))
+	if cell == "" {
+		return ""
+	}
+	for _, role := range []string{legacyRoleVLAN, legacyRoleVRF, legacyRoleGateway, legacyRoleSite, legacyRoleName, legacyRoleCIDR} {
+		for _, kw := range legacyHeaderKeywords[role] {
+			if cell == kw || (len(kw) > 2 && strings.Contains(cell, kw)) {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+func legacyCell(row []string, idx int) string {
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[idx])
+}
+
+// analyzeLegacySheet guesses the header row and column roles of one sheet and
+// returns scored rows.
+func analyzeLegacySheet(name string, rows [][]string) (*LegacySheet, []LegacyRow) {
+	sheet := &LegacySheet{Name: name, HeaderRow: -1}
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	if width == 0 {
+		return nil, nil
+	}
+
+	headerRoles := map[int]string{}
+	bestMatches := 0
+	for i := 0; i < len(rows) && i < legacyHeaderScan; i++ {
+		roles := map[int]string{}
+		for j, cell := range rows[i] {
+			if role := legacyHeaderRole(cell); role != "" {
+				roles[j] = role
+			}
+		}
+		if len(roles) > bestMatches {
+			bestMatches = len(roles)
+			sheet.HeaderRow = i
+			headerRoles = roles
+		}
+	}
+	data := rows[sheet.HeaderRow+1:]
+
+	cidrFrac := make([]float64, width)
+	vlanFrac := make([]float64, width)
+	ipFrac := make([]float64, width)
+	textFrac := make([]float64, width)
+	for j := 0; j < width; j++ {
+		var filled, cidrs, vlans, ips, texts int
+		for _, row := range data {
+			cell := legacyCell(row, j)
+			if cell == "" {
+				continue
+			}
+			filled++
+			if _, _, ok := parseLegacyCIDR(cell); ok {
+				cidrs++
+			} else if _, err := netip.ParseAddr(cell); err == nil {
+				ips++
+			} else if _, ok := parseLegacyVLAN(cell); ok {
+				vlans++
+			} else {
+				texts++
+			}
+		}
+		if filled > 0 {
+			cidrFrac[j] = float64(cidrs) / float64(filled)
+			vlanFrac[j] = float64(vlans) / float64(filled)
+			ipFrac[j] = float64(ips) / float64(filled)
+			textFrac[j] = float64(texts) / float64(filled)
+		}
+	}
+
+	used := map[int]bool{}
+	pick := func(role string, fracs []float64, minFrac float64) {
+		best, bestScore := -1, 0.0
+		for j := 0; j < width; j++ {
+			if used[j] {
+				continue
+			}
+			headerHit := headerRoles[j] == role
+			var score float64
+			if fracs == nil {
+				if !headerHit {
+					continue
+				}
+				score = 0.9
+			} else {
+				if fracs[j] < minFrac {
+					continue
+				}
+				score = 0.3 + 0.5*fracs[j]
+				if headerHit {
+					score += 0.2
+				}
+			}
+			if score > bestScore {
+				best, bestScore = j, score
+			}
+		}
+		if best < 0 {
+			return
+		}
+		used[best] = true
+		header := ""
+		if sheet.HeaderRow >= 0 {
+			header = legacyCell(rows[sheet.HeaderRow], best)
+		}
+		letter, _ := excelize.ColumnNumberToName(best + 1)
+		sheet.Columns = append(sheet.Columns, LegacyColumn{Role: role, Index: best, Letter: letter, Header: header, Confidence: bestScore})
+	}
+	pick(legacyRoleCIDR, cidrFrac, 0.5)
+	if len(sheet.Columns) == 0 {
+		return nil, nil
+	}
+	pick(legacyRoleVLAN, vlanFrac, 0.5)
+	pick(legacyRoleGateway, ipFrac, 0.5)
+	pick(legacyRoleVRF, nil, 0)
+	pick(legacyRoleSite, nil, 0)
+	pick(legacyRoleName, textFrac, 0.3)
+
+	col := map[string]LegacyColumn{}
+	for _, c := range sheet.Columns {
+		col[c.Role] = c
+	}
+	cell := func(row []string, role string) string {
+		c, ok := col[role]
+		if !ok {
+			return ""
+		}
+		return legacyCell(row, c.Index)
+	}
+	sheet.SiteFrom = "sheet name"
+	if _, ok := col[legacyRoleSite]; ok {
+		sheet.SiteFrom = "column"
+	}
+
+	var out []LegacyRow
+	for i, row := range data {
+		prefix, hostBits, ok := parseLegacyCIDR(cell(row, legacyRoleCIDR))
+		if !ok {
+			sheet.Skipped++
+			continue
+		}
+		r := LegacyRow{
+			Sheet:      name,
+			Row:        sheet.HeaderRow + i + 2,
+			CIDR:       prefix.Masked().String(),
+			Name:       cell(row, legacyRoleName),
+			VRF:        cell(row, legacyRoleVRF),
+			Site:       cell(row, legacyRoleSite),
+			Gateway:    cell(row, legacyRoleGateway),
+			Confidence: col[legacyRoleCIDR].Confidence,
+		}
+		if hostBits {
+			r.Confidence -= 0.2
+			r.Issues = append(r.Issues, "host bits set, using "+r.CIDR)
+		}
+		if vlan, ok := parseLegacyVLAN(cell(row, legacyRoleVLAN)); ok {
+			r.VLAN = vlan
+		} else {
+			r.Confidence -= 0.3
+			r.Issues = append(r.Issues, "no VLAN")
+		}
+		if r.Name == "" {
+			r.Name = r.CIDR
+			r.Confidence -= 0.1
+			r.Issues = append(r.Issues, "no name, using CIDR")
+		}
+		if r.Site == "" {
+			r.Site = strings.TrimSpace(name)
+			r.Confidence -= 0.1
+			r.Issues = append(r.Issues, "site from sheet name")
+		}
+		if r.VRF == "" {
+			r.VRF = legacyDefaultVRF
+			r.Confidence -= 0.05
+		}
+		if r.Gateway != "" {
+			if gw, err := netip.ParseAddr(r.Gateway); err != nil || !prefix.Masked().Contains(gw) {
+				r.Issues = append(r.Issues, "gateway "+r.Gateway+" outside subnet, dropped")
+				r.Gateway = ""
+				r.Confidence -= 0.1
+			}
+		}
+		if r.Confidence < 0 {
+			r.Confidence = 0
+		}
+		r.Include = r.VLAN > 0 && r.Confidence >= legacyMinConfidence
+		out = append(out, r)
+	}
+	return sheet, out
+}
+
+// legacyRowsFromForm reads the reviewed preview rows; only rows whose index is
+// listed in "include" are returned.
+func legacyRowsFromForm(c *gin.Context) []LegacyRow {
+	sites := c.PostFormArray("site")
+	vrfs := c.PostFormArray("vrf")
+	vlans := c.PostFormArray("vlan")
+	names := c.PostFormArray("name")
+	cidrs := c.PostFormArray("cidr")
+	gateways := c.PostFormArray("gateway")
+	include := map[int]bool{}
+	for _, raw := range c.PostFormArray("include") {
+		if i, err := strconv.Atoi(raw); err == nil {
+			include[i] = true
+		}
+	}
+	var out []LegacyRow
+	for i := range cidrs {
+		if !include[i] {
+			continue
+		}
+		at := func(values []string) string {
+			if i < len(values) {
+				return strings.TrimSpace(values[i])
+			}
+			return ""
+		}
+		vlan, _ := parseLegacyVLAN(at(vlans))
+		out = append(out, LegacyRow{
+			Row:     i + 1,
+			Site:    at(sites),
+			VRF:     at(vrfs),
+			VLAN:    vlan,
+			Name:    at(names),
+			CIDR:    at(cidrs),
+			Gateway: at(gateways),
+			Include: true,
+		})
+	}
+	return out
+}
+
+func importLegacyRows(db *sql.DB, rows []LegacyRow, activeProjectID int64) *ImportReport {
+	report := &ImportReport{}
+	state := newPlanImportState()
+	for i, r := range rows {
+		locked := true
+		dhcp := false
+		vlan := r.VLAN
+		row := PlanRow{
+			RowType: planRowSegment,
+			Site:    r.Site,
+			VRF:     r.VRF,
+			VLAN:    &vlan,
+			Name:    r.Name,
+			Locked:  &locked,
+			DHCP:    &dhcp,
+			Gateway: r.Gateway,
+		}
+		prefix, err := netip.ParsePrefix(r.CIDR)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("row %d: invalid cidr %q", r.Row, r.CIDR))
+			continue
+		}
+		bits := prefix.Bits()
+		if prefix.Addr().Is6() {
+			row.CIDRV6 = prefix.Masked().String()
+			row.PrefixV6 = &bits
+		} else {
+			row.CIDR = prefix.Masked().String()
+			row.Prefix = &bits
+		}
+		if err := applyPlanRow(db, report, state, row, i+1, activeProjectID, "legacy"); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", r.Row, err))
+		}
+	}
+	return report
+}
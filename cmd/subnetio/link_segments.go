@@ -0,0 +1,265 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Segment kinds.
+const (
+	SegmentKindLAN  = "lan"
+	SegmentKindLink = "link"
+)
+
+// Pool kinds.
+const (
+	PoolKindLAN  = SegmentKindLAN
+	PoolKindLink = SegmentKindLink
+)
+
+const defaultLinkPrefix = 31
+
+func parseSegmentKind(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", SegmentKindLAN:
+		return SegmentKindLAN, nil
+	case SegmentKindLink:
+		return SegmentKindLink, nil
+	}
+	return "", fmt.Errorf("invalid kind: %s (use lan or link)", raw)
+}
+
+func normalizeSegmentKind(raw string) string {
+	kind, err := parseSegmentKind(raw)
+	if err != nil {
+		return SegmentKindLAN
+	}
+	return kind
+}
+
+func isLinkSegment(s Segment) bool {
+	return s.Kind == SegmentKindLink
+}
+
+func isLinkPool(p Pool) bool {
+	return p.Kind == PoolKindLink
+}
+
+// linkSizes checks the size request of a link segment.
+func linkSizes(hosts, prefix, prefixV6 sql.NullInt64) (sql.NullInt64, sql.NullInt64, error) {
+	if hosts.Valid {
+		return prefix, prefixV6, errors.New("link segments are sized by prefix (/30, /31 or /127), not hosts")
+	}
+	if !prefix.Valid && !prefixV6.Valid {
+		prefix = sql.NullInt64{Int64: defaultLinkPrefix, Valid: true}
+	}
+	if prefix.Valid && prefix.Int64 != 30 && prefix.Int64 != 31 {
+		return prefix, prefixV6, fmt.Errorf("link segments use /30 or /31 for IPv4, not /%d", prefix.Int64)
+	}
+	if prefixV6.Valid && prefixV6.Int64 != 127 {
+		return prefix, prefixV6, fmt.Errorf("link segments use /127 for IPv6, not /%d", prefixV6.Int64)
+	}
+	return prefix, prefixV6, nil
+}
+
+// LinkEnd is one end of a link, written as "device:interface".
+type LinkEnd struct {
+	Device    string
+	Interface string
+}
+
+func parseLinkEnd(raw string) LinkEnd {
+	device, iface, _ := strings.Cut(strings.TrimSpace(raw), ":")
+	return LinkEnd{Device: strings.TrimSpace(device), Interface: strings.TrimSpace(iface)}
+}
+
+// linkEndAddrs returns the addresses of the A and B ends: both addresses of
+// a /31 or /127 (RFC 3021, RFC 6164), or the two usable ones of a /30.
+func linkEndAddrs(p netip.Prefix) (netip.Addr, netip.Addr, bool) {
+	p = p.Masked()
+	a := p.Addr()
+	switch a.BitLen() - p.Bits() {
+	case 1:
+	case 2:
+		a = a.Next()
+	default:
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	return a, a.Next(), true
+}
+
+func splitLinkPools(items []poolItem) ([]poolItem, []poolItem) {
+	var lan, link []poolItem
+	for _, item := range items {
+		if isLinkPool(item.Pool) {
+			link = append(link, item)
+		} else {
+			lan = append(lan, item)
+		}
+	}
+	return lan, link
+}
+
+func splitLinkSegments(segs []Segment) ([]Segment, []Segment) {
+	var lan, link []Segment
+	for _, s := range segs {
+		if isLinkSegment(s) {
+			link = append(link, s)
+		} else {
+			lan = append(lan, s)
+		}
+	}
+	return lan, link
+}
+
+// allocateLinks carves link segments from the link pools in creation order.
+func allocateLinks(items []poolItem, links []Segment, used []netip.Prefix, family string, strict bool) (map[int64]netip.Prefix, []Conflict) {
+	ordered := make([]Segment, len(links))
+	copy(ordered, links)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].ID < ordered[j].ID })
+	alloc := map[int64]netip.Prefix{}
+	var conflicts []Conflict
+	for _, s := range ordered {
+		want := desiredPrefixByFamily(s, family)
+		if want == 0 {
+			continue
+		}
+		placed := false
+		for _, pool := range items {
+			if p, ok := allocateInPool(pool.Prefix, want, used); ok {
+				alloc[s.ID] = p
+				used = append(used, p)
+				placed = true
+				break
+			}
+		}
+		if placed {
+			continue
+		}
+		conflicts = append(conflicts, Conflict{
+			Kind:      "ALLOCATE_FAIL",
+			Detail:    "link segment " + s.Name + " could not be allocated: link pools are full (" + family + ")",
+			Level:     statusWarning.Label(),
+			segmentID: s.ID,
+			key:       segmentConflictKey(s) + " " + family,
+		})
+		if strict {
+			break
+		}
+	}
+	return alloc, conflicts
+}
+
+// renderLink is a link segment as templates see it, with the interface
+// address of each end.
+type renderLink struct {
+	ID           int64
+	Site         string
+	VRF          string
+	VLAN         int
+	Name         string
+	HasIPv4      bool
+	Network      string
+	Mask         string
+	PrefixBits   int
+	HasIPv6      bool
+	NetworkV6    string
+	PrefixBitsV6 int
+	A            renderLinkEnd
+	B            renderLinkEnd
+	Ends         []renderLinkEnd
+}
+
+// renderLinkEnd is one end of a link.
+type renderLinkEnd struct {
+	Device    string
+	Interface string
+	Peer      string
+	Address   string
+	AddressV6 string
+}
+
+// buildRenderLinks turns the link segments among views into template links,
+// with the same filters and family selection as buildRenderSegments.
+func buildRenderLinks(opts GenerateOptions, views []SegmentView) []renderLink {
+	var out []renderLink
+	for _, v := range views {
+		if !isLinkSegment(v.Segment) {
+			continue
+		}
+		if opts.SiteFilter != "" && opts.SiteFilter != v.Site {
+			continue
+		}
+		if opts.VRFFilter != "" && opts.VRFFilter != v.VRF {
+			continue
+		}
+		if opts.SegmentFilter != "" && !segmentFilterMatch(opts.SegmentFilter, v) {
+			continue
+		}
+		a := parseLinkEnd(nullString(v.LinkA))
+		b := parseLinkEnd(nullString(v.LinkB))
+		if a.Device == "" {
+			a.Device = v.Site
+		}
+		if b.Device == "" {
+			b.Device = "peer"
+		}
+		link := renderLink{
+			ID:   v.ID,
+			Site: v.Site,
+			VRF:  v.VRF,
+			VLAN: v.VLAN,
+			Name: v.Name,
+			A:    renderLinkEnd{Device: a.Device, Interface: a.Interface, Peer: b.Device},
+			B:    renderLinkEnd{Device: b.Device, Interface: b.Interface, Peer: a.Device},
+		}
+		if opts.WantIPv4() && v.CIDR != "" {
+			if p, err := netip.ParsePrefix(v.CIDR); err == nil && p.Addr().Is4() {
+				if addrA, addrB, ok := linkEndAddrs(p); ok {
+					details, _ := prefixDetailsIPv4(p)
+					link.HasIPv4 = true
+					link.Network = p.Masked().Addr().String()
+					link.Mask = details.Mask
+					link.PrefixBits = p.Bits()
+					link.A.Address = addrA.String()
+					link.B.Address = addrB.String()
+				}
+			}
+		}
+		if opts.WantIPv6() && v.CIDRV6 != "" {
+			if p, err := netip.ParsePrefix(v.CIDRV6); err == nil && p.Addr().Is6() {
+				if addrA, addrB, ok := linkEndAddrs(p); ok {
+					link.HasIPv6 = true
+					link.NetworkV6 = p.Masked().Addr().String()
+					link.PrefixBitsV6 = p.Bits()
+					link.A.AddressV6 = addrA.String()
+					link.B.AddressV6 = addrB.String()
+				}
+			}
+		}
+		if !link.HasIPv4 && !link.HasIPv6 {
+			continue
+		}
+		link.Ends = []renderLinkEnd{link.A, link.B}
+		out = append(out, link)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Site != out[j].Site {
+			return out[i].Site < out[j].Site
+		}
+		if out[i].VRF != out[j].VRF {
+			return out[i].VRF < out[j].VRF
+		}
+		if out[i].VLAN != out[j].VLAN {
+			return out[i].VLAN < out[j].VLAN
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MACReservation struct {
+	ID          int64
+	SegmentID   int64
+	Site        string
+	VRF         string
+	VLAN        int
+	SegmentName string
+	CIDR        string
+	MAC         string
+	IP          string
+	Hostname    string
+	Notes       string
+	CreatedAt   string
+	Vendor      string
+	DuplicateOf []string
+}
+
+// builtinOUIs covers common network and virtualization vendors.
+var builtinOUIs = map[string]string{
+	"00000c": "Cisco",
+	"00180a": "Cisco Meraki",
+	"000585": "Juniper Networks",
+	"288a1c": "Juniper Networks",
+	"3c6104": "Juniper Networks",
+	"54e032": "Juniper Networks",
+	"7819f7": "Juniper Networks",
+	"84b59c": "Juniper Networks",
+	"000c42": "MikroTik",
+	"4c5e0c": "MikroTik",
+	"d4ca6d": "MikroTik",
+	"e48d8c": "MikroTik",
+	"6c3b6b": "MikroTik",
+	"64d154": "MikroTik",
+	"cc2de0": "MikroTik",
+	"b869f4": "MikroTik",
+	"488f5a": "MikroTik",
+	"00156d": "Ubiquiti",
+	"002722": "Ubiquiti",
+	"0418d6": "Ubiquiti",
+	"24a43c": "Ubiquiti",
+	"44d9e7": "Ubiquiti",
+	"687251": "Ubiquiti",
+	"788a20": "Ubiquiti",
+	"802aa8": "Ubiquiti",
+	"b4fbe4": "Ubiquiti",
+	"dc9fdb": "Ubiquiti",
+	"f09fc2": "Ubiquiti",
+	"fcecda": "Ubiquiti",
+	"001c73": "Arista Networks",
+	"444ca8": "Arista Networks",
+	"000b86": "Aruba",
+	"001a1e": "Aruba",
+	"24dec6": "Aruba",
+	"00090f": "Fortinet",
+	"085b0e": "Fortinet",
+	"906cac": "Fortinet",
+	"001b17": "Palo Alto Networks",
+	"002590": "Supermicro",
+	"0cc47a": "Supermicro",
+	"ac1f6b": "Supermicro",
+	"001422": "Dell",
+	"001e4f": "Dell",
+	"f8bc12": "Dell",
+	"d4ae52": "Dell",
+	"001b21": "Intel",
+	"3cfdfe": "Intel",
+	"a0369f": "Intel",
+	"000393": "Apple",
+	"000a95": "Apple",
+	"b827eb": "Raspberry Pi",
+	"dca632": "Raspberry Pi",
+	"e45f01": "Raspberry Pi",
+	"00408c": "Axis Communications",
+	"accc8e": "Axis Communications",
+	"0004f2": "Polycom",
+	"805ec0": "Yealink",
+	"005056": "VMware",
+	"000c29": "VMware",
+	"000569": "VMware",
+	"00155d": "Microsoft Hyper-V",
+	"080027": "VirtualBox",
+	"001c42": "Parallels",
+	"525400": "QEMU/KVM",
+}
+
+var (
+	ouiOnce  sync.Once
+	ouiTable map[string]string
+)
+
+func ouiVendors() map[string]string {
+	ouiOnce.Do(func() {
+		ouiTable = builtinOUIs
+		path := mustEnv("OUI_FILE", "")
+		if path == "" {
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("OUI_FILE: %v", err)
+			return
+		}
+		defer f.Close()
+		loaded, err := parseOUIRegistry(f)
+		if err != nil {
+			log.Printf("OUI_FILE: %v", err)
+			return
+		}
+		for k, v := range builtinOUIs {
+			if _, ok := loaded[k]; !ok {
+				loaded[k] = v
+			}
+		}
+		ouiTable = loaded
+	})
+	return ouiTable
+}
+
+// parseOUIRegistry reads the IEEE MA-L registry, either oui.csv
+// (Registry,Assignment,Organization Name,...) or oui.txt ("00-00-0C (hex) Name").
+func parseOUIRegistry(r io.Reader) (map[string]string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(64)
+	out := map[string]string{}
+	if strings.HasPrefix(strings.TrimPrefix(string(head), "\ufeff"), "Registry,") {
+		cr := csv.NewReader(br)
+		cr.FieldsPerRecord = -1
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(rec) >= 3 && len(rec[1]) == 6 {
+				out[strings.ToLower(rec[1])] = strings.TrimSpace(rec[2])
+			}
+		}
+		return out, nil
+	}
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		prefix, name, ok := strings.Cut(scanner.Text(), "(hex)")
+		if !ok {
+			continue
+		}
+		key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(prefix), "-", ""))
+		if len(key) == 6 {
+			out[key] = strings.TrimSpace(name)
+		}
+	}
+	return out, scanner.Err()
+}
+
+// normalizeMAC accepts colon, hyphen, Cisco dotted and bare hex forms and
+// returns lowercase colon notation.
+func normalizeMAC(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == 12 {
+		if b, err := hex.DecodeString(raw); err == nil {
+			return net.HardwareAddr(b).String(), nil
+		}
+	}
+	hw, err := net.ParseMAC(raw)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("invalid MAC address %q", raw)
+	}
+	return hw.String(), nil
+}
+
+func macVendor(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil || len(hw) != 6 {
+		return ""
+	}
+	key := hex.EncodeToString(hw[:3])
+	if name, ok := ouiVendors()[key]; ok {
+		if hw[0]&0x02 != 0 {
+			return name + " (locally administered)"
+		}
+		return name
+	}
+	if hw[0]&0x02 != 0 {
+		return "locally administered (random or virtual)"
+	}
+	return ""
+}
+
+func saveMACReservation(db *sql.DB, r MACReservation) (int64, error) {
+	seg, ok := segmentByID(db, r.SegmentID)
+	if !ok {
+		return 0, fmt.Errorf("segment not found")
+	}
+	mac, err := normalizeMAC(r.MAC)
+	if err != nil {
+		return 0, err
+	}
+	if hw, _ := net.ParseMAC(mac); hw[0]&0x01 != 0 {
+		return 0, fmt.Errorf("%s is a multicast address", mac)
+	}
+	ip := strings.TrimSpace(r.IP)
+	if ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Is4() {
+			return 0, fmt.Errorf("invalid IPv4 address %q", ip)
+		}
+		if !seg.CIDR.Valid {
+			return 0, fmt.Errorf("segment %s has no CIDR allocated yet", seg.Name)
+		}
+		prefix, err := netip.ParsePrefix(seg.CIDR.String)
+		if err != nil || !prefix.Contains(addr) {
+			return 0, fmt.Errorf("%s is outside %s", ip, seg.CIDR.String)
+		}
+		last, _ := prefixLastAddr(prefix)
+		if prefix.Bits() < 31 && (addr == prefix.Masked().Addr() || addr == last) {
+			return 0, fmt.Errorf("%s is the network or broadcast address of %s", ip, seg.CIDR.String)
+		}
+		ip = addr.String()
+		var other string
+		err = db.QueryRow(`SELECT mac FROM mac_reservations WHERE segment_id=? AND ip=? AND mac<>?`, seg.ID, ip, mac).Scan(&other)
+		if err == nil {
+			return 0, fmt.Errorf("%s is already reserved for %s", ip, other)
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO mac_reservations(segment_id, mac, ip, hostname, notes, created_at)
+		VALUES(?, ?, ?, ?, ?, ?)
+		ON CONFLICT(segment_id, mac) DO UPDATE SET ip=excluded.ip, hostname=excluded.hostname, notes=excluded.notes
+		RETURNING id`,
+		seg.ID, mac, ip, strings.TrimSpace(r.Hostname), strings.TrimSpace(r.Notes), time.Now().UTC().Format(time.RFC3339),
+	).Scan(&id)
+	return id, err
+}
+
+func deleteMACReservation(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM mac_reservations WHERE id=?`, id)
+	return err
+}
+
+func macReservationByID(db *sql.DB, id int64) (MACReservation, bool) {
+	var r MACReservation
+	err := db.QueryRow(`SELECT id, segment_id, mac, ip, hostname FROM mac_reservations WHERE id=?`, id).
+		Scan(&r.ID, &r.SegmentID, &r.MAC, &r.IP, &r.Hostname)
+	return r, err == nil
+}
+
+// listMACReservations returns the project's MACs with vendor and duplicate
+// information filled in.
+func listMACReservations(db *sql.DB, projectID int64) ([]MACReservation, error) {
+	rows, err := db.Query(`
+		SELECT m.id, m.segment_id, si.name, s.vrf, s.vlan, s.name, COALESCE(s.cidr, ''), m.mac, m.ip, m.hostname, m.notes, m.created_at
+		FROM mac_reservations m
+		JOIN segments s ON s.id = m.segment_id
+		JOIN sites si ON si.id = s.site_id
+		JOIN project_sites ps ON ps.site_id = si.id
+		WHERE ps.project_id=?
+		ORDER BY si.name, s.vrf, s.vlan, m.ip, m.mac`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []MACReservation
+	for rows.Next() {
+		var r MACReservation
+		if err := rows.Scan(&r.ID, &r.SegmentID, &r.Site, &r.VRF, &r.VLAN, &r.SegmentName, &r.CIDR, &r.MAC, &r.IP, &r.Hostname, &r.Notes, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Vendor = macVendor(r.MAC)
+		out = append(out, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	byMAC := map[string][]int{}
+	for i, r := range out {
+		byMAC[r.MAC] = append(byMAC[r.MAC], i)
+	}
+	for _, idx := range byMAC {
+		if len(idx) < 2 {
+			continue
+		}
+		for _, i := range idx {
+			for _, j := range idx {
+				if i != j {
+					out[i].DuplicateOf = append(out[i].DuplicateOf, out[j].segmentLabel())
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func (r MACReservation) segmentLabel() string {
+	return fmt.Sprintf("%s/%s/vlan%d %s", r.Site, r.VRF, r.VLAN, r.SegmentName)
+}
+
+type macDuplicate struct {
+	MAC      string
+	Vendor   string
+	Segments []string
+}
+
+func duplicateMACs(list []MACReservation) []macDuplicate {
+	var out []macDuplicate
+	seen := map[string]bool{}
+	for _, r := range list {
+		if len(r.DuplicateOf) == 0 || seen[r.MAC] {
+			continue
+		}
+		seen[r.MAC] = true
+		segments := append([]string{r.segmentLabel()}, r.DuplicateOf...)
+		sort.Strings(segments)
+		out = append(out, macDuplicate{MAC: r.MAC, Vendor: r.Vendor, Segments: segments})
+	}
+	return out
+}
+
+// Only reservations with an IP address are exported.
+func renderReservations(list []MACReservation, format string) (string, string, error) {
+	var fixed []MACReservation
+	for _, r := range list {
+		if r.IP != "" {
+			fixed = append(fixed, r)
+		}
+	}
+	var b strings.Builder
+	switch format {
+	case "kea":
+		type keaReservation struct {
+			HWAddress string `json:"hw-address"`
+			IPAddress string `json:"ip-address"`
+			Hostname  string `json:"hostname,omitempty"`
+		}
+		type keaSubnet struct {
+			Subnet       string           `json:"subnet"`
+			Reservations []keaReservation `json:"reservations"`
+		}
+		subnets := []keaSubnet{}
+		index := map[string]int{}
+		for _, r := range fixed {
+			i, ok := index[r.CIDR]
+			if !ok {
+				i = len(subnets)
+				index[r.CIDR] = i
+				subnets = append(subnets, keaSubnet{Subnet: r.CIDR})
+			}
+			subnets[i].Reservations = append(subnets[i].Reservations, keaReservation{HWAddress: r.MAC, IPAddress: r.IP, Hostname: r.Hostname})
+		}
+		out, err := json.MarshalIndent(map[string]any{"Dhcp4": map[string]any{"subnet4": subnets}}, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		return string(out) + "\n", "json", nil
+	case "dhcpd":
+		names := map[string]int{}
+		for _, r := range fixed {
+			name := safeName(r.Hostname)
+			if name == "" {
+				name = "res-" + strings.ReplaceAll(r.MAC, ":", "")
+			}
+			names[name]++
+			if names[name] > 1 {
+				name = fmt.Sprintf("%s-%d", name, names[name])
+			}
+			fmt.Fprintf(&b, "# %s\nhost %s {\n  hardware ethernet %s;\n  fixed-address %s;\n}\n", r.segmentLabel(), name, r.MAC, r.IP)
+		}
+		return b.String(), "conf", nil
+	case "mikrotik":
+		for _, r := range fixed {
+			comment := r.Hostname
+			if comment == "" {
+				comment = r.segmentLabel()
+			}
+			fmt.Fprintf(&b, "/ip dhcp-server lease add mac-address=%s address=%s comment=%q\n", strings.ToUpper(r.MAC), r.IP, comment)
+		}
+		return b.String(), "rsc", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q (kea, dhcpd or mikrotik)", format)
+}
@@ -3,11 +3,10 @@
 package main
 
 import (
-	"archive/zip"
-	"bytes"
 	"database/sql"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -21,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	_ "modernc.org/sqlite"
@@ -53,6 +53,19 @@ type Site struct {
 	DhcpBootFile   sql.NullString
 	DhcpNextServer sql.NullString
 	DhcpVendorOpts sql.NullString
+	BGPASN         sql.NullInt64
+	VLANDomain     sql.NullString
+
+	DhcpFailoverPrimary   sql.NullString
+	DhcpFailoverSecondary sql.NullString
+
+	OwnerTeam  sql.NullString
+	OwnerEmail sql.NullString
+
+	Devices []Device
+	NAT     []NATMapping
+	// Vars are the site's template variables.
+	Vars map[string]string
 }
 
 type Project struct {
@@ -60,22 +73,36 @@ type Project struct {
 	Name        string
 	Description sql.NullString
 	SiteCount   int
+	Archived    bool
+	Sandbox     bool
+
+	// RoutingDomain groups projects whose networks are routed together, so
+	// their addresses must not overlap.
+	RoutingDomain string
+
+	// AuditReads records exports and downloads of the project in the audit
+	// log.
+	AuditReads bool
 }
 
 type Pool struct {
-	ID       int64
-	SiteID   int64
-	Site     string
-	CIDR     string
-	Family   string
-	Tier     sql.NullString
-	Priority int
+	ID        int64
+	SiteID    int64
+	Site      string
+	CIDR      string
+	Family    string
+	Tier      sql.NullString
+	Priority  int
+	VRFShares sql.NullString
+	Kind      string
+	State     string
 }
 
 type Segment struct {
 	ID               int64
 	SiteID           int64
 	Site             string
+	VLANDomain       string
 	VRF              string
 	VLAN             int
 	Name             string
@@ -93,10 +120,24 @@ type Segment struct {
 	Notes            sql.NullString
 	Tags             sql.NullString
 	PoolTier         sql.NullString
+	IPv6Mode         sql.NullString
+	IPv6DNS          sql.NullString
+	OwnerTeam        sql.NullString
+	OwnerEmail       sql.NullString
+	ExpiresAt        sql.NullString
+	ExpiryAction     sql.NullString
+	ExpiredAt        sql.NullString
+	MultiPrefix      bool
+	SecondaryCIDRs   sql.NullString
+	Kind             string
+	LinkA            sql.NullString
+	LinkB            sql.NullString
 }
 
+// mustEnv returns the setting from the environment or the config file, or
+// def when neither sets it.
 func mustEnv(key, def string) string {
-	v := strings.TrimSpace(os.Getenv(key))
+	v, _ := lookupSetting(key)
 	if v == "" {
 		return def
 	}
@@ -115,16 +156,33 @@ func sqliteDSN(raw string) string {
 }
 
 func main() {
+	if err := runCLI(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServer is the `serve` subcommand and the default without arguments.
+// With skipMigrations the schema must already be current.
+func runServer(skipMigrations bool) {
 	dbPath := mustEnv("DB_PATH", "./subnetio.sqlite")
 	listen := mustEnv("LISTEN_ADDR", "0.0.0.0:8080")
-
+	basePath = normalizeBasePath(mustEnv("BASE_PATH", ""))
+	tlsConfig := loadTLSConfig(dbPath)
+	if err := tlsConfig.Validate(); err != nil {
+		log.Fatal(err)
+	}
 	db, err := sql.Open("sqlite", sqliteDSN(dbPath))
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	if err := migrate(db); err != nil {
+	if skipMigrations {
+		err = checkSchemaCurrent(db)
+	} else {
+		err = migrate(db)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -132,9 +190,37 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := sealAuditChain(db); err != nil {
+		log.Printf("audit chain: %v", err)
+	}
+	if err := moveInlineConfigs(db); err != nil {
+		log.Printf("config storage: %v", err)
+	}
+	startGenerationScheduler(db)
+	startSegmentExpiry(db)
+	startHealthSnapshots(db)
+	startSandboxPurge(db, defaultProjectID)
+	startDBMaintenance(db)
 
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	if err := configureTrustedProxies(r, mustEnv("TRUSTED_PROXIES", "")); err != nil {
+		log.Fatal(err)
+	}
+	errorPageData = func(c *gin.Context) gin.H {
+		data, _ := baseData(c, db, defaultProjectID)
+		return data
+	}
+	r.Use(gin.Logger(), gin.CustomRecovery(recoverWithProblem))
+	r.NoRoute(notFoundHandler)
+	if tlsConfig.Enabled() && tlsConfig.HSTSMaxAge > 0 {
+		r.Use(hstsMiddleware(tlsConfig.HSTSMaxAge))
+	}
+	r.Use(maintenanceGuard(db))
+	r.Use(idempotencyMiddleware(db, idempotencyTTL()))
+	r.Use(archiveGuard(db, defaultProjectID))
+	r.Use(sandboxExportGuard(db, defaultProjectID))
+	r.Use(readAuditMiddleware(db, defaultProjectID))
+	r.Use(dataVersionMiddleware(db, defaultProjectID))
 
 	assetSub, err := fs.Sub(assetFS, "assets")
 	if err != nil {
@@ -143,21 +229,84 @@ func main() {
 	r.StaticFS("/assets", http.FS(assetSub))
 
 	r.GET("/healthz", func(c *gin.Context) { c.String(200, "ok") })
-	r.GET("/", func(c *gin.Context) { c.Redirect(302, "/segments") })
+	r.GET("/schemas", func(c *gin.Context) {
+		var list []gin.H
+		for _, doc := range jsonSchemaDocs {
+			list = append(list, gin.H{"name": doc.Name, "title": doc.Title, "url": requestBaseURL(c) + withBase("/schemas/"+doc.Name+".json")})
+		}
+		c.JSON(200, gin.H{"schemas": list})
+	})
+	r.GET("/schemas/:file", func(c *gin.Context) {
+		name, ok := strings.CutSuffix(c.Param("file"), ".json")
+		title, t, found := jsonSchemaDocByName(name)
+		if !ok || !found {
+			respondError(c, 404, fmt.Errorf("unknown schema"))
+			return
+		}
+		id := requestBaseURL(c) + withBase("/schemas/"+name+".json")
+		c.Header("Content-Type", "application/schema+json")
+		c.IndentedJSON(200, buildJSONSchema(id, title, t))
+	})
+	r.GET("/", func(c *gin.Context) {
+		data, _ := baseData(c, db, defaultProjectID)
+		dashboard, err := buildDashboard(db)
+		if err != nil {
+			data["DashboardError"] = err.Error()
+		}
+		data["Active"] = "dashboard"
+		data["Dashboard"] = dashboard
+		render(c, "dashboard", data)
+	})
 
 	// Projects
 	r.GET("/projects", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		meta, _ := getProjectMeta(db, activeProjectID)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
 		data["Active"] = "projects"
 		data["ProjectMeta"] = meta
+		data["DHCPPolicies"] = dhcpPolicies
+		if v, ok := getAllocationValidator(db, activeProjectID); ok {
+			data["AllocationValidator"] = v
+		}
+		data["ValidatorError"] = strings.TrimSpace(c.Query("validator_error"))
+		if q, ok := getProjectQuota(db, activeProjectID); ok {
+			data["ProjectQuota"] = q
+		}
+		data["ProjectQuotaUsage"], _ = projectQuotaUsage(db, activeProjectID)
+		data["QuotaError"] = strings.TrimSpace(c.Query("quota_error"))
+		if t, ok := getTicketIntegration(db, activeProjectID); ok {
+			data["TicketIntegration"] = t
+		}
+		routes, _ := listNotificationRoutes(db, activeProjectID)
+		teams, _ := ownerTeams(db, activeProjectID)
+		data["NotificationRoutes"] = formatNotificationRoutes(routes)
+		data["UnroutedTeams"] = notificationTeamsWithoutRoute(teams, routes)
+		data["NotificationError"] = strings.TrimSpace(c.Query("notification_error"))
+		data["TicketingError"] = strings.TrimSpace(c.Query("ticketing_error"))
+		imports, _ := listDefaultsImports(db, activeProjectID, 5)
+		data["DefaultsImports"] = imports
+		data["DefaultsOk"] = strings.TrimSpace(c.Query("defaults_ok"))
+		data["DefaultsError"] = strings.TrimSpace(c.Query("defaults_error"))
+		if project, ok := projectByID(db, activeProjectID); ok {
+			data["ActiveRoutingDomain"] = project.RoutingDomain
+			data["ActiveAuditReads"] = project.AuditReads
+		}
+		if projects, ok := data["Projects"].([]Project); ok {
+			data["RoutingDomainNames"] = listRoutingDomains(projects)
+		}
 		render(c, "projects", data)
 	})
 	r.POST("/projects", func(c *gin.Context) {
 		name := strings.TrimSpace(c.PostForm("name"))
 		desc := strings.TrimSpace(c.PostForm("description"))
+		sandbox := c.PostForm("sandbox") == "1"
 		if name != "" {
-			res, err := db.Exec(`INSERT OR IGNORE INTO projects(name, description) VALUES(?, ?)`, name, nullStringToAny(desc))
+			var sandboxSince any
+			if sandbox {
+				sandboxSince = time.Now().UTC().Format(time.RFC3339)
+			}
+			res, err := db.Exec(`INSERT OR IGNORE INTO projects(name, description, sandbox, sandbox_since) VALUES(?, ?, ?, ?)`, name, nullStringToAny(desc), boolToInt(sandbox), sandboxSince)
 			if err == nil {
 				if rows, _ := res.RowsAffected(); rows > 0 {
 					projectID, _ := res.LastInsertId()
@@ -165,6 +314,7 @@ func main() {
 						ID:          projectID,
 						Name:        name,
 						Description: parseNullString(desc),
+						Sandbox:     sandbox,
 					}
 					writeAudit(db, c, auditRecord{
 						ProjectID:  projectID,
@@ -177,7 +327,7 @@ func main() {
 				}
 			}
 		}
-		c.Redirect(302, "/projects")
+		c.Redirect(302, withBase("/projects"))
 	})
 	r.POST("/projects/meta", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
@@ -217,7 +367,358 @@ func main() {
 			Before:     snapshotProjectMeta(beforeMeta),
 			After:      snapshotProjectMeta(afterMeta),
 		})
-		c.Redirect(302, "/projects?project_id="+itoa64(projectID))
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/dhcp-policies", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			projectID = activeProjectID
+		}
+		policy := DHCPPolicy{
+			ProjectID:      projectID,
+			Tag:            c.PostForm("tag"),
+			Priority:       atoiDefault(c.PostForm("priority"), 0),
+			DhcpSearch:     parseNullString(c.PostForm("dhcp_search")),
+			DhcpLeaseTime:  parseNullInt(c.PostForm("dhcp_lease_time")),
+			DhcpRenewTime:  parseNullInt(c.PostForm("dhcp_renew_time")),
+			DhcpRebindTime: parseNullInt(c.PostForm("dhcp_rebind_time")),
+			DhcpBootFile:   parseNullString(c.PostForm("dhcp_boot_file")),
+			DhcpNextServer: parseNullString(c.PostForm("dhcp_next_server")),
+			DhcpVendorOpts: parseNullString(c.PostForm("dhcp_vendor_options")),
+		}
+		var before any
+		action := "create"
+		if existing, ok := dhcpPolicyByTag(db, projectID, policy.Tag); ok {
+			before = snapshotDHCPPolicy(existing)
+			action = "update"
+		}
+		id, err := saveDHCPPolicy(db, policy)
+		if err == nil {
+			if saved, ok := dhcpPolicyByID(db, id); ok {
+				writeAudit(db, c, auditRecord{
+					ProjectID:  projectID,
+					Action:     action,
+					EntityType: "dhcp_policy",
+					EntityID:   sql.NullInt64{Int64: id, Valid: true},
+					EntityLabel: sql.NullString{String: saved.Tag, Valid: true},
+					Before:     before,
+					After:      snapshotDHCPPolicy(saved),
+				})
+			}
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/dhcp-policies/delete", func(c *gin.Context) {
+		policyID := parseProjectID(c.PostForm("policy_id"))
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if policy, ok := dhcpPolicyByID(db, policyID); ok {
+			projectID = policy.ProjectID
+			writeAudit(db, c, auditRecord{
+				ProjectID:  projectID,
+				Action:     "delete",
+				EntityType: "dhcp_policy",
+				EntityID:   sql.NullInt64{Int64: policyID, Valid: true},
+				EntityLabel: sql.NullString{String: policy.Tag, Valid: true},
+				Before:     snapshotDHCPPolicy(policy),
+			})
+			_, _ = db.Exec(`DELETE FROM dhcp_tag_policies WHERE id=?`, policyID)
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/allocation-validator", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			projectID = activeProjectID
+		}
+		v := AllocationValidator{
+			ProjectID:      projectID,
+			URL:            c.PostForm("url"),
+			TokenRef:       c.PostForm("token_ref"),
+			TimeoutSeconds: atoiDefault(c.PostForm("timeout_seconds"), 10),
+			FailOpen:       c.PostForm("fail_open") == "1",
+		}
+		before, existed := getAllocationValidator(db, projectID)
+		if err := saveAllocationValidator(db, v); err != nil {
+			c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)+"&validator_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		after, _ := getAllocationValidator(db, projectID)
+		action := "create"
+		var beforeSnap any
+		if existed {
+			action = "update"
+			beforeSnap = before
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      action,
+			EntityType:  "allocation_validator",
+			EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+			EntityLabel: sql.NullString{String: after.URL, Valid: true},
+			Before:      beforeSnap,
+			After:       after,
+		})
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/allocation-validator/delete", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if before, ok := getAllocationValidator(db, projectID); ok {
+			if err := deleteAllocationValidator(db, projectID); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      "delete",
+				EntityType:  "allocation_validator",
+				EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.URL, Valid: true},
+				Before:      before,
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/project-quota", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			projectID = activeProjectID
+		}
+		q := ProjectQuota{
+			ProjectID:        projectID,
+			MaxSegments:      atoiDefault(c.PostForm("max_segments"), 0),
+			MaxIPv4Addresses: int64(atoiDefault(c.PostForm("max_ipv4_addresses"), 0)),
+			MaxIPv6Prefix:    atoiDefault(strings.TrimPrefix(strings.TrimSpace(c.PostForm("max_ipv6_prefix")), "/"), 0),
+		}
+		before, existed := getProjectQuota(db, projectID)
+		if err := saveProjectQuota(db, q); err != nil {
+			c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)+"&quota_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		after, ok := getProjectQuota(db, projectID)
+		action := "update"
+		var beforeSnap, afterSnap any
+		if existed {
+			beforeSnap = before
+		} else {
+			action = "create"
+		}
+		if ok {
+			afterSnap = after
+		} else {
+			action = "delete"
+		}
+		if existed || ok {
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      action,
+				EntityType:  "project_quota",
+				EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: "quota", Valid: true},
+				Before:      beforeSnap,
+				After:       afterSnap,
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/ticketing", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			projectID = activeProjectID
+		}
+		t := TicketIntegration{
+			ProjectID:       projectID,
+			Kind:            c.PostForm("kind"),
+			URL:             c.PostForm("url"),
+			Board:           c.PostForm("board"),
+			IssueType:       c.PostForm("issue_type"),
+			Username:        c.PostForm("username"),
+			TokenRef:        c.PostForm("token_ref"),
+			SummaryTemplate: c.PostForm("summary_template"),
+		}
+		before, existed := getTicketIntegration(db, projectID)
+		if err := saveTicketIntegration(db, t); err != nil {
+			c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)+"&ticketing_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		after, _ := getTicketIntegration(db, projectID)
+		action := "create"
+		var beforeSnap any
+		if existed {
+			action = "update"
+			beforeSnap = before
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      action,
+			EntityType:  "ticket_integration",
+			EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+			EntityLabel: sql.NullString{String: after.Kind + " " + after.Board, Valid: true},
+			Before:      beforeSnap,
+			After:       after,
+		})
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/notifications/routes", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/projects?project_id=" + itoa64(projectID)
+		routes, err := parseNotificationRoutes(c.PostForm("routes"))
+		if err != nil {
+			c.Redirect(302, withBase(back+"&notification_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		before, err := listNotificationRoutes(db, projectID)
+		if err == nil {
+			err = saveNotificationRoutes(db, projectID, routes)
+		}
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "notification_routes",
+			EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+			EntityLabel: sql.NullString{String: "notification routes", Valid: true},
+			Before:      before,
+			After:       routes,
+		})
+		c.Redirect(302, withBase(back))
+	})
+	r.POST("/ticketing/delete", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if before, ok := getTicketIntegration(db, projectID); ok {
+			if err := deleteTicketIntegration(db, projectID); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      "delete",
+				EntityType:  "ticket_integration",
+				EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Kind + " " + before.Board, Valid: true},
+				Before:      before,
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/projects/archive", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		archived := c.PostForm("archived") == "1"
+		if before, ok := projectByID(db, projectID); ok && before.Archived != archived {
+			if err := setProjectArchived(db, projectID, archived); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			after, _ := projectByID(db, projectID)
+			action := "archive"
+			if !archived {
+				action = "unarchive"
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:  projectID,
+				Action:     action,
+				EntityType: "project",
+				EntityID:   sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:     snapshotProject(before),
+				After:      snapshotProject(after),
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/projects/sandbox", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		sandbox := c.PostForm("sandbox") == "1"
+		if projectID == defaultProjectID && sandbox {
+			respondError(c, 400, fmt.Errorf("the default project cannot be a sandbox"))
+			return
+		}
+		if before, ok := projectByID(db, projectID); ok && before.Sandbox != sandbox {
+			if err := setProjectSandbox(db, projectID, sandbox); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			after, _ := projectByID(db, projectID)
+			writeAudit(db, c, auditRecord{
+				ProjectID:  projectID,
+				Action:     "update",
+				EntityType: "project",
+				EntityID:   sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:     snapshotProject(before),
+				After:      snapshotProject(after),
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/projects/routing-domain", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		domain := normalizeRoutingDomain(c.PostForm("routing_domain"))
+		if before, ok := projectByID(db, projectID); ok && before.RoutingDomain != domain {
+			if err := setProjectRoutingDomain(db, projectID, domain); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			after, _ := projectByID(db, projectID)
+			writeAudit(db, c, auditRecord{
+				ProjectID:  projectID,
+				Action:     "update",
+				EntityType: "project",
+				EntityID:   sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:     snapshotProject(before),
+				After:      snapshotProject(after),
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/projects/audit-reads", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		enabled := parseBool(c.PostForm("audit_reads"))
+		if before, ok := projectByID(db, projectID); ok && before.AuditReads != enabled {
+			if err := setProjectAuditReads(db, projectID, enabled); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			after, _ := projectByID(db, projectID)
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      "update",
+				EntityType:  "project",
+				EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:      snapshotProject(before),
+				After:       snapshotProject(after),
+			})
+		}
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
+	})
+	r.POST("/maintenance", func(c *gin.Context) {
+		projectID := resolveActiveProjectID(c, db, defaultProjectID)
+		before := getMaintenance(db)
+		if before.Forced {
+			respondError(c, 409, fmt.Errorf("maintenance mode is forced by MAINTENANCE_MODE"))
+			return
+		}
+		if err := setMaintenance(db, c.PostForm("enabled") == "1", c.PostForm("message")); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		after := getMaintenance(db)
+		writeAudit(db, c, auditRecord{
+			ProjectID:  projectID,
+			Action:     "update",
+			EntityType: "maintenance",
+			Before:     before,
+			After:      after,
+		})
+		c.Redirect(302, withBase("/projects?project_id="+itoa64(projectID)))
 	})
 	r.POST("/projects/delete", func(c *gin.Context) {
 		projectID, _ := strconv.ParseInt(c.PostForm("project_id"), 10, 64)
@@ -234,31 +735,46 @@ func main() {
 			}
 		}
 		_ = deleteProject(db, projectID, defaultProjectID)
-		c.Redirect(302, "/projects")
+		c.Redirect(302, withBase("/projects"))
 	})
 
 	// Sites
-	r.GET("/sites", func(c *gin.Context) {
+	renderSites := func(c *gin.Context, split *PoolSplitResult, splitErr string, form *FormState) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		sites, _ := listSites(db, activeProjectID)
 		pools, _ := listPools(db, activeProjectID)
-		if msg := strings.TrimSpace(c.Query("pool_error")); msg != "" {
-			switch msg {
-			case "invalid_cidr":
-				cidr := strings.TrimSpace(c.Query("pool_cidr"))
-				if cidr != "" {
-					data["PoolError"] = "Некорректный CIDR пула: " + cidr
-				} else {
-					data["PoolError"] = "Некорректный CIDR пула."
-				}
-			default:
-				data["PoolError"] = "Не удалось сохранить пул."
-			}
+		if msg := strings.TrimSpace(c.Query("rdap_error")); msg != "" {
+			data["PoolError"] = "RDAP: " + msg
+		}
+		poolRDAP, _ := listPoolRDAP(db, pools)
+		tiers, _ := listPoolTiers(db, activeProjectID)
+		if c.Query("group") == "tier" {
+			pools, data["PoolTierGroups"] = groupPoolsByTier(pools, tiers)
+			data["GroupByTier"] = true
 		}
+		data["PoolTiers"] = tiers
+		data["TierOk"] = strings.TrimSpace(c.Query("tier_ok"))
+		data["TierError"] = strings.TrimSpace(c.Query("tier_error"))
 		data["Active"] = "sites"
 		data["Sites"] = sites
 		data["Pools"] = pools
-		render(c, "sites", data)
+		data["PoolRDAP"] = poolRDAP
+		segs, _ := listSegments(db, activeProjectID)
+		data["PoolCapacity"] = capacityByPool(buildCapacityReport(segs, pools, sites, 0, 0, 64))
+		data["SiteReserved"] = siteReservedRanges(sites, pools)
+		data["SiteProvenance"], _ = provenanceByEntity(db, planRowSite)
+		data["PublicPools"] = publicPoolIDs(pools)
+		data["PoolSplit"] = split
+		data["PoolSplitError"] = splitErr
+		data["Form"] = form
+		status := http.StatusOK
+		if form.Failed() {
+			status = http.StatusUnprocessableEntity
+		}
+		renderStatus(c, status, "sites", data)
+	}
+	r.GET("/sites", func(c *gin.Context) {
+		renderSites(c, nil, "", nil)
 	})
 	r.POST("/sites", func(c *gin.Context) {
 		name := strings.TrimSpace(c.PostForm("name"))
@@ -275,6 +791,21 @@ func main() {
 		dhcpBootFile := strings.TrimSpace(c.PostForm("dhcp_boot_file"))
 		dhcpNextServer := strings.TrimSpace(c.PostForm("dhcp_next_server"))
 		dhcpVendorOpts := strings.TrimSpace(c.PostForm("dhcp_vendor_options"))
+		vlanDomain := normalizeVLANDomain(c.PostForm("vlan_domain"))
+		form := newFormState(c, "site", 0)
+		validateSiteForm(form)
+		siteRules, _ := getProjectRules(db, projectID)
+		if err := checkSiteNameUnique(db, siteRules, name); err != nil {
+			form.Fail("name", "%s", err.Error())
+		}
+		if form.Failed() {
+			if projectID > 0 {
+				c.Request.URL.RawQuery = "project_id=" + itoa64(projectID)
+			}
+			renderSites(c, nil, "", form)
+			return
+		}
+		bgpASN, _ := parseASN(c.PostForm("bgp_asn"))
 
 		if name != "" {
 			var siteID int64
@@ -308,9 +839,10 @@ func main() {
 					INSERT INTO site_meta(
 						site_id, region, dns, ntp, gateway_policy, reserved_ranges,
 						dhcp_search, dhcp_lease_time, dhcp_renew_time, dhcp_rebind_time,
-						dhcp_boot_file, dhcp_next_server, dhcp_vendor_options
+						dhcp_boot_file, dhcp_next_server, dhcp_vendor_options,
+						bgp_asn, vlan_domain
 					)
-					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 					ON CONFLICT(site_id) DO UPDATE SET
 						region=excluded.region,
 						dns=excluded.dns,
@@ -323,7 +855,9 @@ func main() {
 						dhcp_rebind_time=excluded.dhcp_rebind_time,
 						dhcp_boot_file=excluded.dhcp_boot_file,
 						dhcp_next_server=excluded.dhcp_next_server,
-						dhcp_vendor_options=excluded.dhcp_vendor_options`,
+						dhcp_vendor_options=excluded.dhcp_vendor_options,
+						bgp_asn=excluded.bgp_asn,
+						vlan_domain=excluded.vlan_domain`,
 					siteID,
 					nullStringToAny(region),
 					nullStringToAny(dns),
@@ -337,7 +871,11 @@ func main() {
 					nullStringToAny(dhcpBootFile),
 					nullStringToAny(dhcpNextServer),
 					nullStringToAny(dhcpVendorOpts),
+					nullIntToAny(bgpASN),
+					nullStringToAny(vlanDomain),
 				)
+				_ = saveSiteFailover(db, siteID, c.PostForm("dhcp_failover_primary"), c.PostForm("dhcp_failover_secondary"))
+				_ = saveSiteOwner(db, siteID, c.PostForm("owner_team"), c.PostForm("owner_email"))
 				if s, ok := siteByID(db, siteID); ok {
 					action := "update"
 					if !existed {
@@ -359,33 +897,39 @@ func main() {
 				}
 			}
 		}
-		c.Redirect(302, "/sites")
+		c.Redirect(302, withBase("/sites"))
 	})
 	r.POST("/pools", func(c *gin.Context) {
 		siteID, _ := strconv.ParseInt(c.PostForm("site_id"), 10, 64)
 		cidr := strings.TrimSpace(c.PostForm("cidr"))
 		tier := strings.TrimSpace(c.PostForm("tier"))
 		priority := atoiDefault(c.PostForm("priority"), 0)
-		if siteID > 0 && cidr != "" {
-			prefix, err := netip.ParsePrefix(cidr)
-			if err != nil {
-				projectID := projectIDBySite(db, siteID)
-				values := url.Values{}
-				if projectID > 0 {
-					values.Set("project_id", itoa64(projectID))
-				}
-				values.Set("pool_error", "invalid_cidr")
-				values.Set("pool_cidr", cidr)
-				c.Redirect(302, "/sites?"+values.Encode())
-				return
+		form := newFormState(c, "pool", 0)
+		validatePoolForm(form, true)
+		if !form.Failed() {
+			poolProjectID := projectIDBySite(db, siteID)
+			poolRules, _ := getProjectRules(db, poolProjectID)
+			if err := checkPoolCIDRUnique(db, poolRules, poolProjectID, cidr, 0); err != nil {
+				form.Fail("cidr", "%s", err.Error())
 			}
+		}
+		if form.Failed() {
+			if projectID := projectIDBySite(db, siteID); projectID > 0 {
+				c.Request.URL.RawQuery = "project_id=" + itoa64(projectID)
+			}
+			renderSites(c, nil, "", form)
+			return
+		}
+		if siteID > 0 && cidr != "" {
+			prefix, _ := netip.ParsePrefix(cidr)
+			shares, _ := vrfSharesValue(c.PostForm("vrf_shares"))
 			family := "ipv4"
 			if prefix.Addr().Is6() {
 				family = "ipv6"
 			}
 			cidr = prefix.String()
-			res, err := db.Exec(`INSERT INTO pools(site_id, cidr, family, tier, priority) VALUES(?, ?, ?, ?, ?)`,
-				siteID, cidr, family, nullStringToAny(tier), priority)
+			res, err := db.Exec(`INSERT INTO pools(site_id, cidr, family, tier, priority, vrf_shares, kind) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+				siteID, cidr, family, nullStringToAny(tier), priority, shares, normalizePoolKind(c.PostForm("kind")))
 			if err == nil {
 				poolID, _ := res.LastInsertId()
 				if pool, ok := poolByID(db, poolID); ok {
@@ -401,7 +945,7 @@ func main() {
 				}
 			}
 		}
-		c.Redirect(302, "/sites")
+		c.Redirect(302, withBase("/sites"))
 	})
 	r.POST("/pools/update", func(c *gin.Context) {
 		poolID, _ := strconv.ParseInt(c.PostForm("pool_id"), 10, 64)
@@ -414,18 +958,27 @@ func main() {
 				projectID = projectIDBySite(db, pool.SiteID)
 			}
 		}
-		if poolID > 0 && cidr != "" {
-			prefix, err := netip.ParsePrefix(cidr)
-			if err != nil {
-				values := url.Values{}
-				if projectID > 0 {
-					values.Set("project_id", itoa64(projectID))
+		form := newFormState(c, "pool_edit", poolID)
+		validatePoolForm(form, false)
+		if !form.Failed() {
+			if pool, ok := poolByID(db, poolID); ok {
+				poolProjectID := projectIDBySite(db, pool.SiteID)
+				poolRules, _ := getProjectRules(db, poolProjectID)
+				if err := checkPoolCIDRUnique(db, poolRules, poolProjectID, cidr, poolID); err != nil {
+					form.Fail("cidr", "%s", err.Error())
 				}
-				values.Set("pool_error", "invalid_cidr")
-				values.Set("pool_cidr", cidr)
-				c.Redirect(302, "/sites?"+values.Encode())
-				return
 			}
+		}
+		if form.Failed() {
+			if projectID > 0 {
+				c.Request.URL.RawQuery = "project_id=" + itoa64(projectID)
+			}
+			renderSites(c, nil, "", form)
+			return
+		}
+		if poolID > 0 && cidr != "" {
+			prefix, _ := netip.ParsePrefix(cidr)
+			shares, _ := vrfSharesValue(c.PostForm("vrf_shares"))
 			family := "ipv4"
 			if prefix.Addr().Is6() {
 				family = "ipv6"
@@ -435,8 +988,8 @@ func main() {
 			if p, ok := poolByID(db, poolID); ok {
 				before = &p
 			}
-			_, _ = db.Exec(`UPDATE pools SET cidr=?, family=?, tier=?, priority=? WHERE id=?`,
-				cidr, family, nullStringToAny(tier), priority, poolID)
+			_, _ = db.Exec(`UPDATE pools SET cidr=?, family=?, tier=?, priority=?, vrf_shares=?, kind=? WHERE id=?`,
+				cidr, family, nullStringToAny(tier), priority, shares, normalizePoolKind(c.PostForm("kind")), poolID)
 			if after, ok := poolByID(db, poolID); ok {
 				var beforeSnap any
 				if before != nil {
@@ -454,10 +1007,10 @@ func main() {
 			}
 		}
 		if projectID > 0 {
-			c.Redirect(302, "/sites?project_id="+itoa64(projectID))
+			c.Redirect(302, withBase("/sites?project_id="+itoa64(projectID)))
 			return
 		}
-		c.Redirect(302, "/sites")
+		c.Redirect(302, withBase("/sites"))
 	})
 	r.POST("/pools/delete", func(c *gin.Context) {
 		poolID, _ := strconv.ParseInt(c.PostForm("pool_id"), 10, 64)
@@ -476,61 +1029,393 @@ func main() {
 			})
 		}
 		if poolID > 0 {
+			_, _ = db.Exec(`DELETE FROM import_provenance WHERE entity_type='pool' AND entity_id=?`, poolID)
 			_, _ = db.Exec(`DELETE FROM pools WHERE id=?`, poolID)
 		}
 		if projectID > 0 {
-			c.Redirect(302, "/sites?project_id="+itoa64(projectID))
+			c.Redirect(302, withBase("/sites?project_id="+itoa64(projectID)))
 			return
 		}
-		c.Redirect(302, "/sites")
+		c.Redirect(302, withBase("/sites"))
 	})
-	r.POST("/sites/delete", func(c *gin.Context) {
-		siteID, _ := strconv.ParseInt(c.PostForm("site_id"), 10, 64)
+	r.POST("/pools/state", func(c *gin.Context) {
+		poolID, _ := strconv.ParseInt(c.PostForm("pool_id"), 10, 64)
 		projectID := parseProjectID(c.PostForm("project_id"))
-		if site, ok := siteByID(db, siteID); ok {
+		state, err := parsePoolState(c.PostForm("state"))
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		if before, ok := poolByID(db, poolID); ok {
 			if projectID == 0 {
-				projectID = projectIDBySite(db, siteID)
+				projectID = projectIDBySite(db, before.SiteID)
+			}
+			_, _ = db.Exec(`UPDATE pools SET state=? WHERE id=?`, state, poolID)
+			if after, ok := poolByID(db, poolID); ok && normalizePoolState(before.State) != state {
+				writeAudit(db, c, auditRecord{
+					ProjectID:   projectID,
+					Action:      "update",
+					EntityType:  "pool",
+					EntityID:    sql.NullInt64{Int64: poolID, Valid: true},
+					EntityLabel: sql.NullString{String: after.CIDR, Valid: true},
+					Before:      snapshotPool(before),
+					After:       snapshotPool(after),
+				})
 			}
-			writeAudit(db, c, auditRecord{
-				ProjectID:  projectID,
-				Action:     "delete",
-				EntityType: "site",
-				EntityID:   sql.NullInt64{Int64: siteID, Valid: true},
-				EntityLabel: sql.NullString{String: site.Name, Valid: true},
-				Before:     snapshotSite(site),
-			})
 		}
-		_ = deleteSite(db, siteID)
 		if projectID > 0 {
-			c.Redirect(302, "/sites?project_id="+itoa64(projectID))
+			c.Redirect(302, withBase("/sites?project_id="+itoa64(projectID)))
 			return
 		}
-		c.Redirect(302, "/sites")
+		c.Redirect(302, withBase("/sites"))
 	})
-
-	// Segments
-	r.GET("/segments", func(c *gin.Context) {
+	r.GET("/renumber", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
-		sites, _ := listSites(db, activeProjectID)
-		segs, _ := listSegments(db, activeProjectID)
-		pools, _ := listPools(db, activeProjectID)
-		rules, _ := getProjectRules(db, activeProjectID)
-		statuses, conflicts := analyzeAll(segs, pools, sites, rules)
-		views := buildSegmentViews(segs, statuses, pools)
-		filters := parseSegmentFilters(c)
-		filtered := applySegmentFilters(views, filters)
-		presets, _ := listFilterPresets(db, activeProjectID, "segments")
-
-		if msg := strings.TrimSpace(c.Query("filter_ok")); msg != "" {
-			switch msg {
-			case "saved":
-				data["SegmentFilterOk"] = "Фильтр сохранен."
-			case "deleted":
-				data["SegmentFilterOk"] = "Сохраненный фильтр удален."
+		req := renumberRequestFromQuery(c)
+		data["Active"] = "sites"
+		data["Sites"], _ = listSites(db, activeProjectID)
+		data["TemplateCatalog"] = listTemplateCatalog()
+		data["Renumber"] = RenumberPlan{Request: req}
+		if req.Site != "" || req.OldRaw != "" {
+			plan, err := planRenumbering(db, activeProjectID, req)
+			data["Renumber"] = plan
+			if err != nil {
+				data["RenumberError"] = err.Error()
+			} else if req.Template != "" {
+				before, after, err := renderRenumberedConfigs(db, activeProjectID, plan, req.Template)
+				if err != nil {
+					data["RenumberError"] = err.Error()
+				} else {
+					data["RenumberDiff"] = unifiedDiffLabeled("current", "renumbered", before, after)
+				}
 			}
+			data["RenumberQuery"] = c.Request.URL.Query().Encode()
 		}
-		if msg := strings.TrimSpace(c.Query("filter_error")); msg != "" {
-			switch msg {
+		render(c, "renumber", data)
+	})
+	r.GET("/export/renumber", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		req := renumberRequestFromQuery(c)
+		plan, err := planRenumbering(db, activeProjectID, req)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		name := "subnetio_renumber_" + safeName(plan.Request.Site)
+		switch format := strings.ToLower(strings.TrimSpace(c.Query("format"))); format {
+		case "checklist":
+			projectName := "Default"
+			if p, ok := projectByID(db, activeProjectID); ok {
+				projectName = p.Name
+			}
+			c.Header("Content-Disposition", "attachment; filename="+name+"_checklist.md")
+			c.Data(200, "text/markdown; charset=utf-8", []byte(renderRenumberChecklist(plan, projectName, time.Now())))
+		case "config":
+			if req.Template == "" {
+				respondError(c, 400, errors.New("template is required for format=config"))
+				return
+			}
+			_, after, err := renderRenumberedConfigs(db, activeProjectID, plan, req.Template)
+			if err != nil {
+				respondError(c, 400, err)
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename="+name+"_"+safeName(req.Template)+".txt")
+			c.Data(200, "text/plain; charset=utf-8", []byte(after))
+		default:
+			out, ext, err := renderRenumberMapping(plan, format)
+			if err != nil {
+				respondError(c, 400, err)
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename="+name+"."+ext)
+			c.Data(200, "text/plain; charset=utf-8", []byte(out))
+		}
+	})
+	r.GET("/pools/migrate", func(c *gin.Context) {
+		data, _ := baseData(c, db, defaultProjectID)
+		pool, ok := poolByID(db, parseProjectID(c.Query("pool_id")))
+		if !ok {
+			respondError(c, 404, errors.New("pool not found"))
+			return
+		}
+		plan, err := planPoolMigration(db, pool, parseProjectID(c.Query("target_id")))
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "sites"
+		data["Migration"] = plan
+		data["MigrationError"] = c.Query("migrate_error")
+		render(c, "pool_migrate", data)
+	})
+	r.POST("/pools/migrate", func(c *gin.Context) {
+		poolID := parseProjectID(c.PostForm("pool_id"))
+		targetID := parseProjectID(c.PostForm("target_id"))
+		back := func(msg string) {
+			values := url.Values{}
+			values.Set("pool_id", itoa64(poolID))
+			if targetID > 0 {
+				values.Set("target_id", itoa64(targetID))
+			}
+			if msg != "" {
+				values.Set("migrate_error", msg)
+			}
+			c.Redirect(302, withBase("/pools/migrate?"+values.Encode()))
+		}
+		pool, ok := poolByID(db, poolID)
+		if !ok {
+			respondError(c, 404, errors.New("pool not found"))
+			return
+		}
+		if !isDrainingPool(pool) {
+			back("pool " + pool.CIDR + " is not draining")
+			return
+		}
+		plan, err := planPoolMigration(db, pool, targetID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		var move PoolMigrationMove
+		if segmentID := parseProjectID(c.PostForm("segment_id")); segmentID > 0 {
+			move, ok = plan.Move(segmentID)
+		} else {
+			move, ok = plan.Next()
+		}
+		if !ok {
+			back("nothing left to move")
+			return
+		}
+		if move.Error != "" {
+			back(move.Segment.Name + ": " + move.Error)
+			return
+		}
+		reason := sql.NullString{String: "moved out of draining pool " + pool.CIDR + " to " + move.Target, Valid: true}
+		force := segmentForceFromRequest(c)
+		forced, err := checkSegmentLock(move.Segment, "moving it", []string{"cidr"}, force)
+		if err != nil {
+			back(err.Error())
+			return
+		}
+		if forced {
+			reason.String += "; " + force.auditReason().String
+		}
+		family := normalizePoolFamily(pool.Family)
+		if err := applyPoolMigrationMove(db, family, move); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		if after, ok := segmentByID(db, move.Segment.ID); ok {
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectIDBySite(db, pool.SiteID),
+				Action:      "update",
+				EntityType:  "segment",
+				EntityID:    sql.NullInt64{Int64: after.ID, Valid: true},
+				EntityLabel: sql.NullString{String: after.Name, Valid: true},
+				Reason:      reason,
+				Before:      snapshotSegment(move.Segment),
+				After:       snapshotSegment(after),
+			})
+		}
+		back("")
+	})
+	r.POST("/pools/split", func(c *gin.Context) {
+		projectID := resolveActiveProjectID(c, db, defaultProjectID)
+		sites, _ := listSites(db, projectID)
+		pools, _ := listPools(db, projectID)
+		req, err := parsePoolSplit(c, sites)
+		if err != nil {
+			renderSites(c, &PoolSplitResult{Request: req}, err.Error(), nil)
+			return
+		}
+		plan := planPoolSplit(req, sites, pools)
+		if c.PostForm("action") != "apply" {
+			renderSites(c, &plan, "", nil)
+			return
+		}
+		ids, err := applyPoolSplit(db, plan)
+		if err != nil {
+			renderSites(c, &plan, err.Error(), nil)
+			return
+		}
+		for _, poolID := range ids {
+			if pool, ok := poolByID(db, poolID); ok {
+				writeAudit(db, c, auditRecord{
+					ProjectID:  projectID,
+					Action:     "create",
+					EntityType: "pool",
+					EntityID:   sql.NullInt64{Int64: poolID, Valid: true},
+					EntityLabel: sql.NullString{String: pool.CIDR, Valid: true},
+					After:      snapshotPool(pool),
+				})
+			}
+		}
+		c.Redirect(302, withBase("/sites?project_id="+itoa64(projectID)))
+	})
+	r.POST("/pools/rdap", func(c *gin.Context) {
+		poolID, _ := strconv.ParseInt(c.PostForm("pool_id"), 10, 64)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		pool, ok := poolByID(db, poolID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("pool not found"))
+			return
+		}
+		if projectID == 0 {
+			projectID = projectIDBySite(db, pool.SiteID)
+		}
+		target := "/sites?project_id=" + itoa64(projectID)
+		if _, err := refreshPoolRDAP(db, pool); err != nil {
+			target += "&rdap_error=" + url.QueryEscape(err.Error())
+		}
+		c.Redirect(302, withBase(target))
+	})
+	r.POST("/tiers", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/sites?project_id=" + itoa64(projectID)
+		name := normalizeTierName(c.PostForm("name"))
+		var before any
+		if tiers, err := listPoolTiers(db, projectID); err == nil {
+			for _, t := range tiers {
+				if t.Name == name {
+					before = map[string]string{"name": t.Name, "description": t.Description}
+				}
+			}
+		}
+		id, err := savePoolTier(db, projectID, PoolTier{Name: name, Description: c.PostForm("description")})
+		if err != nil {
+			c.Redirect(302, withBase(back+"&tier_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		saved, _ := poolTierByID(db, projectID, id)
+		action := "create"
+		if before != nil {
+			action = "update"
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      action,
+			EntityType:  "pool_tier",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: saved.Name, Valid: true},
+			Before:      before,
+			After:       map[string]string{"name": saved.Name, "description": saved.Description},
+		})
+		c.Redirect(302, withBase(back+"&tier_ok=saved"))
+	})
+	r.POST("/tiers/move", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/sites?project_id=" + itoa64(projectID)
+		delta := 1
+		if c.PostForm("direction") == "up" {
+			delta = -1
+		}
+		tier, ok := poolTierByID(db, projectID, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("tier not found"))
+			return
+		}
+		if err := movePoolTier(db, projectID, tier.ID, delta); err != nil {
+			c.Redirect(302, withBase(back+"&tier_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		moved, _ := poolTierByID(db, projectID, tier.ID)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "update",
+			EntityType:  "pool_tier",
+			EntityID:    sql.NullInt64{Int64: tier.ID, Valid: true},
+			EntityLabel: sql.NullString{String: tier.Name, Valid: true},
+			Before:      map[string]int{"position": tier.Position},
+			After:       map[string]int{"position": moved.Position},
+		})
+		c.Redirect(302, withBase(back))
+	})
+	r.POST("/tiers/delete", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/sites?project_id=" + itoa64(projectID)
+		tier, ok := poolTierByID(db, projectID, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("tier not found"))
+			return
+		}
+		if err := deletePoolTier(db, projectID, tier.ID); err != nil {
+			c.Redirect(302, withBase(back+"&tier_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "delete",
+			EntityType:  "pool_tier",
+			EntityID:    sql.NullInt64{Int64: tier.ID, Valid: true},
+			EntityLabel: sql.NullString{String: tier.Name, Valid: true},
+			Before:      map[string]string{"name": tier.Name, "description": tier.Description},
+		})
+		c.Redirect(302, withBase(back+"&tier_ok=deleted"))
+	})
+	r.POST("/sites/delete", func(c *gin.Context) {
+		siteID, _ := strconv.ParseInt(c.PostForm("site_id"), 10, 64)
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if site, ok := siteByID(db, siteID); ok {
+			if projectID == 0 {
+				projectID = projectIDBySite(db, siteID)
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:  projectID,
+				Action:     "delete",
+				EntityType: "site",
+				EntityID:   sql.NullInt64{Int64: siteID, Valid: true},
+				EntityLabel: sql.NullString{String: site.Name, Valid: true},
+				Before:     snapshotSite(site),
+			})
+		}
+		_ = deleteSite(db, siteID)
+		if projectID > 0 {
+			c.Redirect(302, withBase("/sites?project_id="+itoa64(projectID)))
+			return
+		}
+		c.Redirect(302, withBase("/sites"))
+	})
+
+	// Segments
+	renderSegments := func(c *gin.Context, form *FormState, quick *QuickAddPreview) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		statuses, conflicts := analyzeAll(segs, pools, sites, rules)
+		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
+		filters, appliedPreset := resolveSegmentFilters(c, db, activeProjectID)
+		filtered := applySegmentFilters(views, filters)
+		presets, _ := listFilterPresets(db, activeProjectID, "segments")
+		tiers, _ := listPoolTiers(db, activeProjectID)
+		if c.Query("group") == "tier" {
+			filtered, data["SegmentTierGroups"] = groupSegmentViewsByTier(filtered, tiers)
+			data["GroupByTier"] = true
+		}
+		data["PoolTiers"] = tiers
+
+		data["AllocateError"] = strings.TrimSpace(c.Query("allocate_error"))
+		data["AllocateHints"] = c.QueryArray("allocate_hint")
+		data["SegmentError"] = strings.TrimSpace(c.Query("segment_error"))
+		data["SegmentWarning"] = strings.TrimSpace(c.Query("segment_warning"))
+		data["DefaultVRF"] = rules.DefaultVRF
+		data["SegmentProvenance"], _ = provenanceByEntity(db, planRowSegment)
+		if msg := strings.TrimSpace(c.Query("filter_ok")); msg != "" {
+			switch msg {
+			case "saved":
+				data["SegmentFilterOk"] = "Фильтр сохранен."
+			case "deleted":
+				data["SegmentFilterOk"] = "Сохраненный фильтр удален."
+			case "default":
+				data["SegmentFilterOk"] = "Фильтр по умолчанию обновлен."
+			}
+		}
+		if msg := strings.TrimSpace(c.Query("filter_error")); msg != "" {
+			switch msg {
 			case "name":
 				data["SegmentFilterError"] = "Укажите название для сохраненного фильтра."
 			case "empty":
@@ -553,9 +1438,24 @@ func main() {
 		data["SegmentFiltersQuery"] = segmentFiltersQuery(filters)
 		data["SegmentFiltersActive"] = filtersActive(filters)
 		data["SegmentPresets"] = presets
+		data["AppliedPreset"] = appliedPreset
 		data["Conflicts"] = conflicts
 		data["Rules"] = rules
-		render(c, "segments", data)
+		data["LiveEvents"] = true
+		data["Form"] = form
+		data["SizingPresets"] = sizingPresets
+		data["QuickAdd"] = quick
+		if n := atoiDefault(c.Query("quick_added"), 0); n > 0 {
+			data["QuickAdded"] = n
+		}
+		status := http.StatusOK
+		if form.Failed() || (quick != nil && !quick.Ready()) {
+			status = http.StatusUnprocessableEntity
+		}
+		renderStatus(c, status, "segments", data)
+	}
+	r.GET("/segments", func(c *gin.Context) {
+		renderSegments(c, nil, nil)
 	})
 
 	r.POST("/segments", func(c *gin.Context) {
@@ -567,6 +1467,10 @@ func main() {
 		prefixStr := strings.TrimSpace(c.PostForm("prefix"))
 		prefixV6Str := strings.TrimSpace(c.PostForm("prefix_v6"))
 		locked := c.PostForm("locked") == "on"
+		multiPrefix := c.PostForm("multi_prefix") == "on"
+		kind := normalizeSegmentKind(c.PostForm("kind"))
+		linkA := strings.TrimSpace(c.PostForm("link_a"))
+		linkB := strings.TrimSpace(c.PostForm("link_b"))
 		dhcpEnabled := c.PostForm("dhcp_enabled") == "on"
 		dhcpRange := strings.TrimSpace(c.PostForm("dhcp_range"))
 		dhcpReservations := strings.TrimSpace(c.PostForm("dhcp_reservations"))
@@ -575,6 +1479,25 @@ func main() {
 		notes := strings.TrimSpace(c.PostForm("notes"))
 		tags := strings.TrimSpace(c.PostForm("tags"))
 		poolTier := strings.TrimSpace(c.PostForm("pool_tier"))
+		ipv6Mode, _ := normalizeIPv6Mode(c.PostForm("ipv6_mode"))
+		ipv6DNS := strings.TrimSpace(c.PostForm("ipv6_dns"))
+		ownerTeam := strings.TrimSpace(c.PostForm("owner_team"))
+		ownerEmail := strings.TrimSpace(c.PostForm("owner_email"))
+		expiresAt, _ := parseSegmentExpiry(c.PostForm("expires_at"))
+		expiryAction := normalizeExpiryAction(c.PostForm("expiry_action"))
+
+		projectID := projectIDBySite(db, siteID)
+		rules, _ := getProjectRules(db, projectID)
+		form := newFormState(c, "segment", 0)
+		validateSegmentForm(form, true, rules.DefaultVRF)
+		sizing, sized := sizeSegmentForm(form)
+		if sized && kind == SegmentKindLink {
+			form.Fail("sizing", "Link segments are sized by prefix only.")
+		}
+		if form.Failed() {
+			renderSegments(c, form, nil)
+			return
+		}
 
 		var hosts sql.NullInt64
 		if hostsStr != "" {
@@ -595,19 +1518,59 @@ func main() {
 			}
 		}
 
+		if sized {
+			hosts = sql.NullInt64{Int64: int64(sizing.Addresses), Valid: true}
+			prefix = sql.NullInt64{Int64: int64(sizing.Prefix), Valid: true}
+		}
+		if vrf == "" {
+			vrf = rules.DefaultVRF
+		}
+		if kind == SegmentKindLink {
+			var err error
+			if prefix, prefixV6, err = linkSizes(hosts, prefix, prefixV6); err != nil {
+				form.Fail(linkSizeField(hosts, prefix), "%s", err.Error())
+			}
+			multiPrefix = false
+		} else {
+			linkA, linkB = "", ""
+		}
+		if err := checkPoolTierReference(db, projectID, poolTier); err != nil {
+			form.Fail("pool_tier", "%s", err.Error())
+		}
+		if err := checkSegmentNameUnique(db, rules, projectID, vrf, name, 0); err != nil {
+			form.Fail("name", "%s", err.Error())
+		}
+		var segmentWarnings []string
+		if !form.Failed() {
+			known, _ := projectVRFs(db, projectID, 0)
+			warnings, err := checkSegmentRules(rules, vrf, vlan, known)
+			if err == nil {
+				err = checkSegmentQuota(db, projectID, 1)
+			}
+			if err != nil {
+				form.Fail("", "%s", err.Error())
+			}
+			segmentWarnings = append(warnings, sizing.Warnings()...)
+		}
+		if form.Failed() {
+			renderSegments(c, form, nil)
+			return
+		}
 		if siteID > 0 && vrf != "" && vlan > 0 && name != "" {
 			res, _ := db.Exec(`
-				INSERT INTO segments(site_id, vrf, vlan, name, hosts, prefix, prefix_v6, locked)
-				VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+				INSERT INTO segments(site_id, vrf, vlan, name, hosts, prefix, prefix_v6, locked, multi_prefix, expires_at, expiry_action, kind, link_a, link_b)
+				VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 				siteID, vrf, vlan, name,
 				nullIntToAny(hosts), nullIntToAny(prefix), nullIntToAny(prefixV6),
-				boolToInt(locked),
+				boolToInt(locked), boolToInt(multiPrefix),
+				nullStringToAny(expiresAt.String), expiryAction,
+				kind, nullStringToAny(linkA), nullStringToAny(linkB),
 			)
 			segID, _ := res.LastInsertId()
 			if segID > 0 {
 				_, _ = db.Exec(`
-					INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, dhcp_reservations, gateway, gateway_v6, notes, tags, pool_tier)
-					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+					INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, dhcp_reservations, gateway, gateway_v6, notes, tags, pool_tier, ipv6_mode, ipv6_dns, owner_team, owner_email)
+					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 					ON CONFLICT(segment_id) DO UPDATE SET
 						dhcp_enabled=excluded.dhcp_enabled,
 						dhcp_range=excluded.dhcp_range,
@@ -616,7 +1579,11 @@ func main() {
 						gateway_v6=excluded.gateway_v6,
 						notes=excluded.notes,
 						tags=excluded.tags,
-						pool_tier=excluded.pool_tier`,
+						pool_tier=excluded.pool_tier,
+						ipv6_mode=excluded.ipv6_mode,
+						ipv6_dns=excluded.ipv6_dns,
+						owner_team=excluded.owner_team,
+						owner_email=excluded.owner_email`,
 					segID,
 					boolToInt(dhcpEnabled),
 					nullStringToAny(dhcpRange),
@@ -626,9 +1593,12 @@ func main() {
 					nullStringToAny(notes),
 					nullStringToAny(tags),
 					nullStringToAny(poolTier),
+					nullStringToAny(ipv6Mode),
+					nullStringToAny(ipv6DNS),
+					nullStringToAny(ownerTeam),
+					nullStringToAny(ownerEmail),
 				)
 				if seg, ok := segmentByID(db, segID); ok {
-					projectID := projectIDBySite(db, siteID)
 					writeAudit(db, c, auditRecord{
 						ProjectID:  projectID,
 						Action:     "create",
@@ -640,7 +1610,58 @@ func main() {
 				}
 			}
 		}
-		c.Redirect(302, "/segments")
+		if len(segmentWarnings) > 0 {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, "", "segment_warning", strings.Join(segmentWarnings, "; "))))
+			return
+		}
+		c.Redirect(302, withBase("/segments"))
+	})
+	quickAddPreview := func(c *gin.Context) (*QuickAddPreview, int64) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID <= 0 {
+			projectID = resolveActiveProjectID(c, db, defaultProjectID)
+		}
+		c.Request.URL.RawQuery = "project_id=" + itoa64(projectID)
+		sites, _ := listSites(db, projectID)
+		return previewQuickAdd(db, projectID, sites, c.PostForm("rows")), projectID
+	}
+	r.POST("/segments/quick-add/preview", func(c *gin.Context) {
+		preview, _ := quickAddPreview(c)
+		renderSegments(c, nil, preview)
+	})
+	r.POST("/segments/quick-add", func(c *gin.Context) {
+		preview, projectID := quickAddPreview(c)
+		if !preview.Ready() {
+			renderSegments(c, nil, preview)
+			return
+		}
+		ids, err := applyQuickAdd(db, preview)
+		if err != nil {
+			preview.Error = err.Error()
+			renderSegments(c, nil, preview)
+			return
+		}
+		var warnings []string
+		for i, segID := range ids {
+			for _, w := range preview.Rows[i].Warnings {
+				warnings = append(warnings, preview.Rows[i].Name+": "+w)
+			}
+			if seg, ok := segmentByID(db, segID); ok {
+				writeAudit(db, c, auditRecord{
+					ProjectID:   projectID,
+					Action:      "create",
+					EntityType:  "segment",
+					EntityID:    sql.NullInt64{Int64: segID, Valid: true},
+					EntityLabel: sql.NullString{String: seg.Name, Valid: true},
+					After:       snapshotSegment(seg),
+				})
+			}
+		}
+		target := segmentsRedirectURL(projectID, "", "quick_added", strconv.Itoa(len(ids)))
+		if len(warnings) > 0 {
+			target += "&segment_warning=" + url.QueryEscape(strings.Join(warnings, "; "))
+		}
+		c.Redirect(302, withBase(target))
 	})
 	r.POST("/segments/update", func(c *gin.Context) {
 		segmentID, _ := strconv.ParseInt(c.PostForm("segment_id"), 10, 64)
@@ -651,17 +1672,28 @@ func main() {
 		prefixStr := strings.TrimSpace(c.PostForm("prefix"))
 		prefixV6Str := strings.TrimSpace(c.PostForm("prefix_v6"))
 		locked := c.PostForm("locked") == "on"
-		dhcpEnabled := c.PostForm("dhcp_enabled") == "on"
-		dhcpRange := strings.TrimSpace(c.PostForm("dhcp_range"))
-		dhcpReservations := strings.TrimSpace(c.PostForm("dhcp_reservations"))
-		gateway := strings.TrimSpace(c.PostForm("gateway"))
-		gatewayV6 := strings.TrimSpace(c.PostForm("gateway_v6"))
-		notes := strings.TrimSpace(c.PostForm("notes"))
-		tags := strings.TrimSpace(c.PostForm("tags"))
-		poolTier := strings.TrimSpace(c.PostForm("pool_tier"))
+		multiPrefix := c.PostForm("multi_prefix") == "on"
+		kind := normalizeSegmentKind(c.PostForm("kind"))
+		linkA := strings.TrimSpace(c.PostForm("link_a"))
+		linkB := strings.TrimSpace(c.PostForm("link_b"))
+		metaPatch := segmentMetaPatchFromForm(c)
+		expiresAt, _ := parseSegmentExpiry(c.PostForm("expires_at"))
+		expiryAction := normalizeExpiryAction(c.PostForm("expiry_action"))
 		projectID := parseProjectID(c.PostForm("project_id"))
 		returnTo := normalizeSegmentFilterQuery(c.PostForm("return_to"))
 
+		// A rejected edit is shown on the list it was made from.
+		form := newFormState(c, "segment_edit", segmentID)
+		rejectEdit := func() {
+			_, c.Request.URL.RawQuery, _ = strings.Cut(segmentsRedirectURL(projectID, returnTo, "", ""), "?")
+			renderSegments(c, form, nil)
+		}
+		validateSegmentForm(form, false, "")
+		if form.Failed() {
+			rejectEdit()
+			return
+		}
+
 		var hosts sql.NullInt64
 		if hostsStr != "" {
 			if v, err := strconv.ParseInt(hostsStr, 10, 64); err == nil && v > 0 {
@@ -681,10 +1713,71 @@ func main() {
 			}
 		}
 
+		if kind == SegmentKindLink {
+			var err error
+			if prefix, prefixV6, err = linkSizes(hosts, prefix, prefixV6); err != nil {
+				form.Fail(linkSizeField(hosts, prefix), "%s", err.Error())
+				rejectEdit()
+				return
+			}
+			multiPrefix = false
+		} else {
+			linkA, linkB = "", ""
+		}
+
+		var segmentWarnings []string
+		if segmentID > 0 && vrf != "" && vlan != 0 && name != "" {
+			if seg, ok := segmentByID(db, segmentID); ok {
+				rulesProjectID := projectIDBySite(db, seg.SiteID)
+				rules, _ := getProjectRules(db, rulesProjectID)
+				known, _ := projectVRFs(db, rulesProjectID, segmentID)
+				warnings, err := checkSegmentRules(rules, vrf, vlan, known)
+				if err != nil {
+					form.Fail("", "%s", err.Error())
+				}
+				if metaPatch.PoolTier.Value != nil {
+					if err := checkPoolTierReference(db, rulesProjectID, *metaPatch.PoolTier.Value); err != nil {
+						form.Fail("pool_tier", "%s", err.Error())
+					}
+				}
+				if !strings.EqualFold(seg.Name, name) || seg.VRF != vrf {
+					if err := checkSegmentNameUnique(db, rules, rulesProjectID, vrf, name, segmentID); err != nil {
+						form.Fail("name", "%s", err.Error())
+					}
+				}
+				if form.Failed() {
+					if projectID == 0 {
+						projectID = rulesProjectID
+					}
+					rejectEdit()
+					return
+				}
+				segmentWarnings = warnings
+			}
+		}
 		if segmentID > 0 && vrf != "" && vlan > 0 && name != "" {
 			var before *Segment
+			var lockReason sql.NullString
 			if seg, ok := segmentByID(db, segmentID); ok {
 				before = &seg
+				proposed := seg
+				proposed.VRF, proposed.VLAN, proposed.Name = vrf, vlan, name
+				proposed.Hosts, proposed.Prefix, proposed.PrefixV6 = hosts, prefix, prefixV6
+				proposed.Locked, proposed.MultiPrefix, proposed.Kind = locked, multiPrefix, kind
+				proposed.LinkA, proposed.LinkB = sql.NullString{String: linkA, Valid: linkA != ""}, sql.NullString{String: linkB, Valid: linkB != ""}
+				if !multiPrefix {
+					proposed.SecondaryCIDRs = sql.NullString{}
+				}
+				proposed = metaPatch.applyTo(proposed)
+				force := segmentForceFromRequest(c)
+				forced, err := checkSegmentLock(seg, "editing it", segmentChanges(seg, proposed), force)
+				if err != nil {
+					c.Redirect(302, withBase(segmentsRedirectURL(projectIDBySite(db, seg.SiteID), returnTo, "segment_error", err.Error())))
+					return
+				}
+				if forced {
+					lockReason = force.auditReason()
+				}
 			}
 			_, _ = db.Exec(`
 				UPDATE segments SET
@@ -694,7 +1787,15 @@ func main() {
 					hosts=?,
 					prefix=?,
 					prefix_v6=?,
-					locked=?
+					locked=?,
+					multi_prefix=?,
+					secondary_cidrs=CASE WHEN ? THEN secondary_cidrs ELSE NULL END,
+					expired_at=CASE WHEN COALESCE(expires_at, '')=COALESCE(?, '') THEN expired_at ELSE NULL END,
+					expires_at=?,
+					expiry_action=?,
+					kind=?,
+					link_a=?,
+					link_b=?
 				WHERE id=?`,
 				vrf,
 				vlan,
@@ -703,36 +1804,18 @@ func main() {
 				nullIntToAny(prefix),
 				nullIntToAny(prefixV6),
 				boolToInt(locked),
+				boolToInt(multiPrefix),
+				boolToInt(multiPrefix),
+				nullStringToAny(expiresAt.String),
+				nullStringToAny(expiresAt.String),
+				expiryAction,
+				kind,
+				nullStringToAny(linkA),
+				nullStringToAny(linkB),
 				segmentID,
 			)
 
-			metaProvided := dhcpEnabled || dhcpRange != "" || dhcpReservations != "" || gateway != "" || gatewayV6 != "" || tags != "" || notes != "" || poolTier != ""
-			if metaProvided {
-				_, _ = db.Exec(`
-					INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, dhcp_reservations, gateway, gateway_v6, notes, tags, pool_tier)
-					VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
-					ON CONFLICT(segment_id) DO UPDATE SET
-						dhcp_enabled=excluded.dhcp_enabled,
-						dhcp_range=excluded.dhcp_range,
-						dhcp_reservations=excluded.dhcp_reservations,
-						gateway=excluded.gateway,
-						gateway_v6=excluded.gateway_v6,
-						notes=excluded.notes,
-						tags=excluded.tags,
-						pool_tier=excluded.pool_tier`,
-					segmentID,
-					boolToInt(dhcpEnabled),
-					nullStringToAny(dhcpRange),
-					nullStringToAny(dhcpReservations),
-					nullStringToAny(gateway),
-					nullStringToAny(gatewayV6),
-					nullStringToAny(notes),
-					nullStringToAny(tags),
-					nullStringToAny(poolTier),
-				)
-			} else {
-				_, _ = db.Exec(`DELETE FROM segment_meta WHERE segment_id=?`, segmentID)
-			}
+			_ = applySegmentMetaPatch(db, segmentID, metaPatch)
 
 			if after, ok := segmentByID(db, segmentID); ok {
 				var beforeSnap any
@@ -751,24 +1834,29 @@ func main() {
 					EntityType: "segment",
 					EntityID:   sql.NullInt64{Int64: segmentID, Valid: true},
 					EntityLabel: sql.NullString{String: after.Name, Valid: true},
+					Reason:     lockReason,
 					Before:     beforeSnap,
 					After:      snapshotSegment(after),
 				})
 			}
 		}
+		if len(segmentWarnings) > 0 {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "segment_warning", strings.Join(segmentWarnings, "; "))))
+			return
+		}
 		if projectID > 0 {
 			redirect := "/segments?project_id=" + itoa64(projectID)
 			if returnTo != "" {
 				redirect += "&" + returnTo
 			}
-			c.Redirect(302, redirect)
+			c.Redirect(302, withBase(redirect))
 			return
 		}
 		if returnTo != "" {
-			c.Redirect(302, "/segments?"+returnTo)
+			c.Redirect(302, withBase("/segments?"+returnTo))
 			return
 		}
-		c.Redirect(302, "/segments")
+		c.Redirect(302, withBase("/segments"))
 	})
 	r.POST("/segments/delete", func(c *gin.Context) {
 		segmentID, _ := strconv.ParseInt(c.PostForm("segment_id"), 10, 64)
@@ -778,12 +1866,23 @@ func main() {
 			if projectID == 0 {
 				projectID = projectIDBySite(db, seg.SiteID)
 			}
+			force := segmentForceFromRequest(c)
+			forced, err := checkSegmentLock(seg, "deleting it", nil, force)
+			if err != nil {
+				c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "segment_error", err.Error())))
+				return
+			}
+			var lockReason sql.NullString
+			if forced {
+				lockReason = force.auditReason()
+			}
 			writeAudit(db, c, auditRecord{
 				ProjectID:  projectID,
 				Action:     "delete",
 				EntityType: "segment",
 				EntityID:   sql.NullInt64{Int64: segmentID, Valid: true},
 				EntityLabel: sql.NullString{String: seg.Name, Valid: true},
+				Reason:     lockReason,
 				Before:     snapshotSegment(seg),
 			})
 		}
@@ -793,21 +1892,171 @@ func main() {
 			if returnTo != "" {
 				redirect += "&" + returnTo
 			}
-			c.Redirect(302, redirect)
+			c.Redirect(302, withBase(redirect))
 			return
 		}
 		if returnTo != "" {
-			c.Redirect(302, "/segments?"+returnTo)
+			c.Redirect(302, withBase("/segments?"+returnTo))
 			return
 		}
-		c.Redirect(302, "/segments")
+		c.Redirect(302, withBase("/segments"))
 	})
-
-	r.POST("/filters/save", func(c *gin.Context) {
-		projectID := parseProjectID(c.PostForm("project_id"))
-		if projectID == 0 {
-			_, projectID = baseData(c, db, defaultProjectID)
-		}
+	r.GET("/segments/bulk-delete", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		ids := parseSegmentIDs(c.QueryArray("segment_ids"))
+		importID := parseProjectID(c.Query("import_id"))
+		if importID > 0 {
+			imported, err := importedSegmentIDs(db, activeProjectID, importID)
+			if err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			ids = append(ids, imported...)
+		}
+		preview, err := previewBulkDelete(db, activeProjectID, ids)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "segments"
+		data["BulkDelete"] = preview
+		data["BulkDeleteImportID"] = importID
+		data["ReturnTo"] = normalizeSegmentFilterQuery(c.Query("return_to"))
+		render(c, "segments_bulk_delete", data)
+	})
+	r.POST("/segments/bulk-delete", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			_, projectID = baseData(c, db, defaultProjectID)
+		}
+		returnTo := normalizeSegmentFilterQuery(c.PostForm("return_to"))
+		redirect := "/segments?project_id=" + itoa64(projectID)
+		if returnTo != "" {
+			redirect += "&" + returnTo
+		}
+		preview, err := previewBulkDelete(db, projectID, parseSegmentIDs(c.PostFormArray("segment_ids")))
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		if len(preview.Segments) == 0 {
+			c.Redirect(302, withBase(redirect))
+			return
+		}
+		var lockReason sql.NullString
+		force := segmentForceFromRequest(c)
+		for _, s := range preview.Segments {
+			forced, err := checkSegmentLock(s.Segment, "deleting it", nil, force)
+			if err != nil {
+				c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "segment_error", err.Error())))
+				return
+			}
+			if forced {
+				lockReason = force.auditReason()
+			}
+		}
+		if err := bulkDeleteSegments(db, preview.IDs()); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		snapshots := make([]any, 0, len(preview.Segments))
+		for _, s := range preview.Segments {
+			snapshots = append(snapshots, snapshotSegment(s.Segment))
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "bulk_delete",
+			EntityType:  "segment",
+			EntityLabel: sql.NullString{String: itoa(len(preview.Segments)) + " segments", Valid: true},
+			Reason:      lockReason,
+			Before: gin.H{
+				"segments":         snapshots,
+				"segment_meta":     preview.MetaRows,
+				"mac_reservations": preview.Reservations,
+				"deployed_configs": preview.Configs,
+				"schedules":        preview.Schedules,
+			},
+		})
+		c.Redirect(302, withBase(redirect))
+	})
+	r.GET("/segments/bulk-dhcp", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		returnTo := normalizeSegmentFilterQuery(c.Query("return_to"))
+		req, err := bulkDHCPRequestFrom(c.Query)
+		if err != nil {
+			data["BulkDHCPError"] = err.Error()
+		}
+		ids := parseSegmentIDs(c.QueryArray("segment_ids"))
+		preview, err := previewBulkDHCP(db, activeProjectID, ids, returnTo, req)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "segments"
+		data["BulkDHCP"] = preview
+		data["BulkDHCPPicked"] = len(ids) > 0
+		data["ReturnTo"] = returnTo
+		render(c, "segments_bulk_dhcp", data)
+	})
+	r.POST("/segments/bulk-dhcp", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			_, projectID = baseData(c, db, defaultProjectID)
+		}
+		returnTo := normalizeSegmentFilterQuery(c.PostForm("return_to"))
+		req, err := bulkDHCPRequestFrom(c.PostForm)
+		if err != nil {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "segment_error", err.Error())))
+			return
+		}
+		preview, err := previewBulkDHCP(db, projectID, parseSegmentIDs(c.PostFormArray("segment_ids")), returnTo, req)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		var lockReason sql.NullString
+		force := segmentForceFromRequest(c)
+		var before, after []any
+		for _, s := range preview.Segments {
+			if !s.Changed {
+				continue
+			}
+			forced, err := checkSegmentLock(s.Segment, "changing DHCP", segmentChanges(s.Segment, s.After), force)
+			if err != nil {
+				c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "segment_error", err.Error())))
+				return
+			}
+			if forced {
+				lockReason = force.auditReason()
+			}
+			before = append(before, snapshotSegment(s.Segment))
+			after = append(after, snapshotSegment(s.After))
+		}
+		if preview.Changed == 0 {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "", "")))
+			return
+		}
+		if err := applyBulkDHCP(db, preview); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "bulk_update",
+			EntityType:  "segment",
+			EntityLabel: sql.NullString{String: itoa(preview.Changed) + " segments", Valid: true},
+			Reason:      lockReason,
+			Before:      gin.H{"segments": before},
+			After:       gin.H{"segments": after, "dhcp": preview.Request},
+		})
+		c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "", "")))
+	})
+
+	r.POST("/filters/save", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			_, projectID = baseData(c, db, defaultProjectID)
+		}
 		page := strings.TrimSpace(c.PostForm("page"))
 		if page == "" {
 			page = "segments"
@@ -815,22 +2064,33 @@ func main() {
 		name := strings.TrimSpace(c.PostForm("name"))
 		normalizedQuery := normalizeSegmentFilterQuery(c.PostForm("query"))
 		if page != "segments" {
-			c.Redirect(302, segmentsRedirectURL(projectID, "", "filter_error", "invalid"))
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, "", "filter_error", "invalid")))
 			return
 		}
 		if name == "" {
-			c.Redirect(302, segmentsRedirectURL(projectID, normalizedQuery, "filter_error", "name"))
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, normalizedQuery, "filter_error", "name")))
 			return
 		}
 		if normalizedQuery == "" {
-			c.Redirect(302, segmentsRedirectURL(projectID, "", "filter_error", "empty"))
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, "", "filter_error", "empty")))
 			return
 		}
-		if err := saveFilterPreset(db, projectID, page, name, normalizedQuery); err != nil {
-			c.Redirect(302, segmentsRedirectURL(projectID, normalizedQuery, "filter_error", "save"))
+		presetID, err := saveFilterPreset(db, FilterPreset{
+			ProjectID:   projectID,
+			Page:        page,
+			Name:        name,
+			Description: strings.TrimSpace(c.PostForm("description")),
+			Scope:       c.PostForm("scope"),
+			Query:       normalizedQuery,
+		})
+		if err == nil && parseBool(c.PostForm("default")) {
+			err = setDefaultFilterPreset(db, projectID, page, presetID)
+		}
+		if err != nil {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, normalizedQuery, "filter_error", "save")))
 			return
 		}
-		c.Redirect(302, segmentsRedirectURL(projectID, normalizedQuery, "filter_ok", "saved"))
+		c.Redirect(302, withBase(segmentsRedirectURL(projectID, normalizedQuery, "filter_ok", "saved")))
 	})
 
 	r.POST("/filters/delete", func(c *gin.Context) {
@@ -845,29 +2105,256 @@ func main() {
 		presetID, _ := strconv.ParseInt(c.PostForm("preset_id"), 10, 64)
 		returnTo := normalizeSegmentFilterQuery(c.PostForm("return_to"))
 		if page != "segments" || presetID <= 0 {
-			c.Redirect(302, segmentsRedirectURL(projectID, returnTo, "filter_error", "invalid"))
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_error", "invalid")))
 			return
 		}
 		if err := deleteFilterPreset(db, projectID, presetID, page); err != nil {
-			c.Redirect(302, segmentsRedirectURL(projectID, returnTo, "filter_error", "delete"))
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_error", "delete")))
+			return
+		}
+		c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_ok", "deleted")))
+	})
+
+	r.POST("/filters/default", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if projectID == 0 {
+			_, projectID = baseData(c, db, defaultProjectID)
+		}
+		presetID, _ := strconv.ParseInt(c.PostForm("preset_id"), 10, 64)
+		returnTo := normalizeSegmentFilterQuery(c.PostForm("return_to"))
+		if presetID > 0 {
+			if _, ok := filterPresetByID(db, projectID, presetID); !ok {
+				c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_error", "invalid")))
+				return
+			}
+		}
+		if err := setDefaultFilterPreset(db, projectID, "segments", presetID); err != nil {
+			c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_error", "save")))
+			return
+		}
+		c.Redirect(302, withBase(segmentsRedirectURL(projectID, returnTo, "filter_ok", "default")))
+	})
+
+	// Filter presets API
+	r.GET("/api/filters", func(c *gin.Context) {
+		_, projectID := baseData(c, db, defaultProjectID)
+		page := strings.TrimSpace(c.DefaultQuery("page", "segments"))
+		presets, err := listFilterPresets(db, projectID, page)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		out := make([]gin.H, 0, len(presets))
+		for _, p := range presets {
+			out = append(out, filterPresetJSON(p, projectID))
+		}
+		c.JSON(200, gin.H{"project_id": projectID, "page": page, "presets": out})
+	})
+	r.GET("/api/filters/:id", func(c *gin.Context) {
+		_, projectID := baseData(c, db, defaultProjectID)
+		presetID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+		preset, ok := filterPresetByID(db, projectID, presetID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("preset not found"))
+			return
+		}
+		c.JSON(200, filterPresetJSON(preset, projectID))
+	})
+	r.POST("/api/filters", func(c *gin.Context) {
+		_, projectID := baseData(c, db, defaultProjectID)
+		var req filterPresetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, 400, fmt.Errorf("invalid json: %v", err))
+			return
+		}
+		if req.ProjectID > 0 {
+			projectID = req.ProjectID
+		}
+		if !projectExists(db, projectID) {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		preset := FilterPreset{ProjectID: projectID, Page: "segments"}
+		if err := req.apply(&preset); err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		id, err := saveFilterPreset(db, preset)
+		if err == nil && req.Default != nil && *req.Default {
+			err = setDefaultFilterPreset(db, projectID, preset.Page, id)
+		}
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		preset, _ = filterPresetByID(db, projectID, id)
+		c.JSON(201, filterPresetJSON(preset, projectID))
+	})
+	r.PUT("/api/filters/:id", func(c *gin.Context) {
+		_, projectID := baseData(c, db, defaultProjectID)
+		presetID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+		preset, ok := filterPresetByID(db, projectID, presetID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("preset not found"))
+			return
+		}
+		var req filterPresetRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, 400, fmt.Errorf("invalid json: %v", err))
+			return
+		}
+		if err := req.apply(&preset); err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		err := updateFilterPreset(db, preset)
+		if err == nil && req.Default != nil {
+			switch {
+			case *req.Default:
+				err = setDefaultFilterPreset(db, projectID, preset.Page, preset.ID)
+			case preset.IsDefault:
+				err = setDefaultFilterPreset(db, projectID, preset.Page, 0)
+			}
+		}
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		preset, _ = filterPresetByID(db, projectID, presetID)
+		c.JSON(200, filterPresetJSON(preset, projectID))
+	})
+	r.DELETE("/api/filters/:id", func(c *gin.Context) {
+		_, projectID := baseData(c, db, defaultProjectID)
+		presetID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+		preset, ok := filterPresetByID(db, projectID, presetID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("preset not found"))
+			return
+		}
+		if err := deleteFilterPreset(db, projectID, preset.ID, preset.Page); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.Status(204)
+	})
+	r.PATCH("/api/segments/:id", func(c *gin.Context) {
+		segmentID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+		before, ok := segmentByID(db, segmentID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("segment not found"))
+			return
+		}
+		if projectArchived(db, projectIDBySite(db, before.SiteID)) {
+			respondError(c, 409, fmt.Errorf("project of segment %s is archived (read-only); unarchive it to make changes", before.Name))
+			return
+		}
+		var patch segmentPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			respondError(c, 400, fmt.Errorf("invalid json: %v", err))
+			return
+		}
+		if err := patch.validate(); err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		if isLinkSegment(before) && patch.Hosts.Set && patch.Hosts.Value != nil {
+			respondError(c, 422, fmt.Errorf("link segments are sized by prefix, not hosts"))
+			return
+		}
+		force := segmentForceFromRequest(c)
+		forced, err := checkSegmentLock(before, "editing it", segmentChanges(before, patch.applyTo(before)), force)
+		if err != nil {
+			status := 409
+			if errors.Is(err, errSegmentForceReason) {
+				status = 400
+			}
+			respondError(c, status, err)
+			return
+		}
+		var lockReason sql.NullString
+		if forced {
+			lockReason = force.auditReason()
+		}
+		if patch.Name.Set && !strings.EqualFold(before.Name, strings.TrimSpace(*patch.Name.Value)) {
+			projectID := projectIDBySite(db, before.SiteID)
+			rules, _ := getProjectRules(db, projectID)
+			if err := checkSegmentNameUnique(db, rules, projectID, before.VRF, *patch.Name.Value, segmentID); err != nil {
+				respondError(c, 422, err)
+				return
+			}
+		}
+		if patch.VLAN.Set {
+			projectID := projectIDBySite(db, before.SiteID)
+			rules, _ := getProjectRules(db, projectID)
+			known, _ := projectVRFs(db, projectID, segmentID)
+			warnings, err := checkSegmentRules(rules, before.VRF, *patch.VLAN.Value, known)
+			if err != nil {
+				respondError(c, 422, err)
+				return
+			}
+			for _, w := range warnings {
+				c.Writer.Header().Add("Warning", `199 subnetio "`+strings.ReplaceAll(w, `"`, `'`)+`"`)
+			}
+		}
+		if err := applySegmentPatch(db, segmentID, patch); err != nil {
+			respondError(c, 500, err)
 			return
 		}
-		c.Redirect(302, segmentsRedirectURL(projectID, returnTo, "filter_ok", "deleted"))
+		after, _ := segmentByID(db, segmentID)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectIDBySite(db, after.SiteID),
+			Action:      "update",
+			EntityType:  "segment",
+			EntityID:    sql.NullInt64{Int64: segmentID, Valid: true},
+			EntityLabel: sql.NullString{String: after.Name, Valid: true},
+			Reason:      lockReason,
+			Before:      snapshotSegment(before),
+			After:       snapshotSegment(after),
+		})
+		c.JSON(200, snapshotSegment(after))
 	})
 
 	// Allocate (VLSM IPv4)
 	r.POST("/allocate", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		beforeSegs, _ := listSegments(db, activeProjectID)
-		if err := allocateProject(db, activeProjectID); err != nil {
-			c.String(500, fmt.Sprintf("allocate error: %v", err))
-			return
-		}
-		afterSegs, _ := listSegments(db, activeProjectID)
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
 		}
+		var check allocationCheck
+		if v, ok := getAllocationValidator(db, activeProjectID); ok {
+			check = allocationValidatorCheck(v, project, auditActor(c))
+		}
+		check = chainAllocationChecks(check, allocationHookCheck(project, auditActor(c)))
+		if err := allocateProject(db, activeProjectID, check); err != nil {
+			if isValidatorError(err) || isQuotaError(err) {
+				writeAudit(db, c, auditRecord{
+					ProjectID:  activeProjectID,
+					Action:     "allocate_rejected",
+					EntityType: "allocation",
+					EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
+					EntityLabel: sql.NullString{String: project.Name, Valid: true},
+					After:      map[string]string{"error": err.Error()},
+				})
+				c.Redirect(302, withBase("/segments?project_id="+itoa64(activeProjectID)+"&allocate_error="+url.QueryEscape(err.Error())))
+				return
+			}
+			var allocErr *AllocationError
+			if errors.As(err, &allocErr) {
+				q := url.Values{}
+				q.Set("project_id", itoa64(activeProjectID))
+				q.Set("allocate_error", allocErr.Error())
+				for _, alt := range allocErr.Alternatives {
+					q.Add("allocate_hint", alt.Detail)
+				}
+				c.Redirect(302, withBase("/segments?"+q.Encode()))
+				return
+			}
+			respondError(c, 500, fmt.Errorf("allocate error: %v", err))
+			return
+		}
+		afterSegs, _ := listSegments(db, activeProjectID)
 		summary := buildAllocationSummary(beforeSegs, afterSegs)
 		writeAudit(db, c, auditRecord{
 			ProjectID:  activeProjectID,
@@ -877,60 +2364,468 @@ func main() {
 			EntityLabel: sql.NullString{String: project.Name, Valid: true},
 			After:      summary,
 		})
-		c.Redirect(302, "/segments?project_id="+itoa64(activeProjectID))
+		go notifyAllocationHook(project, auditActor(c), summary)
+		c.Redirect(302, withBase("/segments?project_id="+itoa64(activeProjectID)))
 	})
 
-	// Conflicts & Rules
-	r.GET("/conflicts", func(c *gin.Context) {
+	// MAC inventory
+	r.GET("/macs", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
-		sites, _ := listSites(db, activeProjectID)
+		list, err := listMACReservations(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
 		segs, _ := listSegments(db, activeProjectID)
-		pools, _ := listPools(db, activeProjectID)
-		rules, _ := getProjectRules(db, activeProjectID)
-		_, conflicts := analyzeAll(segs, pools, sites, rules)
-		data["Active"] = "conflicts"
-		data["Conflicts"] = conflicts
-		data["Rules"] = rules
-		render(c, "conflicts", data)
+		data["Active"] = "macs"
+		data["MACs"] = list
+		data["MACDuplicates"] = duplicateMACs(list)
+		data["Segments"] = segs
+		data["MACOk"] = strings.TrimSpace(c.Query("mac_ok"))
+		data["MACError"] = strings.TrimSpace(c.Query("mac_error"))
+		render(c, "macs", data)
+	})
+	r.POST("/macs", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/macs?project_id=" + itoa64(projectID)
+		reservation := MACReservation{
+			SegmentID: parseProjectID(c.PostForm("segment_id")),
+			MAC:       c.PostForm("mac"),
+			IP:        c.PostForm("ip"),
+			Hostname:  c.PostForm("hostname"),
+			Notes:     c.PostForm("notes"),
+		}
+		id, err := saveMACReservation(db, reservation)
+		if err != nil {
+			c.Redirect(302, withBase(back+"&mac_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		saved, _ := macReservationByID(db, id)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "mac_reservation",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: saved.MAC, Valid: true},
+			After:       map[string]string{"segment_id": itoa64(saved.SegmentID), "ip": saved.IP, "hostname": saved.Hostname},
+		})
+		c.Redirect(302, withBase(back+"&mac_ok=saved"))
+	})
+	r.POST("/macs/delete", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		reservation, ok := macReservationByID(db, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("reservation not found"))
+			return
+		}
+		if err := deleteMACReservation(db, reservation.ID); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "delete",
+			EntityType:  "mac_reservation",
+			EntityID:    sql.NullInt64{Int64: reservation.ID, Valid: true},
+			EntityLabel: sql.NullString{String: reservation.MAC, Valid: true},
+			Before:      map[string]string{"segment_id": itoa64(reservation.SegmentID), "ip": reservation.IP, "hostname": reservation.Hostname},
+		})
+		c.Redirect(302, withBase("/macs?project_id="+itoa64(projectID)+"&mac_ok=deleted"))
+	})
+	r.GET("/export/reservations", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		list, err := listMACReservations(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		format := strings.ToLower(strings.TrimSpace(c.Query("format")))
+		out, ext, err := renderReservations(list, format)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=subnetio_reservations_"+format+"."+ext)
+		c.Data(200, "text/plain; charset=utf-8", []byte(out))
 	})
 
-	// Planning
-	r.GET("/planning", func(c *gin.Context) {
+	// Device inventory
+	r.GET("/devices", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
+		devices, err := listDevices(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
 		sites, _ := listSites(db, activeProjectID)
-		segs, _ := listSegments(db, activeProjectID)
-		pools, _ := listPools(db, activeProjectID)
-		meta, _ := getProjectMeta(db, activeProjectID)
-		growthDefault := 5.0
-		if meta.GrowthRate.Valid {
-			growthDefault = meta.GrowthRate.Float64
+		data["Active"] = "devices"
+		data["Devices"] = devices
+		data["Sites"] = sites
+		data["DeviceOk"] = strings.TrimSpace(c.Query("device_ok"))
+		data["DeviceError"] = strings.TrimSpace(c.Query("device_error"))
+		render(c, "devices", data)
+	})
+	r.POST("/devices", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/devices?project_id=" + itoa64(projectID)
+		device := Device{
+			SiteID: parseProjectID(c.PostForm("site_id")),
+			Name:   c.PostForm("name"),
+			Role:   c.PostForm("role"),
+			Notes:  c.PostForm("notes"),
 		}
-		monthsDefault := 12
-		if meta.GrowthMonths.Valid {
-			monthsDefault = int(meta.GrowthMonths.Int64)
+		id, err := saveDevice(db, projectID, device)
+		if err != nil {
+			c.Redirect(302, withBase(back+"&device_error="+url.QueryEscape(err.Error())))
+			return
 		}
-		growthRate := parseQueryFloat(c.Query("growth_rate"), growthDefault)
-		months := parseQueryInt(c.Query("months"), monthsDefault)
-		v6Unit := parseQueryInt(c.Query("v6_unit"), 64)
-		report := buildCapacityReport(segs, pools, sites, growthRate, months, v6Unit)
-		data["Active"] = "planning"
-		data["Capacity"] = report
-		data["Meta"] = meta
-		render(c, "planning", data)
+		saved, _ := deviceByID(db, id)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "device",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: saved.Name, Valid: true},
+			After:       map[string]string{"site": saved.Site, "role": saved.Role, "loopback": saved.Loopback.String, "loopback_v6": saved.LoopbackV6.String},
+		})
+		c.Redirect(302, withBase(back+"&device_ok=saved"))
+	})
+	r.POST("/devices/delete", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		device, ok := deviceByID(db, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("device not found"))
+			return
+		}
+		if err := deleteDevice(db, device.ID); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "delete",
+			EntityType:  "device",
+			EntityID:    sql.NullInt64{Int64: device.ID, Valid: true},
+			EntityLabel: sql.NullString{String: device.Name, Valid: true},
+			Before:      map[string]string{"site": device.Site, "role": device.Role, "loopback": device.Loopback.String, "loopback_v6": device.LoopbackV6.String},
+		})
+		c.Redirect(302, withBase("/devices?project_id="+itoa64(projectID)+"&device_ok=deleted"))
+	})
+	r.GET("/export/devices", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		devices, err := listDevices(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		format := strings.ToLower(strings.TrimSpace(c.Query("format")))
+		out, ext, err := renderDeviceInventory(devices, format)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=subnetio_devices."+ext)
+		c.Data(200, "text/plain; charset=utf-8", []byte(out))
 	})
 
-	// Generate (templates)
-	r.GET("/generate", func(c *gin.Context) {
+	// NAT planning
+	r.GET("/nat", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
+		mappings, err := listNATMappings(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
 		sites, _ := listSites(db, activeProjectID)
 		segs, _ := listSegments(db, activeProjectID)
 		pools, _ := listPools(db, activeProjectID)
-		rules, _ := getProjectRules(db, activeProjectID)
-		statuses, _ := analyzeAll(segs, pools, sites, rules)
-		views := buildSegmentViews(segs, statuses, pools)
-		opts := parseGenerateOptions(c)
-		project := Project{ID: activeProjectID}
-		if p, ok := projectByID(db, activeProjectID); ok {
+		var natPools []Pool
+		for _, p := range pools {
+			if isNATPool(p) {
+				natPools = append(natPools, p)
+			}
+		}
+		data["Active"] = "nat"
+		data["NATMappings"] = mappings
+		data["NATPools"] = natPools
+		data["NATConflicts"] = analyzeNAT(segs, pools, sites)
+		data["Segments"] = segs
+		data["NATOk"] = strings.TrimSpace(c.Query("nat_ok"))
+		data["NATError"] = strings.TrimSpace(c.Query("nat_error"))
+		render(c, "nat", data)
+	})
+	r.POST("/nat", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/nat?project_id=" + itoa64(projectID)
+		mapping := NATMapping{
+			SegmentID:   parseProjectID(c.PostForm("segment_id")),
+			Type:        c.PostForm("type"),
+			PublicCIDR:  c.PostForm("public"),
+			Protocol:    c.PostForm("protocol"),
+			PublicPort:  atoiDefault(c.PostForm("public_port"), 0),
+			PrivateAddr: c.PostForm("private_addr"),
+			PrivatePort: atoiDefault(c.PostForm("private_port"), 0),
+			Notes:       c.PostForm("notes"),
+		}
+		id, err := saveNATMapping(db, projectID, mapping)
+		if err != nil {
+			c.Redirect(302, withBase(back+"&nat_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		saved, _ := natMappingByID(db, id)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "create",
+			EntityType:  "nat_mapping",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: saved.PublicLabel(), Valid: true},
+			After:       map[string]string{"segment_id": itoa64(saved.SegmentID), "type": saved.Type, "public": saved.PublicLabel(), "private_addr": saved.PrivateAddr},
+		})
+		c.Redirect(302, withBase(back+"&nat_ok=saved"))
+	})
+	r.POST("/nat/delete", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		mapping, ok := natMappingByID(db, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("mapping not found"))
+			return
+		}
+		if err := deleteNATMapping(db, mapping.ID); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "delete",
+			EntityType:  "nat_mapping",
+			EntityID:    sql.NullInt64{Int64: mapping.ID, Valid: true},
+			EntityLabel: sql.NullString{String: mapping.PublicLabel(), Valid: true},
+			Before:      map[string]string{"segment_id": itoa64(mapping.SegmentID), "type": mapping.Type, "public": mapping.PublicLabel(), "private_addr": mapping.PrivateAddr},
+		})
+		c.Redirect(302, withBase("/nat?project_id="+itoa64(projectID)+"&nat_ok=deleted"))
+	})
+
+	// Conflicts & Rules
+	r.GET("/conflicts", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		_, conflicts := analyzeAll(segs, pools, sites, rules)
+		storeConflictCounts(db, activeProjectID, conflicts)
+		tickets, _ := listConflictTickets(db, activeProjectID)
+		openTickets, resolvedTickets := splitConflictTickets(tickets, conflicts)
+		data["Active"] = "conflicts"
+		data["Conflicts"] = sortConflicts(conflicts)
+		data["ConflictOwners"] = conflictOwnerIndex(conflicts, segs, pools, sites)
+		data["Rules"] = rules
+		data["Tickets"] = openTickets
+		data["ResolvedTickets"] = resolvedTickets
+		_, data["TicketingEnabled"] = getTicketIntegration(db, activeProjectID)
+		data["TicketOk"] = strings.TrimSpace(c.Query("ticket_ok"))
+		data["TicketError"] = strings.TrimSpace(c.Query("ticket_error"))
+		data["NotifyOk"] = strings.TrimSpace(c.Query("notify_ok"))
+		data["NotifyError"] = strings.TrimSpace(c.Query("notify_error"))
+		if project, ok := projectByID(db, activeProjectID); ok {
+			data["CrossOverlaps"], _ = projectCrossOverlaps(db, project)
+			data["FederatedOverlaps"], _ = buildFederatedOverlaps(db, project)
+		}
+		render(c, "conflicts", data)
+	})
+	r.GET("/routes", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, projectID)
+		data["Active"] = "conflicts"
+		data["Sites"] = sites
+		data["RoutesSiteID"] = int64(0)
+		render(c, "routes", data)
+	})
+	r.POST("/routes", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, projectID)
+		siteID := parseProjectID(c.PostForm("site_id"))
+		raw := c.PostForm("routing_table")
+		data["Active"] = "conflicts"
+		data["Sites"] = sites
+		data["RoutesSiteID"] = siteID
+		data["RoutingTable"] = raw
+		if fileHeader, err := c.FormFile("routing_file"); err == nil {
+			if fileHeader.Size > maxDeviceConfigSize {
+				data["RoutesError"] = "routing table is too large (max 1MB)"
+				render(c, "routes", data)
+				return
+			}
+			file, err := fileHeader.Open()
+			if err != nil {
+				data["RoutesError"] = "failed to read routing table"
+				render(c, "routes", data)
+				return
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				data["RoutesError"] = "failed to read routing table"
+				render(c, "routes", data)
+				return
+			}
+			raw = string(content)
+		}
+		routes, skipped, err := parseRoutingTable(raw)
+		if err != nil {
+			data["RoutesError"] = err.Error()
+			render(c, "routes", data)
+			return
+		}
+		segs, _ := listSegments(db, projectID)
+		result := reconcileRoutes(routes, segs, siteID)
+		result.Skipped = skipped
+		data["Reconciliation"] = result
+		render(c, "routes", data)
+	})
+	r.POST("/conflicts/ticket", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		conflictID := strings.TrimSpace(c.PostForm("conflict_id"))
+		back := "/conflicts?project_id=" + itoa64(projectID)
+		fail := func(msg string) {
+			c.Redirect(302, withBase(back+"&ticket_error="+url.QueryEscape(msg)))
+		}
+		integration, ok := getTicketIntegration(db, projectID)
+		if !ok {
+			fail("ticketing is not configured for this project")
+			return
+		}
+		if existing, ok := conflictTicketByID(db, projectID, conflictID); ok {
+			fail("conflict " + conflictID + " already has ticket " + existing.TicketKey)
+			return
+		}
+		sites, _ := listSites(db, projectID)
+		segs, _ := listSegments(db, projectID)
+		pools, _ := listPools(db, projectID)
+		rules, _ := getProjectRules(db, projectID)
+		_, conflicts := analyzeAll(segs, pools, sites, rules)
+		var conflict *Conflict
+		for i := range conflicts {
+			if conflicts[i].ID() == conflictID {
+				conflict = &conflicts[i]
+				break
+			}
+		}
+		if conflict == nil {
+			fail("conflict " + conflictID + " is no longer reported")
+			return
+		}
+		project := Project{ID: projectID}
+		if p, ok := projectByID(db, projectID); ok {
+			project = p
+		}
+		key, link, err := createTicket(integration, buildTicketContext(project.Name, *conflict, segs))
+		if err != nil {
+			fail(err.Error())
+			return
+		}
+		if err := saveConflictTicket(db, projectID, *conflict, key, link); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "create",
+			EntityType:  "conflict_ticket",
+			EntityLabel: sql.NullString{String: conflictID + " " + key, Valid: true},
+			After:       map[string]string{"conflict_id": conflictID, "kind": conflict.Kind, "ticket": key, "url": link},
+		})
+		c.Redirect(302, withBase(back+"&ticket_ok="+url.QueryEscape(key)))
+	})
+	r.POST("/conflicts/notify", func(c *gin.Context) {
+		projectID := writeTargetProjectID(c, db, defaultProjectID)
+		back := "/conflicts?project_id=" + itoa64(projectID)
+		project, ok := projectByID(db, projectID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		result, err := sendProjectNotifications(db, project, false, time.Now())
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		if result.Sent > 0 || len(result.Errors) > 0 {
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      "notify",
+				EntityType:  "notification",
+				EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After:       map[string]any{"sent": result.Sent, "deliveries": result.Deliveries, "errors": result.Errors},
+			})
+		}
+		if len(result.Errors) > 0 {
+			c.Redirect(302, withBase(back+"&notify_error="+url.QueryEscape(strings.Join(result.Errors, "; "))))
+			return
+		}
+		c.Redirect(302, withBase(back+"&notify_ok="+url.QueryEscape(notifySummary(result))))
+	})
+
+	// Address map
+	loadAddressMap := func(projectID int64) AddressMap {
+		sites, _ := listSites(db, projectID)
+		segs, _ := listSegments(db, projectID)
+		pools, _ := listPools(db, projectID)
+		rules, _ := getProjectRules(db, projectID)
+		statuses, _ := analyzeAll(segs, pools, sites, rules)
+		views := buildSegmentViews(segs, statuses, pools)
+		project := Project{ID: projectID}
+		if p, ok := projectByID(db, projectID); ok {
+			project = p
+		}
+		return buildAddressMap(project, sites, pools, views)
+	}
+	r.GET("/map", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		data["Active"] = "map"
+		data["AddressMap"] = loadAddressMap(activeProjectID)
+		render(c, "map", data)
+	})
+	r.GET("/map/svg", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		c.Data(200, "image/svg+xml; charset=utf-8", renderAddressMapSVG(loadAddressMap(activeProjectID)))
+	})
+	r.GET("/map/json", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		c.JSON(200, loadAddressMap(activeProjectID))
+	})
+
+	// Planning
+	r.GET("/planning", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		growthRate, months := growthParams(c, meta)
+		v6Unit := parseQueryInt(c.Query("v6_unit"), 64)
+		report := buildCapacityReport(segs, pools, sites, growthRate, months, v6Unit)
+		data["Active"] = "planning"
+		data["Capacity"] = report
+		data["Meta"] = meta
+		render(c, "planning", data)
+	})
+
+	// Generate (templates)
+	renderGenerate := func(c *gin.Context, device *DeviceDiffResult) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		statuses, _ := analyzeAll(segs, pools, sites, rules)
+		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
+		opts := parseGenerateOptions(c)
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
 		}
 		meta, _ := getProjectMeta(db, activeProjectID)
@@ -943,6 +2838,7 @@ func main() {
 		deployedDiff := ""
 		if opts.Template != "" {
 			if result, err := generateConfig(opts, views, sites, project, meta); err == nil {
+				_, _ = recordGeneration(db, c, activeProjectID, generationPreview, opts, result)
 				preview = result.Output
 				templateInfo = TemplateInfo{
 					Name:    result.Metadata.Template,
@@ -975,10 +2871,18 @@ func main() {
 						deployedDiff = unifiedDiff(deployed.Content, preview)
 					}
 				}
+				if device != nil && device.Error == "" {
+					*device = diffDeviceConfig(getNoiseFilter(db, opts.Template), device.Content, preview)
+				}
 			} else {
 				preview = "error: " + err.Error()
 			}
 		}
+		if opts.Template != "" {
+			data["NoiseFilter"] = getNoiseFilter(db, opts.Template)
+		}
+		data["NoiseError"] = strings.TrimSpace(c.Query("noise_error"))
+		data["DeviceDiff"] = device
 		data["Active"] = "generate"
 		data["TemplateInfo"] = templateInfo
 		data["Preview"] = preview
@@ -992,6 +2896,68 @@ func main() {
 		data["Meta"] = meta
 		data["Example"] = templateExample(opts.Template)
 		render(c, "generate", data)
+	}
+	r.GET("/generate", func(c *gin.Context) {
+		renderGenerate(c, nil)
+	})
+	r.POST("/generate/device-diff", func(c *gin.Context) {
+		device := &DeviceDiffResult{Content: c.PostForm("device_config")}
+		if parseGenerateOptions(c).Template == "" {
+			device.Error = "template is required"
+		}
+		if fileHeader, err := c.FormFile("device_file"); err == nil {
+			if fileHeader.Size > maxDeviceConfigSize {
+				device.Error = "device config is too large (max 1MB)"
+			} else if file, err := fileHeader.Open(); err != nil {
+				device.Error = "failed to read device config"
+			} else {
+				content, err := io.ReadAll(file)
+				file.Close()
+				if err != nil {
+					device.Error = "failed to read device config"
+				}
+				device.Content = string(content)
+			}
+		}
+		if device.Error == "" && strings.TrimSpace(device.Content) == "" {
+			device.Error = "device config is empty"
+		}
+		renderGenerate(c, device)
+	})
+	r.POST("/generate/noise-filter", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		target := "/generate?" + strings.TrimPrefix(c.PostForm("query_string"), "?")
+		name, err := normalizeTemplateName(c.PostForm("template"))
+		if err != nil {
+			c.Redirect(302, withBase(target+"&noise_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		before := getNoiseFilter(db, name)
+		action := "update"
+		if c.PostForm("action") == "reset" {
+			action = "reset"
+			err = deleteNoiseFilter(db, name)
+		} else {
+			err = saveNoiseFilter(db, NoiseFilter{
+				Template:         name,
+				StripComments:    c.PostForm("strip_comments") != "",
+				IgnoreWhitespace: c.PostForm("ignore_whitespace") != "",
+				Patterns:         c.PostForm("ignore_patterns"),
+			})
+		}
+		if err != nil {
+			c.Redirect(302, withBase(target+"&noise_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:  activeProjectID,
+			Action:     action,
+			EntityType: "noise_filter",
+			EntityLabel: sql.NullString{String: name, Valid: true},
+			Before:     before,
+			After:      getNoiseFilter(db, name),
+		})
+		c.Redirect(302, withBase(target))
 	})
 	r.POST("/generate/deployed/save", func(c *gin.Context) {
 		projectID := parseProjectID(c.PostForm("project_id"))
@@ -1004,118 +2970,964 @@ func main() {
 		if template != "" {
 			_ = saveDeployedConfig(db, projectID, template, scopeKey, content)
 		}
-		query := strings.TrimPrefix(c.PostForm("query_string"), "?")
-		if query != "" {
-			c.Redirect(302, "/generate?"+query)
+		query := strings.TrimPrefix(c.PostForm("query_string"), "?")
+		if query != "" {
+			c.Redirect(302, withBase("/generate?"+query))
+			return
+		}
+		c.Redirect(302, withBase("/generate?project_id="+itoa64(projectID)))
+	})
+	r.POST("/generate/deployed/delete", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		template := strings.TrimSpace(c.PostForm("template"))
+		scopeKey := strings.TrimSpace(c.PostForm("scope_key"))
+		if scopeKey == "" {
+			scopeKey = "project"
+		}
+		if template != "" {
+			_ = deleteDeployedConfig(db, projectID, template, scopeKey)
+		}
+		query := strings.TrimPrefix(c.PostForm("query_string"), "?")
+		if query != "" {
+			c.Redirect(302, withBase("/generate?"+query))
+			return
+		}
+		c.Redirect(302, withBase("/generate?project_id="+itoa64(projectID)))
+	})
+	r.GET("/generate/download", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		statuses, _ := analyzeAll(segs, pools, sites, rules)
+		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
+		opts := parseGenerateOptions(c)
+		if opts.Template == "" {
+			respondError(c, 400, fmt.Errorf("template is required"))
+			return
+		}
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
+		meta, _ := getProjectMeta(db, activeProjectID)
+		result, err := generateConfig(opts, views, sites, project, meta)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		recordGenerationAudit(db, c, activeProjectID, generationDownload, opts, result)
+		ext := templateExtension(opts.Template)
+		filename := "subnetio_" + opts.Template + "." + ext
+		contentType := "text/plain; charset=utf-8"
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.String(200, result.Output)
+	})
+	r.GET("/generate/bundle", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		statuses, _ := analyzeAll(segs, pools, sites, rules)
+		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
+		opts := parseGenerateOptions(c)
+		if opts.Template == "" {
+			respondError(c, 400, fmt.Errorf("template is required"))
+			return
+		}
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
+		meta, _ := getProjectMeta(db, activeProjectID)
+		result, err := generateConfig(opts, views, sites, project, meta)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		result.Metadata.Checksum = checksumSHA256(result.Output)
+		metaBytes, err := encodeMetadataJSON(result.Metadata)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		bundle, err := buildConfigBundle(opts.Template, result.Output, metaBytes)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		recordGenerationAudit(db, c, activeProjectID, generationBundle, opts, result)
+		filename := "subnetio_bundle_" + opts.Template + ".zip"
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(200, "application/zip", bundle)
+	})
+	r.GET("/generate/bundle/all", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		views, sites, project, meta := loadGenerateInputs(db, activeProjectID)
+		opts := parseGenerateOptions(c)
+		outputs, batch := generateBatch(opts, views, sites, project, meta)
+		if len(outputs) == 0 {
+			respondError(c, 500, fmt.Errorf("no template could be rendered"))
+			return
+		}
+		bundle, err := buildBatchBundle(outputs, batch)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		for _, out := range outputs {
+			opts.Template = out.Template
+			recordGenerationAudit(db, c, activeProjectID, generationBundle, opts, out.Result)
+		}
+		filename := "subnetio_bundle_all_" + safeName(batch.ProjectName) + ".zip"
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", "attachment; filename="+filename)
+		c.Data(200, "application/zip", bundle)
+	})
+	r.GET("/generate/history", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		generations, err := listGenerations(db, activeProjectID, atoiDefault(c.Query("limit"), 200))
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		schedules, err := listGenerationSchedules(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "generate"
+		data["Generations"] = generations
+		data["Schedules"] = schedules
+		data["TemplateCatalog"] = listTemplateCatalog()
+		data["ScheduleOk"] = strings.TrimSpace(c.Query("schedule_ok"))
+		data["ScheduleError"] = strings.TrimSpace(c.Query("schedule_error"))
+		render(c, "generations", data)
+	})
+	r.POST("/generate/schedules", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		back := "/generate/history?project_id=" + itoa64(projectID)
+		schedule := GenerationSchedule{
+			ProjectID:     projectID,
+			Template:      strings.TrimSpace(c.PostForm("template")),
+			SiteFilter:    c.PostForm("filter_site"),
+			VRFFilter:     c.PostForm("filter_vrf"),
+			SegmentFilter: c.PostForm("filter_segment"),
+			Cron:          c.PostForm("cron"),
+			Retention:     atoiDefault(c.PostForm("retention"), defaultScheduleRetention),
+			Enabled:       c.PostForm("enabled") != "",
+		}
+		id, err := saveGenerationSchedule(db, schedule)
+		if err != nil {
+			c.Redirect(302, withBase(back+"&schedule_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "generation_schedule",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: schedule.Template + " " + schedule.ScopeKey(), Valid: true},
+			After: map[string]any{
+				"cron":      strings.Join(strings.Fields(schedule.Cron), " "),
+				"retention": schedule.Retention,
+				"enabled":   schedule.Enabled,
+			},
+		})
+		c.Redirect(302, withBase(back+"&schedule_ok=saved"))
+	})
+	r.POST("/generate/schedules/run", func(c *gin.Context) {
+		schedule, ok := generationScheduleByID(db, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("schedule not found"))
+			return
+		}
+		back := "/generate/history?project_id=" + itoa64(schedule.ProjectID)
+		if _, err := runGenerationSchedule(db, schedule, time.Now()); err != nil {
+			c.Redirect(302, withBase(back+"&schedule_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		c.Redirect(302, withBase(back+"&schedule_ok=ran"))
+	})
+	r.POST("/generate/schedules/delete", func(c *gin.Context) {
+		schedule, ok := generationScheduleByID(db, parseProjectID(c.PostForm("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("schedule not found"))
+			return
+		}
+		if err := deleteGenerationSchedule(db, schedule.ID); err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   schedule.ProjectID,
+			Action:      "delete",
+			EntityType:  "generation_schedule",
+			EntityID:    sql.NullInt64{Int64: schedule.ID, Valid: true},
+			EntityLabel: sql.NullString{String: schedule.Template + " " + schedule.ScopeKey(), Valid: true},
+			Before:      map[string]any{"cron": schedule.Cron, "retention": schedule.Retention},
+		})
+		c.Redirect(302, withBase("/generate/history?project_id="+itoa64(schedule.ProjectID)+"&schedule_ok=deleted"))
+	})
+	r.GET("/generate/storage", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		report, err := configStorageReport(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "generate"
+		data["Storage"] = report
+		data["StorageOk"] = strings.TrimSpace(c.Query("storage_ok"))
+		data["StorageError"] = strings.TrimSpace(c.Query("storage_error"))
+		render(c, "config_storage", data)
+	})
+	r.POST("/generate/storage/retention", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		back := "/generate/storage?project_id=" + itoa64(projectID)
+		before, err := configRetention(db, projectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		retention := ConfigRetention{
+			ProjectID:    projectID,
+			KeepPerScope: atoiDefault(c.PostForm("keep_per_scope"), 0),
+			MaxAgeDays:   atoiDefault(c.PostForm("max_age_days"), 0),
+		}
+		if err := saveConfigRetention(db, retention); err != nil {
+			c.Redirect(302, withBase(back+"&storage_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "config_retention",
+			EntityID:    sql.NullInt64{Int64: projectID, Valid: true},
+			EntityLabel: sql.NullString{String: "config retention", Valid: true},
+			Before:      map[string]any{"keep_per_scope": before.KeepPerScope, "max_age_days": before.MaxAgeDays},
+			After:       map[string]any{"keep_per_scope": retention.KeepPerScope, "max_age_days": retention.MaxAgeDays},
+		})
+		c.Redirect(302, withBase(back+"&storage_ok=saved"))
+	})
+	r.POST("/generate/storage/prune", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		back := "/generate/storage?project_id=" + itoa64(projectID)
+		retention, err := configRetention(db, projectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		removed, err := applyConfigRetention(db, retention, time.Now())
+		if err != nil {
+			c.Redirect(302, withBase(back+"&storage_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		collected, err := gcConfigBlobs(db)
+		if err != nil {
+			c.Redirect(302, withBase(back+"&storage_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		c.Redirect(302, withBase(back+"&storage_ok="+url.QueryEscape("pruned: "+itoa64(removed)+" history entries and "+itoa64(collected)+" unused blobs removed")))
+	})
+	r.GET("/generate/vars", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		scopes, err := templateVarScopes(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Active"] = "generate"
+		data["VarScopes"] = scopes
+		data["VarsOk"] = strings.TrimSpace(c.Query("vars_ok"))
+		data["VarsError"] = strings.TrimSpace(c.Query("vars_error"))
+		render(c, "template_vars", data)
+	})
+	r.POST("/generate/vars", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		siteID := parseProjectID(c.PostForm("site_id"))
+		back := "/generate/vars?project_id=" + itoa64(projectID)
+		vars, err := parseTemplateVars(c.PostForm("vars"))
+		if err != nil {
+			c.Redirect(302, withBase(back+"&vars_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		before, err := projectTemplateVars(db, projectID)
+		label := "project variables"
+		if siteID > 0 {
+			sites := []Site{{ID: siteID}}
+			err = attachSiteTemplateVars(db, sites)
+			before = sites[0].Vars
+			label = "site variables"
+		}
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		if err := saveTemplateVars(db, projectID, siteID, vars); err != nil {
+			c.Redirect(302, withBase(back+"&vars_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		entityID := projectID
+		if siteID > 0 {
+			entityID = siteID
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      "save",
+			EntityType:  "template_vars",
+			EntityID:    sql.NullInt64{Int64: entityID, Valid: true},
+			EntityLabel: sql.NullString{String: label, Valid: true},
+			Before:      before,
+			After:       vars,
+		})
+		c.Redirect(302, withBase(back+"&vars_ok=saved"))
+	})
+
+	r.POST("/api/v1/generate", func(c *gin.Context) {
+		var req generateAPIRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, 400, fmt.Errorf("invalid JSON: %v", err))
+			return
+		}
+		projectID := req.ProjectID
+		if projectID == 0 {
+			projectID = resolveActiveProjectID(c, db, defaultProjectID)
+		}
+		if _, ok := projectByID(db, projectID); !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		out, err := generateAPI(db, c, projectID, req)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.JSON(200, out)
+	})
+	r.POST("/api/v1/whatif", func(c *gin.Context) {
+		var req whatIfAPIRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, 400, fmt.Errorf("invalid JSON: %v", err))
+			return
+		}
+		projectID := req.ProjectID
+		if projectID == 0 {
+			projectID = resolveActiveProjectID(c, db, defaultProjectID)
+		}
+		if _, ok := projectByID(db, projectID); !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		out, err := whatIfAPI(db, projectID, req)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.JSON(200, out)
+	})
+	r.GET("/api/v1/projects/:id/compare", func(c *gin.Context) {
+		left, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		right, ok := projectByID(db, parseProjectID(c.Query("other_id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("other project not found"))
+			return
+		}
+		comparison, err := buildProjectComparison(db, left, right, c.Query("site"), c.Query("other_site"))
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.JSON(200, comparison)
+	})
+	r.GET("/api/v1/sizing", func(c *gin.Context) {
+		if strings.TrimSpace(c.Query("preset")) == "" {
+			c.JSON(200, gin.H{"presets": sizingPresets})
+			return
+		}
+		res, err := computeSizing(SizingRequest{
+			Preset:      c.Query("preset"),
+			Hosts:       atoiDefault(c.Query("hosts"), 0),
+			Nodes:       atoiDefault(c.Query("nodes"), 0),
+			PodsPerNode: atoiDefault(c.Query("pods_per_node"), 0),
+		})
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.JSON(200, res)
+	})
+	r.POST("/api/v1/chatops", func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		if err := verifyChatOps(c.GetHeader, body, time.Now()); err != nil {
+			respondError(c, 403, err)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		c.JSON(200, runChatOpsCommand(db, form.Get("text")))
+	})
+	r.GET("/api/v1/overlaps", func(c *gin.Context) {
+		reports, err := buildCrossProjectOverlaps(db)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.JSON(200, gin.H{"routing_domains": reports})
+	})
+	r.POST("/api/v1/routes/reconcile", func(c *gin.Context) {
+		projectID := resolveActiveProjectID(c, db, defaultProjectID)
+		if _, ok := projectByID(db, projectID); !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxDeviceConfigSize+1))
+		if err != nil {
+			respondError(c, 400, fmt.Errorf("failed to read routing table"))
+			return
+		}
+		if len(body) > maxDeviceConfigSize {
+			respondError(c, 413, fmt.Errorf("routing table is too large (max 1MB)"))
+			return
+		}
+		routes, skipped, err := parseRoutingTable(string(body))
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		segs, err := listSegments(db, projectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		result := reconcileRoutes(routes, segs, parseProjectID(c.Query("site_id")))
+		result.Skipped = skipped
+		c.JSON(200, result)
+	})
+	r.GET("/api/v1/projects/:id/summary", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		summary, err := buildProjectSummary(db, project)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.JSON(200, summary)
+	})
+
+	r.GET("/api/v1/projects/:id/health", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		now := time.Now()
+		health, err := buildProjectHealth(db, project, now)
+		if err == nil {
+			err = recordProjectHealth(db, health)
+		}
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		trend, err := projectHealthTrend(db, project.ID, atoiDefault(c.Query("days"), healthTrendDays), now)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.JSON(200, gin.H{"health": health, "trend": trend})
+	})
+
+	r.GET("/api/v1/health", func(c *gin.Context) {
+		projects, err := buildAllProjectHealth(db, time.Now())
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.JSON(200, gin.H{"generated_at": time.Now().UTC().Format(time.RFC3339), "projects": projects})
+	})
+
+	r.GET("/api/v1/projects/:id/events", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		streamProjectEvents(c, db, project.ID)
+	})
+
+	r.GET("/api/v1/projects/:id/dhcp-settings", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		siteID := parseProjectID(c.Query("site_id"))
+		if name := strings.TrimSpace(c.Query("site")); name != "" && siteID == 0 {
+			sites, _ := listSites(db, project.ID)
+			for _, s := range sites {
+				if strings.EqualFold(s.Name, name) {
+					siteID = s.ID
+				}
+			}
+			if siteID == 0 {
+				respondError(c, 404, fmt.Errorf("site not found"))
+				return
+			}
+		}
+		segmentID := parseProjectID(c.Query("segment_id"))
+		report, err := buildDHCPSettingsReport(db, project.ID, siteID, segmentID, c.Query("segments") == "1")
+		if err != nil {
+			respondError(c, 404, err)
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	r.GET("/api/v1/projects/:id/tiers", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		tiers, err := listPoolTiers(db, project.ID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		pools, err := listPools(db, project.ID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		segs, err := listSegments(db, project.ID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		c.JSON(200, gin.H{"project_id": project.ID, "tiers": buildTierGroups(tiers, pools, segs)})
+	})
+
+	// Artifacts API: latest scheduled renders for downstream systems.
+	r.GET("/api/artifacts", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		schedules, err := listGenerationSchedules(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		out := make([]gin.H, 0, len(schedules))
+		for _, s := range schedules {
+			out = append(out, scheduleJSON(s))
+		}
+		c.JSON(200, gin.H{"project_id": activeProjectID, "schedules": out})
+	})
+	r.GET("/api/artifacts/:id", func(c *gin.Context) {
+		schedule, ok := generationScheduleByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("schedule not found"))
+			return
+		}
+		artifacts, err := listScheduleArtifacts(db, schedule.ID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		items := make([]gin.H, 0, len(artifacts))
+		for _, a := range artifacts {
+			items = append(items, gin.H{
+				"id":               a.ID,
+				"checksum":         a.Checksum,
+				"template_version": a.TemplateVersion,
+				"created_at":       a.CreatedAt,
+			})
+		}
+		out := scheduleJSON(schedule)
+		out["artifacts"] = items
+		c.JSON(200, out)
+	})
+	r.GET("/api/artifacts/:id/latest", func(c *gin.Context) {
+		schedule, ok := generationScheduleByID(db, parseProjectID(c.Param("id")))
+		if !ok || schedule.LatestID == 0 {
+			respondError(c, 404, fmt.Errorf("no artifact rendered yet"))
+			return
+		}
+		g, ok := generationByID(db, schedule.LatestID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("artifact not found"))
+			return
+		}
+		etag := `"` + g.Checksum + `"`
+		c.Header("ETag", etag)
+		c.Header("X-Subnetio-Checksum", "sha256:"+g.Checksum)
+		c.Header("X-Subnetio-Rendered-At", g.CreatedAt)
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(304)
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=subnetio_"+g.Template+"_"+itoa64(g.ID)+"."+templateExtension(g.Template))
+		c.Data(200, "text/plain; charset=utf-8", []byte(g.Content))
+	})
+	r.GET("/generate/history/download", func(c *gin.Context) {
+		id := parseProjectID(c.Query("id"))
+		g, ok := generationByID(db, id)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("generation not found"))
+			return
+		}
+		if g.Kind == generationBundle {
+			bundle, err := buildConfigBundle(g.Template, g.Content, []byte(g.Metadata))
+			if err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename=subnetio_bundle_"+g.Template+"_"+itoa64(g.ID)+".zip")
+			c.Data(200, "application/zip", bundle)
+			return
+		}
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Disposition", "attachment; filename=subnetio_"+g.Template+"_"+itoa64(g.ID)+"."+templateExtension(g.Template))
+		c.String(200, g.Content)
+	})
+
+	// Integrity
+	r.GET("/health", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		data["Active"] = "dashboard"
+		project, ok := projectByID(db, projectID)
+		if !ok {
+			data["HealthError"] = "project not found"
+			render(c, "health", data)
+			return
+		}
+		now := time.Now()
+		health, err := buildProjectHealth(db, project, now)
+		if err == nil {
+			err = recordProjectHealth(db, health)
+		}
+		if err != nil {
+			data["HealthError"] = err.Error()
+		}
+		trend, _ := projectHealthTrend(db, projectID, healthTrendDays, now)
+		data["Health"] = health
+		data["HealthTrend"] = trend
+		render(c, "health", data)
+	})
+	r.GET("/compare", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		data["Active"] = "projects"
+		otherID := parseProjectID(c.Query("other_id"))
+		data["CompareLeftID"] = projectID
+		data["CompareRightID"] = otherID
+		data["CompareSite"] = strings.TrimSpace(c.Query("site"))
+		data["CompareOtherSite"] = strings.TrimSpace(c.Query("other_site"))
+		if otherID == 0 {
+			render(c, "compare", data)
+			return
+		}
+		left, lok := projectByID(db, projectID)
+		right, rok := projectByID(db, otherID)
+		if !lok || !rok {
+			data["CompareError"] = "project not found"
+			render(c, "compare", data)
+			return
+		}
+		comparison, err := buildProjectComparison(db, left, right, c.Query("site"), c.Query("other_site"))
+		if err != nil {
+			data["CompareError"] = err.Error()
+		} else {
+			data["Comparison"] = comparison
+		}
+		render(c, "compare", data)
+	})
+	r.GET("/history", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		data["Active"] = "segments"
+		data["AsOfInput"] = strings.TrimSpace(c.Query("as_of"))
+		at, err := parseAsOf(c.Query("as_of"))
+		if err != nil {
+			data["HistoryError"] = err.Error()
+			renderStatus(c, http.StatusBadRequest, "history", data)
+			return
+		}
+		if at.IsZero() {
+			render(c, "history", data)
+			return
+		}
+		history, err := planAsOf(db, projectID, at)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		sites, _ := listSites(db, projectID)
+		pools, _ := listPools(db, projectID)
+		rules, _ := getProjectRules(db, projectID)
+		statuses, _ := analyzeAll(history.Segments, pools, sites, rules)
+		changed := map[int64]bool{}
+		for _, seg := range history.Changed {
+			changed[seg.ID] = true
+		}
+		removed := map[int64]bool{}
+		for _, seg := range history.Removed {
+			removed[seg.ID] = true
+		}
+		data["History"] = history
+		data["HistoryAsOf"] = at.Format(time.RFC3339)
+		data["AsOfInput"] = at.Format("2006-01-02T15:04:05")
+		data["HistoryViews"] = buildSegmentViews(history.Segments, statuses, pools)
+		data["HistoryChanged"] = changed
+		data["HistoryRemoved"] = removed
+		render(c, "history", data)
+	})
+	r.GET("/dhcp/settings", func(c *gin.Context) {
+		data, projectID := baseData(c, db, defaultProjectID)
+		siteID := parseProjectID(c.Query("site_id"))
+		segmentID := parseProjectID(c.Query("segment_id"))
+		sites, _ := listSites(db, projectID)
+		report, err := buildDHCPSettingsReport(db, projectID, siteID, segmentID, siteID > 0)
+		data["Active"] = "sites"
+		data["Sites"] = sites
+		data["DHCPSettings"] = report
+		data["DHCPSettingsSiteID"] = siteID
+		data["DHCPSettingsSegmentID"] = segmentID
+		if err != nil {
+			data["DHCPSettingsError"] = err.Error()
+		}
+		render(c, "dhcp_settings", data)
+	})
+	r.GET("/integrity", func(c *gin.Context) {
+		data, _ := baseData(c, db, defaultProjectID)
+		groups, err := checkIntegrity(db)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		total := 0
+		for _, g := range groups {
+			total += len(g.Issues)
+		}
+		data["Active"] = "projects"
+		data["IntegrityGroups"] = groups
+		data["IntegrityTotal"] = total
+		data["IntegrityError"] = strings.TrimSpace(c.Query("integrity_error"))
+		data["DBMaintenanceRuns"], _ = listDBMaintenanceRuns(db)
+		data["DBMaintenanceInterval"] = dbMaintenanceInterval()
+		data["DBMaintenanceOk"] = strings.TrimSpace(c.Query("db_ok"))
+		data["DBMaintenanceError"] = strings.TrimSpace(c.Query("db_error"))
+		render(c, "integrity", data)
+	})
+	r.GET("/overlaps", func(c *gin.Context) {
+		data, _ := baseData(c, db, defaultProjectID)
+		reports, err := buildCrossProjectOverlaps(db)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		total := 0
+		for _, r := range reports {
+			total += len(r.Overlaps)
+		}
+		data["Active"] = "projects"
+		data["RoutingDomains"] = reports
+		data["OverlapTotal"] = total
+		render(c, "overlaps", data)
+	})
+	r.GET("/federation", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		project, ok := projectByID(db, activeProjectID)
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
+		}
+		sources, err := listFederationSources(db, project.ID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		overlaps, err := buildFederatedOverlaps(db, project)
+		if err != nil {
+			respondError(c, 500, err)
 			return
 		}
-		c.Redirect(302, "/generate?project_id="+itoa64(projectID))
+		data["Active"] = "projects"
+		data["FederationSources"] = sources
+		data["FederatedOverlaps"] = overlaps
+		data["FederationError"] = strings.TrimSpace(c.Query("federation_error"))
+		render(c, "federation", data)
 	})
-	r.POST("/generate/deployed/delete", func(c *gin.Context) {
+	r.POST("/federation", func(c *gin.Context) {
 		projectID := parseProjectID(c.PostForm("project_id"))
-		template := strings.TrimSpace(c.PostForm("template"))
-		scopeKey := strings.TrimSpace(c.PostForm("scope_key"))
-		if scopeKey == "" {
-			scopeKey = "project"
+		if _, ok := projectByID(db, projectID); !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
+			return
 		}
-		if template != "" {
-			_ = deleteDeployedConfig(db, projectID, template, scopeKey)
+		src := FederationSource{
+			ID:              parseProjectID(c.PostForm("source_id")),
+			ProjectID:       projectID,
+			Name:            c.PostForm("name"),
+			URL:             c.PostForm("url"),
+			RemoteProjectID: parseProjectID(c.PostForm("remote_project_id")),
+			TokenRef:        c.PostForm("token_ref"),
 		}
-		query := strings.TrimPrefix(c.PostForm("query_string"), "?")
-		if query != "" {
-			c.Redirect(302, "/generate?"+query)
+		before, existed := federationSourceByID(db, projectID, src.ID)
+		if src.ID > 0 && !existed {
+			respondError(c, 404, fmt.Errorf("source not found"))
 			return
 		}
-		c.Redirect(302, "/generate?project_id="+itoa64(projectID))
-	})
-	r.GET("/generate/download", func(c *gin.Context) {
-		_, activeProjectID := baseData(c, db, defaultProjectID)
-		sites, _ := listSites(db, activeProjectID)
-		segs, _ := listSegments(db, activeProjectID)
-		pools, _ := listPools(db, activeProjectID)
-		rules, _ := getProjectRules(db, activeProjectID)
-		statuses, _ := analyzeAll(segs, pools, sites, rules)
-		views := buildSegmentViews(segs, statuses, pools)
-		opts := parseGenerateOptions(c)
-		if opts.Template == "" {
-			c.String(400, "template is required")
+		id, err := saveFederationSource(db, src)
+		if err != nil {
+			c.Redirect(302, withBase("/federation?project_id="+itoa64(projectID)+"&federation_error="+url.QueryEscape(err.Error())))
 			return
 		}
-		project := Project{ID: activeProjectID}
-		if p, ok := projectByID(db, activeProjectID); ok {
-			project = p
+		after, _ := federationSourceByID(db, projectID, id)
+		action := "create"
+		var beforeSnap any
+		if existed {
+			action = "update"
+			beforeSnap = before
 		}
-		meta, _ := getProjectMeta(db, activeProjectID)
-		result, err := generateConfig(opts, views, sites, project, meta)
+		writeAudit(db, c, auditRecord{
+			ProjectID:   projectID,
+			Action:      action,
+			EntityType:  "federation_source",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: after.Name, Valid: true},
+			Before:      beforeSnap,
+			After:       after,
+		})
+		c.Redirect(302, withBase("/federation?project_id="+itoa64(projectID)))
+	})
+	r.POST("/federation/delete", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		if before, ok := federationSourceByID(db, projectID, parseProjectID(c.PostForm("source_id"))); ok {
+			if err := deleteFederationSource(db, before.ID); err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:   projectID,
+				Action:      "delete",
+				EntityType:  "federation_source",
+				EntityID:    sql.NullInt64{Int64: before.ID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:      before,
+			})
+		}
+		c.Redirect(302, withBase("/federation?project_id="+itoa64(projectID)))
+	})
+	r.POST("/federation/pull", func(c *gin.Context) {
+		projectID := parseProjectID(c.PostForm("project_id"))
+		sources, err := listFederationSources(db, projectID)
 		if err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 			return
 		}
-		ext := templateExtension(opts.Template)
-		filename := "subnetio_" + opts.Template + "." + ext
-		contentType := "text/plain; charset=utf-8"
-		c.Header("Content-Type", contentType)
-		c.Header("Content-Disposition", "attachment; filename="+filename)
-		c.String(200, result.Output)
+		sourceID := parseProjectID(c.PostForm("source_id"))
+		client := &http.Client{Timeout: 30 * time.Second}
+		var failed []string
+		for _, src := range sources {
+			if sourceID > 0 && src.ID != sourceID {
+				continue
+			}
+			if err := pullFederationSource(db, client, src); err != nil {
+				failed = append(failed, src.Name+": "+err.Error())
+			}
+		}
+		target := "/federation?project_id=" + itoa64(projectID)
+		if len(failed) > 0 {
+			target += "&federation_error=" + url.QueryEscape("pull failed: "+strings.Join(failed, "; "))
+		}
+		c.Redirect(302, withBase(target))
 	})
-	r.GET("/generate/bundle", func(c *gin.Context) {
-		_, activeProjectID := baseData(c, db, defaultProjectID)
-		sites, _ := listSites(db, activeProjectID)
-		segs, _ := listSegments(db, activeProjectID)
-		pools, _ := listPools(db, activeProjectID)
-		rules, _ := getProjectRules(db, activeProjectID)
-		statuses, _ := analyzeAll(segs, pools, sites, rules)
-		views := buildSegmentViews(segs, statuses, pools)
-		opts := parseGenerateOptions(c)
-		if opts.Template == "" {
-			c.String(400, "template is required")
+	r.GET("/api/v1/projects/:id/federation", func(c *gin.Context) {
+		project, ok := projectByID(db, parseProjectID(c.Param("id")))
+		if !ok {
+			respondError(c, 404, fmt.Errorf("project not found"))
 			return
 		}
-		project := Project{ID: activeProjectID}
-		if p, ok := projectByID(db, activeProjectID); ok {
-			project = p
-		}
-		meta, _ := getProjectMeta(db, activeProjectID)
-		result, err := generateConfig(opts, views, sites, project, meta)
+		sources, err := listFederationSources(db, project.ID)
 		if err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 			return
 		}
-		result.Metadata.Checksum = checksumSHA256(result.Output)
-		metaBytes, err := encodeMetadataJSON(result.Metadata)
+		overlaps, err := buildFederatedOverlaps(db, project)
 		if err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 			return
 		}
-		var buf bytes.Buffer
-		zw := zip.NewWriter(&buf)
-		configName := "subnetio_" + opts.Template + "." + templateExtension(opts.Template)
-		configFile, err := zw.Create(configName)
+		if sources == nil {
+			sources = []FederationSource{}
+		}
+		c.JSON(200, gin.H{"sources": sources, "overlaps": overlaps})
+	})
+	r.GET("/integrity/json", func(c *gin.Context) {
+		groups, err := checkIntegrity(db)
 		if err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 			return
 		}
-		if _, err := configFile.Write([]byte(result.Output)); err != nil {
-			c.String(500, err.Error())
+		runs, err := listDBMaintenanceRuns(db)
+		if err != nil {
+			respondError(c, 500, err)
 			return
 		}
-		metaFile, err := zw.Create("metadata.json")
+		c.JSON(200, gin.H{"groups": groups, "database_maintenance": runs})
+	})
+	r.POST("/integrity/database", func(c *gin.Context) {
+		target := "/integrity?project_id=" + itoa64(parseProjectID(c.PostForm("project_id")))
+		run, err := runDBMaintenance(db, "manual", time.Now())
 		if err != nil {
-			c.String(500, err.Error())
+			c.Redirect(302, withBase(target+"&db_error="+url.QueryEscape(err.Error())))
 			return
 		}
-		if _, err := metaFile.Write(metaBytes); err != nil {
-			c.String(500, err.Error())
+		writeAudit(db, c, auditDBMaintenance(run))
+		if !run.OK() {
+			c.Redirect(302, withBase(target+"&db_error="+url.QueryEscape(run.Summary())))
 			return
 		}
-		if err := zw.Close(); err != nil {
-			c.String(500, err.Error())
+		c.Redirect(302, withBase(target+"&db_ok="+url.QueryEscape(run.Summary())))
+	})
+	r.POST("/integrity/cleanup", func(c *gin.Context) {
+		kind := strings.TrimSpace(c.PostForm("kind"))
+		id := parseProjectID(c.PostForm("entity_id"))
+		target := "/integrity?project_id=" + itoa64(parseProjectID(c.PostForm("project_id")))
+		removed, err := cleanupIntegrity(db, kind, id)
+		if err != nil {
+			c.Redirect(302, withBase(target+"&integrity_error="+url.QueryEscape(err.Error())))
 			return
 		}
-		filename := "subnetio_bundle_" + opts.Template + ".zip"
-		c.Header("Content-Type", "application/zip")
-		c.Header("Content-Disposition", "attachment; filename="+filename)
-		c.Data(200, "application/zip", buf.Bytes())
+		for _, issue := range removed {
+			writeAudit(db, c, auditRecord{
+				Action:      "cleanup",
+				EntityType:  issue.EntityType,
+				EntityID:    sql.NullInt64{Int64: issue.EntityID, Valid: true},
+				EntityLabel: sql.NullString{String: issue.Label, Valid: issue.Label != ""},
+				Before:      issue,
+			})
+		}
+		c.Redirect(302, withBase(target))
 	})
 
 	// Templates
@@ -1131,6 +3943,7 @@ func main() {
 
 		templateCatalog := listTemplateCatalog()
 		data["TemplateCatalog"] = templateCatalog
+		data["TemplateCache"] = templateSources.Stats()
 		data["TemplateSelectedInfo"] = TemplateInfo{}
 		data["TemplateHelpers"] = []string{
 			"itoa - int to string",
@@ -1143,6 +3956,13 @@ func main() {
 			"ciscoDomainSearch - option 119 format",
 			"firstVLAN - first VLAN in group",
 			"mikrotikDhcpLine - DHCP line",
+			"cidrHost - nth usable address (negative from end)",
+			"cidrNetmask - IPv4 dotted netmask",
+			"cidrSubnets - split prefix by extra bits",
+			"wildcardMask - IPv4 ACL wildcard",
+			"ip6Compress - canonical IPv6 form",
+			"inCIDR - address within prefix",
+			"offsetAddr - address plus offset",
 		}
 
 		sites, _ := listSites(db, activeProjectID)
@@ -1151,6 +3971,8 @@ func main() {
 		rules, _ := getProjectRules(db, activeProjectID)
 		statuses, _ := analyzeAll(segs, pools, sites, rules)
 		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
 		data["TemplateSegments"] = views
 
 		selectedSegmentID := parseProjectID(c.Query("segment_id"))
@@ -1212,7 +4034,13 @@ func main() {
 		siteDefaults := buildSiteDefaults(sites, meta)
 		dhcpBySite := buildDHCPBySite(sites, defaults, domain)
 		renderSegments := buildRenderSegments(opts, views, sites, domain, dhcpBySite, siteDefaults)
+		renderLinks := buildRenderLinks(opts, views)
+		renderDevices := buildRenderDevices(opts, sites)
+		renderNAT := buildRenderNAT(opts, views, sites)
 		metadata := buildMetadata(opts, project, domain, renderSegments, defaults, version, source)
+		metadata.LinkCount = len(renderLinks)
+		metadata.DeviceCount = len(renderDevices)
+		metadata.NATCount = len(renderNAT)
 		prefix := "#"
 		if selectedTemplate != "" {
 			prefix = templateCommentPrefix(selectedTemplate)
@@ -1225,8 +4053,11 @@ func main() {
 			Groups:   groupSegments(renderSegments),
 			Segments: renderSegments,
 			Defaults: defaults,
+			Links:    renderLinks,
+			Devices:  renderDevices,
+			NAT:      renderNAT,
 		}
-		if len(renderSegments) > 0 {
+		if len(renderSegments) > 0 || len(renderLinks) > 0 || len(renderDevices) > 0 {
 			if raw, err := json.MarshalIndent(ctx, "", "  "); err == nil {
 				data["TemplatePreview"] = string(raw)
 			} else {
@@ -1241,6 +4072,19 @@ func main() {
 				data["TemplateOutput"] = result.Output
 			}
 		}
+		data["FixtureError"] = strings.TrimSpace(c.Query("fixture_error"))
+		data["FixtureOk"] = strings.TrimSpace(c.Query("fixture_ok"))
+		if selectedTemplate != "" {
+			data["TemplateFixtures"], _ = listTemplateFixtures(db, selectedTemplate)
+			if c.Query("run_fixtures") != "" {
+				results, err := runStoredTemplateFixtures(db, selectedTemplate)
+				if err != nil {
+					data["FixtureError"] = err.Error()
+				}
+				data["FixtureResults"] = results
+				data["FixtureFailures"] = fixtureFailures(results)
+			}
+		}
 		render(c, "templates", data)
 	})
 	r.POST("/templates/upload", func(c *gin.Context) {
@@ -1251,156 +4095,464 @@ func main() {
 		if rawName == "" && fileHeader != nil {
 			rawName = strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename))
 		}
-		name, err := normalizeTemplateName(rawName)
+		name, err := normalizeTemplateName(rawName)
+		if err != nil {
+			redirectTemplateMessage(c, activeProjectID, rawName, "upload_error", "invalid template name")
+			return
+		}
+
+		var content []byte
+		if fileHeader != nil {
+			file, err := fileHeader.Open()
+			if err != nil {
+				redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to read template file")
+				return
+			}
+			defer file.Close()
+			data, err := io.ReadAll(file)
+			if err != nil {
+				redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to read template file")
+				return
+			}
+			content = data
+		} else if contentField != "" {
+			content = []byte(contentField)
+		}
+		if len(content) == 0 {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template content is empty")
+			return
+		}
+		if len(content) > 1<<20 {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template is too large (max 1MB)")
+			return
+		}
+		if _, err := template.New(name).Funcs(templateFuncs()).Parse(string(content)); err != nil {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template parse error: "+err.Error())
+			return
+		}
+		if c.PostForm("skip_fixtures") == "" {
+			fixtures, _ := listTemplateFixtures(db, name)
+			if failed := fixtureFailures(runTemplateFixtures(name, string(content), fixtures)); failed > 0 {
+				redirectTemplateMessage(c, activeProjectID, name, "upload_error", fmt.Sprintf("%d of %d fixtures fail with this version; run the fixtures to see the diff, update them, or save with fixtures skipped", failed, len(fixtures)))
+				return
+			}
+		}
+
+		if err := os.MkdirAll(customTemplateDir(), 0o755); err != nil {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to create templates dir")
+			return
+		}
+		path := customTemplatePath(name)
+		var before []byte
+		if existing, err := os.ReadFile(path); err == nil {
+			before = existing
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to write template")
+			return
+		}
+		templateSources.forget(name)
+		action := "create"
+		if len(before) > 0 {
+			action = "update"
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:  activeProjectID,
+			Action:     action,
+			EntityType: "template",
+			EntityLabel: sql.NullString{String: name, Valid: true},
+			Before:     templateSnapshotIfAny(name, "override", before),
+			After:      snapshotTemplate(name, "override", content),
+		})
+		redirectTemplateMessage(c, activeProjectID, name, "upload_ok", "template saved")
+	})
+	r.POST("/templates/delete", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		rawName := strings.TrimSpace(c.PostForm("template_name"))
+		name, err := normalizeTemplateName(rawName)
+		if err != nil {
+			redirectTemplateMessage(c, activeProjectID, rawName, "upload_error", "invalid template name")
+			return
+		}
+		path := customTemplatePath(name)
+		before, err := os.ReadFile(path)
+		if err != nil {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "override not found")
+			return
+		}
+		if err := os.Remove(path); err != nil {
+			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to delete template")
+			return
+		}
+		templateSources.forget(name)
+		writeAudit(db, c, auditRecord{
+			ProjectID:  activeProjectID,
+			Action:     "delete",
+			EntityType: "template",
+			EntityLabel: sql.NullString{String: name, Valid: true},
+			Before:     snapshotTemplate(name, "override", before),
+		})
+		redirectTemplateMessage(c, activeProjectID, name, "upload_ok", "template deleted")
+	})
+
+	r.POST("/templates/fixtures", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		rawName := strings.TrimSpace(c.PostForm("template_name"))
+		fixture := TemplateFixture{
+			Template: rawName,
+			Name:     c.PostForm("fixture_name"),
+			Context:  c.PostForm("context"),
+			Expected: c.PostForm("expected"),
+		}
+		if c.PostForm("capture") != "" {
+			opts := GenerateOptions{Template: rawName, IncludeVRF: true, IncludeVLAN: true, IncludeDHCP: true}
+			if id := parseProjectID(c.PostForm("segment_id")); id > 0 {
+				opts.SegmentFilter = itoa64(id)
+			}
+			captured, err := captureTemplateFixture(db, activeProjectID, opts, fixture.Name)
+			if err != nil {
+				redirectTemplateMessage(c, activeProjectID, rawName, "fixture_error", err.Error())
+				return
+			}
+			fixture = captured
+		}
+		var before any
+		if name, err := normalizeTemplateName(rawName); err == nil {
+			existing, _ := listTemplateFixtures(db, name)
+			for _, f := range existing {
+				if f.Name == strings.TrimSpace(fixture.Name) {
+					before = snapshotTemplateFixture(f)
+				}
+			}
+		}
+		saved, err := saveTemplateFixture(db, fixture)
+		if err != nil {
+			redirectTemplateMessage(c, activeProjectID, rawName, "fixture_error", err.Error())
+			return
+		}
+		action := "create"
+		if before != nil {
+			action = "update"
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:  activeProjectID,
+			Action:     action,
+			EntityType: "template_fixture",
+			EntityID:   sql.NullInt64{Int64: saved.ID, Valid: saved.ID > 0},
+			EntityLabel: sql.NullString{String: saved.Template + "/" + saved.Name, Valid: true},
+			Before:     before,
+			After:      snapshotTemplateFixture(saved),
+		})
+		redirectTemplateMessage(c, activeProjectID, saved.Template, "fixture_ok", "fixture "+saved.Name+" saved")
+	})
+	r.POST("/templates/fixtures/delete", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		fixture, ok := templateFixtureByID(db, parseProjectID(c.PostForm("fixture_id")))
+		if !ok {
+			redirectTemplateMessage(c, activeProjectID, c.PostForm("template_name"), "fixture_error", "fixture not found")
+			return
+		}
+		if err := deleteTemplateFixture(db, fixture.ID); err != nil {
+			redirectTemplateMessage(c, activeProjectID, fixture.Template, "fixture_error", err.Error())
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:  activeProjectID,
+			Action:     "delete",
+			EntityType: "template_fixture",
+			EntityID:   sql.NullInt64{Int64: fixture.ID, Valid: true},
+			EntityLabel: sql.NullString{String: fixture.Template + "/" + fixture.Name, Valid: true},
+			Before:     snapshotTemplateFixture(fixture),
+		})
+		redirectTemplateMessage(c, activeProjectID, fixture.Template, "fixture_ok", "fixture "+fixture.Name+" deleted")
+	})
+	r.GET("/api/v1/templates/:name/fixtures/run", func(c *gin.Context) {
+		name, err := normalizeTemplateName(c.Param("name"))
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		results, err := runStoredTemplateFixtures(db, name)
+		if err != nil {
+			respondError(c, 404, err)
+			return
+		}
+		failed := fixtureFailures(results)
+		c.JSON(200, gin.H{"template": name, "passed": len(results) - failed, "failed": failed, "results": results})
+	})
+	r.POST("/templates/reload", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		templateSources.Reset()
+		redirectTemplateMessage(c, activeProjectID, "", "upload_ok", "template cache cleared")
+	})
+
+	// Export
+	r.GET("/export", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		data["Active"] = "export"
+		data["ExportColumns"] = exportColumnChoices(getExportColumns(db, activeProjectID))
+		data["ExportSites"], _ = listSites(db, activeProjectID)
+		data["ExportColumnsOk"] = strings.TrimSpace(c.Query("columns_ok"))
+		data["ExportColumnsError"] = strings.TrimSpace(c.Query("columns_error"))
+		render(c, "export", data)
+	})
+	r.GET("/export/report", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		growthRate, months := growthParams(c, meta)
+		report := buildPlanReport(db, activeProjectID, growthRate, months)
+		if c.Query("format") == "pdf" {
+			c.Header("Content-Disposition", "attachment; filename="+safeName(report.Project.Name)+"_report.pdf")
+			c.Data(200, "application/pdf", renderReportPDF(report))
+			return
+		}
+		tmpl, err := loadTemplate("report")
+		if err != nil {
+			respondError(c, 500, err)
+			return
+		}
+		data["Report"] = report
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.ExecuteTemplate(c.Writer, "report", data); err != nil {
+			respondError(c, 500, err)
+		}
+	})
+	r.GET("/export/justification", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		growthRate, months := growthParams(c, meta)
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
+		report := buildJustificationReport(project, buildCapacityReport(segs, pools, sites, growthRate, months, 64), time.Now())
+		name := safeName(project.Name) + "_justification"
+		switch strings.ToLower(strings.TrimSpace(c.Query("format"))) {
+		case "csv":
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", "attachment; filename="+name+".csv")
+			if err := writeJustificationCSV(c.Writer, report); err != nil {
+				respondError(c, 500, err)
+			}
+		case "pdf":
+			c.Header("Content-Disposition", "attachment; filename="+name+".pdf")
+			c.Data(200, "application/pdf", renderJustificationPDF(report))
+		default:
+			c.Header("Content-Type", "text/plain; charset=utf-8")
+			if err := writeJustificationText(c.Writer, report); err != nil {
+				respondError(c, 500, err)
+			}
+		}
+	})
+	r.GET("/export/reverse-dns", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		zones := buildReverseDelegations(segs, sites, meta)
+		switch strings.ToLower(strings.TrimSpace(c.Query("format"))) {
+		case "json":
+			c.JSON(200, zones)
+		case "bind":
+			c.Header("Content-Disposition", "attachment; filename=subnetio_reverse_delegations.conf")
+			c.Data(200, "text/plain; charset=utf-8", []byte(renderReverseBIND(zones, strings.TrimSpace(nullString(meta.DomainName)))))
+		default:
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", "attachment; filename=subnetio_reverse_delegations.csv")
+			if err := writeReverseCSV(c.Writer, zones); err != nil {
+				respondError(c, 500, err)
+			}
+		}
+	})
+	r.GET("/export/dhcp", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		scope := planScopeFromQuery(c)
+		scopes, err := buildDHCPScopes(db, activeProjectID, scope)
 		if err != nil {
-			redirectTemplateMessage(c, activeProjectID, rawName, "upload_error", "invalid template name")
+			respondError(c, 500, err)
 			return
 		}
-
-		var content []byte
-		if fileHeader != nil {
-			file, err := fileHeader.Open()
-			if err != nil {
-				redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to read template file")
-				return
-			}
-			defer file.Close()
-			data, err := io.ReadAll(file)
-			if err != nil {
-				redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to read template file")
-				return
-			}
-			content = data
-		} else if contentField != "" {
-			content = []byte(contentField)
-		}
-		if len(content) == 0 {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template content is empty")
+		format := strings.ToLower(strings.TrimSpace(c.Query("format")))
+		out, ext, err := renderDHCPScopes(scopes, format)
+		if err != nil {
+			respondError(c, 400, err)
 			return
 		}
-		if len(content) > 1<<20 {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template is too large (max 1MB)")
+		c.Header("Content-Disposition", "attachment; filename=subnetio_dhcp"+scope.fileSuffix()+"."+ext)
+		c.Data(200, "text/plain; charset=utf-8", []byte(out))
+	})
+	r.GET("/export/acl", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		scope := planScopeFromQuery(c)
+		req, err := aclRequestFromQuery(c)
+		if err != nil {
+			respondError(c, 400, err)
 			return
 		}
-		if _, err := template.New(name).Funcs(templateFuncs()).Parse(string(content)); err != nil {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "template parse error: "+err.Error())
+		sites, _ := listSites(db, activeProjectID)
+		segs, err := listSegments(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
 			return
 		}
-
-		if err := os.MkdirAll(customTemplateDir, 0o755); err != nil {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to create templates dir")
+		_, _, segs = scope.filter(sites, nil, segs)
+		format := strings.ToLower(strings.TrimSpace(c.Query("format")))
+		out, ext, err := renderACLObjects(buildACLObjects(segs, req), format)
+		if err != nil {
+			respondError(c, 400, err)
 			return
 		}
-		path := customTemplatePath(name)
-		var before []byte
-		if existing, err := os.ReadFile(path); err == nil {
-			before = existing
+		c.Header("Content-Disposition", "attachment; filename=subnetio_acl_"+req.By+scope.fileSuffix()+"."+ext)
+		c.Data(200, "text/plain; charset=utf-8", []byte(out))
+	})
+	r.GET("/export/diagram", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		scope := planScopeFromQuery(c)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		sites, _, segs = scope.filter(sites, nil, segs)
+		projectName := "Default"
+		if p, ok := projectByID(db, activeProjectID); ok {
+			projectName = p.Name
 		}
-		if err := os.WriteFile(path, content, 0o644); err != nil {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to write template")
-			return
+		diagram := buildNetworkDiagram(projectName, sites, segs)
+		name := "subnetio_diagram" + scope.fileSuffix()
+		switch strings.ToLower(strings.TrimSpace(c.Query("format"))) {
+		case "dot":
+			c.Header("Content-Disposition", "attachment; filename="+name+".dot")
+			c.Data(200, "text/vnd.graphviz; charset=utf-8", []byte(renderDOT(projectName, diagram)))
+		default:
+			out, err := renderDrawio(projectName, diagram)
+			if err != nil {
+				respondError(c, 500, err)
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename="+name+".drawio")
+			c.Data(200, "application/xml; charset=utf-8", out)
 		}
-		action := "create"
-		if len(before) > 0 {
-			action = "update"
+	})
+	r.GET("/export/segments/csv", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		if err := exportSegmentsCSV(c, db, activeProjectID); err != nil {
+			respondError(c, 500, err)
 		}
-		writeAudit(db, c, auditRecord{
-			ProjectID:  activeProjectID,
-			Action:     action,
-			EntityType: "template",
-			EntityLabel: sql.NullString{String: name, Valid: true},
-			Before:     templateSnapshotIfAny(name, "override", before),
-			After:      snapshotTemplate(name, "override", content),
-		})
-		redirectTemplateMessage(c, activeProjectID, name, "upload_ok", "template saved")
 	})
-	r.POST("/templates/delete", func(c *gin.Context) {
+	r.POST("/export/columns", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
-		rawName := strings.TrimSpace(c.PostForm("template_name"))
-		name, err := normalizeTemplateName(rawName)
-		if err != nil {
-			redirectTemplateMessage(c, activeProjectID, rawName, "upload_error", "invalid template name")
-			return
+		before := getExportColumns(db, activeProjectID)
+		var cols []string
+		var err error
+		if c.PostForm("action") != "reset" {
+			cols, err = parseExportColumns(c.PostFormArray("columns"))
+			if err == nil && len(cols) == 0 {
+				err = fmt.Errorf("select at least one column")
+			}
 		}
-		path := customTemplatePath(name)
-		before, err := os.ReadFile(path)
-		if err != nil {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "override not found")
-			return
+		if err == nil {
+			err = saveExportColumns(db, activeProjectID, cols)
 		}
-		if err := os.Remove(path); err != nil {
-			redirectTemplateMessage(c, activeProjectID, name, "upload_error", "failed to delete template")
+		if err != nil {
+			c.Redirect(302, withBase("/export?columns_error="+url.QueryEscape(err.Error())))
 			return
 		}
 		writeAudit(db, c, auditRecord{
 			ProjectID:  activeProjectID,
-			Action:     "delete",
-			EntityType: "template",
-			EntityLabel: sql.NullString{String: name, Valid: true},
-			Before:     snapshotTemplate(name, "override", before),
+			Action:     "update",
+			EntityType: "export_columns",
+			EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
+			Before:     before,
+			After:      getExportColumns(db, activeProjectID),
 		})
-		redirectTemplateMessage(c, activeProjectID, name, "upload_ok", "template deleted")
-	})
-
-	// Export
-	r.GET("/export", func(c *gin.Context) {
-		data, _ := baseData(c, db, defaultProjectID)
-		data["Active"] = "export"
-		render(c, "export", data)
+		c.Redirect(302, withBase("/export?columns_ok=saved"))
 	})
 	r.GET("/export/csv", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportCSV(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/xlsx", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportXLSX(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/yaml", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportYAML(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/json", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportJSON(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/defaults/csv", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportDefaultsCSV(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/defaults/yaml", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportDefaultsYAML(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/defaults/json", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportDefaultsJSON(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
+		}
+	})
+	r.GET("/export/conflicts.csv", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		if err := exportConflictsCSV(c, db, activeProjectID); err != nil {
+			respondError(c, 500, err)
+		}
+	})
+	r.GET("/export/conflicts.json", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		if err := exportConflictsJSON(c, db, activeProjectID); err != nil {
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/audit/csv", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportAuditCSV(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
 		}
 	})
 	r.GET("/export/audit/json", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		if err := exportAuditJSON(c, db, activeProjectID); err != nil {
-			c.String(500, err.Error())
+			respondError(c, 500, err)
+		}
+	})
+	r.GET("/export/audit/ndjson", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		cursor, err := parseAuditCursor(c)
+		if err != nil {
+			respondError(c, 400, err)
+			return
+		}
+		if err := exportAuditNDJSON(c, db, activeProjectID, cursor); err != nil {
+			respondError(c, 500, err)
+		}
+	})
+	r.GET("/export/audit/verify", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		result, err := verifyAuditLog(db, activeProjectID)
+		if err != nil {
+			respondError(c, 500, err)
+			return
 		}
+		c.JSON(200, result)
 	})
 
 	// Import
@@ -1408,6 +4560,9 @@ func main() {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		meta, _ := getProjectMeta(db, activeProjectID)
 		report := importCSVPlan(c, db, activeProjectID)
+		if err := recordImportProvenance(db, activeProjectID, "csv", auditActor(c), report); err != nil {
+			report.Warnings = append(report.Warnings, "import provenance: "+err.Error())
+		}
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
@@ -1424,6 +4579,11 @@ func main() {
 				SitesAdded:    report.SitesAdded,
 				PoolsAdded:    report.PoolsAdded,
 				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Deleted:       report.Deleted,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
 				Warnings:      report.Warnings,
 				Errors:        report.Errors,
 			},
@@ -1437,6 +4597,9 @@ func main() {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		meta, _ := getProjectMeta(db, activeProjectID)
 		report := importPlanYAML(c, db, activeProjectID)
+		if err := recordImportProvenance(db, activeProjectID, "yaml", auditActor(c), report); err != nil {
+			report.Warnings = append(report.Warnings, "import provenance: "+err.Error())
+		}
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
@@ -1453,6 +4616,11 @@ func main() {
 				SitesAdded:    report.SitesAdded,
 				PoolsAdded:    report.PoolsAdded,
 				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Deleted:       report.Deleted,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
 				Warnings:      report.Warnings,
 				Errors:        report.Errors,
 			},
@@ -1466,6 +4634,9 @@ func main() {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		meta, _ := getProjectMeta(db, activeProjectID)
 		report := importPlanJSON(c, db, activeProjectID)
+		if err := recordImportProvenance(db, activeProjectID, "json", auditActor(c), report); err != nil {
+			report.Warnings = append(report.Warnings, "import provenance: "+err.Error())
+		}
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
@@ -1482,6 +4653,11 @@ func main() {
 				SitesAdded:    report.SitesAdded,
 				PoolsAdded:    report.PoolsAdded,
 				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Deleted:       report.Deleted,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
 				Warnings:      report.Warnings,
 				Errors:        report.Errors,
 			},
@@ -1491,9 +4667,13 @@ func main() {
 		data["ImportReport"] = report
 		render(c, "projects", data)
 	})
-	r.POST("/import/defaults/csv", func(c *gin.Context) {
+	r.POST("/import/phpipam", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
-		report := importDefaultsCSV(c, db, activeProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		report := importPHPIPAM(c, db, activeProjectID)
+		if err := recordImportProvenance(db, activeProjectID, "phpipam", auditActor(c), report); err != nil {
+			report.Warnings = append(report.Warnings, "import provenance: "+err.Error())
+		}
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
@@ -1501,26 +4681,41 @@ func main() {
 		writeAudit(db, c, auditRecord{
 			ProjectID:  activeProjectID,
 			Action:     "import",
-			EntityType: "defaults",
+			EntityType: "plan",
 			EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
 			EntityLabel: sql.NullString{String: project.Name, Valid: true},
-			After: auditDefaultsImportSummary{
-				Source:         "csv",
-				ProjectUpdated: report.ProjectUpdated,
-				SitesUpdated:   report.SitesUpdated,
-				Warnings:       report.Warnings,
-				Errors:         report.Errors,
+			After: auditImportSummary{
+				Source:        "phpipam",
+				ProjectsAdded: report.ProjectsAdded,
+				SitesAdded:    report.SitesAdded,
+				PoolsAdded:    report.PoolsAdded,
+				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
+				Warnings:      report.Warnings,
+				Errors:        report.Errors,
 			},
 		})
-		meta, _ := getProjectMeta(db, activeProjectID)
 		data["Active"] = "projects"
 		data["ProjectMeta"] = meta
-		data["DefaultsImportReport"] = report
+		data["ImportReport"] = report
 		render(c, "projects", data)
 	})
-	r.POST("/import/defaults/yaml", func(c *gin.Context) {
+	r.POST("/import/legacy/preview", func(c *gin.Context) {
+		data, _ := baseData(c, db, defaultProjectID)
+		data["Active"] = "projects"
+		data["LegacyPreview"] = previewLegacyUpload(c)
+		render(c, "legacy_import", data)
+	})
+	r.POST("/import/legacy/apply", func(c *gin.Context) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
-		report := importDefaultsYAML(c, db, activeProjectID)
+		meta, _ := getProjectMeta(db, activeProjectID)
+		report := importLegacyRows(db, legacyRowsFromForm(c), activeProjectID)
+		if err := recordImportProvenance(db, activeProjectID, "legacy-xlsx", auditActor(c), report); err != nil {
+			report.Warnings = append(report.Warnings, "import provenance: "+err.Error())
+		}
 		project := Project{ID: activeProjectID}
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
@@ -1528,21 +4723,90 @@ func main() {
 		writeAudit(db, c, auditRecord{
 			ProjectID:  activeProjectID,
 			Action:     "import",
-			EntityType: "defaults",
+			EntityType: "plan",
 			EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
 			EntityLabel: sql.NullString{String: project.Name, Valid: true},
-			After: auditDefaultsImportSummary{
-				Source:         "yaml",
-				ProjectUpdated: report.ProjectUpdated,
-				SitesUpdated:   report.SitesUpdated,
-				Warnings:       report.Warnings,
-				Errors:         report.Errors,
+			After: auditImportSummary{
+				Source:        "legacy-xlsx",
+				ProjectsAdded: report.ProjectsAdded,
+				SitesAdded:    report.SitesAdded,
+				PoolsAdded:    report.PoolsAdded,
+				SegmentsAdded: report.SegmentsAdded,
+				Updated:       report.Updated,
+				Skipped:       report.Skipped,
+				Policy:        report.Policy,
+				ImportID:      report.ImportID,
+				Warnings:      report.Warnings,
+				Errors:        report.Errors,
 			},
 		})
+		data["Active"] = "projects"
+		data["ProjectMeta"] = meta
+		data["ImportReport"] = report
+		render(c, "projects", data)
+	})
+	r.POST("/import/defaults/csv", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		report := importDefaultsCSV(c, db, activeProjectID)
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
+		if !report.DryRun {
+			writeAudit(db, c, auditRecord{
+				ProjectID:  activeProjectID,
+				Action:     "import",
+				EntityType: "defaults",
+				EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After: auditDefaultsImportSummary{
+					Source:         "csv",
+					ProjectUpdated: report.ProjectUpdated,
+					SitesUpdated:   report.SitesUpdated,
+					ImportID:       report.SnapshotID,
+					Warnings:       report.Warnings,
+					Errors:         report.Errors,
+				},
+			})
+		}
+		meta, _ := getProjectMeta(db, activeProjectID)
+		imports, _ := listDefaultsImports(db, activeProjectID, 5)
+		data["Active"] = "projects"
+		data["ProjectMeta"] = meta
+		data["DefaultsImportReport"] = report
+		data["DefaultsImports"] = imports
+		render(c, "projects", data)
+	})
+	r.POST("/import/defaults/yaml", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		report := importDefaultsYAML(c, db, activeProjectID)
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
+		if !report.DryRun {
+			writeAudit(db, c, auditRecord{
+				ProjectID:  activeProjectID,
+				Action:     "import",
+				EntityType: "defaults",
+				EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After: auditDefaultsImportSummary{
+					Source:         "yaml",
+					ProjectUpdated: report.ProjectUpdated,
+					SitesUpdated:   report.SitesUpdated,
+					ImportID:       report.SnapshotID,
+					Warnings:       report.Warnings,
+					Errors:         report.Errors,
+				},
+			})
+		}
 		meta, _ := getProjectMeta(db, activeProjectID)
+		imports, _ := listDefaultsImports(db, activeProjectID, 5)
 		data["Active"] = "projects"
 		data["ProjectMeta"] = meta
 		data["DefaultsImportReport"] = report
+		data["DefaultsImports"] = imports
 		render(c, "projects", data)
 	})
 	r.POST("/import/defaults/json", func(c *gin.Context) {
@@ -1552,42 +4816,95 @@ func main() {
 		if p, ok := projectByID(db, activeProjectID); ok {
 			project = p
 		}
+		if !report.DryRun {
+			writeAudit(db, c, auditRecord{
+				ProjectID:  activeProjectID,
+				Action:     "import",
+				EntityType: "defaults",
+				EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
+				EntityLabel: sql.NullString{String: project.Name, Valid: true},
+				After: auditDefaultsImportSummary{
+					Source:         "json",
+					ProjectUpdated: report.ProjectUpdated,
+					SitesUpdated:   report.SitesUpdated,
+					ImportID:       report.SnapshotID,
+					Warnings:       report.Warnings,
+					Errors:         report.Errors,
+				},
+			})
+		}
+		meta, _ := getProjectMeta(db, activeProjectID)
+		imports, _ := listDefaultsImports(db, activeProjectID, 5)
+		data["Active"] = "projects"
+		data["ProjectMeta"] = meta
+		data["DefaultsImportReport"] = report
+		data["DefaultsImports"] = imports
+		render(c, "projects", data)
+	})
+	r.POST("/import/defaults/rollback", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		importID, _ := strconv.ParseInt(c.PostForm("import_id"), 10, 64)
+		restored, warnings, err := rollbackDefaultsImport(db, activeProjectID, importID)
+		if err != nil {
+			c.Redirect(302, withBase("/projects?defaults_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		project := Project{ID: activeProjectID}
+		if p, ok := projectByID(db, activeProjectID); ok {
+			project = p
+		}
 		writeAudit(db, c, auditRecord{
 			ProjectID:  activeProjectID,
-			Action:     "import",
+			Action:     "rollback",
 			EntityType: "defaults",
 			EntityID:   sql.NullInt64{Int64: activeProjectID, Valid: true},
 			EntityLabel: sql.NullString{String: project.Name, Valid: true},
-			After: auditDefaultsImportSummary{
-				Source:         "json",
-				ProjectUpdated: report.ProjectUpdated,
-				SitesUpdated:   report.SitesUpdated,
-				Warnings:       report.Warnings,
-				Errors:         report.Errors,
+			After: auditDefaultsRollback{
+				ImportID: importID,
+				Restored: restored,
+				Warnings: warnings,
 			},
 		})
-		meta, _ := getProjectMeta(db, activeProjectID)
-		data["Active"] = "projects"
-		data["ProjectMeta"] = meta
-		data["DefaultsImportReport"] = report
-		render(c, "projects", data)
+		msg := fmt.Sprintf("defaults import #%d rolled back: %d change(s) restored", importID, restored)
+		if len(warnings) > 0 {
+			msg += "; " + strings.Join(warnings, "; ")
+		}
+		c.Redirect(302, withBase("/projects?defaults_ok="+url.QueryEscape(msg)))
 	})
 
 	// Rules
-	r.GET("/rules", func(c *gin.Context) {
+	// A rejected custom rules form is shown with the submitted rules in
+	// place of the stored ones.
+	renderRules := func(c *gin.Context, submitted *ProjectRules, form *FormState) {
 		data, activeProjectID := baseData(c, db, defaultProjectID)
 		rules, _ := getProjectRules(db, activeProjectID)
+		if submitted != nil {
+			rules = *submitted
+		}
 		meta, _ := getProjectMeta(db, activeProjectID)
+		presets, _ := listRulePresets(db)
 		data["Active"] = "rules"
 		data["Rules"] = rules
 		data["Meta"] = meta
-		render(c, "rules", data)
+		data["RulePresets"] = presets
+		if msg := strings.TrimSpace(c.Query("rules_error")); msg != "" {
+			data["RulesError"] = msg
+		}
+		data["Form"] = form
+		status := http.StatusOK
+		if form.Failed() {
+			status = http.StatusUnprocessableEntity
+		}
+		renderStatus(c, status, "rules", data)
+	}
+	r.GET("/rules", func(c *gin.Context) {
+		renderRules(c, nil, nil)
 	})
 	r.POST("/rules", func(c *gin.Context) {
 		_, activeProjectID := baseData(c, db, defaultProjectID)
 		beforeRules, _ := getProjectRules(db, activeProjectID)
 		preset := strings.TrimSpace(c.PostForm("preset"))
-		rules, ok := presetRules(preset)
+		rules, ok := resolveRulePreset(db, preset, beforeRules)
 		if !ok {
 			rules = ProjectRules{
 				VLANScope:            strings.TrimSpace(c.PostForm("vlan_scope")),
@@ -1596,6 +4913,18 @@ func main() {
 				OversizeThreshold:    atoiDefault(c.PostForm("oversize_threshold"), 50),
 				PoolStrategy:         strings.TrimSpace(c.PostForm("pool_strategy")),
 				PoolTierFallback:     c.PostForm("pool_tier_fallback") == "on",
+				IPv6Scheme:           strings.TrimSpace(c.PostForm("ipv6_scheme")),
+				VLANRanges:           strings.TrimSpace(c.PostForm("vlan_ranges")),
+				DefaultVRF:           strings.TrimSpace(c.PostForm("default_vrf")),
+				SegmentCheck:         c.PostForm("segment_check"),
+				PersistDerived:       c.PostForm("persist_derived") == "on",
+				Uniqueness:           uniquenessFromKeys(c.PostFormArray("unique")),
+			}
+			form := newFormState(c, "rules", 0)
+			validateRulesForm(form)
+			if form.Failed() {
+				renderRules(c, &rules, form)
+				return
 			}
 		}
 		_ = saveProjectRules(db, activeProjectID, rules)
@@ -1613,7 +4942,57 @@ func main() {
 			Before:     snapshotRules(beforeRules),
 			After:      snapshotRules(afterRules),
 		})
-		c.Redirect(302, "/rules?project_id="+itoa64(activeProjectID))
+		c.Redirect(302, withBase("/rules?project_id="+itoa64(activeProjectID)))
+	})
+	r.POST("/rules/presets", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		preset := RulePreset{
+			Name:        strings.TrimSpace(c.PostForm("name")),
+			Description: strings.TrimSpace(c.PostForm("description")),
+			Rules:       rules,
+		}
+		before, existed := rulePresetByName(db, preset.Name)
+		if err := saveRulePreset(db, preset); err != nil {
+			c.Redirect(302, withBase("/rules?project_id="+itoa64(activeProjectID)+"&rules_error="+url.QueryEscape(err.Error())))
+			return
+		}
+		rec := auditRecord{
+			ProjectID:   activeProjectID,
+			Action:      "create",
+			EntityType:  "rule_preset",
+			EntityLabel: sql.NullString{String: preset.Name, Valid: true},
+			After:       snapshotRules(rules),
+		}
+		if existed {
+			rec.Action = "update"
+			rec.Before = snapshotRules(before.Rules)
+		}
+		if saved, ok := rulePresetByName(db, preset.Name); ok {
+			rec.EntityID = sql.NullInt64{Int64: saved.ID, Valid: true}
+		}
+		writeAudit(db, c, rec)
+		c.Redirect(302, withBase("/rules?project_id="+itoa64(activeProjectID)))
+	})
+	r.POST("/rules/presets/delete", func(c *gin.Context) {
+		_, activeProjectID := baseData(c, db, defaultProjectID)
+		name := strings.TrimSpace(c.PostForm("name"))
+		before, existed := rulePresetByName(db, name)
+		if existed {
+			if _, err := deleteRulePreset(db, name); err != nil {
+				respondError(c, 500, fmt.Errorf("delete preset error: %v", err))
+				return
+			}
+			writeAudit(db, c, auditRecord{
+				ProjectID:   activeProjectID,
+				Action:      "delete",
+				EntityType:  "rule_preset",
+				EntityID:    sql.NullInt64{Int64: before.ID, Valid: true},
+				EntityLabel: sql.NullString{String: before.Name, Valid: true},
+				Before:      snapshotRules(before.Rules),
+			})
+		}
+		c.Redirect(302, withBase("/rules?project_id="+itoa64(activeProjectID)))
 	})
 	r.POST("/rules/delete", func(c *gin.Context) {
 		projectID := parseProjectID(c.PostForm("project_id"))
@@ -1635,7 +5014,28 @@ func main() {
 			Before:     snapshotRules(beforeRules),
 			After:      snapshotRules(defaultProjectRules()),
 		})
-		c.Redirect(302, "/rules?project_id="+itoa64(projectID))
+		c.Redirect(302, withBase("/rules?project_id="+itoa64(projectID)))
+	})
+
+	r.POST("/whatif/pool", func(c *gin.Context) {
+		data, activeProjectID := baseData(c, db, defaultProjectID)
+		sites, _ := listSites(db, activeProjectID)
+		segs, _ := listSegments(db, activeProjectID)
+		pools, _ := listPools(db, activeProjectID)
+		rules, _ := getProjectRules(db, activeProjectID)
+		data["Active"] = "sites"
+		data["Sites"] = sites
+		data["Pools"] = pools
+		change, err := parsePoolWhatIf(c, pools)
+		if err != nil {
+			data["PoolError"] = err.Error()
+			render(c, "sites", data)
+			return
+		}
+		data["PoolWhatIf"] = runPoolWhatIfPlan(segs, pools, sites, change, rules)
+		data["PoolRDAP"], _ = listPoolRDAP(db, pools)
+		data["PublicPools"] = publicPoolIDs(pools)
+		render(c, "sites", data)
 	})
 
 	// What-if allocation
@@ -1662,7 +5062,7 @@ func main() {
 			data["SegmentFiltersQuery"] = segmentFiltersQuery(filters)
 			data["SegmentFiltersActive"] = filtersActive(filters)
 			data["SegmentPresets"] = presets
-			data["Conflicts"] = []Conflict{{Kind: "WHATIF_ERROR", Detail: err.Error(), Level: statusWarning.Label()}}
+			data["Conflicts"] = []Conflict{{Kind: "WHATIF_ERROR", Detail: err.Error(), Level: statusWarning.Label(), key: "whatif"}}
 			render(c, "segments", data)
 			return
 		}
@@ -1670,6 +5070,8 @@ func main() {
 		statuses, conflicts := analyzeAll(segs, pools, sites, rules)
 		filters := parseSegmentFilters(c)
 		views := buildSegmentViews(segs, statuses, pools)
+		dhcpPolicies, _ := listDHCPPolicies(db, activeProjectID)
+		views = attachDHCPPolicies(views, dhcpPolicies)
 		filtered := applySegmentFilters(views, filters)
 		presets, _ := listFilterPresets(db, activeProjectID, "segments")
 
@@ -1688,22 +5090,41 @@ func main() {
 		render(c, "segments", data)
 	})
 
-	log.Printf("listening on http://%s", listen)
-	if err := r.Run(listen); err != nil {
+	log.Printf("listening on %s://%s", tlsConfig.Scheme(), listen)
+	if err := serve(listen, basePathHandler(r), tlsConfig); err != nil {
 		log.Fatal(err)
 	}
 }
 
+// growthParams reads growth_rate and months from the query, falling back to
+// the project's planning defaults.
+func growthParams(c *gin.Context, meta ProjectMeta) (float64, int) {
+	growthDefault := 5.0
+	if meta.GrowthRate.Valid {
+		growthDefault = meta.GrowthRate.Float64
+	}
+	monthsDefault := 12
+	if meta.GrowthMonths.Valid {
+		monthsDefault = int(meta.GrowthMonths.Int64)
+	}
+	return parseQueryFloat(c.Query("growth_rate"), growthDefault), parseQueryInt(c.Query("months"), monthsDefault)
+}
+
 func render(c *gin.Context, name string, data any) {
+	renderStatus(c, http.StatusOK, name, data)
+}
+
+func renderStatus(c *gin.Context, status int, name string, data any) {
 	tmpl, err := loadTemplate(name)
 	if err != nil {
-		c.String(500, err.Error())
+		respondError(c, 500, err)
 		return
 	}
-	c.Status(http.StatusOK)
+	c.Status(status)
 	c.Header("Content-Type", "text/html; charset=utf-8")
 	if err := tmpl.ExecuteTemplate(c.Writer, "layout", data); err != nil {
-		c.String(500, err.Error())
+		// The page is partly written; the status can no longer change.
+		log.Printf("render %s: %v", name, err)
 	}
 }
 
@@ -1715,7 +5136,7 @@ func loadTemplate(name string) (*template.Template, error) {
 		"web/templates/layout.gohtml",
 		"web/templates/" + name + ".gohtml",
 	}
-	tmpl, err := template.New("").ParseFS(tmplFS, files...)
+	tmpl, err := template.New("").Funcs(pageFuncs()).ParseFS(tmplFS, files...)
 	if err != nil {
 		return nil, err
 	}
@@ -1724,7 +5145,7 @@ func loadTemplate(name string) (*template.Template, error) {
 }
 
 func customTemplatePath(name string) string {
-	return filepath.Join(customTemplateDir, name+".tmpl")
+	return filepath.Join(customTemplateDir(), name+".tmpl")
 }
 
 func templateSnapshotIfAny(name, source string, content []byte) any {
@@ -1747,7 +5168,7 @@ func redirectTemplateMessage(c *gin.Context, projectID int64, templateName, key,
 	if encoded := values.Encode(); encoded != "" {
 		target += "?" + encoded
 	}
-	c.Redirect(302, target)
+	c.Redirect(302, withBase(target))
 }
 
 func ensureDefaultProject(db *sql.DB) (int64, error) {
@@ -1771,7 +5192,9 @@ func listSites(db *sql.DB, projectID int64) ([]Site, error) {
 			p.name,
 			m.region, m.dns, m.ntp, m.gateway_policy, m.reserved_ranges,
 			m.dhcp_search, m.dhcp_lease_time, m.dhcp_renew_time, m.dhcp_rebind_time,
-			m.dhcp_boot_file, m.dhcp_next_server, m.dhcp_vendor_options
+			m.dhcp_boot_file, m.dhcp_next_server, m.dhcp_vendor_options,
+			m.bgp_asn, m.vlan_domain, m.dhcp_failover_primary, m.dhcp_failover_secondary,
+			m.owner_team, m.owner_email
 		FROM sites s
 		LEFT JOIN project_sites ps ON ps.site_id = s.id
 		LEFT JOIN projects p ON p.id = ps.project_id
@@ -1797,6 +5220,8 @@ func listSites(db *sql.DB, projectID int64) ([]Site, error) {
 			&s.Region, &s.DNS, &s.NTP, &s.GatewayPolicy, &s.ReservedRanges,
 			&s.DhcpSearch, &s.DhcpLeaseTime, &s.DhcpRenewTime, &s.DhcpRebindTime,
 			&s.DhcpBootFile, &s.DhcpNextServer, &s.DhcpVendorOpts,
+			&s.BGPASN, &s.VLANDomain, &s.DhcpFailoverPrimary, &s.DhcpFailoverSecondary,
+			&s.OwnerTeam, &s.OwnerEmail,
 		); err != nil {
 			return nil, err
 		}
@@ -1805,13 +5230,22 @@ func listSites(db *sql.DB, projectID int64) ([]Site, error) {
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if err := attachSiteDevices(db, out); err != nil {
+		return nil, err
+	}
+	if err := attachSiteNAT(db, out); err != nil {
+		return nil, err
+	}
+	if err := attachSiteTemplateVars(db, out); err != nil {
+		return nil, err
+	}
 	return out, nil
 }
 
 func listPools(db *sql.DB, projectID int64) ([]Pool, error) {
 	query := `
 		SELECT p.id, p.site_id, s.name, p.cidr,
-			COALESCE(p.family, 'ipv4'), p.tier, COALESCE(p.priority, 0)
+			COALESCE(p.family, 'ipv4'), p.tier, COALESCE(p.priority, 0), p.vrf_shares, p.kind, p.state
 		FROM pools p
 		JOIN sites s ON s.id = p.site_id
 	`
@@ -1829,7 +5263,7 @@ func listPools(db *sql.DB, projectID int64) ([]Pool, error) {
 	var out []Pool
 	for rows.Next() {
 		var p Pool
-		if err := rows.Scan(&p.ID, &p.SiteID, &p.Site, &p.CIDR, &p.Family, &p.Tier, &p.Priority); err != nil {
+		if err := rows.Scan(&p.ID, &p.SiteID, &p.Site, &p.CIDR, &p.Family, &p.Tier, &p.Priority, &p.VRFShares, &p.Kind, &p.State); err != nil {
 			return nil, err
 		}
 		out = append(out, p)
@@ -1842,12 +5276,16 @@ func listPools(db *sql.DB, projectID int64) ([]Pool, error) {
 
 func listSegments(db *sql.DB, projectID int64) ([]Segment, error) {
 	query := `
-		SELECT s.id, s.site_id, si.name, s.vrf, s.vlan, s.name, s.hosts, s.prefix, s.cidr,
+		SELECT s.id, s.site_id, si.name, COALESCE(sim.vlan_domain, ''), s.vrf, s.vlan, s.name, s.hosts, s.prefix, s.cidr,
 			s.prefix_v6, s.cidr_v6, s.locked,
 			sm.dhcp_enabled, sm.dhcp_range, sm.dhcp_reservations, sm.gateway, sm.gateway_v6,
-			sm.notes, sm.tags, sm.pool_tier
+			sm.notes, sm.tags, sm.pool_tier, sm.ipv6_mode, sm.ipv6_dns,
+			sm.owner_team, sm.owner_email,
+			s.expires_at, s.expiry_action, s.expired_at,
+			s.multi_prefix, s.secondary_cidrs, s.kind, s.link_a, s.link_b
 		FROM segments s
 		JOIN sites si ON si.id = s.site_id
+		LEFT JOIN site_meta sim ON sim.site_id = s.site_id
 		LEFT JOIN segment_meta sm ON sm.segment_id = s.id
 	`
 	var args []any
@@ -1868,11 +5306,14 @@ func listSegments(db *sql.DB, projectID int64) ([]Segment, error) {
 		var lockedInt int
 		var dhcpEnabledInt sql.NullInt64
 		if err := rows.Scan(
-			&seg.ID, &seg.SiteID, &seg.Site, &seg.VRF, &seg.VLAN, &seg.Name,
+			&seg.ID, &seg.SiteID, &seg.Site, &seg.VLANDomain, &seg.VRF, &seg.VLAN, &seg.Name,
 			&seg.Hosts, &seg.Prefix, &seg.CIDR,
 			&seg.PrefixV6, &seg.CIDRV6, &lockedInt,
 			&dhcpEnabledInt, &seg.DhcpRange, &seg.DhcpReservations, &seg.Gateway, &seg.GatewayV6,
-			&seg.Notes, &seg.Tags, &seg.PoolTier,
+			&seg.Notes, &seg.Tags, &seg.PoolTier, &seg.IPv6Mode, &seg.IPv6DNS,
+			&seg.OwnerTeam, &seg.OwnerEmail,
+			&seg.ExpiresAt, &seg.ExpiryAction, &seg.ExpiredAt,
+			&seg.MultiPrefix, &seg.SecondaryCIDRs, &seg.Kind, &seg.LinkA, &seg.LinkB,
 		); err != nil {
 			return nil, err
 		}
@@ -1888,7 +5329,7 @@ func listSegments(db *sql.DB, projectID int64) ([]Segment, error) {
 
 func listProjects(db *sql.DB) ([]Project, error) {
 	rows, err := db.Query(`
-		SELECT p.id, p.name, p.description, COUNT(ps.site_id)
+		SELECT p.id, p.name, p.description, COUNT(ps.site_id), p.archived, p.sandbox, COALESCE(p.routing_domain, ''), p.audit_reads
 		FROM projects p
 		LEFT JOIN project_sites ps ON ps.project_id = p.id
 		GROUP BY p.id
@@ -1900,7 +5341,7 @@ func listProjects(db *sql.DB) ([]Project, error) {
 	var out []Project
 	for rows.Next() {
 		var p Project
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.SiteCount); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.SiteCount, &p.Archived, &p.Sandbox, &p.RoutingDomain, &p.AuditReads); err != nil {
 			return nil, err
 		}
 		out = append(out, p)
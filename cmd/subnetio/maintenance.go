@@ -0,0 +1,103 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceExemptPaths stay writable in maintenance mode: the toggle itself
+// and POST endpoints that only preview and never change the plan.
+var maintenanceExemptPaths = map[string]bool{
+	"/maintenance":                true,
+	"/whatif":                     true,
+	"/whatif/pool":                true,
+	"/import/legacy/preview":      true,
+	"/segments/quick-add/preview": true,
+	"/generate/device-diff":       true,
+	"/routes":                     true,
+	"/templates/reload":           true,
+	"/integrity/database":         true,
+	"/api/v1/generate":            true,
+	"/api/v1/whatif":              true,
+	"/api/v1/routes/reconcile":    true,
+	"/api/v1/chatops":             true,
+}
+
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Forced  bool   `json:"forced"`
+	Message string `json:"message,omitempty"`
+	Since   string `json:"since,omitempty"`
+}
+
+// getMaintenance reports the admin toggle, overridden by MAINTENANCE_MODE so a
+// deployment can force read-only mode for a migration or backup window.
+func getMaintenance(db *sql.DB) MaintenanceState {
+	var state MaintenanceState
+	var enabled int
+	var message, updatedAt sql.NullString
+	if err := db.QueryRow(`SELECT enabled, message, updated_at FROM maintenance_mode WHERE id=1`).Scan(&enabled, &message, &updatedAt); err == nil {
+		state.Enabled = enabled != 0
+		state.Message = message.String
+		if state.Enabled {
+			state.Since = updatedAt.String
+		}
+	}
+	if parseBool(mustEnv("MAINTENANCE_MODE", "")) {
+		state.Enabled = true
+		state.Forced = true
+		if msg := mustEnv("MAINTENANCE_MESSAGE", ""); msg != "" {
+			state.Message = msg
+		}
+	}
+	return state
+}
+
+func setMaintenance(db *sql.DB, enabled bool, message string) error {
+	_, err := db.Exec(`
+		INSERT INTO maintenance_mode(id, enabled, message, updated_at)
+		VALUES(1, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			enabled=excluded.enabled,
+			message=excluded.message,
+			updated_at=excluded.updated_at`,
+		boolToInt(enabled), nullStringToAny(strings.TrimSpace(message)), time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func maintenanceGuard(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutatingMethod(c.Request.Method) || maintenanceExemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		err := maintenanceError(db)
+		if err == nil {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", "300")
+		abortWithError(c, http.StatusServiceUnavailable, err)
+	}
+}
+
+// maintenanceError is the error writes get while maintenance mode is on.
+func maintenanceError(db *sql.DB) error {
+	state := getMaintenance(db)
+	if !state.Enabled {
+		return nil
+	}
+	msg := "subnetio is in maintenance mode (read-only); exports and generation remain available"
+	if state.Message != "" {
+		msg += ": " + state.Message
+	}
+	return errors.New(msg)
+}
@@ -12,10 +12,17 @@ import (
 	"time"
 )
 
+// migrate applies pending up-migrations. Each version is recorded as dirty
+// before its statements run and cleared afterwards, so a failed or
+// interrupted migration blocks startup until an operator fixes the schema
+// and runs `subnetio migrate --force N`.
 func migrate(db *sql.DB) error {
 	if err := ensureMigrationsTable(db); err != nil {
 		return err
 	}
+	if err := checkMigrationsClean(db); err != nil {
+		return err
+	}
 	files, err := listMigrationFiles()
 	if err != nil {
 		return err
@@ -47,8 +54,11 @@ func migrate(db *sql.DB) error {
 		if err != nil {
 			return err
 		}
+		if err := markMigrationDirty(db, version); err != nil {
+			return err
+		}
 		if err := execMigrationSQL(db, string(body)); err != nil {
-			return fmt.Errorf("%s: %w", file, err)
+			return fmt.Errorf("%s: %w (schema left dirty at version %d)", file, err, version)
 		}
 		if err := markMigration(db, version); err != nil {
 			return err
@@ -57,6 +67,172 @@ func migrate(db *sql.DB) error {
 	return nil
 }
 
+// migrateDown runs down-migrations, newest first, until the schema is at
+// target. Every applied version above target needs a .down.sql script.
+func migrateDown(db *sql.DB, target int) error {
+	if target < 0 {
+		return fmt.Errorf("target version must be >= 0")
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	if err := checkMigrationsClean(db); err != nil {
+		return err
+	}
+	versions, err := appliedMigrationVersions(db)
+	if err != nil {
+		return err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		if version <= target {
+			break
+		}
+		file, err := downMigrationFile(version)
+		if err != nil {
+			return err
+		}
+		body, err := migFS.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(`UPDATE schema_migrations SET dirty=1 WHERE version=?`, version); err != nil {
+			return err
+		}
+		if err := execMigrationSQL(db, string(body)); err != nil {
+			return fmt.Errorf("%s: %w (schema left dirty at version %d)", file, err, version)
+		}
+		if _, err := db.Exec(`DELETE FROM schema_migrations WHERE version=?`, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forceMigrationVersion records version as the clean current schema without
+// running any SQL; it is the way out of a dirty state after a manual fix.
+func forceMigrationVersion(db *sql.DB, version int) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	files, err := listMigrationFiles()
+	if err != nil {
+		return err
+	}
+	latest, err := latestMigrationVersion(files)
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > latest {
+		return fmt.Errorf("version %d is outside 0..%d", version, latest)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version>?`, version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE schema_migrations SET dirty=0`); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, file := range files {
+		v, err := migrationVersion(file)
+		if err != nil {
+			return err
+		}
+		if v > version {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO schema_migrations(version, applied_at, dirty) VALUES(?, ?, 0)`, v, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func checkMigrationsClean(db *sql.DB) error {
+	dirty, err := dirtyMigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	if dirty > 0 {
+		return fmt.Errorf("database schema is dirty at version %d: a migration did not finish; fix the schema and run `subnetio migrate --force N`", dirty)
+	}
+	return nil
+}
+
+func dirtyMigrationVersion(db *sql.DB) (int, error) {
+	var value sql.NullInt64
+	if err := db.QueryRow(`SELECT MIN(version) FROM schema_migrations WHERE dirty=1`).Scan(&value); err != nil {
+		return 0, err
+	}
+	return int(value.Int64), nil
+}
+
+// checkSchemaCurrent is used when serving with migrations disabled: the
+// schema must already be at the version this binary was built for.
+func checkSchemaCurrent(db *sql.DB) error {
+	if err := ensureMigrationsTable(db); err != nil {
+		return err
+	}
+	if err := checkMigrationsClean(db); err != nil {
+		return err
+	}
+	files, err := listMigrationFiles()
+	if err != nil {
+		return err
+	}
+	latest, err := latestMigrationVersion(files)
+	if err != nil {
+		return err
+	}
+	current, err := currentMigrationVersion(db)
+	if err != nil {
+		return err
+	}
+	if current != latest {
+		return fmt.Errorf("database schema is at version %d, this binary expects %d; run `subnetio migrate` first", current, latest)
+	}
+	return nil
+}
+
+func appliedMigrationVersions(db *sql.DB) ([]int, error) {
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func downMigrationFile(version int) (string, error) {
+	entries, err := migFS.ReadDir("migrations")
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".down.sql") {
+			continue
+		}
+		v, err := migrationVersion(entry.Name())
+		if err == nil && v == version {
+			return "migrations/" + entry.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("migration %03d has no down script", version)
+}
+
 func latestMigrationVersion(files []string) (int, error) {
 	latest := 0
 	for _, file := range files {
@@ -86,10 +262,18 @@ func ensureMigrationsTable(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS schema_migrations (
 			version INTEGER PRIMARY KEY,
-			applied_at TEXT NOT NULL
+			applied_at TEXT NOT NULL,
+			dirty INTEGER NOT NULL DEFAULT 0
 		)
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+	// Tables created before dirty tracking existed get the column here.
+	if _, err := db.Exec(`ALTER TABLE schema_migrations ADD COLUMN dirty INTEGER NOT NULL DEFAULT 0`); err != nil && !isDuplicateColumnError(err) {
+		return err
+	}
+	return nil
 }
 
 func listMigrationFiles() ([]string, error) {
@@ -102,7 +286,7 @@ func listMigrationFiles() ([]string, error) {
 		if entry.IsDir() {
 			continue
 		}
-		if filepath.Ext(entry.Name()) != ".sql" {
+		if filepath.Ext(entry.Name()) != ".sql" || strings.HasSuffix(entry.Name(), ".down.sql") {
 			continue
 		}
 		files = append(files, "migrations/"+entry.Name())
@@ -139,8 +323,13 @@ func migrationApplied(db *sql.DB, version int) (bool, error) {
 	return out > 0, nil
 }
 
+func markMigrationDirty(db *sql.DB, version int) error {
+	_, err := db.Exec(`INSERT INTO schema_migrations(version, applied_at, dirty) VALUES(?, ?, 1)`, version, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
 func markMigration(db *sql.DB, version int) error {
-	_, err := db.Exec(`INSERT INTO schema_migrations(version, applied_at) VALUES(?, ?)`, version, time.Now().UTC().Format(time.RFC3339))
+	_, err := db.Exec(`UPDATE schema_migrations SET dirty=0, applied_at=? WHERE version=?`, time.Now().UTC().Format(time.RFC3339), version)
 	return err
 }
 
@@ -152,7 +341,7 @@ func execMigrationSQL(db *sql.DB, body string) error {
 			continue
 		}
 		if _, err := db.Exec(stmt); err != nil {
-			if isDuplicateColumnError(err) {
+			if isDuplicateColumnError(err) || (isMissingColumnError(err) && strings.Contains(strings.ToUpper(stmt), "DROP COLUMN")) {
 				continue
 			}
 			return err
@@ -165,3 +354,8 @@ func isDuplicateColumnError(err error) bool {
 	msg := strings.ToLower(err.Error())
 	return strings.Contains(msg, "duplicate column name")
 }
+
+func isMissingColumnError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "no such column")
+}
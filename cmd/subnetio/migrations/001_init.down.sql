@@ -0,0 +1,11 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS project_meta;
+DROP TABLE IF EXISTS project_rules;
+DROP TABLE IF EXISTS segment_meta;
+DROP TABLE IF EXISTS site_meta;
+DROP TABLE IF EXISTS project_sites;
+DROP TABLE IF EXISTS projects;
+DROP TABLE IF EXISTS segments;
+DROP TABLE IF EXISTS pools;
+DROP TABLE IF EXISTS sites;
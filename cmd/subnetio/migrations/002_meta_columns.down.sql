@@ -0,0 +1,20 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE project_meta DROP COLUMN dhcp_vendor_options;
+ALTER TABLE project_meta DROP COLUMN dhcp_next_server;
+ALTER TABLE project_meta DROP COLUMN dhcp_boot_file;
+ALTER TABLE project_meta DROP COLUMN dhcp_rebind_time;
+ALTER TABLE project_meta DROP COLUMN dhcp_renew_time;
+ALTER TABLE project_meta DROP COLUMN dhcp_lease_time;
+ALTER TABLE project_meta DROP COLUMN dhcp_search;
+ALTER TABLE project_meta DROP COLUMN gateway_policy;
+ALTER TABLE project_meta DROP COLUMN ntp;
+ALTER TABLE project_meta DROP COLUMN dns;
+ALTER TABLE project_meta DROP COLUMN domain_name;
+ALTER TABLE site_meta DROP COLUMN dhcp_vendor_options;
+ALTER TABLE site_meta DROP COLUMN dhcp_next_server;
+ALTER TABLE site_meta DROP COLUMN dhcp_boot_file;
+ALTER TABLE site_meta DROP COLUMN dhcp_rebind_time;
+ALTER TABLE site_meta DROP COLUMN dhcp_renew_time;
+ALTER TABLE site_meta DROP COLUMN dhcp_lease_time;
+ALTER TABLE site_meta DROP COLUMN dhcp_search;
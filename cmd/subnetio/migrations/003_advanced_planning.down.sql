@@ -0,0 +1,15 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS deployed_configs;
+
+ALTER TABLE project_meta DROP COLUMN growth_months;
+ALTER TABLE project_meta DROP COLUMN growth_rate;
+ALTER TABLE project_rules DROP COLUMN pool_tier_fallback;
+ALTER TABLE project_rules DROP COLUMN pool_strategy;
+ALTER TABLE segment_meta DROP COLUMN pool_tier;
+ALTER TABLE segment_meta DROP COLUMN gateway_v6;
+ALTER TABLE segments DROP COLUMN cidr_v6;
+ALTER TABLE segments DROP COLUMN prefix_v6;
+ALTER TABLE pools DROP COLUMN priority;
+ALTER TABLE pools DROP COLUMN tier;
+ALTER TABLE pools DROP COLUMN family;
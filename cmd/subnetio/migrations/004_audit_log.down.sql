@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS audit_log_project_time;
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS filter_presets_project_page;
+DROP TABLE IF EXISTS filter_presets;
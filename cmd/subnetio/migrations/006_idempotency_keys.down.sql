@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS idempotency_keys_created;
+DROP TABLE IF EXISTS idempotency_keys;
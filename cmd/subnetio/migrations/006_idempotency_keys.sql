@@ -0,0 +1,13 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key TEXT PRIMARY KEY,
+  request_hash TEXT NOT NULL,
+  status INTEGER NOT NULL,
+  content_type TEXT,
+  location TEXT,
+  body BLOB,
+  created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created ON idempotency_keys(created_at);
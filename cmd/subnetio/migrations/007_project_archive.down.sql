@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE projects DROP COLUMN archived_at;
+ALTER TABLE projects DROP COLUMN archived;
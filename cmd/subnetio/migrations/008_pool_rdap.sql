@@ -0,0 +1,14 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS pool_rdap (
+  cidr TEXT PRIMARY KEY,
+  handle TEXT,
+  name TEXT,
+  org TEXT,
+  country TEXT,
+  registered_at TEXT,
+  start_address TEXT,
+  end_address TEXT,
+  error TEXT,
+  fetched_at TEXT NOT NULL
+);
@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE project_rules ADD COLUMN ipv6_scheme TEXT;
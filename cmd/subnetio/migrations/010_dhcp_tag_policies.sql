@@ -0,0 +1,17 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS dhcp_tag_policies (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  tag TEXT NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 0,
+  dhcp_search TEXT,
+  dhcp_lease_time INTEGER,
+  dhcp_renew_time INTEGER,
+  dhcp_rebind_time INTEGER,
+  dhcp_boot_file TEXT,
+  dhcp_next_server TEXT,
+  dhcp_vendor_options TEXT,
+  UNIQUE(project_id, tag),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
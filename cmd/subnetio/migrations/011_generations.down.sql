@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS idx_generations_project;
+DROP TABLE IF EXISTS generations;
@@ -0,0 +1,18 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS generations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  template TEXT NOT NULL,
+  template_version TEXT,
+  scope_key TEXT NOT NULL,
+  checksum TEXT NOT NULL,
+  actor TEXT,
+  content TEXT NOT NULL,
+  metadata TEXT,
+  created_at TEXT NOT NULL,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_generations_project ON generations(project_id, id);
@@ -0,0 +1,10 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS allocation_validators (
+  project_id INTEGER PRIMARY KEY,
+  url TEXT NOT NULL,
+  token_ref TEXT,
+  timeout_seconds INTEGER NOT NULL DEFAULT 10,
+  fail_open INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
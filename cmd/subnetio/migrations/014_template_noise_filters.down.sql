@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS template_noise_filters;
@@ -0,0 +1,9 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS template_noise_filters (
+  template TEXT PRIMARY KEY,
+  strip_comments INTEGER NOT NULL DEFAULT 1,
+  ignore_whitespace INTEGER NOT NULL DEFAULT 1,
+  ignore_patterns TEXT,
+  updated_at TEXT
+);
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE site_meta DROP COLUMN vlan_domain;
+ALTER TABLE site_meta DROP COLUMN bgp_asn;
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE site_meta ADD COLUMN bgp_asn INTEGER;
+ALTER TABLE site_meta ADD COLUMN vlan_domain TEXT;
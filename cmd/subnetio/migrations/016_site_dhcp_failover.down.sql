@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE site_meta DROP COLUMN dhcp_failover_secondary;
+ALTER TABLE site_meta DROP COLUMN dhcp_failover_primary;
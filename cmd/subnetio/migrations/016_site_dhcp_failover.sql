@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE site_meta ADD COLUMN dhcp_failover_primary TEXT;
+ALTER TABLE site_meta ADD COLUMN dhcp_failover_secondary TEXT;
@@ -0,0 +1,6 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS filter_preset_defaults;
+
+ALTER TABLE filter_presets DROP COLUMN scope;
+ALTER TABLE filter_presets DROP COLUMN description;
@@ -0,0 +1,13 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE filter_presets ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE filter_presets ADD COLUMN scope TEXT NOT NULL DEFAULT 'project';
+
+CREATE TABLE IF NOT EXISTS filter_preset_defaults (
+  project_id INTEGER NOT NULL,
+  page TEXT NOT NULL,
+  preset_id INTEGER NOT NULL,
+  PRIMARY KEY(project_id, page),
+  FOREIGN KEY(project_id) REFERENCES projects(id),
+  FOREIGN KEY(preset_id) REFERENCES filter_presets(id)
+);
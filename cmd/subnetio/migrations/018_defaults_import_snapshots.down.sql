@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS defaults_imports_project;
+DROP TABLE IF EXISTS defaults_imports;
@@ -0,0 +1,13 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS defaults_imports (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  source TEXT NOT NULL,
+  before_state TEXT NOT NULL,
+  after_state TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  rolled_back_at TEXT
+);
+
+CREATE INDEX IF NOT EXISTS defaults_imports_project ON defaults_imports(project_id, id DESC);
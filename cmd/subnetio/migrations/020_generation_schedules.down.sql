@@ -0,0 +1,7 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS idx_generations_schedule;
+DROP INDEX IF EXISTS generation_schedules_scope;
+DROP TABLE IF EXISTS generation_schedules;
+
+ALTER TABLE generations DROP COLUMN schedule_id;
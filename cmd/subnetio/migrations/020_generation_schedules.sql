@@ -0,0 +1,24 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS generation_schedules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  template TEXT NOT NULL,
+  site_filter TEXT NOT NULL DEFAULT '',
+  vrf_filter TEXT NOT NULL DEFAULT '',
+  segment_filter TEXT NOT NULL DEFAULT '',
+  cron TEXT NOT NULL,
+  retention INTEGER NOT NULL DEFAULT 10,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  last_run_at TEXT,
+  next_run_at TEXT,
+  last_error TEXT,
+  created_at TEXT NOT NULL,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS generation_schedules_scope ON generation_schedules(project_id, template, site_filter, vrf_filter, segment_filter);
+
+ALTER TABLE generations ADD COLUMN schedule_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_generations_schedule ON generations(schedule_id, id);
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS mac_reservations_mac;
+DROP TABLE IF EXISTS mac_reservations;
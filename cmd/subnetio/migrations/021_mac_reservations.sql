@@ -0,0 +1,15 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS mac_reservations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  segment_id INTEGER NOT NULL,
+  mac TEXT NOT NULL,
+  ip TEXT NOT NULL DEFAULT '',
+  hostname TEXT NOT NULL DEFAULT '',
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  UNIQUE(segment_id, mac),
+  FOREIGN KEY(segment_id) REFERENCES segments(id)
+);
+
+CREATE INDEX IF NOT EXISTS mac_reservations_mac ON mac_reservations(mac);
@@ -0,0 +1,7 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS idx_segments_expires_at;
+
+ALTER TABLE segments DROP COLUMN expired_at;
+ALTER TABLE segments DROP COLUMN expiry_action;
+ALTER TABLE segments DROP COLUMN expires_at;
@@ -0,0 +1,7 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segments ADD COLUMN expires_at TEXT;
+ALTER TABLE segments ADD COLUMN expiry_action TEXT;
+ALTER TABLE segments ADD COLUMN expired_at TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_segments_expires_at ON segments(expires_at);
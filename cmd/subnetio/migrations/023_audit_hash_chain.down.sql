@@ -0,0 +1,7 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS audit_log_unsealed;
+DROP INDEX IF EXISTS audit_log_chain;
+
+ALTER TABLE audit_log DROP COLUMN hash;
+ALTER TABLE audit_log DROP COLUMN prev_hash;
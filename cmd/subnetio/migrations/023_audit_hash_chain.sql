@@ -0,0 +1,7 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE audit_log ADD COLUMN prev_hash TEXT;
+ALTER TABLE audit_log ADD COLUMN hash TEXT;
+
+CREATE INDEX IF NOT EXISTS audit_log_chain ON audit_log(project_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_unsealed ON audit_log(id) WHERE hash IS NULL;
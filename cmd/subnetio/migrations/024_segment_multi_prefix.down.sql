@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segments DROP COLUMN secondary_cidrs;
+ALTER TABLE segments DROP COLUMN multi_prefix;
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segments ADD COLUMN multi_prefix INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE segments ADD COLUMN secondary_cidrs TEXT;
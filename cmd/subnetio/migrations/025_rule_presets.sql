@@ -0,0 +1,15 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS rule_presets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL COLLATE NOCASE UNIQUE,
+  description TEXT,
+  vlan_scope TEXT NOT NULL,
+  require_in_pool INTEGER NOT NULL,
+  allow_reserved_overlap INTEGER NOT NULL,
+  oversize_threshold INTEGER NOT NULL,
+  pool_strategy TEXT NOT NULL,
+  pool_tier_fallback INTEGER NOT NULL,
+  ipv6_scheme TEXT,
+  created_at TEXT NOT NULL
+);
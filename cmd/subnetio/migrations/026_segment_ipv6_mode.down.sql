@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segment_meta DROP COLUMN ipv6_dns;
+ALTER TABLE segment_meta DROP COLUMN ipv6_mode;
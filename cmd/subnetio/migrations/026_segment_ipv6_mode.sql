@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segment_meta ADD COLUMN ipv6_mode TEXT;
+ALTER TABLE segment_meta ADD COLUMN ipv6_dns TEXT;
@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE pools DROP COLUMN vrf_shares;
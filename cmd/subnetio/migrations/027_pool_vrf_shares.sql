@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE pools ADD COLUMN vrf_shares TEXT;
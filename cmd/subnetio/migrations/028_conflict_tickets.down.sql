@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS conflict_tickets;
+DROP TABLE IF EXISTS ticket_integrations;
@@ -0,0 +1,26 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS ticket_integrations (
+  project_id INTEGER PRIMARY KEY,
+  kind TEXT NOT NULL,
+  url TEXT NOT NULL,
+  board TEXT NOT NULL,
+  issue_type TEXT,
+  username TEXT,
+  token_ref TEXT,
+  summary_template TEXT,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
+
+CREATE TABLE IF NOT EXISTS conflict_tickets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  conflict_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  detail TEXT NOT NULL,
+  ticket_key TEXT NOT NULL,
+  ticket_url TEXT,
+  created_at TEXT NOT NULL,
+  UNIQUE(project_id, conflict_id),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
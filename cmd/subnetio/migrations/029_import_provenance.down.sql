@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS import_provenance;
+DROP TABLE IF EXISTS plan_imports;
@@ -0,0 +1,24 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS plan_imports (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  source TEXT NOT NULL,
+  file_name TEXT,
+  actor TEXT,
+  created_at TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS plan_imports_project ON plan_imports(project_id, id DESC);
+
+CREATE TABLE IF NOT EXISTS import_provenance (
+  import_id INTEGER NOT NULL,
+  entity_type TEXT NOT NULL,
+  entity_id INTEGER NOT NULL,
+  row_uid TEXT,
+  action TEXT NOT NULL,
+  PRIMARY KEY(import_id, entity_type, entity_id),
+  FOREIGN KEY(import_id) REFERENCES plan_imports(id)
+);
+
+CREATE INDEX IF NOT EXISTS import_provenance_entity ON import_provenance(entity_type, entity_id, import_id DESC);
@@ -0,0 +1,5 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE project_rules DROP COLUMN segment_check;
+ALTER TABLE project_rules DROP COLUMN default_vrf;
+ALTER TABLE project_rules DROP COLUMN vlan_ranges;
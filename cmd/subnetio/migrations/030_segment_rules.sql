@@ -0,0 +1,5 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE project_rules ADD COLUMN vlan_ranges TEXT;
+ALTER TABLE project_rules ADD COLUMN default_vrf TEXT;
+ALTER TABLE project_rules ADD COLUMN segment_check TEXT NOT NULL DEFAULT 'warn';
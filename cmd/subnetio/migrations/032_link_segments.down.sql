@@ -0,0 +1,6 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE pools DROP COLUMN kind;
+ALTER TABLE segments DROP COLUMN link_b;
+ALTER TABLE segments DROP COLUMN link_a;
+ALTER TABLE segments DROP COLUMN kind;
@@ -0,0 +1,6 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE segments ADD COLUMN kind TEXT NOT NULL DEFAULT 'lan';
+ALTER TABLE segments ADD COLUMN link_a TEXT;
+ALTER TABLE segments ADD COLUMN link_b TEXT;
+ALTER TABLE pools ADD COLUMN kind TEXT NOT NULL DEFAULT 'lan';
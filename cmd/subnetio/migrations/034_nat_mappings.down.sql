@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP INDEX IF EXISTS nat_mappings_segment;
+DROP TABLE IF EXISTS nat_mappings;
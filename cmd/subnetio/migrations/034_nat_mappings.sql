@@ -0,0 +1,17 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS nat_mappings (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  segment_id INTEGER NOT NULL,
+  type TEXT NOT NULL,
+  public_cidr TEXT NOT NULL,
+  protocol TEXT NOT NULL DEFAULT '',
+  public_port INTEGER NOT NULL DEFAULT 0,
+  private_addr TEXT NOT NULL DEFAULT '',
+  private_port INTEGER NOT NULL DEFAULT 0,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  FOREIGN KEY(segment_id) REFERENCES segments(id)
+);
+
+CREATE INDEX IF NOT EXISTS nat_mappings_segment ON nat_mappings(segment_id);
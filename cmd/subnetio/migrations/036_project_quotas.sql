@@ -0,0 +1,9 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS project_quotas (
+  project_id INTEGER PRIMARY KEY,
+  max_segments INTEGER NOT NULL DEFAULT 0,
+  max_ipv4_addresses INTEGER NOT NULL DEFAULT 0,
+  max_ipv6_prefix INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
@@ -0,0 +1,10 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS segment_tombstones (
+  project_id INTEGER NOT NULL,
+  uid TEXT NOT NULL,
+  label TEXT NOT NULL,
+  deleted_at TEXT NOT NULL,
+  PRIMARY KEY(project_id, uid),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
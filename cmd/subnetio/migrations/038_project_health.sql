@@ -0,0 +1,11 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS project_health (
+  project_id INTEGER NOT NULL,
+  day TEXT NOT NULL,
+  score INTEGER NOT NULL,
+  components TEXT NOT NULL DEFAULT '{}',
+  recorded_at TEXT NOT NULL,
+  PRIMARY KEY(project_id, day),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
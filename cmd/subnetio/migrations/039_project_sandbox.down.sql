@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE projects DROP COLUMN sandbox_since;
+ALTER TABLE projects DROP COLUMN sandbox;
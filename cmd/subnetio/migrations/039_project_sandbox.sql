@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE projects ADD COLUMN sandbox INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE projects ADD COLUMN sandbox_since TEXT;
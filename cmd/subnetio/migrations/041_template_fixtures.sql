@@ -0,0 +1,11 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS template_fixtures (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  template TEXT NOT NULL,
+  name TEXT NOT NULL,
+  context TEXT NOT NULL,
+  expected TEXT NOT NULL,
+  updated_at TEXT,
+  UNIQUE(template, name)
+);
@@ -0,0 +1,6 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+-- Existing projects keep accepting duplicates. New projects get the default
+-- rules when the application first saves their rules.
+ALTER TABLE project_rules ADD COLUMN uniqueness TEXT NOT NULL DEFAULT 'none';
+INSERT INTO project_rules(project_id) SELECT id FROM projects WHERE id NOT IN (SELECT project_id FROM project_rules);
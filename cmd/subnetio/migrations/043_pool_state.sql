@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE pools ADD COLUMN state TEXT NOT NULL DEFAULT 'active';
@@ -0,0 +1,4 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS federation_prefixes;
+DROP TABLE IF EXISTS federation_sources;
@@ -0,0 +1,28 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS federation_sources (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  url TEXT NOT NULL,
+  remote_project_id INTEGER,
+  token_ref TEXT,
+  pulled_at TEXT,
+  remote_project TEXT,
+  pull_error TEXT,
+  UNIQUE(project_id, name),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
+
+CREATE TABLE IF NOT EXISTS federation_prefixes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  source_id INTEGER NOT NULL,
+  kind TEXT NOT NULL,
+  site TEXT NOT NULL,
+  vrf TEXT,
+  name TEXT,
+  cidr TEXT NOT NULL,
+  FOREIGN KEY(source_id) REFERENCES federation_sources(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS federation_prefixes_source ON federation_prefixes(source_id);
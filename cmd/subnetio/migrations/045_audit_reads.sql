@@ -0,0 +1,3 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE projects ADD COLUMN audit_reads INTEGER NOT NULL DEFAULT 0;
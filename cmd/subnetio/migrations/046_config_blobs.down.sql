@@ -0,0 +1,15 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+-- Inline the stored content again before the blobs go away. Compressed
+-- blobs cannot be expanded in SQL, so those rows keep an empty content.
+UPDATE generations SET content = COALESCE((SELECT CAST(data AS TEXT) FROM config_blobs b WHERE b.hash=generations.content_hash AND b.encoding='identity'), content)
+  WHERE content_hash IS NOT NULL;
+UPDATE deployed_configs SET content = COALESCE((SELECT CAST(data AS TEXT) FROM config_blobs b WHERE b.hash=deployed_configs.content_hash AND b.encoding='identity'), content)
+  WHERE content_hash IS NOT NULL;
+
+DROP TABLE IF EXISTS config_retention;
+DROP INDEX IF EXISTS deployed_configs_content_hash;
+DROP INDEX IF EXISTS generations_content_hash;
+ALTER TABLE deployed_configs DROP COLUMN content_hash;
+ALTER TABLE generations DROP COLUMN content_hash;
+DROP TABLE IF EXISTS config_blobs;
@@ -0,0 +1,24 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS config_blobs (
+  hash TEXT PRIMARY KEY,
+  size INTEGER NOT NULL,
+  stored_size INTEGER NOT NULL,
+  encoding TEXT NOT NULL DEFAULT 'identity',
+  data BLOB NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+ALTER TABLE generations ADD COLUMN content_hash TEXT;
+ALTER TABLE deployed_configs ADD COLUMN content_hash TEXT;
+
+CREATE INDEX IF NOT EXISTS generations_content_hash ON generations(content_hash);
+CREATE INDEX IF NOT EXISTS deployed_configs_content_hash ON deployed_configs(content_hash);
+
+CREATE TABLE IF NOT EXISTS config_retention (
+  project_id INTEGER PRIMARY KEY,
+  keep_per_scope INTEGER NOT NULL DEFAULT 0,
+  max_age_days INTEGER NOT NULL DEFAULT 0,
+  updated_at TEXT NOT NULL,
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
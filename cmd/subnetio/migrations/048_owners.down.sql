@@ -0,0 +1,8 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+DROP TABLE IF EXISTS notifications_sent;
+DROP TABLE IF EXISTS notification_routes;
+ALTER TABLE segment_meta DROP COLUMN owner_email;
+ALTER TABLE segment_meta DROP COLUMN owner_team;
+ALTER TABLE site_meta DROP COLUMN owner_email;
+ALTER TABLE site_meta DROP COLUMN owner_team;
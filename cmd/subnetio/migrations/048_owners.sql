@@ -0,0 +1,26 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+ALTER TABLE site_meta ADD COLUMN owner_team TEXT;
+ALTER TABLE site_meta ADD COLUMN owner_email TEXT;
+ALTER TABLE segment_meta ADD COLUMN owner_team TEXT;
+ALTER TABLE segment_meta ADD COLUMN owner_email TEXT;
+
+-- A route sends the notifications of one owner team to webhooks or mail
+-- addresses. The team '*' catches whatever no other route takes.
+CREATE TABLE IF NOT EXISTS notification_routes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  project_id INTEGER NOT NULL,
+  team TEXT NOT NULL,
+  target TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  UNIQUE(project_id, team, target),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
+
+CREATE TABLE IF NOT EXISTS notifications_sent (
+  project_id INTEGER NOT NULL,
+  event_key TEXT NOT NULL,
+  sent_at TEXT NOT NULL,
+  PRIMARY KEY(project_id, event_key),
+  FOREIGN KEY(project_id) REFERENCES projects(id)
+);
@@ -0,0 +1,13 @@
+-- Copyright (c) 2025 Berik Ashimov
+
+CREATE TABLE IF NOT EXISTS db_maintenance_runs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL,
+  started_at TEXT NOT NULL,
+  duration_ms INTEGER NOT NULL DEFAULT 0,
+  size_before INTEGER NOT NULL DEFAULT 0,
+  size_after INTEGER NOT NULL DEFAULT 0,
+  free_before INTEGER NOT NULL DEFAULT 0,
+  integrity TEXT NOT NULL,
+  error TEXT
+);
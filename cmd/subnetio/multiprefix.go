@@ -0,0 +1,101 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// A hosts-based IPv4 segment with multi_prefix set may be split over several
+// prefixes when no single free block is large enough.
+const maxSegmentPrefixes = 8
+
+func parseSecondaryCIDRs(raw string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		p, err := netip.ParsePrefix(part)
+		if err != nil || !p.Addr().Is4() {
+			return nil, fmt.Errorf("invalid secondary cidr: %s", part)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+func segmentSecondaryPrefixes(s Segment) []netip.Prefix {
+	if !s.SecondaryCIDRs.Valid {
+		return nil
+	}
+	var out []netip.Prefix
+	for _, part := range strings.Split(s.SecondaryCIDRs.String, ",") {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(part)); err == nil && p.Addr().Is4() {
+			out = append(out, p.Masked())
+		}
+	}
+	return out
+}
+
+func segmentPrefixesV4(s Segment) []netip.Prefix {
+	var out []netip.Prefix
+	if s.CIDR.Valid {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(s.CIDR.String)); err == nil && p.Addr().Is4() {
+			out = append(out, p)
+		}
+	}
+	return append(out, segmentSecondaryPrefixes(s)...)
+}
+
+func joinSecondaryCIDRs(prefixes []netip.Prefix) string {
+	parts := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// usableHostsIPv4 mirrors hostsToPrefixIPv4: network, gateway and broadcast
+// are not available to hosts.
+func usableHostsIPv4(bits int) int64 {
+	return int64(1)<<uint(32-bits) - 3
+}
+
+// allocateMultiPrefix covers the host count of s with up to maxSegmentPrefixes
+// blocks.
+func allocateMultiPrefix(items []poolItem, s Segment, used []netip.Prefix, rules ProjectRules, family string) ([]netip.Prefix, bool) {
+	if family != "ipv4" || !s.MultiPrefix || s.Prefix.Valid || !s.Hosts.Valid || s.Hosts.Int64 <= 0 {
+		return nil, false
+	}
+	taken := append([]netip.Prefix(nil), used...)
+	firstFit := func(bits int) (netip.Prefix, bool) {
+		for _, pool := range items {
+			if p, ok := allocateSegmentInPool(pool.Prefix, s, bits, taken, rules, family); ok {
+				return p, true
+			}
+		}
+		return netip.Prefix{}, false
+	}
+	var out []netip.Prefix
+	remaining := s.Hosts.Int64
+	for len(out) < maxSegmentPrefixes {
+		want := hostsToPrefixIPv4(int(remaining))
+		if p, ok := firstFit(want); ok {
+			return append(out, p), true
+		}
+		var block netip.Prefix
+		found := false
+		for bits := want + 1; bits <= 30; bits++ {
+			if block, found = firstFit(bits); found {
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		out = append(out, block)
+		taken = append(taken, block)
+		remaining -= usableHostsIPv4(block.Bits())
+	}
+	return nil, false
+}
@@ -0,0 +1,421 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PoolKindNAT pools hold public addresses for NAT mappings.
+const PoolKindNAT = "nat"
+
+func isNATPool(p Pool) bool {
+	return p.Kind == PoolKindNAT
+}
+
+// NAT mapping types.
+const (
+	NATTypeOverload = "overload"
+	NATTypeStatic   = "static"
+	NATTypePort     = "port"
+)
+
+type NATMapping struct {
+	ID          int64
+	SegmentID   int64
+	SiteID      int64
+	Site        string
+	VRF         string
+	VLAN        int
+	SegmentName string
+	SegmentCIDR string
+	Type        string
+	PublicCIDR  string
+	Protocol    string
+	PublicPort  int
+	PrivateAddr string
+	PrivatePort int
+	Notes       string
+	CreatedAt   string
+}
+
+func (m NATMapping) segmentLabel() string {
+	return fmt.Sprintf("%s/%s/vlan%d %s", m.Site, m.VRF, m.VLAN, m.SegmentName)
+}
+
+// PublicLabel is the public side as shown in lists: the block, or
+// protocol/address:port for a port mapping.
+func (m NATMapping) PublicLabel() string {
+	if m.Type == NATTypePort {
+		addr := strings.TrimSuffix(m.PublicCIDR, "/32")
+		return m.Protocol + "/" + addr + ":" + strconv.Itoa(m.PublicPort)
+	}
+	return m.PublicCIDR
+}
+
+const natMappingColumns = `
+	n.id, n.segment_id, s.site_id, si.name, s.vrf, s.vlan, s.name, COALESCE(s.cidr, ''),
+	n.type, n.public_cidr, n.protocol, n.public_port, n.private_addr, n.private_port, n.notes, n.created_at`
+
+func scanNATMapping(sc interface{ Scan(...any) error }) (NATMapping, error) {
+	var m NATMapping
+	err := sc.Scan(&m.ID, &m.SegmentID, &m.SiteID, &m.Site, &m.VRF, &m.VLAN, &m.SegmentName, &m.SegmentCIDR,
+		&m.Type, &m.PublicCIDR, &m.Protocol, &m.PublicPort, &m.PrivateAddr, &m.PrivatePort, &m.Notes, &m.CreatedAt)
+	return m, err
+}
+
+func listNATMappings(db sqlConn, projectID int64) ([]NATMapping, error) {
+	rows, err := db.Query(`
+		SELECT `+natMappingColumns+`
+		FROM nat_mappings n
+		JOIN segments s ON s.id = n.segment_id
+		JOIN sites si ON si.id = s.site_id
+		JOIN project_sites ps ON ps.site_id = si.id
+		WHERE ps.project_id=?
+		ORDER BY si.name, s.vrf, s.vlan, n.id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NATMapping
+	for rows.Next() {
+		m, err := scanNATMapping(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func natMappingByID(db sqlConn, id int64) (NATMapping, bool) {
+	m, err := scanNATMapping(db.QueryRow(`
+		SELECT `+natMappingColumns+`
+		FROM nat_mappings n
+		JOIN segments s ON s.id = n.segment_id
+		JOIN sites si ON si.id = s.site_id
+		WHERE n.id=?`, id))
+	return m, err == nil
+}
+
+// attachSiteNAT fills in the NAT mappings of each site's segments.
+func attachSiteNAT(db sqlConn, sites []Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(sites))
+	for i, site := range sites {
+		index[site.ID] = i
+	}
+	rows, err := db.Query(`
+		SELECT ` + natMappingColumns + `
+		FROM nat_mappings n
+		JOIN segments s ON s.id = n.segment_id
+		JOIN sites si ON si.id = s.site_id
+		ORDER BY s.vrf, s.vlan, n.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanNATMapping(rows)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[m.SiteID]; ok {
+			sites[i].NAT = append(sites[i].NAT, m)
+		}
+	}
+	return rows.Err()
+}
+
+// normalizeNATMapping checks the fields of m on their own and returns it with
+// the public side as a prefix and the private address in canonical form.
+func normalizeNATMapping(m NATMapping) (NATMapping, error) {
+	m.Type = strings.ToLower(strings.TrimSpace(m.Type))
+	m.Protocol = strings.ToLower(strings.TrimSpace(m.Protocol))
+	m.Notes = strings.TrimSpace(m.Notes)
+	raw := strings.TrimSpace(m.PublicCIDR)
+	if raw != "" && !strings.Contains(raw, "/") {
+		raw += "/32"
+	}
+	public, err := netip.ParsePrefix(raw)
+	if err != nil || !public.Addr().Is4() {
+		return m, fmt.Errorf("invalid public IPv4 address or block %q", m.PublicCIDR)
+	}
+	m.PublicCIDR = public.Masked().String()
+	switch m.Type {
+	case NATTypeOverload, NATTypeStatic:
+		m.Protocol, m.PublicPort, m.PrivateAddr, m.PrivatePort = "", 0, "", 0
+	case NATTypePort:
+		if public.Bits() != 32 {
+			return m, errors.New("a port mapping forwards a single public address")
+		}
+		if m.Protocol != "tcp" && m.Protocol != "udp" {
+			return m, errors.New("protocol must be tcp or udp")
+		}
+		if m.PublicPort < 1 || m.PublicPort > 65535 || m.PrivatePort < 1 || m.PrivatePort > 65535 {
+			return m, errors.New("ports must be between 1 and 65535")
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(m.PrivateAddr))
+		if err != nil || !addr.Is4() {
+			return m, fmt.Errorf("invalid private IPv4 address %q", m.PrivateAddr)
+		}
+		m.PrivateAddr = addr.String()
+	default:
+		return m, fmt.Errorf("invalid NAT type %q (use overload, static or port)", m.Type)
+	}
+	return m, nil
+}
+
+// natProblems checks a mapping against the plan and the other mappings.
+func natProblems(m NATMapping, segs []Segment, pools []Pool, others []NATMapping) []string {
+	var out []string
+	public, err := netip.ParsePrefix(m.PublicCIDR)
+	if err != nil {
+		return []string{"invalid public block " + m.PublicCIDR}
+	}
+	var seg Segment
+	found := false
+	for _, s := range segs {
+		if s.ID == m.SegmentID {
+			seg, found = s, true
+			break
+		}
+	}
+	if !found {
+		return []string{"segment not found"}
+	}
+	if problem := natSegmentProblem(m, seg); problem != "" {
+		out = append(out, problem)
+	}
+	inPool := false
+	for _, p := range pools {
+		if p.SiteID != seg.SiteID || !isNATPool(p) {
+			continue
+		}
+		if pool, err := netip.ParsePrefix(p.CIDR); err == nil && pool.Bits() <= public.Bits() && pool.Contains(public.Addr()) {
+			inPool = true
+			break
+		}
+	}
+	if !inPool {
+		out = append(out, public.String()+" is not in a NAT pool of site "+seg.Site)
+	}
+	for _, s := range segs {
+		for _, p := range segmentPrefixesV4(s) {
+			if prefixesOverlap(public, p) {
+				out = append(out, public.String()+" overlaps segment "+s.Site+"/"+s.Name+" "+p.String())
+			}
+		}
+	}
+	for _, p := range pools {
+		if isNATPool(p) {
+			continue
+		}
+		if pool, err := netip.ParsePrefix(p.CIDR); err == nil && prefixesOverlap(public, pool) {
+			out = append(out, public.String()+" overlaps pool "+p.Site+" "+pool.String())
+		}
+	}
+	for _, o := range others {
+		if o.ID == m.ID {
+			continue
+		}
+		other, err := netip.ParsePrefix(o.PublicCIDR)
+		if err != nil || !prefixesOverlap(public, other) {
+			continue
+		}
+		switch {
+		case m.Type == NATTypeStatic || o.Type == NATTypeStatic:
+			out = append(out, public.String()+" overlaps the "+o.Type+" mapping "+o.PublicLabel()+" of "+o.segmentLabel())
+		case m.Type == NATTypePort && o.Type == NATTypePort && m.Protocol == o.Protocol && m.PublicPort == o.PublicPort:
+			out = append(out, m.PublicLabel()+" is already forwarded to "+o.segmentLabel())
+		}
+	}
+	return out
+}
+
+// natSegmentProblem checks a mapping against its segment.
+func natSegmentProblem(m NATMapping, seg Segment) string {
+	private, err := netip.ParsePrefix(strings.TrimSpace(seg.CIDR.String))
+	if !seg.CIDR.Valid || err != nil {
+		return "segment " + seg.Name + " has no CIDR allocated yet"
+	}
+	switch m.Type {
+	case NATTypeStatic:
+		public, err := netip.ParsePrefix(m.PublicCIDR)
+		if err != nil || public.Bits() != private.Bits() {
+			return fmt.Sprintf("static mapping needs a /%d to match %s, not %s", private.Bits(), private, m.PublicCIDR)
+		}
+	case NATTypePort:
+		addr, err := netip.ParseAddr(m.PrivateAddr)
+		if err != nil || !private.Contains(addr) {
+			return m.PrivateAddr + " is outside " + private.String()
+		}
+	}
+	return ""
+}
+
+// analyzeNAT reports the problems of the NAT mappings of sites.
+func analyzeNAT(segs []Segment, pools []Pool, sites []Site) []Conflict {
+	var all []NATMapping
+	for _, site := range sites {
+		all = append(all, site.NAT...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	var out []Conflict
+	for i, m := range all {
+		for _, problem := range natProblems(m, segs, pools, all[:i]) {
+			out = append(out, Conflict{
+				Kind:      "NAT_MAPPING",
+				Detail:    m.Type + " NAT " + m.PublicLabel() + " for " + m.segmentLabel() + ": " + problem,
+				Level:     statusConflict.Label(),
+				segmentID: m.SegmentID,
+				key:       "nat=" + m.Type + " " + m.PublicLabel() + " " + m.segmentLabel() + " " + problem,
+			})
+		}
+	}
+	return out
+}
+
+// saveNATMapping checks a mapping against the project's plan and stores it.
+func saveNATMapping(db *sql.DB, projectID int64, m NATMapping) (int64, error) {
+	m, err := normalizeNATMapping(m)
+	if err != nil {
+		return 0, err
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return 0, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return 0, err
+	}
+	others, err := listNATMappings(db, projectID)
+	if err != nil {
+		return 0, err
+	}
+	if problems := natProblems(m, segs, pools, others); len(problems) > 0 {
+		return 0, errors.New(strings.Join(problems, "; "))
+	}
+	var id int64
+	err = db.QueryRow(`
+		INSERT INTO nat_mappings(segment_id, type, public_cidr, protocol, public_port, private_addr, private_port, notes, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		m.SegmentID, m.Type, m.PublicCIDR, m.Protocol, m.PublicPort, m.PrivateAddr, m.PrivatePort, m.Notes, time.Now().UTC().Format(time.RFC3339),
+	).Scan(&id)
+	return id, err
+}
+
+func deleteNATMapping(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM nat_mappings WHERE id=?`, id)
+	return err
+}
+
+// renderNAT is a NAT mapping as templates see it.
+type renderNAT struct {
+	Rule          int
+	Name          string
+	Site          string
+	VRF           string
+	VLAN          int
+	Segment       string
+	Type          string
+	Network       string
+	Mask          string
+	Wildcard      string
+	PrefixBits    int
+	PublicNetwork string
+	PublicMask    string
+	PublicBits    int
+	PublicFirst   string
+	PublicLast    string
+	Protocol      string
+	PublicPort    int
+	PrivateAddr   string
+	PrivatePort   int
+}
+
+// buildRenderNAT returns the NAT mappings of the rendered segments.
+func buildRenderNAT(opts GenerateOptions, views []SegmentView, sites []Site) []renderNAT {
+	if !opts.WantIPv4() {
+		return nil
+	}
+	byID := make(map[int64]SegmentView, len(views))
+	for _, v := range views {
+		byID[v.ID] = v
+	}
+	var out []renderNAT
+	for _, site := range sites {
+		for _, m := range site.NAT {
+			v, ok := byID[m.SegmentID]
+			if !ok {
+				continue
+			}
+			if opts.SiteFilter != "" && opts.SiteFilter != v.Site {
+				continue
+			}
+			if opts.VRFFilter != "" && opts.VRFFilter != v.VRF {
+				continue
+			}
+			if opts.SegmentFilter != "" && !segmentFilterMatch(opts.SegmentFilter, v) {
+				continue
+			}
+			if natSegmentProblem(m, v.Segment) != "" {
+				continue
+			}
+			private, err := netip.ParsePrefix(v.CIDR)
+			if err != nil {
+				continue
+			}
+			public := netip.MustParsePrefix(m.PublicCIDR)
+			privDetails, _ := prefixDetailsIPv4(private)
+			pubDetails, _ := prefixDetailsIPv4(public)
+			wildcard, _ := wildcardMask(private)
+			last, _ := prefixLastAddr(public)
+			out = append(out, renderNAT{
+				Name:          safeName(fmt.Sprintf("NAT-%s-%s-%d", v.Site, v.Name, m.ID)),
+				Site:          v.Site,
+				VRF:           v.VRF,
+				VLAN:          v.VLAN,
+				Segment:       v.Name,
+				Type:          m.Type,
+				Network:       private.Masked().Addr().String(),
+				Mask:          privDetails.Mask,
+				Wildcard:      wildcard,
+				PrefixBits:    private.Bits(),
+				PublicNetwork: public.Addr().String(),
+				PublicMask:    pubDetails.Mask,
+				PublicBits:    public.Bits(),
+				PublicFirst:   public.Addr().String(),
+				PublicLast:    last.String(),
+				Protocol:      m.Protocol,
+				PublicPort:    m.PublicPort,
+				PrivateAddr:   m.PrivateAddr,
+				PrivatePort:   m.PrivatePort,
+			})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Site != out[j].Site {
+			return out[i].Site < out[j].Site
+		}
+		if out[i].VRF != out[j].VRF {
+			return out[i].VRF < out[j].VRF
+		}
+		return out[i].VLAN < out[j].VLAN
+	})
+	for i := range out {
+		out[i].Rule = (i + 1) * 10
+	}
+	return out
+}
@@ -0,0 +1,491 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	notificationTeamDefault = "*"
+	notificationTimeout     = 10 * time.Second
+)
+
+// NotificationRoute sends the events of one owner team to Target.
+type NotificationRoute struct {
+	Team   string `json:"team"`
+	Target string `json:"target"`
+}
+
+func (r NotificationRoute) IsWebhook() bool {
+	return isHookURL(r.Target)
+}
+
+// notificationEvent is one open conflict or capacity warning.
+type notificationEvent struct {
+	Key     string  `json:"key"`
+	Kind    string  `json:"kind"`
+	Level   string  `json:"level"`
+	Subject string  `json:"subject"`
+	Detail  string  `json:"detail"`
+	Owners  []Owner `json:"owners,omitempty"`
+}
+
+// notificationDelivery is what one target gets.
+type notificationDelivery struct {
+	Target string
+	Teams  []string
+	Events []notificationEvent
+}
+
+// NotifyResult reports a notification run.
+type NotifyResult struct {
+	Open       int
+	Sent       int
+	Pending    int
+	Unrouted   int
+	Deliveries []string
+	Errors     []string
+}
+
+// parseNotificationRoutes reads "team: target, target" lines; blank lines
+// and lines starting with # are skipped.
+func parseNotificationRoutes(raw string) ([]NotificationRoute, error) {
+	var out []NotificationRoute
+	seen := map[NotificationRoute]bool{}
+	for i, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		team, targets, ok := strings.Cut(line, ":")
+		team = strings.TrimSpace(team)
+		if !ok || team == "" || strings.HasPrefix(team, "http") {
+			return nil, fmt.Errorf("line %d: expected team: target", i+1)
+		}
+		for _, target := range strings.Split(targets, ",") {
+			target = strings.TrimSpace(target)
+			if target == "" {
+				continue
+			}
+			if !isHookURL(target) {
+				if err := validateOwnerEmail(target); err != nil {
+					return nil, fmt.Errorf("line %d: %s is neither a webhook URL nor a mail address", i+1, target)
+				}
+			}
+			route := NotificationRoute{Team: team, Target: target}
+			if !seen[route] {
+				seen[route] = true
+				out = append(out, route)
+			}
+		}
+	}
+	return out, nil
+}
+
+// formatNotificationRoutes writes routes in the form parseNotificationRoutes
+// reads, one line per team.
+func formatNotificationRoutes(routes []NotificationRoute) string {
+	var teams []string
+	targets := map[string][]string{}
+	for _, r := range routes {
+		if _, ok := targets[r.Team]; !ok {
+			teams = append(teams, r.Team)
+		}
+		targets[r.Team] = append(targets[r.Team], r.Target)
+	}
+	var b strings.Builder
+	for _, team := range teams {
+		b.WriteString(team + ": " + strings.Join(targets[team], ", ") + "\n")
+	}
+	return b.String()
+}
+
+func listNotificationRoutes(db sqlConn, projectID int64) ([]NotificationRoute, error) {
+	rows, err := db.Query(`SELECT team, target FROM notification_routes WHERE project_id=? ORDER BY team='*', team, id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []NotificationRoute
+	for rows.Next() {
+		var r NotificationRoute
+		if err := rows.Scan(&r.Team, &r.Target); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// saveNotificationRoutes replaces the routes of a project.
+func saveNotificationRoutes(db *sql.DB, projectID int64, routes []NotificationRoute) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_routes WHERE project_id=?`, projectID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, r := range routes {
+		if _, err := tx.Exec(`INSERT INTO notification_routes(project_id, team, target, created_at) VALUES(?, ?, ?, ?)`, projectID, r.Team, r.Target, now); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// projectNotificationEvents lists the project's open conflicts and capacity
+// warnings with their owners.
+func projectNotificationEvents(db *sql.DB, projectID int64) ([]notificationEvent, error) {
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	rules, _ := getProjectRules(db, projectID)
+	_, conflicts := analyzeAll(segs, pools, sites, rules)
+	var out []notificationEvent
+	for _, c := range sortConflicts(conflicts) {
+		out = append(out, notificationEvent{
+			Key:     "conflict:" + c.ID(),
+			Kind:    "conflict",
+			Level:   c.Level,
+			Subject: c.Kind,
+			Detail:  c.Detail,
+			Owners:  conflictOwners(c, segs, pools, sites),
+		})
+	}
+	for _, p := range buildCapacityReport(segs, pools, sites, 0, 0, 64).Pools {
+		if !p.Warning {
+			continue
+		}
+		var owners []Owner
+		if !p.Owner.IsZero() {
+			owners = []Owner{p.Owner}
+		}
+		out = append(out, notificationEvent{
+			Key:     "capacity:" + p.CIDR,
+			Kind:    "capacity",
+			Level:   statusWarning.Label(),
+			Subject: "pool " + p.CIDR + " at " + p.Site + " is " + p.Utilization + " used",
+			Detail:  fmt.Sprintf("site=%s pool=%s used %s of %s, %s free", p.Site, p.CIDR, p.Used, p.Total, p.Free),
+			Owners:  owners,
+		})
+	}
+	return out, nil
+}
+
+// routeNotifications groups events by target.
+func routeNotifications(routes []NotificationRoute, events []notificationEvent) ([]notificationDelivery, []notificationEvent) {
+	byTeam := map[string][]string{}
+	for _, r := range routes {
+		byTeam[r.Team] = append(byTeam[r.Team], r.Target)
+	}
+	var order []string
+	deliveries := map[string]*notificationDelivery{}
+	deliver := func(target, team string, ev notificationEvent) {
+		d, ok := deliveries[target]
+		if !ok {
+			d = &notificationDelivery{Target: target}
+			deliveries[target] = d
+			order = append(order, target)
+		}
+		if n := len(d.Events); n == 0 || d.Events[n-1].Key != ev.Key {
+			d.Events = append(d.Events, ev)
+		}
+		if team == "" {
+			return
+		}
+		for _, t := range d.Teams {
+			if t == team {
+				return
+			}
+		}
+		d.Teams = append(d.Teams, team)
+	}
+	var unrouted []notificationEvent
+	for _, ev := range events {
+		routed := false
+		for _, o := range ev.Owners {
+			if targets := byTeam[o.Team]; o.Team != "" && len(targets) > 0 {
+				for _, target := range targets {
+					deliver(target, o.Team, ev)
+				}
+				routed = true
+			} else if o.Email != "" {
+				deliver(o.Email, o.Team, ev)
+				routed = true
+			}
+		}
+		if !routed {
+			for _, target := range byTeam[notificationTeamDefault] {
+				deliver(target, notificationTeamDefault, ev)
+				routed = true
+			}
+		}
+		if !routed {
+			unrouted = append(unrouted, ev)
+		}
+	}
+	out := make([]notificationDelivery, 0, len(order))
+	for _, target := range order {
+		out = append(out, *deliveries[target])
+	}
+	return out, unrouted
+}
+
+// sendProjectNotifications sends the events not sent yet.
+func sendProjectNotifications(db *sql.DB, project Project, dryRun bool, now time.Time) (NotifyResult, error) {
+	var result NotifyResult
+	events, err := projectNotificationEvents(db, project.ID)
+	if err != nil {
+		return result, err
+	}
+	result.Open = len(events)
+	sent := map[string]bool{}
+	rows, err := db.Query(`SELECT event_key FROM notifications_sent WHERE project_id=?`, project.ID)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return result, err
+		}
+		sent[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	open := map[string]bool{}
+	var pending []notificationEvent
+	for _, ev := range events {
+		open[ev.Key] = true
+		if !sent[ev.Key] {
+			pending = append(pending, ev)
+		}
+	}
+	result.Pending = len(pending)
+	routes, err := listNotificationRoutes(db, project.ID)
+	if err != nil {
+		return result, err
+	}
+	deliveries, unrouted := routeNotifications(routes, pending)
+	result.Unrouted = len(unrouted)
+	for _, d := range deliveries {
+		label := d.Target
+		if len(d.Teams) > 0 {
+			label += " (" + strings.Join(d.Teams, ", ") + ")"
+		}
+		result.Deliveries = append(result.Deliveries, fmt.Sprintf("%s: %d event(s)", label, len(d.Events)))
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	failed := map[string]bool{}
+	for _, d := range deliveries {
+		if err := deliverNotification(project, d); err != nil {
+			result.Errors = append(result.Errors, d.Target+": "+err.Error())
+			for _, ev := range d.Events {
+				failed[ev.Key] = true
+			}
+		}
+	}
+	for _, d := range deliveries {
+		for _, ev := range d.Events {
+			if failed[ev.Key] || sent[ev.Key] {
+				continue
+			}
+			sent[ev.Key] = true
+			result.Sent++
+			if _, err := db.Exec(`INSERT INTO notifications_sent(project_id, event_key, sent_at) VALUES(?, ?, ?) ON CONFLICT DO NOTHING`,
+				project.ID, ev.Key, now.UTC().Format(time.RFC3339)); err != nil {
+				return result, err
+			}
+		}
+	}
+	for key := range sent {
+		if !open[key] {
+			if _, err := db.Exec(`DELETE FROM notifications_sent WHERE project_id=? AND event_key=?`, project.ID, key); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+type notificationPayload struct {
+	Project string              `json:"project"`
+	Teams   []string            `json:"teams"`
+	Text    string              `json:"text"`
+	Events  []notificationEvent `json:"events"`
+}
+
+func notificationText(project Project, d notificationDelivery) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subnetio %s: %d open issue(s)", project.Name, len(d.Events))
+	if len(d.Teams) > 0 {
+		fmt.Fprintf(&b, " for %s", strings.Join(d.Teams, ", "))
+	}
+	b.WriteString("\n")
+	for _, ev := range d.Events {
+		fmt.Fprintf(&b, "\n[%s] %s: %s", ev.Level, ev.Subject, ev.Detail)
+	}
+	return b.String()
+}
+
+func deliverNotification(project Project, d notificationDelivery) error {
+	text := notificationText(project, d)
+	if !isHookURL(d.Target) {
+		subject := fmt.Sprintf("Subnetio %s: %d open issue(s)", project.Name, len(d.Events))
+		return sendNotificationMail(d.Target, subject, text)
+	}
+	body, err := json.Marshal(notificationPayload{Project: project.Name, Teams: d.Teams, Text: text, Events: d.Events})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook unreachable: %v", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sendNotificationMail sends a plain text mail through SMTP_ADDR.
+var sendNotificationMail = func(to, subject, text string) error {
+	addr := mustEnv("SMTP_ADDR", "")
+	from := mustEnv("SMTP_FROM", "")
+	if addr == "" || from == "" {
+		return fmt.Errorf("mail is not configured: set SMTP_ADDR and SMTP_FROM")
+	}
+	var auth smtp.Auth
+	if user := mustEnv("SMTP_USERNAME", ""); user != "" {
+		password, err := resolveSecret(mustEnv("SMTP_PASSWORD", ""))
+		if err != nil {
+			return err
+		}
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+	return sendSMTPMail(addr, auth, from, to, notificationMailMessage(from, to, subject, text))
+}
+
+func notificationMailMessage(from, to, subject, text string) []byte {
+	header := strings.NewReplacer("\r", " ", "\n", " ")
+	msg := "From: " + header.Replace(from) + "\r\nTo: " + header.Replace(to) + "\r\nSubject: " + header.Replace(subject) +
+		"\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + strings.ReplaceAll(text, "\n", "\r\n") + "\r\n"
+	return []byte(msg)
+}
+
+// sendSMTPMail does what smtp.SendMail does, with the whole exchange bounded
+// by notificationTimeout.
+func sendSMTPMail(addr string, auth smtp.Auth, from, to string, msg []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("tcp", addr, notificationTimeout)
+	if err != nil {
+		return fmt.Errorf("smtp unreachable: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(notificationTimeout)); err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// notificationTeamsWithoutRoute lists owner teams that have no route of
+// their own, for the routing form.
+func notificationTeamsWithoutRoute(teams []string, routes []NotificationRoute) []string {
+	routed := map[string]bool{}
+	for _, r := range routes {
+		routed[r.Team] = true
+	}
+	var out []string
+	for _, team := range teams {
+		if !routed[team] {
+			out = append(out, team)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// notifySummary describes a run in one line.
+func notifySummary(r NotifyResult) string {
+	out := fmt.Sprintf("%d open, %d new, %d sent", r.Open, r.Pending, r.Sent)
+	if r.Unrouted > 0 {
+		out += fmt.Sprintf(", %d without owner or route", r.Unrouted)
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+type OverlapSide struct {
+	ProjectID int64  `json:"project_id"`
+	Project   string `json:"project"`
+	Site      string `json:"site"`
+	Kind      string `json:"kind"`
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	CIDR      string `json:"cidr"`
+	Source    string `json:"source,omitempty"`
+}
+
+type CrossProjectOverlap struct {
+	Domain string      `json:"domain"`
+	VRF    string      `json:"vrf,omitempty"`
+	Level  string      `json:"level"`
+	A      OverlapSide `json:"a"`
+	B      OverlapSide `json:"b"`
+}
+
+func (o CrossProjectOverlap) Involves(projectID int64) bool {
+	return o.A.ProjectID == projectID || o.B.ProjectID == projectID
+}
+
+type RoutingDomainReport struct {
+	Domain   string                `json:"domain"`
+	Projects []string              `json:"projects"`
+	Overlaps []CrossProjectOverlap `json:"overlaps"`
+}
+
+type overlapEntry struct {
+	side   OverlapSide
+	prefix netip.Prefix
+}
+
+func normalizeRoutingDomain(raw string) string {
+	return strings.TrimSpace(raw)
+}
+
+func setProjectRoutingDomain(db *sql.DB, projectID int64, domain string) error {
+	if projectID <= 0 {
+		return fmt.Errorf("project is required")
+	}
+	_, err := db.Exec(`UPDATE projects SET routing_domain=? WHERE id=?`, nullStringToAny(normalizeRoutingDomain(domain)), projectID)
+	return err
+}
+
+// listRoutingDomains returns the domain names in use, for suggestions.
+func listRoutingDomains(projects []Project) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, p := range projects {
+		if d := normalizeRoutingDomain(p.RoutingDomain); d != "" && !seen[strings.ToLower(d)] {
+			seen[strings.ToLower(d)] = true
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// findPrefixOverlaps reports every pair of entries from different projects
+// whose prefixes overlap.
+func findPrefixOverlaps(entries []overlapEntry, report func(a, b overlapEntry)) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].prefix, entries[j].prefix
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+	var open []overlapEntry
+	for _, e := range entries {
+		for len(open) > 0 && !open[len(open)-1].prefix.Contains(e.prefix.Addr()) {
+			open = open[:len(open)-1]
+		}
+		for _, o := range open {
+			if o.side.ProjectID != e.side.ProjectID {
+				report(o, e)
+			}
+		}
+		open = append(open, e)
+	}
+}
+
+func segmentPrefixes(s Segment) []netip.Prefix {
+	raw := []string{s.CIDR.String, s.CIDRV6.String}
+	raw = append(raw, strings.Split(s.SecondaryCIDRs.String, ",")...)
+	var out []netip.Prefix
+	for _, r := range raw {
+		if p, err := netip.ParsePrefix(strings.TrimSpace(r)); err == nil {
+			out = append(out, p.Masked())
+		}
+	}
+	return out
+}
+
+// buildCrossProjectOverlaps checks every routing domain shared by at least
+// two projects.
+func buildCrossProjectOverlaps(db *sql.DB) ([]RoutingDomainReport, error) {
+	projects, err := listProjects(db)
+	if err != nil {
+		return nil, err
+	}
+	domains := map[string]*RoutingDomainReport{}
+	members := map[string][]Project{}
+	var order []string
+	for _, p := range projects {
+		domain := normalizeRoutingDomain(p.RoutingDomain)
+		if domain == "" || p.Sandbox {
+			continue
+		}
+		key := strings.ToLower(domain)
+		if _, ok := domains[key]; !ok {
+			domains[key] = &RoutingDomainReport{Domain: domain, Overlaps: []CrossProjectOverlap{}}
+			order = append(order, key)
+		}
+		domains[key].Projects = append(domains[key].Projects, p.Name)
+		members[key] = append(members[key], p)
+	}
+	sort.Strings(order)
+
+	out := []RoutingDomainReport{}
+	for _, key := range order {
+		report := domains[key]
+		if len(members[key]) > 1 {
+			segsByVRF := map[string][]overlapEntry{}
+			var pools []overlapEntry
+			for _, p := range members[key] {
+				segs, err := listSegments(db, p.ID)
+				if err != nil {
+					return nil, err
+				}
+				for _, s := range segs {
+					vrf := strings.ToLower(strings.TrimSpace(s.VRF))
+					for _, prefix := range segmentPrefixes(s) {
+						segsByVRF[vrf] = append(segsByVRF[vrf], overlapEntry{
+							side:   OverlapSide{ProjectID: p.ID, Project: p.Name, Site: s.Site, Kind: "segment", ID: s.ID, Name: s.Name, CIDR: prefix.String()},
+							prefix: prefix,
+						})
+					}
+				}
+				list, err := listPools(db, p.ID)
+				if err != nil {
+					return nil, err
+				}
+				for _, pool := range list {
+					prefix, err := netip.ParsePrefix(strings.TrimSpace(pool.CIDR))
+					if err != nil {
+						continue
+					}
+					pools = append(pools, overlapEntry{
+						side:   OverlapSide{ProjectID: p.ID, Project: p.Name, Site: pool.Site, Kind: "pool", ID: pool.ID, Name: pool.Site, CIDR: prefix.Masked().String()},
+						prefix: prefix.Masked(),
+					})
+				}
+			}
+			vrfs := make([]string, 0, len(segsByVRF))
+			for vrf := range segsByVRF {
+				vrfs = append(vrfs, vrf)
+			}
+			sort.Strings(vrfs)
+			for _, vrf := range vrfs {
+				findPrefixOverlaps(segsByVRF[vrf], func(a, b overlapEntry) {
+					report.Overlaps = append(report.Overlaps, CrossProjectOverlap{
+						Domain: report.Domain, VRF: vrf, Level: statusConflict.Label(), A: a.side, B: b.side,
+					})
+				})
+			}
+			findPrefixOverlaps(pools, func(a, b overlapEntry) {
+				report.Overlaps = append(report.Overlaps, CrossProjectOverlap{
+					Domain: report.Domain, Level: statusWarning.Label(), A: a.side, B: b.side,
+				})
+			})
+		}
+		out = append(out, *report)
+	}
+	return out, nil
+}
+
+// projectCrossOverlaps lists the overlaps that involve the project.
+func projectCrossOverlaps(db *sql.DB, project Project) ([]CrossProjectOverlap, error) {
+	if normalizeRoutingDomain(project.RoutingDomain) == "" || project.Sandbox {
+		return nil, nil
+	}
+	reports, err := buildCrossProjectOverlaps(db)
+	if err != nil {
+		return nil, err
+	}
+	var out []CrossProjectOverlap
+	for _, r := range reports {
+		for _, o := range r.Overlaps {
+			if o.Involves(project.ID) {
+				out = append(out, o)
+			}
+		}
+	}
+	return out, nil
+}
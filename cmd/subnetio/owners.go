@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/mail"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Owner is the team and contact address responsible for a site or segment.
+type Owner struct {
+	Team  string `json:"team,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+func (o Owner) IsZero() bool {
+	return o.Team == "" && o.Email == ""
+}
+
+// Label is the owner as shown on pages, e.g. "netops <netops@example.com>".
+func (o Owner) Label() string {
+	switch {
+	case o.Team != "" && o.Email != "":
+		return o.Team + " <" + o.Email + ">"
+	case o.Team != "":
+		return o.Team
+	}
+	return o.Email
+}
+
+// validateOwnerEmail accepts an empty value or a single bare address.
+func validateOwnerEmail(raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(raw)
+	if err != nil || addr.Address != raw {
+		return fmt.Errorf("%q is not a mail address such as netops@example.com", raw)
+	}
+	return nil
+}
+
+func (s Site) Owner() Owner {
+	return Owner{Team: strings.TrimSpace(s.OwnerTeam.String), Email: strings.TrimSpace(s.OwnerEmail.String)}
+}
+
+// Owner is the segment's own owner, without falling back to the site's.
+func (s Segment) Owner() Owner {
+	return Owner{Team: strings.TrimSpace(s.OwnerTeam.String), Email: strings.TrimSpace(s.OwnerEmail.String)}
+}
+
+// segmentOwner is the segment's own owner, else its site's.
+func segmentOwner(seg Segment, sites map[int64]Site) Owner {
+	if own := seg.Owner(); !own.IsZero() {
+		return own
+	}
+	return sites[seg.SiteID].Owner()
+}
+
+func saveSiteOwner(db sqlConn, siteID int64, team, email string) error {
+	if err := validateOwnerEmail(email); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO site_meta(site_id, owner_team, owner_email)
+		VALUES(?, ?, ?)
+		ON CONFLICT(site_id) DO UPDATE SET
+			owner_team=excluded.owner_team,
+			owner_email=excluded.owner_email`,
+		siteID, nullStringToAny(strings.TrimSpace(team)), nullStringToAny(strings.TrimSpace(email)),
+	)
+	return err
+}
+
+// conflictOwners returns the owners of the segments and sites a conflict
+// names.
+func conflictOwners(c Conflict, segs []Segment, pools []Pool, sites []Site) []Owner {
+	ctx := buildTicketContext("", c, segs)
+	if len(ctx.Sites) == 0 && len(ctx.Segments) == 0 {
+		for _, p := range pools {
+			if slices.Contains(ctx.CIDRs, p.CIDR) && !slices.Contains(ctx.Sites, p.Site) {
+				ctx.Sites = append(ctx.Sites, p.Site)
+			}
+		}
+	}
+	byID := make(map[int64]Site, len(sites))
+	byName := make(map[string]Site, len(sites))
+	for _, site := range sites {
+		byID[site.ID] = site
+		byName[site.Name] = site
+	}
+	named := map[string]bool{}
+	for _, name := range ctx.Segments {
+		named[name] = true
+	}
+	inSite := map[string]bool{}
+	for _, name := range ctx.Sites {
+		inSite[name] = true
+	}
+	var out []Owner
+	seen := map[Owner]bool{}
+	add := func(o Owner) {
+		if o.IsZero() || seen[o] {
+			return
+		}
+		seen[o] = true
+		out = append(out, o)
+	}
+	covered := map[string]bool{}
+	for _, seg := range segs {
+		if !named[seg.Name] || (len(inSite) > 0 && !inSite[seg.Site]) {
+			continue
+		}
+		covered[seg.Site] = true
+		add(segmentOwner(seg, byID))
+	}
+	for _, name := range ctx.Sites {
+		if site, ok := byName[name]; ok && !covered[name] {
+			add(site.Owner())
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Label() < out[j].Label() })
+	return out
+}
+
+func conflictOwnerIndex(conflicts []Conflict, segs []Segment, pools []Pool, sites []Site) map[string][]Owner {
+	out := map[string][]Owner{}
+	for _, c := range conflicts {
+		if owners := conflictOwners(c, segs, pools, sites); len(owners) > 0 {
+			out[c.ID()] = owners
+		}
+	}
+	return out
+}
+
+func ownerTeams(db *sql.DB, projectID int64) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT m.owner_team FROM site_meta m
+		JOIN project_sites ps ON ps.site_id = m.site_id
+		WHERE ps.project_id=? AND COALESCE(m.owner_team, '')<>''
+		UNION
+		SELECT sm.owner_team FROM segment_meta sm
+		JOIN segments s ON s.id = sm.segment_id
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		WHERE ps.project_id=? AND COALESCE(sm.owner_team, '')<>''
+		ORDER BY 1`, projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var team string
+		if err := rows.Scan(&team); err != nil {
+			return nil, err
+		}
+		out = append(out, team)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfDoc is a small A4 text/table writer on top of the PDF standard fonts.
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 40.0
+	pdfMonoSize   = 8.0
+	pdfMonoWidth  = pdfMonoSize * 0.6
+)
+
+type pdfDoc struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func newPDFDoc() *pdfDoc {
+	d := &pdfDoc{}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDoc) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pdfPageHeight - pdfMargin
+}
+
+func (d *pdfDoc) ensure(h float64) {
+	if d.y-h < pdfMargin+12 {
+		d.newPage()
+	}
+}
+
+func (d *pdfDoc) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *pdfDoc) put(font string, size, x, y float64, s string) {
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (d *pdfDoc) heading(level int, s string) {
+	size := 12.0
+	if level == 1 {
+		size = 16
+	}
+	d.ensure(size * 2.5)
+	d.y -= size * 1.2
+	d.put("F2", size, pdfMargin, d.y, s)
+	d.y -= size * 0.6
+}
+
+func (d *pdfDoc) text(s string) {
+	size := 9.0
+	maxChars := int((pdfPageWidth - 2*pdfMargin) / (size * 0.5))
+	for _, line := range wrapText(s, maxChars) {
+		d.ensure(size * 1.4)
+		d.y -= size * 1.4
+		d.put("F1", size, pdfMargin, d.y, line)
+	}
+	d.y -= 4
+}
+
+// bar draws a labelled horizontal utilization bar.
+func (d *pdfDoc) bar(label string, percent float64) {
+	const h = 9.0
+	d.ensure(h + 5)
+	d.y -= h + 5
+	d.put("F3", pdfMonoSize, pdfMargin, d.y+1, truncateText(label, 38))
+	x, w := pdfMargin+190, 260.0
+	fmt.Fprintf(d.page(), "0.9 g %.2f %.2f %.2f %.2f re f\n", x, d.y, w, h)
+	if percent > 100 {
+		percent = 100
+	}
+	r, g, b := 0.25, 0.6, 0.35
+	switch {
+	case percent >= 90:
+		r, g, b = 0.8, 0.2, 0.2
+	case percent >= 70:
+		r, g, b = 0.9, 0.6, 0.1
+	}
+	fmt.Fprintf(d.page(), "%.2f %.2f %.2f rg %.2f %.2f %.2f %.2f re f 0 g\n", r, g, b, x, d.y, w*percent/100, h)
+	d.put("F3", pdfMonoSize, x+w+8, d.y+1, fmt.Sprintf("%.1f%%", percent))
+}
+
+// table lays out rows in Courier; widths are percentages of the text area
+// and long cells wrap.
+func (d *pdfDoc) table(headers []string, widths []int, rows [][]string) {
+	area := pdfPageWidth - 2*pdfMargin
+	cols := make([]int, len(widths))
+	xs := make([]float64, len(widths))
+	x := pdfMargin
+	for i, w := range widths {
+		xs[i] = x
+		span := area * float64(w) / 100
+		cols[i] = int(span/pdfMonoWidth) - 1
+		x += span
+	}
+	line := pdfMonoSize * 1.35
+	drawRow := func(font string, cells []string) {
+		wrapped := make([][]string, len(cells))
+		height := 1
+		for i, cell := range cells {
+			if i >= len(cols) {
+				break
+			}
+			wrapped[i] = wrapText(cell, cols[i])
+			if len(wrapped[i]) > height {
+				height = len(wrapped[i])
+			}
+		}
+		d.ensure(float64(height) * line)
+		for l := 0; l < height; l++ {
+			d.y -= line
+			for i, w := range wrapped {
+				if l < len(w) {
+					d.put(font, pdfMonoSize, xs[i], d.y, w[l])
+				}
+			}
+		}
+	}
+	drawRow("F4", headers)
+	fmt.Fprintf(d.page(), "0.6 G %.2f %.2f m %.2f %.2f l S 0 G\n", pdfMargin, d.y-2, pdfPageWidth-pdfMargin, d.y-2)
+	d.y -= 2
+	for _, row := range rows {
+		drawRow("F3", row)
+	}
+	d.y -= 6
+}
+
+func (d *pdfDoc) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	const firstContent = 8
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstContent+2*i+1)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, font := range []string{"Helvetica", "Helvetica-Bold", "Courier", "Courier-Bold"} {
+		obj("<< /Type /Font /Subtype /Type1 /BaseFont /" + font + " /Encoding /WinAnsiEncoding >>")
+	}
+	obj("<< /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R /F4 6 0 R >> >>")
+	for i, page := range d.pages {
+		fmt.Fprintf(page, "BT /F1 8.0 Tf %.2f %.2f Td (%s) Tj ET\n", pdfPageWidth-pdfMargin-50, pdfMargin-16, pdfEscape(fmt.Sprintf("Page %d / %d", i+1, len(d.pages))))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources 7 0 R /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, firstContent+2*i))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfEscape converts s to Latin-1 for WinAnsiEncoding and escapes string
+// delimiters; other runes become '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '\t':
+			b.WriteByte(' ')
+		case r >= 0x20 && r < 0x7f:
+			b.WriteByte(byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+func wrapText(s string, width int) []string {
+	if width < 4 {
+		width = 4
+	}
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		runes := []rune(para)
+		for len(runes) > width {
+			cut := width
+			for i := width; i > width/2; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, strings.TrimSpace(string(runes[:cut])))
+			runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+func truncateText(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "~"
+}
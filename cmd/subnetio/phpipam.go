@@ -0,0 +1,497 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const phpIPAMDefaultVRF = "default"
+
+// phpIPAMTables holds raw rows keyed by column name, regardless of whether they
+// came from the REST API or from a mysqldump file.
+type phpIPAMTables map[string][]map[string]string
+
+type phpIPAMSubnet struct {
+	ID          int64
+	SectionID   int64
+	MasterID    int64
+	VLANID      int64
+	VRFID       int64
+	LocationID  int64
+	Prefix      netip.Prefix
+	Description string
+	IsFolder    bool
+	IsPool      bool
+}
+
+type phpIPAMData struct {
+	Sections  map[int64]string
+	Subnets   []phpIPAMSubnet
+	VLANs     map[int64]int
+	VRFs      map[int64]string
+	Locations map[int64]string
+}
+
+func importPHPIPAM(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
+	report := &ImportReport{}
+	var tables phpIPAMTables
+	var err error
+	switch strings.ToLower(strings.TrimSpace(c.PostForm("source"))) {
+	case "api":
+		report.FileName = "phpIPAM API"
+		tables, err = fetchPHPIPAMFromForm(c)
+	default:
+		if fileHeader, ferr := c.FormFile("file"); ferr == nil {
+			report.FileName = fileHeader.Filename
+		}
+		tables, err = readPHPIPAMDumpUpload(c)
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, "phpipam: "+err.Error())
+		return report
+	}
+	data, warnings := parsePHPIPAMTables(tables)
+	report.Warnings = append(report.Warnings, warnings...)
+	rows, warnings := phpIPAMPlanRows(data, strings.TrimSpace(c.PostForm("site")))
+	report.Warnings = append(report.Warnings, warnings...)
+
+	state := newPlanImportState()
+	for i, row := range rows {
+		if err := applyPlanRow(db, report, state, row, i+1, activeProjectID, "phpipam"); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s %s%s: %v", row.RowType, row.Pool, row.CIDR, err))
+		}
+	}
+	return report
+}
+
+func readPHPIPAMDumpUpload(c *gin.Context) (phpIPAMTables, error) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %v", err)
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open file: %v", err)
+	}
+	defer file.Close()
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %v", err)
+	}
+	return parsePHPIPAMDump(string(raw))
+}
+
+func fetchPHPIPAMFromForm(c *gin.Context) (phpIPAMTables, error) {
+	baseURL := strings.TrimRight(strings.TrimSpace(c.PostForm("api_url")), "/")
+	if baseURL == "" {
+		baseURL = strings.TrimRight(mustEnv("PHPIPAM_URL", ""), "/")
+	}
+	appID := strings.TrimSpace(c.PostForm("app_id"))
+	if appID == "" {
+		appID = mustEnv("PHPIPAM_APP_ID", "")
+	}
+	tokenRef := strings.TrimSpace(c.PostForm("token"))
+	if tokenRef == "" {
+		tokenRef = mustEnv("PHPIPAM_TOKEN", "")
+	}
+	if baseURL == "" || appID == "" || tokenRef == "" {
+		return nil, errors.New("api url, app id and token are required")
+	}
+	if err := validateSecretField("token", tokenRef); err != nil {
+		return nil, err
+	}
+	token, err := resolveSecret(tokenRef)
+	if err != nil {
+		return nil, err
+	}
+	return fetchPHPIPAM(&http.Client{Timeout: 30 * time.Second}, baseURL, appID, token)
+}
+
+// fetchPHPIPAM reads sections, subnets, VLANs, VRFs and locations through the
+// phpIPAM REST API using an app code token.
+func fetchPHPIPAM(client *http.Client, baseURL, appID, token string) (phpIPAMTables, error) {
+	tables := phpIPAMTables{}
+	endpoints := []struct {
+		table    string
+		path     string
+		optional bool
+	}{
+		{"sections", "/sections/", false},
+		{"vlans", "/vlan/", true},
+		{"vrf", "/vrf/", true},
+		{"locations", "/tools/locations/", true},
+	}
+	for _, ep := range endpoints {
+		rows, err := phpIPAMGet(client, baseURL+"/api/"+appID+ep.path, token)
+		if err != nil {
+			if ep.optional {
+				continue
+			}
+			return nil, err
+		}
+		tables[ep.table] = rows
+	}
+	for _, section := range tables["sections"] {
+		rows, err := phpIPAMGet(client, baseURL+"/api/"+appID+"/sections/"+section["id"]+"/subnets/", token)
+		if err != nil {
+			return nil, err
+		}
+		tables["subnets"] = append(tables["subnets"], rows...)
+	}
+	return tables, nil
+}
+
+func phpIPAMGet(client *http.Client, endpoint, token string) ([]map[string]string, error) {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("token", token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	var payload struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Data    []map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 32<<20)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK || !payload.Success {
+		return nil, fmt.Errorf("%s: status %d %s", endpoint, resp.StatusCode, payload.Message)
+	}
+	out := make([]map[string]string, 0, len(payload.Data))
+	for _, item := range payload.Data {
+		row := map[string]string{}
+		for k, v := range item {
+			switch tv := v.(type) {
+			case nil:
+			case string:
+				row[k] = tv
+			case float64:
+				row[k] = strconv.FormatFloat(tv, 'f', -1, 64)
+			case bool:
+				row[k] = "0"
+				if tv {
+					row[k] = "1"
+				}
+			}
+		}
+		out = append(out, row)
+	}
+	return out, nil
+}
+
+var (
+	dumpCreatePattern = regexp.MustCompile("(?s)CREATE TABLE `([A-Za-z0-9_]+)` \\((.*?)\\n\\)")
+	dumpColumnPattern = regexp.MustCompile("(?m)^\\s*`([A-Za-z0-9_]+)`")
+	dumpInsertPattern = regexp.MustCompile("INSERT INTO `([A-Za-z0-9_]+)`\\s*(\\(([^)]*)\\))?\\s*VALUES\\s*")
+)
+
+var phpIPAMDumpTables = map[string]bool{"sections": true, "subnets": true, "vlans": true, "vrf": true, "locations": true}
+
+// parsePHPIPAMDump extracts the phpIPAM tables from a mysqldump file.
+func parsePHPIPAMDump(dump string) (phpIPAMTables, error) {
+	columns := map[string][]string{}
+	for _, m := range dumpCreatePattern.FindAllStringSubmatch(dump, -1) {
+		for _, col := range dumpColumnPattern.FindAllStringSubmatch(m[2], -1) {
+			columns[m[1]] = append(columns[m[1]], col[1])
+		}
+	}
+	tables := phpIPAMTables{}
+	for _, loc := range dumpInsertPattern.FindAllStringSubmatchIndex(dump, -1) {
+		table := dump[loc[2]:loc[3]]
+		if !phpIPAMDumpTables[table] {
+			continue
+		}
+		cols := columns[table]
+		if loc[6] >= 0 {
+			cols = nil
+			for _, col := range strings.Split(dump[loc[6]:loc[7]], ",") {
+				cols = append(cols, strings.Trim(strings.TrimSpace(col), "`"))
+			}
+		}
+		if len(cols) == 0 {
+			return nil, fmt.Errorf("dump: no column list for table %s", table)
+		}
+		tuples, err := parseDumpValues(dump[loc[1]:])
+		if err != nil {
+			return nil, fmt.Errorf("dump: table %s: %w", table, err)
+		}
+		for _, values := range tuples {
+			if len(values) != len(cols) {
+				return nil, fmt.Errorf("dump: table %s: %d values for %d columns", table, len(values), len(cols))
+			}
+			row := map[string]string{}
+			for i, col := range cols {
+				if values[i] != nil {
+					row[col] = *values[i]
+				}
+			}
+			tables[table] = append(tables[table], row)
+		}
+	}
+	if len(tables["subnets"]) == 0 {
+		return nil, errors.New("dump: no subnets found")
+	}
+	return tables, nil
+}
+
+// parseDumpValues reads "(...),(...);" tuples; NULL values are returned as nil.
+func parseDumpValues(s string) ([][]*string, error) {
+	var out [][]*string
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',' || s[i] == '\n' || s[i] == '\r' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) || s[i] == ';' {
+			return out, nil
+		}
+		if s[i] != '(' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+		}
+		i++
+		var tuple []*string
+		for {
+			for i < len(s) && s[i] == ' ' {
+				i++
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated tuple")
+			}
+			if s[i] == '\'' {
+				var b strings.Builder
+				i++
+				for ; i < len(s) && s[i] != '\''; i++ {
+					if s[i] == '\\' && i+1 < len(s) {
+						i++
+						switch s[i] {
+						case 'n':
+							b.WriteByte('\n')
+						case 'r':
+							b.WriteByte('\r')
+						case 't':
+							b.WriteByte('\t')
+						case '0':
+							b.WriteByte(0)
+						default:
+							b.WriteByte(s[i])
+						}
+						continue
+					}
+					b.WriteByte(s[i])
+				}
+				if i >= len(s) {
+					return nil, errors.New("unterminated string")
+				}
+				i++
+				v := b.String()
+				tuple = append(tuple, &v)
+			} else {
+				start := i
+				for i < len(s) && s[i] != ',' && s[i] != ')' {
+					i++
+				}
+				raw := strings.TrimSpace(s[start:i])
+				if strings.EqualFold(raw, "NULL") {
+					tuple = append(tuple, nil)
+				} else {
+					tuple = append(tuple, &raw)
+				}
+			}
+			if i >= len(s) {
+				return nil, errors.New("unterminated tuple")
+			}
+			if s[i] == ')' {
+				i++
+				break
+			}
+			i++
+		}
+		out = append(out, tuple)
+	}
+}
+
+func phpIPAMInt(row map[string]string, key string) int64 {
+	v, _ := strconv.ParseInt(strings.TrimSpace(row[key]), 10, 64)
+	return v
+}
+
+// phpIPAMAddress accepts the dotted form returned by the API and the decimal
+// integer form stored in the database.
+func phpIPAMAddress(raw string) (netip.Addr, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.ContainsAny(raw, ".:") {
+		return netip.ParseAddr(raw)
+	}
+	n, ok := new(big.Int).SetString(raw, 10)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid subnet address %q", raw)
+	}
+	bits := 128
+	if n.BitLen() <= 32 {
+		bits = 32
+	}
+	addr, ok := bigToAddr(n, bits)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid subnet address %q", raw)
+	}
+	return addr, nil
+}
+
+func parsePHPIPAMTables(tables phpIPAMTables) (phpIPAMData, []string) {
+	data := phpIPAMData{
+		Sections:  map[int64]string{},
+		VLANs:     map[int64]int{},
+		VRFs:      map[int64]string{},
+		Locations: map[int64]string{},
+	}
+	var warnings []string
+	for _, row := range tables["sections"] {
+		data.Sections[phpIPAMInt(row, "id")] = strings.TrimSpace(row["name"])
+	}
+	for _, row := range tables["vlans"] {
+		data.VLANs[phpIPAMInt(row, "vlanId")] = int(phpIPAMInt(row, "number"))
+	}
+	for _, row := range tables["vrf"] {
+		data.VRFs[phpIPAMInt(row, "vrfId")] = strings.TrimSpace(row["name"])
+	}
+	for _, row := range tables["locations"] {
+		data.Locations[phpIPAMInt(row, "id")] = strings.TrimSpace(row["name"])
+	}
+	for _, row := range tables["subnets"] {
+		s := phpIPAMSubnet{
+			ID:          phpIPAMInt(row, "id"),
+			SectionID:   phpIPAMInt(row, "sectionId"),
+			MasterID:    phpIPAMInt(row, "masterSubnetId"),
+			VLANID:      phpIPAMInt(row, "vlanId"),
+			VRFID:       phpIPAMInt(row, "vrfId"),
+			LocationID:  phpIPAMInt(row, "location"),
+			Description: strings.TrimSpace(row["description"]),
+			IsFolder:    row["isFolder"] == "1",
+			IsPool:      row["isPool"] == "1",
+		}
+		if s.IsFolder || strings.TrimSpace(row["subnet"]) == "" {
+			continue
+		}
+		addr, err := phpIPAMAddress(row["subnet"])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("phpipam subnet %d: %v", s.ID, err))
+			continue
+		}
+		prefix, err := addr.Prefix(int(phpIPAMInt(row, "mask")))
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("phpipam subnet %d: invalid mask %q", s.ID, row["mask"]))
+			continue
+		}
+		s.Prefix = prefix
+		data.Subnets = append(data.Subnets, s)
+	}
+	sort.Slice(data.Subnets, func(i, j int) bool { return data.Subnets[i].ID < data.Subnets[j].ID })
+	return data, warnings
+}
+
+// phpIPAMPlanRows maps sections to projects, subnets with children (or marked
+// as pools) to pools and leaf subnets to locked segments.
+func phpIPAMPlanRows(data phpIPAMData, defaultSite string) ([]PlanRow, []string) {
+	var warnings []string
+	hasChildren := map[int64]bool{}
+	for _, s := range data.Subnets {
+		if s.MasterID > 0 {
+			hasChildren[s.MasterID] = true
+		}
+	}
+	siteFor := func(s phpIPAMSubnet) string {
+		if name := data.Locations[s.LocationID]; name != "" {
+			return name
+		}
+		if defaultSite != "" {
+			return defaultSite
+		}
+		return data.Sections[s.SectionID]
+	}
+
+	var pools, segments []PlanRow
+	seenSites := map[string]bool{}
+	var sites []PlanRow
+	for _, s := range data.Subnets {
+		project := data.Sections[s.SectionID]
+		site := siteFor(s)
+		if site == "" {
+			warnings = append(warnings, fmt.Sprintf("phpipam subnet %s: no site, skipped", s.Prefix))
+			continue
+		}
+		if key := project + "\x00" + site; !seenSites[key] {
+			seenSites[key] = true
+			sites = append(sites, PlanRow{RowType: planRowSite, Project: project, Site: site})
+		}
+		family := "ipv4"
+		if s.Prefix.Addr().Is6() {
+			family = "ipv6"
+		}
+		if hasChildren[s.ID] || s.IsPool {
+			pools = append(pools, PlanRow{RowType: planRowPool, Project: project, Site: site, Pool: s.Prefix.String(), PoolFamily: family})
+			continue
+		}
+		vlan, ok := data.VLANs[s.VLANID]
+		if !ok || vlan <= 0 {
+			warnings = append(warnings, fmt.Sprintf("phpipam subnet %s: no VLAN, skipped", s.Prefix))
+			continue
+		}
+		vrf := data.VRFs[s.VRFID]
+		if vrf == "" {
+			vrf = phpIPAMDefaultVRF
+		}
+		name := s.Description
+		if name == "" {
+			name = s.Prefix.String()
+		}
+		locked := true
+		dhcp := false
+		row := PlanRow{
+			RowType: planRowSegment,
+			Project: project,
+			Site:    site,
+			VRF:     vrf,
+			VLAN:    &vlan,
+			Name:    name,
+			Locked:  &locked,
+			DHCP:    &dhcp,
+			Notes:   "phpipam subnet " + strconv.FormatInt(s.ID, 10),
+		}
+		bits := s.Prefix.Bits()
+		if family == "ipv6" {
+			row.CIDRV6 = s.Prefix.String()
+			row.PrefixV6 = &bits
+		} else {
+			row.CIDR = s.Prefix.String()
+			row.Prefix = &bits
+		}
+		segments = append(segments, row)
+	}
+	rows := append(append(sites, pools...), segments...)
+	return rows, warnings
+}
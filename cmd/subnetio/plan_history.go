@@ -0,0 +1,239 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// asOfLayouts are the accepted forms of the as_of parameter, read as UTC.
+var asOfLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// parseAsOf reads an as_of value; an empty one is the zero time, meaning now.
+func parseAsOf(raw string) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range asOfLayouts {
+		t, err := time.ParseInLocation(layout, raw, time.UTC)
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		if t.After(time.Now()) {
+			return time.Time{}, fmt.Errorf("as_of %s is in the future", raw)
+		}
+		return t.UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid as_of %q: use 2006-01-02, 2006-01-02T15:04 or RFC 3339", raw)
+}
+
+type PlanHistory struct {
+	At       time.Time
+	Segments []Segment
+	Undone   int
+	Gaps     []AuditEntry
+	Added    []Segment
+	Removed  []Segment
+	Changed  []Segment
+}
+
+// planAsOf rebuilds the project's segments as they were at the given time.
+func planAsOf(db *sql.DB, projectID int64, at time.Time) (PlanHistory, error) {
+	h := PlanHistory{At: at}
+	current, err := listSegments(db, projectID)
+	if err != nil {
+		return h, err
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return h, err
+	}
+	state := make(map[int64]auditSegmentSnapshot, len(current))
+	for _, seg := range current {
+		state[seg.ID] = snapshotSegment(seg)
+	}
+	rows, err := db.Query(`
+		SELECT id, project_id, actor, action, entity_type, entity_id, entity_label, reason, before_json, after_json, created_at
+		FROM audit_log
+		WHERE project_id=? AND created_at>? AND entity_type IN ('segment', 'allocation', 'plan', 'site')
+		ORDER BY created_at DESC, id DESC`, projectID, at.UTC().Format(time.RFC3339))
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.Actor, &e.Action, &e.EntityType, &e.EntityID, &e.EntityLabel,
+			&e.Reason, &e.BeforeJSON, &e.AfterJSON, &e.CreatedAt); err != nil {
+			return h, err
+		}
+		switch undoAuditEntry(state, e) {
+		case undoApplied:
+			h.Undone++
+		case undoGap:
+			h.Gaps = append(h.Gaps, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return h, err
+	}
+
+	siteByName := map[string]Site{}
+	for _, s := range sites {
+		siteByName[s.Name] = s
+	}
+	for _, snap := range state {
+		h.Segments = append(h.Segments, segmentFromSnapshot(snap, siteByName))
+	}
+	sort.Slice(h.Segments, func(i, j int) bool {
+		a, b := h.Segments[i], h.Segments[j]
+		if a.Site != b.Site {
+			return a.Site < b.Site
+		}
+		if a.VRF != b.VRF {
+			return a.VRF < b.VRF
+		}
+		if a.VLAN != b.VLAN {
+			return a.VLAN < b.VLAN
+		}
+		return a.Name < b.Name
+	})
+
+	past := make(map[int64]Segment, len(h.Segments))
+	for _, seg := range h.Segments {
+		past[seg.ID] = seg
+	}
+	for _, seg := range current {
+		old, ok := past[seg.ID]
+		switch {
+		case !ok:
+			h.Added = append(h.Added, seg)
+		case !reflect.DeepEqual(snapshotSegment(old), snapshotSegment(seg)):
+			h.Changed = append(h.Changed, old)
+		}
+		delete(past, seg.ID)
+	}
+	for _, seg := range h.Segments {
+		if _, ok := past[seg.ID]; ok {
+			h.Removed = append(h.Removed, seg)
+		}
+	}
+	return h, nil
+}
+
+type undoResult int
+
+const (
+	undoSkipped undoResult = iota
+	undoApplied
+	undoGap
+)
+
+// undoAuditEntry rolls state back over one audit entry.
+func undoAuditEntry(state map[int64]auditSegmentSnapshot, e AuditEntry) undoResult {
+	switch e.EntityType {
+	case "segment":
+		switch e.Action {
+		case "create":
+			if !e.EntityID.Valid {
+				return undoGap
+			}
+			delete(state, e.EntityID.Int64)
+			return undoApplied
+		case "update", "delete", "expire":
+			var before auditSegmentSnapshot
+			if !e.BeforeJSON.Valid || json.Unmarshal([]byte(e.BeforeJSON.String), &before) != nil || before.ID == 0 {
+				return undoGap
+			}
+			state[before.ID] = before
+			return undoApplied
+		case "bulk_delete", "bulk_update":
+			var payload struct {
+				Segments []auditSegmentSnapshot `json:"segments"`
+			}
+			if !e.BeforeJSON.Valid || json.Unmarshal([]byte(e.BeforeJSON.String), &payload) != nil {
+				return undoGap
+			}
+			for _, before := range payload.Segments {
+				state[before.ID] = before
+			}
+			return undoApplied
+		}
+		return undoGap
+	case "allocation":
+		if e.Action != "allocate" {
+			return undoSkipped
+		}
+		var summary auditAllocationSummary
+		if !e.AfterJSON.Valid || json.Unmarshal([]byte(e.AfterJSON.String), &summary) != nil {
+			return undoGap
+		}
+		for _, ch := range summary.Changes {
+			if snap, ok := state[ch.SegmentID]; ok {
+				snap.CIDR, snap.CIDRV6 = ch.CIDRBefore, ch.CIDRV6Before
+				state[ch.SegmentID] = snap
+			}
+		}
+		return undoApplied
+	case "plan":
+		if e.Action == "import" {
+			return undoGap
+		}
+	case "site":
+		if e.Action == "delete" {
+			return undoGap
+		}
+	}
+	return undoSkipped
+}
+
+func segmentFromSnapshot(s auditSegmentSnapshot, sites map[string]Site) Segment {
+	seg := Segment{
+		ID:               s.ID,
+		Site:             s.Site,
+		VRF:              s.VRF,
+		VLAN:             s.VLAN,
+		Name:             s.Name,
+		Hosts:            intPtrToNull(s.Hosts),
+		Prefix:           intPtrToNull(s.Prefix),
+		PrefixV6:         intPtrToNull(s.PrefixV6),
+		CIDR:             parseNullString(s.CIDR),
+		CIDRV6:           parseNullString(s.CIDRV6),
+		Locked:           s.Locked,
+		DhcpEnabled:      s.DhcpEnabled,
+		DhcpRange:        parseNullString(s.DhcpRange),
+		DhcpReservations: parseNullString(s.DhcpReservations),
+		Gateway:          parseNullString(s.Gateway),
+		GatewayV6:        parseNullString(s.GatewayV6),
+		Tags:             parseNullString(s.Tags),
+		Notes:            parseNullString(s.Notes),
+		PoolTier:         parseNullString(s.PoolTier),
+		IPv6Mode:         parseNullString(s.IPv6Mode),
+		IPv6DNS:          parseNullString(s.IPv6DNS),
+		OwnerTeam:        parseNullString(s.OwnerTeam),
+		OwnerEmail:       parseNullString(s.OwnerEmail),
+		ExpiresAt:        parseNullString(s.ExpiresAt),
+		ExpiryAction:     parseNullString(s.ExpiryAction),
+		ExpiredAt:        parseNullString(s.ExpiredAt),
+		MultiPrefix:      s.MultiPrefix,
+		SecondaryCIDRs:   parseNullString(s.SecondaryCIDRs),
+		Kind:             normalizeSegmentKind(s.Kind),
+		LinkA:            parseNullString(s.LinkA),
+		LinkB:            parseNullString(s.LinkB),
+	}
+	if site, ok := sites[s.Site]; ok {
+		seg.SiteID = site.ID
+		seg.VLANDomain = nullString(site.VLANDomain)
+	}
+	return seg
+}
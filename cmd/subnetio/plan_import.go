@@ -5,54 +5,145 @@ package main
 import (
 	"bytes"
 	"database/sql"
-	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/netip"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"gopkg.in/yaml.v3"
 )
 
 func importPlanCSV(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
-	report := &ImportReport{}
-	state := newPlanImportState()
+	return importPlanUpload(c, db, activeProjectID, "csv")
+}
+
+func importPlanJSON(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
+	return importPlanUpload(c, db, activeProjectID, "json")
+}
+
+func isSupportedSchemaVersion(v string) bool {
+	return v == "1" || v == "2"
+}
+
+func importPlanYAML(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
+	return importPlanUpload(c, db, activeProjectID, "yaml")
+}
+
+func importPlanUpload(c *gin.Context, db *sql.DB, activeProjectID int64, format string) *ImportReport {
 	fileHeader, err := c.FormFile("file")
 	if err != nil {
-		report.Errors = append(report.Errors, "upload failed: "+err.Error())
-		return report
+		return &ImportReport{Errors: []string{"upload failed: " + err.Error()}}
 	}
 	file, err := fileHeader.Open()
 	if err != nil {
-		report.Errors = append(report.Errors, "open file: "+err.Error())
-		return report
+		return &ImportReport{Errors: []string{"open file: " + err.Error()}}
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	reader.TrimLeadingSpace = true
+	policy, err := importPolicyFromForm(c)
+	if err != nil {
+		return &ImportReport{Errors: []string{err.Error()}}
+	}
+	var report *ImportReport
+	if policy.Patch {
+		report = importPlanPatch(db, file, format, activeProjectID, policy)
+	} else {
+		report = importPlanStream(db, file, format, activeProjectID, policy)
+	}
+	report.FileName = fileHeader.Filename
+	return report
+}
+
+// importPlanData applies a plan file held in memory; see importPlanStream.
+func importPlanData(db sqlConn, raw []byte, format string, activeProjectID int64, policy ImportPolicy) *ImportReport {
+	return importPlanStream(db, bytes.NewReader(raw), format, activeProjectID, policy)
+}
+
+// importPlanStream applies a plan file in csv, json or yaml format. The file
+// is read row by row, so memory does not grow with its size. Rows are
+// applied one by one; a failing row is reported and the rest continue.
+// policy decides what happens to rows that differ from existing data.
+func importPlanStream(db sqlConn, r io.Reader, format string, activeProjectID int64, policy ImportPolicy) *ImportReport {
+	report := &ImportReport{Policy: policy.String()}
+	state := newPlanImportState()
+	state.policy = policy
+	if format == "csv" {
+		importPlanCSVRows(db, report, state, r, activeProjectID)
+		state.finalize(report)
+		return report
+	}
+
+	rows, err := newPlanRowReader(r, format)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	defer rows.close()
+	version, err := rows.header()
+	if err != nil {
+		report.Errors = append(report.Errors, "parse "+format+": "+err.Error())
+		return report
+	}
+	if version == "" {
+		report.Errors = append(report.Errors, "schema_version is required")
+		return report
+	}
+	if !isSupportedSchemaVersion(version) {
+		report.Errors = append(report.Errors, fmt.Sprintf("schema_version mismatch: %s", version))
+		return report
+	}
+	for rowIndex := 1; ; rowIndex++ {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr planRowError
+		if errors.As(err, &rowErr) {
+			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
+			continue
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, "parse "+format+": "+err.Error())
+			break
+		}
+		if err := applyPlanRow(db, report, state, row, rowIndex, activeProjectID, format); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
+		}
+	}
+	state.finalize(report)
+	return report
+}
+
+func importPlanCSVRows(db sqlConn, report *ImportReport, state *planImportState, r io.Reader, activeProjectID int64) {
+	reader, used, err := newCSVReader(r, state.policy.CSV)
+	if err != nil {
+		report.Errors = append(report.Errors, "read CSV: "+err.Error())
+		return
+	}
+	report.CSV = used.String()
+	reader.TrimLeadingSpace = used.Delimiter != '\t' // leading tabs are empty fields
 	reader.FieldsPerRecord = -1
 
 	first, err := reader.Read()
 	if err == io.EOF {
 		report.Errors = append(report.Errors, "empty CSV file")
-		return report
+		return
 	}
 	if err != nil {
 		report.Errors = append(report.Errors, "read CSV: "+err.Error())
-		return report
+		return
 	}
 	if !looksLikeHeader(first) {
 		report.Errors = append(report.Errors, "CSV header is required for strict schema")
-		return report
+		return
 	}
 	cols, err := mapPlanColumns(first)
 	if err != nil {
 		report.Errors = append(report.Errors, err.Error())
-		return report
+		return
 	}
 	state.setCSVColumns(cols)
 
@@ -76,94 +167,6 @@ func importPlanCSV(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportRep
 			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
 		}
 	}
-	state.finalize(report)
-	return report
-}
-
-func importPlanJSON(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
-	return importPlanBundle(c, db, activeProjectID, "json")
-}
-
-func isSupportedSchemaVersion(v string) bool {
-	return v == "1" || v == "2"
-}
-
-func importPlanYAML(c *gin.Context, db *sql.DB, activeProjectID int64) *ImportReport {
-	return importPlanBundle(c, db, activeProjectID, "yaml")
-}
-
-func importPlanBundle(c *gin.Context, db *sql.DB, activeProjectID int64, format string) *ImportReport {
-	report := &ImportReport{}
-	state := newPlanImportState()
-	fileHeader, err := c.FormFile("file")
-	if err != nil {
-		report.Errors = append(report.Errors, "upload failed: "+err.Error())
-		return report
-	}
-	file, err := fileHeader.Open()
-	if err != nil {
-		report.Errors = append(report.Errors, "open file: "+err.Error())
-		return report
-	}
-	defer file.Close()
-
-	raw, err := io.ReadAll(file)
-	if err != nil {
-		report.Errors = append(report.Errors, "read file: "+err.Error())
-		return report
-	}
-
-	var bundle PlanBundle
-	switch format {
-	case "json":
-		if err := decodePlanJSON(raw, &bundle); err != nil {
-			report.Errors = append(report.Errors, "parse json: "+err.Error())
-			return report
-		}
-	case "yaml":
-		if err := decodePlanYAML(raw, &bundle); err != nil {
-			report.Errors = append(report.Errors, "parse yaml: "+err.Error())
-			return report
-		}
-	default:
-		report.Errors = append(report.Errors, "unsupported format")
-		return report
-	}
-
-	if bundle.SchemaVersion == "" {
-		report.Errors = append(report.Errors, "schema_version is required")
-		return report
-	}
-	if !isSupportedSchemaVersion(bundle.SchemaVersion) {
-		report.Errors = append(report.Errors, fmt.Sprintf("schema_version mismatch: %s", bundle.SchemaVersion))
-		return report
-	}
-	for i, row := range bundle.Rows {
-		rowIndex := i + 1
-		if err := applyPlanRow(db, report, state, row, rowIndex, activeProjectID, format); err != nil {
-			report.Errors = append(report.Errors, fmt.Sprintf("row %d: %v", rowIndex, err))
-		}
-	}
-	state.finalize(report)
-	return report
-}
-
-func decodePlanJSON(raw []byte, bundle *PlanBundle) error {
-	dec := json.NewDecoder(bytes.NewReader(raw))
-	dec.DisallowUnknownFields()
-	return dec.Decode(bundle)
-}
-
-func decodePlanYAML(raw []byte, bundle *PlanBundle) error {
-	var anyData any
-	if err := yaml.Unmarshal(raw, &anyData); err != nil {
-		return err
-	}
-	asJSON, err := json.Marshal(anyData)
-	if err != nil {
-		return err
-	}
-	return decodePlanJSON(asJSON, bundle)
 }
 
 type planColumns struct {
@@ -177,6 +180,8 @@ type planColumns struct {
 	NTP                  int
 	GatewayPolicy        int
 	ReservedRanges       int
+	BGPASN               int
+	VLANDomain           int
 	Pool                 int
 	PoolFamily           int
 	PoolTier             int
@@ -189,12 +194,16 @@ type planColumns struct {
 	CIDR                 int
 	PrefixV6             int
 	CIDRV6               int
+	MultiPrefix          int
+	SecondaryCIDRs       int
 	Locked               int
 	DHCP                 int
 	DHCPRange            int
 	DHCPReservations     int
 	Gateway              int
 	GatewayV6            int
+	IPv6Mode             int
+	IPv6DNS              int
 	Tags                 int
 	Notes                int
 	DomainName           int
@@ -216,6 +225,19 @@ type planColumns struct {
 	OversizeThreshold    int
 	PoolStrategy         int
 	PoolTierFallback     int
+	IPv6Scheme           int
+	VLANRanges           int
+	DefaultVRF           int
+	SegmentCheck         int
+	PersistDerived       int
+	Uniqueness           int
+	PoolKind             int
+	PoolState            int
+	SegmentKind          int
+	LinkA                int
+	LinkB                int
+	ExportedAt           int
+	Op                   int
 }
 
 func mapPlanColumns(header []string) (planColumns, error) {
@@ -230,6 +252,8 @@ func mapPlanColumns(header []string) (planColumns, error) {
 		NTP:                  -1,
 		GatewayPolicy:        -1,
 		ReservedRanges:       -1,
+		BGPASN:               -1,
+		VLANDomain:           -1,
 		Pool:                 -1,
 		PoolFamily:           -1,
 		PoolTier:             -1,
@@ -242,12 +266,16 @@ func mapPlanColumns(header []string) (planColumns, error) {
 		CIDR:                 -1,
 		PrefixV6:             -1,
 		CIDRV6:               -1,
+		MultiPrefix:          -1,
+		SecondaryCIDRs:       -1,
 		Locked:               -1,
 		DHCP:                 -1,
 		DHCPRange:            -1,
 		DHCPReservations:     -1,
 		Gateway:              -1,
 		GatewayV6:            -1,
+		IPv6Mode:             -1,
+		IPv6DNS:              -1,
 		Tags:                 -1,
 		Notes:                -1,
 		DomainName:           -1,
@@ -269,6 +297,19 @@ func mapPlanColumns(header []string) (planColumns, error) {
 		OversizeThreshold:    -1,
 		PoolStrategy:         -1,
 		PoolTierFallback:     -1,
+		IPv6Scheme:           -1,
+		VLANRanges:           -1,
+		DefaultVRF:           -1,
+		SegmentCheck:         -1,
+		PersistDerived:       -1,
+		Uniqueness:           -1,
+		PoolKind:             -1,
+		PoolState:            -1,
+		SegmentKind:          -1,
+		LinkA:                -1,
+		LinkB:                -1,
+		ExportedAt:           -1,
+		Op:                   -1,
 	}
 	var unknown []string
 	for i, raw := range header {
@@ -294,6 +335,10 @@ func mapPlanColumns(header []string) (planColumns, error) {
 			cols.GatewayPolicy = i
 		case "reservedranges":
 			cols.ReservedRanges = i
+		case "bgpasn", "asn":
+			cols.BGPASN = i
+		case "vlandomain":
+			cols.VLANDomain = i
 		case "pool":
 			cols.Pool = i
 		case "poolfamily":
@@ -318,6 +363,10 @@ func mapPlanColumns(header []string) (planColumns, error) {
 			cols.PrefixV6 = i
 		case "cidrv6":
 			cols.CIDRV6 = i
+		case "multiprefix":
+			cols.MultiPrefix = i
+		case "secondarycidrs":
+			cols.SecondaryCIDRs = i
 		case "locked":
 			cols.Locked = i
 		case "dhcp":
@@ -330,6 +379,10 @@ func mapPlanColumns(header []string) (planColumns, error) {
 			cols.Gateway = i
 		case "gatewayv6":
 			cols.GatewayV6 = i
+		case "ipv6mode":
+			cols.IPv6Mode = i
+		case "ipv6dns":
+			cols.IPv6DNS = i
 		case "tags":
 			cols.Tags = i
 		case "notes":
@@ -372,6 +425,32 @@ func mapPlanColumns(header []string) (planColumns, error) {
 			cols.PoolStrategy = i
 		case "pooltierfallback":
 			cols.PoolTierFallback = i
+		case "ipv6scheme":
+			cols.IPv6Scheme = i
+		case "vlanranges":
+			cols.VLANRanges = i
+		case "defaultvrf":
+			cols.DefaultVRF = i
+		case "segmentcheck":
+			cols.SegmentCheck = i
+		case "persistderived":
+			cols.PersistDerived = i
+		case "uniqueness":
+			cols.Uniqueness = i
+		case "poolkind":
+			cols.PoolKind = i
+		case "poolstate":
+			cols.PoolState = i
+		case "segmentkind":
+			cols.SegmentKind = i
+		case "linka":
+			cols.LinkA = i
+		case "linkb":
+			cols.LinkB = i
+		case "exportedat":
+			cols.ExportedAt = i
+		case "op":
+			cols.Op = i
 		default:
 			if name != "" {
 				unknown = append(unknown, raw)
@@ -554,8 +633,16 @@ func planRowFromCSV(cols planColumns, row []string) (PlanRow, error) {
 	if err != nil {
 		return PlanRow{}, fmt.Errorf("pool_tier_fallback: %w", err)
 	}
+	multiPrefix, err := parseOptionalBool(get(cols.MultiPrefix))
+	if err != nil {
+		return PlanRow{}, fmt.Errorf("multi_prefix: %w", err)
+	}
+	persistDerived, err := parseOptionalBool(get(cols.PersistDerived))
+	if err != nil {
+		return PlanRow{}, fmt.Errorf("persist_derived: %w", err)
+	}
 
-	return PlanRow{
+	out := PlanRow{
 		RowType:              rowType,
 		UID:                  get(cols.UID),
 		Project:              get(cols.Project),
@@ -566,6 +653,8 @@ func planRowFromCSV(cols planColumns, row []string) (PlanRow, error) {
 		NTP:                  get(cols.NTP),
 		GatewayPolicy:        get(cols.GatewayPolicy),
 		ReservedRanges:       get(cols.ReservedRanges),
+		BGPASN:               get(cols.BGPASN),
+		VLANDomain:           get(cols.VLANDomain),
 		Pool:                 get(cols.Pool),
 		PoolFamily:           get(cols.PoolFamily),
 		PoolTier:             get(cols.PoolTier),
@@ -578,12 +667,16 @@ func planRowFromCSV(cols planColumns, row []string) (PlanRow, error) {
 		CIDR:                 get(cols.CIDR),
 		PrefixV6:             prefixV6,
 		CIDRV6:               get(cols.CIDRV6),
+		MultiPrefix:          multiPrefix,
+		SecondaryCIDRs:       get(cols.SecondaryCIDRs),
 		Locked:               locked,
 		DHCP:                 dhcp,
 		DHCPRange:            get(cols.DHCPRange),
 		DHCPReservations:     get(cols.DHCPReservations),
 		Gateway:              get(cols.Gateway),
 		GatewayV6:            get(cols.GatewayV6),
+		IPv6Mode:             get(cols.IPv6Mode),
+		IPv6DNS:              get(cols.IPv6DNS),
 		Tags:                 get(cols.Tags),
 		Notes:                get(cols.Notes),
 		DomainName:           get(cols.DomainName),
@@ -605,13 +698,66 @@ func planRowFromCSV(cols planColumns, row []string) (PlanRow, error) {
 		OversizeThreshold:    oversize,
 		PoolStrategy:         get(cols.PoolStrategy),
 		PoolTierFallback:     poolTierFallback,
-	}, nil
+		IPv6Scheme:           get(cols.IPv6Scheme),
+		VLANRanges:           get(cols.VLANRanges),
+		DefaultVRF:           get(cols.DefaultVRF),
+		SegmentCheck:         get(cols.SegmentCheck),
+		PersistDerived:       persistDerived,
+		Uniqueness:           get(cols.Uniqueness),
+		PoolKind:             get(cols.PoolKind),
+		PoolState:            get(cols.PoolState),
+		SegmentKind:          get(cols.SegmentKind),
+		LinkA:                get(cols.LinkA),
+		LinkB:                get(cols.LinkB),
+		ExportedAt:           get(cols.ExportedAt),
+		Op:                   get(cols.Op),
+	}
+	for key, idx := range map[string]int{
+		"dhcp":              cols.DHCP,
+		"dhcp_range":        cols.DHCPRange,
+		"dhcp_reservations": cols.DHCPReservations,
+		"gateway":           cols.Gateway,
+		"gateway_v6":        cols.GatewayV6,
+		"ipv6_mode":         cols.IPv6Mode,
+		"ipv6_dns":          cols.IPv6DNS,
+		"notes":             cols.Notes,
+		"tags":              cols.Tags,
+		"pool_tier":         cols.PoolTier,
+	} {
+		if idx >= 0 {
+			out.markMetaKey(key)
+		}
+	}
+	return out, nil
+}
+
+func applyPlanRow(db sqlConn, report *ImportReport, state *planImportState, row PlanRow, rowIndex int, activeProjectID int64, source string) error {
+	if state.policy.Patch {
+		return applyPlanPatchRow(db, report, state, row, rowIndex, activeProjectID, source)
+	}
+	if strings.TrimSpace(row.Op) != "" {
+		return fmt.Errorf("op is only allowed in patches")
+	}
+	return applyPlanRowFields(db, report, state, row, rowIndex, activeProjectID, source)
 }
 
-func applyPlanRow(db *sql.DB, report *ImportReport, state *planImportState, row PlanRow, rowIndex int, activeProjectID int64, source string) error {
+// applyPlanRowFields writes one row of a plan or patch.
+func applyPlanRowFields(db sqlConn, report *ImportReport, state *planImportState, row PlanRow, rowIndex int, activeProjectID int64, source string) error {
 	rowType := strings.TrimSpace(strings.ToLower(row.RowType))
 	switch rowType {
 	case planRowMeta, planRowRules, planRowSite, planRowPool, planRowSegment:
+	case planRowRulePreset:
+		if err := validateRulePresetRow(row); err != nil {
+			return err
+		}
+		if row.UID != "" && row.UID != stableID(planRowRulePreset, row.Name) {
+			return fmt.Errorf("uid mismatch (expected %s)", stableID(planRowRulePreset, row.Name))
+		}
+		if err := saveRulePreset(db, RulePreset{Name: row.Name, Description: row.Notes, Rules: rulesFromPlanRow(row)}); err != nil {
+			return err
+		}
+		report.PresetsSaved++
+		return nil
 	default:
 		return fmt.Errorf("invalid row_type: %s", row.RowType)
 	}
@@ -629,6 +775,9 @@ func applyPlanRow(db *sql.DB, report *ImportReport, state *planImportState, row
 	if row.UID != "" && expectedUID != "" && row.UID != expectedUID {
 		return fmt.Errorf("uid mismatch (expected %s)", expectedUID)
 	}
+	if row.UID == "" {
+		row.UID = expectedUID
+	}
 
 	switch rowType {
 	case planRowMeta:
@@ -648,6 +797,13 @@ func applyPlanRow(db *sql.DB, report *ImportReport, state *planImportState, row
 			return fmt.Errorf("duplicate meta row for project")
 		}
 		state.markMeta(projectName)
+		if row.ExportedAt != "" {
+			ts, err := time.Parse(time.RFC3339, strings.TrimSpace(row.ExportedAt))
+			if err != nil {
+				return fmt.Errorf("exported_at must be an RFC 3339 time: %s", row.ExportedAt)
+			}
+			state.exportedAt[projectID] = ts
+		}
 		return applyPlanMetaRow(db, projectID, row)
 	case planRowRules:
 		if err := validateRulesRow(row); err != nil {
@@ -662,32 +818,32 @@ func applyPlanRow(db *sql.DB, report *ImportReport, state *planImportState, row
 		if err := validateSiteRow(row); err != nil {
 			return err
 		}
-		return applyPlanSiteRow(db, report, projectID, row)
+		return applyPlanSiteRow(db, report, state, projectID, row, rowIndex)
 	case planRowPool:
 		if err := validatePoolRow(row); err != nil {
 			return err
 		}
-		return applyPlanPoolRow(db, report, projectID, row)
+		return applyPlanPoolRow(db, report, state, projectID, row, rowIndex)
 	case planRowSegment:
 		if err := validateSegmentRow(row); err != nil {
 			return err
 		}
-		return applyPlanSegmentRow(db, report, projectID, row, rowIndex, source)
+		return applyPlanSegmentRow(db, report, state, projectID, row, rowIndex, source)
 	}
 	return nil
 }
 
 func validateMetaRow(row PlanRow) error {
-	if row.Site != "" || row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" {
+	if row.Site != "" || row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" || row.BGPASN != "" || row.VLANDomain != "" {
 		return fmt.Errorf("meta row cannot include site fields")
 	}
-	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" {
+	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.PoolKind != "" || row.PoolState != "" || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" || row.SegmentKind != "" || row.LinkA != "" || row.LinkB != "" {
 		return fmt.Errorf("meta row cannot include segment fields")
 	}
 	if row.VLAN != nil || row.Hosts != nil || row.Prefix != nil || row.PrefixV6 != nil || row.Locked != nil || row.DHCP != nil {
 		return fmt.Errorf("meta row cannot include numeric/boolean segment fields")
 	}
-	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil {
+	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil || row.IPv6Scheme != "" || row.VLANRanges != "" || row.DefaultVRF != "" || row.SegmentCheck != "" || row.PersistDerived != nil || row.Uniqueness != "" {
 		return fmt.Errorf("meta row cannot include rules fields")
 	}
 	return nil
@@ -706,10 +862,22 @@ func validateRulesRow(row PlanRow) error {
 			return fmt.Errorf("invalid pool_strategy: %s", row.PoolStrategy)
 		}
 	}
-	if row.Site != "" || row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" {
+	if _, _, err := parseIPv6Scheme(row.IPv6Scheme); err != nil {
+		return err
+	}
+	if _, err := parseVLANRanges(row.VLANRanges); err != nil {
+		return err
+	}
+	if row.SegmentCheck != "" && normalizeSegmentCheck(row.SegmentCheck) != strings.ToLower(strings.TrimSpace(row.SegmentCheck)) {
+		return fmt.Errorf("invalid segment_check: %s", row.SegmentCheck)
+	}
+	if _, err := parseUniqueness(row.Uniqueness); err != nil {
+		return err
+	}
+	if row.Site != "" || row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" || row.BGPASN != "" || row.VLANDomain != "" {
 		return fmt.Errorf("rules row cannot include site fields")
 	}
-	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" {
+	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.PoolKind != "" || row.PoolState != "" || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" || row.SegmentKind != "" || row.LinkA != "" || row.LinkB != "" {
 		return fmt.Errorf("rules row cannot include segment fields")
 	}
 	if row.VLAN != nil || row.Hosts != nil || row.Prefix != nil || row.PrefixV6 != nil || row.Locked != nil || row.DHCP != nil {
@@ -721,11 +889,29 @@ func validateRulesRow(row PlanRow) error {
 	return nil
 }
 
+func validateRulePresetRow(row PlanRow) error {
+	name := strings.TrimSpace(row.Name)
+	if name == "" {
+		return fmt.Errorf("rule_preset row requires name")
+	}
+	if isReservedPresetName(name) {
+		return fmt.Errorf("preset name %s is reserved", name)
+	}
+	if strings.TrimSpace(row.Project) != "" {
+		return fmt.Errorf("rule_preset row cannot include project")
+	}
+	row.Name = ""
+	return validateRulesRow(row)
+}
+
 func validateSiteRow(row PlanRow) error {
 	if strings.TrimSpace(row.Site) == "" {
 		return fmt.Errorf("site is required")
 	}
-	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" {
+	if _, err := parseASN(row.BGPASN); err != nil {
+		return err
+	}
+	if row.Pool != "" || row.PoolFamily != "" || row.PoolTier != "" || row.PoolPriority != nil || row.PoolKind != "" || row.PoolState != "" || row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" || row.SegmentKind != "" || row.LinkA != "" || row.LinkB != "" {
 		return fmt.Errorf("site row cannot include segment fields")
 	}
 	if row.VLAN != nil || row.Hosts != nil || row.Prefix != nil || row.PrefixV6 != nil || row.Locked != nil || row.DHCP != nil {
@@ -734,7 +920,7 @@ func validateSiteRow(row PlanRow) error {
 	if row.DomainName != "" || row.ProjectDNS != "" || row.ProjectNTP != "" || row.ProjectGatewayPolicy != "" || row.DHCPSearch != "" || row.DHCPLeaseTime != nil || row.DHCPRenewTime != nil || row.DHCPRebindTime != nil || row.DHCPBootFile != "" || row.DHCPNextServer != "" || row.DHCPVendorOptions != "" || row.GrowthRate != nil || row.GrowthMonths != nil {
 		return fmt.Errorf("site row cannot include meta fields")
 	}
-	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil {
+	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil || row.IPv6Scheme != "" || row.VLANRanges != "" || row.DefaultVRF != "" || row.SegmentCheck != "" || row.PersistDerived != nil || row.Uniqueness != "" {
 		return fmt.Errorf("site row cannot include rules fields")
 	}
 	return nil
@@ -753,7 +939,13 @@ func validatePoolRow(row PlanRow) error {
 			return fmt.Errorf("invalid pool_family: %s", row.PoolFamily)
 		}
 	}
-	if row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" {
+	if _, err := parsePoolKind(row.PoolKind); err != nil {
+		return fmt.Errorf("pool_kind: %w", err)
+	}
+	if _, err := parsePoolState(row.PoolState); err != nil {
+		return fmt.Errorf("pool_state: %w", err)
+	}
+	if row.VRF != "" || row.Name != "" || row.CIDR != "" || row.CIDRV6 != "" || row.SegmentKind != "" || row.LinkA != "" || row.LinkB != "" {
 		return fmt.Errorf("pool row cannot include segment fields")
 	}
 	if row.VLAN != nil || row.Hosts != nil || row.Prefix != nil || row.PrefixV6 != nil || row.Locked != nil || row.DHCP != nil {
@@ -762,7 +954,7 @@ func validatePoolRow(row PlanRow) error {
 	if row.DomainName != "" || row.ProjectDNS != "" || row.ProjectNTP != "" || row.ProjectGatewayPolicy != "" || row.DHCPSearch != "" || row.DHCPLeaseTime != nil || row.DHCPRenewTime != nil || row.DHCPRebindTime != nil || row.DHCPBootFile != "" || row.DHCPNextServer != "" || row.DHCPVendorOptions != "" || row.GrowthRate != nil || row.GrowthMonths != nil {
 		return fmt.Errorf("pool row cannot include meta fields")
 	}
-	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil {
+	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil || row.IPv6Scheme != "" || row.VLANRanges != "" || row.DefaultVRF != "" || row.SegmentCheck != "" || row.PersistDerived != nil || row.Uniqueness != "" {
 		return fmt.Errorf("pool row cannot include rules fields")
 	}
 	return nil
@@ -787,21 +979,42 @@ func validateSegmentRow(row PlanRow) error {
 	if row.DomainName != "" || row.ProjectDNS != "" || row.ProjectNTP != "" || row.ProjectGatewayPolicy != "" || row.DHCPSearch != "" || row.DHCPLeaseTime != nil || row.DHCPRenewTime != nil || row.DHCPRebindTime != nil || row.DHCPBootFile != "" || row.DHCPNextServer != "" || row.DHCPVendorOptions != "" || row.GrowthRate != nil || row.GrowthMonths != nil {
 		return fmt.Errorf("segment row cannot include meta fields")
 	}
-	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil {
+	if row.VLANScope != "" || row.RequireInPool != nil || row.AllowReservedOverlap != nil || row.OversizeThreshold != nil || row.PoolStrategy != "" || row.PoolTierFallback != nil || row.IPv6Scheme != "" || row.VLANRanges != "" || row.DefaultVRF != "" || row.SegmentCheck != "" || row.PersistDerived != nil || row.Uniqueness != "" {
 		return fmt.Errorf("segment row cannot include rules fields")
 	}
-	if row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" {
+	if row.Region != "" || row.DNS != "" || row.NTP != "" || row.GatewayPolicy != "" || row.ReservedRanges != "" || row.BGPASN != "" || row.VLANDomain != "" {
 		return fmt.Errorf("segment row cannot include site fields")
 	}
 	if row.Pool != "" {
 		return fmt.Errorf("segment row cannot include pool")
 	}
-	if row.PoolFamily != "" || row.PoolPriority != nil {
-		return fmt.Errorf("segment row cannot include pool family/priority")
+	if row.PoolFamily != "" || row.PoolPriority != nil || row.PoolKind != "" || row.PoolState != "" {
+		return fmt.Errorf("segment row cannot include pool family/priority/kind/state")
 	}
-	if row.DHCP == nil && (row.DHCPRange != "" || row.DHCPReservations != "" || row.Gateway != "" || row.GatewayV6 != "" || row.Tags != "" || row.Notes != "" || row.PoolTier != "") {
+	kind, err := parseSegmentKind(row.SegmentKind)
+	if err != nil {
+		return fmt.Errorf("segment_kind: %w", err)
+	}
+	if kind != SegmentKindLink && (row.LinkA != "" || row.LinkB != "") {
+		return fmt.Errorf("link_a and link_b need segment_kind link")
+	}
+	if kind == SegmentKindLink {
+		if _, _, err := linkSizes(intPtrToNull(row.Hosts), intPtrToNull(row.Prefix), intPtrToNull(row.PrefixV6)); err != nil {
+			return err
+		}
+		if boolValue(row.MultiPrefix) {
+			return fmt.Errorf("link segments cannot have multiple prefixes")
+		}
+	}
+	if row.DHCP == nil && (row.DHCPRange != "" || row.DHCPReservations != "" || row.Gateway != "" || row.GatewayV6 != "" || row.IPv6Mode != "" || row.IPv6DNS != "" || row.Tags != "" || row.Notes != "" || row.PoolTier != "") {
 		return fmt.Errorf("dhcp flag required when segment meta fields are provided")
 	}
+	if _, err := normalizeIPv6Mode(row.IPv6Mode); err != nil {
+		return err
+	}
+	if _, err := parseIPv6DNS(row.IPv6DNS); err != nil {
+		return fmt.Errorf("ipv6_dns: %w", err)
+	}
 	if row.CIDR != "" {
 		if _, err := netip.ParsePrefix(row.CIDR); err != nil {
 			return fmt.Errorf("invalid cidr: %s", row.CIDR)
@@ -812,6 +1025,11 @@ func validateSegmentRow(row PlanRow) error {
 			return fmt.Errorf("invalid cidr_v6: %s", row.CIDRV6)
 		}
 	}
+	if row.SecondaryCIDRs != "" {
+		if _, err := parseSecondaryCIDRs(row.SecondaryCIDRs); err != nil {
+			return err
+		}
+	}
 	if row.Prefix != nil {
 		if *row.Prefix < 1 || *row.Prefix > 32 {
 			return fmt.Errorf("invalid prefix: %d", *row.Prefix)
@@ -825,7 +1043,7 @@ func validateSegmentRow(row PlanRow) error {
 	return nil
 }
 
-func applyPlanMetaRow(db *sql.DB, projectID int64, row PlanRow) error {
+func applyPlanMetaRow(db sqlConn, projectID int64, row PlanRow) error {
 	meta := ProjectMeta{
 		ProjectID:      projectID,
 		DomainName:     parseNullString(row.DomainName),
@@ -845,7 +1063,11 @@ func applyPlanMetaRow(db *sql.DB, projectID int64, row PlanRow) error {
 	return saveProjectMeta(db, meta)
 }
 
-func applyPlanRulesRow(db *sql.DB, projectID int64, row PlanRow) error {
+func applyPlanRulesRow(db sqlConn, projectID int64, row PlanRow) error {
+	return saveProjectRules(db, projectID, rulesFromPlanRow(row))
+}
+
+func rulesFromPlanRow(row PlanRow) ProjectRules {
 	strategy := strings.ToLower(strings.TrimSpace(row.PoolStrategy))
 	if strategy == "" {
 		strategy = PoolStrategySpillover
@@ -854,108 +1076,279 @@ func applyPlanRulesRow(db *sql.DB, projectID int64, row PlanRow) error {
 	if row.PoolTierFallback != nil {
 		fallback = boolValue(row.PoolTierFallback)
 	}
-	rules := ProjectRules{
+	return ProjectRules{
 		VLANScope:            strings.TrimSpace(row.VLANScope),
 		RequireInPool:        boolValue(row.RequireInPool),
 		AllowReservedOverlap: boolValue(row.AllowReservedOverlap),
 		OversizeThreshold:    intValue(row.OversizeThreshold),
 		PoolStrategy:         strategy,
 		PoolTierFallback:     fallback,
+		IPv6Scheme:           strings.TrimSpace(row.IPv6Scheme),
+		VLANRanges:           strings.TrimSpace(row.VLANRanges),
+		DefaultVRF:           strings.TrimSpace(row.DefaultVRF),
+		SegmentCheck:         strings.TrimSpace(row.SegmentCheck),
+		PersistDerived:       boolValue(row.PersistDerived),
+		Uniqueness:           strings.TrimSpace(row.Uniqueness),
 	}
-	return saveProjectRules(db, projectID, rules)
 }
 
-func applyPlanSiteRow(db *sql.DB, report *ImportReport, projectID int64, row PlanRow) error {
-	siteID, created, err := getOrCreateSiteID(db, row.Site)
+func applyPlanSiteRow(db sqlConn, report *ImportReport, state *planImportState, projectID int64, row PlanRow, rowIndex int) error {
+	siteID, created, err := getOrCreateProjectSiteID(db, projectID, row.Site)
 	if err != nil {
 		return fmt.Errorf("site error: %v", err)
 	}
 	if created {
 		report.SitesAdded++
 	}
+	asn, err := parseASN(row.BGPASN)
+	if err != nil {
+		return err
+	}
+	if !created {
+		diff, err := storedDiff(db, `
+			SELECT ps.project_id, m.region, m.dns, m.ntp, m.gateway_policy, m.reserved_ranges, m.bgp_asn, m.vlan_domain
+			FROM sites s
+			LEFT JOIN project_sites ps ON ps.site_id = s.id
+			LEFT JOIN site_meta m ON m.site_id = s.id
+			WHERE s.id=?`, []any{siteID},
+			[]string{"project", "region", "dns", "ntp", "gateway_policy", "reserved_ranges", "bgp_asn", "vlan_domain"},
+			[]any{projectID, nullStringToAny(row.Region), nullStringToAny(row.DNS), nullStringToAny(row.NTP),
+				nullStringToAny(row.GatewayPolicy), nullStringToAny(row.ReservedRanges), nullIntToAny(asn),
+				nullStringToAny(normalizeVLANDomain(row.VLANDomain))})
+		if err != nil {
+			return fmt.Errorf("site lookup error: %v", err)
+		}
+		if apply, err := resolveImportConflict(report, state, rowIndex, planRowSite, row.Site, diff); !apply {
+			return err
+		}
+		if len(diff) > 0 {
+			report.touch(planRowSite, siteID, row.UID, provenanceUpdated)
+		}
+	} else {
+		report.touch(planRowSite, siteID, row.UID, provenanceCreated)
+	}
 	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?) ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, projectID, siteID)
 	_, err = db.Exec(`
-		INSERT INTO site_meta(site_id, region, dns, ntp, gateway_policy, reserved_ranges)
-		VALUES(?, ?, ?, ?, ?, ?)
+		INSERT INTO site_meta(site_id, region, dns, ntp, gateway_policy, reserved_ranges, bgp_asn, vlan_domain)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(site_id) DO UPDATE SET
 			region=excluded.region,
 			dns=excluded.dns,
 			ntp=excluded.ntp,
 			gateway_policy=excluded.gateway_policy,
-			reserved_ranges=excluded.reserved_ranges`,
+			reserved_ranges=excluded.reserved_ranges,
+			bgp_asn=excluded.bgp_asn,
+			vlan_domain=excluded.vlan_domain`,
 		siteID,
 		nullStringToAny(row.Region),
 		nullStringToAny(row.DNS),
 		nullStringToAny(row.NTP),
 		nullStringToAny(row.GatewayPolicy),
 		nullStringToAny(row.ReservedRanges),
+		nullIntToAny(asn),
+		nullStringToAny(normalizeVLANDomain(row.VLANDomain)),
 	)
 	return err
 }
 
-func applyPlanPoolRow(db *sql.DB, report *ImportReport, projectID int64, row PlanRow) error {
-	siteID, created, err := getOrCreateSiteID(db, row.Site)
+func applyPlanPoolRow(db sqlConn, report *ImportReport, state *planImportState, projectID int64, row PlanRow, rowIndex int) error {
+	siteID, created, err := getOrCreateProjectSiteID(db, projectID, row.Site)
 	if err != nil {
 		return fmt.Errorf("site error: %v", err)
 	}
 	if created {
 		report.SitesAdded++
+		report.touch(planRowSite, siteID, "", provenanceCreated)
 	}
-	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?) ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, projectID, siteID)
 	if _, err := netip.ParsePrefix(row.Pool); err != nil {
 		return fmt.Errorf("invalid pool: %s", row.Pool)
 	}
-	if !poolExists(db, siteID, row.Pool) {
+	var poolID int64
+	kind := normalizePoolKind(row.PoolKind)
+	poolState := normalizePoolState(row.PoolState)
+	exists := db.QueryRow(`SELECT id FROM pools WHERE site_id=? AND cidr=?`, siteID, row.Pool).Scan(&poolID) == nil
+	if exists {
+		diff, err := storedDiff(db, `SELECT family, tier, priority, kind, state FROM pools WHERE site_id=? AND cidr=?`, []any{siteID, row.Pool},
+			[]string{"pool_family", "pool_tier", "pool_priority", "pool_kind", "pool_state"},
+			[]any{normalizePoolFamily(row.PoolFamily), nullStringToAny(row.PoolTier), intValue(row.PoolPriority), kind, poolState})
+		if err != nil {
+			return fmt.Errorf("pool lookup error: %v", err)
+		}
+		if apply, err := resolveImportConflict(report, state, rowIndex, planRowPool, row.Site+" "+row.Pool, diff); !apply {
+			return err
+		}
+		if len(diff) > 0 {
+			report.touch(planRowPool, poolID, row.UID, provenanceUpdated)
+		}
+	}
+	if !exists {
+		rules, err := getProjectRules(db, projectID)
+		if err != nil {
+			return fmt.Errorf("rules error: %v", err)
+		}
+		if err := checkPoolCIDRUnique(db, rules, projectID, row.Pool, 0); err != nil {
+			return err
+		}
+	}
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?) ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, projectID, siteID)
+	if !exists {
 		family := normalizePoolFamily(row.PoolFamily)
 		priority := intValue(row.PoolPriority)
-		_, err := db.Exec(`INSERT INTO pools(site_id, cidr, family, tier, priority) VALUES(?, ?, ?, ?, ?)`,
-			siteID, row.Pool, family, nullStringToAny(row.PoolTier), priority)
+		res, err := db.Exec(`INSERT INTO pools(site_id, cidr, family, tier, priority, kind, state) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+			siteID, row.Pool, family, nullStringToAny(row.PoolTier), priority, kind, poolState)
 		if err != nil {
 			return fmt.Errorf("insert pool: %v", err)
 		}
 		report.PoolsAdded++
+		poolID, _ = res.LastInsertId()
+		report.touch(planRowPool, poolID, row.UID, provenanceCreated)
 	} else {
 		family := normalizePoolFamily(row.PoolFamily)
 		priority := intValue(row.PoolPriority)
-		_, _ = db.Exec(`UPDATE pools SET family=?, tier=?, priority=? WHERE site_id=? AND cidr=?`,
-			family, nullStringToAny(row.PoolTier), priority, siteID, row.Pool)
+		_, _ = db.Exec(`UPDATE pools SET family=?, tier=?, priority=?, kind=?, state=? WHERE site_id=? AND cidr=?`,
+			family, nullStringToAny(row.PoolTier), priority, kind, poolState, siteID, row.Pool)
 	}
 	return nil
 }
 
-func applyPlanSegmentRow(db *sql.DB, report *ImportReport, projectID int64, row PlanRow, rowIndex int, source string) error {
-	siteID, created, err := getOrCreateSiteID(db, row.Site)
+func applyPlanSegmentRow(db sqlConn, report *ImportReport, state *planImportState, projectID int64, row PlanRow, rowIndex int, source string) error {
+	deletedAt, deleted, err := state.deletedSinceExport(db, projectID, row)
+	if err != nil {
+		return fmt.Errorf("tombstone lookup error: %v", err)
+	}
+	if deleted {
+		report.Skipped++
+		report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: segment %s/%s/%d/%s was deleted at %s, after the export, skipped",
+			rowIndex, row.Site, row.VRF, intValue(row.VLAN), row.Name, deletedAt.Format(time.RFC3339)))
+		return nil
+	}
+	siteID, created, err := getOrCreateProjectSiteID(db, projectID, row.Site)
 	if err != nil {
 		return fmt.Errorf("site error: %v", err)
 	}
 	if created {
 		report.SitesAdded++
+		report.touch(planRowSite, siteID, "", provenanceCreated)
 	}
-	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?) ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, projectID, siteID)
 
 	segID, exists, err := findSegmentID(db, siteID, row.VRF, intValue(row.VLAN), row.Name)
 	if err != nil {
 		return fmt.Errorf("segment lookup error: %v", err)
 	}
+	rules, err := getProjectRules(db, projectID)
+	if err != nil {
+		return fmt.Errorf("rules error: %v", err)
+	}
+	known, err := state.knownVRFs(db, projectID)
+	if err != nil {
+		return fmt.Errorf("vrf lookup error: %v", err)
+	}
+	warnings, err := checkSegmentRules(rules, row.VRF, intValue(row.VLAN), known)
+	if err != nil {
+		return err
+	}
+	if err := checkPoolTierReference(db, projectID, row.PoolTier); err != nil {
+		return err
+	}
+	if !exists {
+		if err := checkSegmentNameUnique(db, rules, projectID, row.VRF, row.Name, 0); err != nil {
+			return err
+		}
+	}
+	for _, w := range warnings {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: %s", rowIndex, w))
+	}
 	hosts := intPtrToNull(row.Hosts)
 	prefix := intPtrToNull(row.Prefix)
 	prefixV6 := intPtrToNull(row.PrefixV6)
+	kind := normalizeSegmentKind(row.SegmentKind)
+	if kind == SegmentKindLink {
+		if prefix, prefixV6, err = linkSizes(hosts, prefix, prefixV6); err != nil {
+			return err
+		}
+	}
+	linkA := strings.TrimSpace(row.LinkA)
+	linkB := strings.TrimSpace(row.LinkB)
 	cidr := strings.TrimSpace(row.CIDR)
 	cidrV6 := strings.TrimSpace(row.CIDRV6)
+	secondary := strings.TrimSpace(row.SecondaryCIDRs)
+	patch := row.segmentMetaPatch()
+
+	if exists {
+		names := []string{"hosts", "prefix", "prefix_v6", "cidr", "cidr_v6", "multi_prefix", "secondary_cidrs", "locked", "segment_kind", "link_a", "link_b"}
+		selects := []string{"s.hosts", "s.prefix", "s.prefix_v6", "s.cidr", "s.cidr_v6", "s.multi_prefix", "s.secondary_cidrs", "s.locked", "s.kind", "s.link_a", "s.link_b"}
+		want := []any{nullIntToAny(hosts), nullIntToAny(prefix), nullIntToAny(prefixV6), nullStringToAny(cidr), nullStringToAny(cidrV6),
+			boolToInt(boolValue(row.MultiPrefix)), nullStringToAny(secondary), boolToInt(boolValue(row.Locked)),
+			kind, nullStringToAny(linkA), nullStringToAny(linkB)}
+		metaCols, metaVals := patch.columns()
+		for i, col := range metaCols {
+			names = append(names, col)
+			if col == "dhcp_enabled" {
+				selects = append(selects, "COALESCE(m.dhcp_enabled, 0)")
+			} else {
+				selects = append(selects, "m."+col)
+			}
+			want = append(want, metaVals[i])
+		}
+		diff, err := storedDiff(db, `
+			SELECT `+strings.Join(selects, ", ")+`
+			FROM segments s
+			LEFT JOIN segment_meta m ON m.segment_id = s.id
+			WHERE s.id=?`, []any{segID}, names, want)
+		if err != nil {
+			return fmt.Errorf("segment lookup error: %v", err)
+		}
+		key := fmt.Sprintf("%s/%s/%d/%s", row.Site, row.VRF, intValue(row.VLAN), row.Name)
+		var locked bool
+		if err := db.QueryRow(`SELECT locked FROM segments WHERE id=?`, segID).Scan(&locked); err != nil {
+			return fmt.Errorf("segment lookup error: %v", err)
+		}
+		if fields := lockedFields(diff); locked && len(fields) > 0 {
+			if !state.policy.ForceLocked {
+				return segmentLockError{Name: key, Fields: fields}
+			}
+			report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: locked segment %s changed (%s), forced", rowIndex, key, strings.Join(fields, ", ")))
+		}
+		if apply, err := resolveImportConflict(report, state, rowIndex, planRowSegment, key, diff); !apply {
+			return err
+		}
+		if len(diff) > 0 {
+			report.touch(planRowSegment, segID, row.UID, provenanceUpdated)
+		}
+	}
+	tracker, err := state.quotaTracker(db, projectID)
+	if err != nil {
+		return fmt.Errorf("quota error: %v", err)
+	}
+	if tracker != nil {
+		var old [3]string
+		if exists {
+			if err := db.QueryRow(`SELECT COALESCE(cidr, ''), COALESCE(cidr_v6, ''), COALESCE(secondary_cidrs, '') FROM segments WHERE id=?`, segID).Scan(&old[0], &old[1], &old[2]); err != nil {
+				return fmt.Errorf("segment lookup error: %v", err)
+			}
+		}
+		if err := tracker.apply(!exists, old, [3]string{cidr, cidrV6, secondary}); err != nil {
+			return err
+		}
+	}
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?) ON CONFLICT(site_id) DO UPDATE SET project_id=excluded.project_id`, projectID, siteID)
 
 	if !exists {
 		res, err := db.Exec(`
-			INSERT INTO segments(site_id, vrf, vlan, name, hosts, prefix, prefix_v6, locked, cidr, cidr_v6)
-			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			INSERT INTO segments(site_id, vrf, vlan, name, hosts, prefix, prefix_v6, locked, cidr, cidr_v6, multi_prefix, secondary_cidrs, kind, link_a, link_b)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 			siteID, row.VRF, intValue(row.VLAN), row.Name,
 			nullIntToAny(hosts), nullIntToAny(prefix), nullIntToAny(prefixV6),
 			boolToInt(boolValue(row.Locked)), nullStringToAny(cidr), nullStringToAny(cidrV6),
+			boolToInt(boolValue(row.MultiPrefix)), nullStringToAny(secondary),
+			kind, nullStringToAny(linkA), nullStringToAny(linkB),
 		)
 		if err != nil {
 			return fmt.Errorf("insert segment failed: %v", err)
 		}
 		segID, _ = res.LastInsertId()
 		report.SegmentsAdded++
+		report.touch(planRowSegment, segID, row.UID, provenanceCreated)
 	} else {
 		_, err := db.Exec(`
 			UPDATE segments SET
@@ -964,14 +1357,24 @@ func applyPlanSegmentRow(db *sql.DB, report *ImportReport, projectID int64, row
 				prefix_v6=?,
 				cidr=?,
 				cidr_v6=?,
-				locked=?
+				multi_prefix=?,
+				secondary_cidrs=?,
+				locked=?,
+				kind=?,
+				link_a=?,
+				link_b=?
 			WHERE id=?`,
 			nullIntToAny(hosts),
 			nullIntToAny(prefix),
 			nullIntToAny(prefixV6),
 			nullStringToAny(cidr),
 			nullStringToAny(cidrV6),
+			boolToInt(boolValue(row.MultiPrefix)),
+			nullStringToAny(secondary),
 			boolToInt(boolValue(row.Locked)),
+			kind,
+			nullStringToAny(linkA),
+			nullStringToAny(linkB),
 			segID,
 		)
 		if err != nil {
@@ -979,40 +1382,44 @@ func applyPlanSegmentRow(db *sql.DB, report *ImportReport, projectID int64, row
 		}
 	}
 
-	metaProvided := row.DHCP != nil || row.DHCPRange != "" || row.DHCPReservations != "" || row.Gateway != "" || row.GatewayV6 != "" || row.Tags != "" || row.Notes != "" || row.PoolTier != ""
-	if metaProvided {
-		_, err := db.Exec(`
-			INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, dhcp_reservations, gateway, gateway_v6, notes, tags, pool_tier)
-			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)
-			ON CONFLICT(segment_id) DO UPDATE SET
-				dhcp_enabled=excluded.dhcp_enabled,
-				dhcp_range=excluded.dhcp_range,
-				dhcp_reservations=excluded.dhcp_reservations,
-				gateway=excluded.gateway,
-				gateway_v6=excluded.gateway_v6,
-				notes=excluded.notes,
-				tags=excluded.tags,
-				pool_tier=excluded.pool_tier`,
-			segID,
-			boolToInt(boolValue(row.DHCP)),
-			nullStringToAny(strings.TrimSpace(row.DHCPRange)),
-			nullStringToAny(strings.TrimSpace(row.DHCPReservations)),
-			nullStringToAny(strings.TrimSpace(row.Gateway)),
-			nullStringToAny(strings.TrimSpace(row.GatewayV6)),
-			nullStringToAny(strings.TrimSpace(row.Notes)),
-			nullStringToAny(strings.TrimSpace(row.Tags)),
-			nullStringToAny(strings.TrimSpace(row.PoolTier)),
-		)
-		if err != nil {
-			return fmt.Errorf("segment meta failed: %v", err)
-		}
-	} else {
-		_, _ = db.Exec(`DELETE FROM segment_meta WHERE segment_id=?`, segID)
+	if err := applySegmentMetaPatch(db, segID, patch); err != nil {
+		return fmt.Errorf("segment meta failed: %v", err)
 	}
 	return nil
 }
 
-func resolveProjectID(db *sql.DB, name string, activeProjectID int64) (int64, string, bool, error) {
+func (row *PlanRow) markMetaKey(key string) {
+	if row.metaKeys == nil {
+		row.metaKeys = map[string]bool{}
+	}
+	row.metaKeys[key] = true
+}
+
+// segmentMetaPatch turns the row's meta fields into a patch. A field counts
+// when it has a value or its key is present in the source file; rows built
+// in code (legacy and phpIPAM imports) never clear anything.
+func (row PlanRow) segmentMetaPatch() segmentMetaPatch {
+	field := func(key, value string) optionalField[string] {
+		return optionalString(value, strings.TrimSpace(value) != "" || row.metaKeys[key])
+	}
+	p := segmentMetaPatch{
+		DhcpRange:        field("dhcp_range", row.DHCPRange),
+		DhcpReservations: field("dhcp_reservations", row.DHCPReservations),
+		Gateway:          field("gateway", row.Gateway),
+		GatewayV6:        field("gateway_v6", row.GatewayV6),
+		IPv6Mode:         ipv6ModeField(field("ipv6_mode", row.IPv6Mode)),
+		IPv6DNS:          field("ipv6_dns", row.IPv6DNS),
+		Notes:            field("notes", row.Notes),
+		Tags:             field("tags", row.Tags),
+		PoolTier:         field("pool_tier", row.PoolTier),
+	}
+	if row.DHCP != nil || row.metaKeys["dhcp"] {
+		p.DhcpEnabled = optionalField[bool]{Set: true, Value: row.DHCP}
+	}
+	return p
+}
+
+func resolveProjectID(db sqlConn, name string, activeProjectID int64) (int64, string, bool, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		if activeProjectID <= 0 {
@@ -1143,14 +1550,62 @@ type planImportState struct {
 	meta     map[string]bool
 	rules    map[string]bool
 	csvCols  *planColumns
+	policy   ImportPolicy
+	vrfs     map[int64]map[string]bool
+	quotas   map[int64]*quotaTracker
+	// exportedAt holds the export time of each project's meta row.
+	exportedAt map[int64]time.Time
+	// uids indexes each project's stored rows by UID while a patch applies.
+	uids map[int64]map[string]PlanRow
 }
 
 func newPlanImportState() *planImportState {
 	return &planImportState{
-		projects: map[string]bool{},
-		meta:     map[string]bool{},
-		rules:    map[string]bool{},
+		projects:   map[string]bool{},
+		meta:       map[string]bool{},
+		rules:      map[string]bool{},
+		exportedAt: map[int64]time.Time{},
+	}
+}
+
+// knownVRFs returns the project's VRFs as they were before the import wrote
+// its first segment, so a file may bring several VRFs into an empty project.
+func (s *planImportState) knownVRFs(db sqlConn, projectID int64) (map[string]bool, error) {
+	if known, ok := s.vrfs[projectID]; ok {
+		return known, nil
+	}
+	known, err := projectVRFs(db, projectID, 0)
+	if err != nil {
+		return nil, err
 	}
+	if s.vrfs == nil {
+		s.vrfs = map[int64]map[string]bool{}
+	}
+	s.vrfs[projectID] = known
+	return known, nil
+}
+
+// quotaTracker returns the project's usage as of its first segment row, or
+// nil when the project has no quota.
+func (s *planImportState) quotaTracker(db sqlConn, projectID int64) (*quotaTracker, error) {
+	if t, ok := s.quotas[projectID]; ok {
+		return t, nil
+	}
+	if s.quotas == nil {
+		s.quotas = map[int64]*quotaTracker{}
+	}
+	q, ok := getProjectQuota(db, projectID)
+	if !ok {
+		s.quotas[projectID] = nil
+		return nil, nil
+	}
+	usage, err := projectQuotaUsage(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	t := &quotaTracker{quota: q, usage: usage}
+	s.quotas[projectID] = t
+	return t, nil
 }
 
 func (s *planImportState) setCSVColumns(cols planColumns) {
@@ -1185,6 +1640,9 @@ func (s *planImportState) markRules(name string) {
 }
 
 func (s *planImportState) finalize(report *ImportReport) {
+	if s.policy.Patch {
+		return // patches carry only the rows that changed
+	}
 	for project := range s.projects {
 		if !s.meta[project] {
 			report.Errors = append(report.Errors, fmt.Sprintf("project %s: meta row missing", project))
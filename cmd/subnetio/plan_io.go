@@ -8,10 +8,12 @@ import (
 	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gopkg.in/yaml.v3"
@@ -25,6 +27,8 @@ const (
 	planRowSite    = "site"
 	planRowPool    = "pool"
 	planRowSegment = "segment"
+
+	planRowRulePreset = "rule_preset"
 )
 
 type PlanBundle struct {
@@ -34,9 +38,11 @@ type PlanBundle struct {
 
 type PlanRow struct {
 	RowType       string `json:"row_type" yaml:"row_type"`
+	Op            string `json:"op,omitempty" yaml:"op,omitempty"`
 	UID           string `json:"uid,omitempty" yaml:"uid,omitempty"`
 	Project       string `json:"project,omitempty" yaml:"project,omitempty"`
 	SchemaVersion string `json:"schema_version,omitempty" yaml:"schema_version,omitempty"`
+	ExportedAt    string `json:"exported_at,omitempty" yaml:"exported_at,omitempty"`
 
 	Site           string `json:"site,omitempty" yaml:"site,omitempty"`
 	Region         string `json:"region,omitempty" yaml:"region,omitempty"`
@@ -44,10 +50,14 @@ type PlanRow struct {
 	NTP            string `json:"ntp,omitempty" yaml:"ntp,omitempty"`
 	GatewayPolicy  string `json:"gateway_policy,omitempty" yaml:"gateway_policy,omitempty"`
 	ReservedRanges string `json:"reserved_ranges,omitempty" yaml:"reserved_ranges,omitempty"`
+	BGPASN         string `json:"bgp_asn,omitempty" yaml:"bgp_asn,omitempty"`
+	VLANDomain     string `json:"vlan_domain,omitempty" yaml:"vlan_domain,omitempty"`
 	Pool           string `json:"pool,omitempty" yaml:"pool,omitempty"`
 	PoolFamily     string `json:"pool_family,omitempty" yaml:"pool_family,omitempty"`
 	PoolTier       string `json:"pool_tier,omitempty" yaml:"pool_tier,omitempty"`
 	PoolPriority   *int   `json:"pool_priority,omitempty" yaml:"pool_priority,omitempty"`
+	PoolKind       string `json:"pool_kind,omitempty" yaml:"pool_kind,omitempty"`
+	PoolState      string `json:"pool_state,omitempty" yaml:"pool_state,omitempty"`
 
 	VRF              string `json:"vrf,omitempty" yaml:"vrf,omitempty"`
 	VLAN             *int   `json:"vlan,omitempty" yaml:"vlan,omitempty"`
@@ -57,12 +67,19 @@ type PlanRow struct {
 	CIDR             string `json:"cidr,omitempty" yaml:"cidr,omitempty"`
 	PrefixV6         *int   `json:"prefix_v6,omitempty" yaml:"prefix_v6,omitempty"`
 	CIDRV6           string `json:"cidr_v6,omitempty" yaml:"cidr_v6,omitempty"`
+	MultiPrefix      *bool  `json:"multi_prefix,omitempty" yaml:"multi_prefix,omitempty"`
+	SecondaryCIDRs   string `json:"secondary_cidrs,omitempty" yaml:"secondary_cidrs,omitempty"`
+	SegmentKind      string `json:"segment_kind,omitempty" yaml:"segment_kind,omitempty"`
+	LinkA            string `json:"link_a,omitempty" yaml:"link_a,omitempty"`
+	LinkB            string `json:"link_b,omitempty" yaml:"link_b,omitempty"`
 	Locked           *bool  `json:"locked,omitempty" yaml:"locked,omitempty"`
 	DHCP             *bool  `json:"dhcp,omitempty" yaml:"dhcp,omitempty"`
 	DHCPRange        string `json:"dhcp_range,omitempty" yaml:"dhcp_range,omitempty"`
 	DHCPReservations string `json:"dhcp_reservations,omitempty" yaml:"dhcp_reservations,omitempty"`
 	Gateway          string `json:"gateway,omitempty" yaml:"gateway,omitempty"`
 	GatewayV6        string `json:"gateway_v6,omitempty" yaml:"gateway_v6,omitempty"`
+	IPv6Mode         string `json:"ipv6_mode,omitempty" yaml:"ipv6_mode,omitempty"`
+	IPv6DNS          string `json:"ipv6_dns,omitempty" yaml:"ipv6_dns,omitempty"`
 	Tags             string `json:"tags,omitempty" yaml:"tags,omitempty"`
 	Notes            string `json:"notes,omitempty" yaml:"notes,omitempty"`
 
@@ -86,59 +103,201 @@ type PlanRow struct {
 	OversizeThreshold    *int   `json:"oversize_threshold,omitempty" yaml:"oversize_threshold,omitempty"`
 	PoolStrategy         string `json:"pool_strategy,omitempty" yaml:"pool_strategy,omitempty"`
 	PoolTierFallback     *bool  `json:"pool_tier_fallback,omitempty" yaml:"pool_tier_fallback,omitempty"`
+	IPv6Scheme           string `json:"ipv6_scheme,omitempty" yaml:"ipv6_scheme,omitempty"`
+	VLANRanges           string `json:"vlan_ranges,omitempty" yaml:"vlan_ranges,omitempty"`
+	DefaultVRF           string `json:"default_vrf,omitempty" yaml:"default_vrf,omitempty"`
+	SegmentCheck         string `json:"segment_check,omitempty" yaml:"segment_check,omitempty"`
+	PersistDerived       *bool  `json:"persist_derived,omitempty" yaml:"persist_derived,omitempty"`
+	Uniqueness           string `json:"uniqueness,omitempty" yaml:"uniqueness,omitempty"`
+
+	// metaKeys holds the segment meta keys present in the imported file,
+	// so an empty value there clears the field instead of leaving it as is.
+	metaKeys map[string]bool
 }
 
+var planSegmentMetaKeys = []string{"dhcp", "dhcp_range", "dhcp_reservations", "gateway", "gateway_v6", "ipv6_mode", "ipv6_dns", "notes", "tags", "pool_tier"}
+
 func exportPlanCSV(c *gin.Context, db *sql.DB, projectID int64) error {
-	bundle, err := buildPlanBundle(db, projectID)
+	scope, err := planExportScope(c)
 	if err != nil {
-		return err
+		respondError(c, 400, err)
+		return nil
 	}
-	c.Header("Content-Type", "text/csv; charset=utf-8")
-	c.Header("Content-Disposition", "attachment; filename=subnetio_plan.csv")
-	w := csv.NewWriter(c.Writer)
-	if err := w.Write(planCSVHeaders()); err != nil {
+	bundle, err := buildScopedPlanBundle(db, projectID, scope)
+	if err != nil {
 		return err
 	}
-	for _, row := range bundle.Rows {
-		if err := w.Write(planRowToCSV(row)); err != nil {
-			return err
-		}
-	}
-	w.Flush()
-	return w.Error()
+	stampPlanExport(&bundle, scope.exportedAt())
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=subnetio_plan"+scope.fileSuffix()+".csv")
+	return writePlanBundle(c.Writer, bundle, "csv")
 }
 
 func exportPlanYAML(c *gin.Context, db *sql.DB, projectID int64) error {
-	bundle, err := buildPlanBundle(db, projectID)
+	scope, err := planExportScope(c)
 	if err != nil {
-		return err
+		respondError(c, 400, err)
+		return nil
 	}
-	out, err := yaml.Marshal(bundle)
+	bundle, err := buildScopedPlanBundle(db, projectID, scope)
 	if err != nil {
 		return err
 	}
+	stampPlanExport(&bundle, scope.exportedAt())
 	c.Header("Content-Type", "application/x-yaml; charset=utf-8")
-	c.Header("Content-Disposition", "attachment; filename=subnetio_plan.yaml")
-	c.String(200, string(out))
-	return nil
+	c.Header("Content-Disposition", "attachment; filename=subnetio_plan"+scope.fileSuffix()+".yaml")
+	return writePlanBundle(c.Writer, bundle, "yaml")
 }
 
 func exportPlanJSON(c *gin.Context, db *sql.DB, projectID int64) error {
-	bundle, err := buildPlanBundle(db, projectID)
+	scope, err := planExportScope(c)
 	if err != nil {
-		return err
+		respondError(c, 400, err)
+		return nil
 	}
-	out, err := json.MarshalIndent(bundle, "", "  ")
+	bundle, err := buildScopedPlanBundle(db, projectID, scope)
 	if err != nil {
 		return err
 	}
+	stampPlanExport(&bundle, scope.exportedAt())
 	c.Header("Content-Type", "application/json; charset=utf-8")
-	c.Header("Content-Disposition", "attachment; filename=subnetio_plan.json")
-	c.String(200, string(out))
-	return nil
+	c.Header("Content-Disposition", "attachment; filename=subnetio_plan"+scope.fileSuffix()+".json")
+	return writePlanBundle(c.Writer, bundle, "json")
+}
+
+func writePlanBundle(w io.Writer, bundle PlanBundle, format string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(planCSVHeaders()); err != nil {
+			return err
+		}
+		for _, row := range bundle.Rows {
+			if err := cw.Write(planRowToCSV(row)); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "yaml":
+		out, err := yaml.Marshal(bundle)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	case "json":
+		out, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// PlanScope restricts a plan export to one site and/or VRF. Row UIDs do not
+// depend on the scope, so a scoped bundle imports like the matching slice of
+// a full export. A non-zero AsOf exports the segments as they were at that
+// time (see planAsOf).
+type PlanScope struct {
+	Site string
+	VRF  string
+	AsOf time.Time
+}
+
+func planScopeFromQuery(c *gin.Context) PlanScope {
+	return PlanScope{Site: strings.TrimSpace(c.Query("site")), VRF: strings.TrimSpace(c.Query("vrf"))}
+}
+
+// planExportScope is planScopeFromQuery plus the as_of parameter.
+func planExportScope(c *gin.Context) (PlanScope, error) {
+	scope := planScopeFromQuery(c)
+	var err error
+	scope.AsOf, err = parseAsOf(c.Query("as_of"))
+	return scope, err
+}
+
+// exportedAt is the time stamped on the export, so a past plan imported
+// again is checked against the deletions made since then.
+func (s PlanScope) exportedAt() time.Time {
+	if s.AsOf.IsZero() {
+		return time.Now()
+	}
+	return s.AsOf
+}
+
+func (s PlanScope) segments(db *sql.DB, projectID int64) ([]Segment, error) {
+	if s.AsOf.IsZero() {
+		return listSegments(db, projectID)
+	}
+	h, err := planAsOf(db, projectID, s.AsOf)
+	return h.Segments, err
+}
+
+func (s PlanScope) Empty() bool {
+	return s.Site == "" && s.VRF == ""
+}
+
+func (s PlanScope) fileSuffix() string {
+	suffix := ""
+	if s.Site != "" {
+		suffix += "_" + safeName(s.Site)
+	}
+	if s.VRF != "" {
+		suffix += "_vrf_" + safeName(s.VRF)
+	}
+	if !s.AsOf.IsZero() {
+		suffix += "_as_of_" + s.AsOf.UTC().Format("20060102T150405Z")
+	}
+	return suffix
+}
+
+// filter keeps the segments in scope and the sites and pools they need. With
+// a VRF scope, only sites holding segments of that VRF are kept; their pools
+// come along because VRFs share site pools.
+func (s PlanScope) filter(sites []Site, pools []Pool, segments []Segment) ([]Site, []Pool, []Segment) {
+	if s.Empty() {
+		return sites, pools, segments
+	}
+	keepSite := map[int64]bool{}
+	for _, site := range sites {
+		if s.Site == "" || strings.EqualFold(site.Name, s.Site) {
+			keepSite[site.ID] = s.VRF == ""
+		}
+	}
+	var outSegments []Segment
+	for _, seg := range segments {
+		if _, ok := keepSite[seg.SiteID]; !ok {
+			continue
+		}
+		if s.VRF != "" && !strings.EqualFold(strings.TrimSpace(seg.VRF), s.VRF) {
+			continue
+		}
+		keepSite[seg.SiteID] = true
+		outSegments = append(outSegments, seg)
+	}
+	var outSites []Site
+	for _, site := range sites {
+		if keepSite[site.ID] {
+			outSites = append(outSites, site)
+		}
+	}
+	var outPools []Pool
+	for _, p := range pools {
+		if keepSite[p.SiteID] {
+			outPools = append(outPools, p)
+		}
+	}
+	return outSites, outPools, outSegments
 }
 
 func buildPlanBundle(db *sql.DB, projectID int64) (PlanBundle, error) {
+	return buildScopedPlanBundle(db, projectID, PlanScope{})
+}
+
+func buildScopedPlanBundle(db *sql.DB, projectID int64, scope PlanScope) (PlanBundle, error) {
 	project := Project{ID: projectID, Name: "Default"}
 	if p, ok := projectByID(db, projectID); ok {
 		project = p
@@ -148,6 +307,10 @@ func buildPlanBundle(db *sql.DB, projectID int64) (PlanBundle, error) {
 		return PlanBundle{}, err
 	}
 	rules, _ := getProjectRules(db, projectID)
+	presets, err := listRulePresets(db)
+	if err != nil {
+		return PlanBundle{}, err
+	}
 	sites, err := listSites(db, projectID)
 	if err != nil {
 		return PlanBundle{}, err
@@ -156,10 +319,11 @@ func buildPlanBundle(db *sql.DB, projectID int64) (PlanBundle, error) {
 	if err != nil {
 		return PlanBundle{}, err
 	}
-	segments, err := listSegments(db, projectID)
+	segments, err := scope.segments(db, projectID)
 	if err != nil {
 		return PlanBundle{}, err
 	}
+	sites, pools, segments = scope.filter(sites, pools, segments)
 	projectName := strings.TrimSpace(project.Name)
 	if projectName == "" {
 		projectName = "Default"
@@ -177,6 +341,7 @@ func buildPlanBundle(db *sql.DB, projectID int64) (PlanBundle, error) {
 	var rows []PlanRow
 	rows = append(rows, buildPlanMetaRow(projectName, meta))
 	rows = append(rows, buildPlanRulesRow(projectName, rules))
+	rows = append(rows, buildPlanRulePresetRows(presets)...)
 	rows = append(rows, buildPlanSiteRows(projectName, sites)...)
 	rows = append(rows, buildPlanPoolRows(siteProject, pools)...)
 	rows = append(rows, buildPlanSegmentRows(siteProject, segments)...)
@@ -223,6 +388,7 @@ func buildPlanRulesRow(projectName string, rules ProjectRules) PlanRow {
 	allowReserved := rules.AllowReservedOverlap
 	oversize := rules.OversizeThreshold
 	poolFallback := rules.PoolTierFallback
+	persistDerived := rules.PersistDerived
 	return PlanRow{
 		RowType:              planRowRules,
 		UID:                  stableID(planRowRules, projectName),
@@ -233,9 +399,30 @@ func buildPlanRulesRow(projectName string, rules ProjectRules) PlanRow {
 		OversizeThreshold:    &oversize,
 		PoolStrategy:         rules.PoolStrategy,
 		PoolTierFallback:     &poolFallback,
+		IPv6Scheme:           rules.IPv6Scheme,
+		VLANRanges:           rules.VLANRanges,
+		DefaultVRF:           rules.DefaultVRF,
+		SegmentCheck:         rules.SegmentCheck,
+		PersistDerived:       &persistDerived,
+		Uniqueness:           rules.Uniqueness,
 	}
 }
 
+// buildPlanRulePresetRows exports custom rule presets. They are global, so
+// the rows carry no project.
+func buildPlanRulePresetRows(presets []RulePreset) []PlanRow {
+	out := make([]PlanRow, 0, len(presets))
+	for _, p := range presets {
+		row := buildPlanRulesRow("", p.Rules)
+		row.RowType = planRowRulePreset
+		row.UID = stableID(planRowRulePreset, p.Name)
+		row.Name = p.Name
+		row.Notes = p.Description
+		out = append(out, row)
+	}
+	return out
+}
+
 func buildPlanSiteRows(defaultProject string, sites []Site) []PlanRow {
 	out := make([]PlanRow, 0, len(sites))
 	for _, s := range sites {
@@ -253,6 +440,8 @@ func buildPlanSiteRows(defaultProject string, sites []Site) []PlanRow {
 			NTP:            nullString(s.NTP),
 			GatewayPolicy:  nullString(s.GatewayPolicy),
 			ReservedRanges: nullString(s.ReservedRanges),
+			BGPASN:         asnString(s.BGPASN),
+			VLANDomain:     nullString(s.VLANDomain),
 		}
 		out = append(out, row)
 	}
@@ -267,7 +456,7 @@ func buildPlanPoolRows(siteProject map[int64]string, pools []Pool) []PlanRow {
 			projectName = "Default"
 		}
 		priority := p.Priority
-		out = append(out, PlanRow{
+		row := PlanRow{
 			RowType:      planRowPool,
 			UID:          stableID(planRowPool, projectName, p.Site, p.CIDR),
 			Project:      projectName,
@@ -276,7 +465,14 @@ func buildPlanPoolRows(siteProject map[int64]string, pools []Pool) []PlanRow {
 			PoolFamily:   normalizePoolFamily(p.Family),
 			PoolTier:     nullString(p.Tier),
 			PoolPriority: &priority,
-		})
+		}
+		if p.Kind != PoolKindLAN {
+			row.PoolKind = p.Kind
+		}
+		if isDrainingPool(p) {
+			row.PoolState = p.State
+		}
+		out = append(out, row)
 	}
 	return out
 }
@@ -303,10 +499,24 @@ func buildPlanSegmentRows(siteProject map[int64]string, segments []Segment) []Pl
 			CIDRV6:    nullString(s.CIDRV6),
 			Gateway:   nullString(s.Gateway),
 			GatewayV6: nullString(s.GatewayV6),
+			IPv6Mode:  nullString(s.IPv6Mode),
+			IPv6DNS:   nullString(s.IPv6DNS),
 			Tags:      nullString(s.Tags),
 			Notes:     nullString(s.Notes),
 			PoolTier:  nullString(s.PoolTier),
 		}
+		if s.MultiPrefix {
+			multi := true
+			row.MultiPrefix = &multi
+		}
+		if s.SecondaryCIDRs.Valid {
+			row.SecondaryCIDRs = strings.TrimSpace(s.SecondaryCIDRs.String)
+		}
+		if isLinkSegment(s) {
+			row.SegmentKind = SegmentKindLink
+			row.LinkA = strings.TrimSpace(nullString(s.LinkA))
+			row.LinkB = strings.TrimSpace(nullString(s.LinkB))
+		}
 		if s.Hosts.Valid {
 			val := int(s.Hosts.Int64)
 			row.Hosts = &val
@@ -325,7 +535,7 @@ func buildPlanSegmentRows(siteProject map[int64]string, segments []Segment) []Pl
 		if s.DhcpReservations.Valid {
 			row.DHCPReservations = strings.TrimSpace(s.DhcpReservations.String)
 		}
-		hasMeta := s.DhcpEnabled || s.DhcpRange.Valid || s.DhcpReservations.Valid || s.Gateway.Valid || s.GatewayV6.Valid || s.Notes.Valid || s.Tags.Valid || s.PoolTier.Valid
+		hasMeta := s.DhcpEnabled || s.DhcpRange.Valid || s.DhcpReservations.Valid || s.Gateway.Valid || s.GatewayV6.Valid || s.IPv6Mode.Valid || s.IPv6DNS.Valid || s.Notes.Valid || s.Tags.Valid || s.PoolTier.Valid
 		if hasMeta {
 			val := s.DhcpEnabled
 			row.DHCP = &val
@@ -337,11 +547,12 @@ func buildPlanSegmentRows(siteProject map[int64]string, segments []Segment) []Pl
 
 func sortPlanRows(rows []PlanRow) {
 	typeOrder := map[string]int{
-		planRowMeta:    0,
-		planRowRules:   1,
-		planRowSite:    2,
-		planRowPool:    3,
-		planRowSegment: 4,
+		planRowMeta:       0,
+		planRowRules:      1,
+		planRowRulePreset: 2,
+		planRowSite:       3,
+		planRowPool:       4,
+		planRowSegment:    5,
 	}
 	sort.Slice(rows, func(i, j int) bool {
 		a := rows[i]
@@ -387,6 +598,8 @@ func planCSVHeaders() []string {
 		"ntp",
 		"gateway_policy",
 		"reserved_ranges",
+		"bgp_asn",
+		"vlan_domain",
 		"pool",
 		"pool_family",
 		"pool_tier",
@@ -399,12 +612,16 @@ func planCSVHeaders() []string {
 		"cidr",
 		"prefix_v6",
 		"cidr_v6",
+		"multi_prefix",
+		"secondary_cidrs",
 		"locked",
 		"dhcp",
 		"dhcp_range",
 		"dhcp_reservations",
 		"gateway",
 		"gateway_v6",
+		"ipv6_mode",
+		"ipv6_dns",
 		"tags",
 		"notes",
 		"domain_name",
@@ -426,6 +643,18 @@ func planCSVHeaders() []string {
 		"oversize_threshold",
 		"pool_strategy",
 		"pool_tier_fallback",
+		"ipv6_scheme",
+		"vlan_ranges",
+		"default_vrf",
+		"segment_check",
+		"persist_derived",
+		"uniqueness",
+		"pool_kind",
+		"pool_state",
+		"segment_kind",
+		"link_a",
+		"link_b",
+		"exported_at",
 	}
 }
 
@@ -441,6 +670,8 @@ func planRowToCSV(row PlanRow) []string {
 		row.NTP,
 		row.GatewayPolicy,
 		row.ReservedRanges,
+		row.BGPASN,
+		row.VLANDomain,
 		row.Pool,
 		row.PoolFamily,
 		row.PoolTier,
@@ -453,12 +684,16 @@ func planRowToCSV(row PlanRow) []string {
 		row.CIDR,
 		intPointerString(row.PrefixV6),
 		row.CIDRV6,
+		boolPointerString(row.MultiPrefix),
+		row.SecondaryCIDRs,
 		boolPointerString(row.Locked),
 		boolPointerString(row.DHCP),
 		row.DHCPRange,
 		row.DHCPReservations,
 		row.Gateway,
 		row.GatewayV6,
+		row.IPv6Mode,
+		row.IPv6DNS,
 		row.Tags,
 		row.Notes,
 		row.DomainName,
@@ -480,6 +715,18 @@ func planRowToCSV(row PlanRow) []string {
 		intPointerString(row.OversizeThreshold),
 		row.PoolStrategy,
 		boolPointerString(row.PoolTierFallback),
+		row.IPv6Scheme,
+		row.VLANRanges,
+		row.DefaultVRF,
+		row.SegmentCheck,
+		boolPointerString(row.PersistDerived),
+		row.Uniqueness,
+		row.PoolKind,
+		row.PoolState,
+		row.SegmentKind,
+		row.LinkA,
+		row.LinkB,
+		row.ExportedAt,
 	}
 }
 
@@ -0,0 +1,263 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	planOpAdd    = "add"
+	planOpUpdate = "update"
+	planOpDelete = "delete"
+)
+
+// importPlanPatch applies a patch in one transaction; if any row fails,
+// nothing is saved.
+func importPlanPatch(db *sql.DB, r io.Reader, format string, activeProjectID int64, policy ImportPolicy) *ImportReport {
+	policy.Patch = true
+	tx, err := db.Begin()
+	if err != nil {
+		return &ImportReport{Policy: policy.String(), Errors: []string{"begin: " + err.Error()}}
+	}
+	report := importPlanStream(tx, r, format, activeProjectID, policy)
+	if len(report.Errors) > 0 {
+		_ = tx.Rollback()
+		report.rollBackPatch()
+		return report
+	}
+	if err := tx.Commit(); err != nil {
+		report.Errors = append(report.Errors, "commit: "+err.Error())
+		report.rollBackPatch()
+	}
+	return report
+}
+
+// rollBackPatch marks a report whose patch was not saved.
+func (r *ImportReport) rollBackPatch() {
+	r.touched = nil
+	r.Errors = append(r.Errors, "patch rolled back, nothing was changed")
+}
+
+// planUIDIndex returns the UIDs of the project's meta, rules, sites, pools
+// and segments, each with the key fields of its row.
+func planUIDIndex(db sqlConn, projectID int64, projectName string) (map[string]PlanRow, error) {
+	index := map[string]PlanRow{
+		stableID(planRowMeta, projectName):  {RowType: planRowMeta},
+		stableID(planRowRules, projectName): {RowType: planRowRules},
+	}
+	sites, err := db.Query(`
+		SELECT s.name FROM sites s
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE ps.project_id=?`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for sites.Next() {
+		var row PlanRow
+		if err := sites.Scan(&row.Site); err != nil {
+			sites.Close()
+			return nil, err
+		}
+		row.RowType = planRowSite
+		index[stableID(planRowSite, projectName, row.Site)] = row
+	}
+	sites.Close()
+	if err := sites.Err(); err != nil {
+		return nil, err
+	}
+	pools, err := db.Query(`
+		SELECT s.name, p.cidr FROM pools p
+		JOIN sites s ON s.id = p.site_id
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE ps.project_id=?`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for pools.Next() {
+		var row PlanRow
+		if err := pools.Scan(&row.Site, &row.Pool); err != nil {
+			pools.Close()
+			return nil, err
+		}
+		row.RowType = planRowPool
+		index[stableID(planRowPool, projectName, row.Site, row.Pool)] = row
+	}
+	pools.Close()
+	if err := pools.Err(); err != nil {
+		return nil, err
+	}
+	segments, err := db.Query(`
+		SELECT s.name, g.vrf, g.vlan, g.name FROM segments g
+		JOIN sites s ON s.id = g.site_id
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE ps.project_id=?`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer segments.Close()
+	for segments.Next() {
+		var (
+			row  PlanRow
+			vlan int
+		)
+		if err := segments.Scan(&row.Site, &row.VRF, &vlan, &row.Name); err != nil {
+			return nil, err
+		}
+		row.RowType = planRowSegment
+		row.VLAN = &vlan
+		index[stableID(planRowSegment, projectName, row.Site, row.VRF, itoa(vlan), row.Name)] = row
+	}
+	return index, segments.Err()
+}
+
+// patchIndex returns the project's UID index as of the rows applied so far.
+func (s *planImportState) patchIndex(db sqlConn, projectID int64, projectName string) (map[string]PlanRow, error) {
+	if index, ok := s.uids[projectID]; ok {
+		return index, nil
+	}
+	index, err := planUIDIndex(db, projectID, projectName)
+	if err != nil {
+		return nil, err
+	}
+	if s.uids == nil {
+		s.uids = map[int64]map[string]PlanRow{}
+	}
+	s.uids[projectID] = index
+	return index, nil
+}
+
+// applyPlanPatchRow checks a patch row against the stored plan and applies
+// it.
+func applyPlanPatchRow(db sqlConn, report *ImportReport, state *planImportState, row PlanRow, rowIndex int, activeProjectID int64, source string) error {
+	op := strings.ToLower(strings.TrimSpace(row.Op))
+	rowType := strings.ToLower(strings.TrimSpace(row.RowType))
+	row.UID = strings.TrimSpace(row.UID)
+	switch op {
+	case planOpAdd, planOpUpdate, planOpDelete:
+	case "":
+		return fmt.Errorf("op is required in a patch (add, update or delete)")
+	default:
+		return fmt.Errorf("invalid op: %s (use add, update or delete)", row.Op)
+	}
+	switch rowType {
+	case planRowMeta, planRowRules:
+		if op != planOpUpdate {
+			return fmt.Errorf("%s rows can only be updated", rowType)
+		}
+	case planRowSite, planRowPool, planRowSegment:
+	default:
+		return fmt.Errorf("invalid row_type in a patch: %s", row.RowType)
+	}
+	if row.UID == "" {
+		return fmt.Errorf("uid is required in a patch")
+	}
+	if name := strings.TrimSpace(row.Project); name != "" {
+		var id int64
+		if err := db.QueryRow(`SELECT id FROM projects WHERE name=?`, name).Scan(&id); err == sql.ErrNoRows {
+			return fmt.Errorf("project %s does not exist", name)
+		} else if err != nil {
+			return err
+		}
+	}
+	projectID, projectName, _, err := resolveProjectID(db, row.Project, activeProjectID)
+	if err != nil {
+		return err
+	}
+	index, err := state.patchIndex(db, projectID, projectName)
+	if err != nil {
+		return fmt.Errorf("plan lookup error: %v", err)
+	}
+	stored, exists := index[row.UID]
+	if exists && stored.RowType != rowType {
+		return fmt.Errorf("uid %s belongs to a %s row", row.UID, stored.RowType)
+	}
+
+	switch op {
+	case planOpDelete:
+		if !exists {
+			return fmt.Errorf("cannot delete %s %s: it does not exist", rowType, row.UID)
+		}
+		if expected := expectedPlanUID(rowType, projectName, row); expected != "" && expected != row.UID {
+			return fmt.Errorf("uid mismatch (expected %s)", expected)
+		}
+		if err := deletePlanPatchEntity(db, report, state, projectID, stored, rowIndex); err != nil {
+			return err
+		}
+		delete(index, row.UID)
+		report.Deleted++
+		return nil
+	case planOpAdd:
+		if exists {
+			return fmt.Errorf("cannot add %s %s: it already exists (use update)", rowType, row.UID)
+		}
+	case planOpUpdate:
+		if !exists {
+			return fmt.Errorf("cannot update %s %s: it does not exist (use add)", rowType, row.UID)
+		}
+	}
+	if rowType == planRowPool || rowType == planRowSegment {
+		if _, ok := index[stableID(planRowSite, projectName, row.Site)]; !ok && strings.TrimSpace(row.Site) != "" {
+			return fmt.Errorf("site %s does not exist (add it first)", row.Site)
+		}
+	}
+	updated, skipped := report.Updated, report.Skipped
+	if err := applyPlanRowFields(db, report, state, row, rowIndex, activeProjectID, source); err != nil {
+		return err
+	}
+	if op == planOpAdd {
+		index[row.UID] = row
+	} else if rowType != planRowMeta && rowType != planRowRules && report.Updated == updated && report.Skipped == skipped {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: update of %s %s changed nothing", rowIndex, rowType, row.UID))
+	}
+	return nil
+}
+
+// deletePlanPatchEntity removes the site, pool or segment of a stored row.
+func deletePlanPatchEntity(db sqlConn, report *ImportReport, state *planImportState, projectID int64, stored PlanRow, rowIndex int) error {
+	var siteID int64
+	err := db.QueryRow(`
+		SELECT s.id FROM sites s
+		JOIN project_sites ps ON ps.site_id = s.id
+		WHERE ps.project_id=? AND s.name=?`, projectID, stored.Site).Scan(&siteID)
+	if err != nil {
+		return fmt.Errorf("site lookup error: %v", err)
+	}
+	switch stored.RowType {
+	case planRowSite:
+		var pools, segments int
+		if err := db.QueryRow(`SELECT (SELECT COUNT(*) FROM pools WHERE site_id=?), (SELECT COUNT(*) FROM segments WHERE site_id=?)`, siteID, siteID).Scan(&pools, &segments); err != nil {
+			return fmt.Errorf("site lookup error: %v", err)
+		}
+		if pools > 0 || segments > 0 {
+			return fmt.Errorf("cannot delete site %s: it still has %d pools and %d segments (delete them first)", stored.Site, pools, segments)
+		}
+		return deleteSiteTx(db, siteID)
+	case planRowPool:
+		if _, err := db.Exec(`DELETE FROM import_provenance WHERE entity_type='pool' AND entity_id IN (SELECT id FROM pools WHERE site_id=? AND cidr=?)`, siteID, stored.Pool); err != nil {
+			return err
+		}
+		_, err := db.Exec(`DELETE FROM pools WHERE site_id=? AND cidr=?`, siteID, stored.Pool)
+		return err
+	case planRowSegment:
+		segID, ok, err := findSegmentID(db, siteID, stored.VRF, intValue(stored.VLAN), stored.Name)
+		if err != nil || !ok {
+			return fmt.Errorf("segment lookup error: %v", err)
+		}
+		var locked bool
+		if err := db.QueryRow(`SELECT locked FROM segments WHERE id=?`, segID).Scan(&locked); err != nil {
+			return fmt.Errorf("segment lookup error: %v", err)
+		}
+		if key := fmt.Sprintf("%s/%s/%d/%s", stored.Site, stored.VRF, intValue(stored.VLAN), stored.Name); locked {
+			if !state.policy.ForceLocked {
+				return segmentLockError{Name: key, Op: "deleting it"}
+			}
+			report.Warnings = append(report.Warnings, fmt.Sprintf("row %d: locked segment %s deleted, forced", rowIndex, key))
+		}
+		return deleteSegmentTx(db, segID)
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxPlanLine bounds one line of a YAML plan file.
+const maxPlanLine = 1 << 20
+
+// planBundleScanner walks the top level of a json or yaml plan bundle
+// without loading it.
+type planBundleScanner interface {
+	key() (string, error)
+	version() (string, error)
+	startRows() (bool, error)
+	nextRow() (json.RawMessage, error)
+}
+
+// planRowError is a row that could not be decoded.
+type planRowError struct {
+	err error
+}
+
+func (e planRowError) Error() string {
+	return e.err.Error()
+}
+
+// planRowReader reads a json or yaml plan bundle one row at a time so only the
+// current row is held in memory.
+type planRowReader struct {
+	scan   planBundleScanner
+	inRows bool
+	spool  *os.File
+	queue  *json.Decoder
+}
+
+// spooledRow is one row in the spool file: the row or its decode error.
+type spooledRow struct {
+	Row json.RawMessage `json:"row,omitempty"`
+	Err string          `json:"err,omitempty"`
+}
+
+func newPlanRowReader(r io.Reader, format string) (*planRowReader, error) {
+	switch format {
+	case "json":
+		return &planRowReader{scan: &jsonBundleScanner{dec: json.NewDecoder(r)}}, nil
+	case "yaml":
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), maxPlanLine)
+		return &planRowReader{scan: &yamlBundleScanner{sc: sc, seqIndent: -1}}, nil
+	default:
+		return nil, errors.New("unsupported format")
+	}
+}
+
+func (p *planRowReader) header() (string, error) {
+	version := ""
+	for {
+		key, err := p.scan.key()
+		if err == io.EOF {
+			return version, p.rewind()
+		}
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case "schema_version":
+			if version, err = p.scan.version(); err != nil {
+				return "", err
+			}
+		case "rows":
+			hasRows, err := p.scan.startRows()
+			if err != nil {
+				return "", err
+			}
+			if !hasRows {
+				continue
+			}
+			if version != "" {
+				p.inRows = true
+				return version, nil
+			}
+			if err := p.spoolRows(); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("unknown field %q", key)
+		}
+	}
+}
+
+func (p *planRowReader) next() (PlanRow, error) {
+	raw, err := p.nextRaw()
+	if err != nil {
+		return PlanRow{}, err
+	}
+	row, err := decodePlanRowJSON(raw)
+	if err != nil {
+		return PlanRow{}, planRowError{err: err}
+	}
+	return row, nil
+}
+
+func (p *planRowReader) nextRaw() (json.RawMessage, error) {
+	if p.queue != nil {
+		var rec spooledRow
+		if err := p.queue.Decode(&rec); err != nil {
+			return nil, err
+		}
+		if rec.Err != "" {
+			return nil, planRowError{err: errors.New(rec.Err)}
+		}
+		return rec.Row, nil
+	}
+	if !p.inRows {
+		return nil, io.EOF
+	}
+	raw, err := p.scan.nextRow()
+	if err != io.EOF {
+		return raw, err
+	}
+	p.inRows = false
+	for {
+		key, err := p.scan.key()
+		if err != nil {
+			return nil, err
+		}
+		if key != "schema_version" {
+			return nil, fmt.Errorf("unexpected field %q after rows", key)
+		}
+		if _, err := p.scan.version(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *planRowReader) spoolRows() error {
+	if p.spool != nil {
+		return errors.New("rows appears twice")
+	}
+	f, err := os.CreateTemp("", "subnetio-plan-*.jsonl")
+	if err != nil {
+		return err
+	}
+	p.spool = f
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for {
+		raw, err := p.scan.nextRow()
+		if err == io.EOF {
+			break
+		}
+		rec := spooledRow{Row: raw}
+		var rowErr planRowError
+		if errors.As(err, &rowErr) {
+			rec = spooledRow{Err: err.Error()}
+		} else if err != nil {
+			return err
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (p *planRowReader) rewind() error {
+	if p.spool == nil {
+		return nil
+	}
+	if _, err := p.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	p.queue = json.NewDecoder(bufio.NewReader(p.spool))
+	return nil
+}
+
+func (p *planRowReader) close() {
+	if p.spool != nil {
+		_ = p.spool.Close()
+		_ = os.Remove(p.spool.Name())
+	}
+}
+
+// decodePlanRowJSON decodes one row, rejecting unknown keys and marking the
+// segment meta keys that are present.
+func decodePlanRowJSON(raw []byte) (PlanRow, error) {
+	var row PlanRow
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&row); err != nil {
+		return PlanRow{}, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return PlanRow{}, err
+	}
+	for _, key := range planSegmentMetaKeys {
+		if _, ok := keys[key]; ok {
+			row.markMetaKey(key)
+		}
+	}
+	return row, nil
+}
+
+type jsonBundleScanner struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+}
+
+func (s *jsonBundleScanner) key() (string, error) {
+	if s.done {
+		return "", io.EOF
+	}
+	if !s.started {
+		s.started = true
+		tok, err := s.dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if d, ok := tok.(json.Delim); !ok || d != '{' {
+			return "", errors.New("plan bundle must be an object")
+		}
+	}
+	if !s.dec.More() {
+		s.done = true
+		if _, err := s.dec.Token(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	tok, err := s.dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected %v", tok)
+	}
+	return key, nil
+}
+
+func (s *jsonBundleScanner) version() (string, error) {
+	var v string
+	err := s.dec.Decode(&v)
+	return v, err
+}
+
+func (s *jsonBundleScanner) startRows() (bool, error) {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return false, err
+	}
+	if tok == nil {
+		return false, nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return false, errors.New("rows must be an array")
+	}
+	return true, nil
+}
+
+func (s *jsonBundleScanner) nextRow() (json.RawMessage, error) {
+	if !s.dec.More() {
+		if _, err := s.dec.Token(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := s.dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// yamlBundleScanner splits a block-style YAML bundle into top-level keys and
+// rows items by indentation and converts each item on its own.
+type yamlBundleScanner struct {
+	sc        *bufio.Scanner
+	line      string
+	unread    bool
+	value     any
+	seqIndent int
+}
+
+func (s *yamlBundleScanner) readLine() (string, bool) {
+	if s.unread {
+		s.unread = false
+		return s.line, true
+	}
+	if !s.sc.Scan() {
+		return "", false
+	}
+	s.line = s.sc.Text()
+	return s.line, true
+}
+
+func yamlIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func (s *yamlBundleScanner) key() (string, error) {
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			if err := s.sc.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if trimmed == "..." {
+			return "", io.EOF
+		}
+		if yamlIndent(line) > 0 {
+			return "", fmt.Errorf("unexpected line: %s", trimmed)
+		}
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "{") || strings.HasPrefix(line, "[") {
+			return "", errors.New("plan bundle must be a block mapping")
+		}
+		var kv map[string]any
+		if err := yaml.Unmarshal([]byte(line), &kv); err != nil {
+			return "", err
+		}
+		if len(kv) != 1 {
+			return "", fmt.Errorf("unexpected line: %s", line)
+		}
+		for k, v := range kv {
+			s.value = v
+			return k, nil
+		}
+	}
+}
+
+func (s *yamlBundleScanner) version() (string, error) {
+	if s.value == nil {
+		return "", nil
+	}
+	return fmt.Sprint(s.value), nil
+}
+
+func (s *yamlBundleScanner) startRows() (bool, error) {
+	if s.value == nil {
+		return true, nil
+	}
+	if list, ok := s.value.([]any); ok && len(list) == 0 {
+		return false, nil
+	}
+	return false, errors.New("rows must be a block sequence")
+}
+
+func (s *yamlBundleScanner) nextRow() (json.RawMessage, error) {
+	var item []string
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			if err := s.sc.Err(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			if len(item) > 0 {
+				item = append(item, line)
+			}
+			continue
+		}
+		indent := yamlIndent(line)
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		if s.seqIndent < 0 && isItem {
+			s.seqIndent = indent
+		}
+		if indent == 0 && !isItem {
+			s.unread = true
+			break
+		}
+		if isItem && indent == s.seqIndent {
+			if len(item) > 0 {
+				s.unread = true
+				break
+			}
+		} else if len(item) == 0 {
+			return nil, fmt.Errorf("unexpected line in rows: %s", trimmed)
+		}
+		item = append(item, line)
+	}
+	if len(item) == 0 {
+		return nil, io.EOF
+	}
+	var seq []any
+	if err := yaml.Unmarshal([]byte(strings.Join(item, "\n")), &seq); err != nil {
+		return nil, planRowError{err: err}
+	}
+	if len(seq) != 1 {
+		return nil, planRowError{err: errors.New("row is not a single mapping")}
+	}
+	raw, err := json.Marshal(seq[0])
+	if err != nil {
+		return nil, planRowError{err: err}
+	}
+	return raw, nil
+}
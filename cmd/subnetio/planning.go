@@ -21,6 +21,7 @@ type CapacityReport struct {
 }
 
 type CapacityPool struct {
+	PoolID      int64
 	Site        string
 	Family      string
 	Tier        string
@@ -32,6 +33,19 @@ type CapacityPool struct {
 	Utilization string
 	Units       string
 	Forecast    string
+	Shares      []CapacityShare
+	// Warning is set when the pool is at least capacityWarningPercent used.
+	Warning bool
+	Owner   Owner
+	// Reserved lists the site's reserved ranges inside the pool and
+	// FreeBlocks the largest free blocks, IPv6 ones no smaller than the /64
+	// (or pool) unit gaps are counted in.
+	Reserved      []string
+	FreeBlocks    []string
+	Gaps          int
+	Fragmentation int
+
+	used, total *big.Int
 }
 
 type CapacitySummary struct {
@@ -41,8 +55,19 @@ type CapacitySummary struct {
 	Utilization string
 }
 
+// capacityWarningPercent is the pool utilization that raises a capacity
+// warning.
+const capacityWarningPercent = 80
+
+// capacityFreeBlocks is how many free blocks a pool suggests.
+const capacityFreeBlocks = 3
+
 func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate float64, months int, v6Unit int) CapacityReport {
 	reservedV4, reservedV6, _ := buildReservedIndex(sites)
+	sitesByID := make(map[int64]Site, len(sites))
+	for _, site := range sites {
+		sitesByID[site.ID] = site
+	}
 	segmentsBySite := map[int64][]Segment{}
 	for _, s := range segs {
 		segmentsBySite[s.SiteID] = append(segmentsBySite[s.SiteID], s)
@@ -70,11 +95,13 @@ func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate
 		}
 		segments := segmentsBySite[p.SiteID]
 		poolReport := CapacityPool{
+			PoolID:   p.ID,
 			Site:     p.Site,
 			Family:   family,
 			Tier:     poolTierValue(p),
 			Priority: p.Priority,
 			CIDR:     prefix.String(),
+			Owner:    sitesByID[p.SiteID].Owner(),
 		}
 
 		var usedCount *big.Int
@@ -85,6 +112,14 @@ func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate
 			totalCount = prefixSize(prefix)
 			sumV4Total.Add(sumV4Total, totalCount)
 			sumV4Used.Add(sumV4Used, usedCount)
+			gaps, _, frag := poolGapsV4(prefix, segments, reservedV4[p.SiteID])
+			var blocks []netip.Prefix
+			for _, g := range gaps {
+				blocks = append(blocks, rangeToPrefixes(g)...)
+			}
+			poolReport.Reserved = reservedInPool(prefix, reservedV4[p.SiteID])
+			poolReport.FreeBlocks = largestBlocks(blocks, capacityFreeBlocks)
+			poolReport.Gaps, poolReport.Fragmentation = len(gaps), frag
 		} else {
 			usedPrefixes := collectUsedPrefixesV6(segments, reservedV6[p.SiteID])
 			usedRanges := buildUsedRangesBig(prefix, usedPrefixes)
@@ -93,6 +128,14 @@ func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate
 			sumV6Total.Add(sumV6Total, totalCount)
 			sumV6Used.Add(sumV6Used, usedCount)
 			poolReport.Units = formatUnits(totalCount, usedCount, v6Unit, prefix.Bits())
+			gaps, _, frag := poolGapsV6(prefix, segments, reservedV6[p.SiteID])
+			var blocks []netip.Prefix
+			for _, g := range gaps {
+				blocks = append(blocks, bigRangeBlocks(g, poolGapUnitV6(prefix))...)
+			}
+			poolReport.Reserved = reservedInPool(prefix, reservedV6[p.SiteID])
+			poolReport.FreeBlocks = largestBlocks(blocks, capacityFreeBlocks)
+			poolReport.Gaps, poolReport.Fragmentation = len(gaps), frag
 		}
 
 		freeCount := new(big.Int).Sub(new(big.Int).Set(totalCount), usedCount)
@@ -101,6 +144,9 @@ func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate
 		poolReport.Free = formatBigInt(freeCount)
 		poolReport.Utilization = ratioPercent(usedCount, totalCount)
 		poolReport.Forecast = forecastSummary(usedCount, totalCount, growthRate, months)
+		poolReport.Shares = buildCapacityShares(p, prefix, segments, family)
+		poolReport.used, poolReport.total = usedCount, totalCount
+		poolReport.Warning = totalCount.Sign() > 0 && new(big.Int).Mul(usedCount, big.NewInt(100)).Cmp(new(big.Int).Mul(totalCount, big.NewInt(capacityWarningPercent))) >= 0
 		report.Pools = append(report.Pools, poolReport)
 	}
 
@@ -122,6 +168,80 @@ func buildCapacityReport(segs []Segment, pools []Pool, sites []Site, growthRate
 	return report
 }
 
+// reservedInPool lists the reserved ranges that overlap pool.
+func reservedInPool(pool netip.Prefix, reserved []netip.Prefix) []string {
+	var out []string
+	for _, r := range reserved {
+		if r.Overlaps(pool) {
+			out = append(out, r.String())
+		}
+	}
+	return out
+}
+
+// largestBlocks returns up to limit blocks, largest first and then by
+// address.
+func largestBlocks(blocks []netip.Prefix, limit int) []string {
+	sort.SliceStable(blocks, func(i, j int) bool {
+		if blocks[i].Bits() != blocks[j].Bits() {
+			return blocks[i].Bits() < blocks[j].Bits()
+		}
+		return blocks[i].Addr().Less(blocks[j].Addr())
+	})
+	var out []string
+	for _, b := range blocks {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, b.String())
+	}
+	return out
+}
+
+// capacityByPool indexes a report's pools by pool ID.
+func capacityByPool(report CapacityReport) map[int64]CapacityPool {
+	out := make(map[int64]CapacityPool, len(report.Pools))
+	for _, p := range report.Pools {
+		out[p.PoolID] = p
+	}
+	return out
+}
+
+// ReservedRange is one entry of a site's reserved ranges as the Sites page
+// shows it: its family and the pool it falls in, or why it is ignored.
+type ReservedRange struct {
+	CIDR    string
+	Family  string
+	Pool    string
+	Invalid bool
+}
+
+// siteReservedRanges splits each site's reserved ranges by family and finds
+// their pools.
+func siteReservedRanges(sites []Site, pools []Pool) map[int64][]ReservedRange {
+	refs := buildPoolRefs(pools)
+	out := map[int64][]ReservedRange{}
+	for _, site := range sites {
+		for _, part := range strings.Split(site.ReservedRanges.String, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(part)
+			if err != nil {
+				out[site.ID] = append(out[site.ID], ReservedRange{CIDR: part, Invalid: true})
+				continue
+			}
+			r := ReservedRange{CIDR: prefix.String(), Family: "ipv4", Pool: poolLabelForPrefix(prefix, refs[site.ID])}
+			if prefix.Addr().Is6() {
+				r.Family = "ipv6"
+			}
+			out[site.ID] = append(out[site.ID], r)
+		}
+	}
+	return out
+}
+
 func buildSummary(used, total *big.Int) CapacitySummary {
 	if total == nil || total.Sign() == 0 {
 		return CapacitySummary{Total: "0", Used: "0", Free: "0", Utilization: "0%"}
@@ -0,0 +1,331 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Pool states.
+const (
+	PoolStateActive   = "active"
+	PoolStateDraining = "draining"
+)
+
+func parsePoolState(raw string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", PoolStateActive:
+		return PoolStateActive, nil
+	case PoolStateDraining:
+		return PoolStateDraining, nil
+	}
+	return "", fmt.Errorf("unknown pool state %q (use active or draining)", raw)
+}
+
+func normalizePoolState(raw string) string {
+	state, err := parsePoolState(raw)
+	if err != nil {
+		return PoolStateActive
+	}
+	return state
+}
+
+func isDrainingPool(p Pool) bool {
+	return normalizePoolState(p.State) == PoolStateDraining
+}
+
+func splitDrainingPools(items []poolItem) ([]poolItem, []poolItem) {
+	var active, draining []poolItem
+	for _, item := range items {
+		if isDrainingPool(item.Pool) {
+			draining = append(draining, item)
+		} else {
+			active = append(active, item)
+		}
+	}
+	return active, draining
+}
+
+// heldInDrainingPools returns the unlocked segments that already sit in a
+// draining pool at their requested size.
+func heldInDrainingPools(segs []Segment, draining []poolItem, family string) map[int64]netip.Prefix {
+	held := map[int64]netip.Prefix{}
+	if len(draining) == 0 {
+		return held
+	}
+	for _, s := range segs {
+		if s.Locked || (family == "ipv4" && len(segmentSecondaryPrefixes(s)) > 0) {
+			continue
+		}
+		cidr := segmentCIDRByFamily(s, family)
+		if !cidr.Valid {
+			continue
+		}
+		p, err := netip.ParsePrefix(strings.TrimSpace(cidr.String))
+		if err != nil || p.Bits() != desiredPrefixByFamily(s, family) {
+			continue
+		}
+		for _, item := range draining {
+			if prefixWithin(item.Prefix, p) {
+				held[s.ID] = p
+				break
+			}
+		}
+	}
+	return held
+}
+
+// PoolMigrationMove proposes a new prefix for one segment of a draining pool.
+type PoolMigrationMove struct {
+	Segment      Segment
+	From         netip.Prefix
+	To           netip.Prefix
+	Target       string
+	GatewayFrom  string
+	GatewayTo    string
+	DhcpFrom     string
+	DhcpTo       string
+	Reservations int
+	Error        string
+}
+
+type PoolMigrationPlan struct {
+	Pool     Pool
+	Targets  []Pool
+	TargetID int64
+	Moves    []PoolMigrationMove
+	Unplaced int
+	Locked   int
+}
+
+// Next is the first move that can be applied.
+func (p PoolMigrationPlan) Next() (PoolMigrationMove, bool) {
+	for _, m := range p.Moves {
+		if m.Error == "" {
+			return m, true
+		}
+	}
+	return PoolMigrationMove{}, false
+}
+
+// Move finds the proposed move of a segment.
+func (p PoolMigrationPlan) Move(segmentID int64) (PoolMigrationMove, bool) {
+	for _, m := range p.Moves {
+		if m.Segment.ID == segmentID {
+			return m, true
+		}
+	}
+	return PoolMigrationMove{}, false
+}
+
+// planPoolMigration proposes a prefix of the same size for every segment in
+// the pool.
+func planPoolMigration(db *sql.DB, pool Pool, targetID int64) (PoolMigrationPlan, error) {
+	plan := PoolMigrationPlan{Pool: pool, TargetID: targetID}
+	source, err := netip.ParsePrefix(strings.TrimSpace(pool.CIDR))
+	if err != nil {
+		return plan, fmt.Errorf("invalid pool %q", pool.CIDR)
+	}
+	family := normalizePoolFamily(pool.Family)
+	pools, err := poolsBySite(db, pool.SiteID)
+	if err != nil {
+		return plan, err
+	}
+	var targets []poolItem
+	for _, item := range poolItemsForFamily(pools, family) {
+		if item.Pool.ID == pool.ID || isDrainingPool(item.Pool) || isLinkPool(item.Pool) != isLinkPool(pool) {
+			continue
+		}
+		plan.Targets = append(plan.Targets, item.Pool)
+		if targetID == 0 || item.Pool.ID == targetID {
+			targets = append(targets, item)
+		}
+	}
+	plan.Moves, err = proposeSegmentMoves(db, pool.SiteID, source.Masked(), family, targets, pools)
+	if err != nil {
+		return plan, err
+	}
+	for _, m := range plan.Moves {
+		if m.Error != "" {
+			plan.Unplaced++
+		}
+		if m.Segment.Locked {
+			plan.Locked++
+		}
+	}
+	return plan, nil
+}
+
+// proposeSegmentMoves maps every segment of the site whose prefix lies in
+// source to a free prefix of the same size in targets, tried in order.
+func proposeSegmentMoves(db *sql.DB, siteID int64, source netip.Prefix, family string, targets []poolItem, pools []Pool) ([]PoolMigrationMove, error) {
+	segs, err := listSegments(db, projectIDBySite(db, siteID))
+	if err != nil {
+		return nil, err
+	}
+	reservedV4, reservedV6, _ := reservedRangesBySite(db, siteID)
+	used := append([]netip.Prefix(nil), reservedV4...)
+	if family == "ipv6" {
+		used = append([]netip.Prefix(nil), reservedV6...)
+	}
+	var siteSegs, moving []Segment
+	for _, s := range segs {
+		if s.SiteID != siteID {
+			continue
+		}
+		siteSegs = append(siteSegs, s)
+		if family == "ipv4" {
+			used = append(used, segmentPrefixesV4(s)...)
+		} else if s.CIDRV6.Valid {
+			if p, err := netip.ParsePrefix(strings.TrimSpace(s.CIDRV6.String)); err == nil {
+				used = append(used, p.Masked())
+			}
+		}
+		if p, ok := segmentFamilyPrefix(s, family); ok && prefixWithin(source, p) {
+			moving = append(moving, s)
+		}
+	}
+	sort.SliceStable(moving, func(i, j int) bool {
+		pi, _ := segmentFamilyPrefix(moving[i], family)
+		pj, _ := segmentFamilyPrefix(moving[j], family)
+		if pi.Bits() != pj.Bits() {
+			return pi.Bits() < pj.Bits()
+		}
+		return pi.Addr().Less(pj.Addr())
+	})
+	linkTargets := false
+	for _, t := range targets {
+		linkTargets = linkTargets || isLinkPool(t.Pool)
+	}
+	views := map[int64]SegmentView{}
+	for _, v := range buildSegmentViews(siteSegs, nil, pools) {
+		views[v.ID] = v
+	}
+	var moves []PoolMigrationMove
+	for _, s := range moving {
+		from, _ := segmentFamilyPrefix(s, family)
+		move := PoolMigrationMove{Segment: s, From: from}
+		for _, t := range targets {
+			if linkTargets && isLinkPool(t.Pool) != isLinkSegment(s) {
+				continue
+			}
+			if to, ok := allocateInPool(t.Prefix, from.Bits(), used); ok {
+				move.To, move.Target = to, t.Prefix.String()
+				used = append(used, to)
+				break
+			}
+		}
+		if !move.To.IsValid() {
+			move.Error = fmt.Sprintf("no room for a /%d in the target pools", from.Bits())
+		} else {
+			v := views[s.ID]
+			move.GatewayFrom = v.Gateway
+			if family == "ipv6" {
+				move.GatewayFrom = v.GatewayV6
+			}
+			move.GatewayTo = translateAddrString(move.GatewayFrom, from, move.To)
+			if family == "ipv4" && s.DhcpRange.Valid {
+				move.DhcpFrom = strings.TrimSpace(s.DhcpRange.String)
+				move.DhcpTo = translateRange(move.DhcpFrom, from, move.To)
+			}
+		}
+		if err := db.QueryRow(`SELECT COUNT(*) FROM mac_reservations WHERE segment_id=? AND ip<>''`, s.ID).Scan(&move.Reservations); err != nil {
+			return nil, err
+		}
+		moves = append(moves, move)
+	}
+	return moves, nil
+}
+
+func segmentFamilyPrefix(s Segment, family string) (netip.Prefix, bool) {
+	cidr := segmentCIDRByFamily(s, family)
+	if !cidr.Valid {
+		return netip.Prefix{}, false
+	}
+	p, err := netip.ParsePrefix(strings.TrimSpace(cidr.String))
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return p.Masked(), true
+}
+
+// translateAddrString translates an address held as text; anything outside
+// the old prefix is returned unchanged.
+func translateAddrString(raw string, from, to netip.Prefix) string {
+	addr, err := netip.ParseAddr(strings.TrimSpace(raw))
+	if err != nil {
+		return raw
+	}
+	out, ok := translateAddr(addr, from, to)
+	if !ok {
+		return raw
+	}
+	return out.String()
+}
+
+func translateRange(raw string, from, to netip.Prefix) string {
+	start, end := splitRange(raw)
+	if start == "" || end == "" {
+		return raw
+	}
+	return translateAddrString(start, from, to) + "-" + translateAddrString(end, from, to)
+}
+
+// applyPoolMigrationMove renumbers one segment in one transaction.
+func applyPoolMigrationMove(db *sql.DB, family string, move PoolMigrationMove) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := updateSegmentCIDRByFamily(tx, move.Segment.ID, family, move.To.String()); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	var patch segmentMetaPatch
+	if family == "ipv6" {
+		if move.Segment.GatewayV6.Valid {
+			patch.GatewayV6 = optionalString(translateAddrString(move.Segment.GatewayV6.String, move.From, move.To), true)
+		}
+	} else {
+		if move.Segment.Gateway.Valid {
+			patch.Gateway = optionalString(translateAddrString(move.Segment.Gateway.String, move.From, move.To), true)
+		}
+		if move.DhcpFrom != "" {
+			patch.DhcpRange = optionalString(move.DhcpTo, true)
+		}
+	}
+	if err := applySegmentMetaPatch(tx, move.Segment.ID, patch); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	rows, err := tx.Query(`SELECT id, ip FROM mac_reservations WHERE segment_id=? AND ip<>''`, move.Segment.ID)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	renumbered := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var ip string
+		if err := rows.Scan(&id, &ip); err != nil {
+			rows.Close()
+			_ = tx.Rollback()
+			return err
+		}
+		if next := translateAddrString(ip, move.From, move.To); next != ip {
+			renumbered[id] = next
+		}
+	}
+	rows.Close()
+	for id, ip := range renumbered {
+		if _, err := tx.Exec(`UPDATE mac_reservations SET ip=? WHERE id=?`, ip, id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PoolSplitRequest struct {
+	Supernet    netip.Prefix
+	SupernetRaw string
+	SplitRaw    string
+	SizesRaw    string
+	DefaultBits int
+	Sizes       map[int64]int
+	SiteIDs     map[int64]bool
+	Tier        string
+	Priority    int
+}
+
+type PoolSplitAssignment struct {
+	SiteID int64
+	Site   string
+	CIDR   string
+	Error  string
+}
+
+type PoolSplitResult struct {
+	Request     PoolSplitRequest
+	Family      string
+	Assignments []PoolSplitAssignment
+	Failed      int
+}
+
+func parsePrefixBits(raw string) (int, error) {
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "/")
+	if raw == "" {
+		return 0, nil
+	}
+	bits, err := strconv.Atoi(raw)
+	if err != nil || bits <= 0 || bits > 128 {
+		return 0, fmt.Errorf("invalid prefix length %q", raw)
+	}
+	return bits, nil
+}
+
+func parsePoolSplit(c *gin.Context, sites []Site) (PoolSplitRequest, error) {
+	req := PoolSplitRequest{
+		SupernetRaw: strings.TrimSpace(c.PostForm("supernet")),
+		SplitRaw:    strings.TrimSpace(c.PostForm("split_prefix")),
+		SizesRaw:    strings.TrimSpace(c.PostForm("site_sizes")),
+		Sizes:       map[int64]int{},
+		SiteIDs:     map[int64]bool{},
+		Tier:        strings.TrimSpace(c.PostForm("tier")),
+		Priority:    atoiDefault(c.PostForm("priority"), 0),
+	}
+	for _, raw := range c.PostFormArray("site_ids") {
+		if id := parseProjectID(raw); id > 0 {
+			req.SiteIDs[id] = true
+		}
+	}
+	if req.SupernetRaw == "" {
+		return req, errors.New("supernet is required")
+	}
+	supernet, err := netip.ParsePrefix(req.SupernetRaw)
+	if err != nil {
+		return req, fmt.Errorf("invalid supernet %q", req.SupernetRaw)
+	}
+	req.Supernet = supernet.Masked()
+	if req.DefaultBits, err = parsePrefixBits(req.SplitRaw); err != nil {
+		return req, err
+	}
+
+	siteByName := map[string]Site{}
+	for _, s := range sites {
+		siteByName[strings.ToLower(s.Name)] = s
+	}
+	for _, line := range parseLines(req.SizesRaw) {
+		name, size, ok := strings.Cut(line, "=")
+		if !ok {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return req, fmt.Errorf("invalid sizing line %q (expected SITE=/NN)", line)
+			}
+			name, size = fields[0], fields[1]
+		}
+		site, ok := siteByName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return req, fmt.Errorf("unknown site %q in sizing table", strings.TrimSpace(name))
+		}
+		bits, err := parsePrefixBits(size)
+		if err != nil || bits == 0 {
+			return req, fmt.Errorf("invalid size for site %s", site.Name)
+		}
+		req.Sizes[site.ID] = bits
+		if len(c.PostFormArray("site_ids")) == 0 {
+			req.SiteIDs[site.ID] = true
+		}
+	}
+	if len(req.SiteIDs) == 0 {
+		return req, errors.New("select at least one site")
+	}
+	if req.DefaultBits == 0 && len(req.Sizes) == 0 {
+		return req, errors.New("split size or sizing table is required")
+	}
+	return req, nil
+}
+
+func planPoolSplit(req PoolSplitRequest, sites []Site, pools []Pool) PoolSplitResult {
+	result := PoolSplitResult{Request: req, Family: "ipv4"}
+	if req.Supernet.Addr().Is6() {
+		result.Family = "ipv6"
+	}
+	var used []netip.Prefix
+	for _, p := range pools {
+		prefix, err := netip.ParsePrefix(p.CIDR)
+		if err == nil && prefix.Overlaps(req.Supernet) {
+			used = append(used, prefix.Masked())
+		}
+	}
+
+	var selected []Site
+	for _, s := range sites {
+		if req.SiteIDs[s.ID] {
+			selected = append(selected, s)
+		}
+	}
+	wantFor := func(s Site) int {
+		if bits, ok := req.Sizes[s.ID]; ok {
+			return bits
+		}
+		return req.DefaultBits
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		wi, wj := wantFor(selected[i]), wantFor(selected[j])
+		if wi != wj {
+			return wi < wj
+		}
+		return selected[i].Name < selected[j].Name
+	})
+
+	maxBits := 32
+	if result.Family == "ipv6" {
+		maxBits = 128
+	}
+	for _, s := range selected {
+		a := PoolSplitAssignment{SiteID: s.ID, Site: s.Name}
+		want := wantFor(s)
+		switch {
+		case want == 0:
+			a.Error = "no size for site"
+		case want < req.Supernet.Bits() || want > maxBits:
+			a.Error = "/" + itoa(want) + " does not fit in " + req.Supernet.String()
+		default:
+			if p, ok := allocateInPool(req.Supernet, want, used); ok {
+				used = append(used, p)
+				a.CIDR = p.String()
+			} else {
+				a.Error = "no free /" + itoa(want) + " left in " + req.Supernet.String()
+			}
+		}
+		if a.Error != "" {
+			result.Failed++
+		}
+		result.Assignments = append(result.Assignments, a)
+	}
+	sort.SliceStable(result.Assignments, func(i, j int) bool {
+		return result.Assignments[i].Site < result.Assignments[j].Site
+	})
+	return result
+}
+
+func applyPoolSplit(db *sql.DB, result PoolSplitResult) ([]int64, error) {
+	if result.Failed > 0 {
+		return nil, errors.New("split plan has unassigned sites")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for _, a := range result.Assignments {
+		res, err := tx.Exec(`INSERT INTO pools(site_id, cidr, family, tier, priority) VALUES(?, ?, ?, ?, ?)`,
+			a.SiteID, a.CIDR, result.Family, nullStringToAny(result.Request.Tier), result.Request.Priority)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, err
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
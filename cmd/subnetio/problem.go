@@ -0,0 +1,121 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	ErrorID  string `json:"error_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// errorPageData supplies the layout data (project switcher, banners) for
+// error pages; runServer points it at baseData.
+var errorPageData func(c *gin.Context) gin.H
+
+func newErrorID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func newProblem(c *gin.Context, status int, err error) Problem {
+	p := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: c.Request.URL.Path,
+	}
+	if status == http.StatusInternalServerError {
+		p.ErrorID = newErrorID()
+		log.Printf("error %s: %s %s: %v", p.ErrorID, c.Request.Method, c.Request.URL.RequestURI(), err)
+		p.Detail = "An internal error occurred. Quote error ID " + p.ErrorID + " when reporting it."
+	} else if err != nil {
+		p.Detail = err.Error()
+	}
+	p.Error = p.Detail
+	return p
+}
+
+// wantsProblemJSON reports whether the request comes from a program rather
+// than a browser page: the API, exports, or an explicit JSON Accept header.
+func wantsProblemJSON(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/export/") || strings.HasPrefix(path, "/schemas/") {
+		return true
+	}
+	accept := c.GetHeader("Accept")
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.Contains(accept, "application/json") || strings.Contains(accept, problemContentType)
+}
+
+// respondError answers the request with err, as a problem document or an
+// error page depending on who is asking.
+func respondError(c *gin.Context, status int, err error) {
+	if wantsProblemJSON(c) {
+		respondProblem(c, status, err)
+		return
+	}
+	p := newProblem(c, status, err)
+	tmpl, terr := loadTemplate("error")
+	if terr != nil {
+		c.String(status, fmt.Sprintf("%s: %s", p.Title, p.Detail))
+		return
+	}
+	data := gin.H{"Maintenance": MaintenanceState{}, "CurrentPath": c.Request.URL.Path}
+	if errorPageData != nil {
+		data = errorPageData(c)
+	}
+	data["Active"] = ""
+	data["Problem"] = p
+	c.Status(status)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if terr := tmpl.ExecuteTemplate(c.Writer, "layout", data); terr != nil {
+		log.Printf("render error page: %v", terr)
+	}
+}
+
+func respondProblem(c *gin.Context, status int, err error) {
+	p := newProblem(c, status, err)
+	c.Header("Content-Type", problemContentType)
+	c.JSON(status, p)
+}
+
+func abortWithError(c *gin.Context, status int, err error) {
+	respondError(c, status, err)
+	c.Abort()
+}
+
+func abortWithProblem(c *gin.Context, status int, err error) {
+	respondProblem(c, status, err)
+	c.Abort()
+}
+
+func recoverWithProblem(c *gin.Context, recovered any) {
+	respondError(c, http.StatusInternalServerError, fmt.Errorf("panic: %v", recovered))
+	c.Abort()
+}
+
+func notFoundHandler(c *gin.Context) {
+	respondError(c, http.StatusNotFound, fmt.Errorf("nothing at %s", c.Request.URL.Path))
+}
@@ -22,6 +22,8 @@ type ProjectMeta struct {
 	DhcpVendorOpts sql.NullString
 	GrowthRate     sql.NullFloat64
 	GrowthMonths   sql.NullInt64
+	// Vars are the project's template variables.
+	Vars map[string]string
 }
 
 func getProjectMeta(db *sql.DB, projectID int64) (ProjectMeta, error) {
@@ -51,16 +53,16 @@ func getProjectMeta(db *sql.DB, projectID int64) (ProjectMeta, error) {
 		&meta.GrowthRate,
 		&meta.GrowthMonths,
 	); err {
-	case nil:
-		return meta, nil
-	case sql.ErrNoRows:
-		return meta, nil
+	case nil, sql.ErrNoRows:
 	default:
 		return meta, err
 	}
+	vars, err := projectTemplateVars(db, projectID)
+	meta.Vars = vars
+	return meta, err
 }
 
-func saveProjectMeta(db *sql.DB, meta ProjectMeta) error {
+func saveProjectMeta(db sqlConn, meta ProjectMeta) error {
 	if meta.ProjectID <= 0 {
 		return nil
 	}
@@ -0,0 +1,77 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var basePath string
+
+func normalizeBasePath(raw string) string {
+	raw = strings.TrimSpace(raw)
+	raw = strings.Trim(raw, "/")
+	if raw == "" {
+		return ""
+	}
+	return "/" + raw
+}
+
+func withBase(path string) string {
+	if basePath == "" {
+		return path
+	}
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+	return basePath + path
+}
+
+func cookiePath() string {
+	if basePath == "" {
+		return "/"
+	}
+	return basePath + "/"
+}
+
+func pageFuncs() map[string]any {
+	return map[string]any{
+		"base": func() string { return basePath },
+	}
+}
+
+func basePathHandler(h http.Handler) http.Handler {
+	if basePath == "" {
+		return h
+	}
+	strip := http.StripPrefix(basePath, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == basePath {
+			target := basePath + "/"
+			if req.URL.RawQuery != "" {
+				target += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, target, http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(req.URL.Path, basePath+"/") {
+			http.NotFound(w, req)
+			return
+		}
+		strip.ServeHTTP(w, req)
+	})
+}
+
+func configureTrustedProxies(r *gin.Engine, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil
+	}
+	if strings.EqualFold(raw, "none") {
+		return r.SetTrustedProxies(nil)
+	}
+	return r.SetTrustedProxies(splitCSV(raw))
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const quickAddMaxRows = 1000
+
+type QuickAddRow struct {
+	Line     int
+	Site     string
+	VRF      string
+	VLAN     string
+	Name     string
+	Hosts    string
+	Error    string
+	Warnings []string
+
+	siteID int64
+	vlan   int
+	hosts  sql.NullInt64
+}
+
+type QuickAddPreview struct {
+	Text    string
+	Rows    []QuickAddRow
+	Valid   int
+	Invalid int
+	Error   string
+}
+
+// Ready reports whether the preview can be applied.
+func (p *QuickAddPreview) Ready() bool {
+	return p != nil && p.Error == "" && p.Invalid == 0 && p.Valid > 0
+}
+
+// quickAddDelimiter picks the separator of one pasted line.
+func quickAddDelimiter(line string) rune {
+	switch {
+	case strings.Contains(line, "\t"):
+		return '\t'
+	case strings.Contains(line, ";"):
+		return ';'
+	}
+	return ','
+}
+
+// parseQuickAdd splits the pasted text into rows.
+func parseQuickAdd(text string) []QuickAddRow {
+	var rows []QuickAddRow
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		row := QuickAddRow{Line: i + 1}
+		r := csv.NewReader(strings.NewReader(line))
+		r.Comma = quickAddDelimiter(line)
+		r.LazyQuotes = true
+		r.FieldsPerRecord = -1
+		cells, err := r.Read()
+		if err != nil {
+			row.Error = fmt.Sprintf("cannot read the line: %v", err)
+			rows = append(rows, row)
+			continue
+		}
+		for j := range cells {
+			cells[j] = strings.TrimSpace(cells[j])
+		}
+		if len(rows) == 0 && len(cells) >= 3 && strings.EqualFold(cells[2], "vlan") {
+			continue
+		}
+		fields := []*string{&row.Site, &row.VRF, &row.VLAN, &row.Name, &row.Hosts}
+		for j := 0; j < len(cells) && j < len(fields); j++ {
+			*fields[j] = cells[j]
+		}
+		if len(cells) < 4 || len(cells) > 5 {
+			row.Error = fmt.Sprintf("expected 4 or 5 columns (site, vrf, vlan, name, hosts), got %d", len(cells))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// previewQuickAdd parses text and checks every row against the project.
+func previewQuickAdd(db sqlConn, projectID int64, sites []Site, text string) *QuickAddPreview {
+	p := &QuickAddPreview{Text: text, Rows: parseQuickAdd(text)}
+	switch {
+	case len(p.Rows) == 0:
+		p.Error = "Nothing to add: paste one segment per line."
+		return p
+	case len(p.Rows) > quickAddMaxRows:
+		p.Error = fmt.Sprintf("Too many rows: %d, at most %d at once.", len(p.Rows), quickAddMaxRows)
+		return p
+	}
+	siteIDs := map[string]int64{}
+	for _, s := range sites {
+		siteIDs[strings.ToLower(s.Name)] = s.ID
+	}
+	rules, _ := getProjectRules(db, projectID)
+	known, _ := projectVRFs(db, projectID, 0)
+	seen := map[string]int{}
+	for i := range p.Rows {
+		row := &p.Rows[i]
+		if row.Error == "" {
+			row.Error = checkQuickAddRow(db, projectID, row, rules, known, siteIDs, seen)
+		}
+		if row.Error != "" {
+			p.Invalid++
+			continue
+		}
+		p.Valid++
+		known[row.VRF] = true
+	}
+	if p.Invalid == 0 {
+		if err := checkSegmentQuota(db, projectID, p.Valid); err != nil {
+			p.Error = err.Error()
+		}
+	}
+	return p
+}
+
+func checkQuickAddRow(db sqlConn, projectID int64, row *QuickAddRow, rules ProjectRules, known map[string]bool, siteIDs map[string]int64, seen map[string]int) string {
+	siteID, ok := siteIDs[strings.ToLower(row.Site)]
+	if !ok {
+		if row.Site == "" {
+			return "site is required"
+		}
+		return fmt.Sprintf("no site %q in this project", row.Site)
+	}
+	row.siteID = siteID
+	if row.VRF == "" {
+		row.VRF = rules.DefaultVRF
+	}
+	if row.VRF == "" {
+		return "VRF is required (the project has no default VRF)"
+	}
+	vlan, err := strconv.Atoi(row.VLAN)
+	if err != nil {
+		return fmt.Sprintf("VLAN %q is not a number", row.VLAN)
+	}
+	row.vlan = vlan
+	if row.Name == "" {
+		return "name is required"
+	}
+	if row.Hosts != "" {
+		hosts, err := strconv.ParseInt(row.Hosts, 10, 64)
+		if err != nil || hosts < 1 || hosts > maxFormInt {
+			return fmt.Sprintf("hosts %q must be a positive whole number", row.Hosts)
+		}
+		row.hosts = sql.NullInt64{Int64: hosts, Valid: true}
+	}
+	warnings, err := checkSegmentRules(rules, row.VRF, vlan, known)
+	if err != nil {
+		return err.Error()
+	}
+	row.Warnings = warnings
+	key := fmt.Sprintf("%d|%s|%d|%s", siteID, row.VRF, vlan, row.Name)
+	if line, dup := seen[key]; dup {
+		return fmt.Sprintf("repeats line %d", line)
+	}
+	seen[key] = row.Line
+	if _, exists, err := findSegmentID(db, siteID, row.VRF, vlan, row.Name); err != nil {
+		return err.Error()
+	} else if exists {
+		return "segment already exists"
+	}
+	if rules.Unique(UniqueSegmentNames) {
+		nameKey := fmt.Sprintf("name|%s|%s", row.VRF, strings.ToLower(row.Name))
+		if line, dup := seen[nameKey]; dup {
+			return fmt.Sprintf("segment name %q is already used in VRF %s on line %d", row.Name, row.VRF, line)
+		}
+		seen[nameKey] = row.Line
+		if err := checkSegmentNameUnique(db, rules, projectID, row.VRF, row.Name, 0); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// applyQuickAdd creates the rows of a ready preview in one transaction and
+// returns the new segment IDs.
+func applyQuickAdd(db *sql.DB, p *QuickAddPreview) ([]int64, error) {
+	if !p.Ready() {
+		return nil, fmt.Errorf("the paste has rejected rows")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	ids := make([]int64, 0, len(p.Rows))
+	for _, row := range p.Rows {
+		res, err := tx.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, ?, ?, ?, ?)`,
+			row.siteID, row.VRF, row.vlan, row.Name, nullIntToAny(row.hosts))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
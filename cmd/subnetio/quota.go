@@ -0,0 +1,213 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+// ProjectQuota caps how much of a shared instance one project can take.
+type ProjectQuota struct {
+	ProjectID        int64 `json:"project_id"`
+	MaxSegments      int   `json:"max_segments"`
+	MaxIPv4Addresses int64 `json:"max_ipv4_addresses"`
+	MaxIPv6Prefix    int   `json:"max_ipv6_prefix"`
+}
+
+// QuotaUsage is what a project holds: its segments and the addresses of
+// their assigned prefixes, secondary networks included.
+type QuotaUsage struct {
+	Segments int
+	IPv4     *big.Int
+	IPv6     *big.Int
+}
+
+// quotaError marks a change refused by the project's quota, so it can be
+// shown to the user instead of a 500.
+type quotaError struct {
+	Message string
+}
+
+func (e *quotaError) Error() string {
+	return e.Message
+}
+
+func isQuotaError(err error) bool {
+	var qerr *quotaError
+	return errors.As(err, &qerr)
+}
+
+func newQuotaUsage() QuotaUsage {
+	return QuotaUsage{IPv4: new(big.Int), IPv6: new(big.Int)}
+}
+
+func (u QuotaUsage) clone() QuotaUsage {
+	return QuotaUsage{Segments: u.Segments, IPv4: new(big.Int).Set(u.IPv4), IPv6: new(big.Int).Set(u.IPv6)}
+}
+
+// add counts one segment's prefixes; sign -1 takes them away again.
+func (u *QuotaUsage) add(cidr, cidrV6, secondary string, sign int) {
+	u.Segments += sign
+	count := func(raw string) {
+		p, err := netip.ParsePrefix(strings.TrimSpace(raw))
+		if err != nil {
+			return
+		}
+		size := prefixSize(p.Masked())
+		if sign < 0 {
+			size.Neg(size)
+		}
+		if p.Addr().Is4() {
+			u.IPv4.Add(u.IPv4, size)
+		} else {
+			u.IPv6.Add(u.IPv6, size)
+		}
+	}
+	count(cidr)
+	count(cidrV6)
+	for _, part := range strings.Split(secondary, ",") {
+		count(part)
+	}
+}
+
+func (u QuotaUsage) IPv4Text() string { return formatBigInt(u.IPv4) }
+func (u QuotaUsage) IPv6Text() string { return formatBigInt(u.IPv6) }
+
+func (q ProjectQuota) empty() bool {
+	return q.MaxSegments == 0 && q.MaxIPv4Addresses == 0 && q.MaxIPv6Prefix == 0
+}
+
+func (q ProjectQuota) ipv6Limit() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(128-q.MaxIPv6Prefix))
+}
+
+// checkGrowth refuses a change from before to after that leaves the project
+// over a limit.
+func (q ProjectQuota) checkGrowth(before, after QuotaUsage) error {
+	var over []string
+	if q.MaxSegments > 0 && after.Segments > q.MaxSegments && after.Segments > before.Segments {
+		over = append(over, fmt.Sprintf("%d segments (limit %d)", after.Segments, q.MaxSegments))
+	}
+	if q.MaxIPv4Addresses > 0 && after.IPv4.Cmp(big.NewInt(q.MaxIPv4Addresses)) > 0 && after.IPv4.Cmp(before.IPv4) > 0 {
+		over = append(over, fmt.Sprintf("%s IPv4 addresses (limit %s)", formatBigInt(after.IPv4), formatBigInt(big.NewInt(q.MaxIPv4Addresses))))
+	}
+	if q.MaxIPv6Prefix > 0 && after.IPv6.Cmp(q.ipv6Limit()) > 0 && after.IPv6.Cmp(before.IPv6) > 0 {
+		over = append(over, fmt.Sprintf("%s IPv6 addresses (limit one /%d)", formatBigInt(after.IPv6), q.MaxIPv6Prefix))
+	}
+	if len(over) == 0 {
+		return nil
+	}
+	return &quotaError{Message: "project quota exceeded: " + strings.Join(over, ", ")}
+}
+
+func getProjectQuota(db sqlConn, projectID int64) (ProjectQuota, bool) {
+	q := ProjectQuota{ProjectID: projectID}
+	err := db.QueryRow(`
+		SELECT max_segments, max_ipv4_addresses, max_ipv6_prefix
+		FROM project_quotas WHERE project_id=?`, projectID,
+	).Scan(&q.MaxSegments, &q.MaxIPv4Addresses, &q.MaxIPv6Prefix)
+	if err != nil || q.empty() {
+		return ProjectQuota{ProjectID: projectID}, false
+	}
+	return q, true
+}
+
+func validateProjectQuota(q ProjectQuota) error {
+	if q.MaxSegments < 0 {
+		return errors.New("max segments must not be negative")
+	}
+	if q.MaxIPv4Addresses < 0 || q.MaxIPv4Addresses > 1<<32 {
+		return errors.New("max IPv4 addresses must be between 0 and 4294967296")
+	}
+	if q.MaxIPv6Prefix < 0 || q.MaxIPv6Prefix > 128 {
+		return errors.New("max IPv6 space must be a prefix length between 1 and 128")
+	}
+	return nil
+}
+
+func saveProjectQuota(db *sql.DB, q ProjectQuota) error {
+	if err := validateProjectQuota(q); err != nil {
+		return err
+	}
+	if q.empty() {
+		return deleteProjectQuota(db, q.ProjectID)
+	}
+	_, err := db.Exec(`
+		INSERT INTO project_quotas(project_id, max_segments, max_ipv4_addresses, max_ipv6_prefix)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET
+			max_segments=excluded.max_segments,
+			max_ipv4_addresses=excluded.max_ipv4_addresses,
+			max_ipv6_prefix=excluded.max_ipv6_prefix`,
+		q.ProjectID, q.MaxSegments, q.MaxIPv4Addresses, q.MaxIPv6Prefix,
+	)
+	return err
+}
+
+func deleteProjectQuota(db *sql.DB, projectID int64) error {
+	_, err := db.Exec(`DELETE FROM project_quotas WHERE project_id=?`, projectID)
+	return err
+}
+
+func projectQuotaUsage(db sqlConn, projectID int64) (QuotaUsage, error) {
+	usage := newQuotaUsage()
+	rows, err := db.Query(`
+		SELECT COALESCE(s.cidr, ''), COALESCE(s.cidr_v6, ''), COALESCE(s.secondary_cidrs, '')
+		FROM segments s
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		WHERE ps.project_id=?`, projectID)
+	if err != nil {
+		return usage, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cidr, cidrV6, secondary string
+		if err := rows.Scan(&cidr, &cidrV6, &secondary); err != nil {
+			return usage, err
+		}
+		usage.add(cidr, cidrV6, secondary, 1)
+	}
+	return usage, rows.Err()
+}
+
+// checkSegmentQuota is called before n segments without prefixes are added.
+func checkSegmentQuota(db sqlConn, projectID int64, n int) error {
+	q, ok := getProjectQuota(db, projectID)
+	if !ok || q.MaxSegments == 0 {
+		return nil
+	}
+	before, err := projectQuotaUsage(db, projectID)
+	if err != nil {
+		return err
+	}
+	after := before.clone()
+	after.Segments += n
+	return q.checkGrowth(before, after)
+}
+
+// quotaTracker follows a project's usage through a plan import, so rows are
+// checked without reading every segment again.
+type quotaTracker struct {
+	quota ProjectQuota
+	usage QuotaUsage
+}
+
+// apply checks a segment row that replaces old prefixes (empty for a new
+// segment) with new ones and records it when it fits.
+func (t *quotaTracker) apply(isNew bool, old, next [3]string) error {
+	after := t.usage.clone()
+	if !isNew {
+		after.add(old[0], old[1], old[2], -1)
+	}
+	after.add(next[0], next[1], next[2], 1)
+	if err := t.quota.checkGrowth(t.usage, after); err != nil {
+		return err
+	}
+	t.usage = after
+	return nil
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const rdapTimeout = 10 * time.Second
+
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+type PoolRDAP struct {
+	CIDR         string
+	Handle       string
+	Name         string
+	Org          string
+	Country      string
+	RegisteredAt string
+	StartAddress string
+	EndAddress   string
+	Error        string
+	FetchedAt    string
+	Mismatch     bool
+}
+
+type rdapResponse struct {
+	Handle       string       `json:"handle"`
+	Name         string       `json:"name"`
+	Country      string       `json:"country"`
+	StartAddress string       `json:"startAddress"`
+	EndAddress   string       `json:"endAddress"`
+	Events       []rdapEvent  `json:"events"`
+	Entities     []rdapEntity `json:"entities"`
+}
+
+type rdapEvent struct {
+	Action string `json:"eventAction"`
+	Date   string `json:"eventDate"`
+}
+
+type rdapEntity struct {
+	Roles      []string        `json:"roles"`
+	VCardArray json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity    `json:"entities"`
+}
+
+func isPublicPrefix(p netip.Prefix) bool {
+	addr := p.Masked().Addr()
+	if addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	if addr.Is6() && !netip.MustParsePrefix("2000::/3").Contains(addr) {
+		return false
+	}
+	for _, np := range nonPublicPrefixes {
+		if np.Overlaps(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func rdapBaseURL() string {
+	return strings.TrimRight(mustEnv("RDAP_BASE_URL", "https://rdap.org"), "/")
+}
+
+func rdapOrgNames() []string {
+	return splitCSV(mustEnv("RDAP_ORG", ""))
+}
+
+func fetchRDAP(client *http.Client, baseURL string, prefix netip.Prefix) (PoolRDAP, error) {
+	out := PoolRDAP{CIDR: prefix.String()}
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/ip/"+prefix.Masked().String(), nil)
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("Accept", "application/rdap+json, application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("rdap: unexpected status %d", resp.StatusCode)
+	}
+	var payload rdapResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&payload); err != nil {
+		return out, fmt.Errorf("rdap: decode: %w", err)
+	}
+	out.Handle = payload.Handle
+	out.Name = payload.Name
+	out.Country = payload.Country
+	out.StartAddress = payload.StartAddress
+	out.EndAddress = payload.EndAddress
+	for _, ev := range payload.Events {
+		if ev.Action == "registration" {
+			out.RegisteredAt = ev.Date
+			break
+		}
+	}
+	out.Org = rdapRegistrant(payload.Entities)
+	return out, nil
+}
+
+func rdapRegistrant(entities []rdapEntity) string {
+	for _, e := range entities {
+		for _, role := range e.Roles {
+			if role == "registrant" {
+				if fn := vcardFN(e.VCardArray); fn != "" {
+					return fn
+				}
+			}
+		}
+	}
+	for _, e := range entities {
+		if org := rdapRegistrant(e.Entities); org != "" {
+			return org
+		}
+	}
+	return ""
+}
+
+func vcardFN(raw json.RawMessage) string {
+	var card []any
+	if err := json.Unmarshal(raw, &card); err != nil || len(card) < 2 {
+		return ""
+	}
+	props, ok := card[1].([]any)
+	if !ok {
+		return ""
+	}
+	for _, prop := range props {
+		fields, ok := prop.([]any)
+		if !ok || len(fields) < 4 {
+			continue
+		}
+		if name, _ := fields[0].(string); name == "fn" {
+			value, _ := fields[3].(string)
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func rdapMismatch(info PoolRDAP, orgNames []string) bool {
+	if len(orgNames) == 0 || info.Error != "" {
+		return false
+	}
+	haystack := strings.ToLower(info.Org + " " + info.Name + " " + info.Handle)
+	for _, name := range orgNames {
+		if strings.Contains(haystack, strings.ToLower(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func refreshPoolRDAP(db *sql.DB, pool Pool) (PoolRDAP, error) {
+	prefix, err := netip.ParsePrefix(pool.CIDR)
+	if err != nil {
+		return PoolRDAP{}, err
+	}
+	if !isPublicPrefix(prefix) {
+		return PoolRDAP{}, fmt.Errorf("pool %s is not public address space", pool.CIDR)
+	}
+	client := &http.Client{Timeout: rdapTimeout}
+	info, fetchErr := fetchRDAP(client, rdapBaseURL(), prefix)
+	if fetchErr != nil {
+		info.Error = fetchErr.Error()
+	}
+	info.FetchedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := savePoolRDAP(db, info); err != nil {
+		return info, err
+	}
+	return info, fetchErr
+}
+
+func savePoolRDAP(db *sql.DB, info PoolRDAP) error {
+	_, err := db.Exec(`
+		INSERT INTO pool_rdap(cidr, handle, name, org, country, registered_at, start_address, end_address, error, fetched_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(cidr) DO UPDATE SET
+			handle=excluded.handle,
+			name=excluded.name,
+			org=excluded.org,
+			country=excluded.country,
+			registered_at=excluded.registered_at,
+			start_address=excluded.start_address,
+			end_address=excluded.end_address,
+			error=excluded.error,
+			fetched_at=excluded.fetched_at`,
+		info.CIDR,
+		nullStringToAny(info.Handle),
+		nullStringToAny(info.Name),
+		nullStringToAny(info.Org),
+		nullStringToAny(info.Country),
+		nullStringToAny(info.RegisteredAt),
+		nullStringToAny(info.StartAddress),
+		nullStringToAny(info.EndAddress),
+		nullStringToAny(info.Error),
+		info.FetchedAt,
+	)
+	return err
+}
+
+func listPoolRDAP(db *sql.DB, pools []Pool) (map[string]PoolRDAP, error) {
+	out := map[string]PoolRDAP{}
+	if len(pools) == 0 {
+		return out, nil
+	}
+	orgNames := rdapOrgNames()
+	for _, pool := range pools {
+		var info PoolRDAP
+		var handle, name, org, country, registered, start, end, errText sql.NullString
+		err := db.QueryRow(`
+			SELECT cidr, handle, name, org, country, registered_at, start_address, end_address, error, fetched_at
+			FROM pool_rdap
+			WHERE cidr=?
+		`, pool.CIDR).Scan(&info.CIDR, &handle, &name, &org, &country, &registered, &start, &end, &errText, &info.FetchedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.Handle = handle.String
+		info.Name = name.String
+		info.Org = org.String
+		info.Country = country.String
+		info.RegisteredAt = registered.String
+		info.StartAddress = start.String
+		info.EndAddress = end.String
+		info.Error = errText.String
+		info.Mismatch = rdapMismatch(info, orgNames)
+		out[pool.CIDR] = info
+	}
+	return out, nil
+}
+
+func publicPoolIDs(pools []Pool) map[int64]bool {
+	out := map[int64]bool{}
+	for _, p := range pools {
+		if prefix, err := netip.ParsePrefix(p.CIDR); err == nil && isPublicPrefix(prefix) {
+			out[p.ID] = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// ReverseZone is one in-addr.arpa / ip6.arpa zone that the parent zone has
+// to delegate to a site's DNS servers.
+type ReverseZone struct {
+	Zone     string   `json:"zone"`
+	Family   string   `json:"family"`
+	Prefix   string   `json:"prefix"`
+	Site     string   `json:"site"`
+	Segments []string `json:"segments"`
+	Servers  []string `json:"servers"`
+	Delegate bool     `json:"delegate"`
+	Note     string   `json:"note,omitempty"`
+}
+
+// reverseZonePrefixes maps an allocated prefix onto delegable zones: octet
+// boundaries for IPv4, nibble boundaries for IPv6.
+func reverseZonePrefixes(p netip.Prefix) []netip.Prefix {
+	p = p.Masked()
+	step, limit := 8, 24
+	if p.Addr().Is6() {
+		step, limit = 4, 64
+	}
+	bits := p.Bits()
+	if bits%step == 0 {
+		return []netip.Prefix{p}
+	}
+	if bits > limit {
+		zone, _ := p.Addr().Prefix(bits - bits%step)
+		return []netip.Prefix{zone}
+	}
+	zoneBits := bits + step - bits%step
+	count := 1 << (zoneBits - bits)
+	width := addrBitLen(p.Addr())
+	start := addrToBig(p.Addr())
+	size := new(big.Int).Lsh(big.NewInt(1), uint(width-zoneBits))
+	out := make([]netip.Prefix, 0, count)
+	for i := 0; i < count; i++ {
+		addr, ok := bigToAddr(new(big.Int).Add(start, new(big.Int).Mul(size, big.NewInt(int64(i)))), width)
+		if !ok {
+			break
+		}
+		out = append(out, netip.PrefixFrom(addr, zoneBits))
+	}
+	return out
+}
+
+func reverseZoneName(p netip.Prefix) string {
+	p = p.Masked()
+	var labels []string
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		for i := p.Bits()/8 - 1; i >= 0; i-- {
+			labels = append(labels, itoa(int(b[i])))
+		}
+		return strings.Join(append(labels, "in-addr.arpa"), ".")
+	}
+	b := p.Addr().As16()
+	for i := p.Bits()/4 - 1; i >= 0; i-- {
+		nibble := b[i/2] >> 4
+		if i%2 == 1 {
+			nibble = b[i/2] & 0x0f
+		}
+		labels = append(labels, fmt.Sprintf("%x", nibble))
+	}
+	return strings.Join(append(labels, "ip6.arpa"), ".")
+}
+
+// buildReverseDelegations lists the reverse zones of every allocated segment.
+func buildReverseDelegations(segs []Segment, sites []Site, meta ProjectMeta) []ReverseZone {
+	servers := map[string][]string{}
+	projectDNS := parseList(meta.DNS)
+	for _, s := range sites {
+		list := parseList(s.DNS)
+		if len(list) == 0 {
+			list = projectDNS
+		}
+		servers[s.Name] = list
+	}
+
+	type zoneAcc struct {
+		prefix   netip.Prefix
+		sites    map[string]bool
+		segments []string
+	}
+	zones := map[string]*zoneAcc{}
+	add := func(cidr string, s Segment) {
+		p, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return
+		}
+		for _, zp := range reverseZonePrefixes(p) {
+			name := reverseZoneName(zp)
+			acc, ok := zones[name]
+			if !ok {
+				acc = &zoneAcc{prefix: zp, sites: map[string]bool{}}
+				zones[name] = acc
+			}
+			acc.sites[s.Site] = true
+			acc.segments = append(acc.segments, fmt.Sprintf("%s/%s/vlan%d %s (%s)", s.Site, s.VRF, s.VLAN, s.Name, p.Masked()))
+		}
+	}
+	for _, s := range segs {
+		if s.CIDR.Valid {
+			add(s.CIDR.String, s)
+		}
+		for _, p := range segmentSecondaryPrefixes(s) {
+			add(p.String(), s)
+		}
+		if s.CIDRV6.Valid {
+			add(s.CIDRV6.String, s)
+		}
+	}
+
+	out := make([]ReverseZone, 0, len(zones))
+	for name, acc := range zones {
+		z := ReverseZone{Zone: name, Family: "ipv4", Prefix: acc.prefix.String(), Segments: acc.segments}
+		if acc.prefix.Addr().Is6() {
+			z.Family = "ipv6"
+		}
+		owners := make([]string, 0, len(acc.sites))
+		for site := range acc.sites {
+			owners = append(owners, site)
+		}
+		sort.Strings(owners)
+		switch {
+		case len(owners) > 1:
+			z.Site = strings.Join(owners, ", ")
+			z.Note = "shared by several sites; keep it in a central zone or use RFC 2317 classless delegation"
+		case len(servers[owners[0]]) == 0:
+			z.Site = owners[0]
+			z.Note = "no DNS servers set for the site or project"
+		default:
+			z.Site = owners[0]
+			z.Servers = servers[owners[0]]
+			z.Delegate = true
+		}
+		out = append(out, z)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Family != out[j].Family {
+			return out[i].Family < out[j].Family
+		}
+		a, b := netip.MustParsePrefix(out[i].Prefix), netip.MustParsePrefix(out[j].Prefix)
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		return a.Bits() < b.Bits()
+	})
+	return out
+}
+
+// reverseNSName returns the NS target for a configured server.
+func reverseNSName(server, site, domain string, index int) (name string, addr netip.Addr) {
+	server = strings.TrimSuffix(strings.TrimSpace(server), ".")
+	ip, err := netip.ParseAddr(server)
+	if err != nil {
+		return server + ".", netip.Addr{}
+	}
+	if domain == "" {
+		domain = "invalid"
+	}
+	return fmt.Sprintf("ns%d.%s.%s.", index+1, safeName(site), strings.TrimSuffix(domain, ".")), ip
+}
+
+func renderReverseBIND(zones []ReverseZone, domain string) string {
+	var b strings.Builder
+	b.WriteString("; Reverse zone delegations generated by Subnetio.\n")
+	b.WriteString("; Add the NS records to the parent in-addr.arpa / ip6.arpa zone.\n")
+	var glue []string
+	seen := map[string]bool{}
+	for _, z := range zones {
+		fmt.Fprintf(&b, "\n; %s %s\n", z.Prefix, z.Site)
+		if !z.Delegate {
+			fmt.Fprintf(&b, "; not delegated: %s\n", z.Note)
+			continue
+		}
+		for i, server := range z.Servers {
+			name, ip := reverseNSName(server, z.Site, domain, i)
+			fmt.Fprintf(&b, "%s.\tIN\tNS\t%s\n", z.Zone, name)
+			if ip.IsValid() && !seen[name] {
+				seen[name] = true
+				rr := "A"
+				if ip.Is6() {
+					rr = "AAAA"
+				}
+				glue = append(glue, fmt.Sprintf("%s\tIN\t%s\t%s", name, rr, ip))
+			}
+		}
+	}
+	if len(glue) > 0 {
+		b.WriteString("\n; Address records for the name servers above (forward zone):\n")
+		for _, line := range glue {
+			b.WriteString("; " + line + "\n")
+		}
+	}
+	if domain == "" && len(glue) > 0 {
+		b.WriteString("; Set the project domain name to get real name server names instead of .invalid.\n")
+	}
+	return b.String()
+}
+
+func writeReverseCSV(w io.Writer, zones []ReverseZone) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"zone", "family", "prefix", "site", "dns_servers", "delegate", "segments", "note"})
+	for _, z := range zones {
+		delegate := "no"
+		if z.Delegate {
+			delegate = "yes"
+		}
+		_ = cw.Write([]string{z.Zone, z.Family, z.Prefix, z.Site, strings.Join(z.Servers, " "), delegate, strings.Join(z.Segments, "; "), z.Note})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditActionRead = "read"
+
+// readAuditRoutes are the downloads outside /export/ that are audited.
+var readAuditRoutes = map[string]string{
+	"/generate/download":         "config",
+	"/generate/bundle":           "config",
+	"/generate/bundle/all":       "config",
+	"/generate/history/download": "config",
+	"/api/artifacts/:id/latest":  "config",
+}
+
+func readAuditKind(route string) (string, bool) {
+	if kind, ok := readAuditRoutes[route]; ok {
+		return kind, true
+	}
+	if strings.HasPrefix(route, "/export/audit/") {
+		return "audit_log", true
+	}
+	if strings.HasPrefix(route, "/export/") {
+		return "export", true
+	}
+	return "", false
+}
+
+// auditReadScope is the after payload of a read entry.
+type auditReadScope struct {
+	Path   string `json:"path"`
+	Query  string `json:"query,omitempty"`
+	Status int    `json:"status"`
+	Bytes  int    `json:"bytes"`
+}
+
+func setProjectAuditReads(db *sql.DB, projectID int64, enabled bool) error {
+	_, err := db.Exec(`UPDATE projects SET audit_reads=? WHERE id=?`, enabled, projectID)
+	return err
+}
+
+// readAuditProjectID finds the project a download belongs to: the project
+// of the artifact or stored generation, else the active project.
+func readAuditProjectID(c *gin.Context, db *sql.DB, defaultProjectID int64) int64 {
+	switch c.FullPath() {
+	case "/api/artifacts/:id/latest":
+		if s, ok := generationScheduleByID(db, parseProjectID(c.Param("id"))); ok {
+			return s.ProjectID
+		}
+		return 0
+	case "/generate/history/download":
+		if g, ok := generationByID(db, parseProjectID(c.Query("id"))); ok {
+			return g.ProjectID
+		}
+		return 0
+	}
+	return resolveActiveProjectID(c, db, defaultProjectID)
+}
+
+// readAuditMiddleware writes a read entry after every successful download
+// of a project that has read auditing on.
+func readAuditMiddleware(db *sql.DB, defaultProjectID int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kind, ok := readAuditKind(c.FullPath())
+		if c.Request.Method != http.MethodGet || !ok {
+			c.Next()
+			return
+		}
+		c.Next()
+		status := c.Writer.Status()
+		if status < 200 || status >= 300 {
+			return
+		}
+		p, ok := projectByID(db, readAuditProjectID(c, db, defaultProjectID))
+		if !ok || !p.AuditReads {
+			return
+		}
+		writeAudit(db, c, auditRecord{
+			ProjectID:   p.ID,
+			Action:      auditActionRead,
+			EntityType:  kind,
+			EntityLabel: sql.NullString{String: c.Request.URL.Path, Valid: true},
+			After: auditReadScope{
+				Path:   c.Request.URL.Path,
+				Query:  c.Request.URL.RawQuery,
+				Status: status,
+				Bytes:  c.Writer.Size(),
+			},
+		})
+	}
+}
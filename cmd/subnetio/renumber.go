@@ -0,0 +1,309 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RenumberRequest struct {
+	Site     string
+	OldRaw   string
+	Old      netip.Prefix
+	Targets  []string
+	Template string
+}
+
+func renumberRequestFromQuery(c *gin.Context) RenumberRequest {
+	req := RenumberRequest{
+		Site:     strings.TrimSpace(c.Query("site")),
+		OldRaw:   strings.TrimSpace(c.Query("old")),
+		Template: strings.ToLower(strings.TrimSpace(c.Query("template"))),
+	}
+	for _, raw := range c.QueryArray("target") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			req.Targets = append(req.Targets, raw)
+		}
+	}
+	return req
+}
+
+// RenumberMapping is one row of the old→new table.
+type RenumberMapping struct {
+	Site         string `json:"site"`
+	VRF          string `json:"vrf"`
+	VLAN         int    `json:"vlan"`
+	Name         string `json:"name"`
+	Locked       bool   `json:"locked,omitempty"`
+	OldCIDR      string `json:"old_cidr"`
+	NewCIDR      string `json:"new_cidr,omitempty"`
+	TargetPool   string `json:"target_pool,omitempty"`
+	OldGateway   string `json:"old_gateway,omitempty"`
+	NewGateway   string `json:"new_gateway,omitempty"`
+	OldDhcpRange string `json:"old_dhcp_range,omitempty"`
+	NewDhcpRange string `json:"new_dhcp_range,omitempty"`
+	Reservations int    `json:"mac_reservations,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type RenumberPlan struct {
+	Request  RenumberRequest
+	SiteID   int64
+	Family   string
+	Pools    []Pool
+	Mappings []RenumberMapping
+	Unplaced int
+	moves    []PoolMigrationMove
+}
+
+// Selected reports whether a candidate pool is one of the targets.
+func (p RenumberPlan) Selected(cidr string) bool {
+	if len(p.Request.Targets) == 0 {
+		return true
+	}
+	for _, t := range p.Request.Targets {
+		if t == cidr {
+			return true
+		}
+	}
+	return false
+}
+
+// planRenumbering maps the site's segments in the old space to the target
+// pools.
+func planRenumbering(db *sql.DB, projectID int64, req RenumberRequest) (RenumberPlan, error) {
+	plan := RenumberPlan{Request: req}
+	if req.Site == "" {
+		return plan, errors.New("site is required")
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return plan, err
+	}
+	for _, s := range sites {
+		if strings.EqualFold(s.Name, req.Site) {
+			plan.SiteID, plan.Request.Site = s.ID, s.Name
+		}
+	}
+	if plan.SiteID == 0 {
+		return plan, fmt.Errorf("unknown site %q", req.Site)
+	}
+	if req.OldRaw == "" {
+		return plan, errors.New("old address space is required")
+	}
+	old, err := netip.ParsePrefix(req.OldRaw)
+	if err != nil {
+		return plan, fmt.Errorf("invalid old address space %q", req.OldRaw)
+	}
+	plan.Request.Old = old.Masked()
+	plan.Family = "ipv4"
+	if old.Addr().Is6() {
+		plan.Family = "ipv6"
+	}
+	pools, err := poolsBySite(db, plan.SiteID)
+	if err != nil {
+		return plan, err
+	}
+	var targets []poolItem
+	for _, item := range poolItemsForFamily(pools, plan.Family) {
+		if prefixesOverlap(item.Prefix, plan.Request.Old) {
+			continue
+		}
+		plan.Pools = append(plan.Pools, item.Pool)
+		if plan.Selected(item.Prefix.String()) {
+			targets = append(targets, item)
+		}
+	}
+	for _, t := range req.Targets {
+		found := false
+		for _, p := range plan.Pools {
+			found = found || p.CIDR == t
+		}
+		if !found {
+			return plan, fmt.Errorf("%s is not a pool of site %s outside %s", t, plan.Request.Site, plan.Request.Old)
+		}
+	}
+	if len(targets) == 0 {
+		return plan, fmt.Errorf("site %s has no %s pool outside %s; add the new space as a pool first", plan.Request.Site, plan.Family, plan.Request.Old)
+	}
+	plan.moves, err = proposeSegmentMoves(db, plan.SiteID, plan.Request.Old, plan.Family, targets, pools)
+	if err != nil {
+		return plan, err
+	}
+	for _, m := range plan.moves {
+		row := RenumberMapping{
+			Site:         m.Segment.Site,
+			VRF:          m.Segment.VRF,
+			VLAN:         m.Segment.VLAN,
+			Name:         m.Segment.Name,
+			Locked:       m.Segment.Locked,
+			OldCIDR:      m.From.String(),
+			TargetPool:   m.Target,
+			OldGateway:   m.GatewayFrom,
+			NewGateway:   m.GatewayTo,
+			OldDhcpRange: m.DhcpFrom,
+			NewDhcpRange: m.DhcpTo,
+			Reservations: m.Reservations,
+			Error:        m.Error,
+		}
+		if m.To.IsValid() {
+			row.NewCIDR = m.To.String()
+		} else {
+			plan.Unplaced++
+		}
+		plan.Mappings = append(plan.Mappings, row)
+	}
+	return plan, nil
+}
+
+// renumbered returns segs with the planned prefixes and the stored gateways
+// and DHCP ranges moved along.
+func (p RenumberPlan) renumbered(segs []Segment) []Segment {
+	moves := map[int64]PoolMigrationMove{}
+	for _, m := range p.moves {
+		if m.To.IsValid() {
+			moves[m.Segment.ID] = m
+		}
+	}
+	out := make([]Segment, len(segs))
+	copy(out, segs)
+	for i, s := range out {
+		m, ok := moves[s.ID]
+		if !ok {
+			continue
+		}
+		if p.Family == "ipv6" {
+			out[i].CIDRV6 = sql.NullString{String: m.To.String(), Valid: true}
+			if s.GatewayV6.Valid {
+				out[i].GatewayV6.String = translateAddrString(s.GatewayV6.String, m.From, m.To)
+			}
+			continue
+		}
+		out[i].CIDR = sql.NullString{String: m.To.String(), Valid: true}
+		if s.Gateway.Valid {
+			out[i].Gateway.String = translateAddrString(s.Gateway.String, m.From, m.To)
+		}
+		if m.DhcpFrom != "" {
+			out[i].DhcpRange.String = m.DhcpTo
+		}
+	}
+	return out
+}
+
+// renderRenumberedConfigs renders a template for the site against the
+// current and the renumbered plan.
+func renderRenumberedConfigs(db *sql.DB, projectID int64, plan RenumberPlan, template string) (string, string, error) {
+	opts := GenerateOptions{
+		Template:    template,
+		IncludeVRF:  true,
+		IncludeVLAN: true,
+		IncludeDHCP: true,
+		SiteFilter:  plan.Request.Site,
+		Family:      plan.Family,
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return "", "", err
+	}
+	views, sites, project, meta := generateInputsFor(db, projectID, segs)
+	before, err := generateConfig(opts, views, sites, project, meta)
+	if err != nil {
+		return "", "", err
+	}
+	views, sites, project, meta = generateInputsFor(db, projectID, plan.renumbered(segs))
+	after, err := generateConfig(opts, views, sites, project, meta)
+	if err != nil {
+		return "", "", err
+	}
+	return before.Output, after.Output, nil
+}
+
+// renderRenumberMapping writes the mapping table as csv or json.
+func renderRenumberMapping(plan RenumberPlan, format string) (string, string, error) {
+	switch format {
+	case "", "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		_ = w.Write([]string{"site", "vrf", "vlan", "name", "locked", "old_cidr", "new_cidr", "target_pool",
+			"old_gateway", "new_gateway", "old_dhcp_range", "new_dhcp_range", "mac_reservations", "error"})
+		for _, m := range plan.Mappings {
+			_ = w.Write([]string{m.Site, m.VRF, itoa(m.VLAN), m.Name, boolToString(m.Locked), m.OldCIDR, m.NewCIDR, m.TargetPool,
+				m.OldGateway, m.NewGateway, m.OldDhcpRange, m.NewDhcpRange, itoa(m.Reservations), m.Error})
+		}
+		w.Flush()
+		return b.String(), "csv", w.Error()
+	case "json":
+		out, err := json.MarshalIndent(plan.Mappings, "", "  ")
+		if err != nil {
+			return "", "", err
+		}
+		return string(out) + "\n", "json", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q (use csv, json, checklist or config)", format)
+}
+
+// renderRenumberChecklist is the cutover checklist as Markdown task lists.
+func renderRenumberChecklist(plan RenumberPlan, projectName string, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Renumbering %s: %s\n\n", plan.Request.Site, plan.Request.Old)
+	fmt.Fprintf(&b, "Project %s, generated %s. %d segments", projectName, now.UTC().Format(time.RFC3339), len(plan.Mappings))
+	if plan.Unplaced > 0 {
+		fmt.Fprintf(&b, ", %d without a new prefix", plan.Unplaced)
+	}
+	b.WriteString(".\n\n## Before cutover\n\n")
+	var targets []string
+	for _, p := range plan.Pools {
+		if plan.Selected(p.CIDR) {
+			targets = append(targets, p.CIDR)
+		}
+	}
+	fmt.Fprintf(&b, "- [ ] Route and advertise the new space: %s\n", strings.Join(targets, ", "))
+	b.WriteString("- [ ] Lower the DHCP lease time of the scopes below a day ahead\n")
+	if plan.Request.Template != "" {
+		fmt.Fprintf(&b, "- [ ] Review the renumbered %s configs\n", plan.Request.Template)
+	}
+	b.WriteString("- [ ] Update firewall rules, ACLs and DNS records that name the old prefixes\n")
+	b.WriteString("\n## Segments\n")
+	for _, m := range plan.Mappings {
+		fmt.Fprintf(&b, "\n### %s (VRF %s, VLAN %d): %s", m.Name, m.VRF, m.VLAN, m.OldCIDR)
+		if m.Error != "" {
+			fmt.Fprintf(&b, "\n\n- [ ] Blocked: %s\n", m.Error)
+			continue
+		}
+		fmt.Fprintf(&b, " → %s\n\n", m.NewCIDR)
+		if m.NewGateway != "" {
+			fmt.Fprintf(&b, "- [ ] Add gateway %s on VLAN %d next to %s\n", m.NewGateway, m.VLAN, m.OldGateway)
+		}
+		if m.OldDhcpRange != "" {
+			fmt.Fprintf(&b, "- [ ] Move the DHCP range %s to %s\n", m.OldDhcpRange, m.NewDhcpRange)
+		} else {
+			fmt.Fprintf(&b, "- [ ] Move the DHCP scope to %s\n", m.NewCIDR)
+		}
+		if m.Reservations > 0 {
+			fmt.Fprintf(&b, "- [ ] Renumber %d MAC reservations\n", m.Reservations)
+		}
+		b.WriteString("- [ ] Renumber static hosts and check they reach the new gateway\n")
+		if m.OldGateway != "" {
+			fmt.Fprintf(&b, "- [ ] Remove gateway %s\n", m.OldGateway)
+		}
+		if m.Locked {
+			b.WriteString("- [ ] Record the new prefix in Subnetio (locked: needs a reason)\n")
+		} else {
+			b.WriteString("- [ ] Record the new prefix in Subnetio\n")
+		}
+	}
+	b.WriteString("\n## After cutover\n\n")
+	fmt.Fprintf(&b, "- [ ] Withdraw %s from routing\n", plan.Request.Old)
+	b.WriteString("- [ ] Regenerate and deploy configs, then save them as deployed\n")
+	b.WriteString("- [ ] Restore the DHCP lease times\n")
+	return b.String()
+}
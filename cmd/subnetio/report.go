@@ -0,0 +1,169 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlanReport is the printable address plan summary behind /export/report.
+type PlanReport struct {
+	Project       Project
+	GeneratedAt   string
+	SiteCount     int
+	SegmentCount  int
+	Allocated     int
+	Unallocated   int
+	ConflictCount int
+	WarningCount  int
+	Capacity      CapacityReport
+	Bars          []ReportBar
+	Sites         []ReportSite
+	Conflicts     []Conflict
+}
+
+type ReportSite struct {
+	Name     string
+	Region   string
+	Segments []SegmentView
+}
+
+type ReportBar struct {
+	Label   string
+	Percent float64
+}
+
+func (b ReportBar) Width() string {
+	return strconv.FormatFloat(b.Percent, 'f', 1, 64) + "%"
+}
+
+func (b ReportBar) Class() string {
+	switch {
+	case b.Percent >= 90:
+		return "bar-high"
+	case b.Percent >= 70:
+		return "bar-mid"
+	}
+	return "bar-low"
+}
+
+func buildPlanReport(db *sql.DB, projectID int64, growthRate float64, months int) PlanReport {
+	sites, _ := listSites(db, projectID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	statuses, conflicts := analyzeAll(segs, pools, sites, rules)
+	views := buildSegmentViews(segs, statuses, pools)
+
+	report := PlanReport{
+		Project:      Project{ID: projectID},
+		GeneratedAt:  time.Now().UTC().Format("2006-01-02 15:04 UTC"),
+		SiteCount:    len(sites),
+		SegmentCount: len(segs),
+		Conflicts:    conflicts,
+		Capacity:     buildCapacityReport(segs, pools, sites, growthRate, months, 64),
+	}
+	if p, ok := projectByID(db, projectID); ok {
+		report.Project = p
+	}
+	for _, s := range segs {
+		if s.CIDR.Valid && strings.TrimSpace(s.CIDR.String) != "" {
+			report.Allocated++
+		} else {
+			report.Unallocated++
+		}
+	}
+	for _, c := range conflicts {
+		if c.Level == statusConflict.Label() {
+			report.ConflictCount++
+		} else {
+			report.WarningCount++
+		}
+	}
+	for _, p := range report.Capacity.Pools {
+		pct, _ := strconv.ParseFloat(strings.TrimSuffix(p.Utilization, "%"), 64)
+		report.Bars = append(report.Bars, ReportBar{Label: p.Site + " " + p.CIDR, Percent: pct})
+	}
+
+	index := map[string]int{}
+	for _, site := range sites {
+		index[site.Name] = len(report.Sites)
+		report.Sites = append(report.Sites, ReportSite{Name: site.Name, Region: site.Region.String})
+	}
+	for _, v := range views {
+		i, ok := index[v.Site]
+		if !ok {
+			i = len(report.Sites)
+			index[v.Site] = i
+			report.Sites = append(report.Sites, ReportSite{Name: v.Site})
+		}
+		report.Sites[i].Segments = append(report.Sites[i].Segments, v)
+	}
+	return report
+}
+
+// renderReportPDF lays the report out with the PDF standard fonts.
+func renderReportPDF(r PlanReport) []byte {
+	doc := newPDFDoc()
+	doc.heading(1, "Address plan report: "+r.Project.Name)
+	doc.text(fmt.Sprintf("Generated %s", r.GeneratedAt))
+	if r.Project.Description.Valid && r.Project.Description.String != "" {
+		doc.text(r.Project.Description.String)
+	}
+
+	doc.heading(2, "Summary")
+	doc.table([]string{"Item", "Value"}, []int{40, 60}, [][]string{
+		{"Sites", itoa(r.SiteCount)},
+		{"Segments", fmt.Sprintf("%d (%d allocated, %d unallocated)", r.SegmentCount, r.Allocated, r.Unallocated)},
+		{"IPv4 addresses", fmt.Sprintf("%s total, %s used, %s free (%s)", r.Capacity.SummaryV4.Total, r.Capacity.SummaryV4.Used, r.Capacity.SummaryV4.Free, r.Capacity.SummaryV4.Utilization)},
+		{"IPv6 addresses", fmt.Sprintf("%s used (%s)", r.Capacity.SummaryV6.Used, r.Capacity.SummaryV6.Utilization)},
+		{"Conflicts / warnings", fmt.Sprintf("%d / %d", r.ConflictCount, r.WarningCount)},
+	})
+
+	if len(r.Bars) > 0 {
+		doc.heading(2, "Pool utilization")
+		for _, b := range r.Bars {
+			doc.bar(b.Label, b.Percent)
+		}
+	}
+
+	for _, site := range r.Sites {
+		title := "Site " + site.Name
+		if site.Region != "" {
+			title += " (" + site.Region + ")"
+		}
+		doc.heading(2, title)
+		rows := make([][]string, 0, len(site.Segments))
+		for _, v := range site.Segments {
+			rows = append(rows, []string{v.VRF, itoa(v.VLAN), v.Name, v.Request, v.CIDR, v.Gateway, v.StatusLabel})
+		}
+		if len(rows) == 0 {
+			doc.text("No segments.")
+			continue
+		}
+		doc.table([]string{"VRF", "VLAN", "Name", "Request", "CIDR", "Gateway", "Status"}, []int{12, 6, 22, 10, 19, 16, 10}, rows)
+	}
+
+	doc.heading(2, "Conflicts")
+	if len(r.Conflicts) == 0 {
+		doc.text("No conflicts.")
+	} else {
+		rows := make([][]string, 0, len(r.Conflicts))
+		for _, c := range r.Conflicts {
+			rows = append(rows, []string{c.Level, c.Kind, c.Detail})
+		}
+		doc.table([]string{"Level", "Kind", "Detail"}, []int{10, 18, 72}, rows)
+	}
+
+	doc.heading(2, fmt.Sprintf("Capacity outlook (%.1f%% growth, %d months)", r.Capacity.GrowthRate, r.Capacity.Months))
+	rows := make([][]string, 0, len(r.Capacity.Pools))
+	for _, p := range r.Capacity.Pools {
+		rows = append(rows, []string{p.Site, p.CIDR, p.Used + "/" + p.Total, p.Utilization, p.Forecast})
+	}
+	doc.table([]string{"Site", "Pool", "Used/Total", "Util", "Forecast"}, []int{14, 22, 20, 8, 36}, rows)
+	return doc.bytes()
+}
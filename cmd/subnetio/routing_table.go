@@ -0,0 +1,369 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A routing table pasted from a router is the reality check for the plan:
+// routes nobody planned, and segments the network does not carry.
+const (
+	routeMatched      = "matched"
+	routeSummary      = "summary"
+	routeMoreSpecific = "more-specific"
+	routeUnknown      = "unknown"
+
+	segmentRouted     = "routed"
+	segmentSummarized = "summarized"
+	segmentNotRouted  = "not routed"
+)
+
+type RouteEntry struct {
+	VRF      string `json:"vrf,omitempty"`
+	Prefix   string `json:"prefix"`
+	Protocol string `json:"protocol,omitempty"`
+	NextHop  string `json:"next_hop,omitempty"`
+	prefix   netip.Prefix
+}
+
+type RouteCheck struct {
+	RouteEntry
+	Status   string   `json:"status"`
+	Segments []string `json:"segments,omitempty"`
+}
+
+type SegmentRouteCheck struct {
+	ID     int64  `json:"id"`
+	Site   string `json:"site"`
+	VRF    string `json:"vrf"`
+	VLAN   int    `json:"vlan"`
+	Name   string `json:"name"`
+	CIDR   string `json:"cidr"`
+	Status string `json:"status"`
+	Route  string `json:"route,omitempty"`
+}
+
+type RouteReconciliation struct {
+	Routes   []RouteCheck        `json:"routes"`
+	Segments []SegmentRouteCheck `json:"segments"`
+	Counts   map[string]int      `json:"counts"`
+	Skipped  int                 `json:"skipped"`
+}
+
+func (r RouteReconciliation) UnknownRoutes() []RouteCheck {
+	var out []RouteCheck
+	for _, route := range r.Routes {
+		if route.Status == routeUnknown {
+			out = append(out, route)
+		}
+	}
+	return out
+}
+
+func (r RouteReconciliation) UnroutedSegments() []SegmentRouteCheck {
+	var out []SegmentRouteCheck
+	for _, s := range r.Segments {
+		if s.Status == segmentNotRouted {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+var (
+	routeTableVRFHeaders = []*regexp.Regexp{
+		regexp.MustCompile(`^Routing Table:\s*(\S+)`),
+		regexp.MustCompile(`^IPv?6? ?Route Table for VRF "([^"]+)"`),
+		regexp.MustCompile(`^VRF:\s*(\S+)`),
+	}
+	junosTableHeader = regexp.MustCompile(`^(?:(\S+)\.)?inet6?\.0:`)
+	junosProtocol    = regexp.MustCompile(`\[([A-Za-z-]+)/\d+\]`)
+	routeCodeToken   = regexp.MustCompile(`^[A-Za-z0-9*%+]{1,5}$`)
+	routeVia         = regexp.MustCompile(`\bvia ([0-9A-Fa-f.:]+[0-9A-Fa-f])`)
+	// routeWords look like protocol codes but start next-hop lines.
+	routeWords = map[string]bool{"via": true, "to": true}
+)
+
+func defaultVRFName(name string) string {
+	switch strings.ToLower(name) {
+	case "default", "global", "master":
+		return ""
+	}
+	return name
+}
+
+// parseRoutingTable reads the routes of raw, text or JSON.
+func parseRoutingTable(raw string) ([]RouteEntry, int, error) {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return nil, 0, fmt.Errorf("routing table is empty")
+	}
+	var entries []RouteEntry
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var err error
+		if entries, err = parseRoutingTableJSON(trimmed); err != nil {
+			return nil, 0, err
+		}
+	} else {
+		entries = parseRoutingTableText(trimmed)
+	}
+
+	seen := map[string]bool{}
+	var out []RouteEntry
+	skipped := 0
+	for _, e := range entries {
+		if e.prefix.Bits() == 0 || (isLocalRouteProtocol(e.Protocol) && e.prefix.IsSingleIP()) {
+			skipped++
+			continue
+		}
+		key := strings.ToLower(e.VRF) + "|" + e.prefix.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, e)
+	}
+	if len(out) == 0 && skipped == 0 {
+		return nil, 0, fmt.Errorf("no routes found")
+	}
+	return out, skipped, nil
+}
+
+func isLocalRouteProtocol(protocol string) bool {
+	switch strings.ToLower(protocol) {
+	case "l", "local", "hsrp", "vrrp":
+		return true
+	}
+	return false
+}
+
+// parseRoutingTableJSON reads a list of routes, bare or under "routes".
+func parseRoutingTableJSON(raw string) ([]RouteEntry, error) {
+	type jsonRoute struct {
+		Prefix      string `json:"prefix"`
+		Network     string `json:"network"`
+		Destination string `json:"destination"`
+		VRF         string `json:"vrf"`
+		Table       string `json:"table"`
+		Protocol    string `json:"protocol"`
+		NextHop     string `json:"next_hop"`
+		Nexthop     string `json:"nexthop"`
+		Gateway     string `json:"gateway"`
+	}
+	var list []jsonRoute
+	if strings.HasPrefix(raw, "{") {
+		var wrapped struct {
+			Routes []jsonRoute `json:"routes"`
+		}
+		if err := json.Unmarshal([]byte(raw), &wrapped); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %v", err)
+		}
+		list = wrapped.Routes
+	} else if err := json.Unmarshal([]byte(raw), &list); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	var out []RouteEntry
+	for i, r := range list {
+		text := firstNonEmpty(r.Prefix, r.Network, r.Destination)
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("route %d: invalid prefix %q", i+1, text)
+		}
+		out = append(out, RouteEntry{
+			VRF:      defaultVRFName(strings.TrimSpace(firstNonEmpty(r.VRF, r.Table))),
+			Prefix:   prefix.Masked().String(),
+			Protocol: strings.TrimSpace(r.Protocol),
+			NextHop:  strings.TrimSpace(firstNonEmpty(r.NextHop, r.Nexthop, r.Gateway)),
+			prefix:   prefix.Masked(),
+		})
+	}
+	return out, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// parseRoutingTableText reads CLI output line by line.
+func parseRoutingTableText(raw string) []RouteEntry {
+	var out []RouteEntry
+	vrf := ""
+	var subnetted netip.Prefix
+	for _, line := range splitLines(raw) {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		header := false
+		for _, re := range routeTableVRFHeaders {
+			if m := re.FindStringSubmatch(trimmed); m != nil {
+				vrf, header = defaultVRFName(m[1]), true
+				break
+			}
+		}
+		if m := junosTableHeader.FindStringSubmatch(trimmed); m != nil {
+			vrf, header = defaultVRFName(m[1]), true
+		}
+		fields := strings.Fields(strings.NewReplacer(",", " ").Replace(trimmed))
+		if header || strings.Contains(trimmed, "subnetted") {
+			subnetted = netip.Prefix{}
+			if strings.Contains(trimmed, " is subnetted") {
+				subnetted, _ = netip.ParsePrefix(fields[0])
+			}
+			continue
+		}
+		var codes []string
+		for _, f := range fields {
+			prefix, err := netip.ParsePrefix(f)
+			if err != nil {
+				prefix, err = subnettedPrefix(subnetted, f, len(codes) > 0)
+			}
+			if err != nil {
+				if routeCodeToken.MatchString(f) && !routeWords[strings.ToLower(strings.Trim(f, "*"))] {
+					codes = append(codes, strings.Trim(f, "*"))
+					continue
+				}
+				break
+			}
+			entry := RouteEntry{VRF: vrf, Prefix: prefix.Masked().String(), prefix: prefix.Masked()}
+			entry.Protocol = strings.Join(codes, " ")
+			if m := junosProtocol.FindStringSubmatch(trimmed); m != nil {
+				entry.Protocol = m[1]
+			}
+			if m := routeVia.FindStringSubmatch(trimmed); m != nil {
+				entry.NextHop = m[1]
+			}
+			out = append(out, entry)
+			break
+		}
+	}
+	return out
+}
+
+// subnettedPrefix is the prefix of a maskless IOS route line under an
+// "is subnetted" header.
+func subnettedPrefix(subnetted netip.Prefix, field string, afterCodes bool) (netip.Prefix, error) {
+	addr, err := netip.ParseAddr(field)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !afterCodes || !subnetted.IsValid() || !addr.Is4() {
+		return netip.Prefix{}, fmt.Errorf("no mask for %s", field)
+	}
+	network := netip.PrefixFrom(subnetted.Addr(), classfulPrefixBits(subnetted.Addr())).Masked()
+	if !network.Contains(addr) {
+		return netip.Prefix{}, fmt.Errorf("%s is outside %s", field, network)
+	}
+	return addr.Prefix(subnetted.Bits())
+}
+
+func classfulPrefixBits(addr netip.Addr) int {
+	first := addr.As4()[0]
+	switch {
+	case first < 128:
+		return 8
+	case first < 192:
+		return 16
+	default:
+		return 24
+	}
+}
+
+// reconcileRoutes checks routes against the project's segments.
+func reconcileRoutes(routes []RouteEntry, segs []Segment, siteID int64) RouteReconciliation {
+	out := RouteReconciliation{
+		Routes:   []RouteCheck{},
+		Segments: []SegmentRouteCheck{},
+		Counts:   map[string]int{},
+	}
+	type segPrefix struct {
+		seg    Segment
+		prefix netip.Prefix
+	}
+	var planned []segPrefix
+	for _, s := range segs {
+		for _, p := range segmentPrefixes(s) {
+			planned = append(planned, segPrefix{seg: s, prefix: p})
+		}
+	}
+	sameVRF := func(route RouteEntry, s Segment) bool {
+		return route.VRF == "" || strings.EqualFold(route.VRF, defaultVRFName(strings.TrimSpace(s.VRF)))
+	}
+	label := func(s Segment, p netip.Prefix) string {
+		return s.Site + " " + s.Name + " " + p.String()
+	}
+
+	for _, route := range routes {
+		check := RouteCheck{RouteEntry: route, Status: routeUnknown}
+		for _, sp := range planned {
+			if !sameVRF(route, sp.seg) || !route.prefix.Overlaps(sp.prefix) {
+				continue
+			}
+			status := routeMoreSpecific
+			switch {
+			case route.prefix == sp.prefix:
+				status = routeMatched
+			case route.prefix.Bits() < sp.prefix.Bits():
+				status = routeSummary
+			}
+			if check.Status == routeUnknown || routeStatusRank(status) < routeStatusRank(check.Status) {
+				check.Status = status
+			}
+			check.Segments = append(check.Segments, label(sp.seg, sp.prefix))
+		}
+		out.Routes = append(out.Routes, check)
+		out.Counts[check.Status]++
+	}
+
+	for _, sp := range planned {
+		if siteID > 0 && sp.seg.SiteID != siteID {
+			continue
+		}
+		check := SegmentRouteCheck{
+			ID: sp.seg.ID, Site: sp.seg.Site, VRF: sp.seg.VRF, VLAN: sp.seg.VLAN, Name: sp.seg.Name,
+			CIDR: sp.prefix.String(), Status: segmentNotRouted,
+		}
+		for _, route := range routes {
+			if !sameVRF(route, sp.seg) {
+				continue
+			}
+			if route.prefix == sp.prefix {
+				check.Status, check.Route = segmentRouted, route.Prefix
+				break
+			}
+			if route.prefix.Bits() < sp.prefix.Bits() && route.prefix.Contains(sp.prefix.Addr()) {
+				check.Status, check.Route = segmentSummarized, route.Prefix
+			}
+		}
+		out.Segments = append(out.Segments, check)
+		out.Counts[check.Status]++
+	}
+	sort.SliceStable(out.Segments, func(i, j int) bool {
+		a, b := out.Segments[i], out.Segments[j]
+		if a.Site != b.Site {
+			return a.Site < b.Site
+		}
+		return a.VLAN < b.VLAN
+	})
+	return out
+}
+
+func routeStatusRank(status string) int {
+	for i, s := range []string{routeMatched, routeSummary, routeMoreSpecific} {
+		if s == status {
+			return i
+		}
+	}
+	return 99
+}
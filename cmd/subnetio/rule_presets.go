@@ -0,0 +1,142 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// RulePreset is a named set of rules saved by an admin.
+type RulePreset struct {
+	ID          int64
+	Name        string
+	Description string
+	Rules       ProjectRules
+	CreatedAt   string
+}
+
+var builtinRulePresets = []string{"strict", "balanced", "legacy"}
+
+func isReservedPresetName(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "custom" {
+		return true
+	}
+	for _, builtin := range builtinRulePresets {
+		if name == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+const rulePresetColumns = `id, name, COALESCE(description, ''), vlan_scope, require_in_pool, allow_reserved_overlap,
+	oversize_threshold, pool_strategy, pool_tier_fallback, COALESCE(ipv6_scheme, ''), created_at`
+
+func scanRulePreset(row interface{ Scan(...any) error }) (RulePreset, error) {
+	var p RulePreset
+	var requireInPool, allowReserved, fallback int
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Rules.VLANScope, &requireInPool, &allowReserved,
+		&p.Rules.OversizeThreshold, &p.Rules.PoolStrategy, &fallback, &p.Rules.IPv6Scheme, &p.CreatedAt)
+	p.Rules.RequireInPool = requireInPool != 0
+	p.Rules.AllowReservedOverlap = allowReserved != 0
+	p.Rules.PoolTierFallback = fallback != 0
+	return p, err
+}
+
+func listRulePresets(db sqlConn) ([]RulePreset, error) {
+	rows, err := db.Query(`SELECT ` + rulePresetColumns + ` FROM rule_presets ORDER BY name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RulePreset
+	for rows.Next() {
+		p, err := scanRulePreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func rulePresetByName(db sqlConn, name string) (RulePreset, bool) {
+	p, err := scanRulePreset(db.QueryRow(`SELECT `+rulePresetColumns+` FROM rule_presets WHERE name=?`, strings.TrimSpace(name)))
+	if err != nil {
+		return RulePreset{}, false
+	}
+	return p, true
+}
+
+// saveRulePreset creates the preset or overwrites the one with the same name.
+func saveRulePreset(db sqlConn, preset RulePreset) error {
+	name := strings.TrimSpace(preset.Name)
+	if name == "" {
+		return errors.New("preset name is required")
+	}
+	if isReservedPresetName(name) {
+		return errors.New("preset name " + name + " is reserved")
+	}
+	rules := normalizeRules(preset.Rules)
+	if _, _, err := parseIPv6Scheme(rules.IPv6Scheme); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO rule_presets(name, description, vlan_scope, require_in_pool, allow_reserved_overlap, oversize_threshold,
+			pool_strategy, pool_tier_fallback, ipv6_scheme, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			description=excluded.description,
+			vlan_scope=excluded.vlan_scope,
+			require_in_pool=excluded.require_in_pool,
+			allow_reserved_overlap=excluded.allow_reserved_overlap,
+			oversize_threshold=excluded.oversize_threshold,
+			pool_strategy=excluded.pool_strategy,
+			pool_tier_fallback=excluded.pool_tier_fallback,
+			ipv6_scheme=excluded.ipv6_scheme`,
+		name,
+		nullStringToAny(strings.TrimSpace(preset.Description)),
+		rules.VLANScope,
+		boolToInt(rules.RequireInPool),
+		boolToInt(rules.AllowReservedOverlap),
+		rules.OversizeThreshold,
+		rules.PoolStrategy,
+		boolToInt(rules.PoolTierFallback),
+		nullStringToAny(rules.IPv6Scheme),
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func deleteRulePreset(db sqlConn, name string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM rule_presets WHERE name=?`, strings.TrimSpace(name))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// resolveRulePreset looks up a built-in preset first, then a custom one.
+func resolveRulePreset(db sqlConn, name string, current ProjectRules) (ProjectRules, bool) {
+	rules, ok := presetRules(name)
+	if !ok {
+		custom, found := rulePresetByName(db, name)
+		if !found {
+			return ProjectRules{}, false
+		}
+		rules = custom.Rules
+	}
+	if strings.TrimSpace(rules.IPv6Scheme) == "" {
+		rules.IPv6Scheme = current.IPv6Scheme
+	}
+	rules.VLANRanges = current.VLANRanges
+	rules.DefaultVRF = current.DefaultVRF
+	rules.SegmentCheck = current.SegmentCheck
+	rules.PersistDerived = current.PersistDerived
+	rules.Uniqueness = current.Uniqueness
+	return rules, true
+}
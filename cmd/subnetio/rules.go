@@ -15,6 +15,12 @@ type ProjectRules struct {
 	OversizeThreshold    int
 	PoolStrategy         string
 	PoolTierFallback     bool
+	IPv6Scheme           string
+	VLANRanges           string
+	DefaultVRF           string
+	SegmentCheck         string
+	PersistDerived       bool
+	Uniqueness           string
 }
 
 const (
@@ -37,6 +43,8 @@ func defaultProjectRules() ProjectRules {
 		OversizeThreshold:    50,
 		PoolStrategy:         PoolStrategySpillover,
 		PoolTierFallback:     true,
+		SegmentCheck:         SegmentCheckWarn,
+		Uniqueness:           defaultUniqueness,
 	}
 }
 
@@ -74,7 +82,7 @@ func presetRules(name string) (ProjectRules, bool) {
 	}
 }
 
-func getProjectRules(db *sql.DB, projectID int64) (ProjectRules, error) {
+func getProjectRules(db sqlConn, projectID int64) (ProjectRules, error) {
 	if projectID <= 0 {
 		return defaultProjectRules(), nil
 	}
@@ -83,16 +91,21 @@ func getProjectRules(db *sql.DB, projectID int64) (ProjectRules, error) {
 	var allowReserved int
 	var oversize int
 	var poolTierFallback int
+	var persistDerived int
 	row := db.QueryRow(`
 		SELECT vlan_scope, require_in_pool, allow_reserved_overlap, oversize_threshold,
-			COALESCE(pool_strategy, 'spillover'), COALESCE(pool_tier_fallback, 1)
+			COALESCE(pool_strategy, 'spillover'), COALESCE(pool_tier_fallback, 1), COALESCE(ipv6_scheme, ''),
+			COALESCE(vlan_ranges, ''), COALESCE(default_vrf, ''), COALESCE(segment_check, 'warn'),
+			persist_derived, uniqueness
 		FROM project_rules WHERE project_id=?`, projectID)
-	switch err := row.Scan(&rules.VLANScope, &requireInPool, &allowReserved, &oversize, &rules.PoolStrategy, &poolTierFallback); err {
+	switch err := row.Scan(&rules.VLANScope, &requireInPool, &allowReserved, &oversize, &rules.PoolStrategy, &poolTierFallback, &rules.IPv6Scheme,
+		&rules.VLANRanges, &rules.DefaultVRF, &rules.SegmentCheck, &persistDerived, &rules.Uniqueness); err {
 	case nil:
 		rules.RequireInPool = requireInPool != 0
 		rules.AllowReservedOverlap = allowReserved != 0
 		rules.OversizeThreshold = oversize
 		rules.PoolTierFallback = poolTierFallback != 0
+		rules.PersistDerived = persistDerived != 0
 		return normalizeRules(rules), nil
 	case sql.ErrNoRows:
 		def := defaultProjectRules()
@@ -105,21 +118,28 @@ func getProjectRules(db *sql.DB, projectID int64) (ProjectRules, error) {
 	}
 }
 
-func saveProjectRules(db *sql.DB, projectID int64, rules ProjectRules) error {
+func saveProjectRules(db sqlConn, projectID int64, rules ProjectRules) error {
 	if projectID <= 0 {
 		return errors.New("project id required")
 	}
 	rules = normalizeRules(rules)
 	_, err := db.Exec(`
-		INSERT INTO project_rules(project_id, vlan_scope, require_in_pool, allow_reserved_overlap, oversize_threshold, pool_strategy, pool_tier_fallback)
-		VALUES(?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO project_rules(project_id, vlan_scope, require_in_pool, allow_reserved_overlap, oversize_threshold, pool_strategy, pool_tier_fallback, ipv6_scheme,
+			vlan_ranges, default_vrf, segment_check, persist_derived, uniqueness)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(project_id) DO UPDATE SET
 			vlan_scope=excluded.vlan_scope,
 			require_in_pool=excluded.require_in_pool,
 			allow_reserved_overlap=excluded.allow_reserved_overlap,
 			oversize_threshold=excluded.oversize_threshold,
 			pool_strategy=excluded.pool_strategy,
-			pool_tier_fallback=excluded.pool_tier_fallback`,
+			pool_tier_fallback=excluded.pool_tier_fallback,
+			ipv6_scheme=excluded.ipv6_scheme,
+			vlan_ranges=excluded.vlan_ranges,
+			default_vrf=excluded.default_vrf,
+			segment_check=excluded.segment_check,
+			persist_derived=excluded.persist_derived,
+			uniqueness=excluded.uniqueness`,
 		projectID,
 		rules.VLANScope,
 		boolToInt(rules.RequireInPool),
@@ -127,6 +147,12 @@ func saveProjectRules(db *sql.DB, projectID int64, rules ProjectRules) error {
 		rules.OversizeThreshold,
 		rules.PoolStrategy,
 		boolToInt(rules.PoolTierFallback),
+		nullStringToAny(rules.IPv6Scheme),
+		nullStringToAny(rules.VLANRanges),
+		nullStringToAny(rules.DefaultVRF),
+		rules.SegmentCheck,
+		boolToInt(rules.PersistDerived),
+		rules.Uniqueness,
 	)
 	return err
 }
@@ -150,16 +176,35 @@ func normalizeRules(rules ProjectRules) ProjectRules {
 	default:
 		rules.PoolStrategy = PoolStrategySpillover
 	}
+	if scheme, ok, err := parseIPv6Scheme(rules.IPv6Scheme); err == nil && ok {
+		rules.IPv6Scheme = scheme.String()
+	}
+	if ranges, err := parseVLANRanges(rules.VLANRanges); err == nil {
+		rules.VLANRanges = formatVLANRanges(ranges)
+	}
+	rules.DefaultVRF = strings.TrimSpace(rules.DefaultVRF)
+	rules.SegmentCheck = normalizeSegmentCheck(rules.SegmentCheck)
+	if set, err := parseUniqueness(rules.Uniqueness); err == nil {
+		rules.Uniqueness = formatUniqueness(set)
+	} else {
+		rules.Uniqueness = defaultUniqueness
+	}
 	return rules
 }
 
+// vlanKey scopes VLAN uniqueness. Sites sharing a VLAN domain count as one
+// site, so duplicates are caught across the whole L2 domain.
 func vlanKey(s Segment, rules ProjectRules) string {
+	site := s.Site
+	if s.VLANDomain != "" {
+		site = "domain:" + s.VLANDomain
+	}
 	switch rules.VLANScope {
 	case VlanScopeGlobal:
 		return itoa(s.VLAN)
 	case VlanScopeSite:
-		return s.Site + "|" + itoa(s.VLAN)
+		return site + "|" + itoa(s.VLAN)
 	default:
-		return s.Site + "|" + s.VRF + "|" + itoa(s.VLAN)
+		return site + "|" + s.VRF + "|" + itoa(s.VLAN)
 	}
 }
@@ -0,0 +1,156 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sandbox projects are scratch space for training and experiments.
+const (
+	defaultSandboxIdleTTL = 14 * 24 * time.Hour
+	sandboxPurgeInterval  = time.Hour
+)
+
+func sandboxIdleTTL() time.Duration {
+	raw := mustEnv("SANDBOX_IDLE_TTL", "")
+	if raw == "" {
+		return defaultSandboxIdleTTL
+	}
+	ttl, err := time.ParseDuration(raw)
+	if err != nil || ttl <= 0 {
+		log.Printf("invalid SANDBOX_IDLE_TTL %q, using %s", raw, defaultSandboxIdleTTL)
+		return defaultSandboxIdleTTL
+	}
+	return ttl
+}
+
+func setProjectSandbox(db *sql.DB, projectID int64, sandbox bool) error {
+	if projectID <= 0 {
+		return fmt.Errorf("project is required")
+	}
+	var since any
+	if sandbox {
+		since = time.Now().UTC().Format(time.RFC3339)
+	}
+	_, err := db.Exec(`UPDATE projects SET sandbox=?, sandbox_since=? WHERE id=?`, boolToInt(sandbox), since, projectID)
+	return err
+}
+
+// sandboxLastActivity is the later of when the project became a sandbox and
+// its newest audit entry.
+func sandboxLastActivity(db *sql.DB, projectID int64) (time.Time, error) {
+	var since, last string
+	err := db.QueryRow(`
+		SELECT COALESCE(p.sandbox_since, ''),
+			COALESCE((SELECT MAX(a.created_at) FROM audit_log a WHERE a.project_id = p.id), '')
+		FROM projects p WHERE p.id=?`, projectID).Scan(&since, &last)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var out time.Time
+	for _, raw := range []string{since, last} {
+		if t, err := time.Parse(time.RFC3339, raw); err == nil && t.After(out) {
+			out = t
+		}
+	}
+	return out, nil
+}
+
+// purgeIdleSandboxes deletes sandbox projects idle for longer than idle and
+// returns their names.
+func purgeIdleSandboxes(db *sql.DB, defaultProjectID int64, idle time.Duration, now time.Time) ([]string, error) {
+	projects, err := listProjects(db)
+	if err != nil {
+		return nil, err
+	}
+	var purged []string
+	for _, p := range projects {
+		if !p.Sandbox || p.ID == defaultProjectID {
+			continue
+		}
+		last, err := sandboxLastActivity(db, p.ID)
+		if err != nil {
+			return purged, err
+		}
+		if last.IsZero() || now.Sub(last) < idle {
+			continue
+		}
+		if err := deleteSandboxProject(db, p.ID); err != nil {
+			return purged, err
+		}
+		if err := insertAuditRecord(db, auditRecord{
+			Actor:       "scheduler",
+			Action:      "purge",
+			EntityType:  "project",
+			EntityID:    sql.NullInt64{Int64: p.ID, Valid: true},
+			EntityLabel: sql.NullString{String: p.Name, Valid: true},
+			Reason:      sql.NullString{String: "sandbox idle since " + last.UTC().Format(time.RFC3339), Valid: true},
+			Before:      snapshotProject(p),
+		}); err != nil {
+			log.Printf("sandbox purge audit %d: %v", p.ID, err)
+		}
+		purged = append(purged, p.Name)
+	}
+	return purged, nil
+}
+
+// deleteSandboxProject deletes a sandbox together with its audit chain, which
+// references the project and would keep it from being deleted.
+func deleteSandboxProject(db *sql.DB, projectID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM audit_log WHERE project_id=?`, projectID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := deleteProjectTx(tx, projectID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func startSandboxPurge(db *sql.DB, defaultProjectID int64) {
+	idle := sandboxIdleTTL()
+	go func() {
+		ticker := time.NewTicker(sandboxPurgeInterval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if getMaintenance(db).Enabled {
+				continue
+			}
+			if names, err := purgeIdleSandboxes(db, defaultProjectID, idle, time.Now()); err != nil {
+				log.Printf("sandbox purge: %v", err)
+			} else if len(names) > 0 {
+				log.Printf("sandbox purge: deleted %s", strings.Join(names, ", "))
+			}
+		}
+	}()
+}
+
+// sandboxExportGuard refuses the /export/ downloads of a sandbox project.
+func sandboxExportGuard(db *sql.DB, defaultProjectID int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || !strings.HasPrefix(c.Request.URL.Path, "/export/") {
+			c.Next()
+			return
+		}
+		projectID := resolveActiveProjectID(c, db, defaultProjectID)
+		p, ok := projectByID(db, projectID)
+		if !ok || !p.Sandbox {
+			c.Next()
+			return
+		}
+		abortWithError(c, http.StatusConflict, fmt.Errorf("project %q is a sandbox; sandbox projects are not exported", p.Name))
+	}
+}
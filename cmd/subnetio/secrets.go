@@ -0,0 +1,240 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const secretRefPrefix = "secret:"
+
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.\-/]+$`)
+
+type SecretBackend interface {
+	Name() string
+	Lookup(name, key string) (string, error)
+}
+
+var (
+	secretsMu      sync.RWMutex
+	secretsBackend SecretBackend = envSecretBackend{prefix: "SUBNETIO_SECRET_"}
+)
+
+func loadSecretBackend() (SecretBackend, error) {
+	switch strings.ToLower(mustEnv("SECRETS_BACKEND", "env")) {
+	case "env":
+		return envSecretBackend{prefix: mustEnv("SECRETS_ENV_PREFIX", "SUBNETIO_SECRET_")}, nil
+	case "file":
+		dir := mustEnv("SECRETS_DIR", "")
+		if dir == "" {
+			return nil, errors.New("SECRETS_DIR is required for the file secret backend")
+		}
+		return fileSecretBackend{dir: dir}, nil
+	case "vault":
+		addr := strings.TrimRight(mustEnv("VAULT_ADDR", ""), "/")
+		if addr == "" {
+			return nil, errors.New("VAULT_ADDR is required for the vault secret backend")
+		}
+		token := mustEnv("VAULT_TOKEN", "")
+		if token == "" {
+			if path := mustEnv("VAULT_TOKEN_FILE", ""); path != "" {
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("read VAULT_TOKEN_FILE: %w", err)
+				}
+				token = strings.TrimSpace(string(raw))
+			}
+		}
+		if token == "" {
+			return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is required for the vault secret backend")
+		}
+		ttl, err := time.ParseDuration(mustEnv("VAULT_CACHE_TTL", "5m"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid VAULT_CACHE_TTL: %w", err)
+		}
+		return &vaultSecretBackend{
+			addr:      addr,
+			token:     token,
+			namespace: mustEnv("VAULT_NAMESPACE", ""),
+			mount:     strings.Trim(mustEnv("VAULT_KV_MOUNT", "secret"), "/"),
+			ttl:       ttl,
+			client:    &http.Client{Timeout: 10 * time.Second},
+			cache:     map[string]vaultCacheEntry{},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown SECRETS_BACKEND %q (env, file, vault)", mustEnv("SECRETS_BACKEND", ""))
+	}
+}
+
+func setSecretBackend(b SecretBackend) {
+	secretsMu.Lock()
+	secretsBackend = b
+	secretsMu.Unlock()
+}
+
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), secretRefPrefix)
+}
+
+// parseSecretRef splits "secret:name#key" into name and key; key defaults to
+// "value" and is only meaningful for backends storing maps (vault).
+func parseSecretRef(value string) (string, string, error) {
+	ref := strings.TrimPrefix(strings.TrimSpace(value), secretRefPrefix)
+	name, key, _ := strings.Cut(ref, "#")
+	name = strings.TrimSpace(name)
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = "value"
+	}
+	if name == "" || !secretNamePattern.MatchString(name) || strings.Contains(name, "..") {
+		return "", "", fmt.Errorf("invalid secret reference %q", value)
+	}
+	return name, key, nil
+}
+
+func validateSecretField(field, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if !isSecretRef(value) {
+		return fmt.Errorf("%s must be a secret reference (secret:<name>), plaintext is not stored", field)
+	}
+	_, _, err := parseSecretRef(value)
+	return err
+}
+
+func resolveSecret(value string) (string, error) {
+	if !isSecretRef(value) {
+		return value, nil
+	}
+	name, key, err := parseSecretRef(value)
+	if err != nil {
+		return "", err
+	}
+	secretsMu.RLock()
+	backend := secretsBackend
+	secretsMu.RUnlock()
+	secret, err := backend.Lookup(name, key)
+	if err != nil {
+		return "", fmt.Errorf("secret %s (%s): %w", name, backend.Name(), err)
+	}
+	return secret, nil
+}
+
+type envSecretBackend struct {
+	prefix string
+}
+
+func (b envSecretBackend) Name() string { return "env" }
+
+func (b envSecretBackend) Lookup(name, _ string) (string, error) {
+	envName := b.prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_", "/", "_").Replace(name))
+	v, ok := os.LookupEnv(envName)
+	if !ok || v == "" {
+		return "", fmt.Errorf("%s is not set", envName)
+	}
+	return v, nil
+}
+
+type fileSecretBackend struct {
+	dir string
+}
+
+func (b fileSecretBackend) Name() string { return "file" }
+
+func (b fileSecretBackend) Lookup(name, _ string) (string, error) {
+	raw, err := os.ReadFile(filepath.Join(b.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(raw), "\r\n"), nil
+}
+
+type vaultCacheEntry struct {
+	data    map[string]any
+	expires time.Time
+}
+
+type vaultSecretBackend struct {
+	addr      string
+	token     string
+	namespace string
+	mount     string
+	ttl       time.Duration
+	client    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]vaultCacheEntry
+}
+
+func (b *vaultSecretBackend) Name() string { return "vault" }
+
+func (b *vaultSecretBackend) Lookup(name, key string) (string, error) {
+	data, err := b.read(name)
+	if err != nil {
+		return "", err
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q is not a string", key)
+	}
+	return s, nil
+}
+
+func (b *vaultSecretBackend) read(name string) (map[string]any, error) {
+	b.mu.Lock()
+	if entry, ok := b.cache[name]; ok && time.Now().Before(entry.expires) {
+		b.mu.Unlock()
+		return entry.data, nil
+	}
+	b.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodGet, b.addr+"/v1/"+b.mount+"/data/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", b.token)
+	if b.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", b.namespace)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault: unexpected status %d", resp.StatusCode)
+	}
+	var payload struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&payload); err != nil {
+		return nil, fmt.Errorf("vault: decode: %w", err)
+	}
+	if payload.Data.Data == nil {
+		return nil, errors.New("vault: empty secret")
+	}
+	if b.ttl > 0 {
+		b.mu.Lock()
+		b.cache[name] = vaultCacheEntry{data: payload.Data.Data, expires: time.Now().Add(b.ttl)}
+		b.mu.Unlock()
+	}
+	return payload.Data.Data, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// BulkDeletePreview lists what removing a set of segments takes with it.
+type BulkDeletePreview struct {
+	Segments     []BulkDeleteSegment
+	MetaRows     int
+	Reservations int
+	Locked       int
+	Configs      []BulkDeleteReference
+	Schedules    []BulkDeleteReference
+}
+
+type BulkDeleteSegment struct {
+	SegmentView
+	HasMeta      bool
+	Reservations int
+}
+
+// BulkDeleteReference is a deployed config or schedule that mentions some of
+// the selected segments.
+type BulkDeleteReference struct {
+	ID       int64    `json:"id,omitempty"`
+	Template string   `json:"template"`
+	Scope    string   `json:"scope"`
+	Segments []string `json:"segments"`
+}
+
+func (p BulkDeletePreview) IDs() []int64 {
+	out := make([]int64, 0, len(p.Segments))
+	for _, s := range p.Segments {
+		out = append(out, s.ID)
+	}
+	return out
+}
+
+func parseSegmentIDs(values []string) []int64 {
+	seen := map[int64]bool{}
+	var out []int64
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if id := parseProjectID(part); id > 0 && !seen[id] {
+				seen[id] = true
+				out = append(out, id)
+			}
+		}
+	}
+	return out
+}
+
+func previewBulkDelete(db *sql.DB, projectID int64, ids []int64) (BulkDeletePreview, error) {
+	var preview BulkDeletePreview
+	wanted := map[int64]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return preview, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return preview, err
+	}
+	var selected []Segment
+	for _, s := range segs {
+		if wanted[s.ID] {
+			selected = append(selected, s)
+		}
+	}
+	for _, v := range buildSegmentViews(selected, nil, pools) {
+		item := BulkDeleteSegment{SegmentView: v}
+		var metaRows int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM segment_meta WHERE segment_id=?`, v.ID).Scan(&metaRows); err != nil {
+			return preview, err
+		}
+		item.HasMeta = metaRows > 0
+		if err := db.QueryRow(`SELECT COUNT(*) FROM mac_reservations WHERE segment_id=?`, v.ID).Scan(&item.Reservations); err != nil {
+			return preview, err
+		}
+		if item.HasMeta {
+			preview.MetaRows++
+		}
+		if item.Locked {
+			preview.Locked++
+		}
+		preview.Reservations += item.Reservations
+		preview.Segments = append(preview.Segments, item)
+	}
+	if len(preview.Segments) == 0 {
+		return preview, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT d.template, d.scope_key, d.content, d.content_hash, b.data, b.encoding
+		FROM deployed_configs d
+		LEFT JOIN config_blobs b ON b.hash=d.content_hash
+		WHERE d.project_id=? ORDER BY d.template, d.scope_key`, projectID)
+	if err != nil {
+		return preview, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ref BulkDeleteReference
+		var inline string
+		var hash, encoding sql.NullString
+		var data []byte
+		if err := rows.Scan(&ref.Template, &ref.Scope, &inline, &hash, &data, &encoding); err != nil {
+			return preview, err
+		}
+		content, err := storedConfigContent(inline, hash, data, encoding)
+		if err != nil {
+			return preview, err
+		}
+		for _, s := range preview.Segments {
+			if segmentInConfig(s.SegmentView, content) {
+				ref.Segments = append(ref.Segments, s.Name)
+			}
+		}
+		if len(ref.Segments) > 0 {
+			preview.Configs = append(preview.Configs, ref)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return preview, err
+	}
+
+	schedules, err := listGenerationSchedules(db, projectID)
+	if err != nil {
+		return preview, err
+	}
+	for _, sc := range schedules {
+		if strings.TrimSpace(sc.SegmentFilter) == "" {
+			continue
+		}
+		ref := BulkDeleteReference{ID: sc.ID, Template: sc.Template, Scope: sc.ScopeKey()}
+		for _, s := range preview.Segments {
+			if segmentFilterMatch(sc.SegmentFilter, s.SegmentView) {
+				ref.Segments = append(ref.Segments, s.Name)
+			}
+		}
+		if len(ref.Segments) > 0 {
+			preview.Schedules = append(preview.Schedules, ref)
+		}
+	}
+	return preview, nil
+}
+
+// segmentInConfig reports whether a deployed config mentions one of the
+// segment's prefixes or gateways.
+func segmentInConfig(v SegmentView, content string) bool {
+	for _, needle := range []string{v.CIDR, v.CIDRV6, v.Gateway, v.GatewayV6} {
+		if needle != "" && containsAddrToken(content, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAddrToken matches needle only where it is not part of a longer
+// address, so 10.0.1.1 does not match 10.0.1.10.
+func containsAddrToken(content, needle string) bool {
+	addrChar := func(b byte) bool {
+		return b == '.' || b == ':' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+	}
+	for start := 0; ; {
+		i := strings.Index(content[start:], needle)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(needle)
+		if (i == 0 || !addrChar(content[i-1])) && (end == len(content) || !addrChar(content[end])) {
+			return true
+		}
+		start = i + 1
+	}
+}
+
+// bulkDeleteSegments removes the segments with their meta and reservations
+// in one transaction.
+func bulkDeleteSegments(db *sql.DB, ids []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := deleteSegmentTx(tx, id); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Range policies of the bulk DHCP action.
+const (
+	bulkDHCPKeep  = "keep"
+	bulkDHCPAuto  = "auto"
+	bulkDHCPFirst = "first"
+	bulkDHCPLast  = "last"
+)
+
+const bulkDHCPDefaultCount = 50
+
+// BulkDHCPRequest is what the bulk DHCP form asks for.
+type BulkDHCPRequest struct {
+	Enable bool   `json:"enable"`
+	Policy string `json:"policy"`
+	Count  int    `json:"count,omitempty"`
+}
+
+// bulkDHCPRequestFrom reads the form; get is c.Query or c.PostForm.
+func bulkDHCPRequestFrom(get func(string) string) (BulkDHCPRequest, error) {
+	req := BulkDHCPRequest{Enable: true, Policy: bulkDHCPKeep, Count: bulkDHCPDefaultCount}
+	switch strings.TrimSpace(get("dhcp")) {
+	case "", "on":
+	case "off":
+		req.Enable = false
+	default:
+		return req, fmt.Errorf("dhcp must be on or off")
+	}
+	if raw := strings.ToLower(strings.TrimSpace(get("policy"))); raw != "" {
+		req.Policy = raw
+	}
+	switch req.Policy {
+	case bulkDHCPKeep, bulkDHCPAuto:
+	case bulkDHCPFirst, bulkDHCPLast:
+		if raw := strings.TrimSpace(get("count")); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxFormInt {
+				return req, fmt.Errorf("the number of addresses must be a positive whole number")
+			}
+			req.Count = n
+		}
+	default:
+		return req, fmt.Errorf("unknown range policy %q", req.Policy)
+	}
+	if !req.Enable {
+		req.Policy = bulkDHCPKeep
+	}
+	return req, nil
+}
+
+// BulkDHCPPreview shows the DHCP state of each selected segment before and
+// after the change.
+type BulkDHCPPreview struct {
+	Request  BulkDHCPRequest
+	Segments []BulkDHCPSegment
+	Changed  int
+	Locked   int
+}
+
+type BulkDHCPSegment struct {
+	SegmentView
+	After      Segment
+	AfterRange string
+	Note       string
+	Changed    bool
+	patch      segmentMetaPatch
+}
+
+// selectBulkSegments returns the segments picked on the segments page, or
+// every segment matching filterQuery when none were picked.
+func selectBulkSegments(db *sql.DB, projectID int64, ids []int64, filterQuery string) ([]SegmentView, []Pool, error) {
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) > 0 {
+		wanted := map[int64]bool{}
+		for _, id := range ids {
+			wanted[id] = true
+		}
+		var selected []Segment
+		for _, s := range segs {
+			if wanted[s.ID] {
+				selected = append(selected, s)
+			}
+		}
+		return buildSegmentViews(selected, nil, pools), pools, nil
+	}
+	values, _ := url.ParseQuery(filterQuery)
+	return applySegmentFilters(buildSegmentViews(segs, nil, pools), segmentFiltersFromValues(values)), pools, nil
+}
+
+func previewBulkDHCP(db *sql.DB, projectID int64, ids []int64, filterQuery string, req BulkDHCPRequest) (BulkDHCPPreview, error) {
+	preview := BulkDHCPPreview{Request: req}
+	views, pools, err := selectBulkSegments(db, projectID, ids, filterQuery)
+	if err != nil {
+		return preview, err
+	}
+	enable := req.Enable
+	afters := make([]Segment, 0, len(views))
+	for _, v := range views {
+		item := BulkDHCPSegment{SegmentView: v}
+		item.patch.DhcpEnabled = optionalField[bool]{Set: true, Value: &enable}
+		switch req.Policy {
+		case bulkDHCPAuto:
+			item.patch.DhcpRange = optionalField[string]{Set: true}
+		case bulkDHCPFirst, bulkDHCPLast:
+			rng, note := bulkDHCPRange(v, req.Policy, req.Count)
+			item.Note = note
+			if rng != "" {
+				item.patch.DhcpRange = optionalString(rng, true)
+			}
+		}
+		item.After = item.patch.applyTo(v.Segment)
+		item.Changed = len(segmentChanges(v.Segment, item.After)) > 0
+		if item.Changed {
+			preview.Changed++
+			if item.Locked {
+				preview.Locked++
+			}
+		}
+		afters = append(afters, item.After)
+		preview.Segments = append(preview.Segments, item)
+	}
+	for i, v := range buildSegmentViews(afters, nil, pools) {
+		preview.Segments[i].AfterRange = v.DhcpRange
+	}
+	return preview, nil
+}
+
+// bulkDHCPRange is a range of count addresses at the start or end of the
+// segment's IPv4 prefix.
+func bulkDHCPRange(v SegmentView, policy string, count int) (string, string) {
+	prefix, err := netip.ParsePrefix(v.CIDR)
+	if err != nil {
+		return "", "no IPv4 prefix allocated; the stored range is kept"
+	}
+	details, ok := prefixDetailsIPv4(prefix)
+	if !ok || details.FirstUsable == "" || details.LastUsable == "" {
+		return "", "no IPv4 prefix allocated; the stored range is kept"
+	}
+	first := ipv4ToU32(netip.MustParseAddr(details.FirstUsable))
+	last := ipv4ToU32(netip.MustParseAddr(details.LastUsable))
+	gw, gwOK := uint32(0), false
+	if addr, err := netip.ParseAddr(v.Gateway); err == nil && addr.Is4() {
+		gw, gwOK = ipv4ToU32(addr), true
+	}
+	if gwOK && gw == first {
+		first++
+	}
+	if gwOK && gw == last {
+		last--
+	}
+	if first > last {
+		return "", fmt.Sprintf("%s has no room for a DHCP range; the stored range is kept", prefix)
+	}
+	var note string
+	avail := uint64(last-first) + 1
+	if uint64(count) > avail {
+		note = fmt.Sprintf("only %d addresses available", avail)
+		count = int(avail)
+	}
+	start, end := first, first+uint32(count-1)
+	if policy == bulkDHCPLast {
+		start, end = last-uint32(count-1), last
+	}
+	if gwOK && gw > start && gw < end {
+		if note != "" {
+			note += "; "
+		}
+		note += "the gateway " + v.Gateway + " is inside the range"
+	}
+	return u32ToIPv4(start).String() + "-" + u32ToIPv4(end).String(), note
+}
+
+// applyBulkDHCP writes the changed segments in one transaction.
+func applyBulkDHCP(db *sql.DB, p BulkDHCPPreview) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, s := range p.Segments {
+		if !s.Changed {
+			continue
+		}
+		if err := applySegmentMetaPatch(tx, s.ID, s.patch); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
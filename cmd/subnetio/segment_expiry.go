@@ -0,0 +1,174 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Temporary segments carry an expiry time.
+const (
+	expiryActionRelease = "release"
+	expiryActionFlag    = "flag"
+
+	segmentExpiryWarnWindow = 7 * 24 * time.Hour
+	segmentExpiryInterval   = time.Minute
+	segmentExpiryLayout     = "2006-01-02T15:04:05Z"
+)
+
+// parseSegmentExpiry accepts a date (expiring at 00:00 UTC that day), a
+// datetime-local value or RFC 3339 and returns the stored UTC form.
+func parseSegmentExpiry(raw string) (sql.NullString, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return sql.NullString{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return sql.NullString{String: t.UTC().Format(segmentExpiryLayout), Valid: true}, nil
+		}
+	}
+	return sql.NullString{}, fmt.Errorf("invalid expiry %q: use YYYY-MM-DD or YYYY-MM-DDTHH:MM", raw)
+}
+
+func normalizeExpiryAction(raw string) string {
+	if strings.EqualFold(strings.TrimSpace(raw), expiryActionRelease) {
+		return expiryActionRelease
+	}
+	return expiryActionFlag
+}
+
+func segmentExpiresAt(s Segment) (time.Time, bool) {
+	if !s.ExpiresAt.Valid {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(segmentExpiryLayout, s.ExpiresAt.String)
+	return t, err == nil
+}
+
+// segmentLifetime is the remaining lifetime shown next to a segment.
+func segmentLifetime(s Segment, now time.Time) string {
+	at, ok := segmentExpiresAt(s)
+	if !ok {
+		return ""
+	}
+	left := at.Sub(now)
+	if s.ExpiredAt.Valid || left <= 0 {
+		return "expired " + at.Format("2006-01-02 15:04") + " UTC"
+	}
+	days := int(left / (24 * time.Hour))
+	hours := int(left % (24 * time.Hour) / time.Hour)
+	if days > 0 {
+		return fmt.Sprintf("expires in %dd %dh", days, hours)
+	}
+	return fmt.Sprintf("expires in %dh %dm", hours, int(left%time.Hour/time.Minute))
+}
+
+func analyzeExpiry(segs []Segment, statuses map[int64]SegmentStatus, now time.Time) []Conflict {
+	var out []Conflict
+	for _, s := range segs {
+		at, ok := segmentExpiresAt(s)
+		if !ok {
+			continue
+		}
+		var kind, detail string
+		switch {
+		case s.ExpiredAt.Valid || !at.After(now):
+			kind, detail = "SEGMENT_EXPIRED", "segment "+s.Name+" expired on "+at.Format("2006-01-02 15:04")+" UTC"
+		case at.Sub(now) <= segmentExpiryWarnWindow:
+			kind, detail = "SEGMENT_EXPIRING", "segment "+s.Name+" "+segmentLifetime(s, now)+" ("+normalizeExpiryAction(s.ExpiryAction.String)+")"
+		default:
+			continue
+		}
+		out = append(out, Conflict{Kind: kind, Detail: detail, Level: statusWarning.Label(), key: segmentConflictKey(s)})
+		st := statuses[s.ID]
+		if st.Level < statusWarning {
+			st.Level = statusWarning
+		}
+		st.Details = append(st.Details, detail)
+		statuses[s.ID] = st
+	}
+	return out
+}
+
+// expireSegments processes every segment whose expiry has passed and that has
+// not been handled yet.
+func expireSegments(db *sql.DB, now time.Time) (int, error) {
+	if getMaintenance(db).Enabled {
+		return 0, nil
+	}
+	rows, err := db.Query(`SELECT id FROM segments WHERE expires_at IS NOT NULL AND expires_at <= ? AND expired_at IS NULL ORDER BY id`, now.UTC().Format(segmentExpiryLayout))
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, id := range ids {
+		seg, ok := segmentByID(db, id)
+		if !ok {
+			continue
+		}
+		record := auditRecord{
+			ProjectID:   projectIDBySite(db, seg.SiteID),
+			Actor:       "scheduler",
+			Action:      "expire",
+			EntityType:  "segment",
+			EntityID:    sql.NullInt64{Int64: id, Valid: true},
+			EntityLabel: sql.NullString{String: seg.Name, Valid: true},
+			Reason:      sql.NullString{String: "segment expired at " + seg.ExpiresAt.String, Valid: true},
+			Before:      snapshotSegment(seg),
+		}
+		if normalizeExpiryAction(seg.ExpiryAction.String) == expiryActionRelease && seg.Locked {
+			record.Reason.String += "; locked, kept"
+		}
+		if normalizeExpiryAction(seg.ExpiryAction.String) == expiryActionRelease && !seg.Locked {
+			if err := deleteSegment(db, id); err != nil {
+				return done, err
+			}
+		} else {
+			if _, err := db.Exec(`UPDATE segments SET expired_at=? WHERE id=?`, now.UTC().Format(segmentExpiryLayout), id); err != nil {
+				return done, err
+			}
+			if after, ok := segmentByID(db, id); ok {
+				record.After = snapshotSegment(after)
+			}
+		}
+		if err := insertAuditRecord(db, record); err != nil {
+			log.Printf("segment expiry audit %d: %v", id, err)
+		}
+		done++
+	}
+	return done, nil
+}
+
+func startSegmentExpiry(db *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(segmentExpiryInterval)
+		defer ticker.Stop()
+		for {
+			if n, err := expireSegments(db, time.Now()); err != nil {
+				log.Printf("segment expiry: %v", err)
+			} else if n > 0 {
+				log.Printf("segment expiry: %d segment(s) expired", n)
+			}
+			<-ticker.C
+		}
+	}()
+}
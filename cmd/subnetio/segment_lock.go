@@ -0,0 +1,201 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A locked segment keeps its addressing and settings unless a change is
+// forced with a reason.
+var segmentLockFreeFields = map[string]bool{"notes": true, "tags": true, "expires_at": true, "expiry_action": true}
+
+var errSegmentForceReason = errors.New("force needs a reason")
+
+type segmentLockError struct {
+	Name   string
+	Op     string
+	Fields []string
+}
+
+func (e segmentLockError) Error() string {
+	if len(e.Fields) > 0 {
+		return fmt.Sprintf("segment %s is locked: changing %s needs force and a reason", e.Name, strings.Join(e.Fields, ", "))
+	}
+	return fmt.Sprintf("segment %s is locked: %s needs force and a reason", e.Name, e.Op)
+}
+
+// segmentForce is an explicit override of segment locks.
+type segmentForce struct {
+	Force  bool
+	Reason string
+}
+
+// segmentForceFromRequest reads force=1 and reason from the form or the
+// query string.
+func segmentForceFromRequest(c *gin.Context) segmentForce {
+	raw := c.PostForm("force")
+	if raw == "" {
+		raw = c.Query("force")
+	}
+	return segmentForce{Force: parseBool(raw), Reason: auditReason(c)}
+}
+
+func (f segmentForce) validate() error {
+	if f.Force && strings.TrimSpace(f.Reason) == "" {
+		return errSegmentForceReason
+	}
+	return nil
+}
+
+// auditReason is the reason recorded for a change that overrode a lock.
+func (f segmentForce) auditReason() sql.NullString {
+	return sql.NullString{String: "locked segment override: " + strings.TrimSpace(f.Reason), Valid: true}
+}
+
+// lockedFields drops the fields a locked segment may change freely.
+func lockedFields(fields []string) []string {
+	var out []string
+	for _, f := range fields {
+		if !segmentLockFreeFields[f] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// checkSegmentLock reports whether op may touch seg.
+func checkSegmentLock(seg Segment, op string, fields []string, force segmentForce) (bool, error) {
+	if !seg.Locked {
+		return false, nil
+	}
+	if fields != nil {
+		fields = lockedFields(fields)
+		if len(fields) == 0 {
+			return false, nil
+		}
+	}
+	if err := force.validate(); err != nil {
+		return false, err
+	}
+	if !force.Force {
+		return false, segmentLockError{Name: seg.Name, Op: op, Fields: fields}
+	}
+	return true, nil
+}
+
+// segmentChanges lists the columns that differ between two states of a
+// segment, named as in plan files.
+func segmentChanges(before, after Segment) []string {
+	var out []string
+	for _, f := range []struct {
+		name string
+		a, b any
+	}{
+		{"vrf", before.VRF, after.VRF},
+		{"vlan", before.VLAN, after.VLAN},
+		{"name", before.Name, after.Name},
+		{"hosts", before.Hosts, after.Hosts},
+		{"prefix", before.Prefix, after.Prefix},
+		{"prefix_v6", before.PrefixV6, after.PrefixV6},
+		{"cidr", before.CIDR, after.CIDR},
+		{"cidr_v6", before.CIDRV6, after.CIDRV6},
+		{"multi_prefix", before.MultiPrefix, after.MultiPrefix},
+		{"secondary_cidrs", before.SecondaryCIDRs, after.SecondaryCIDRs},
+		{"locked", before.Locked, after.Locked},
+		{"segment_kind", normalizeSegmentKind(before.Kind), normalizeSegmentKind(after.Kind)},
+		{"link_a", before.LinkA, after.LinkA},
+		{"link_b", before.LinkB, after.LinkB},
+		{"dhcp_enabled", before.DhcpEnabled, after.DhcpEnabled},
+		{"dhcp_range", before.DhcpRange, after.DhcpRange},
+		{"dhcp_reservations", before.DhcpReservations, after.DhcpReservations},
+		{"gateway", before.Gateway, after.Gateway},
+		{"gateway_v6", before.GatewayV6, after.GatewayV6},
+		{"pool_tier", before.PoolTier, after.PoolTier},
+		{"ipv6_mode", before.IPv6Mode, after.IPv6Mode},
+		{"ipv6_dns", before.IPv6DNS, after.IPv6DNS},
+		{"owner_team", before.OwnerTeam, after.OwnerTeam},
+		{"owner_email", before.OwnerEmail, after.OwnerEmail},
+		{"notes", before.Notes, after.Notes},
+		{"tags", before.Tags, after.Tags},
+		{"expires_at", before.ExpiresAt, after.ExpiresAt},
+		{"expiry_action", before.ExpiryAction, after.ExpiryAction},
+	} {
+		if lockCompareValue(f.a) != lockCompareValue(f.b) {
+			out = append(out, f.name)
+		}
+	}
+	return out
+}
+
+// lockCompareValue compares NULL and empty alike, as the forms store them.
+func lockCompareValue(v any) string {
+	switch v := v.(type) {
+	case sql.NullString:
+		return strings.TrimSpace(v.String)
+	case sql.NullInt64:
+		if !v.Valid {
+			return ""
+		}
+		return itoa64(v.Int64)
+	}
+	return fmt.Sprint(v)
+}
+
+// applyTo returns seg with the patch's meta columns applied.
+func (p segmentMetaPatch) applyTo(seg Segment) Segment {
+	if p.DhcpEnabled.Set {
+		seg.DhcpEnabled = p.DhcpEnabled.Value != nil && *p.DhcpEnabled.Value
+	}
+	for _, f := range []struct {
+		dst   *sql.NullString
+		field optionalField[string]
+	}{
+		{&seg.DhcpRange, p.DhcpRange},
+		{&seg.DhcpReservations, p.DhcpReservations},
+		{&seg.Gateway, p.Gateway},
+		{&seg.GatewayV6, p.GatewayV6},
+		{&seg.Notes, p.Notes},
+		{&seg.Tags, p.Tags},
+		{&seg.PoolTier, p.PoolTier},
+		{&seg.IPv6Mode, p.IPv6Mode},
+		{&seg.IPv6DNS, p.IPv6DNS},
+		{&seg.OwnerTeam, p.OwnerTeam},
+		{&seg.OwnerEmail, p.OwnerEmail},
+	} {
+		if !f.field.Set {
+			continue
+		}
+		if f.field.Value == nil {
+			*f.dst = sql.NullString{}
+		} else {
+			*f.dst = sql.NullString{String: *f.field.Value, Valid: *f.field.Value != ""}
+		}
+	}
+	return seg
+}
+
+// applyTo returns seg with the API patch applied.
+func (p segmentPatch) applyTo(seg Segment) Segment {
+	if p.VLAN.Set && p.VLAN.Value != nil {
+		seg.VLAN = *p.VLAN.Value
+	}
+	if p.Name.Set && p.Name.Value != nil {
+		seg.Name = strings.TrimSpace(*p.Name.Value)
+	}
+	if p.Hosts.Set {
+		seg.Hosts = sql.NullInt64{}
+		if p.Hosts.Value != nil {
+			seg.Hosts = sql.NullInt64{Int64: *p.Hosts.Value, Valid: true}
+		}
+	}
+	if p.Locked.Set && p.Locked.Value != nil {
+		seg.Locked = *p.Locked.Value
+	}
+	return segmentMetaPatch{Tags: p.Tags}.applyTo(seg)
+}
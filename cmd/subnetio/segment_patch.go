@@ -0,0 +1,232 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// optionalField records whether a JSON key was present at all, so that an
+// omitted field is left alone while an explicit null clears it.
+type optionalField[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (f *optionalField[T]) UnmarshalJSON(raw []byte) error {
+	f.Set = true
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		f.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	f.Value = &v
+	return nil
+}
+
+// segmentPatch is the body of PATCH /api/segments/:id.
+type segmentPatch struct {
+	VLAN   optionalField[int]    `json:"vlan"`
+	Name   optionalField[string] `json:"name"`
+	Hosts  optionalField[int64]  `json:"hosts"`
+	Tags   optionalField[string] `json:"tags"`
+	Locked optionalField[bool]   `json:"locked"`
+}
+
+func (p segmentPatch) empty() bool {
+	return !p.VLAN.Set && !p.Name.Set && !p.Hosts.Set && !p.Tags.Set && !p.Locked.Set
+}
+
+func (p segmentPatch) validate() error {
+	if p.empty() {
+		return errors.New("no fields to update")
+	}
+	if p.VLAN.Set && (p.VLAN.Value == nil || *p.VLAN.Value < 1 || *p.VLAN.Value > 4094) {
+		return errors.New("vlan must be between 1 and 4094")
+	}
+	if p.Name.Set && (p.Name.Value == nil || strings.TrimSpace(*p.Name.Value) == "") {
+		return errors.New("name is required")
+	}
+	if p.Hosts.Set && p.Hosts.Value != nil && *p.Hosts.Value <= 0 {
+		return errors.New("hosts must be positive")
+	}
+	if p.Locked.Set && p.Locked.Value == nil {
+		return errors.New("locked must be true or false")
+	}
+	return nil
+}
+
+// applySegmentPatch updates the supplied fields of one segment in a single
+// transaction.
+func applySegmentPatch(db *sql.DB, segmentID int64, p segmentPatch) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	var sets []string
+	var args []any
+	if p.VLAN.Set {
+		sets = append(sets, "vlan=?")
+		args = append(args, *p.VLAN.Value)
+	}
+	if p.Name.Set {
+		sets = append(sets, "name=?")
+		args = append(args, strings.TrimSpace(*p.Name.Value))
+	}
+	if p.Hosts.Set {
+		sets = append(sets, "hosts=?")
+		if p.Hosts.Value != nil {
+			args = append(args, *p.Hosts.Value)
+		} else {
+			args = append(args, nil)
+		}
+	}
+	if p.Locked.Set {
+		sets = append(sets, "locked=?")
+		args = append(args, boolToInt(*p.Locked.Value))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if len(sets) > 0 {
+		args = append(args, segmentID)
+		if _, err := tx.Exec(`UPDATE segments SET `+strings.Join(sets, ", ")+` WHERE id=?`, args...); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := applySegmentMetaPatch(tx, segmentID, segmentMetaPatch{Tags: p.Tags}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func optionalString(value string, present bool) optionalField[string] {
+	if !present {
+		return optionalField[string]{}
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return optionalField[string]{Set: true}
+	}
+	return optionalField[string]{Set: true, Value: &value}
+}
+
+// segmentMetaPatch updates segment_meta column by column: an unset field is
+// left unchanged, a nil value clears the column and anything else sets it.
+type segmentMetaPatch struct {
+	DhcpEnabled      optionalField[bool]
+	DhcpRange        optionalField[string]
+	DhcpReservations optionalField[string]
+	Gateway          optionalField[string]
+	GatewayV6        optionalField[string]
+	Notes            optionalField[string]
+	Tags             optionalField[string]
+	PoolTier         optionalField[string]
+	IPv6Mode         optionalField[string]
+	IPv6DNS          optionalField[string]
+	OwnerTeam        optionalField[string]
+	OwnerEmail       optionalField[string]
+}
+
+func (p segmentMetaPatch) columns() ([]string, []any) {
+	var cols []string
+	var vals []any
+	if p.DhcpEnabled.Set {
+		cols = append(cols, "dhcp_enabled")
+		vals = append(vals, boolToInt(p.DhcpEnabled.Value != nil && *p.DhcpEnabled.Value))
+	}
+	for _, f := range []struct {
+		col   string
+		field optionalField[string]
+	}{
+		{"dhcp_range", p.DhcpRange},
+		{"dhcp_reservations", p.DhcpReservations},
+		{"gateway", p.Gateway},
+		{"gateway_v6", p.GatewayV6},
+		{"notes", p.Notes},
+		{"tags", p.Tags},
+		{"pool_tier", p.PoolTier},
+		{"ipv6_mode", p.IPv6Mode},
+		{"ipv6_dns", p.IPv6DNS},
+		{"owner_team", p.OwnerTeam},
+		{"owner_email", p.OwnerEmail},
+	} {
+		if !f.field.Set {
+			continue
+		}
+		cols = append(cols, f.col)
+		if f.field.Value == nil {
+			vals = append(vals, nil)
+		} else {
+			vals = append(vals, nullStringToAny(*f.field.Value))
+		}
+	}
+	return cols, vals
+}
+
+// applySegmentMetaPatch writes the set columns and drops the row once
+// nothing is left in it.
+func applySegmentMetaPatch(db sqlConn, segmentID int64, p segmentMetaPatch) error {
+	cols, vals := p.columns()
+	if len(cols) == 0 {
+		return nil
+	}
+	updates := make([]string, 0, len(cols))
+	for _, col := range cols {
+		updates = append(updates, col+"=excluded."+col)
+	}
+	args := append([]any{segmentID}, vals...)
+	if _, err := db.Exec(`
+		INSERT INTO segment_meta(segment_id, `+strings.Join(cols, ", ")+`)
+		VALUES(?`+strings.Repeat(", ?", len(cols))+`)
+		ON CONFLICT(segment_id) DO UPDATE SET `+strings.Join(updates, ", "), args...); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		DELETE FROM segment_meta
+		WHERE segment_id=? AND dhcp_enabled=0 AND dhcp_range IS NULL AND dhcp_reservations IS NULL
+			AND gateway IS NULL AND gateway_v6 IS NULL AND notes IS NULL AND tags IS NULL AND pool_tier IS NULL
+			AND ipv6_mode IS NULL AND ipv6_dns IS NULL AND owner_team IS NULL AND owner_email IS NULL`, segmentID)
+	return err
+}
+
+// segmentMetaPatchFromForm reads the meta fields of the segment edit form.
+func segmentMetaPatchFromForm(c *gin.Context) segmentMetaPatch {
+	field := func(key string) optionalField[string] {
+		value, ok := c.GetPostForm(key)
+		return optionalString(value, ok)
+	}
+	p := segmentMetaPatch{
+		DhcpRange:        field("dhcp_range"),
+		DhcpReservations: field("dhcp_reservations"),
+		Gateway:          field("gateway"),
+		GatewayV6:        field("gateway_v6"),
+		Notes:            field("notes"),
+		Tags:             field("tags"),
+		PoolTier:         field("pool_tier"),
+		IPv6Mode:         ipv6ModeField(field("ipv6_mode")),
+		IPv6DNS:          field("ipv6_dns"),
+		OwnerTeam:        field("owner_team"),
+		OwnerEmail:       field("owner_email"),
+	}
+	if values, ok := c.GetPostFormArray("dhcp_enabled"); ok {
+		enabled := false
+		for _, v := range values {
+			enabled = enabled || v == "on"
+		}
+		p.DhcpEnabled = optionalField[bool]{Set: true, Value: &enabled}
+	}
+	return p
+}
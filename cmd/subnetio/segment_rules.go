@@ -0,0 +1,120 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SegmentCheckWarn  = "warn"
+	SegmentCheckBlock = "block"
+)
+
+const (
+	minVLAN = 1
+	maxVLAN = 4094
+)
+
+type vlanRange struct {
+	Lo int
+	Hi int
+}
+
+func normalizeSegmentCheck(raw string) string {
+	if strings.ToLower(strings.TrimSpace(raw)) == SegmentCheckBlock {
+		return SegmentCheckBlock
+	}
+	return SegmentCheckWarn
+}
+
+// parseVLANRanges reads "100-199, 300, 400-499".
+func parseVLANRanges(raw string) ([]vlanRange, error) {
+	var out []vlanRange
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		if !isRange {
+			hi = lo
+		}
+		a, errA := strconv.Atoi(strings.TrimSpace(lo))
+		b, errB := strconv.Atoi(strings.TrimSpace(hi))
+		if errA != nil || errB != nil || a < minVLAN || b > maxVLAN || a > b {
+			return nil, fmt.Errorf("invalid VLAN range: %s (use 1-4094, e.g. 100-199,300)", part)
+		}
+		out = append(out, vlanRange{Lo: a, Hi: b})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Lo < out[j].Lo })
+	return out, nil
+}
+
+func formatVLANRanges(ranges []vlanRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		if r.Lo == r.Hi {
+			parts = append(parts, itoa(r.Lo))
+		} else {
+			parts = append(parts, itoa(r.Lo)+"-"+itoa(r.Hi))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func vlanInRanges(vlan int, ranges []vlanRange) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if vlan >= r.Lo && vlan <= r.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// projectVRFs lists the VRFs used by the project's segments, leaving out
+// segment exceptID so an edit does not vouch for itself.
+func projectVRFs(db sqlConn, projectID, exceptID int64) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT s.vrf
+		FROM segments s
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		WHERE ps.project_id=? AND s.id<>?`, projectID, exceptID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]bool{}
+	for rows.Next() {
+		var vrf string
+		if err := rows.Scan(&vrf); err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(vrf)] = true
+	}
+	return out, rows.Err()
+}
+
+// checkSegmentRules validates a segment's VLAN and VRF against the project
+// rules.
+func checkSegmentRules(rules ProjectRules, vrf string, vlan int, known map[string]bool) ([]string, error) {
+	if vlan < minVLAN || vlan > maxVLAN {
+		return nil, fmt.Errorf("VLAN %d is outside 1-4094", vlan)
+	}
+	var findings []string
+	ranges, _ := parseVLANRanges(rules.VLANRanges)
+	if !vlanInRanges(vlan, ranges) {
+		findings = append(findings, fmt.Sprintf("VLAN %d is outside the project ranges %s", vlan, formatVLANRanges(ranges)))
+	}
+	vrf = strings.TrimSpace(vrf)
+	if (len(known) > 0 || rules.DefaultVRF != "") && vrf != rules.DefaultVRF && !known[vrf] {
+		findings = append(findings, fmt.Sprintf("VRF %s is not used in the project yet", vrf))
+	}
+	if len(findings) > 0 && rules.SegmentCheck == SegmentCheckBlock {
+		return nil, errors.New(strings.Join(findings, "; "))
+	}
+	return findings, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ACMEDomains  []string
+	ACMEEmail    string
+	ACMECacheDir string
+	RedirectAddr string
+	HSTSMaxAge   int
+}
+
+func loadTLSConfig(dbPath string) TLSConfig {
+	cfg := TLSConfig{
+		CertFile:     mustEnv("TLS_CERT_FILE", ""),
+		KeyFile:      mustEnv("TLS_KEY_FILE", ""),
+		ACMEDomains:  splitCSV(mustEnv("ACME_DOMAINS", "")),
+		ACMEEmail:    mustEnv("ACME_EMAIL", ""),
+		ACMECacheDir: mustEnv("ACME_CACHE_DIR", filepath.Join(filepath.Dir(dbPath), "acme")),
+		RedirectAddr: mustEnv("HTTP_REDIRECT_ADDR", ""),
+		HSTSMaxAge:   atoiDefault(mustEnv("HSTS_MAX_AGE", ""), 31536000),
+	}
+	return cfg
+}
+
+func (cfg TLSConfig) Enabled() bool {
+	return cfg.UsesACME() || (cfg.CertFile != "" && cfg.KeyFile != "")
+}
+
+func (cfg TLSConfig) UsesACME() bool {
+	return len(cfg.ACMEDomains) > 0
+}
+
+func (cfg TLSConfig) Scheme() string {
+	if cfg.Enabled() {
+		return "https"
+	}
+	return "http"
+}
+
+func (cfg TLSConfig) Validate() error {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.UsesACME() && cfg.CertFile != "" {
+		return fmt.Errorf("ACME_DOMAINS cannot be combined with TLS_CERT_FILE/TLS_KEY_FILE")
+	}
+	return nil
+}
+
+func hstsMiddleware(maxAge int) gin.HandlerFunc {
+	value := "max-age=" + strconv.Itoa(maxAge) + "; includeSubDomains"
+	return func(c *gin.Context) {
+		c.Header("Strict-Transport-Security", value)
+		c.Next()
+	}
+}
+
+func httpsRedirectHandler(listen string) http.Handler {
+	_, tlsPort, _ := net.SplitHostPort(listen)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host += ":" + tlsPort
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+func serve(listen string, handler http.Handler, cfg TLSConfig) error {
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !cfg.Enabled() {
+		return srv.ListenAndServe()
+	}
+
+	redirect := httpsRedirectHandler(listen)
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.UsesACME() {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Cache:      autocert.DirCache(cfg.ACMECacheDir),
+			Email:      cfg.ACMEEmail,
+		}
+		srv.TLSConfig = manager.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+		redirect = manager.HTTPHandler(redirect)
+		if cfg.RedirectAddr == "" {
+			log.Printf("ACME enabled without HTTP_REDIRECT_ADDR; only TLS-ALPN challenges will work")
+		}
+	}
+	if cfg.RedirectAddr != "" {
+		go func() {
+			redirectSrv := &http.Server{
+				Addr:              cfg.RedirectAddr,
+				Handler:           redirect,
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			if err := redirectSrv.ListenAndServe(); err != nil {
+				log.Printf("http redirect listener: %v", err)
+			}
+		}()
+	}
+	return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+type SizingPreset struct {
+	Key         string `json:"key"`
+	Label       string `json:"label"`
+	Reserved    int    `json:"reserved"`
+	MinPrefix   int    `json:"min_prefix"`
+	MaxPrefix   int    `json:"max_prefix"`
+	Kubernetes  bool   `json:"kubernetes"`
+	DefaultPods int    `json:"default_pods_per_node,omitempty"`
+}
+
+var sizingPresets = []SizingPreset{
+	{Key: "aws", Label: "AWS VPC subnet", Reserved: 5, MinPrefix: 16, MaxPrefix: 28},
+	{Key: "azure", Label: "Azure VNet subnet", Reserved: 5, MinPrefix: 8, MaxPrefix: 29},
+	{Key: "gcp", Label: "Google Cloud subnet", Reserved: 4, MinPrefix: 8, MaxPrefix: 29},
+	{Key: "eks", Label: "EKS (VPC CNI)", Reserved: 5, MinPrefix: 16, MaxPrefix: 28, Kubernetes: true, DefaultPods: 29},
+	{Key: "aks", Label: "AKS (Azure CNI)", Reserved: 5, MinPrefix: 8, MaxPrefix: 29, Kubernetes: true, DefaultPods: 30},
+	{Key: "gke", Label: "GKE (VPC-native)", Reserved: 4, MinPrefix: 8, MaxPrefix: 29, Kubernetes: true, DefaultPods: 110},
+}
+
+// gkeServicesPrefix is the size GKE gives the services range by default.
+const gkeServicesPrefix = 20
+
+const maxSizingNodes = 5000
+
+func sizingPresetByKey(key string) (SizingPreset, bool) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	for _, p := range sizingPresets {
+		if p.Key == key {
+			return p, true
+		}
+	}
+	return SizingPreset{}, false
+}
+
+type SizingRequest struct {
+	Preset      string `json:"preset"`
+	Hosts       int    `json:"hosts,omitempty"`
+	Nodes       int    `json:"nodes,omitempty"`
+	PodsPerNode int    `json:"pods_per_node,omitempty"`
+}
+
+type SizingRange struct {
+	Name      string `json:"name"`
+	Prefix    int    `json:"prefix"`
+	Addresses int    `json:"addresses"`
+}
+
+type SizingResult struct {
+	Preset    SizingPreset  `json:"preset"`
+	Addresses int           `json:"addresses"`
+	Reserved  int           `json:"reserved"`
+	Prefix    int           `json:"prefix"`
+	Extra     []SizingRange `json:"extra,omitempty"`
+	Formula   string        `json:"formula"`
+}
+
+// Warnings describes the extra ranges, which are not allocated with the
+// segment.
+func (r SizingResult) Warnings() []string {
+	var out []string
+	for _, x := range r.Extra {
+		out = append(out, fmt.Sprintf("%s also needs a %s range of /%d (%d addresses), planned separately", r.Preset.Label, x.Name, x.Prefix, x.Addresses))
+	}
+	return out
+}
+
+// prefixForAddresses is the longest IPv4 prefix holding n addresses.
+func prefixForAddresses(n int) int {
+	if n <= 1 {
+		return 32
+	}
+	return 32 - bits.Len(uint(n-1))
+}
+
+func computeSizing(req SizingRequest) (SizingResult, error) {
+	preset, ok := sizingPresetByKey(req.Preset)
+	if !ok {
+		return SizingResult{}, fmt.Errorf("unknown sizing preset %q", req.Preset)
+	}
+	res := SizingResult{Preset: preset, Reserved: preset.Reserved}
+	pods := req.PodsPerNode
+	if pods == 0 {
+		pods = preset.DefaultPods
+	}
+	switch {
+	case !preset.Kubernetes:
+		if req.Hosts < 1 || req.Hosts > maxFormInt {
+			return res, fmt.Errorf("%s sizing needs a number of hosts from 1 to %d", preset.Label, maxFormInt)
+		}
+		res.Addresses = req.Hosts
+		res.Formula = fmt.Sprintf("%d hosts", req.Hosts)
+	case req.Nodes < 1:
+		return res, fmt.Errorf("%s sizing needs the number of nodes", preset.Label)
+	case req.Nodes > maxSizingNodes:
+		return res, fmt.Errorf("nodes must be at most %d", maxSizingNodes)
+	case pods < 1 || pods > 250:
+		return res, fmt.Errorf("pods per node must be from 1 to 250")
+	case preset.Key == "eks":
+		res.Addresses = req.Nodes * (pods + 1)
+		res.Formula = fmt.Sprintf("%d nodes × (%d pods + 1)", req.Nodes, pods)
+	case preset.Key == "aks":
+		res.Addresses = (req.Nodes + 1) * (pods + 1)
+		res.Formula = fmt.Sprintf("(%d nodes + 1 for upgrades) × (%d pods + 1)", req.Nodes, pods)
+	case preset.Key == "gke":
+		res.Addresses = req.Nodes
+		res.Formula = fmt.Sprintf("%d nodes; pods in a secondary range", req.Nodes)
+		perNode := 1 << (32 - prefixForAddresses(2*pods))
+		res.Extra = append(res.Extra,
+			SizingRange{Name: "pod", Prefix: prefixForAddresses(req.Nodes * perNode), Addresses: req.Nodes * perNode},
+			SizingRange{Name: "service", Prefix: gkeServicesPrefix, Addresses: 1 << (32 - gkeServicesPrefix)},
+		)
+	}
+	res.Prefix = prefixForAddresses(res.Addresses + res.Reserved)
+	if res.Prefix > preset.MaxPrefix {
+		res.Prefix = preset.MaxPrefix
+	}
+	if res.Prefix < preset.MinPrefix {
+		return res, fmt.Errorf("%s needs a /%d (%d addresses) but allows at most a /%d", preset.Label, res.Prefix, res.Addresses+res.Reserved, preset.MinPrefix)
+	}
+	return res, nil
+}
+
+// sizeSegmentForm applies the sizing preset chosen on a segment form.
+func sizeSegmentForm(f *FormState) (SizingResult, bool) {
+	key := f.trimmed("sizing")
+	if key == "" {
+		return SizingResult{}, false
+	}
+	preset, ok := sizingPresetByKey(key)
+	if !ok {
+		f.Fail("sizing", "Unknown sizing preset %q.", key)
+		return SizingResult{}, false
+	}
+	req := SizingRequest{Preset: preset.Key}
+	req.Hosts, _ = strconv.Atoi(f.trimmed("hosts"))
+	if preset.Kubernetes {
+		f.checkInt("nodes", "Nodes", 1, maxSizingNodes)
+		f.checkInt("pods_per_node", "Pods per node", 1, 250)
+		if f.trimmed("nodes") == "" {
+			f.Fail("nodes", "Nodes are required for %s.", preset.Label)
+		}
+		req.Nodes, _ = strconv.Atoi(f.trimmed("nodes"))
+		req.PodsPerNode, _ = strconv.Atoi(f.trimmed("pods_per_node"))
+	} else if req.Hosts < 1 {
+		f.Fail("hosts", "Hosts are required for %s.", preset.Label)
+	}
+	if f.Failed() {
+		return SizingResult{}, false
+	}
+	res, err := computeSizing(req)
+	if err != nil {
+		f.Fail("sizing", "%s", err.Error())
+		return SizingResult{}, false
+	}
+	return res, true
+}
@@ -3,9 +3,37 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
 
 	_ "modernc.org/sqlite"
 )
@@ -67,7 +95,7 @@ func TestSmokeAllocate(t *testing.T) {
 		t.Fatalf("insert segment: %v", err)
 	}
 
-	if err := allocateProject(db, projectID); err != nil {
+	if err := allocateProject(db, projectID, nil); err != nil {
 		t.Fatalf("allocate: %v", err)
 	}
 
@@ -120,7 +148,7 @@ func TestSmokeAllocate(t *testing.T) {
 }
 
 func TestTemplatesParse(t *testing.T) {
-	names := []string{"projects", "sites", "segments", "conflicts", "planning", "generate", "export", "rules"}
+	names := []string{"projects", "sites", "segments", "conflicts", "planning", "generate", "export", "rules", "map", "generations", "config_storage", "template_vars", "integrity", "legacy_import", "macs", "devices", "nat", "report", "dashboard", "segments_bulk_delete", "segments_bulk_dhcp", "pool_migrate", "renumber", "federation", "dhcp_settings", "health", "compare", "overlaps", "routes", "error", "history"}
 	for _, name := range names {
 		if _, err := loadTemplate(name); err != nil {
 			t.Fatalf("template %s: %v", name, err)
@@ -192,6 +220,51 @@ func TestRulesStorageAndPolicy(t *testing.T) {
 	}
 }
 
+func TestIntegrityCleanup(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:integrity?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, err := db.Exec(`INSERT INTO sites(name) VALUES('LOST')`)
+	if err != nil {
+		t.Fatalf("insert site: %v", err)
+	}
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, "10.70.0.0/24")
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, notes) VALUES(999, 'stale')`)
+
+	groups, err := checkIntegrity(db)
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	counts := map[string]int{}
+	for _, g := range groups {
+		counts[g.Kind] = len(g.Issues)
+	}
+	if counts["site_without_project"] != 1 || counts["segment_meta_orphan"] != 1 || counts["pool_unused"] != 1 {
+		t.Fatalf("unexpected issues: %+v", counts)
+	}
+
+	removed, err := cleanupIntegrity(db, "site_without_project", siteID)
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("cleanup site: %v %+v", err, removed)
+	}
+	if _, err := cleanupIntegrity(db, "segment_meta_orphan", 0); err != nil {
+		t.Fatalf("cleanup meta: %v", err)
+	}
+	groups, _ = checkIntegrity(db)
+	for _, g := range groups {
+		if len(g.Issues) != 0 {
+			t.Fatalf("issues left after cleanup: %s %+v", g.Kind, g.Issues)
+		}
+	}
+}
+
 func TestReservedOverlapConflict(t *testing.T) {
 	db, err := sql.Open("sqlite", "file::memory:?cache=shared")
 	if err != nil {
@@ -252,3 +325,6240 @@ func TestReservedOverlapConflict(t *testing.T) {
 		}
 	}
 }
+
+func TestTemplateIPFuncs(t *testing.T) {
+	if got, err := cidrHost("10.1.2.0/24", 1); err != nil || got != "10.1.2.1" {
+		t.Fatalf("cidrHost first: %q %v", got, err)
+	}
+	if got, err := cidrHost("10.1.2.0/24", -1); err != nil || got != "10.1.2.254" {
+		t.Fatalf("cidrHost last: %q %v", got, err)
+	}
+	if _, err := cidrHost("10.1.2.0/30", 3); err == nil {
+		t.Fatalf("expected cidrHost out of range error")
+	}
+	if got, _ := cidrNetmask("10.1.2.0/22"); got != "255.255.252.0" {
+		t.Fatalf("cidrNetmask: %q", got)
+	}
+	if got, _ := wildcardMask("10.1.2.0/22"); got != "0.0.3.255" {
+		t.Fatalf("wildcardMask: %q", got)
+	}
+	subnets, err := cidrSubnets("10.1.0.0/24", 2)
+	if err != nil || len(subnets) != 4 || subnets[3] != "10.1.0.192/26" {
+		t.Fatalf("cidrSubnets: %v %v", subnets, err)
+	}
+	if got, _ := ip6Compress("2001:0db8:0000:0000:0000:0000:0000:0001"); got != "2001:db8::1" {
+		t.Fatalf("ip6Compress: %q", got)
+	}
+	if !inCIDR("10.1.2.5", "10.1.2.0/24") || inCIDR("10.1.3.5", "10.1.2.0/24") {
+		t.Fatalf("inCIDR mismatch")
+	}
+	if got, _ := offsetAddr("10.1.2.1", 2); got != "10.1.2.3" {
+		t.Fatalf("offsetAddr: %q", got)
+	}
+	if got, _ := cidrHost(netip.MustParsePrefix("2001:db8::/64"), 1); got != "2001:db8::1" {
+		t.Fatalf("cidrHost v6: %q", got)
+	}
+}
+
+func TestPoolWhatIfResize(t *testing.T) {
+	sites := []Site{{ID: 1, Name: "SAI"}}
+	pools := []Pool{
+		{ID: 1, SiteID: 1, Site: "SAI", CIDR: "10.40.0.0/24", Family: "ipv4"},
+		{ID: 2, SiteID: 1, Site: "SAI", CIDR: "10.41.0.0/24", Family: "ipv4"},
+	}
+	segs := []Segment{
+		{ID: 1, SiteID: 1, Site: "SAI", VRF: "PROD", VLAN: 10, Name: "users", Prefix: sql.NullInt64{Int64: 26, Valid: true}, CIDR: sql.NullString{String: "10.40.0.128/26", Valid: true}},
+		{ID: 2, SiteID: 1, Site: "SAI", VRF: "MGMT", VLAN: 20, Name: "mgmt", Prefix: sql.NullInt64{Int64: 26, Valid: true}, CIDR: sql.NullString{String: "10.40.0.192/26", Valid: true}, Locked: true},
+	}
+	change := PoolChange{Pool: pools[0], Action: "resize", NewCIDR: "10.40.0.0/25"}
+	result := runPoolWhatIfPlan(segs, pools, sites, change, defaultProjectRules())
+	if len(result.Outside) != 2 {
+		t.Fatalf("expected 2 segments outside, got %d", len(result.Outside))
+	}
+	if result.Absorbed {
+		t.Fatalf("locked segment outside pool must block absorption")
+	}
+
+	change = PoolChange{Pool: pools[0], Action: "remove"}
+	segs[1].Locked = false
+	result = runPoolWhatIfPlan(segs, pools, sites, change, defaultProjectRules())
+	if !result.Absorbed || len(result.Unallocated) != 0 {
+		t.Fatalf("expected remaining pool to absorb segments: %s", result.Summary)
+	}
+}
+
+func TestFetchRDAP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ip/203.0.112.0/24" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"handle":"NET-203-0-112-0-1","name":"EXAMPLE-NET","country":"KZ","startAddress":"203.0.112.0","endAddress":"203.0.112.255",
+			"events":[{"eventAction":"last changed","eventDate":"2024-01-01T00:00:00Z"},{"eventAction":"registration","eventDate":"2019-05-02T00:00:00Z"}],
+			"entities":[{"roles":["registrant"],"vcardArray":["vcard",[["version",{},"text","4.0"],["fn",{},"text","Example Telecom LLP"]]]}]}`))
+	}))
+	defer srv.Close()
+
+	info, err := fetchRDAP(srv.Client(), srv.URL, netip.MustParsePrefix("203.0.112.0/24"))
+	if err != nil {
+		t.Fatalf("fetchRDAP: %v", err)
+	}
+	if info.Org != "Example Telecom LLP" || info.Country != "KZ" || info.RegisteredAt != "2019-05-02T00:00:00Z" {
+		t.Fatalf("unexpected rdap info: %+v", info)
+	}
+	if rdapMismatch(info, []string{"example telecom"}) {
+		t.Fatalf("expected org match")
+	}
+	if !rdapMismatch(info, []string{"Other Org"}) {
+		t.Fatalf("expected org mismatch")
+	}
+	if isPublicPrefix(netip.MustParsePrefix("10.0.0.0/8")) || isPublicPrefix(netip.MustParsePrefix("100.64.0.0/16")) {
+		t.Fatalf("private ranges must not be public")
+	}
+}
+
+func TestAllocationValidator(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/deny":
+			_, _ = w.Write([]byte(`{"allowed":false,"message":"10.0.0.0/8 is reserved for security"}`))
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("blocked by policy"))
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			_, _ = w.Write([]byte(`{"allowed":true}`))
+		}
+	}))
+	defer srv.Close()
+
+	t.Setenv("SUBNETIO_SECRET_VALIDATOR", "s3cret")
+	payload := validatorRequest{ProjectID: 1, Project: "Default"}
+	if err := callAllocationValidator(AllocationValidator{URL: srv.URL + "/ok", TokenRef: "secret:validator", TimeoutSeconds: 5}, payload); err != nil {
+		t.Fatalf("allow: %v", err)
+	}
+	if gotAuth != "Bearer s3cret" {
+		t.Fatalf("unexpected auth header %q", gotAuth)
+	}
+	err := callAllocationValidator(AllocationValidator{URL: srv.URL + "/deny", TimeoutSeconds: 5}, payload)
+	if !isValidatorError(err) || err.Error() != "rejected by validator: 10.0.0.0/8 is reserved for security" {
+		t.Fatalf("deny: %v", err)
+	}
+	err = callAllocationValidator(AllocationValidator{URL: srv.URL + "/forbidden", TimeoutSeconds: 5}, payload)
+	if !isValidatorError(err) || err.Error() != "rejected by validator: blocked by policy" {
+		t.Fatalf("forbidden: %v", err)
+	}
+	if err := callAllocationValidator(AllocationValidator{URL: srv.URL + "/down", TimeoutSeconds: 5, FailOpen: true}, payload); err != nil {
+		t.Fatalf("fail open: %v", err)
+	}
+	if err := validateAllocationValidator(AllocationValidator{URL: srv.URL, TokenRef: "plain", TimeoutSeconds: 5}); err == nil {
+		t.Fatalf("expected plaintext token to be rejected")
+	}
+}
+
+func TestIPv6SchemeAllocation(t *testing.T) {
+	rules := defaultProjectRules()
+	rules.IPv6Scheme = "site=48-55; vrf=56-59; sites=ALA:3; vrfs=PROD:1,MGMT:2"
+	pools := []Pool{{ID: 1, SiteID: 7, Site: "ALA", CIDR: "2001:db8:100::/48", Family: "ipv6"}}
+	segs := []Segment{
+		{ID: 1, SiteID: 7, Site: "ALA", VRF: "PROD", VLAN: 10, Name: "users", PrefixV6: sql.NullInt64{Int64: 64, Valid: true}},
+		{ID: 2, SiteID: 7, Site: "ALA", VRF: "MGMT", VLAN: 20, Name: "mgmt", PrefixV6: sql.NullInt64{Int64: 64, Valid: true}},
+	}
+	_, planV6, conflicts := planAllocations(segs, pools, nil, nil, rules)
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts: %+v", conflicts)
+	}
+	if got := planV6[1].String(); got != "2001:db8:100:310::/64" {
+		t.Fatalf("users v6: %s", got)
+	}
+	if got := planV6[2].String(); got != "2001:db8:100:320::/64" {
+		t.Fatalf("mgmt v6: %s", got)
+	}
+
+	segs[0].CIDRV6 = sql.NullString{String: "2001:db8:100:320::/64", Valid: true}
+	statuses, all := analyzeAll(segs, pools, []Site{{ID: 7, Name: "ALA"}}, rules)
+	found := false
+	for _, c := range all {
+		if c.Kind == "IPV6_SCHEME" {
+			found = true
+		}
+	}
+	if !found || statuses[1].Level < statusWarning {
+		t.Fatalf("expected IPV6_SCHEME warning for misplaced segment")
+	}
+}
+
+func TestPlanPoolSplit(t *testing.T) {
+	sites := []Site{{ID: 1, Name: "ALA"}, {ID: 2, Name: "AST"}, {ID: 3, Name: "SHY"}}
+	pools := []Pool{{ID: 9, SiteID: 1, Site: "ALA", CIDR: "10.128.0.0/16", Family: "ipv4"}}
+	req := PoolSplitRequest{
+		Supernet:    netip.MustParsePrefix("10.128.0.0/12"),
+		DefaultBits: 16,
+		Sizes:       map[int64]int{2: 14},
+		SiteIDs:     map[int64]bool{1: true, 2: true, 3: true},
+	}
+	plan := planPoolSplit(req, sites, pools)
+	if plan.Failed != 0 || len(plan.Assignments) != 3 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	want := map[string]string{"AST": "10.132.0.0/14", "ALA": "10.129.0.0/16", "SHY": "10.130.0.0/16"}
+	for _, a := range plan.Assignments {
+		if a.CIDR != want[a.Site] {
+			t.Fatalf("%s: got %s, want %s", a.Site, a.CIDR, want[a.Site])
+		}
+	}
+
+	req.Sizes = map[int64]int{2: 11}
+	if plan := planPoolSplit(req, sites, pools); plan.Failed != 1 {
+		t.Fatalf("expected oversized site to fail: %+v", plan)
+	}
+}
+
+func TestPHPIPAMDumpImport(t *testing.T) {
+	dump := "CREATE TABLE `vlans` (\n  `vlanId` int(11) NOT NULL,\n  `name` varchar(255),\n  `number` int(4)\n) ENGINE=InnoDB;\n" +
+		"INSERT INTO `sections` (`id`, `name`) VALUES (1,'Campus');\n" +
+		"INSERT INTO `vlans` VALUES (5,'users',110),(6,'voice;it\\'s',120);\n" +
+		"INSERT INTO `vrf` (`vrfId`, `name`) VALUES (2,'PROD');\n" +
+		"INSERT INTO `locations` (`id`, `name`) VALUES (3,'ALA');\n" +
+		"INSERT INTO `subnets` (`id`, `subnet`, `mask`, `sectionId`, `description`, `vrfId`, `masterSubnetId`, `vlanId`, `isFolder`, `isPool`, `location`) VALUES " +
+		"(10,'167772160','16',1,'campus',0,0,0,0,0,3)," +
+		"(11,'167772416','24',1,'users',2,10,5,0,0,3)," +
+		"(12,'167772672','24',1,NULL,0,10,0,0,0,3);\n"
+	tables, err := parsePHPIPAMDump(dump)
+	if err != nil {
+		t.Fatalf("parse dump: %v", err)
+	}
+	if len(tables["vlans"]) != 2 || tables["vlans"][1]["name"] != "voice;it's" {
+		t.Fatalf("unexpected vlans: %+v", tables["vlans"])
+	}
+	data, warnings := parsePHPIPAMTables(tables)
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	rows, warnings := phpIPAMPlanRows(data, "")
+	if len(warnings) != 1 {
+		t.Fatalf("expected warning for subnet without vlan, got %v", warnings)
+	}
+	var pools, segs []PlanRow
+	for _, row := range rows {
+		switch row.RowType {
+		case planRowPool:
+			pools = append(pools, row)
+		case planRowSegment:
+			segs = append(segs, row)
+		}
+	}
+	if len(pools) != 1 || pools[0].Pool != "10.0.0.0/16" || pools[0].Site != "ALA" || pools[0].Project != "Campus" {
+		t.Fatalf("unexpected pools: %+v", pools)
+	}
+	if len(segs) != 1 || segs[0].CIDR != "10.0.1.0/24" || segs[0].VRF != "PROD" || *segs[0].VLAN != 110 {
+		t.Fatalf("unexpected segments: %+v", segs)
+	}
+}
+
+func TestAnalyzeLegacySheet(t *testing.T) {
+	rows := [][]string{
+		{"IP plan Almaty"},
+		{},
+		{"#", "Назначение", "VLAN", "Подсеть", "Шлюз"},
+		{"1", "users", "110", "10.1.10.0/24", "10.1.10.1"},
+		{"2", "voice", "vlan120", "10.1.20.5 255.255.255.0", "10.1.20.1"},
+		{"3", "printers", "", "10.1.30.0/255.255.255.0", "10.9.9.9"},
+		{"", "total", "", "", ""},
+	}
+	sheet, out := analyzeLegacySheet("ALA", rows)
+	if sheet == nil || sheet.HeaderRow != 2 {
+		t.Fatalf("header not detected: %+v", sheet)
+	}
+	if len(out) != 3 || sheet.Skipped != 1 {
+		t.Fatalf("unexpected rows: %+v (skipped %d)", out, sheet.Skipped)
+	}
+	if out[0].CIDR != "10.1.10.0/24" || out[0].VLAN != 110 || out[0].Name != "users" || out[0].Site != "ALA" || !out[0].Include {
+		t.Fatalf("row 1: %+v", out[0])
+	}
+	if out[1].CIDR != "10.1.20.0/24" || out[1].VLAN != 120 || out[1].Percent() >= out[0].Percent() {
+		t.Fatalf("row 2: %+v", out[1])
+	}
+	if out[2].Include || out[2].Gateway != "" {
+		t.Fatalf("row 3 should be excluded without VLAN and drop foreign gateway: %+v", out[2])
+	}
+}
+
+func TestSecretRefs(t *testing.T) {
+	t.Setenv("SUBNETIO_SECRET_WEBHOOK_TOKEN", "s3cr3t")
+	setSecretBackend(envSecretBackend{prefix: "SUBNETIO_SECRET_"})
+	if got, err := resolveSecret("secret:webhook-token"); err != nil || got != "s3cr3t" {
+		t.Fatalf("resolveSecret env: %q %v", got, err)
+	}
+	if got, _ := resolveSecret("plain"); got != "plain" {
+		t.Fatalf("plain values must pass through: %q", got)
+	}
+	if err := validateSecretField("token", "hunter2"); err == nil {
+		t.Fatalf("plaintext secret must be rejected")
+	}
+	if _, _, err := parseSecretRef("secret:../etc/passwd"); err == nil {
+		t.Fatalf("path traversal must be rejected")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "tok" || r.URL.Path != "/v1/secret/data/devices/core" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"value":"v","password":"pw"}}}`))
+	}))
+	defer srv.Close()
+	setSecretBackend(&vaultSecretBackend{addr: srv.URL, token: "tok", mount: "secret", client: srv.Client(), cache: map[string]vaultCacheEntry{}})
+	defer setSecretBackend(envSecretBackend{prefix: "SUBNETIO_SECRET_"})
+	if got, err := resolveSecret("secret:devices/core#password"); err != nil || got != "pw" {
+		t.Fatalf("resolveSecret vault: %q %v", got, err)
+	}
+}
+
+func TestMaintenanceGuard(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:maintenance?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	r := gin.New()
+	r.Use(maintenanceGuard(db))
+	ok := func(c *gin.Context) { c.String(200, "ok") }
+	r.POST("/sites", ok)
+	r.GET("/export/csv", ok)
+	r.POST("/maintenance", ok)
+
+	do := func(method, path string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+	if code := do("POST", "/sites"); code != 200 {
+		t.Fatalf("write before maintenance: %d", code)
+	}
+	if err := setMaintenance(db, true, "backup"); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+	if state := getMaintenance(db); !state.Enabled || state.Message != "backup" {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	if code := do("POST", "/sites"); code != http.StatusServiceUnavailable {
+		t.Fatalf("write during maintenance: %d", code)
+	}
+	if code := do("GET", "/export/csv"); code != 200 {
+		t.Fatalf("export during maintenance: %d", code)
+	}
+	if code := do("POST", "/maintenance"); code != 200 {
+		t.Fatalf("toggle during maintenance: %d", code)
+	}
+}
+
+func TestDeviceDiffNoiseFilter(t *testing.T) {
+	device := "Building configuration...\nCurrent configuration : 1234 bytes\n! Last configuration change at 10:01:02 UTC\nvlan 10\n  name USERS\nvlan 20\n name VOICE\nend\n"
+	rendered := "! generated_at: 2025-01-01T00:00:00Z\nvlan 10\n name USERS\n"
+
+	result := diffDeviceConfig(defaultNoiseFilter("cisco"), device, rendered)
+	if result.Error != "" {
+		t.Fatalf("diff error: %s", result.Error)
+	}
+	if !strings.Contains(result.Diff, "-vlan 20") || strings.Contains(result.Diff, "Building") || strings.Contains(result.Diff, "generated_at") {
+		t.Fatalf("unexpected diff:\n%s", result.Diff)
+	}
+	if strings.Contains(result.Diff, "-vlan 10") || strings.Contains(result.Diff, "-  name USERS") {
+		t.Fatalf("whitespace noise not ignored:\n%s", result.Diff)
+	}
+
+	strict := NoiseFilter{Template: "cisco"}
+	if diff := diffDeviceConfig(strict, device, rendered).Diff; !strings.Contains(diff, "-Building configuration...") {
+		t.Fatalf("strict filter dropped lines:\n%s", diff)
+	}
+	if res := diffDeviceConfig(NoiseFilter{Template: "cisco", Patterns: "("}, device, rendered); res.Error == "" {
+		t.Fatalf("expected invalid pattern error")
+	}
+}
+
+func TestVLANDomainDuplicates(t *testing.T) {
+	for raw, want := range map[string]int64{"65010": 65010, "AS65010": 65010, "1.10": 65546, "4294967295": 4294967295} {
+		asn, err := parseASN(raw)
+		if err != nil || asn.Int64 != want {
+			t.Fatalf("parseASN(%q) = %v, %v", raw, asn, err)
+		}
+	}
+	for _, raw := range []string{"0", "AS", "4294967296", "70000.1"} {
+		if _, err := parseASN(raw); err == nil {
+			t.Fatalf("parseASN(%q) accepted", raw)
+		}
+	}
+
+	segs := []Segment{
+		{ID: 1, SiteID: 1, Site: "A1", VLANDomain: "campus", VRF: "PROD", VLAN: 10, Name: "users-a"},
+		{ID: 2, SiteID: 2, Site: "A2", VLANDomain: "campus", VRF: "PROD", VLAN: 10, Name: "users-b"},
+		{ID: 3, SiteID: 3, Site: "B1", VRF: "PROD", VLAN: 10, Name: "users-c"},
+	}
+	statuses, conflicts := analyzeAll(segs, nil, nil, defaultProjectRules())
+	dups := 0
+	for _, c := range conflicts {
+		if c.Kind == "VLAN_DUP" {
+			dups++
+			if !strings.Contains(c.Detail, "vlan_domain=campus") {
+				t.Fatalf("unexpected detail: %s", c.Detail)
+			}
+		}
+	}
+	if dups != 1 || statuses[3].Level == statusConflict {
+		t.Fatalf("expected one duplicate within the VLAN domain, got %d (%v)", dups, conflicts)
+	}
+}
+
+func TestDHCPFailover(t *testing.T) {
+	if err := validateDHCPFailover("10.0.0.2", ""); err == nil {
+		t.Fatalf("expected error for missing secondary")
+	}
+	if err := validateDHCPFailover("10.0.0.2", "10.0.0.2"); err == nil {
+		t.Fatalf("expected error for identical servers")
+	}
+	if err := validateDHCPFailover("", ""); err != nil {
+		t.Fatalf("empty pair rejected: %v", err)
+	}
+
+	sites := []Site{{
+		ID:                    1,
+		Name:                  "HQ",
+		DhcpFailoverPrimary:   sql.NullString{String: "10.0.0.2", Valid: true},
+		DhcpFailoverSecondary: sql.NullString{String: "10.0.0.3", Valid: true},
+	}}
+	views := []SegmentView{{
+		Segment: Segment{ID: 7, SiteID: 1, Site: "HQ", VRF: "PROD", VLAN: 10, Name: "users", DhcpEnabled: true},
+		CIDR:    "10.10.0.0/24",
+	}}
+	opts := GenerateOptions{Template: "isc-dhcp", IncludeDHCP: true, DHCPRole: "secondary"}
+	res, err := generateConfig(opts, views, sites, Project{Name: "p"}, ProjectMeta{})
+	if err != nil {
+		t.Fatalf("isc-dhcp: %v", err)
+	}
+	for _, want := range []string{`failover peer "subnetio-hq"`, "secondary;", "address 10.0.0.3;", "peer address 10.0.0.2;"} {
+		if !strings.Contains(res.Output, want) {
+			t.Fatalf("isc-dhcp output missing %q:\n%s", want, res.Output)
+		}
+	}
+
+	opts.Template = "kea"
+	opts.DHCPRole = "primary"
+	res, err = generateConfig(opts, views, sites, Project{Name: "p"}, ProjectMeta{})
+	if err != nil {
+		t.Fatalf("kea: %v", err)
+	}
+	for _, want := range []string{`"this-server-name": "hq-primary"`, `"mode": "load-balancing"`, `"url": "http://10.0.0.3:8000/"`, `"subnet": "10.10.0.0/24"`} {
+		if !strings.Contains(res.Output, want) {
+			t.Fatalf("kea output missing %q:\n%s", want, res.Output)
+		}
+	}
+}
+
+func TestFilterPresets(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:presets?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var ids []int64
+	for _, name := range []string{"A", "B"} {
+		res, err := db.Exec(`INSERT INTO projects(name) VALUES(?)`, name)
+		if err != nil {
+			t.Fatalf("insert project: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	local, err := saveFilterPreset(db, FilterPreset{ProjectID: ids[0], Page: "segments", Name: "prod", Query: "filter_vrf=PROD"})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	shared, err := saveFilterPreset(db, FilterPreset{ProjectID: ids[0], Page: "segments", Name: "voice", Scope: "shared", Query: "filter_vlan=20"})
+	if err != nil {
+		t.Fatalf("save shared: %v", err)
+	}
+	if presets, _ := listFilterPresets(db, ids[1], "segments"); len(presets) != 1 || presets[0].ID != shared {
+		t.Fatalf("project B should only see the global preset: %+v", presets)
+	}
+	if _, ok := filterPresetByID(db, ids[1], local); ok {
+		t.Fatalf("project preset leaked into another project")
+	}
+	if err := setDefaultFilterPreset(db, ids[1], "segments", shared); err != nil {
+		t.Fatalf("set default: %v", err)
+	}
+
+	resolve := func(query string) (SegmentFilters, *FilterPreset) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/segments?"+query, nil)
+		return resolveSegmentFilters(c, db, ids[1])
+	}
+	if filters, preset := resolve(""); preset == nil || preset.ID != shared || filters.VLAN != 20 {
+		t.Fatalf("default preset not applied: %+v %+v", filters, preset)
+	}
+	if filters, preset := resolve("preset=none"); preset != nil || filtersActive(filters) {
+		t.Fatalf("preset=none should clear filters: %+v", filters)
+	}
+	if filters, preset := resolve("filter_vrf=LAB"); preset != nil || filters.VRF != "LAB" {
+		t.Fatalf("explicit filters should win: %+v", filters)
+	}
+
+	if err := deleteProject(db, ids[0], ids[1]); err != nil {
+		t.Fatalf("delete project with presets: %v", err)
+	}
+	if presets, _ := listFilterPresets(db, ids[1], "segments"); len(presets) != 0 {
+		t.Fatalf("presets of deleted project remain: %+v", presets)
+	}
+}
+
+func TestDefaultsImportDryRunRollback(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:defaultsimport?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, err := db.Exec(`INSERT INTO projects(name) VALUES('P')`)
+	if err != nil {
+		t.Fatalf("insert project: %v", err)
+	}
+	projectID, _ := res.LastInsertId()
+	res, err = db.Exec(`INSERT INTO sites(name) VALUES('ALA')`)
+	if err != nil {
+		t.Fatalf("insert site: %v", err)
+	}
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, dhcp_lease_time, dhcp_boot_file) VALUES(?, 3600, 'old.efi')`, siteID)
+
+	csvData := "project,site,dhcp_lease_time,dhcp_boot_file\nP,ALA,7200,new.efi\nP,AST,600,\n"
+	run := func(dryRun bool) *DefaultsImportReport {
+		report := &DefaultsImportReport{Format: "csv", DryRun: dryRun}
+		runDefaultsImport(db, report, projectID, func(conn sqlConn) {
+			importDefaultsCSVRows(conn, report, strings.NewReader(csvData), projectID)
+		})
+		if len(report.Errors) > 0 {
+			t.Fatalf("import errors: %v", report.Errors)
+		}
+		return report
+	}
+	lease := func() int {
+		var v int
+		_ = db.QueryRow(`SELECT dhcp_lease_time FROM site_meta WHERE site_id=?`, siteID).Scan(&v)
+		return v
+	}
+	siteCount := func() int {
+		var n int
+		_ = db.QueryRow(`SELECT COUNT(*) FROM sites`).Scan(&n)
+		return n
+	}
+
+	preview := run(true)
+	found := false
+	for _, ch := range preview.Changes {
+		if ch.Label == "ALA" && ch.Field == "dhcp_lease_time" && ch.Old == "3600" && ch.New == "7200" {
+			found = true
+		}
+	}
+	if !found || lease() != 3600 || siteCount() != 1 || preview.SnapshotID != 0 {
+		t.Fatalf("dry run wrote data or missed changes: %+v", preview.Changes)
+	}
+
+	applied := run(false)
+	if applied.SnapshotID == 0 || lease() != 7200 || siteCount() != 2 {
+		t.Fatalf("import not applied: snapshot=%d lease=%d sites=%d", applied.SnapshotID, lease(), siteCount())
+	}
+	_, _ = db.Exec(`UPDATE site_meta SET dhcp_boot_file='manual.efi' WHERE site_id=?`, siteID)
+
+	restored, warnings, err := rollbackDefaultsImport(db, projectID, applied.SnapshotID)
+	if err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	var boot string
+	_ = db.QueryRow(`SELECT dhcp_boot_file FROM site_meta WHERE site_id=?`, siteID).Scan(&boot)
+	if restored == 0 || lease() != 3600 || boot != "manual.efi" || siteCount() != 1 || len(warnings) != 1 {
+		t.Fatalf("unexpected rollback: restored=%d lease=%d boot=%s sites=%d warnings=%v", restored, lease(), boot, siteCount(), warnings)
+	}
+	if _, _, err := rollbackDefaultsImport(db, projectID, applied.SnapshotID); err == nil {
+		t.Fatalf("second rollback should fail")
+	}
+}
+
+func TestExportColumns(t *testing.T) {
+	cols, err := parseExportColumns([]string{"cidr, Mask", "status,cidr"})
+	if err != nil || strings.Join(cols, ",") != "cidr,mask,status" {
+		t.Fatalf("parseExportColumns = %v, %v", cols, err)
+	}
+	if _, err := parseExportColumns([]string{"cidr,bogus"}); err == nil {
+		t.Fatalf("unknown column accepted")
+	}
+
+	db, err := sql.Open("sqlite", "file:exportcolumns?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('ALA')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/24')`, siteID)
+
+	if err := saveExportColumns(db, projectID, []string{"name", "broadcast"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	get := func(query string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/export/segments/csv"+query, nil)
+		if err := exportSegmentsCSV(c, db, projectID); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		return w.Body.String()
+	}
+	if out := get(""); out != "name,broadcast\nusers,10.0.0.255\n" {
+		t.Fatalf("saved columns not used: %q", out)
+	}
+	if out := get("?columns=cidr,mask"); out != "cidr,mask\n10.0.0.0/24,255.255.255.0\n" {
+		t.Fatalf("query columns not used: %q", out)
+	}
+}
+
+func TestCLISubcommands(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.sqlite")
+	t.Setenv("DB_PATH", source)
+	db, _, err := cliOpenDB()
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('CI')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, "10.40.0.0/24")
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 60)`, siteID)
+	db.Close()
+
+	var out strings.Builder
+	if err := runCLI([]string{"allocate", "--project", "CI"}, &out); err != nil {
+		t.Fatalf("allocate: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "users: - -> 10.40.0.") {
+		t.Fatalf("unexpected allocate output:\n%s", out.String())
+	}
+	out.Reset()
+	if err := runCLI([]string{"generate", "--project", "CI", "--template", "cisco", "--site", "HQ"}, &out); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.Contains(out.String(), "10.40.0.") {
+		t.Fatalf("generated config has no allocated subnet:\n%s", out.String())
+	}
+	plan := filepath.Join(dir, "plan.yaml")
+	if err := runCLI([]string{"export", "--project", "CI", "--format", "yaml", "--output", plan}, &out); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	target := filepath.Join(dir, "target.sqlite")
+	t.Setenv("DB_PATH", target)
+	out.Reset()
+	if err := runCLI([]string{"import", plan, "--dry-run"}, &out); err != nil {
+		t.Fatalf("import dry-run: %v\n%s", err, out.String())
+	}
+	countSegments := func() int {
+		db, err := sql.Open("sqlite", sqliteDSN(target))
+		if err != nil {
+			t.Fatalf("open target: %v", err)
+		}
+		defer db.Close()
+		var n int
+		_ = db.QueryRow(`SELECT COUNT(*) FROM segments`).Scan(&n)
+		return n
+	}
+	if n := countSegments(); n != 0 || !strings.Contains(out.String(), "dry-run") {
+		t.Fatalf("dry-run wrote %d segments:\n%s", n, out.String())
+	}
+	if err := runCLI([]string{"import", plan}, &out); err != nil {
+		t.Fatalf("import: %v\n%s", err, out.String())
+	}
+	if n := countSegments(); n != 1 {
+		t.Fatalf("expected 1 imported segment, got %d", n)
+	}
+	if err := runCLI([]string{"bogus"}, &out); err == nil {
+		t.Fatalf("unknown command should fail")
+	}
+}
+
+func TestCLIGuards(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "guards.sqlite"))
+	t.Setenv("SECRETS_BACKEND", "file")
+	t.Setenv("SECRETS_DIR", dir)
+	t.Cleanup(func() { setSecretBackend(envSecretBackend{prefix: "SUBNETIO_SECRET_"}) })
+	if err := os.WriteFile(filepath.Join(dir, "hook-token"), []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatalf("write secret: %v", err)
+	}
+	db, _, err := cliOpenDB()
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('CI')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, "10.41.0.0/24")
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 60)`, siteID)
+
+	var out strings.Builder
+	plan := filepath.Join(dir, "plan.yaml")
+	if err := runCLI([]string{"export", "--project", "CI", "--format", "yaml", "--output", plan}, &out); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if secret, err := resolveSecret("secret:hook-token"); err != nil || secret != "s3cret" {
+		t.Fatalf("CLI did not load the file secret backend: %q, %v", secret, err)
+	}
+
+	if err := setMaintenance(db, true, "upgrade"); err != nil {
+		t.Fatalf("maintenance: %v", err)
+	}
+	for _, args := range [][]string{
+		{"allocate", "--project", "CI"},
+		{"import", plan, "--project", "CI"},
+	} {
+		if err := runCLI(args, &out); err == nil || !strings.Contains(err.Error(), "maintenance mode") {
+			t.Fatalf("%s in maintenance mode: %v", args[0], err)
+		}
+	}
+	if err := runCLI([]string{"import", plan, "--project", "CI", "--dry-run"}, &out); err != nil {
+		t.Fatalf("dry-run import in maintenance mode: %v", err)
+	}
+	_ = setMaintenance(db, false, "")
+
+	if err := setProjectArchived(db, projectID, true); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if err := runCLI([]string{"import", plan, "--project", "CI"}, &out); err == nil || !strings.Contains(err.Error(), "archived") {
+		t.Fatalf("import into archived project: %v", err)
+	}
+	var allocated int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM segments WHERE cidr IS NOT NULL AND cidr <> ''`).Scan(&allocated)
+	if allocated != 0 {
+		t.Fatalf("guarded commands wrote %d prefixes", allocated)
+	}
+}
+
+func TestMigrationsDownAndDirty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.sqlite")
+	t.Setenv("DB_PATH", path)
+	var out strings.Builder
+	if err := runCLI([]string{"--migrate-only"}, &out); err != nil {
+		t.Fatalf("migrate-only: %v", err)
+	}
+	db, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := checkSchemaCurrent(db); err != nil {
+		t.Fatalf("schema should be current: %v", err)
+	}
+	if err := migrateDown(db, 16); err != nil {
+		t.Fatalf("down to 16: %v", err)
+	}
+	if current, _ := currentMigrationVersion(db); current != 16 {
+		t.Fatalf("expected version 16, got %d", current)
+	}
+	if _, err := db.Exec(`SELECT scope FROM filter_presets`); err == nil {
+		t.Fatalf("scope column should be dropped")
+	}
+	if err := checkSchemaCurrent(db); err == nil {
+		t.Fatalf("old schema should not pass the serve check")
+	}
+	if err := migrateDown(db, 0); err != nil {
+		t.Fatalf("down to 0: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatalf("re-migrate: %v", err)
+	}
+
+	latest, _ := currentMigrationVersion(db)
+	_, _ = db.Exec(`UPDATE schema_migrations SET dirty=1 WHERE version=?`, latest)
+	if err := migrate(db); err == nil || !strings.Contains(err.Error(), "dirty at version") {
+		t.Fatalf("dirty schema should block migrate, got %v", err)
+	}
+	if err := forceMigrationVersion(db, latest); err != nil {
+		t.Fatalf("force: %v", err)
+	}
+	out.Reset()
+	want := fmt.Sprintf("schema version %d of %d", latest, latest)
+	if err := runCLI([]string{"migrate", "--status"}, &out); err != nil || !strings.Contains(out.String(), want) {
+		t.Fatalf("status: %v %q", err, out.String())
+	}
+}
+
+func TestGenerationSchedules(t *testing.T) {
+	spec, err := parseCron("*/15 3 * * 1-5")
+	if err != nil {
+		t.Fatalf("parse cron: %v", err)
+	}
+	from := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) // Saturday
+	if next, ok := spec.next(from); !ok || next != time.Date(2025, 3, 3, 3, 0, 0, 0, time.UTC) {
+		t.Fatalf("unexpected next run: %v", next)
+	}
+	for _, bad := range []string{"* * *", "61 * * * *", "*/0 * * * *", "0 0 30 2 *"} {
+		if _, err := nextScheduleRun(bad, from); err == nil {
+			t.Fatalf("cron %q should be rejected", bad)
+		}
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "s.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/24')`, siteID)
+
+	if _, err := saveGenerationSchedule(db, GenerationSchedule{ProjectID: projectID, Template: "nope", Cron: "@daily", Enabled: true}); err == nil {
+		t.Fatalf("unknown template should be rejected")
+	}
+	id, err := saveGenerationSchedule(db, GenerationSchedule{ProjectID: projectID, Template: "cisco", SiteFilter: "HQ", Cron: "@hourly", Retention: 2, Enabled: true})
+	if err != nil {
+		t.Fatalf("save schedule: %v", err)
+	}
+	s, _ := generationScheduleByID(db, id)
+	due, _ := time.Parse(time.RFC3339, s.NextRunAt)
+	if n := runDueGenerationSchedules(db, due.Add(-time.Minute)); n != 0 {
+		t.Fatalf("schedule ran before it was due")
+	}
+	if n := runDueGenerationSchedules(db, due); n != 1 {
+		t.Fatalf("expected one due schedule, ran %d", n)
+	}
+	s, _ = generationScheduleByID(db, id)
+	if s.LatestID == 0 || s.LastError != "" || s.NextRunAt != due.Add(time.Hour).Format(time.RFC3339) {
+		t.Fatalf("unexpected schedule state: %+v", s)
+	}
+	if artifactID, err := runGenerationSchedule(db, s, due.Add(time.Hour)); err != nil || artifactID != 0 {
+		t.Fatalf("unchanged output should not be stored again: id=%d err=%v", artifactID, err)
+	}
+	for i, cidr := range []string{"10.0.1.0/24", "10.0.2.0/24"} {
+		_, _ = db.Exec(`UPDATE segments SET cidr=?`, cidr)
+		s, _ = generationScheduleByID(db, id)
+		if _, err := runGenerationSchedule(db, s, due.Add(time.Duration(i+2)*time.Hour)); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}
+	artifacts, _ := listScheduleArtifacts(db, id)
+	latest, _ := generationByID(db, artifacts[0].ID)
+	if len(artifacts) != 2 || !strings.Contains(latest.Content, "10.0.2.") || latest.Kind != generationScheduled {
+		t.Fatalf("retention or content wrong: %d artifacts, kind %s", len(artifacts), latest.Kind)
+	}
+	if err := deleteGenerationSchedule(db, id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	var left int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM generations WHERE schedule_id=?`, id).Scan(&left)
+	if left != 0 {
+		t.Fatalf("artifacts left after delete: %d", left)
+	}
+}
+
+func TestMACReservations(t *testing.T) {
+	for raw, want := range map[string]string{"00-0C-42-AA-BB-01": "00:0c:42:aa:bb:01", "000c.42aa.bb01": "00:0c:42:aa:bb:01", "000C42AABB01": "00:0c:42:aa:bb:01"} {
+		if got, err := normalizeMAC(raw); err != nil || got != want {
+			t.Fatalf("normalize %s: %q %v", raw, got, err)
+		}
+	}
+	if v := macVendor("00:0c:42:aa:bb:01"); v != "MikroTik" {
+		t.Fatalf("unexpected vendor %q", v)
+	}
+	if v := macVendor("02:00:00:00:00:01"); !strings.Contains(v, "locally administered") {
+		t.Fatalf("unexpected vendor %q", v)
+	}
+	oui, err := parseOUIRegistry(strings.NewReader("Registry,Assignment,Organization Name,Organization Address\nMA-L,ABCDEF,Example Corp,Somewhere\n"))
+	if err != nil || oui["abcdef"] != "Example Corp" {
+		t.Fatalf("oui.csv parse: %v %v", oui, err)
+	}
+	oui, _ = parseOUIRegistry(strings.NewReader("AB-CD-EF   (hex)\t\tExample Corp\nABCDEF     (base 16)\t\tExample Corp\n"))
+	if oui["abcdef"] != "Example Corp" {
+		t.Fatalf("oui.txt parse: %v", oui)
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "m.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/24')`, siteID)
+	usersID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 20, 'voice', '10.0.1.0/24')`, siteID)
+	voiceID, _ := res.LastInsertId()
+
+	if _, err := saveMACReservation(db, MACReservation{SegmentID: usersID, MAC: "00:0c:42:aa:bb:01", IP: "10.0.0.10", Hostname: "ap-1"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	for _, bad := range []MACReservation{
+		{SegmentID: usersID, MAC: "00:0c:42:aa:bb:02", IP: "10.0.0.10"},
+		{SegmentID: usersID, MAC: "00:0c:42:aa:bb:02", IP: "10.0.1.10"},
+		{SegmentID: usersID, MAC: "00:0c:42:aa:bb:02", IP: "10.0.0.255"},
+		{SegmentID: usersID, MAC: "01:00:5e:00:00:01"},
+	} {
+		if _, err := saveMACReservation(db, bad); err == nil {
+			t.Fatalf("reservation %+v should be rejected", bad)
+		}
+	}
+	if _, err := saveMACReservation(db, MACReservation{SegmentID: voiceID, MAC: "000c.42aa.bb01"}); err != nil {
+		t.Fatalf("save second segment: %v", err)
+	}
+	list, err := listMACReservations(db, projectID)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("list: %v %d", err, len(list))
+	}
+	if dups := duplicateMACs(list); len(dups) != 1 || len(dups[0].Segments) != 2 {
+		t.Fatalf("expected one duplicate across two segments: %+v", dups)
+	}
+	kea, _, err := renderReservations(list, "kea")
+	if err != nil || !strings.Contains(kea, `"hw-address": "00:0c:42:aa:bb:01"`) || !strings.Contains(kea, `"subnet": "10.0.0.0/24"`) {
+		t.Fatalf("kea export: %v\n%s", err, kea)
+	}
+	dhcpd, _, _ := renderReservations(list, "dhcpd")
+	if !strings.Contains(dhcpd, "host ap-1 {\n  hardware ethernet 00:0c:42:aa:bb:01;\n  fixed-address 10.0.0.10;") {
+		t.Fatalf("dhcpd export:\n%s", dhcpd)
+	}
+	mikrotik, _, _ := renderReservations(list, "mikrotik")
+	if strings.Count(mikrotik, "lease add") != 1 || !strings.Contains(mikrotik, "mac-address=00:0C:42:AA:BB:01 address=10.0.0.10") {
+		t.Fatalf("mikrotik export:\n%s", mikrotik)
+	}
+	if err := deleteSegment(db, voiceID); err != nil {
+		t.Fatalf("delete segment with MAC: %v", err)
+	}
+}
+
+func TestPlanReport(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "r.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/16')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users (Büro)', '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 20, 'voice', '10.0.0.128/25')`, siteID)
+
+	report := buildPlanReport(db, projectID, 5, 12)
+	if report.SegmentCount != 2 || report.Allocated != 2 || len(report.Sites) != 1 || len(report.Sites[0].Segments) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if report.ConflictCount == 0 || len(report.Bars) != 1 {
+		t.Fatalf("expected an overlap conflict and one pool bar: %+v", report)
+	}
+	pdf := renderReportPDF(report)
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF: %q", pdf[:20])
+	}
+	if !bytes.Contains(pdf, []byte(`(VLAN)`)) || !bytes.Contains(pdf, []byte(`users \(B\374ro\)`)) {
+		t.Fatalf("PDF is missing segment table")
+	}
+
+	tmpl, err := loadTemplate("report")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var html bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&html, "report", map[string]any{"Report": report, "ActiveProjectID": projectID}); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(html.String(), "users (Büro)") || !strings.Contains(html.String(), "Capacity outlook") {
+		t.Fatalf("html report:\n%s", html.String())
+	}
+}
+
+func TestSegmentExpiry(t *testing.T) {
+	if v, err := parseSegmentExpiry("2026-03-01"); err != nil || v.String != "2026-03-01T00:00:00Z" {
+		t.Fatalf("parse date: %v %v", v, err)
+	}
+	if v, err := parseSegmentExpiry("2026-03-01T12:30"); err != nil || v.String != "2026-03-01T12:30:00Z" {
+		t.Fatalf("parse datetime-local: %v %v", v, err)
+	}
+	if _, err := parseSegmentExpiry("next week"); err == nil {
+		t.Fatalf("expected invalid expiry")
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "e.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('LAB')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, expires_at, expiry_action) VALUES(?, 'LAB', 10, 'test-a', '10.9.0.0/24', '2026-03-01T00:00:00Z', 'release')`, siteID)
+	releaseID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, expires_at, expiry_action) VALUES(?, 'LAB', 20, 'test-b', '10.9.1.0/24', '2026-02-28T00:00:00Z', 'flag')`, siteID)
+	flagID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, expires_at) VALUES(?, 'LAB', 30, 'test-c', '10.9.2.0/24', '2026-03-04T00:00:00Z')`, siteID)
+
+	segs, _ := listSegments(db, projectID)
+	statuses := map[int64]SegmentStatus{}
+	conflicts := analyzeExpiry(segs, statuses, now)
+	if len(conflicts) != 3 || conflicts[2].Kind != "SEGMENT_EXPIRING" || !strings.Contains(conflicts[2].Detail, "expires in 2d 12h") {
+		t.Fatalf("unexpected expiry warnings: %+v", conflicts)
+	}
+
+	_ = setMaintenance(db, true, "upgrade")
+	if n, err := expireSegments(db, now); err != nil || n != 0 {
+		t.Fatalf("expire during maintenance: %d %v", n, err)
+	}
+	_ = setMaintenance(db, false, "")
+	n, err := expireSegments(db, now)
+	if err != nil || n != 2 {
+		t.Fatalf("expire: %d %v", n, err)
+	}
+	if _, ok := segmentByID(db, releaseID); ok {
+		t.Fatalf("released segment should be deleted")
+	}
+	flagged, ok := segmentByID(db, flagID)
+	if !ok || !flagged.ExpiredAt.Valid || !flagged.CIDR.Valid {
+		t.Fatalf("flagged segment should keep its CIDR: %+v", flagged)
+	}
+	if n, _ := expireSegments(db, now.Add(time.Hour)); n != 0 {
+		t.Fatalf("expired segments must be processed once, got %d", n)
+	}
+	var audits int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action='expire' AND actor='scheduler'`).Scan(&audits)
+	if audits != 2 {
+		t.Fatalf("expected 2 expire audit entries, got %d", audits)
+	}
+}
+
+func TestAuditHashChain(t *testing.T) {
+	open := func(name string) *sql.DB {
+		db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), name)))
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		if err := migrate(db); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		return db
+	}
+	db := open("a.sqlite")
+	defer db.Close()
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Other')`)
+	otherID, _ := res.LastInsertId()
+
+	// a row written before the chain existed is sealed at startup
+	_, _ = db.Exec(`INSERT INTO audit_log(project_id, actor, action, entity_type, created_at) VALUES(?, 'legacy', 'create', 'site', '2024-01-01T00:00:00Z')`, projectID)
+	if err := sealAuditChain(db); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for _, pid := range []int64{projectID, otherID} {
+			if err := insertAuditRecord(db, auditRecord{ProjectID: pid, Actor: "tester", Action: "update", EntityType: "segment",
+				EntityID: sql.NullInt64{Int64: int64(i + 1), Valid: true}, After: map[string]int{"vlan": 10 + i}}); err != nil {
+				t.Fatalf("insert audit: %v", err)
+			}
+		}
+	}
+	result, err := verifyAuditLog(db, 0)
+	if err != nil || !result.Valid || len(result.Chains) != 2 || result.Rows != 7 {
+		t.Fatalf("verify: %+v %v", result, err)
+	}
+
+	entries, _ := listAuditEntries(db, projectID)
+	if len(entries) != 4 || entries[len(entries)-1].PrevHash != "" || entries[0].PrevHash != entries[1].Hash {
+		t.Fatalf("unexpected chain links: %+v", entries)
+	}
+	jsonExport, _ := json.Marshal(entries)
+	var ndjson bytes.Buffer
+	for _, e := range entries {
+		_ = json.NewEncoder(&ndjson).Encode(auditStreamRow(e))
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if err := exportAuditCSV(c, db, projectID); err != nil {
+		t.Fatalf("csv export: %v", err)
+	}
+	for name, raw := range map[string][]byte{"json": jsonExport, "ndjson": ndjson.Bytes(), "csv": w.Body.Bytes()} {
+		rows, err := parseAuditExport(raw)
+		if err != nil {
+			t.Fatalf("%s parse: %v", name, err)
+		}
+		if r, _ := verifyAuditRows(rows); !r.Valid || r.Rows != 4 || r.Chains[0].Partial {
+			t.Fatalf("%s verify: %+v", name, r)
+		}
+	}
+
+	tampered := bytes.Replace(ndjson.Bytes(), []byte(`"vlan":11`), []byte(`"vlan":99`), 1)
+	rows, _ := parseAuditExport(tampered)
+	if r, _ := verifyAuditRows(rows); r.Valid {
+		t.Fatalf("tampered export should fail verification")
+	}
+	rows, _ = parseAuditExport(jsonExport)
+	if r, _ := verifyAuditRows(append(rows[:1:1], rows[2:]...)); r.Valid {
+		t.Fatalf("export with a removed entry should fail verification")
+	}
+	_, _ = db.Exec(`UPDATE audit_log SET actor='mallory' WHERE actor='legacy'`)
+	if r, _ := verifyAuditLog(db, projectID); r.Valid {
+		t.Fatalf("edited database row should fail verification")
+	}
+
+	restored := open("b.sqlite")
+	defer restored.Close()
+	targetID, _ := ensureDefaultProject(restored)
+	rows, _ = parseAuditExport(jsonExport)
+	if added, skipped, err := restoreAuditRows(restored, targetID, rows); err != nil || added != 4 || skipped != 0 {
+		t.Fatalf("restore: %d %d %v", added, skipped, err)
+	}
+	if added, skipped, err := restoreAuditRows(restored, targetID, rows); err != nil || added != 0 || skipped != 4 {
+		t.Fatalf("second restore should skip: %d %d %v", added, skipped, err)
+	}
+	if err := insertAuditRecord(restored, auditRecord{ProjectID: targetID, Actor: "tester", Action: "delete", EntityType: "site"}); err != nil {
+		t.Fatalf("insert after restore: %v", err)
+	}
+	if r, _ := verifyAuditLog(restored, targetID); !r.Valid || r.Rows != 5 {
+		t.Fatalf("restored chain: %+v", r)
+	}
+	if _, _, err := restoreAuditRows(restored, targetID, rows[:2]); err != nil {
+		t.Fatalf("already present prefix should be skipped: %v", err)
+	}
+	other, _ := listAuditEntries(db, otherID)
+	otherJSON, _ := json.Marshal(other)
+	otherRows, _ := parseAuditExport(otherJSON)
+	if _, _, err := restoreAuditRows(restored, targetID, otherRows); err == nil {
+		t.Fatalf("a chain that does not continue the project head must be rejected")
+	}
+}
+
+func TestAuditChainConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.sqlite")
+	server, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer server.Close()
+	if err := migrate(server); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(server)
+	// a second handle stands in for a CLI process writing to the same file
+	cli, err := sql.Open("sqlite", sqliteDSN(path))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer cli.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for _, db := range []*sql.DB{server, cli} {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(db *sql.DB) {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					errs <- insertAuditRecord(db, auditRecord{ProjectID: projectID, Actor: "tester", Action: "update", EntityType: "segment"})
+				}
+			}(db)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	result, err := verifyAuditLog(server, projectID)
+	if err != nil || !result.Valid || result.Rows != 40 {
+		t.Fatalf("concurrent appends forked the chain: %+v %v", result, err)
+	}
+}
+
+func TestProjectSummary(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "s.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/22')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/24', 1)`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'voice', '10.0.1.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 30, 'iot', 50)`, siteID)
+	_ = insertAuditRecord(db, auditRecord{ProjectID: projectID, Actor: "ci", Action: "allocate", EntityType: "project"})
+
+	project, _ := projectByID(db, projectID)
+	summary, err := buildProjectSummary(db, project)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if summary.Sites != 1 || summary.Segments.Total != 3 || summary.Segments.Allocated != 2 || summary.Segments.Unallocated != 1 || summary.Segments.Locked != 1 {
+		t.Fatalf("segment counts: %+v", summary.Segments)
+	}
+	if summary.Segments.ByStatus["Conflict"] != 2 || summary.Conflicts.ByKind["VLAN_DUP"] != 1 {
+		t.Fatalf("expected duplicate VLAN conflict: %+v %+v", summary.Segments.ByStatus, summary.Conflicts)
+	}
+	if summary.Pools.Total != 1 || len(summary.Pools.Items) != 1 || summary.Pools.IPv4.Used != "512" {
+		t.Fatalf("pools: %+v", summary.Pools)
+	}
+	if summary.LastAllocation == nil || summary.LastAllocation.Actor != "ci" || summary.LastImport != nil {
+		t.Fatalf("audit events: %+v %+v", summary.LastAllocation, summary.LastImport)
+	}
+}
+
+func TestReverseDNSDelegation(t *testing.T) {
+	ns := func(v string) sql.NullString { return sql.NullString{String: v, Valid: v != ""} }
+	sites := []Site{{Name: "HQ", DNS: ns("10.0.0.53, dns2.example.net")}, {Name: "Branch"}}
+	segs := []Segment{
+		{Site: "HQ", VRF: "PROD", VLAN: 10, Name: "users", CIDR: ns("10.0.0.0/23"), CIDRV6: ns("2001:db8:0:10::/64")},
+		{Site: "HQ", VRF: "PROD", VLAN: 20, Name: "mgmt", CIDR: ns("10.0.2.0/26")},
+		{Site: "Branch", VRF: "PROD", VLAN: 20, Name: "mgmt", CIDR: ns("10.0.2.64/26")},
+		{Site: "Branch", VRF: "PROD", VLAN: 30, Name: "voice", CIDRV6: ns("2001:db8:0:20::/66")},
+	}
+	meta := ProjectMeta{DomainName: ns("corp.example"), DNS: ns("192.0.2.53")}
+	zones := buildReverseDelegations(segs, sites, meta)
+	byZone := map[string]ReverseZone{}
+	for _, z := range zones {
+		byZone[z.Zone] = z
+	}
+	if len(zones) != 5 {
+		t.Fatalf("expected 5 zones, got %d: %+v", len(zones), zones)
+	}
+	for _, name := range []string{"0.0.10.in-addr.arpa", "1.0.10.in-addr.arpa"} {
+		if z := byZone[name]; !z.Delegate || z.Site != "HQ" || len(z.Servers) != 2 {
+			t.Fatalf("zone %s: %+v", name, z)
+		}
+	}
+	if z := byZone["2.0.10.in-addr.arpa"]; z.Delegate || !strings.Contains(z.Note, "RFC 2317") {
+		t.Fatalf("shared zone should not be delegated: %+v", z)
+	}
+	if z := byZone["0.1.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"]; !z.Delegate || z.Prefix != "2001:db8:0:10::/64" {
+		t.Fatalf("ipv6 zone: %+v", z)
+	}
+	if z := byZone["0.2.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"]; !z.Delegate || z.Site != "Branch" || z.Servers[0] != "192.0.2.53" {
+		t.Fatalf("ipv6 /66 should fall into its /64 zone with project DNS: %+v", z)
+	}
+
+	bind := renderReverseBIND(zones, "corp.example")
+	for _, want := range []string{
+		"0.0.10.in-addr.arpa.\tIN\tNS\tns1.hq.corp.example.",
+		"0.0.10.in-addr.arpa.\tIN\tNS\tdns2.example.net.",
+		"; ns1.hq.corp.example.\tIN\tA\t10.0.0.53",
+		"; not delegated:",
+	} {
+		if !strings.Contains(bind, want) {
+			t.Fatalf("bind output missing %q:\n%s", want, bind)
+		}
+	}
+	var buf bytes.Buffer
+	if err := writeReverseCSV(&buf, zones); err != nil || strings.Count(buf.String(), "\n") != 6 {
+		t.Fatalf("csv: %v\n%s", err, buf.String())
+	}
+}
+
+func TestACLObjectExport(t *testing.T) {
+	ns := func(v string) sql.NullString { return sql.NullString{String: v, Valid: v != ""} }
+	segs := []Segment{
+		{Site: "HQ", VRF: "PROD", VLAN: 10, Name: "users", CIDR: ns("10.0.0.0/25"), CIDRV6: ns("2001:db8:0:10::/64"), Tags: ns("pci, voice")},
+		{Site: "HQ", VRF: "PROD", VLAN: 11, Name: "users2", CIDR: ns("10.0.0.128/25"), SecondaryCIDRs: ns("10.9.0.0/24"), Tags: ns("PCI")},
+		{Site: "HQ", VRF: "MGMT", VLAN: 20, Name: "oob", CIDR: ns("172.16.0.0/24")},
+		{Site: "HQ", VRF: "MGMT", VLAN: 30, Name: "planned"},
+	}
+	objs := buildACLObjects(segs, ACLRequest{By: "vrf", Prefix: "fw", Summarize: true})
+	if len(objs) != 2 || objs[0].Name != "FW-MGMT" || objs[0].Segments != 1 {
+		t.Fatalf("vrf objects: %+v", objs)
+	}
+	if prod := objs[1]; strings.Join(prod.IPv4, " ") != "10.0.0.0/24 10.9.0.0/24" || len(prod.IPv6) != 1 {
+		t.Fatalf("summarized PROD object: %+v", prod)
+	}
+	objs = buildACLObjects(segs, ACLRequest{By: "tag", Tags: []string{"pci"}, Prefix: "SUBNETIO"})
+	if len(objs) != 1 || objs[0].Name != "SUBNETIO-PCI" || len(objs[0].IPv4) != 3 {
+		t.Fatalf("tag objects: %+v", objs)
+	}
+	for format, want := range map[string]string{
+		"cisco":   "ip prefix-list SUBNETIO-PCI seq 15 permit 10.9.0.0/24",
+		"asa":     " network-object 10.0.0.128 255.255.255.128",
+		"juniper": "set policy-options prefix-list SUBNETIO-PCI 2001:db8:0:10::/64",
+		"ipset":   "create SUBNETIO-PCI-V6 hash:net family inet6 -exist",
+	} {
+		out, _, err := renderACLObjects(objs, format)
+		if err != nil || !strings.Contains(out, want) {
+			t.Fatalf("%s output missing %q: %v\n%s", format, want, err, out)
+		}
+	}
+	if _, _, err := renderACLObjects(objs, "pf"); err == nil {
+		t.Fatalf("expected an error for an unknown format")
+	}
+}
+
+func TestMultiPrefixSegment(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "m.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/26')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, priority) VALUES(?, '10.0.1.0/26', 1)`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 100)`, siteID)
+	segID, _ := res.LastInsertId()
+
+	if err := allocateProject(db, projectID, nil); err == nil {
+		t.Fatalf("expected allocation failure without multi_prefix")
+	}
+	_, _ = db.Exec(`UPDATE segments SET multi_prefix=1 WHERE id=?`, segID)
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	seg, _ := segmentByID(db, segID)
+	if seg.CIDR.String != "10.0.0.0/26" || seg.SecondaryCIDRs.String != "10.0.1.0/26" {
+		t.Fatalf("unexpected allocation: cidr=%v secondary=%v", seg.CIDR, seg.SecondaryCIDRs)
+	}
+
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 20, 'clash', '10.0.1.0/28', 1)`, siteID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	sites, _ := listSites(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	statuses, conflicts := analyzeAll(segs, pools, sites, rules)
+	overlap := false
+	for _, c := range conflicts {
+		if c.Kind == "OVERLAP" && strings.Contains(c.Detail, "10.0.1.0/26") {
+			overlap = true
+		}
+	}
+	if !overlap {
+		t.Fatalf("expected overlap with secondary network: %+v", conflicts)
+	}
+	_, _ = db.Exec(`DELETE FROM segments WHERE name='clash'`)
+
+	segs, _ = listSegments(db, projectID)
+	statuses, _ = analyzeAll(segs, pools, sites, rules)
+	views := buildSegmentViews(segs, statuses, pools)
+	if len(views) != 1 || len(views[0].Secondary) != 1 || views[0].Secondary[0].Gateway != "10.0.1.1" {
+		t.Fatalf("secondary view: %+v", views)
+	}
+	capacity := buildCapacityReport(segs, pools, sites, 0, 0, 64)
+	if capacity.SummaryV4.Used != "128" {
+		t.Fatalf("secondary space should count as used: %+v", capacity.SummaryV4)
+	}
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "cisco", IncludeVLAN: true})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.Contains(out.Output, " ip address 10.0.1.1 255.255.255.192 secondary") {
+		t.Fatalf("cisco output missing secondary address:\n%s", out.Output)
+	}
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("plan bundle: %v", err)
+	}
+	found := false
+	for _, row := range bundle.Rows {
+		if row.RowType == planRowSegment && row.SecondaryCIDRs == "10.0.1.0/26" && row.MultiPrefix != nil && *row.MultiPrefix {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("plan export missing secondary networks")
+	}
+}
+
+func TestAllocationAlternatives(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "alt.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	hqID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('BRANCH')`)
+	branchID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?), (?, ?)`, projectID, hqID, projectID, branchID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/26')`, hqID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.1.0.0/24')`, branchID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 10, 'mgmt', '10.0.0.0/28', 1)`, hqID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 20, 'users', 50)`, hqID)
+
+	err = allocateProject(db, projectID, nil)
+	var allocErr *AllocationError
+	if !errors.As(err, &allocErr) {
+		t.Fatalf("expected AllocationError, got %v", err)
+	}
+	kinds := map[string]string{}
+	for _, alt := range allocErr.Alternatives {
+		kinds[alt.Kind] = alt.Detail
+	}
+	if !strings.Contains(kinds[altSmallerPrefix], "10.0.0.32/27") {
+		t.Fatalf("smaller prefix alternative: %+v", allocErr.Alternatives)
+	}
+	if !strings.Contains(kinds[altFreeSpace], "free 16 of 64 addresses in 10.0.0.0/26") {
+		t.Fatalf("free space alternative: %+v", allocErr.Alternatives)
+	}
+	if !strings.Contains(kinds[altOtherSite], "BRANCH") || !strings.Contains(kinds[altOtherSite], "10.1.0.0/26") {
+		t.Fatalf("other site alternative: %+v", allocErr.Alternatives)
+	}
+
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	_, _, conflicts := planAllocations(segs, pools, nil, nil, rules)
+	if len(conflicts) != 1 || conflicts[0].Kind != "ALLOCATE_FAIL" || len(conflicts[0].Alternatives) != 3 {
+		t.Fatalf("what-if conflicts: %+v", conflicts)
+	}
+}
+
+func TestRulePresets(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "presets.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	custom := ProjectRules{VLANScope: VlanScopeGlobal, RequireInPool: true, OversizeThreshold: 80, PoolStrategy: PoolStrategyTiered}
+	if err := saveRulePreset(db, RulePreset{Name: "strict", Rules: custom}); err == nil {
+		t.Fatalf("expected built-in preset name to be reserved")
+	}
+	if err := saveRulePreset(db, RulePreset{Name: "DC", Description: "datacenter", Rules: custom}); err != nil {
+		t.Fatalf("save preset: %v", err)
+	}
+
+	current, _ := getProjectRules(db, projectID)
+	current.IPv6Scheme = "site=48-55"
+	rules, ok := resolveRulePreset(db, "dc", current)
+	if !ok || rules.VLANScope != VlanScopeGlobal || rules.PoolStrategy != PoolStrategyTiered || rules.IPv6Scheme != "site=48-55" {
+		t.Fatalf("resolve custom preset: %+v %v", rules, ok)
+	}
+	if _, ok := resolveRulePreset(db, "missing", current); ok {
+		t.Fatalf("unknown preset should not resolve")
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writePlanBundle(&buf, bundle, "csv"); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	if !strings.Contains(buf.String(), "rule_preset,"+stableID(planRowRulePreset, "DC")) {
+		t.Fatalf("plan export missing preset row:\n%s", buf.String())
+	}
+	if removed, err := deleteRulePreset(db, "DC"); err != nil || !removed {
+		t.Fatalf("delete preset: %v %v", removed, err)
+	}
+	report := importPlanData(db, buf.Bytes(), "csv", projectID, ImportPolicy{})
+	if len(report.Errors) != 0 || report.PresetsSaved != 1 {
+		t.Fatalf("import report: %+v", report)
+	}
+	preset, ok := rulePresetByName(db, "DC")
+	if !ok || preset.Description != "datacenter" || preset.Rules.OversizeThreshold != 80 {
+		t.Fatalf("imported preset: %+v %v", preset, ok)
+	}
+}
+
+func TestCSVEncodings(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "csvenc.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, err := db.Exec(`INSERT INTO sites(name) VALUES('Алматы')`)
+	if err != nil {
+		t.Fatalf("insert site: %v", err)
+	}
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	if _, err := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'Пользователи', 60)`, siteID); err != nil {
+		t.Fatalf("insert segment: %v", err)
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writePlanBundle(&buf, bundle, "csv"); err != nil {
+		t.Fatalf("write plan: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("read plan: %v", err)
+	}
+	rewrite := func(comma rune) string {
+		var out strings.Builder
+		w := csv.NewWriter(&out)
+		w.Comma = comma
+		_ = w.WriteAll(records)
+		return out.String()
+	}
+	cp1251, err := charmap.Windows1251.NewEncoder().String(rewrite(';'))
+	if err != nil {
+		t.Fatalf("encode cp1251: %v", err)
+	}
+	utf16, err := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String(rewrite('\t'))
+	if err != nil {
+		t.Fatalf("encode utf-16: %v", err)
+	}
+
+	for _, tc := range []struct {
+		raw  string
+		opts CSVOptions
+		used string
+	}{
+		{raw: cp1251, used: "windows-1251, semicolon"},
+		{raw: utf16, used: "utf-16le, tab"},
+		{raw: cp1251, opts: CSVOptions{Delimiter: ';', Encoding: "windows-1251"}, used: "windows-1251, semicolon"},
+	} {
+		if _, err := db.Exec(`DELETE FROM segments`); err != nil {
+			t.Fatalf("delete segments: %v", err)
+		}
+		report := importPlanData(db, []byte(tc.raw), "csv", projectID, ImportPolicy{CSV: tc.opts})
+		if len(report.Errors) != 0 || report.SegmentsAdded != 1 || report.CSV != tc.used {
+			t.Fatalf("import %s: %+v", tc.used, report)
+		}
+		var name, site string
+		if err := db.QueryRow(`SELECT s.name, st.name FROM segments s JOIN sites st ON st.id=s.site_id`).Scan(&name, &site); err != nil || name != "Пользователи" || site != "Алматы" {
+			t.Fatalf("imported %s: %q %q %v", tc.used, name, site, err)
+		}
+	}
+
+	if got := detectCSVEncoding([]byte("name;site\nCafé;Zürich\n")); got != "utf-8" {
+		t.Fatalf("utf-8 detected as %s", got)
+	}
+	if got := detectCSVEncoding([]byte("name;site\nCaf\xe9;Z\xfcrich\n")); got != "windows-1252" {
+		t.Fatalf("latin text detected as %s", got)
+	}
+	if got := detectCSVDelimiter(`"a,b";c;d`); got != ';' {
+		t.Fatalf("delimiter: %q", got)
+	}
+	if _, err := parseCSVOptions("colon", ""); err == nil {
+		t.Fatalf("expected unsupported delimiter error")
+	}
+	if opts, err := parseCSVOptions("tab", "cp1251"); err != nil || opts.Delimiter != '\t' || opts.Encoding != "windows-1251" {
+		t.Fatalf("parse options: %+v %v", opts, err)
+	}
+}
+
+func TestPlanPatch(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "planpatch.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	raw := []byte(`{"schema_version":"2","rows":[
+		{"row_type":"meta","schema_version":"2"},
+		{"row_type":"rules","vlan_scope":"site","require_in_pool":false,"allow_reserved_overlap":false,"oversize_threshold":0},
+		{"row_type":"site","site":"HQ"},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":10,"name":"users","hosts":50,"locked":false,"dhcp":false},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":20,"name":"voice","hosts":20,"locked":false,"dhcp":false}
+	]}`)
+	if report := importPlanData(db, raw, "json", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("import: %v", report.Errors)
+	}
+	uid := func(parts ...string) string {
+		return stableID(planRowSegment, append([]string{"Default", "HQ", "PROD"}, parts...)...)
+	}
+	hosts := func(name string) int {
+		var n int
+		if err := db.QueryRow(`SELECT COALESCE(hosts, -1) FROM segments WHERE name=?`, name).Scan(&n); err != nil {
+			return 0
+		}
+		return n
+	}
+	patch := func(rows string) *ImportReport {
+		return importPlanPatch(db, strings.NewReader(`{"schema_version":"2","rows":[`+rows+`]}`), "json", projectID, ImportPolicy{})
+	}
+
+	report := patch(`
+		{"op":"update","row_type":"segment","uid":"` + uid("10", "users") + `","site":"HQ","vrf":"PROD","vlan":10,"name":"users","hosts":100,"locked":false,"dhcp":false},
+		{"op":"delete","row_type":"segment","uid":"` + uid("20", "voice") + `"},
+		{"op":"add","row_type":"segment","uid":"` + uid("30", "cams") + `","site":"HQ","vrf":"PROD","vlan":30,"name":"cams","hosts":10,"locked":false,"dhcp":false}`)
+	if len(report.Errors) != 0 || report.Updated != 1 || report.Deleted != 1 || report.SegmentsAdded != 1 {
+		t.Fatalf("patch: %+v", report)
+	}
+	if hosts("users") != 100 || hosts("voice") != 0 || hosts("cams") != 10 {
+		t.Fatalf("patched segments: users=%d voice=%d cams=%d", hosts("users"), hosts("voice"), hosts("cams"))
+	}
+
+	// A row whose op does not match the stored plan fails the whole patch.
+	report = patch(`
+		{"op":"update","row_type":"segment","uid":"` + uid("10", "users") + `","site":"HQ","vrf":"PROD","vlan":10,"name":"users","hosts":200,"locked":false,"dhcp":false},
+		{"op":"add","row_type":"segment","uid":"` + uid("30", "cams") + `","site":"HQ","vrf":"PROD","vlan":30,"name":"cams","hosts":10,"locked":false,"dhcp":false}`)
+	if len(report.Errors) != 2 || !strings.Contains(report.Errors[0], "already exists") || hosts("users") != 100 {
+		t.Fatalf("conflicting patch: %+v users=%d", report, hosts("users"))
+	}
+	for _, bad := range []struct{ rows, want string }{
+		{`{"op":"update","row_type":"segment","uid":"` + uid("20", "voice") + `","site":"HQ","vrf":"PROD","vlan":20,"name":"voice","hosts":5,"locked":false,"dhcp":false}`, "does not exist"},
+		{`{"op":"delete","row_type":"site","uid":"` + stableID(planRowSite, "Default", "HQ") + `"}`, "still has"},
+		{`{"op":"add","row_type":"segment","uid":"` + stableID(planRowSegment, "Default", "BR", "PROD", "10", "x") + `","site":"BR","vrf":"PROD","vlan":10,"name":"x","hosts":5,"locked":false,"dhcp":false}`, "site BR does not exist"},
+		{`{"row_type":"segment","uid":"` + uid("10", "users") + `"}`, "op is required"},
+	} {
+		if report := patch(bad.rows); len(report.Errors) == 0 || !strings.Contains(report.Errors[0], bad.want) {
+			t.Fatalf("expected %q: %+v", bad.want, report)
+		}
+	}
+	if report := importPlanData(db, []byte(`{"schema_version":"2","rows":[{"op":"add","row_type":"site","site":"X"}]}`), "json", projectID, ImportPolicy{}); len(report.Errors) == 0 || !strings.Contains(report.Errors[0], "only allowed in patches") {
+		t.Fatalf("op outside a patch: %+v", report)
+	}
+
+	// CSV patches carry an op column next to the export columns.
+	var csvPatch strings.Builder
+	w := csv.NewWriter(&csvPatch)
+	_ = w.Write(append(planCSVHeaders(), "op"))
+	_ = w.Write(append(planRowToCSV(PlanRow{RowType: planRowSegment, UID: uid("30", "cams")}), "delete"))
+	w.Flush()
+	report = importPlanPatch(db, strings.NewReader(csvPatch.String()), "csv", projectID, ImportPolicy{})
+	if len(report.Errors) != 0 || report.Deleted != 1 || hosts("cams") != 0 {
+		t.Fatalf("csv patch: %+v", report)
+	}
+}
+
+func TestSegmentPatch(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "patch.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 50)`, siteID)
+	segID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, gateway, notes, tags) VALUES(?, 1, '10.0.0.254', 'keep me', 'old')`, segID)
+
+	decode := func(body string) segmentPatch {
+		var p segmentPatch
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatalf("decode %s: %v", body, err)
+		}
+		return p
+	}
+	for _, body := range []string{`{}`, `{"vlan":0}`, `{"name":"  "}`, `{"hosts":-1}`, `{"locked":null}`} {
+		if err := decode(body).validate(); err == nil {
+			t.Fatalf("expected validation error for %s", body)
+		}
+	}
+	if err := applySegmentPatch(db, segID, decode(`{"vlan":20,"tags":"voice","locked":true}`)); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	seg, _ := segmentByID(db, segID)
+	if seg.VLAN != 20 || seg.Name != "users" || seg.Hosts.Int64 != 50 || !seg.Locked || seg.Tags.String != "voice" {
+		t.Fatalf("unexpected segment after patch: %+v", seg)
+	}
+	if !seg.DhcpEnabled || seg.Gateway.String != "10.0.0.254" || seg.Notes.String != "keep me" {
+		t.Fatalf("patch wiped segment_meta: %+v", seg)
+	}
+	if err := applySegmentPatch(db, segID, decode(`{"hosts":null,"tags":null}`)); err != nil {
+		t.Fatalf("patch clear: %v", err)
+	}
+	seg, _ = segmentByID(db, segID)
+	if seg.Hosts.Valid || seg.Tags.Valid || seg.VLAN != 20 || seg.Notes.String != "keep me" {
+		t.Fatalf("unexpected segment after clearing: %+v", seg)
+	}
+}
+
+func TestSegmentMetaTriState(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "meta.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 50)`, siteID)
+	segID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, gateway, notes, tags) VALUES(?, 1, '10.0.0.10-10.0.0.20', '10.0.0.254', 'keep me', 'voice')`, segID)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/segments/update", strings.NewReader("tags=&dhcp_enabled=off"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := applySegmentMetaPatch(db, segID, segmentMetaPatchFromForm(c)); err != nil {
+		t.Fatalf("form patch: %v", err)
+	}
+	seg, _ := segmentByID(db, segID)
+	if seg.DhcpEnabled || seg.Tags.Valid || seg.Notes.String != "keep me" || seg.Gateway.String != "10.0.0.254" || seg.DhcpRange.String != "10.0.0.10-10.0.0.20" {
+		t.Fatalf("form patch touched omitted fields: %+v", seg)
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	var buf bytes.Buffer
+	_ = writePlanBundle(&buf, bundle, "json")
+	var doc map[string]any
+	_ = json.Unmarshal(buf.Bytes(), &doc)
+	for _, raw := range doc["rows"].([]any) {
+		row := raw.(map[string]any)
+		if row["row_type"] == planRowSegment {
+			delete(row, "gateway")
+			delete(row, "dhcp_range")
+			row["notes"] = ""
+		}
+	}
+	raw, _ := json.Marshal(doc)
+	if report := importPlanData(db, raw, "json", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("import: %+v", report.Errors)
+	}
+	seg, _ = segmentByID(db, segID)
+	if seg.Notes.Valid || seg.Gateway.String != "10.0.0.254" || seg.DhcpRange.String != "10.0.0.10-10.0.0.20" {
+		t.Fatalf("json import should clear notes only: %+v", seg)
+	}
+
+	buf.Reset()
+	bundle, _ = buildPlanBundle(db, projectID)
+	_ = writePlanBundle(&buf, bundle, "csv")
+	_, _ = db.Exec(`UPDATE segment_meta SET notes='restored' WHERE segment_id=?`, segID)
+	if report := importPlanData(db, buf.Bytes(), "csv", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("csv import: %+v", report.Errors)
+	}
+	seg, _ = segmentByID(db, segID)
+	if seg.Notes.Valid || seg.Gateway.String != "10.0.0.254" {
+		t.Fatalf("csv columns are authoritative: %+v", seg)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "dash.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name, archived) VALUES('Archive', 1)`)
+	archivedID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO projects(name) VALUES('Campus')`)
+	campusID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, campusID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'a', '10.0.0.0/25')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'b', '10.0.0.64/26')`, siteID)
+	_ = insertAuditRecord(db, auditRecord{ProjectID: archivedID, Actor: "ops", Action: "archive", EntityType: "project"})
+	_ = insertAuditRecord(db, auditRecord{ProjectID: campusID, Actor: "ops", Action: "allocate", EntityType: "allocation"})
+
+	dash, err := buildDashboard(db)
+	if err != nil {
+		t.Fatalf("dashboard: %v", err)
+	}
+	if len(dash.Projects) != 2 || dash.Projects[0].Project.Name != "Campus" || !dash.Projects[1].Project.Archived {
+		t.Fatalf("unexpected project order: %+v", dash.Projects)
+	}
+	campus := dash.Projects[0]
+	if campus.Sites != 1 || campus.Segments.Total != 2 || campus.Conflicts.Total == 0 || dash.Segments != 2 {
+		t.Fatalf("unexpected campus stats: %+v", campus.ProjectSummary)
+	}
+	if campus.LastChange == nil || campus.LastChange.Action != "allocate" {
+		t.Fatalf("last change: %+v", campus.LastChange)
+	}
+	if len(dash.Recent) != 2 || dash.Recent[0].Project != "Campus" || dash.Recent[1].Project != "Archive" {
+		t.Fatalf("recent events: %+v", dash.Recent)
+	}
+}
+
+func TestGenerateFamilies(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "family.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Dual')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, dns) VALUES(?, '10.0.0.53, 2001:db8::53')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, cidr_v6) VALUES(?, '', 10, 'users', '10.0.10.0/24', '2001:db8:10::/64')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr_v6) VALUES(?, '', 20, 'v6only', '2001:db8:20::/64')`, siteID)
+
+	render := func(template, family string) string {
+		out, err := generateForProject(db, projectID, GenerateOptions{Template: template, IncludeVLAN: true, Family: family})
+		if err != nil {
+			t.Fatalf("generate %s/%s: %v", template, family, err)
+		}
+		return out.Output
+	}
+	v4 := render("cisco", "")
+	if !strings.Contains(v4, " ip address 10.0.10.1 255.255.255.0") || strings.Contains(v4, "ipv6") || strings.Contains(v4, "vlan 20") {
+		t.Fatalf("ipv4 output should ignore IPv6 prefixes:\n%s", v4)
+	}
+	v6 := render("cisco", "ipv6")
+	if strings.Contains(v6, " ip address ") || !strings.Contains(v6, " ipv6 address 2001:db8:20::1/64") ||
+		!strings.Contains(v6, " ipv6 nd prefix 2001:db8:10::/64") || !strings.Contains(v6, " ipv6 nd ra dns server 2001:db8::53") {
+		t.Fatalf("ipv6 output:\n%s", v6)
+	}
+	if strings.Contains(v6, "10.0.0.53") {
+		t.Fatalf("IPv4 resolvers must not be advertised:\n%s", v6)
+	}
+	dual := render("vyos", "dual")
+	for _, want := range []string{
+		"set interfaces vlan vlan10 address 10.0.10.1/24",
+		"set interfaces vlan vlan10 address 2001:db8:10::1/64",
+		"set service router-advert interface vlan20 prefix 2001:db8:20::/64",
+		"option.family=dual",
+	} {
+		if !strings.Contains(dual, want) {
+			t.Fatalf("dual output missing %q:\n%s", want, dual)
+		}
+	}
+	if normalizeGenerateFamily("dual-stack") != generateFamilyDual || normalizeGenerateFamily("bogus") != generateFamilyIPv4 {
+		t.Fatalf("family normalization")
+	}
+}
+
+func TestIPv6Modes(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "ra.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('RA')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, dns) VALUES(?, '2001:db8::53')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr_v6) VALUES(?, '', 10, 'servers', '2001:db8:10::/64')`, siteID)
+	statefulID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr_v6) VALUES(?, '', 20, 'iot', '2001:db8:20::/56')`, siteID)
+	slaacID, _ := res.LastInsertId()
+
+	mode := "Stateful"
+	dns := "2001:db8::10, 2001:db8::11"
+	if err := applySegmentMetaPatch(db, statefulID, segmentMetaPatch{
+		IPv6Mode: ipv6ModeField(optionalField[string]{Set: true, Value: &mode}),
+		IPv6DNS:  optionalField[string]{Set: true, Value: &dns},
+	}); err != nil {
+		t.Fatalf("patch meta: %v", err)
+	}
+	seg, _ := segmentByID(db, statefulID)
+	if seg.IPv6Mode.String != ipv6ModeStateful {
+		t.Fatalf("mode should be stored normalized: %+v", seg.IPv6Mode)
+	}
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, ipv6_mode, ipv6_dns) VALUES(?, 'slaac', '10.0.0.53')`, slaacID)
+
+	segs, _ := listSegments(db, projectID)
+	statuses, conflicts := analyzeAll(segs, nil, nil, ProjectRules{})
+	if statuses[slaacID].Level != statusConflict || statuses[statefulID].Level == statusConflict {
+		t.Fatalf("statuses: %+v", statuses)
+	}
+	var details []string
+	for _, c := range conflicts {
+		if c.Kind == "IPV6_MODE" {
+			details = append(details, c.Detail)
+		}
+	}
+	if len(details) != 2 || !strings.Contains(strings.Join(details, "\n"), "slaac needs a /64") {
+		t.Fatalf("ipv6 mode conflicts: %v", details)
+	}
+
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "cisco", IncludeVLAN: true, Family: "ipv6"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		" ipv6 nd prefix 2001:db8:10::/64 2592000 604800 no-autoconfig",
+		" ipv6 nd managed-config-flag",
+		" ipv6 nd ra dns server 2001:db8::11",
+		" ipv6 nd prefix 2001:db8:20::/56\n",
+	} {
+		if !strings.Contains(out.Output, want) {
+			t.Fatalf("cisco output missing %q:\n%s", want, out.Output)
+		}
+	}
+	if strings.Count(out.Output, "2001:db8::53") != 1 {
+		t.Fatalf("segment RDNSS should replace the site resolvers:\n%s", out.Output)
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("plan bundle: %v", err)
+	}
+	for _, row := range bundle.Rows {
+		if row.RowType == planRowSegment && row.Name == "servers" && (row.IPv6Mode != ipv6ModeStateful || row.IPv6DNS != dns) {
+			t.Fatalf("plan row: %+v", row)
+		}
+	}
+	dhcp := true
+	if err := validateSegmentRow(PlanRow{RowType: planRowSegment, DHCP: &dhcp, IPv6Mode: "eui64"}); err == nil {
+		t.Fatalf("unknown ipv6_mode should be rejected")
+	}
+}
+
+func TestBulkDeleteSegments(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "bulk.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Bulk')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	var ids []int64
+	for i, cidr := range []string{"10.0.1.0/24", "10.0.10.0/24", "10.0.2.0/24"} {
+		res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', ?, ?, ?, ?)`, siteID, 10+i, "seg"+itoa(i), cidr, i)
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, notes) VALUES(?, 'n')`, ids[0])
+	_, _ = db.Exec(`INSERT INTO mac_reservations(segment_id, mac, created_at) VALUES(?, '00:11:22:33:44:55', 'now')`, ids[0])
+	_, _ = db.Exec(`INSERT INTO deployed_configs(project_id, template, scope_key, content, updated_at) VALUES(?, 'cisco', 'project', ' ip address 10.0.10.1 255.255.255.0', 'now')`, projectID)
+	if _, err := saveGenerationSchedule(db, GenerationSchedule{ProjectID: projectID, Template: "vyos", SegmentFilter: "seg1", Cron: "@daily", Retention: 5, Enabled: true}); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+
+	preview, err := previewBulkDelete(db, projectID, parseSegmentIDs([]string{itoa64(ids[0]) + "," + itoa64(ids[1]), "999"}))
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if len(preview.Segments) != 2 || preview.MetaRows != 1 || preview.Reservations != 1 || preview.Locked != 1 {
+		t.Fatalf("preview counts: %+v", preview)
+	}
+	if len(preview.Configs) != 1 || len(preview.Configs[0].Segments) != 1 || preview.Configs[0].Segments[0] != "seg1" {
+		t.Fatalf("deployed config refs should match the gateway only: %+v", preview.Configs)
+	}
+	if len(preview.Schedules) != 1 || preview.Schedules[0].Segments[0] != "seg1" {
+		t.Fatalf("schedule refs: %+v", preview.Schedules)
+	}
+	if err := bulkDeleteSegments(db, preview.IDs()); err != nil {
+		t.Fatalf("bulk delete: %v", err)
+	}
+	var segs, meta, macs int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM segments`).Scan(&segs)
+	_ = db.QueryRow(`SELECT COUNT(*) FROM segment_meta`).Scan(&meta)
+	_ = db.QueryRow(`SELECT COUNT(*) FROM mac_reservations`).Scan(&macs)
+	if segs != 1 || meta != 0 || macs != 0 {
+		t.Fatalf("after delete: segments=%d meta=%d macs=%d", segs, meta, macs)
+	}
+}
+
+func TestBulkDHCP(t *testing.T) {
+	if _, err := bulkDHCPRequestFrom(url.Values{"policy": {"first"}, "count": {"0"}}.Get); err == nil {
+		t.Fatalf("expected a zero count to be rejected")
+	}
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "bulkdhcp.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Bulk')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	var ids []int64
+	for i, seg := range []struct{ name, cidr string }{{"users", "10.0.1.0/24"}, {"cams", "10.0.2.0/29"}, {"users-new", ""}, {"mgmt", "10.0.3.0/24"}} {
+		res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', ?, ?, NULLIF(?, ''), ?)`, siteID, 10+i, seg.name, seg.cidr, boolToInt(i == 1))
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+
+	req, err := bulkDHCPRequestFrom(url.Values{"policy": {"first"}, "count": {"50"}}.Get)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	preview, err := previewBulkDHCP(db, projectID, nil, "filter_name=users", req)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if len(preview.Segments) != 2 || preview.Changed != 2 || preview.Locked != 0 {
+		t.Fatalf("filtered preview: %+v", preview)
+	}
+	if got := preview.Segments[0].After.DhcpRange.String; got != "10.0.1.2-10.0.1.51" {
+		t.Fatalf("range after the gateway: %q", got)
+	}
+	if preview.Segments[1].Note == "" || preview.Segments[1].After.DhcpRange.Valid {
+		t.Fatalf("unallocated segment should keep its range with a note: %+v", preview.Segments[1])
+	}
+
+	req, _ = bulkDHCPRequestFrom(url.Values{"policy": {"last"}, "count": {"50"}}.Get)
+	preview, err = previewBulkDHCP(db, projectID, []int64{ids[1], ids[3]}, "filter_name=users", req)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if len(preview.Segments) != 2 || preview.Locked != 1 {
+		t.Fatalf("picked preview: %+v", preview)
+	}
+	cams := preview.Segments[0]
+	if cams.After.DhcpRange.String != "10.0.2.2-10.0.2.6" || !strings.Contains(cams.Note, "only 5") {
+		t.Fatalf("capped range: %q %q", cams.After.DhcpRange.String, cams.Note)
+	}
+	if err := applyBulkDHCP(db, preview); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	var enabled int
+	var stored string
+	if err := db.QueryRow(`SELECT dhcp_enabled, dhcp_range FROM segment_meta WHERE segment_id=?`, ids[3]).Scan(&enabled, &stored); err != nil {
+		t.Fatalf("meta: %v", err)
+	}
+	if enabled != 1 || stored != "10.0.3.205-10.0.3.254" {
+		t.Fatalf("stored: enabled=%d range=%q", enabled, stored)
+	}
+
+	req, _ = bulkDHCPRequestFrom(url.Values{"dhcp": {"off"}}.Get)
+	preview, _ = previewBulkDHCP(db, projectID, []int64{ids[3]}, "", req)
+	if err := applyBulkDHCP(db, preview); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	_ = db.QueryRow(`SELECT dhcp_enabled, dhcp_range FROM segment_meta WHERE segment_id=?`, ids[3]).Scan(&enabled, &stored)
+	if enabled != 0 || stored != "10.0.3.205-10.0.3.254" {
+		t.Fatalf("disabling should keep the range: enabled=%d range=%q", enabled, stored)
+	}
+}
+
+func TestPoolDrainingMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "drain.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	res, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family, state) VALUES(?, '10.0.0.0/24', 'ipv4', 'draining')`, siteID)
+	oldPoolID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family, priority) VALUES(?, '10.1.0.0/24', 'ipv4', 10)`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr, locked) VALUES(?, 'PROD', 10, 'voice', 26, '10.0.0.0/26', 1)`, siteID)
+	voiceID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range, gateway) VALUES(?, 1, '10.0.0.10-10.0.0.50', '10.0.0.1')`, voiceID)
+	_, _ = db.Exec(`INSERT INTO mac_reservations(segment_id, mac, ip, created_at) VALUES(?, '00:11:22:33:44:55', '10.0.0.20', 'now')`, voiceID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'PROD', 20, 'users', 26, '10.0.0.64/26')`, siteID)
+	usersID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 30, 'guests', 25)`, siteID)
+	guestsID, _ := res.LastInsertId()
+
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	cidrOf := func(id int64) string {
+		var cidr sql.NullString
+		_ = db.QueryRow(`SELECT cidr FROM segments WHERE id=?`, id).Scan(&cidr)
+		return cidr.String
+	}
+	if got := cidrOf(usersID); got != "10.0.0.64/26" {
+		t.Fatalf("segment in a draining pool should stay, got %s", got)
+	}
+	if got := cidrOf(guestsID); got != "10.1.0.0/25" {
+		t.Fatalf("new segment should skip the draining pool, got %s", got)
+	}
+
+	pool, _ := poolByID(db, oldPoolID)
+	plan, err := planPoolMigration(db, pool, 0)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.Moves) != 2 || plan.Locked != 1 || plan.Unplaced != 0 {
+		t.Fatalf("plan: %+v", plan)
+	}
+	move, _ := plan.Next()
+	if move.Segment.ID != voiceID || move.To.String() != "10.1.0.128/26" || move.GatewayTo != "10.1.0.129" || move.DhcpTo != "10.1.0.138-10.1.0.178" || move.Reservations != 1 {
+		t.Fatalf("first move: %+v", move)
+	}
+	if err := applyPoolMigrationMove(db, "ipv4", move); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	var gateway, dhcpRange, ip string
+	_ = db.QueryRow(`SELECT gateway, dhcp_range FROM segment_meta WHERE segment_id=?`, voiceID).Scan(&gateway, &dhcpRange)
+	_ = db.QueryRow(`SELECT ip FROM mac_reservations WHERE segment_id=?`, voiceID).Scan(&ip)
+	if cidrOf(voiceID) != "10.1.0.128/26" || gateway != "10.1.0.129" || dhcpRange != "10.1.0.138-10.1.0.178" || ip != "10.1.0.148" {
+		t.Fatalf("after move: cidr=%s gateway=%s range=%s ip=%s", cidrOf(voiceID), gateway, dhcpRange, ip)
+	}
+	plan, _ = planPoolMigration(db, pool, 0)
+	if len(plan.Moves) != 1 || plan.Moves[0].Segment.ID != usersID || plan.Moves[0].To.String() != "10.1.0.192/26" {
+		t.Fatalf("remaining plan: %+v", plan.Moves)
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("plan bundle: %v", err)
+	}
+	found := false
+	for _, row := range bundle.Rows {
+		if row.RowType == planRowPool && row.Pool == "10.0.0.0/24" {
+			found = row.PoolState == PoolStateDraining
+		}
+	}
+	if !found {
+		t.Fatalf("plan export should keep the draining state")
+	}
+}
+
+func TestRenumberSite(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "renumber.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family) VALUES(?, '192.168.0.0/16', 'ipv4')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family) VALUES(?, '10.20.0.0/16', 'ipv4')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'PROD', 10, 'users', 24, '192.168.10.0/24')`, siteID)
+	usersID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, dhcp_range) VALUES(?, 1, '192.168.10.100-192.168.10.200')`, usersID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr, locked) VALUES(?, 'PROD', 20, 'printers', 26, '192.168.20.0/26', 1)`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'PROD', 30, 'mgmt', 24, '10.20.0.0/24')`, siteID)
+
+	req := RenumberRequest{Site: "hq", OldRaw: "192.168.0.0/16", Targets: []string{"192.168.0.0/16"}}
+	if _, err := planRenumbering(db, projectID, req); err == nil {
+		t.Fatalf("expected a target inside the old space to be rejected")
+	}
+	req.Targets = nil
+	plan, err := planRenumbering(db, projectID, req)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.Mappings) != 2 || plan.Unplaced != 0 {
+		t.Fatalf("mappings: %+v", plan.Mappings)
+	}
+	users := plan.Mappings[0]
+	if users.Name != "users" || users.NewCIDR != "10.20.1.0/24" || users.NewGateway != "10.20.1.1" || users.NewDhcpRange != "10.20.1.100-10.20.1.200" {
+		t.Fatalf("users mapping: %+v", users)
+	}
+	if plan.Mappings[1].NewCIDR != "10.20.2.0/26" || !plan.Mappings[1].Locked {
+		t.Fatalf("printers mapping: %+v", plan.Mappings[1])
+	}
+
+	out, _, err := renderRenumberMapping(plan, "csv")
+	if err != nil || !strings.Contains(out, "HQ,PROD,10,users,false,192.168.10.0/24,10.20.1.0/24,10.20.0.0/16") {
+		t.Fatalf("csv: %v\n%s", err, out)
+	}
+	checklist := renderRenumberChecklist(plan, "Default", time.Now())
+	for _, want := range []string{"### users (VRF PROD, VLAN 10): 192.168.10.0/24 → 10.20.1.0/24", "- [ ] Move the DHCP range 192.168.10.100-192.168.10.200 to 10.20.1.100-10.20.1.200", "(locked: needs a reason)", "- [ ] Withdraw 192.168.0.0/16 from routing"} {
+		if !strings.Contains(checklist, want) {
+			t.Fatalf("checklist is missing %q:\n%s", want, checklist)
+		}
+	}
+	before, after, err := renderRenumberedConfigs(db, projectID, plan, "cisco")
+	if err != nil {
+		t.Fatalf("configs: %v", err)
+	}
+	if !strings.Contains(before, "192.168.10.1") || strings.Contains(after, "192.168.10.1 ") || !strings.Contains(after, "10.20.1.1") {
+		t.Fatalf("renumbered config:\n%s", after)
+	}
+	var cidr string
+	_ = db.QueryRow(`SELECT cidr FROM segments WHERE id=?`, usersID).Scan(&cidr)
+	if cidr != "192.168.10.0/24" {
+		t.Fatalf("renumbering must not change the plan, got %s", cidr)
+	}
+}
+
+func TestPoolVRFShares(t *testing.T) {
+	if _, err := parseVRFShares("PROD=60, DEV=50"); err == nil {
+		t.Fatalf("expected shares over 100%% to be rejected")
+	}
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "shares.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, vrf_shares) VALUES(?, '10.0.0.0/24', 'DEV=25')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'DEV', 10, 'lab', '10.0.0.0/27', 1)`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'DEV', 20, 'test', 26)`, siteID)
+	testID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 30, 'users', 26)`, siteID)
+
+	err = allocateProject(db, projectID, nil)
+	var allocErr *AllocationError
+	if !errors.As(err, &allocErr) || !strings.Contains(allocErr.Detail, "VRF dev share exhausted (25% of 10.0.0.0/24)") {
+		t.Fatalf("expected share conflict, got %v", err)
+	}
+	_, _ = db.Exec(`UPDATE segments SET prefix=27 WHERE id=?`, testID)
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	sites, _ := listSites(db, projectID)
+	capacity := buildCapacityReport(segs, pools, sites, 0, 0, 64)
+	if len(capacity.Pools) != 1 || len(capacity.Pools[0].Shares) != 2 {
+		t.Fatalf("share rows: %+v", capacity.Pools)
+	}
+	dev, prod := capacity.Pools[0].Shares[0], capacity.Pools[0].Shares[1]
+	if dev.VRF != "DEV" || dev.Used != "64" || dev.Limit != "64" || dev.Utilization != "100.0%" || dev.Over {
+		t.Fatalf("dev share: %+v", dev)
+	}
+	if prod.VRF != "PROD" || prod.Used != "64" || prod.Share != "—" {
+		t.Fatalf("prod share: %+v", prod)
+	}
+}
+
+func TestConflictIDs(t *testing.T) {
+	a := Conflict{Kind: "OVERLAP", Detail: "site=HQ vrf=PROD: a 10.0.0.0/24 overlaps b 10.0.0.0/25", Level: statusConflict.Label()}
+	b := a
+	b.Level = statusWarning.Label()
+	if a.ID() != b.ID() || !strings.HasPrefix(a.ID(), "C-") || len(a.ID()) != 14 {
+		t.Fatalf("unstable id: %s vs %s", a.ID(), b.ID())
+	}
+	c := a
+	c.Kind = "OVERLAP_V6"
+	if c.ID() == a.ID() {
+		t.Fatalf("kind should change the id")
+	}
+	sorted := sortConflicts([]Conflict{c, a})
+	if sorted[0].Kind != "OVERLAP" {
+		t.Fatalf("sort order: %+v", sorted)
+	}
+	rows := exportConflicts([]Conflict{c, a})
+	if rows[0].ID != a.ID() || rows[1].ID != c.ID() {
+		t.Fatalf("export rows: %+v", rows)
+	}
+
+	sites := []Site{{ID: 1, Name: "HQ"}}
+	pools := []Pool{{ID: 1, SiteID: 1, Site: "HQ", CIDR: "10.0.0.0/16", Family: "ipv4"}}
+	segs := []Segment{{ID: 7, SiteID: 1, Site: "HQ", Name: "users", Hosts: sql.NullInt64{Int64: 20, Valid: true},
+		CIDR: sql.NullString{String: "10.0.1.0/24", Valid: true}}}
+	idsByKind := func(segs []Segment) map[string]Conflict {
+		_, conflicts := analyzeAll(segs, pools, sites, defaultProjectRules())
+		out := map[string]Conflict{}
+		for _, c := range conflicts {
+			out[c.Kind] = c
+		}
+		return out
+	}
+	before := idsByKind(segs)
+	segs[0].Name = "staff"
+	segs[0].Hosts.Int64 = 10
+	segs = append(segs, Segment{ID: 8, SiteID: 1, Site: "HQ", Name: "voice", CIDR: sql.NullString{String: "10.0.8.0/22", Valid: true}})
+	after := idsByKind(segs)
+	for _, kind := range []string{"POOL_FRAGMENTATION", "OVERSIZED"} {
+		b, a := before[kind], after[kind]
+		if b.Kind == "" || a.Detail == b.Detail || a.ID() != b.ID() {
+			t.Fatalf("%s id changed with utilization: %q %s -> %q %s", kind, b.Detail, b.ID(), a.Detail, a.ID())
+		}
+	}
+
+	// a copy of the plan has other row IDs but the same conflict IDs
+	renumber := func(segs []Segment, offset int64) []Segment {
+		out := append([]Segment(nil), segs...)
+		for i := range out {
+			out[i].ID += offset
+			out[i].SiteID += offset
+		}
+		return out
+	}
+	segs = append(segs, Segment{ID: 9, SiteID: 1, Site: "HQ", Name: "cams", VLAN: 40, PrefixV6: sql.NullInt64{Int64: 64, Valid: true}})
+	_, original := analyzeAll(segs, pools, sites, defaultProjectRules())
+	copiedPools := append([]Pool(nil), pools...)
+	copiedPools[0].SiteID += 100
+	_, copied := analyzeAll(renumber(segs, 100), copiedPools, []Site{{ID: 101, Name: "HQ"}}, defaultProjectRules())
+	if len(original) == 0 || len(original) != len(copied) {
+		t.Fatalf("conflicts differ between copies: %d vs %d", len(original), len(copied))
+	}
+	for i, c := range sortConflicts(original) {
+		if c.key == "" || c.ID() != sortConflicts(copied)[i].ID() {
+			t.Fatalf("%s has no stable key across copies: %q", c.Kind, c.key)
+		}
+	}
+	poolMissing := func(segs []Segment, pools []Pool) Conflict {
+		_, _, conflicts := planAllocations(segs, pools, nil, nil, defaultProjectRules())
+		for _, c := range conflicts {
+			if c.Kind == "POOL_MISSING" {
+				return c
+			}
+		}
+		t.Fatalf("no POOL_MISSING conflict: %+v", conflicts)
+		return Conflict{}
+	}
+	if a, b := poolMissing(segs, pools), poolMissing(renumber(segs, 100), copiedPools); a.key == "" || a.ID() != b.ID() {
+		t.Fatalf("POOL_MISSING should be keyed by site and family: %q %q", a.key, b.key)
+	}
+}
+
+func TestConflictTickets(t *testing.T) {
+	var gotBody map[string]any
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		switch r.URL.Path {
+		case "/rest/api/2/issue":
+			_, _ = w.Write([]byte(`{"id":"10001","key":"NET-7"}`))
+		case "/api/now/table/incident":
+			_, _ = w.Write([]byte(`{"result":{"sys_id":"abc123","number":"INC0010"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("SUBNETIO_SECRET_JIRA", "tok")
+
+	segs := []Segment{{Name: "users", Site: "HQ"}, {Name: "voice", Site: "HQ"}, {Name: "users", Site: "DC"}}
+	conflict := Conflict{Kind: "OVERLAP", Level: statusConflict.Label(), Detail: "site=HQ vrf=PROD: users 10.0.0.0/24 overlaps voice 10.0.0.0/25"}
+	ctx := buildTicketContext("Core", conflict, segs)
+	if strings.Join(ctx.Sites, ",") != "HQ" || strings.Join(ctx.Segments, ",") != "users,voice" || strings.Join(ctx.CIDRs, ",") != "10.0.0.0/24,10.0.0.0/25" {
+		t.Fatalf("ticket context: %+v", ctx)
+	}
+
+	jira := TicketIntegration{Kind: ticketKindJira, URL: srv.URL, Board: "NET", TokenRef: "secret:jira"}
+	key, link, err := createTicket(jira, ctx)
+	if err != nil || key != "NET-7" || link != srv.URL+"/browse/NET-7" {
+		t.Fatalf("jira: %s %s %v", key, link, err)
+	}
+	fields, _ := gotBody["fields"].(map[string]any)
+	if gotAuth != "Bearer tok" || fields["summary"] != "Core: OVERLAP at HQ (10.0.0.0/24, 10.0.0.0/25)" {
+		t.Fatalf("jira request: auth=%q fields=%v", gotAuth, fields)
+	}
+
+	snow := TicketIntegration{Kind: ticketKindServiceNow, URL: srv.URL, Board: "Network", Username: "bot", TokenRef: "secret:jira", SummaryTemplate: "{{.ID}} {{join .Segments \"/\"}}"}
+	key, link, err = createTicket(snow, ctx)
+	if err != nil || key != "INC0010" || !strings.Contains(link, "sys_id%3Dabc123") {
+		t.Fatalf("servicenow: %s %s %v", key, link, err)
+	}
+	if gotBody["short_description"] != conflict.ID()+" users/voice" || gotBody["assignment_group"] != "Network" || !strings.HasPrefix(gotAuth, "Basic ") {
+		t.Fatalf("servicenow request: auth=%q body=%v", gotAuth, gotBody)
+	}
+	if err := validateTicketIntegration(TicketIntegration{URL: srv.URL, Board: "NET", SummaryTemplate: "{{.Kind"}); err == nil {
+		t.Fatalf("expected broken summary template to be rejected")
+	}
+
+	tickets := []ConflictTicket{{ConflictID: conflict.ID(), TicketKey: "NET-7"}, {ConflictID: "C-000000000000", TicketKey: "NET-1"}}
+	open, resolved := splitConflictTickets(tickets, []Conflict{conflict})
+	if open[conflict.ID()].TicketKey != "NET-7" || len(resolved) != 1 || resolved[0].TicketKey != "NET-1" {
+		t.Fatalf("split tickets: %v %v", open, resolved)
+	}
+}
+
+func TestTemplateSourceCache(t *testing.T) {
+	cache := newTemplateSourceCache(2)
+	mod := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.put("a", templateSource{Content: "A", Source: "override"}, mod, 1)
+	cache.put("b", templateSource{Content: "B", Source: "embedded"}, time.Time{}, 0)
+	if _, ok := cache.get("a", "override", mod, 1); !ok {
+		t.Fatalf("expected cache hit for a")
+	}
+	if _, ok := cache.get("a", "override", mod.Add(time.Second), 1); ok {
+		t.Fatalf("changed mtime should miss")
+	}
+	if _, ok := cache.get("a", "embedded", time.Time{}, 0); ok {
+		t.Fatalf("removed override should miss")
+	}
+	cache.put("c", templateSource{Content: "C", Source: "embedded"}, time.Time{}, 0)
+	if _, ok := cache.get("b", "embedded", time.Time{}, 0); ok {
+		t.Fatalf("least recently used entry should be evicted")
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("stats: %+v", stats)
+	}
+	cache.Reset()
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Hits != 0 {
+		t.Fatalf("reset: %+v", stats)
+	}
+
+	templateSources.forget("vyos")
+	first, err := loadTemplateSource("vyos")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	hits := templateSources.Stats().Hits
+	second, _ := loadTemplateSource("vyos")
+	if second.Content != first.Content || templateSources.Stats().Hits != hits+1 {
+		t.Fatalf("embedded template should be served from cache")
+	}
+}
+
+func TestGenerateAPI(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "gen.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'MGMT', 20, 'mgmt', '10.0.1.0/24')`, siteID)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/v1/generate", nil)
+	var req generateAPIRequest
+	if err := json.Unmarshal([]byte(`{"template":"cisco","filters":{"vrf":"PROD"}}`), &req); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	out, err := generateAPI(db, c, projectID, req)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.Contains(out.Output, "10.0.0.1") || strings.Contains(out.Output, "10.0.1.1") || out.Metadata.SegmentCount != 1 || out.GenerationID == 0 || out.Checksum == "" {
+		t.Fatalf("named template: %+v", out)
+	}
+
+	req = generateAPIRequest{TemplateBody: `{{range .Segments}}{{.Name}}={{.Gateway}};{{end}}`}
+	off := false
+	req.Options.IncludeDHCP = &off
+	out, err = generateAPI(db, c, projectID, req)
+	if err != nil {
+		t.Fatalf("inline: %v", err)
+	}
+	if !strings.HasSuffix(out.Output, "mgmt=10.0.1.1;users=10.0.0.1;") && !strings.HasSuffix(out.Output, "users=10.0.0.1;mgmt=10.0.1.1;") {
+		t.Fatalf("inline output: %q", out.Output)
+	}
+	if out.Metadata.TemplateSource != "inline" || out.Metadata.Options["include_dhcp"] != "false" || out.GenerationID != 0 {
+		t.Fatalf("inline metadata: %+v", out)
+	}
+	if _, err := generateAPI(db, c, projectID, generateAPIRequest{}); err == nil {
+		t.Fatalf("expected missing template to fail")
+	}
+}
+
+func TestScopedPlanExport(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "scope.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	hqID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('BRANCH')`)
+	branchID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?), (?, ?)`, projectID, hqID, projectID, branchID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24'), (?, '10.1.0.0/24')`, hqID, branchID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/26')`, hqID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'DEV', 20, 'lab', '10.0.0.64/26')`, hqID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'DEV', 30, 'lab', '10.1.0.0/26')`, branchID)
+
+	full, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("full bundle: %v", err)
+	}
+	fullUIDs := map[string]bool{}
+	for _, row := range full.Rows {
+		fullUIDs[row.RowType+"|"+row.UID] = true
+	}
+	count := func(b PlanBundle, rowType string) int {
+		n := 0
+		for _, row := range b.Rows {
+			if row.RowType == rowType {
+				n++
+			}
+			if row.UID != "" && !fullUIDs[row.RowType+"|"+row.UID] {
+				t.Fatalf("scoped uid differs from full export: %+v", row)
+			}
+		}
+		return n
+	}
+
+	site, err := buildScopedPlanBundle(db, projectID, PlanScope{Site: "hq"})
+	if err != nil {
+		t.Fatalf("site bundle: %v", err)
+	}
+	if count(site, planRowSite) != 1 || count(site, planRowPool) != 1 || count(site, planRowSegment) != 2 || count(site, planRowMeta) != 1 {
+		t.Fatalf("site scope rows: %+v", site.Rows)
+	}
+	vrf, _ := buildScopedPlanBundle(db, projectID, PlanScope{VRF: "prod"})
+	if count(vrf, planRowSite) != 1 || count(vrf, planRowPool) != 1 || count(vrf, planRowSegment) != 1 {
+		t.Fatalf("vrf scope rows: %+v", vrf.Rows)
+	}
+	both, _ := buildScopedPlanBundle(db, projectID, PlanScope{Site: "BRANCH", VRF: "PROD"})
+	if count(both, planRowSite) != 0 || count(both, planRowSegment) != 0 {
+		t.Fatalf("empty scope rows: %+v", both.Rows)
+	}
+	if got := (PlanScope{Site: "HQ", VRF: "DEV"}).fileSuffix(); got != "_hq_vrf_dev" {
+		t.Fatalf("file suffix: %q", got)
+	}
+}
+
+func TestImportConflictPolicy(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "policy.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	hqID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, hqID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, hqID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, hosts) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/26', 50)`, hqID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 20, 'voice', '10.0.0.64/27')`, hqID)
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("plan bundle: %v", err)
+	}
+	var raw bytes.Buffer
+	if err := writePlanBundle(&raw, bundle, "json"); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+	if report := importPlanData(db, raw.Bytes(), "json", projectID, ImportPolicy{Default: importPolicyFail}); len(report.Errors) != 0 || len(report.Rows) != 0 {
+		t.Fatalf("unchanged re-import should not conflict: %+v", report)
+	}
+
+	for i := range bundle.Rows {
+		if bundle.Rows[i].RowType == planRowSegment && bundle.Rows[i].Name == "users" {
+			bundle.Rows[i].CIDR = "10.0.0.128/26"
+		}
+	}
+	raw.Reset()
+	_ = writePlanBundle(&raw, bundle, "json")
+	cidrOf := func() string {
+		var cidr string
+		_ = db.QueryRow(`SELECT cidr FROM segments WHERE name='users'`).Scan(&cidr)
+		return cidr
+	}
+
+	report := importPlanData(db, raw.Bytes(), "json", projectID, ImportPolicy{Default: importPolicySkip})
+	if len(report.Errors) != 0 || report.Skipped != 1 || len(report.Rows) != 1 || report.Rows[0].Outcome != "skipped" || cidrOf() != "10.0.0.0/26" {
+		t.Fatalf("skip policy: %+v cidr=%s", report, cidrOf())
+	}
+	if len(report.Rows[0].Fields) != 1 || report.Rows[0].Fields[0] != "cidr" {
+		t.Fatalf("skip fields: %+v", report.Rows[0])
+	}
+
+	policy, err := parseImportPolicy("overwrite,segment=fail")
+	if err != nil || policy.String() != "overwrite,segment=fail" {
+		t.Fatalf("parse policy: %+v %v", policy, err)
+	}
+	report = importPlanData(db, raw.Bytes(), "json", projectID, policy)
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "differs from existing data (cidr)") || cidrOf() != "10.0.0.0/26" {
+		t.Fatalf("fail policy: %+v", report)
+	}
+
+	report = importPlanData(db, raw.Bytes(), "json", projectID, ImportPolicy{})
+	if len(report.Errors) != 0 || report.Updated != 1 || report.Rows[0].Outcome != "overwritten" || cidrOf() != "10.0.0.128/26" {
+		t.Fatalf("overwrite policy: %+v cidr=%s", report, cidrOf())
+	}
+	if _, err := parseImportPolicy("vrf=skip"); err == nil {
+		t.Fatalf("expected invalid entity error")
+	}
+}
+
+func TestImportProvenance(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "provenance.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	raw := []byte(`{"schema_version":"2","rows":[
+		{"row_type":"meta","schema_version":"2"},
+		{"row_type":"rules","vlan_scope":"site","require_in_pool":false,"allow_reserved_overlap":false,"oversize_threshold":0},
+		{"row_type":"site","site":"HQ"},
+		{"row_type":"pool","site":"HQ","pool":"10.0.0.0/24"},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":10,"name":"users","cidr":"10.0.0.0/26","locked":false},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":20,"name":"voice","cidr":"10.0.0.64/27","locked":false}
+	]}`)
+	report := importPlanData(db, raw, "json", projectID, ImportPolicy{})
+	report.FileName = "hq.json"
+	if len(report.Errors) != 0 {
+		t.Fatalf("import: %+v", report.Errors)
+	}
+	if err := recordImportProvenance(db, projectID, "json", "tester", report); err != nil || report.ImportID == 0 {
+		t.Fatalf("record provenance: %v %d", err, report.ImportID)
+	}
+	first := report.ImportID
+
+	segs, _ := listSegments(db, projectID)
+	byName := map[string]int64{}
+	for _, s := range segs {
+		byName[s.Name] = s.ID
+	}
+	prov, err := provenanceByEntity(db, planRowSegment)
+	if err != nil {
+		t.Fatalf("provenance: %v", err)
+	}
+	users := prov[byName["users"]]
+	if users.ImportID != first || users.FileName != "hq.json" || users.Action != provenanceCreated || users.RowUID != stableID(planRowSegment, "Default", "HQ", "PROD", "10", "users") {
+		t.Fatalf("segment provenance: %+v", users)
+	}
+	sites, _ := provenanceByEntity(db, planRowSite)
+	if len(sites) != 1 {
+		t.Fatalf("site provenance: %+v", sites)
+	}
+
+	// Unchanged rows are not claimed by a later import; changed ones are.
+	changed := bytes.Replace(raw, []byte(`"10.0.0.64/27"`), []byte(`"10.0.0.96/27"`), 1)
+	report = importPlanData(db, changed, "json", projectID, ImportPolicy{})
+	if err := recordImportProvenance(db, projectID, "json", "tester", report); err != nil {
+		t.Fatalf("record second import: %v", err)
+	}
+	prov, _ = provenanceByEntity(db, planRowSegment)
+	if prov[byName["users"]].ImportID != first || prov[byName["voice"]].ImportID != report.ImportID || prov[byName["voice"]].Action != provenanceUpdated {
+		t.Fatalf("second import provenance: %+v", prov)
+	}
+	ids, err := importedSegmentIDs(db, projectID, first)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("imported segments: %v %v", ids, err)
+	}
+	if ids, _ := importedSegmentIDs(db, projectID, report.ImportID); len(ids) != 0 {
+		t.Fatalf("updates are not deleted with an import: %v", ids)
+	}
+	if err := deleteSegment(db, byName["users"]); err != nil {
+		t.Fatalf("delete segment: %v", err)
+	}
+	if ids, _ := importedSegmentIDs(db, projectID, first); len(ids) != 1 {
+		t.Fatalf("deleted segment still listed: %v", ids)
+	}
+}
+
+func TestSegmentRules(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "segrules.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+
+	if ranges, err := parseVLANRanges("300, 100-199"); err != nil || formatVLANRanges(ranges) != "100-199,300" {
+		t.Fatalf("parse ranges: %v %v", ranges, err)
+	}
+	for _, bad := range []string{"0-10", "100-5000", "200-100", "abc"} {
+		if _, err := parseVLANRanges(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+
+	rules, _ := getProjectRules(db, projectID)
+	rules.VLANRanges = "100-199"
+	rules.DefaultVRF = "PROD"
+	if err := saveProjectRules(db, projectID, rules); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	rules, _ = getProjectRules(db, projectID)
+	if rules.VLANRanges != "100-199" || rules.DefaultVRF != "PROD" || rules.SegmentCheck != SegmentCheckWarn {
+		t.Fatalf("rules round trip: %+v", rules)
+	}
+	if _, err := checkSegmentRules(rules, "PROD", 5000, nil); err == nil {
+		t.Fatalf("VLAN 5000 must be rejected")
+	}
+	warnings, err := checkSegmentRules(rules, "PRDO", 250, nil)
+	if err != nil || len(warnings) != 2 {
+		t.Fatalf("warn mode: %v %v", warnings, err)
+	}
+	if warnings, _ := checkSegmentRules(rules, "PROD", 150, nil); len(warnings) != 0 {
+		t.Fatalf("default VRF in range: %v", warnings)
+	}
+
+	// Imports warn per row; in block mode the row fails.
+	plan := func(vlan int) []byte {
+		return []byte(fmt.Sprintf(`{"schema_version":"2","rows":[
+			{"row_type":"segment","site":"HQ","vrf":"DEV","vlan":%d,"name":"lab","locked":false}
+		]}`, vlan))
+	}
+	report := importPlanData(db, plan(250), "json", projectID, ImportPolicy{})
+	if report.SegmentsAdded != 1 || len(report.Warnings) != 2 {
+		t.Fatalf("warn import: %+v", report)
+	}
+	rules.SegmentCheck = SegmentCheckBlock
+	_ = saveProjectRules(db, projectID, rules)
+	report = importPlanData(db, plan(260), "json", projectID, ImportPolicy{})
+	if report.SegmentsAdded != 0 || len(report.Errors) == 0 || !strings.Contains(report.Errors[0], "VLAN 260 is outside the project ranges 100-199") {
+		t.Fatalf("block import: %+v", report)
+	}
+
+	// Presets keep the project's VLAN and VRF settings.
+	preset, ok := resolveRulePreset(db, "strict", rules)
+	if !ok || preset.VLANRanges != "100-199" || preset.DefaultVRF != "PROD" || preset.SegmentCheck != SegmentCheckBlock {
+		t.Fatalf("preset: %+v", preset)
+	}
+	bundle, _ := buildPlanBundle(db, projectID)
+	for _, row := range bundle.Rows {
+		if row.RowType == planRowRules && (row.VLANRanges != "100-199" || row.DefaultVRF != "PROD" || row.SegmentCheck != SegmentCheckBlock) {
+			t.Fatalf("rules row: %+v", row)
+		}
+	}
+}
+
+func TestPlanImportStreaming(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "stream.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	// Rows before schema_version, as written with sorted keys, a multi-line
+	// block scalar and a row with an unknown key that fails on its own.
+	raw := `# plan
+rows:
+- row_type: meta
+  schema_version: "2"
+- row_type: rules
+  vlan_scope: site
+  require_in_pool: false
+  allow_reserved_overlap: false
+  oversize_threshold: 0
+- row_type: site
+  site: HQ
+- row_type: pool
+  site: HQ
+  pool: 10.0.0.0/24
+- row_type: segment
+  site: HQ
+  vrf: PROD
+  vlan: 10
+  name: users
+  cidr: 10.0.0.0/26
+  locked: false
+  dhcp: false
+  notes: |-
+    first line
+
+    - not a row
+- row_type: segment
+  bogus: 1
+schema_version: "2"
+`
+	report := importPlanStream(db, strings.NewReader(raw), "yaml", projectID, ImportPolicy{})
+	if len(report.Errors) != 1 || !strings.HasPrefix(report.Errors[0], "row 6:") {
+		t.Fatalf("yaml import errors: %+v", report.Errors)
+	}
+	segs, _ := listSegments(db, projectID)
+	if len(segs) != 1 || segs[0].Notes.String != "first line\n\n- not a row" {
+		t.Fatalf("yaml segments: %+v", segs)
+	}
+
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	for _, format := range []string{"yaml", "json"} {
+		var buf bytes.Buffer
+		if err := writePlanBundle(&buf, bundle, format); err != nil {
+			t.Fatalf("write %s: %v", format, err)
+		}
+		report := importPlanStream(db, &buf, format, projectID, ImportPolicy{Default: importPolicyFail})
+		if len(report.Errors) != 0 || len(report.Rows) != 0 {
+			t.Fatalf("%s round trip: %+v %+v", format, report.Errors, report.Rows)
+		}
+	}
+
+	bad := importPlanData(db, []byte(`{"schema_version":"2","rows":[{"row_type":"site","site":"X"},`), "json", projectID, ImportPolicy{})
+	if len(bad.Errors) == 0 || !strings.HasPrefix(bad.Errors[0], "parse json:") {
+		t.Fatalf("truncated json: %+v", bad.Errors)
+	}
+	if r := importPlanData(db, []byte("rows: []\n"), "yaml", projectID, ImportPolicy{}); len(r.Errors) != 1 || r.Errors[0] != "schema_version is required" {
+		t.Fatalf("missing version: %+v", r.Errors)
+	}
+}
+
+func TestPersistDerivedMeta(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "derived.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	rules, _ := getProjectRules(db, projectID)
+	rules.PersistDerived = true
+	if err := saveProjectRules(db, projectID, rules); err != nil {
+		t.Fatalf("save rules: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 10, 'users', 26)`, siteID)
+	usersID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 20, 'voice', 27)`, siteID)
+	voiceID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled) VALUES(?, 1)`, usersID)
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, gateway) VALUES(?, '192.0.2.1')`, voiceID)
+
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	users, _ := segmentByID(db, usersID)
+	voice, _ := segmentByID(db, voiceID)
+	p := netip.MustParsePrefix(users.CIDR.String)
+	gw := p.Addr().Next()
+	if users.Gateway.String != gw.String() || users.DhcpRange.String != gw.Next().String()+"-"+u32ToIPv4(ipv4ToU32(p.Addr())+62).String() {
+		t.Fatalf("users meta: %q %q (%s)", users.Gateway.String, users.DhcpRange.String, users.CIDR.String)
+	}
+	if voice.Gateway.String != "192.0.2.1" || voice.DhcpRange.Valid {
+		t.Fatalf("voice meta: %+v", voice)
+	}
+
+	// A segment that moves takes its saved gateway along.
+	_, _ = db.Exec(`UPDATE segments SET prefix=25 WHERE id=?`, voiceID)
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("reallocate: %v", err)
+	}
+	moved, _ := segmentByID(db, usersID)
+	if moved.CIDR.String == users.CIDR.String {
+		t.Fatalf("users did not move: %s", moved.CIDR.String)
+	}
+	users = moved
+	p = netip.MustParsePrefix(users.CIDR.String)
+	if users.Gateway.String != p.Addr().Next().String() || !strings.HasPrefix(users.DhcpRange.String, p.Addr().Next().Next().String()+"-") {
+		t.Fatalf("moved users meta: %q %q (%s)", users.Gateway.String, users.DhcpRange.String, users.CIDR.String)
+	}
+}
+
+func TestConflictCountsCache(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "counts.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 10, 'users', '10.0.0.0/25', 1)`, siteID)
+
+	if got := projectConflictCounts(db, projectID); got.Conflicts != 0 {
+		t.Fatalf("clean project: %+v", got)
+	}
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 20, 'voice', '10.0.0.0/26', 1)`, siteID)
+	if got := projectConflictCounts(db, projectID); got.Conflicts != 0 {
+		t.Fatalf("unaudited write must be served from the cache: %+v", got)
+	}
+	if err := insertAuditRecord(db, auditRecord{ProjectID: projectID, Actor: "tester", Action: "create", EntityType: "segment"}); err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	if got := projectConflictCounts(db, projectID); got.Conflicts == 0 {
+		t.Fatalf("audited write must refresh the counts: %+v", got)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/segments", nil)
+	data, _ := baseData(c, db, projectID)
+	if counts, ok := data["ConflictCounts"].(ConflictCounts); !ok || counts.Conflicts == 0 {
+		t.Fatalf("baseData counts: %#v", data["ConflictCounts"])
+	}
+}
+
+func TestNetworkDiagramExport(t *testing.T) {
+	sites := []Site{{ID: 1, Name: "HQ"}, {ID: 2, Name: "EMPTY"}}
+	segs := []Segment{
+		{ID: 1, SiteID: 1, Site: "HQ", VRF: "PROD", VLAN: 20, Name: "voice", CIDR: sql.NullString{String: "10.0.0.64/27", Valid: true}},
+		{ID: 2, SiteID: 1, Site: "HQ", VRF: "PROD", VLAN: 10, Name: `users <"a">`, CIDR: sql.NullString{String: "10.0.0.0/26", Valid: true}, Locked: true},
+		{ID: 3, SiteID: 1, Site: "HQ", VRF: "", VLAN: 30, Name: "lab"},
+	}
+	diagram := buildNetworkDiagram("Default", sites, segs)
+	if len(diagram) != 2 || diagram[0].Name != "EMPTY" || len(diagram[1].VRFs) != 2 || diagram[1].VRFs[1].Segments[0].VLAN != 10 {
+		t.Fatalf("diagram: %+v", diagram)
+	}
+	if uid := diagram[1].VRFs[1].Segments[1].UID; uid != stableID(planRowSegment, "Default", "HQ", "PROD", "20", "voice") {
+		t.Fatalf("segment uid: %s", uid)
+	}
+
+	raw, err := renderDrawio("Default", diagram)
+	if err != nil {
+		t.Fatalf("drawio: %v", err)
+	}
+	var file drawioFile
+	if err := xml.Unmarshal(raw, &file); err != nil {
+		t.Fatalf("drawio is not valid xml: %v", err)
+	}
+	parents := map[string]string{}
+	for _, cell := range file.Diagram.Model.Cells {
+		parents[cell.ID] = cell.Parent
+	}
+	// 2 root cells, 2 sites, 2 VRFs, 3 segments.
+	if len(file.Diagram.Model.Cells) != 9 {
+		t.Fatalf("drawio cells: %+v", file.Diagram.Model.Cells)
+	}
+	users := diagram[1].VRFs[1].Segments[0]
+	if vrf := parents[users.UID]; parents[vrf] != stableID(planRowSite, "Default", "HQ") {
+		t.Fatalf("segment is not nested in its site: %q -> %q", vrf, parents[vrf])
+	}
+	if !strings.Contains(string(raw), "users &amp;lt;&amp;#34;a&amp;#34;&amp;gt;") {
+		t.Fatalf("label not escaped: %s", raw)
+	}
+
+	dot := renderDOT("Default", diagram)
+	for _, want := range []string{`label="VRF default"`, `label="users <\"a\">\nVLAN 10\n10.0.0.0/26"`, `"no segments"`, `label="lab\nVLAN 30\nnot allocated"`} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("dot missing %s:\n%s", want, dot)
+		}
+	}
+}
+
+func TestLinkSegments(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "links.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, kind) VALUES(?, '10.255.0.0/29', 'link')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family, kind) VALUES(?, '2001:db8:ff::/120', 'ipv6', 'link')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 50)`, siteID)
+	usersID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, prefix_v6, kind, link_a, link_b) VALUES(?, 'PROD', 901, 'core-edge', 31, 127, 'link', 'core1:Gi0/1', 'edge1:xe-0/0/0')`, siteID)
+	coreID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, kind) VALUES(?, 'PROD', 902, 'isp', 30, 'link')`, siteID)
+	ispID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, kind) VALUES(?, 'PROD', 903, 'dc', 31, 'link')`, siteID)
+	dcID, _ := res.LastInsertId()
+
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	for id, want := range map[int64]string{usersID: "10.0.0.0/26", coreID: "10.255.0.0/31", ispID: "10.255.0.4/30", dcID: "10.255.0.2/31"} {
+		s, _ := segmentByID(db, id)
+		if s.CIDR.String != want {
+			t.Fatalf("segment %s: got %q want %s", s.Name, s.CIDR.String, want)
+		}
+	}
+	if core, _ := segmentByID(db, coreID); core.CIDRV6.String != "2001:db8:ff::/127" {
+		t.Fatalf("core v6: %q", core.CIDRV6.String)
+	}
+
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "cisco", IncludeVLAN: true, Family: "dual"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"interface Gi0/1",
+		"ip address 10.255.0.0 255.255.255.254",
+		"interface xe-0/0/0",
+		"ip address 10.255.0.1 255.255.255.254",
+		"ipv6 address 2001:db8:ff::1/127",
+		"interface Vlan902",
+		"ip address 10.255.0.5 255.255.255.252",
+		"ip address 10.255.0.6 255.255.255.252",
+	} {
+		if !strings.Contains(out.Output, want) {
+			t.Fatalf("generate missing %q:\n%s", want, out.Output)
+		}
+	}
+	if strings.Contains(out.Output, "vlan 901\n") {
+		t.Fatalf("link rendered as a VLAN:\n%s", out.Output)
+	}
+
+	// A full link pool fails the allocation instead of spilling into the LAN pool.
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, kind) VALUES(?, 'PROD', 904, 'wan', 30, 'link')`, siteID)
+	if err := allocateProject(db, projectID, nil); err == nil || !strings.Contains(err.Error(), "link pools are full") {
+		t.Fatalf("expected link pool error, got %v", err)
+	}
+
+	if a, b, ok := linkEndAddrs(netip.MustParsePrefix("192.0.2.8/30")); !ok || a.String() != "192.0.2.9" || b.String() != "192.0.2.10" {
+		t.Fatalf("linkEndAddrs /30: %s %s %v", a, b, ok)
+	}
+	if _, _, err := linkSizes(sql.NullInt64{Int64: 2, Valid: true}, sql.NullInt64{}, sql.NullInt64{}); err == nil {
+		t.Fatalf("expected hosts to be rejected")
+	}
+	if _, _, err := linkSizes(sql.NullInt64{}, sql.NullInt64{Int64: 29, Valid: true}, sql.NullInt64{}); err == nil {
+		t.Fatalf("expected /29 to be rejected")
+	}
+}
+
+func TestDeviceLoopbacks(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "devices.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, bgp_asn) VALUES(?, 65001)`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, kind) VALUES(?, '10.255.255.0/30', 'loopback')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, family, kind) VALUES(?, '2001:db8:ffff::/64', 'ipv6', 'loopback')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 10, 'users', 24)`, siteID)
+	usersID, _ := res.LastInsertId()
+
+	core1, err := saveDevice(db, projectID, Device{SiteID: siteID, Name: "core1", Role: "core"})
+	if err != nil {
+		t.Fatalf("save core1: %v", err)
+	}
+	core2, err := saveDevice(db, projectID, Device{SiteID: siteID, Name: "core2", Role: "core"})
+	if err != nil {
+		t.Fatalf("save core2: %v", err)
+	}
+	if d, _ := deviceByID(db, core2); d.Loopback.String != "10.255.255.2" || d.LoopbackV6.String != "2001:db8:ffff::2" {
+		t.Fatalf("core2 loopbacks: %+v", d)
+	}
+	// The /30 has two host addresses; a third device does not fit.
+	if _, err := saveDevice(db, projectID, Device{SiteID: siteID, Name: "edge1"}); err == nil || !strings.Contains(err.Error(), "are full") {
+		t.Fatalf("expected full loopback pool, got %v", err)
+	}
+	if err := deleteDevice(db, core1); err != nil {
+		t.Fatalf("delete core1: %v", err)
+	}
+	edge1, err := saveDevice(db, projectID, Device{SiteID: siteID, Name: "edge1", Role: "edge"})
+	if err != nil {
+		t.Fatalf("save edge1: %v", err)
+	}
+
+	// Allocation leaves loopback pools to devices and keeps their addresses.
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if users, _ := segmentByID(db, usersID); users.CIDR.String != "10.0.0.0/24" {
+		t.Fatalf("users: %q", users.CIDR.String)
+	}
+	if d, _ := deviceByID(db, edge1); d.Loopback.String != "10.255.255.1" {
+		t.Fatalf("edge1 loopback: %q", d.Loopback.String)
+	}
+	if d, _ := deviceByID(db, core2); d.Loopback.String != "10.255.255.2" {
+		t.Fatalf("core2 moved: %q", d.Loopback.String)
+	}
+
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "cisco", IncludeVLAN: true, Family: "dual"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"! Device core2 (HQ)",
+		" ip address 10.255.255.2 255.255.255.255",
+		" ipv6 address 2001:db8:ffff::1/128",
+		" bgp router-id 10.255.255.1",
+		"  update-source Loopback0",
+	} {
+		if !strings.Contains(out.Output, want) {
+			t.Fatalf("generate missing %q:\n%s", want, out.Output)
+		}
+	}
+	if out.Metadata.DeviceCount != 2 {
+		t.Fatalf("device count: %d", out.Metadata.DeviceCount)
+	}
+
+	devices, _ := listDevices(db, projectID)
+	csvOut, _, err := renderDeviceInventory(devices, "csv")
+	if err != nil || !strings.Contains(csvOut, "HQ,core2,core,10.255.255.2,2001:db8:ffff::2,") {
+		t.Fatalf("inventory csv: %v\n%s", err, csvOut)
+	}
+}
+
+func TestNATMappings(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "nat.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, kind) VALUES(?, '203.0.113.0/28', 'nat')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 10, 'users', 25)`, siteID)
+	usersID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 20, 'servers', 29)`, siteID)
+	serversID, _ := res.LastInsertId()
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	if servers, _ := segmentByID(db, serversID); servers.CIDR.String != "10.0.0.128/29" {
+		t.Fatalf("servers: %q", servers.CIDR.String)
+	}
+
+	valid := []NATMapping{
+		{SegmentID: usersID, Type: "overload", PublicCIDR: "203.0.113.1"},
+		{SegmentID: serversID, Type: "static", PublicCIDR: "203.0.113.8/29"},
+		{SegmentID: serversID, Type: "port", PublicCIDR: "203.0.113.1", Protocol: "tcp", PublicPort: 443, PrivateAddr: "10.0.0.130", PrivatePort: 8443},
+	}
+	for _, m := range valid {
+		if _, err := saveNATMapping(db, projectID, m); err != nil {
+			t.Fatalf("save %s %s: %v", m.Type, m.PublicCIDR, err)
+		}
+	}
+	invalid := map[string]NATMapping{
+		"match":             {SegmentID: serversID, Type: "static", PublicCIDR: "203.0.113.4/30"},
+		"static mapping":    {SegmentID: usersID, Type: "overload", PublicCIDR: "203.0.113.9"},
+		"already forwarded": {SegmentID: usersID, Type: "port", PublicCIDR: "203.0.113.1", Protocol: "tcp", PublicPort: 443, PrivateAddr: "10.0.0.10", PrivatePort: 443},
+		"outside":           {SegmentID: usersID, Type: "port", PublicCIDR: "203.0.113.2", Protocol: "udp", PublicPort: 53, PrivateAddr: "10.0.0.200", PrivatePort: 53},
+		"not in a NAT pool": {SegmentID: usersID, Type: "overload", PublicCIDR: "198.51.100.1"},
+		"overlaps segment":  {SegmentID: usersID, Type: "overload", PublicCIDR: "10.0.0.5"},
+	}
+	for want, m := range invalid {
+		if _, err := saveNATMapping(db, projectID, m); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	}
+
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "cisco"})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, want := range []string{
+		"ip nat pool nat-hq-users-1 203.0.113.1 203.0.113.1 prefix-length 32",
+		"ip nat inside source list nat-hq-users-1 pool nat-hq-users-1 overload",
+		"ip nat inside source static network 10.0.0.128 203.0.113.8 /29",
+		"ip nat inside source static tcp 10.0.0.130 8443 203.0.113.1 443",
+	} {
+		if !strings.Contains(out.Output, want) {
+			t.Fatalf("generate missing %q:\n%s", want, out.Output)
+		}
+	}
+
+	// Growing the segment breaks its static mapping: it becomes a conflict and
+	// is no longer rendered.
+	_, _ = db.Exec(`UPDATE segments SET prefix=28 WHERE id=?`, serversID)
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("reallocate: %v", err)
+	}
+	sites, _ := listSites(db, projectID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	_, conflicts := analyzeAll(segs, pools, sites, ProjectRules{})
+	found := false
+	for _, c := range conflicts {
+		if c.Kind == "NAT_MAPPING" && strings.Contains(c.Detail, "needs a /28") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected NAT_MAPPING conflict: %+v", conflicts)
+	}
+	out, _ = generateForProject(db, projectID, GenerateOptions{Template: "mikrotik"})
+	if strings.Contains(out.Output, "netmap") || !strings.Contains(out.Output, "action=src-nat to-addresses=203.0.113.1 ") {
+		t.Fatalf("mikrotik NAT output:\n%s", out.Output)
+	}
+}
+
+func TestPoolTiers(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "tiers.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, tier) VALUES(?, '10.0.0.0/16', 'Edge')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr, tier) VALUES(?, '10.1.0.0/16', 'core')`, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.2.0.0/16')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 10, 'users', 24)`, siteID)
+	usersID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, pool_tier) VALUES(?, 'core')`, usersID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'PROD', 20, 'lab', 24, NULL)`, siteID)
+
+	// Without tiers any value is accepted.
+	if err := checkPoolTierReference(db, projectID, "gold"); err != nil {
+		t.Fatalf("free-form tier: %v", err)
+	}
+	edge, err := savePoolTier(db, projectID, PoolTier{Name: " EDGE ", Description: "branch uplinks"})
+	if err != nil {
+		t.Fatalf("save edge: %v", err)
+	}
+	core, err := savePoolTier(db, projectID, PoolTier{Name: "core"})
+	if err != nil {
+		t.Fatalf("save core: %v", err)
+	}
+	if _, err := savePoolTier(db, projectID, PoolTier{Name: "tier:x"}); err == nil {
+		t.Fatalf("expected invalid tier name error")
+	}
+	if err := movePoolTier(db, projectID, core, -1); err != nil {
+		t.Fatalf("move core: %v", err)
+	}
+	tiers, _ := listPoolTiers(db, projectID)
+	if len(tiers) != 2 || tiers[0].Name != "core" || tiers[1].Name != "edge" || tiers[1].Position != 2 {
+		t.Fatalf("tier order: %+v", tiers)
+	}
+
+	if err := checkPoolTierReference(db, projectID, "Core"); err != nil {
+		t.Fatalf("core reference: %v", err)
+	}
+	if err := checkPoolTierReference(db, projectID, "gold"); err == nil || !strings.Contains(err.Error(), "defined: core, edge") {
+		t.Fatalf("expected unknown tier error, got %v", err)
+	}
+
+	pools, _ := listPools(db, projectID)
+	grouped, headers := groupPoolsByTier(pools, tiers)
+	if grouped[0].CIDR != "10.1.0.0/16" || grouped[1].CIDR != "10.0.0.0/16" || grouped[2].CIDR != "10.2.0.0/16" {
+		t.Fatalf("pool grouping: %+v", grouped)
+	}
+	if len(headers) != 3 || headers[1].Description != "branch uplinks" || headers[2].Label() != "no tier" {
+		t.Fatalf("pool headers: %+v", headers)
+	}
+
+	segs, _ := listSegments(db, projectID)
+	groups := buildTierGroups(tiers, pools, segs)
+	if len(groups) != 3 || groups[0].Name != "core" || len(groups[0].Segments) != 1 || len(groups[1].Segments) != 0 || groups[2].Name != "" || len(groups[2].Segments) != 1 {
+		t.Fatalf("tier groups: %+v", groups)
+	}
+
+	if err := deletePoolTier(db, projectID, edge); err == nil || !strings.Contains(err.Error(), "in use by 1 pools") {
+		t.Fatalf("expected tier in use error, got %v", err)
+	}
+	_, _ = db.Exec(`UPDATE pools SET tier=NULL WHERE tier='Edge'`)
+	if err := deletePoolTier(db, projectID, edge); err != nil {
+		t.Fatalf("delete edge: %v", err)
+	}
+	if tiers, _ := listPoolTiers(db, projectID); len(tiers) != 1 || tiers[0].Position != 1 {
+		t.Fatalf("tiers after delete: %+v", tiers)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LISTEN_ADDR", "")
+	t.Setenv("TEMPLATE_DIR", "")
+	defer func() { _ = loadConfig("") }()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "subnetio.yaml")
+	write := func(body string) {
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+	}
+
+	write("server:\n  listen_addr: 127.0.0.1:9000\n  idempotency_ttl: 1h\ntemplates:\n  dir: /srv/templates\ntls:\n  acme_domains:\n    - a.example\n    - b.example\nsecrets:\n  vault:\n    token: s3cret\n")
+	if err := loadConfig(path); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if got := mustEnv("LISTEN_ADDR", "0.0.0.0:8080"); got != "127.0.0.1:9000" {
+		t.Fatalf("listen addr from file: %q", got)
+	}
+	if got := customTemplateDir(); got != "/srv/templates" {
+		t.Fatalf("template dir: %q", got)
+	}
+	if got := mustEnv("ACME_DOMAINS", ""); got != "a.example,b.example" {
+		t.Fatalf("list setting: %q", got)
+	}
+	t.Setenv("LISTEN_ADDR", "0.0.0.0:7000")
+	if got := mustEnv("LISTEN_ADDR", ""); got != "0.0.0.0:7000" {
+		t.Fatalf("env override: %q", got)
+	}
+	var out bytes.Buffer
+	writeEffectiveConfig(&out)
+	if !strings.Contains(out.String(), "0.0.0.0:7000 (env)") || !strings.Contains(out.String(), "/srv/templates (file)") || strings.Contains(out.String(), "s3cret") {
+		t.Fatalf("effective config:\n%s", out.String())
+	}
+
+	write("server:\n  listn_addr: 127.0.0.1:9000\n  idempotency_ttl: soon\n")
+	err := loadConfig(path)
+	if err == nil || !strings.Contains(err.Error(), ":2: unknown setting server.listn_addr (did you mean server.listen_addr?)") {
+		t.Fatalf("expected unknown key error, got %v", err)
+	}
+	write("server:\n  idempotency_ttl: soon\n")
+	if err := loadConfig(path); err == nil || !strings.Contains(err.Error(), ":2: server.idempotency_ttl: invalid duration") {
+		t.Fatalf("expected duration error, got %v", err)
+	}
+	write("server:\n  listen_addr: [a, b]\n")
+	if err := loadConfig(path); err == nil || !strings.Contains(err.Error(), "expected a single value") {
+		t.Fatalf("expected list error, got %v", err)
+	}
+
+	t.Setenv("HSTS_MAX_AGE", "forever")
+	if err := runCLI([]string{"--config", filepath.Join(dir, "missing.yaml"), "config"}, &out); err == nil {
+		t.Fatalf("expected missing config file error")
+	}
+	if err := runCLI([]string{"config"}, &out); err == nil || !strings.Contains(err.Error(), "environment variable HSTS_MAX_AGE: invalid number") {
+		t.Fatalf("expected env validation error, got %v", err)
+	}
+}
+
+func TestJSONSchemas(t *testing.T) {
+	for _, doc := range jsonSchemaDocs {
+		schema := buildJSONSchema("http://x/schemas/"+doc.Name+".json", doc.Title, doc.Type)
+		raw, err := json.Marshal(schema)
+		if err != nil {
+			t.Fatalf("%s: %v", doc.Name, err)
+		}
+		var parsed struct {
+			Ref  string                     `json:"$ref"`
+			Defs map[string]json.RawMessage `json:"$defs"`
+		}
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			t.Fatalf("%s: %v", doc.Name, err)
+		}
+		name := strings.TrimPrefix(parsed.Ref, "#/$defs/")
+		if _, ok := parsed.Defs[name]; !ok {
+			t.Fatalf("%s: root %q not in $defs", doc.Name, parsed.Ref)
+		}
+	}
+
+	type property struct {
+		Type any      `json:"type"`
+		Enum []string `json:"enum"`
+		Ref  string   `json:"$ref"`
+	}
+	type object struct {
+		Properties           map[string]property `json:"properties"`
+		Required             []string            `json:"required"`
+		AdditionalProperties *bool               `json:"additionalProperties"`
+	}
+	defsOf := func(name string) map[string]object {
+		_, typ, ok := jsonSchemaDocByName(name)
+		if !ok {
+			t.Fatalf("schema %s not found", name)
+		}
+		raw, _ := json.Marshal(buildJSONSchema("", name, typ))
+		var parsed struct {
+			Defs map[string]object `json:"$defs"`
+		}
+		if err := json.Unmarshal(raw, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Defs
+	}
+
+	plan := defsOf("plan")
+	bundle := plan["PlanBundle"]
+	if !slices.Contains(bundle.Required, "schema_version") || !slices.Contains(bundle.Required, "rows") {
+		t.Fatalf("PlanBundle required = %v", bundle.Required)
+	}
+	if bundle.AdditionalProperties == nil || *bundle.AdditionalProperties {
+		t.Fatalf("PlanBundle must reject unknown properties")
+	}
+	row := plan["PlanRow"]
+	if !slices.Contains(row.Properties["row_type"].Enum, planRowSegment) {
+		t.Fatalf("row_type enum = %v", row.Properties["row_type"].Enum)
+	}
+	if !slices.Contains(row.Properties["pool_kind"].Enum, PoolKindNAT) {
+		t.Fatalf("pool_kind enum = %v", row.Properties["pool_kind"].Enum)
+	}
+	if fmt.Sprint(row.Properties["dhcp"].Type) != "[boolean null]" {
+		t.Fatalf("dhcp type = %v", row.Properties["dhcp"].Type)
+	}
+	if slices.Contains(row.Required, "cidr") {
+		t.Fatalf("optional cidr marked required")
+	}
+
+	if _, ok := defsOf("defaults")["DefaultsBundle"]; !ok {
+		t.Fatalf("defaults schema lacks DefaultsBundle")
+	}
+	if _, ok := defsOf("export")["ExportBundle"]; !ok {
+		t.Fatalf("export schema lacks ExportBundle")
+	}
+	if _, _, ok := jsonSchemaDocByName("missing"); ok {
+		t.Fatalf("unknown schema name found")
+	}
+}
+
+func TestWhatIfAPI(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "whatif.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	res, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.0.0.0/24')`, siteID)
+	poolID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix) VALUES(?, 'PROD', 10, 'users', 25)`, siteID)
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+
+	out, err := whatIfAPI(db, projectID, whatIfAPIRequest{
+		Segment: &whatIfAPISegment{Site: "hq", VRF: "PROD", VLAN: 20, Name: "voice", Prefix: 26},
+		Segments: []whatIfAPISegment{
+			{SiteID: siteID, VRF: "PROD", VLAN: 30, Name: "cameras", Prefix: 23},
+		},
+	})
+	if err != nil {
+		t.Fatalf("whatif: %v", err)
+	}
+	if len(out.Segments) != 2 {
+		t.Fatalf("segments: %+v", out.Segments)
+	}
+	voice, cameras := out.Segments[0], out.Segments[1]
+	if !voice.Allocated || voice.CIDR != "10.0.0.128/26" || voice.Pool == nil || voice.Pool.ID != poolID {
+		t.Fatalf("voice: %+v", voice)
+	}
+	if cameras.Allocated || len(cameras.Conflicts) == 0 || cameras.Conflicts[0].Kind != "ALLOCATE_FAIL" {
+		t.Fatalf("cameras: %+v", cameras)
+	}
+	if len(out.Changes) != 0 || len(out.Unallocated) != 0 {
+		t.Fatalf("existing segments moved: %+v %+v", out.Changes, out.Unallocated)
+	}
+	foundNew := false
+	for _, c := range out.NewConflicts {
+		if c.ID == cameras.Conflicts[0].ID {
+			foundNew = true
+		}
+	}
+	if !foundNew {
+		t.Fatalf("new conflicts: %+v", out.NewConflicts)
+	}
+	if out.Utilization.IPv4.Delta != "+64" || len(out.Utilization.Pools) != 1 || out.Utilization.Pools[0].UtilizationAfter != "75.0%" {
+		t.Fatalf("utilization: %+v", out.Utilization)
+	}
+	var count int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM segments`).Scan(&count)
+	if count != 1 {
+		t.Fatalf("what-if wrote segments: %d", count)
+	}
+
+	bad := map[string]whatIfAPIRequest{
+		"empty":     {},
+		"site":      {Segment: &whatIfAPISegment{Site: "nope", VRF: "PROD", VLAN: 20, Name: "x", Prefix: 26}},
+		"size":      {Segment: &whatIfAPISegment{Site: "HQ", VRF: "PROD", VLAN: 20, Name: "x"}},
+		"vlan":      {Segment: &whatIfAPISegment{Site: "HQ", VRF: "PROD", VLAN: 5000, Name: "x", Prefix: 26}},
+		"link size": {Segment: &whatIfAPISegment{Site: "HQ", VRF: "PROD", VLAN: 20, Name: "x", Kind: "link", Prefix: 29}},
+	}
+	for name, req := range bad {
+		if _, err := whatIfAPI(db, projectID, req); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLiveEvents(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "events.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	record := func(action, label string) {
+		if err := insertAuditRecord(db, auditRecord{ProjectID: projectID, Actor: "alice", Action: action, EntityType: "segment", EntityLabel: sql.NullString{String: label, Valid: true}}); err != nil {
+			t.Fatalf("audit: %v", err)
+		}
+	}
+	record("create", "before-connect")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/events", func(c *gin.Context) { streamProjectEvents(c, db, projectID) })
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	read := func(lastEventID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("content type %q", ct)
+		}
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	nextEvent := func(br *bufio.Reader) (string, LiveEvent) {
+		var id string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+			if v, ok := strings.CutPrefix(line, "id: "); ok {
+				id = v
+			}
+			if v, ok := strings.CutPrefix(line, "data: "); ok {
+				var ev LiveEvent
+				if err := json.Unmarshal([]byte(v), &ev); err != nil {
+					t.Fatalf("data %q: %v", v, err)
+				}
+				return id, ev
+			}
+		}
+	}
+
+	// A new client starts at the end of the log and is woken by new entries.
+	br, closeStream := read("")
+	line, _ := br.ReadString('\n')
+	if !strings.HasPrefix(line, "retry:") {
+		t.Fatalf("first line %q", line)
+	}
+	record("allocate", "users")
+	id, ev := nextEvent(br)
+	if ev.Action != "allocate" || ev.EntityLabel != "users" || ev.Actor != "alice" || id != itoa64(ev.ID) {
+		t.Fatalf("event %s %+v", id, ev)
+	}
+	closeStream()
+
+	// A reconnecting client gets what it missed.
+	br, closeStream = read("0")
+	defer closeStream()
+	if _, ev := nextEvent(br); ev.EntityLabel != "before-connect" {
+		t.Fatalf("replay %+v", ev)
+	}
+	if _, ev := nextEvent(br); ev.EntityLabel != "users" {
+		t.Fatalf("replay %+v", ev)
+	}
+}
+
+func TestDHCPScopeExport(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "dhcp.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	_, _ = db.Exec(`INSERT INTO project_meta(project_id, domain_name, dhcp_lease_time, dhcp_boot_file) VALUES(?, 'corp.example', 86400, 'pxe.efi')`, projectID)
+	var siteIDs []int64
+	for _, name := range []string{"ALA", "AST"} {
+		res, _ := db.Exec(`INSERT INTO sites(name) VALUES(?)`, name)
+		id, _ := res.LastInsertId()
+		siteIDs = append(siteIDs, id)
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, id)
+	}
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, dhcp_lease_time) VALUES(?, 3600)`, siteIDs[0])
+	insert := func(siteID int64, vlan int, name, cidr string, dhcp bool, tags string) int64 {
+		res, _ := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', ?, ?, ?, 1)`, siteID, vlan, name, cidr)
+		id, _ := res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, tags) VALUES(?, ?, ?)`, id, dhcp, nullStringToAny(tags))
+		return id
+	}
+	voiceID := insert(siteIDs[0], 20, "voice", "10.1.20.0/24", true, "voip")
+	insert(siteIDs[0], 30, "servers", "10.1.30.0/24", false, "")
+	insert(siteIDs[1], 10, "users", "10.2.10.0/24", true, "")
+	if _, err := saveDHCPPolicy(db, DHCPPolicy{ProjectID: projectID, Tag: "voip", DhcpRenewTime: sql.NullInt64{Int64: 600, Valid: true}, DhcpVendorOpts: sql.NullString{String: "option tftp-server-name \"10.0.0.5\";", Valid: true}}); err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+	if _, err := saveMACReservation(db, MACReservation{SegmentID: voiceID, MAC: "00:0c:42:aa:bb:01", IP: "10.1.20.50", Hostname: "phone-1"}); err != nil {
+		t.Fatalf("mac: %v", err)
+	}
+
+	scopes, err := buildDHCPScopes(db, projectID, PlanScope{})
+	if err != nil {
+		t.Fatalf("scopes: %v", err)
+	}
+	if len(scopes) != 2 {
+		t.Fatalf("want 2 DHCP scopes, got %+v", scopes)
+	}
+	voice, users := scopes[0], scopes[1]
+	if voice.Name != "voice" || voice.RangeStart == "" || voice.Gateway != "10.1.20.1" {
+		t.Fatalf("voice: %+v", voice)
+	}
+	// Project boot file, site lease time, policy renew time and vendor option.
+	if voice.Options.BootFile != "pxe.efi" || voice.Options.LeaseTime != 3600 || voice.Options.RenewTime != 600 ||
+		len(voice.Options.VendorOptions) != 1 || strings.Join(voice.Policies, ",") != "voip" {
+		t.Fatalf("voice options: %+v %v", voice.Options, voice.Policies)
+	}
+	if len(voice.MACReservations) != 1 || voice.MACReservations[0].IP != "10.1.20.50" {
+		t.Fatalf("voice reservations: %+v", voice.MACReservations)
+	}
+	if users.Options.LeaseTime != 86400 || users.Options.RenewTime != 0 || strings.Join(users.Options.Search, ",") != "corp.example" {
+		t.Fatalf("users options: %+v", users.Options)
+	}
+
+	scoped, _ := buildDHCPScopes(db, projectID, PlanScope{Site: "ast"})
+	if len(scoped) != 1 || scoped[0].Name != "users" {
+		t.Fatalf("scoped: %+v", scoped)
+	}
+
+	out, ext, err := renderDHCPScopes(scopes, "csv")
+	if err != nil || ext != "csv" {
+		t.Fatalf("csv: %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil || len(records) != 3 || records[0][0] != "site" || records[1][16] != "3600" {
+		t.Fatalf("csv rows: %v %q", err, records)
+	}
+	if _, _, err := renderDHCPScopes(scopes, "xml"); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+func TestDHCPEffectiveSettings(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "dhcpfx.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	_, _ = db.Exec(`INSERT INTO project_meta(project_id, domain_name, dhcp_lease_time, dhcp_boot_file, dhcp_vendor_options) VALUES(?, 'corp.example', 86400, 'pxe.efi', 'option a 1;')`, projectID)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('ALA')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO site_meta(site_id, dhcp_lease_time, dhcp_vendor_options) VALUES(?, 3600, 'option b 2;')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr, locked) VALUES(?, 'PROD', 20, 'voice', '10.1.20.0/24', 1)`, siteID)
+	segID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, tags, gateway) VALUES(?, 1, 'voip', '10.1.20.254')`, segID)
+	if _, err := saveDHCPPolicy(db, DHCPPolicy{ProjectID: projectID, Tag: "voip", DhcpRenewTime: sql.NullInt64{Int64: 600, Valid: true}, DhcpVendorOpts: sql.NullString{String: "option c 3;", Valid: true}}); err != nil {
+		t.Fatalf("policy: %v", err)
+	}
+
+	report, err := buildDHCPSettingsReport(db, projectID, 0, segID, false)
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if len(report.Sites) != 1 || len(report.Sites[0].Segments) != 1 {
+		t.Fatalf("report: %+v", report)
+	}
+	setting := func(list []DHCPEffectiveSetting, field string) DHCPEffectiveSetting {
+		for _, s := range list {
+			if s.Field == field {
+				return s
+			}
+		}
+		t.Fatalf("missing field %s", field)
+		return DHCPEffectiveSetting{}
+	}
+	site := report.Sites[0].Settings
+	if s := setting(site, "lease_time"); s.Value != "3600" || s.Source != "site" || !s.Overridden() || len(s.Layers) != 2 {
+		t.Fatalf("site lease: %+v", s)
+	}
+	if s := setting(site, "boot_file"); s.Value != "pxe.efi" || s.Source != "project" || s.Overridden() {
+		t.Fatalf("site boot file: %+v", s)
+	}
+	if s := setting(site, "search"); s.Value != "corp.example" || s.Source != "domain" {
+		t.Fatalf("site search: %+v", s)
+	}
+	seg := report.Sites[0].Segments[0].Settings
+	if s := setting(seg, "renew_time"); s.Value != "600" || s.Source != "policy:voip" {
+		t.Fatalf("segment renew: %+v", s)
+	}
+	// The site replaces the project's vendor lines, the policy adds to them.
+	if s := setting(seg, "vendor_options"); s.Value != "option b 2;\noption c 3;" || s.Source != "site, policy:voip" || !s.Overridden() {
+		t.Fatalf("segment vendor options: %+v", s)
+	}
+	if s := setting(seg, "gateway"); s.Value != "10.1.20.254" || s.Source != "segment" {
+		t.Fatalf("segment gateway: %+v", s)
+	}
+	if s := setting(seg, "dhcp_range"); s.Value == "" || s.Source != "auto" {
+		t.Fatalf("segment range: %+v", s)
+	}
+
+	// The effective values match the merge the generators use.
+	meta, _ := getProjectMeta(db, projectID)
+	sites, _ := listSites(db, projectID)
+	policies, _ := listDHCPPolicies(db, projectID)
+	merged := applyDHCPPolicies(applySiteDHCPOverrides(projectDHCPDefaults(meta, "corp.example"), sites[0], "corp.example"), policies, "corp.example")
+	if setting(seg, "lease_time").Value != itoa(merged.LeaseTime) || setting(seg, "vendor_options").Value != strings.Join(merged.VendorOptions, "\n") ||
+		setting(seg, "search").Value != strings.Join(merged.Search, ",") {
+		t.Fatalf("merge mismatch: %+v vs %+v", seg, merged)
+	}
+
+	if _, err := buildDHCPSettingsReport(db, projectID, siteID+100, 0, false); err == nil {
+		t.Fatalf("expected site not found")
+	}
+}
+
+func TestSegmentLocks(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "locks.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	raw := []byte(`{"schema_version":"2","rows":[
+		{"row_type":"meta","schema_version":"2"},
+		{"row_type":"rules","vlan_scope":"site","require_in_pool":false,"allow_reserved_overlap":false,"oversize_threshold":0},
+		{"row_type":"site","site":"HQ"},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":10,"name":"users","cidr":"10.0.0.0/26","locked":true,"dhcp":false,"notes":"core"}
+	]}`)
+	if report := importPlanData(db, raw, "json", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("import: %v", report.Errors)
+	}
+	segs, _ := listSegments(db, projectID)
+	if len(segs) != 1 || !segs[0].Locked {
+		t.Fatalf("segments: %+v", segs)
+	}
+	seg := segs[0]
+
+	if _, err := checkSegmentLock(seg, "deleting it", nil, segmentForce{}); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Fatalf("delete without force: %v", err)
+	}
+	if _, err := checkSegmentLock(seg, "deleting it", nil, segmentForce{Force: true}); !errors.Is(err, errSegmentForceReason) {
+		t.Fatalf("force without reason: %v", err)
+	}
+	if forced, err := checkSegmentLock(seg, "deleting it", nil, segmentForce{Force: true, Reason: "decommissioned"}); err != nil || !forced {
+		t.Fatalf("forced delete: %v %v", forced, err)
+	}
+
+	// Notes and tags stay editable; the gateway is not.
+	notes := "moved to rack 4"
+	edited := segmentMetaPatch{Notes: optionalField[string]{Set: true, Value: &notes}}.applyTo(seg)
+	if forced, err := checkSegmentLock(seg, "editing it", segmentChanges(seg, edited), segmentForce{}); err != nil || forced {
+		t.Fatalf("notes edit: %v %v", forced, err)
+	}
+	gw := "10.0.0.62"
+	edited = segmentMetaPatch{Gateway: optionalField[string]{Set: true, Value: &gw}}.applyTo(seg)
+	if _, err := checkSegmentLock(seg, "editing it", segmentChanges(seg, edited), segmentForce{}); err == nil || !strings.Contains(err.Error(), "gateway") {
+		t.Fatalf("gateway edit: %v", err)
+	}
+	unlock := false
+	if changes := segmentChanges(seg, segmentPatch{Locked: optionalField[bool]{Set: true, Value: &unlock}}.applyTo(seg)); strings.Join(changes, ",") != "locked" {
+		t.Fatalf("unlock changes: %v", changes)
+	}
+
+	// A plan import may not move a locked segment unless forced.
+	moved := bytes.Replace(raw, []byte(`"10.0.0.0/26"`), []byte(`"10.0.0.64/26"`), 1)
+	report := importPlanData(db, moved, "json", projectID, ImportPolicy{})
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "cidr") {
+		t.Fatalf("locked import: %v", report.Errors)
+	}
+	if s, _ := segmentByID(db, seg.ID); s.CIDR.String != "10.0.0.0/26" {
+		t.Fatalf("locked segment changed: %s", s.CIDR.String)
+	}
+	report = importPlanData(db, moved, "json", projectID, ImportPolicy{ForceLocked: true})
+	if len(report.Errors) != 0 || report.Policy != "overwrite,force-locked" {
+		t.Fatalf("forced import: %v %s", report.Errors, report.Policy)
+	}
+	if s, _ := segmentByID(db, seg.ID); s.CIDR.String != "10.0.0.64/26" {
+		t.Fatalf("forced import not applied: %s", s.CIDR.String)
+	}
+	noteOnly := bytes.Replace(moved, []byte(`"core"`), []byte(`"edge"`), 1)
+	if report := importPlanData(db, noteOnly, "json", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("notes import: %v", report.Errors)
+	}
+
+	// Expiry does not release a locked segment.
+	_, _ = db.Exec(`UPDATE segments SET expires_at='2026-01-01T00:00:00Z', expiry_action='release' WHERE id=?`, seg.ID)
+	if n, err := expireSegments(db, time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)); err != nil || n != 1 {
+		t.Fatalf("expire: %d %v", n, err)
+	}
+	if s, ok := segmentByID(db, seg.ID); !ok || !s.ExpiredAt.Valid {
+		t.Fatalf("locked segment released: %+v %v", s, ok)
+	}
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/segments/delete?force=1", strings.NewReader("reason=cleanup"))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if f := segmentForceFromRequest(c); !f.Force || f.Reason != "cleanup" || f.auditReason().String != "locked segment override: cleanup" {
+		t.Fatalf("force from request: %+v", f)
+	}
+}
+
+func TestProjectQuotas(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "quota.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	if err := saveProjectQuota(db, ProjectQuota{ProjectID: projectID, MaxSegments: 3, MaxIPv4Addresses: 256, MaxIPv6Prefix: 64}); err != nil {
+		t.Fatalf("save quota: %v", err)
+	}
+	if err := saveProjectQuota(db, ProjectQuota{ProjectID: projectID, MaxIPv6Prefix: 129}); err == nil {
+		t.Fatalf("expected invalid IPv6 quota")
+	}
+
+	raw := []byte(`{"schema_version":"2","rows":[
+		{"row_type":"meta","schema_version":"2"},
+		{"row_type":"rules","vlan_scope":"site","require_in_pool":false,"allow_reserved_overlap":false,"oversize_threshold":0},
+		{"row_type":"site","site":"HQ"},
+		{"row_type":"pool","site":"HQ","pool":"10.0.0.0/24"},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":10,"name":"users","cidr":"10.0.0.0/25","locked":true},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":20,"name":"voice","cidr":"10.0.0.128/26","cidr_v6":"2001:db8::/64","locked":true},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":30,"name":"big","cidr":"10.0.1.0/24","locked":true},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":40,"name":"cams","hosts":50,"locked":false}
+	]}`)
+	report := importPlanData(db, raw, "json", projectID, ImportPolicy{})
+	if len(report.Errors) != 1 || !strings.Contains(report.Errors[0], "IPv4 addresses (limit 256)") {
+		t.Fatalf("import errors: %v", report.Errors)
+	}
+	usage, _ := projectQuotaUsage(db, projectID)
+	if usage.Segments != 3 || usage.IPv4.Int64() != 192 || usage.IPv6.Cmp(new(big.Int).Lsh(big.NewInt(1), 64)) != 0 {
+		t.Fatalf("usage: %d %s %s", usage.Segments, usage.IPv4, usage.IPv6)
+	}
+	if err := checkSegmentQuota(db, projectID, 1); !isQuotaError(err) || !strings.Contains(err.Error(), "4 segments (limit 3)") {
+		t.Fatalf("segment quota: %v", err)
+	}
+
+	// Allocating cams (/26) would bring IPv4 to 256 (fits); with a lower
+	// limit the allocation is rolled back.
+	if err := saveProjectQuota(db, ProjectQuota{ProjectID: projectID, MaxSegments: 3, MaxIPv4Addresses: 200}); err != nil {
+		t.Fatalf("lower quota: %v", err)
+	}
+	if err := allocateProject(db, projectID, nil); !isQuotaError(err) {
+		t.Fatalf("allocate over quota: %v", err)
+	}
+	if after, _ := projectQuotaUsage(db, projectID); after.IPv4.Int64() != 192 {
+		t.Fatalf("allocation not rolled back: %s", after.IPv4)
+	}
+	if err := saveProjectQuota(db, ProjectQuota{ProjectID: projectID, MaxSegments: 3, MaxIPv4Addresses: 256}); err != nil {
+		t.Fatalf("raise quota: %v", err)
+	}
+	if err := allocateProject(db, projectID, nil); err != nil {
+		t.Fatalf("allocate within quota: %v", err)
+	}
+
+	// Clearing every limit removes the quota.
+	if err := saveProjectQuota(db, ProjectQuota{ProjectID: projectID}); err != nil {
+		t.Fatalf("clear quota: %v", err)
+	}
+	if _, ok := getProjectQuota(db, projectID); ok {
+		t.Fatalf("quota not cleared")
+	}
+}
+
+func TestSegmentTombstones(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "tombstones.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	raw := []byte(`{"schema_version":"2","rows":[
+		{"row_type":"meta","schema_version":"2"},
+		{"row_type":"rules","vlan_scope":"site","require_in_pool":false,"allow_reserved_overlap":false,"oversize_threshold":0},
+		{"row_type":"site","site":"HQ"},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":10,"name":"users","cidr":"10.0.0.0/26","locked":false,"dhcp":false},
+		{"row_type":"segment","site":"HQ","vrf":"PROD","vlan":20,"name":"voice","cidr":"10.0.0.64/26","locked":false,"dhcp":false}
+	]}`)
+	if report := importPlanData(db, raw, "json", projectID, ImportPolicy{}); len(report.Errors) != 0 {
+		t.Fatalf("import: %v", report.Errors)
+	}
+	bundle, err := buildPlanBundle(db, projectID)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	stampPlanExport(&bundle, time.Now().Add(-time.Hour))
+	var old bytes.Buffer
+	if err := writePlanBundle(&old, bundle, "csv"); err != nil {
+		t.Fatalf("write csv: %v", err)
+	}
+	if !strings.Contains(old.String(), ",exported_at\n") {
+		t.Fatalf("csv header lacks exported_at: %s", strings.SplitN(old.String(), "\n", 2)[0])
+	}
+
+	segs, _ := listSegments(db, projectID)
+	for _, s := range segs {
+		if s.Name == "voice" {
+			if err := deleteSegment(db, s.ID); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+		}
+	}
+	var label string
+	if err := db.QueryRow(`SELECT label FROM segment_tombstones WHERE project_id=? AND uid=?`, projectID,
+		stableID(planRowSegment, "Default", "HQ", "PROD", "20", "voice")).Scan(&label); err != nil || label != "HQ/PROD/20/voice" {
+		t.Fatalf("tombstone: %q %v", label, err)
+	}
+
+	// The file predates the deletion: voice stays deleted, users is kept.
+	tx, _ := db.Begin()
+	report := importPlanStream(tx, bytes.NewReader(old.Bytes()), "csv", projectID, ImportPolicy{RespectDeletions: true})
+	_ = tx.Commit()
+	if len(report.Errors) != 0 || report.Skipped != 1 || report.SegmentsAdded != 0 || report.Policy != "overwrite,respect-deletions" {
+		t.Fatalf("respecting import: %+v", report)
+	}
+	if len(report.Warnings) == 0 || !strings.Contains(report.Warnings[len(report.Warnings)-1], "HQ/PROD/20/voice was deleted") {
+		t.Fatalf("warnings: %v", report.Warnings)
+	}
+	if segs, _ := listSegments(db, projectID); len(segs) != 1 {
+		t.Fatalf("voice came back: %+v", segs)
+	}
+
+	// A file exported after the deletion brings the segment back on purpose.
+	stampPlanExport(&bundle, time.Now().Add(time.Hour))
+	var newer bytes.Buffer
+	_ = writePlanBundle(&newer, bundle, "json")
+	if report := importPlanData(db, newer.Bytes(), "json", projectID, ImportPolicy{RespectDeletions: true}); len(report.Errors) != 0 || report.SegmentsAdded != 1 {
+		t.Fatalf("newer import: %+v", report)
+	}
+
+	// Without the option the old file restores deleted segments as before.
+	segs, _ = listSegments(db, projectID)
+	for _, s := range segs {
+		if s.Name == "voice" {
+			_ = deleteSegment(db, s.ID)
+		}
+	}
+	if report := importPlanData(db, old.Bytes(), "csv", projectID, ImportPolicy{}); len(report.Errors) != 0 || report.SegmentsAdded != 1 {
+		t.Fatalf("plain import: %+v", report)
+	}
+}
+
+func TestProjectHealth(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "health.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	project, _ := projectByID(db, projectID)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	empty := scoreProjectHealth(project, healthInput{}, now)
+	if empty.Score != 100 || empty.Grade != "good" {
+		t.Fatalf("empty project: %+v", empty)
+	}
+	for _, c := range empty.Components {
+		if c.Applicable {
+			t.Fatalf("component applies to an empty project: %+v", c)
+		}
+	}
+
+	// A /24 with a /25 for 10 hosts and a /26: 75% used, one oversized
+	// segment, no gaps, last changed 30 days ago.
+	in := healthInput{
+		Segments: []Segment{
+			{ID: 1, SiteID: 1, Name: "users", Hosts: sql.NullInt64{Int64: 10, Valid: true}, CIDR: sql.NullString{String: "10.0.0.0/25", Valid: true}},
+			{ID: 2, SiteID: 1, Name: "voice", Hosts: sql.NullInt64{Int64: 50, Valid: true}, CIDR: sql.NullString{String: "10.0.0.192/26", Valid: true}},
+		},
+		Pools:      []Pool{{SiteID: 1, Site: "HQ", CIDR: "10.0.0.0/24", Family: "ipv4"}},
+		Conflicts:  []Conflict{{Kind: "OVERSIZED", Level: statusWarning.Label()}, {Kind: "DUPLICATE_VLAN", Level: statusConflict.Label()}},
+		LastChange: now.Add(-30 * 24 * time.Hour),
+	}
+	h := scoreProjectHealth(project, in, now)
+	scores := map[string]int{}
+	for _, c := range h.Components {
+		if !c.Applicable {
+			t.Fatalf("component not applicable: %+v", c)
+		}
+		scores[c.Key] = c.Score
+	}
+	// 1 conflict on 2 segments is a density of 0.5; 75% used is 80 on the
+	// 70-95% scale.
+	want := map[string]int{healthConflicts: 0, healthUtilization: 80, healthFragmentation: 100, healthOversize: 0, healthStaleness: 100}
+	for k, v := range want {
+		if scores[k] != v {
+			t.Fatalf("%s = %d, want %d (%+v)", k, scores[k], v, h.Components)
+		}
+	}
+	if h.Score != 50 || h.Grade != "poor" {
+		t.Fatalf("score: %d %s", h.Score, h.Grade)
+	}
+
+	// Scores are kept per day; the last one of a day wins.
+	for i, score := range []int{40, 60, 90} {
+		p := h
+		p.Score = score
+		p.ComputedAt = now.AddDate(0, 0, i/2).Format(time.RFC3339)
+		if err := recordProjectHealth(db, p); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	trend, err := projectHealthTrend(db, projectID, 7, now.AddDate(0, 0, 1))
+	if err != nil || len(trend) != 2 || trend[0].Score != 60 || trend[1].Score != 90 || trend[1].ComponentText(healthConflicts) != "0" {
+		t.Fatalf("trend: %+v %v", trend, err)
+	}
+	latest, _ := latestProjectHealth(db)
+	if latest[projectID].Score != 90 {
+		t.Fatalf("latest: %+v", latest)
+	}
+}
+
+func TestReadAudit(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "reads.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	defaultProjectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('quiet')`)
+	quietID, _ := res.LastInsertId()
+	if err := setProjectAuditReads(db, defaultProjectID, true); err != nil {
+		t.Fatalf("enable: %v", err)
+	}
+
+	r := gin.New()
+	r.Use(readAuditMiddleware(db, defaultProjectID))
+	r.GET("/export/json", func(c *gin.Context) { c.String(200, "plan") })
+	r.GET("/export/audit/csv", func(c *gin.Context) { c.String(200, "log") })
+	r.GET("/export/dhcp", func(c *gin.Context) { c.String(400, "bad format") })
+	r.GET("/generate/bundle", func(c *gin.Context) { c.String(200, "zip") })
+	r.GET("/conflicts", func(c *gin.Context) { c.String(200, "page") })
+	for _, path := range []string{
+		"/export/json?project_id=" + itoa64(defaultProjectID) + "&site=HQ&as_of=2026-01-01",
+		"/export/audit/csv?project_id=" + itoa64(defaultProjectID),
+		"/export/dhcp?project_id=" + itoa64(defaultProjectID) + "&format=x",
+		"/generate/bundle?project_id=" + itoa64(defaultProjectID),
+		"/conflicts?project_id=" + itoa64(defaultProjectID),
+		"/export/json?project_id=" + itoa64(quietID),
+	} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Actor", "auditor")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries, err := listAuditEntries(db, defaultProjectID)
+	if err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	var kinds []string
+	for _, e := range entries {
+		if e.Action != auditActionRead || e.Actor != "auditor" {
+			t.Fatalf("entry: %+v", e)
+		}
+		kinds = append(kinds, e.EntityType)
+	}
+	if strings.Join(kinds, ",") != "config,audit_log,export" {
+		t.Fatalf("read entries (newest first): %v", kinds)
+	}
+	var scope auditReadScope
+	if err := json.Unmarshal([]byte(entries[2].AfterJSON.String), &scope); err != nil || scope.Path != "/export/json" || !strings.Contains(scope.Query, "site=HQ") || scope.Bytes != 4 {
+		t.Fatalf("scope: %+v %v", scope, err)
+	}
+	if quiet, _ := listAuditEntries(db, quietID); len(quiet) != 0 {
+		t.Fatalf("reads of a project without read audit were recorded: %+v", quiet)
+	}
+	if last, _ := lastProjectChange(db, defaultProjectID); last != nil {
+		t.Fatalf("reads are not changes: %+v", last)
+	}
+}
+
+func TestSandboxProjects(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "sandbox.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	defaultProjectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	now := time.Now().UTC()
+	old := now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)
+	for _, p := range []struct {
+		name    string
+		sandbox int
+		since   any
+	}{{"idle", 1, old}, {"busy", 1, old}, {"plan", 0, nil}} {
+		if _, err := db.Exec(`INSERT INTO projects(name, sandbox, sandbox_since) VALUES(?, ?, ?)`, p.name, p.sandbox, p.since); err != nil {
+			t.Fatalf("insert %s: %v", p.name, err)
+		}
+	}
+	var idleID, busyID int64
+	_ = db.QueryRow(`SELECT id FROM projects WHERE name='idle'`).Scan(&idleID)
+	_ = db.QueryRow(`SELECT id FROM projects WHERE name='busy'`).Scan(&busyID)
+	if err := insertAuditRecord(db, auditRecord{ProjectID: busyID, Actor: "test", Action: "update", EntityType: "project"}); err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	if err := setProjectSandbox(db, defaultProjectID, false); err != nil {
+		t.Fatalf("default: %v", err)
+	}
+
+	// Sandboxes are exported neither over HTTP nor from the CLI path.
+	r := gin.New()
+	r.Use(sandboxExportGuard(db, defaultProjectID))
+	r.GET("/export/json", func(c *gin.Context) { c.String(200, "ok") })
+	for id, want := range map[int64]int{idleID: http.StatusConflict, defaultProjectID: 200} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/export/json?project_id="+itoa64(id), nil))
+		if w.Code != want {
+			t.Fatalf("export project %d: %d %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	dash, err := buildDashboard(db)
+	if err != nil {
+		t.Fatalf("dashboard: %v", err)
+	}
+	if n := len(dash.Projects); n != 4 || !dash.Projects[n-1].Project.Sandbox || len(dash.Recent) != 0 {
+		t.Fatalf("dashboard: %+v", dash)
+	}
+
+	purged, err := purgeIdleSandboxes(db, defaultProjectID, 14*24*time.Hour, now)
+	if err != nil || len(purged) != 1 || purged[0] != "idle" {
+		t.Fatalf("purge: %v %v", purged, err)
+	}
+	if projectExists(db, idleID) || !projectExists(db, busyID) {
+		t.Fatal("wrong project purged")
+	}
+	purged, _ = purgeIdleSandboxes(db, defaultProjectID, 14*24*time.Hour, now.Add(15*24*time.Hour))
+	if len(purged) != 1 || purged[0] != "busy" {
+		t.Fatalf("second purge: %v", purged)
+	}
+	var n int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action='purge' AND actor='scheduler'`).Scan(&n)
+	if n != 2 {
+		t.Fatalf("purge audit entries: %d", n)
+	}
+}
+
+func TestAllocationHooks(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(dir, "hooks.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := ensureDefaultProject(db); err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Hooks')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.40.0.0/24')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts, locked) VALUES(?, 'GUEST', 30, 'guest', 100, 0)`, siteID)
+	project, _ := projectByID(db, projectID)
+
+	// The pre hook refuses to put guest into 10.40.0.0/25.
+	if err := os.Mkdir(filepath.Join(dir, "hook dir"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	pre := filepath.Join(dir, "hook dir", "pre.sh")
+	script := "#!/bin/sh\nif grep -q '\"cidr_after\":\"10.40.0.0/25\"'; then echo '{\"allowed\":false,\"message\":\"never allocate .0/25 to guest\"}'; fi\n"
+	if err := os.WriteFile(pre, []byte(script), 0o755); err != nil {
+		t.Fatalf("write hook: %v", err)
+	}
+	argv, _ := json.Marshal([]string{pre})
+	t.Setenv("ALLOCATION_HOOK_PRE", string(argv))
+	err = allocateProject(db, projectID, chainAllocationChecks(nil, allocationHookCheck(project, "test")))
+	if !isValidatorError(err) || !strings.Contains(err.Error(), "never allocate .0/25 to guest") {
+		t.Fatalf("pre hook: %v", err)
+	}
+	if segs, _ := listSegments(db, projectID); segs[0].CIDR.Valid {
+		t.Fatal("rejected allocation must be rolled back")
+	}
+
+	// Failing hooks reject too, with their output as the reason.
+	t.Setenv("ALLOCATION_HOOK_PRE", "sh -c 'exit 3'")
+	if err := allocateProject(db, projectID, allocationHookCheck(project, "test")); !isValidatorError(err) {
+		t.Fatalf("failing hook: %v", err)
+	}
+
+	for _, hook := range []string{"[]", `[""]`, "[not json"} {
+		if _, err := hookCommand(hook); err == nil {
+			t.Fatalf("hook %q accepted", hook)
+		}
+	}
+	if err := validateSetting(configSetting{Env: "ALLOCATION_HOOK_POST"}, "[]"); err == nil {
+		t.Fatal("empty hook command accepted by the config check")
+	}
+
+	// The post hook gets the committed allocation.
+	var got allocationHookRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+	t.Setenv("ALLOCATION_HOOK_PRE", "")
+	t.Setenv("ALLOCATION_HOOK_POST", srv.URL)
+	before, _ := listSegments(db, projectID)
+	if err := allocateProject(db, projectID, allocationHookCheck(project, "test")); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	after, _ := listSegments(db, projectID)
+	notifyAllocationHook(project, "test", buildAllocationSummary(before, after))
+	if got.Stage != allocationHookPost || got.Project != "Hooks" || len(got.Proposal.Changes) != 1 || got.Proposal.Changes[0].CIDRAfter != "10.40.0.0/25" {
+		t.Fatalf("post hook payload: %+v", got)
+	}
+}
+
+func TestJustificationReport(t *testing.T) {
+	sites := []Site{{ID: 1, Name: "HQ"}}
+	pools := []Pool{
+		{SiteID: 1, Site: "HQ", CIDR: "10.0.0.0/24", Family: "ipv4"},
+		{SiteID: 1, Site: "HQ", CIDR: "2001:db8::/48", Family: "ipv6"},
+	}
+	segs := []Segment{
+		{ID: 1, SiteID: 1, Name: "users", CIDR: sql.NullString{String: "10.0.0.0/25", Valid: true}},
+		{ID: 2, SiteID: 1, Name: "voice", CIDR: sql.NullString{String: "10.0.0.128/26", Valid: true}, CIDRV6: sql.NullString{String: "2001:db8::/64", Valid: true}},
+	}
+	// 192 of 256 used, growing 5% a month: 192 * 1.05^12 = 344.8.
+	report := buildJustificationReport(Project{Name: "Branch"}, buildCapacityReport(segs, pools, sites, 5, 12, 64), time.Now())
+	if len(report.Blocks) != 2 || report.Blocks[0].CIDR != "10.0.0.0/24" || report.Blocks[0].Used != "192" {
+		t.Fatalf("blocks: %+v", report.Blocks)
+	}
+	if len(report.Totals) != 2 {
+		t.Fatalf("totals: %+v", report.Totals)
+	}
+	v4 := report.Totals[0]
+	if v4.Family != "ipv4" || v4.Projected != "345" || v4.Shortfall != "89" || v4.Request != "/25" {
+		t.Fatalf("ipv4 total: %+v", v4)
+	}
+	if v6 := report.Totals[1]; v6.Shortfall != "" || v6.Request != "" {
+		t.Fatalf("ipv6 total: %+v", v6)
+	}
+
+	var text, csvOut bytes.Buffer
+	if err := writeJustificationText(&text, report); err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	if !strings.Contains(text.String(), "Requested block:      /25") || !strings.Contains(text.String(), "IPv6 summary") {
+		t.Fatalf("text report:\n%s", text.String())
+	}
+	if err := writeJustificationCSV(&csvOut, report); err != nil || !strings.Contains(csvOut.String(), "total,ipv4,1 blocks,,256,192,75.0%,345,134.8%,89,/25") {
+		t.Fatalf("csv report: %v\n%s", err, csvOut.String())
+	}
+	if pdf := renderJustificationPDF(report); !bytes.HasPrefix(pdf, []byte("%PDF")) {
+		t.Fatal("pdf report")
+	}
+}
+
+func TestProjectComparison(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "compare.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := ensureDefaultProject(db); err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	addSite := func(project, site string, segs [][4]any) {
+		var projectID int64
+		if err := db.QueryRow(`SELECT id FROM projects WHERE name=?`, project).Scan(&projectID); err != nil {
+			res, _ := db.Exec(`INSERT INTO projects(name) VALUES(?)`, project)
+			projectID, _ = res.LastInsertId()
+		}
+		res, err := db.Exec(`INSERT INTO sites(name) VALUES(?)`, site)
+		if err != nil {
+			t.Fatalf("site %s: %v", site, err)
+		}
+		siteID, _ := res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+		for _, s := range segs {
+			if _, err := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts, prefix, locked) VALUES(?, 'CORP', ?, ?, ?, ?, 0)`, siteID, s[0], s[1], s[2], s[3]); err != nil {
+				t.Fatalf("segment: %v", err)
+			}
+		}
+	}
+	addSite("Reference", "TEMPLATE", [][4]any{{10, "users", 100, nil}, {20, "voice", nil, 26}, {99, "mgmt", nil, 28}})
+	addSite("Branches", "BR-01", [][4]any{{11, "Users", 100, nil}, {20, "voice", nil, 25}, {30, "cameras", 20, nil}})
+	projects, _ := listProjects(db)
+	byName := map[string]Project{}
+	for _, p := range projects {
+		byName[p.Name] = p
+	}
+
+	cmp, err := buildProjectComparison(db, byName["Reference"], byName["Branches"], "template", "BR-01")
+	if err != nil {
+		t.Fatalf("compare sites: %v", err)
+	}
+	if len(cmp.Sites) != 1 || cmp.Sites[0].Status != compareDifferent {
+		t.Fatalf("sites: %+v", cmp.Sites)
+	}
+	got := map[string]string{}
+	for _, s := range cmp.Sites[0].Segments {
+		got[strings.ToLower(s.Name)] = s.Status + " " + strings.Join(s.Diffs, ",")
+	}
+	want := map[string]string{"users": "different vlan", "voice": "different size", "mgmt": "missing ", "cameras": "extra "}
+	for k, v := range want {
+		if got[k] != v {
+			t.Fatalf("%s: %q, want %q (%v)", k, got[k], v, got)
+		}
+	}
+	if cmp.Counts[compareDifferent] != 2 || cmp.Counts[compareMissing] != 1 || cmp.Counts[compareExtra] != 1 {
+		t.Fatalf("counts: %v", cmp.Counts)
+	}
+
+	// Whole projects line up sites by name, so differently named sites are
+	// missing on one side and extra on the other.
+	cmp, err = buildProjectComparison(db, byName["Reference"], byName["Branches"], "", "")
+	if err != nil || len(cmp.Sites) != 2 || cmp.Sites[0].Status != compareMissing || cmp.Sites[1].Status != compareExtra {
+		t.Fatalf("compare projects: %+v %v", cmp.Sites, err)
+	}
+	if _, err := buildProjectComparison(db, byName["Reference"], byName["Branches"], "TEMPLATE", ""); err == nil {
+		t.Fatal("one site name must be refused")
+	}
+}
+
+func TestCrossProjectOverlaps(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "overlaps.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := ensureDefaultProject(db); err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	addProject := func(name, domain, pool string, segs [][2]string) int64 {
+		res, err := db.Exec(`INSERT INTO projects(name, routing_domain) VALUES(?, ?)`, name, nullStringToAny(domain))
+		if err != nil {
+			t.Fatalf("project %s: %v", name, err)
+		}
+		projectID, _ := res.LastInsertId()
+		res, _ = db.Exec(`INSERT INTO sites(name) VALUES(?)`, name+"-SITE")
+		siteID, _ := res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+		_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, pool)
+		for i, s := range segs {
+			if _, err := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, ?, ?, ?, ?)`, siteID, s[0], 10+i, "seg"+itoa(i), s[1]); err != nil {
+				t.Fatalf("segment: %v", err)
+			}
+		}
+		return projectID
+	}
+	wanID := addProject("WAN", "corp", "10.0.0.0/16", [][2]string{{"PROD", "10.0.1.0/24"}, {"GUEST", "10.0.2.0/24"}})
+	dcID := addProject("DC", "Corp", "10.0.128.0/17", [][2]string{{"prod", "10.0.1.128/25"}, {"PROD", "10.0.2.0/24"}})
+	addProject("Lab", "", "10.0.0.0/16", [][2]string{{"PROD", "10.0.1.0/24"}})
+	sandboxID := addProject("Scratch", "corp", "10.0.0.0/8", [][2]string{{"PROD", "10.0.1.0/24"}})
+	_, _ = db.Exec(`UPDATE projects SET sandbox=1 WHERE id=?`, sandboxID)
+
+	reports, err := buildCrossProjectOverlaps(db)
+	if err != nil {
+		t.Fatalf("overlaps: %v", err)
+	}
+	if len(reports) != 1 || len(reports[0].Projects) != 2 {
+		t.Fatalf("domains: %+v", reports)
+	}
+	// The GUEST and PROD 10.0.2.0/24 are in different VRFs; only the PROD
+	// segments and the two pools collide.
+	var conflicts, warnings int
+	for _, o := range reports[0].Overlaps {
+		if o.A.ProjectID == o.B.ProjectID {
+			t.Fatalf("same-project overlap reported: %+v", o)
+		}
+		switch o.Level {
+		case statusConflict.Label():
+			conflicts++
+			if o.VRF != "prod" || o.A.CIDR != "10.0.1.0/24" || o.B.CIDR != "10.0.1.128/25" {
+				t.Fatalf("conflict: %+v", o)
+			}
+		case statusWarning.Label():
+			warnings++
+		}
+	}
+	if conflicts != 1 || warnings != 1 {
+		t.Fatalf("overlaps: %+v", reports[0].Overlaps)
+	}
+
+	dc, _ := projectByID(db, dcID)
+	if got, _ := projectCrossOverlaps(db, dc); len(got) != 2 {
+		t.Fatalf("dc overlaps: %+v", got)
+	}
+	if err := setProjectRoutingDomain(db, wanID, " "); err != nil {
+		t.Fatalf("clear domain: %v", err)
+	}
+	if got, _ := projectCrossOverlaps(db, dc); len(got) != 0 {
+		t.Fatalf("overlaps after leaving the domain: %+v", got)
+	}
+}
+
+func TestFederatedSources(t *testing.T) {
+	open := func(name string) (*sql.DB, int64, int64) {
+		db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), name)))
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := migrate(db); err != nil {
+			t.Fatalf("migrate: %v", err)
+		}
+		projectID, _ := ensureDefaultProject(db)
+		res, _ := db.Exec(`INSERT INTO sites(name) VALUES(?)`, strings.TrimSuffix(name, ".sqlite"))
+		siteID, _ := res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+		return db, projectID, siteID
+	}
+	remote, remoteProjectID, remoteSite := open("emea.sqlite")
+	_, _ = remote.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.20.0.0/16')`, remoteSite)
+	_, _ = remote.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.20.1.0/24')`, remoteSite)
+	_, _ = remote.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'GUEST', 20, 'guest', '10.20.2.0/24')`, remoteSite)
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if r.URL.Path != "/export/json" || r.URL.Query().Get("project_id") != itoa64(remoteProjectID) {
+			http.NotFound(w, r)
+			return
+		}
+		bundle, err := buildPlanBundle(remote, remoteProjectID)
+		if err != nil {
+			t.Errorf("remote bundle: %v", err)
+		}
+		_ = writePlanBundle(w, bundle, "json")
+	}))
+	defer srv.Close()
+
+	db, projectID, siteID := open("hq.sqlite")
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.20.128.0/17')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'prod', 10, 'apps', '10.20.1.128/25')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 20, 'voice', '10.20.2.0/24')`, siteID)
+	if _, err := saveFederationSource(db, FederationSource{ProjectID: projectID, Name: "emea", URL: srv.URL, TokenRef: "plain-token"}); err == nil {
+		t.Fatalf("plaintext tokens must be rejected")
+	}
+	t.Setenv("SUBNETIO_SECRET_EMEA_TOKEN", "t0ken")
+	setSecretBackend(envSecretBackend{prefix: "SUBNETIO_SECRET_"})
+	id, err := saveFederationSource(db, FederationSource{ProjectID: projectID, Name: "emea", URL: srv.URL + "/", RemoteProjectID: remoteProjectID, TokenRef: "secret:emea-token"})
+	if err != nil {
+		t.Fatalf("save source: %v", err)
+	}
+	src, _ := federationSourceByID(db, projectID, id)
+	if err := pullFederationSource(db, srv.Client(), src); err != nil {
+		t.Fatalf("pull: %v", err)
+	}
+	if auth != "Bearer t0ken" {
+		t.Fatalf("authorization header: %q", auth)
+	}
+	src, _ = federationSourceByID(db, projectID, id)
+	if src.Pools != 1 || src.Segments != 2 || src.PulledAt == "" || src.RemoteProject == "" {
+		t.Fatalf("cached source: %+v", src)
+	}
+	var localSegments int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM segments`).Scan(&localSegments)
+	if localSegments != 2 {
+		t.Fatalf("the remote plan must not be merged, got %d segments", localSegments)
+	}
+
+	project, _ := projectByID(db, projectID)
+	overlaps, err := buildFederatedOverlaps(db, project)
+	if err != nil {
+		t.Fatalf("overlaps: %v", err)
+	}
+	// voice and guest share 10.20.2.0/24 but not the VRF.
+	if len(overlaps) != 2 || overlaps[0].Level != statusConflict.Label() || overlaps[0].A.Name != "apps" || overlaps[0].B.Source != "emea" || overlaps[0].B.Name != "users" {
+		t.Fatalf("overlaps: %+v", overlaps)
+	}
+	if overlaps[1].Level != statusWarning.Label() || overlaps[1].A.Kind != "pool" || overlaps[1].B.CIDR != "10.20.0.0/16" {
+		t.Fatalf("pool overlap: %+v", overlaps[1])
+	}
+
+	src.URL = srv.URL + "/missing"
+	if err := pullFederationSource(db, srv.Client(), src); err == nil {
+		t.Fatalf("expected a pull error")
+	}
+	src, _ = federationSourceByID(db, projectID, id)
+	if src.PullError == "" || src.Segments != 2 {
+		t.Fatalf("a failed pull should keep the snapshot: %+v", src)
+	}
+	if err := deleteFederationSource(db, id); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	var cached int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM federation_prefixes`).Scan(&cached)
+	if cached != 0 {
+		t.Fatalf("cached prefixes left: %d", cached)
+	}
+}
+
+func TestRoutingTableReconciliation(t *testing.T) {
+	ios := `Codes: L - local, C - connected, S - static, R - RIP, M - mobile, B - BGP
+       O - OSPF, IA - OSPF inter area
+
+Gateway of last resort is 10.0.0.1 to network 0.0.0.0
+
+S*    0.0.0.0/0 [1/0] via 10.0.0.1
+      10.0.0.0/8 is variably subnetted, 4 subnets, 3 masks
+C        10.1.10.0/24 is directly connected, Vlan10
+L        10.1.10.1/32 is directly connected, Vlan10
+O IA     10.1.0.0/16 [110/2] via 10.0.0.2, 1d, Vlan99
+O        10.9.9.0/24 [110/2] via 10.0.0.2, 1d, Vlan99
+      172.16.0.0/24 is subnetted, 1 subnets
+C        172.16.5.0 is directly connected, Vlan50
+
+Routing Table: GUEST
+C        192.168.50.0/24 is directly connected, Vlan500`
+	routes, skipped, err := parseRoutingTable(ios)
+	if err != nil {
+		t.Fatalf("parse ios: %v", err)
+	}
+	got := []string{}
+	for _, r := range routes {
+		got = append(got, r.VRF+"|"+r.Prefix+"|"+r.Protocol+"|"+r.NextHop)
+	}
+	want := []string{"|10.1.10.0/24|C|", "|10.1.0.0/16|O IA|10.0.0.2", "|10.9.9.0/24|O|10.0.0.2", "|172.16.5.0/24|C|", "GUEST|192.168.50.0/24|C|"}
+	if strings.Join(got, " ") != strings.Join(want, " ") || skipped != 2 {
+		t.Fatalf("ios routes: %v (skipped %d)", got, skipped)
+	}
+
+	junos := `inet.0: 3 destinations, 3 routes (3 active, 0 holddown, 0 hidden)
++ = Active Route, - = Last Active, * = Both
+
+10.1.10.0/24       *[Direct/0] 2w0d 10:00:00
+                    >  via ge-0/0/0.10
+10.1.10.1/32       *[Local/0] 2w0d 10:00:00
+                       Local via ge-0/0/0.10
+
+CORP.inet.0: 1 destinations, 1 routes (1 active, 0 holddown, 0 hidden)
+10.2.0.0/24        *[OSPF/10] 1d 00:00:00, metric 2
+                    >  to 10.0.0.2 via ge-0/0/1.0`
+	routes, skipped, err = parseRoutingTable(junos)
+	if err != nil || len(routes) != 2 || skipped != 1 || routes[1].VRF != "CORP" || routes[1].Protocol != "OSPF" {
+		t.Fatalf("junos routes: %+v %d %v", routes, skipped, err)
+	}
+	if routes, _, err := parseRoutingTable(`{"routes":[{"network":"10.3.0.0/24","vrf":"default"}]}`); err != nil || len(routes) != 1 || routes[0].VRF != "" {
+		t.Fatalf("json routes: %+v %v", routes, err)
+	}
+	if _, _, err := parseRoutingTable("nothing to see"); err == nil {
+		t.Fatal("text without routes must be refused")
+	}
+
+	segs := []Segment{
+		{ID: 1, SiteID: 1, Site: "HQ", VRF: "CORP", VLAN: 10, Name: "users", CIDR: sql.NullString{String: "10.1.10.0/24", Valid: true}},
+		{ID: 2, SiteID: 1, Site: "HQ", VRF: "CORP", VLAN: 20, Name: "voice", CIDR: sql.NullString{String: "10.1.20.0/24", Valid: true}},
+		{ID: 3, SiteID: 1, Site: "HQ", VRF: "CORP", VLAN: 30, Name: "cams", CIDR: sql.NullString{String: "10.5.0.0/24", Valid: true}},
+		{ID: 4, SiteID: 2, Site: "BR", VRF: "GUEST", VLAN: 500, Name: "guest", CIDR: sql.NullString{String: "192.168.60.0/24", Valid: true}},
+	}
+	routes, _, _ = parseRoutingTable(ios)
+	result := reconcileRoutes(routes, segs, 1)
+	statuses := map[string]string{}
+	for _, r := range result.Routes {
+		statuses[r.Prefix] = r.Status
+	}
+	if statuses["10.1.10.0/24"] != routeMatched || statuses["10.1.0.0/16"] != routeSummary || statuses["10.9.9.0/24"] != routeUnknown || statuses["192.168.50.0/24"] != routeUnknown {
+		t.Fatalf("route statuses: %v", statuses)
+	}
+	unrouted := result.UnroutedSegments()
+	if len(result.Segments) != 3 || len(unrouted) != 1 || unrouted[0].Name != "cams" {
+		t.Fatalf("segments: %+v", result.Segments)
+	}
+	if result.Segments[1].Status != segmentSummarized || result.Segments[1].Route != "10.1.0.0/16" {
+		t.Fatalf("voice: %+v", result.Segments[1])
+	}
+}
+
+func TestTemplateFixtures(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEMPLATE_DIR", filepath.Join(dir, "templates"))
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(dir, "fixtures.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.1.0.0/16')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'CORP', 10, 'users', '10.1.10.0/24')`, siteID)
+
+	opts := GenerateOptions{Template: "cisco", IncludeVRF: true, IncludeVLAN: true, IncludeDHCP: true}
+	fixture, err := captureTemplateFixture(db, projectID, opts, "hq")
+	if err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	if !strings.Contains(fixture.Expected, "10.1.10.1") {
+		t.Fatalf("expected output: %s", fixture.Expected)
+	}
+	if _, err := saveTemplateFixture(db, fixture); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := saveTemplateFixture(db, TemplateFixture{Template: "cisco", Name: "bad", Context: "{"}); err == nil {
+		t.Fatal("invalid context must be refused")
+	}
+
+	// The captured context survives the JSON round trip unchanged.
+	results, err := runStoredTemplateFixtures(db, "cisco")
+	if err != nil || len(results) != 1 || !results[0].Passed {
+		t.Fatalf("run: %+v %v", results, err)
+	}
+
+	// An override that renders differently fails with a diff.
+	source, _ := loadTemplateSource("cisco")
+	edited := strings.Replace(source.Content, "vlan", "VLAN", 1)
+	if err := os.MkdirAll(customTemplateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(customTemplatePath("cisco"), []byte(edited), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results, err = runStoredTemplateFixtures(db, "cisco")
+	if err != nil || fixtureFailures(results) != 1 || !strings.Contains(results[0].Diff, "+") {
+		t.Fatalf("run edited: %+v %v", results, err)
+	}
+
+	var out bytes.Buffer
+	t.Setenv("DB_PATH", filepath.Join(dir, "fixtures.sqlite"))
+	if err := runCLI([]string{"template-test"}, &out); err == nil || !strings.Contains(out.String(), "FAIL cisco/hq") {
+		t.Fatalf("cli: %v %s", err, out.String())
+	}
+}
+
+func TestGenerateBatchBundle(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEMPLATE_DIR", filepath.Join(dir, "templates"))
+	if err := os.MkdirAll(customTemplateDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(customTemplatePath("broken"), []byte("{{.Nope}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sites := []Site{{ID: 1, Name: "HQ"}}
+	views := []SegmentView{{Segment: Segment{ID: 1, SiteID: 1, Site: "HQ", VRF: "CORP", VLAN: 10, Name: "users"}, CIDR: "10.1.10.0/24"}}
+	outputs, batch := generateBatch(GenerateOptions{IncludeVLAN: true, SiteFilter: "HQ"}, views, sites, Project{ID: 1, Name: "Lab"}, ProjectMeta{})
+	catalog := listTemplateCatalog()
+	if len(outputs) != len(catalog)-1 || len(batch.Templates) != len(outputs) || batch.Failed["broken"] == "" {
+		t.Fatalf("batch: %d outputs of %d templates, failed %v", len(outputs), len(catalog), batch.Failed)
+	}
+	if batch.Filters["site"] != "HQ" {
+		t.Fatalf("filters: %v", batch.Filters)
+	}
+	bundle, err := buildBatchBundle(outputs, batch)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["metadata.json"] || !names["subnetio_cisco."+templateExtension("cisco")] || len(names) != len(outputs)+1 {
+		t.Fatalf("bundle files: %v", names)
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	r := gin.New()
+	r.Use(gin.CustomRecovery(recoverWithProblem))
+	r.NoRoute(notFoundHandler)
+	r.GET("/api/v1/fail", func(c *gin.Context) {
+		respondError(c, 500, fmt.Errorf("sql: no such table: secrets"))
+	})
+	r.GET("/api/v1/missing", func(c *gin.Context) {
+		respondError(c, 404, fmt.Errorf("project not found"))
+	})
+	r.GET("/boom", func(c *gin.Context) { panic("boom") })
+
+	do := func(path, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) Problem {
+		var p Problem
+		if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+			t.Fatalf("content type %q", ct)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatalf("decode %s: %v", w.Body.String(), err)
+		}
+		return p
+	}
+
+	// Internal errors carry an error ID but not the underlying message.
+	w := do("/api/v1/fail", "")
+	p := decode(w)
+	if w.Code != 500 || p.Status != 500 || p.ErrorID == "" || p.Instance != "/api/v1/fail" {
+		t.Fatalf("500 problem: %d %+v", w.Code, p)
+	}
+	if strings.Contains(w.Body.String(), "secrets") || !strings.Contains(p.Detail, p.ErrorID) {
+		t.Fatalf("500 detail: %+v", p)
+	}
+
+	// Client errors keep their message, also under the old "error" key.
+	p = decode(do("/api/v1/missing", ""))
+	if p.Status != 404 || p.Title != "Not Found" || p.Detail != "project not found" || p.Error != p.Detail || p.ErrorID != "" {
+		t.Fatalf("404 problem: %+v", p)
+	}
+
+	// Browsers get a page, JSON clients a problem document.
+	w = do("/boom", "text/html")
+	if w.Code != 500 || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(w.Body.String(), "Error ID") || strings.Contains(w.Body.String(), "panic") {
+		t.Fatalf("panic page: %d %s", w.Code, w.Body.String())
+	}
+	if p := decode(do("/nowhere", "application/json")); p.Status != 404 {
+		t.Fatalf("no route: %+v", p)
+	}
+}
+
+func TestFormValidation(t *testing.T) {
+	form := func(values url.Values) *FormState {
+		return &FormState{Values: values, Errors: map[string]string{}}
+	}
+
+	f := form(url.Values{"site_id": {"1"}, "vlan": {"0"}, "name": {" "}, "prefix": {"33"}, "expires_at": {"soon"}})
+	validateSegmentForm(f, true, "")
+	for _, field := range []string{"vrf", "vlan", "name", "prefix", "expires_at"} {
+		if f.Error(field) == "" {
+			t.Fatalf("segment %s not rejected: %v", field, f.Errors)
+		}
+	}
+	if f.Error("site_id") != "" || f.Error("hosts") != "" {
+		t.Fatalf("valid segment fields rejected: %v", f.Errors)
+	}
+	// The project's default VRF fills an empty VRF on create, not on edit.
+	f = form(url.Values{"site_id": {"1"}, "vlan": {"10"}, "name": {"users"}})
+	if validateSegmentForm(f, true, "PROD"); f.Failed() {
+		t.Fatalf("default VRF: %v", f.Errors)
+	}
+	f = form(url.Values{"vlan": {"10"}, "name": {"users"}})
+	if validateSegmentForm(f, false, "PROD"); f.Error("vrf") == "" {
+		t.Fatalf("edit without VRF accepted")
+	}
+
+	f = form(url.Values{"cidr": {"10.0.0.0/33"}, "priority": {"x"}})
+	validatePoolForm(f, true)
+	if f.Error("site_id") == "" || f.Error("cidr") == "" || f.Error("priority") == "" || f.Invalid("cidr") != " is-invalid" {
+		t.Fatalf("pool: %v", f.Errors)
+	}
+
+	f = form(url.Values{"name": {"ALA"}, "dhcp_failover_primary": {"10.0.0.1"}, "dhcp_lease_time": {"0"}})
+	validateSiteForm(f)
+	if len(f.Errors) != 1 || f.Error("dhcp_failover_secondary") == "" {
+		t.Fatalf("site: %v", f.Errors)
+	}
+
+	f = form(url.Values{"vlan_scope": {"site"}, "pool_strategy": {"spillover"}, "oversize_threshold": {"5"}, "vlan_ranges": {"100-99"}})
+	validateRulesForm(f)
+	if len(f.Errors) != 2 || f.Error("oversize_threshold") == "" || f.Error("vlan_ranges") == "" {
+		t.Fatalf("rules: %v", f.Errors)
+	}
+
+	// A page without a rejected form gets nil, which the templates rely on.
+	var none *FormState
+	if none.Of("segment").For(1).Value("vrf") != "" || none.Failed() || none.Or("vrf", "PROD") != "PROD" {
+		t.Fatalf("nil form state")
+	}
+	f = form(url.Values{"dhcp_enabled": {"off", "on"}})
+	f.Name, f.ID = "segment_edit", 7
+	if f.Of("segment_edit").For(7) != f || f.Of("segment") != nil || f.For(8) != nil || !f.Checked("dhcp_enabled") {
+		t.Fatalf("form selection")
+	}
+}
+
+func TestQuickAddSegments(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:quickadd?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Quick')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name) VALUES(?, 'PROD', 5, 'mgmt')`, siteID)
+	sites, _ := listSites(db, projectID)
+
+	text := "site\tvrf\tvlan\tname\thosts\nHQ\tPROD\t10\tusers\t120\n# comment\nhq;PROD;20;voice\nBR,PROD,30,x\nHQ,PROD,10,users\nHQ,PROD,5,mgmt\nHQ,PROD,9999,y\nHQ,PROD,40\n"
+	p := previewQuickAdd(db, projectID, sites, text)
+	want := []string{"", "", `no site "BR"`, "repeats line 2", "already exists", "outside 1-4094", "expected 4 or 5 columns"}
+	if len(p.Rows) != len(want) || p.Valid != 2 || p.Invalid != 5 || p.Ready() {
+		t.Fatalf("preview: %d rows, %d valid, %d invalid: %+v", len(p.Rows), p.Valid, p.Invalid, p.Rows)
+	}
+	for i, w := range want {
+		if (w == "") != (p.Rows[i].Error == "") || !strings.Contains(p.Rows[i].Error, w) {
+			t.Fatalf("line %d: error %q, want %q", p.Rows[i].Line, p.Rows[i].Error, w)
+		}
+	}
+	if _, err := applyQuickAdd(db, p); err == nil {
+		t.Fatalf("applied a preview with rejected rows")
+	}
+
+	p = previewQuickAdd(db, projectID, sites, "HQ\tPROD\t10\tusers\t120\nhq;PROD;20;voice\n")
+	ids, err := applyQuickAdd(db, p)
+	if err != nil || len(ids) != 2 {
+		t.Fatalf("apply: %v %v", ids, err)
+	}
+	if seg, ok := segmentByID(db, ids[0]); !ok || seg.Name != "users" || seg.VLAN != 10 || !seg.Hosts.Valid || seg.Hosts.Int64 != 120 {
+		t.Fatalf("created segment: %+v", seg)
+	}
+	if p = previewQuickAdd(db, projectID, sites, "HQ,PROD,20,voice\n"); p.Ready() {
+		t.Fatalf("existing segment accepted again")
+	}
+}
+
+func TestPlanAsOf(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:planasof?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('History')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	add := func(vlan int, name, cidr string) Segment {
+		res, err := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', ?, ?, ?)`, siteID, vlan, name, nullStringToAny(cidr))
+		if err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		id, _ := res.LastInsertId()
+		seg, _ := segmentByID(db, id)
+		return seg
+	}
+	audit := func(at string, record auditRecord) {
+		record.ProjectID = projectID
+		if err := insertAuditRecord(db, record); err != nil {
+			t.Fatalf("audit: %v", err)
+		}
+		_, _ = db.Exec(`UPDATE audit_log SET created_at=? WHERE id=(SELECT MAX(id) FROM audit_log)`, at)
+	}
+
+	// In January: users and voice, allocated. Since then: users was
+	// renumbered by an allocation, voice deleted, cams created, and a plan
+	// imported.
+	users := add(10, "users", "10.0.1.0/24")
+	voice := add(20, "voice", "10.0.2.0/24")
+	cams := add(30, "cams", "")
+	audit("2026-02-01T00:00:00Z", auditRecord{Action: "allocate", EntityType: "allocation", After: auditAllocationSummary{
+		Changes: []auditAllocationChange{{SegmentID: users.ID, CIDRBefore: "10.0.0.0/24", CIDRAfter: "10.0.1.0/24"}}}})
+	audit("2026-02-02T00:00:00Z", auditRecord{Action: "delete", EntityType: "segment", EntityID: sql.NullInt64{Int64: voice.ID, Valid: true}, Before: snapshotSegment(voice)})
+	_, _ = db.Exec(`DELETE FROM segments WHERE id=?`, voice.ID)
+	audit("2026-02-03T00:00:00Z", auditRecord{Action: "create", EntityType: "segment", EntityID: sql.NullInt64{Int64: cams.ID, Valid: true}, After: snapshotSegment(cams)})
+	audit("2026-02-04T00:00:00Z", auditRecord{Action: "import", EntityType: "plan"})
+
+	at, err := parseAsOf("2026-01-31")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	h, err := planAsOf(db, projectID, at)
+	if err != nil {
+		t.Fatalf("as of: %v", err)
+	}
+	got := map[string]string{}
+	for _, seg := range h.Segments {
+		got[seg.Name] = seg.CIDR.String
+	}
+	if len(got) != 2 || got["users"] != "10.0.0.0/24" || got["voice"] != "10.0.2.0/24" {
+		t.Fatalf("segments as of %s: %v", at, got)
+	}
+	if h.Undone != 3 || len(h.Gaps) != 1 || len(h.Added) != 1 || len(h.Removed) != 1 || len(h.Changed) != 1 {
+		t.Fatalf("history: undone %d, gaps %d, added %d, removed %d, changed %d", h.Undone, len(h.Gaps), len(h.Added), len(h.Removed), len(h.Changed))
+	}
+	if h, _ = planAsOf(db, projectID, time.Now()); len(h.Segments) != 2 || h.Undone != 0 {
+		t.Fatalf("now: %d segments, %d undone", len(h.Segments), h.Undone)
+	}
+	if _, err := parseAsOf("2999-01-01"); err == nil {
+		t.Fatalf("future as_of accepted")
+	}
+}
+
+func TestComputeSizing(t *testing.T) {
+	cases := []struct {
+		req    SizingRequest
+		prefix int
+		addrs  int
+	}{
+		{SizingRequest{Preset: "aws", Hosts: 27}, 27, 27},   // 27 + 5 reserved fills a /27
+		{SizingRequest{Preset: "aws", Hosts: 28}, 26, 28},   // one more needs a /26
+		{SizingRequest{Preset: "aws", Hosts: 2}, 28, 2},     // AWS subnets are at least /28
+		{SizingRequest{Preset: "gcp", Hosts: 60}, 26, 60},   // 60 + 4
+		{SizingRequest{Preset: "eks", Nodes: 60}, 21, 1800}, // 60 × (29 + 1)
+		{SizingRequest{Preset: "aks", Nodes: 3, PodsPerNode: 110}, 23, 444},
+		{SizingRequest{Preset: "gke", Nodes: 60}, 26, 60},
+	}
+	for _, tc := range cases {
+		res, err := computeSizing(tc.req)
+		if err != nil || res.Prefix != tc.prefix || res.Addresses != tc.addrs {
+			t.Fatalf("%+v: /%d %d addresses (%v), want /%d %d", tc.req, res.Prefix, res.Addresses, err, tc.prefix, tc.addrs)
+		}
+	}
+	res, _ := computeSizing(SizingRequest{Preset: "gke", Nodes: 60})
+	if len(res.Extra) != 2 || res.Extra[0].Prefix != 18 || res.Extra[1].Prefix != 20 || len(res.Warnings()) != 2 {
+		t.Fatalf("gke ranges: %+v", res.Extra)
+	}
+	for _, req := range []SizingRequest{{Preset: "aws", Hosts: 70000}, {Preset: "eks"}, {Preset: "gke", Nodes: 3, PodsPerNode: 500}, {Preset: "openstack", Hosts: 10}} {
+		if _, err := computeSizing(req); err == nil {
+			t.Fatalf("%+v accepted", req)
+		}
+	}
+
+	f := &FormState{Values: url.Values{"sizing": {"aks"}, "nodes": {"x"}}, Errors: map[string]string{}}
+	if _, ok := sizeSegmentForm(f); ok || f.Error("nodes") == "" {
+		t.Fatalf("form: %v", f.Errors)
+	}
+}
+
+func TestChatOps(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:chatops?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Campus')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, '10.30.0.0/24')`, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'PROD', 10, 'users', '10.30.0.0/26')`, siteID)
+	segID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, gateway) VALUES(?, '10.30.0.1')`, segID)
+
+	cases := map[string]string{
+		"whois 10.30.0.1":      "Segment *users*: Campus / HQ, VRF `PROD`, VLAN 10, `10.30.0.0/26` (gateway)",
+		"whois 10.30.0.63":     "(broadcast address)",
+		"whois 10.30.0.200":    "Pool `10.30.0.0/24`: Campus / HQ",
+		"whois 192.0.2.1":      "Nothing in Subnetio holds",
+		"free site=hq /26":     "`10.30.0.64/26`, `10.30.0.128/26`, `10.30.0.192/26` (3 free /26 in total)",
+		"free site=HQ 25":      "`10.30.0.128/25` (1 free /25 in total)",
+		"free site=HQ /24":     "no free /24",
+		"free site=BR /26":     `No site "BR" found.`,
+		"free site=HQ /26 x=1": `Error: unknown option "x"`,
+		"ping":                 `Error: unknown command "ping"`,
+	}
+	for text, want := range cases {
+		if got := runChatOpsCommand(db, text); !strings.Contains(got.Text, want) || got.ResponseType != "ephemeral" {
+			t.Fatalf("%s: %q, want %q", text, got.Text, want)
+		}
+	}
+
+	body := []byte("command=%2Fsubnetio&text=whois+10.30.0.1&token=t0ken")
+	now := time.Unix(1700000000, 0)
+	headers := map[string]string{"X-Slack-Request-Timestamp": "1700000000"}
+	header := func(k string) string { return headers[k] }
+	if err := verifyChatOps(header, body, now); err != errChatOpsDisabled {
+		t.Fatalf("unconfigured endpoint: %v", err)
+	}
+	t.Setenv("CHATOPS_TOKEN", "t0ken")
+	if err := verifyChatOps(header, body, now); err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	t.Setenv("CHATOPS_SIGNING_SECRET", "s1gn")
+	mac := hmac.New(sha256.New, []byte("s1gn"))
+	mac.Write([]byte("v0:1700000000:" + string(body)))
+	headers["X-Slack-Signature"] = "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if err := verifyChatOps(header, body, now); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	if err := verifyChatOps(header, body, now.Add(10*time.Minute)); err == nil {
+		t.Fatalf("stale request accepted")
+	}
+	if err := verifyChatOps(header, append(body, 'x'), now); err == nil {
+		t.Fatalf("tampered body accepted")
+	}
+}
+
+func TestUniquenessRules(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:uniqueness?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Unique')`)
+	projectID, _ := res.LastInsertId()
+	for _, name := range []string{"HQ", "BR"} {
+		res, _ = db.Exec(`INSERT INTO sites(name) VALUES(?)`, name)
+		siteID, _ := res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	}
+	res, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES((SELECT id FROM sites WHERE name='HQ'), '10.0.0.0/16')`)
+	poolID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name) VALUES((SELECT id FROM sites WHERE name='HQ'), 'PROD', 10, 'users')`)
+
+	rules, _ := getProjectRules(db, projectID)
+	if rules.Uniqueness != defaultUniqueness || rules.Unique(UniqueSegmentNames) || !rules.Unique(UniqueSiteNames) {
+		t.Fatalf("default rules: %q", rules.Uniqueness)
+	}
+	if err := checkSiteNameUnique(db, rules, "hq"); err == nil || !strings.Contains(err.Error(), `existing site "HQ"`) {
+		t.Fatalf("site hq: %v", err)
+	}
+	if checkSiteNameUnique(db, rules, "HQ") != nil || checkSiteNameUnique(db, rules, "Lab") != nil {
+		t.Fatalf("exact and new site names must pass")
+	}
+	if err := checkPoolCIDRUnique(db, rules, projectID, "10.0.5.0/16", 0); err == nil || !strings.Contains(err.Error(), "10.0.0.0/16 already exists in this project at site HQ") {
+		t.Fatalf("pool: %v", err)
+	}
+	if checkPoolCIDRUnique(db, rules, projectID, "10.0.0.0/16", poolID) != nil {
+		t.Fatalf("a pool must not collide with itself")
+	}
+	if checkSegmentNameUnique(db, rules, projectID, "PROD", "users", 0) != nil {
+		t.Fatalf("segment names are not unique by default")
+	}
+
+	rules.Uniqueness = uniquenessFromKeys([]string{"segment_names", "bogus"})
+	_ = saveProjectRules(db, projectID, rules)
+	rules, _ = getProjectRules(db, projectID)
+	if rules.Uniqueness != UniqueSegmentNames {
+		t.Fatalf("saved rules: %q", rules.Uniqueness)
+	}
+	if err := checkSegmentNameUnique(db, rules, projectID, "PROD", "USERS", 0); err == nil || !strings.Contains(err.Error(), "site HQ, VLAN 10") {
+		t.Fatalf("segment name: %v", err)
+	}
+	if checkSegmentNameUnique(db, rules, projectID, "DEV", "users", 0) != nil || checkSiteNameUnique(db, rules, "hq") != nil {
+		t.Fatalf("only segment names are unique now")
+	}
+	if uniquenessFromKeys(nil) != uniquenessNone {
+		t.Fatalf("no rules ticked must store none")
+	}
+	if _, err := parseUniqueness("site_names,vlans"); err == nil {
+		t.Fatalf("unknown rule accepted")
+	}
+
+	rules.Uniqueness = defaultUniqueness + "," + UniqueSegmentNames
+	_ = saveProjectRules(db, projectID, rules)
+	vlan, locked := 20, false
+	bundle, _ := buildPlanBundle(db, projectID)
+	if rows := bundle.Rows; len(rows) == 0 || rows[1].RowType != planRowRules || rows[1].Uniqueness != "segment_names,site_names,pool_cidrs" {
+		t.Fatalf("exported rules row: %+v", rows)
+	}
+	bundle.Rows = append(bundle.Rows[:2], []PlanRow{
+		{RowType: planRowPool, Project: "Unique", Site: "BR", Pool: "10.0.0.0/16"},
+		{RowType: planRowSite, Project: "Unique", Site: "br"},
+		{RowType: planRowSegment, Project: "Unique", Site: "BR", VRF: "PROD", VLAN: &vlan, Name: "Users", Locked: &locked},
+		{RowType: planRowPool, Project: "Unique", Site: "BR", Pool: "10.1.0.0/16"},
+	}...)
+	raw, _ := json.Marshal(bundle)
+	report := importPlanData(db, raw, "json", projectID, ImportPolicy{})
+	want := []string{"pool 10.0.0.0/16 already exists", `site name "br" collides`, `segment name "Users" is already used in VRF PROD at site HQ`}
+	if len(report.Errors) != len(want) || report.PoolsAdded != 1 {
+		t.Fatalf("import: %+v", report)
+	}
+	for i, w := range want {
+		if !strings.Contains(report.Errors[i], w) {
+			t.Fatalf("error %d: %q, want %q", i, report.Errors[i], w)
+		}
+	}
+}
+
+func TestUniquenessMigrationKeepsExistingProjects(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "u.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := migrateDown(db, 41); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('WithRules')`)
+	withRules, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_rules(project_id) VALUES(?)`, withRules)
+	res, _ = db.Exec(`INSERT INTO projects(name) VALUES('WithoutRules')`)
+	withoutRules, _ := res.LastInsertId()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	for _, id := range []int64{withRules, withoutRules} {
+		if rules, _ := getProjectRules(db, id); rules.Uniqueness != uniquenessNone {
+			t.Fatalf("existing project %d got uniqueness %q", id, rules.Uniqueness)
+		}
+	}
+	res, _ = db.Exec(`INSERT INTO projects(name) VALUES('New')`)
+	created, _ := res.LastInsertId()
+	if rules, _ := getProjectRules(db, created); rules.Uniqueness != defaultUniqueness {
+		t.Fatalf("new project got uniqueness %q", rules.Uniqueness)
+	}
+}
+
+func TestConfigStorage(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "c.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	big := strings.Repeat("interface Vlan10\n ip address 10.0.0.1 255.255.255.0\n", 200)
+
+	res, _ := db.Exec(`INSERT INTO generations(project_id, kind, template, scope_key, checksum, content, created_at)
+		VALUES(?, 'download', 'cisco', 'project', ?, ?, '2020-01-01T00:00:00Z')`, projectID, checksumSHA256(big), big)
+	legacyID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO deployed_configs(project_id, template, scope_key, content, updated_at) VALUES(?, 'cisco', 'site=HQ', ?, '2020-01-01T00:00:00Z')`, projectID, big)
+	if err := moveInlineConfigs(db); err != nil {
+		t.Fatalf("move inline: %v", err)
+	}
+	var inline, blobs int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM generations WHERE content<>'' OR content_hash IS NULL`).Scan(&inline)
+	_ = db.QueryRow(`SELECT COUNT(*) FROM config_blobs WHERE encoding='gzip'`).Scan(&blobs)
+	if inline != 0 || blobs != 1 {
+		t.Fatalf("inline rows %d, gzip blobs %d", inline, blobs)
+	}
+	if g, ok := generationByID(db, legacyID); !ok || g.Content != big {
+		t.Fatalf("legacy generation content lost")
+	}
+	if cfg, ok, err := getDeployedConfig(db, projectID, "cisco", "site=HQ"); err != nil || !ok || cfg.Content != big {
+		t.Fatalf("deployed config: %v %v", ok, err)
+	}
+	if err := saveDeployedConfig(db, projectID, "cisco", "site=BR", strings.ReplaceAll(big, "\n", "\r\n")); err != nil {
+		t.Fatalf("save deployed: %v", err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	opts := GenerateOptions{Template: "cisco"}
+	for _, out := range []string{"a\n", "b\n", "c\n", "b\n"} {
+		if _, err := recordGeneration(db, c, projectID, generationDownload, opts, GenerateResult{Output: out}); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+	report, err := configStorageReport(db, projectID)
+	if err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if report.Entries != 7 || report.Distinct != 4 || report.Stored >= report.Logical || report.Saved() < 90 || len(report.Rows) != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if err := saveConfigRetention(db, ConfigRetention{ProjectID: projectID, KeepPerScope: -1}); err == nil {
+		t.Fatalf("negative retention accepted")
+	}
+	if err := saveConfigRetention(db, ConfigRetention{ProjectID: projectID, KeepPerScope: 2}); err != nil {
+		t.Fatalf("save retention: %v", err)
+	}
+	_, _ = recordGeneration(db, c, projectID, generationDownload, opts, GenerateResult{Output: "d\n"})
+	var left int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM generations WHERE project_id=?`, projectID).Scan(&left)
+	if left != 2 {
+		t.Fatalf("keep per scope left %d entries", left)
+	}
+	_, _ = db.Exec(`UPDATE generations SET created_at='2020-01-01T00:00:00Z'`)
+	r := ConfigRetention{ProjectID: projectID, MaxAgeDays: 30}
+	if removed, err := applyConfigRetention(db, r, time.Now()); err != nil || removed != 1 {
+		t.Fatalf("max age removed %d: %v", removed, err)
+	}
+	if collected, err := gcConfigBlobs(db); err != nil || collected != 3 {
+		t.Fatalf("collected %d unused blobs: %v", collected, err)
+	}
+	_ = db.QueryRow(`SELECT COUNT(*) FROM config_blobs`).Scan(&blobs)
+	if blobs != 2 {
+		t.Fatalf("blobs left: %d", blobs)
+	}
+}
+
+func TestExportETags(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "etag.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	defaultProjectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('other')`)
+	otherID, _ := res.LastInsertId()
+
+	renders := 0
+	r := gin.New()
+	r.Use(dataVersionMiddleware(db, defaultProjectID))
+	r.GET("/export/json", func(c *gin.Context) { renders++; c.String(200, "plan") })
+	r.GET("/export/dhcp", func(c *gin.Context) { c.String(400, "bad format") })
+	r.POST("/segments", func(c *gin.Context) { c.Redirect(302, "/segments") })
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	plan := "/export/json?project_id=" + itoa64(defaultProjectID)
+	first := get(plan, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != 200 || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first download: %d %q", first.Code, etag)
+	}
+	if w := get(plan, `"other", `+strings.TrimPrefix(etag, "W/")); w.Code != 304 || renders != 1 || w.Body.Len() != 0 {
+		t.Fatalf("revalidation: %d, %d renders", w.Code, renders)
+	}
+	if w := get(plan+"&site=HQ", etag); w.Code != 200 {
+		t.Fatalf("another scope shares the tag: %d", w.Code)
+	}
+	if w := get("/export/dhcp?format=x", ""); w.Code != 400 || w.Header().Get("ETag") != "" {
+		t.Fatalf("error response tagged: %q", w.Header().Get("ETag"))
+	}
+
+	other := "/export/json?project_id=" + itoa64(otherID)
+	otherTag := get(other, "").Header().Get("ETag")
+	_ = insertAuditRecord(db, auditRecord{ProjectID: defaultProjectID, Action: "update", EntityType: "segment"})
+	if w := get(plan, etag); w.Code != 200 || w.Header().Get("ETag") == etag {
+		t.Fatalf("audited change kept the tag: %d", w.Code)
+	}
+	if w := get(other, otherTag); w.Code != 304 {
+		t.Fatalf("change in another project invalidated the tag: %d", w.Code)
+	}
+	_ = insertAuditRecord(db, auditRecord{ProjectID: defaultProjectID, Action: auditActionRead, EntityType: "export"})
+	etag = get(plan, "").Header().Get("ETag")
+	if w := get(plan, etag); w.Code != 304 {
+		t.Fatalf("read entries must not change the tag: %d", w.Code)
+	}
+	req := httptest.NewRequest("POST", "/segments", strings.NewReader("project_id="+itoa64(defaultProjectID)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if w := get(plan, etag); w.Code != 200 {
+		t.Fatalf("unaudited write kept the tag: %d", w.Code)
+	}
+	if w := get(other, otherTag); w.Code != 304 {
+		t.Fatalf("write to another project invalidated the tag: %d", w.Code)
+	}
+}
+
+func TestTemplateVars(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "vars.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	siteIDs := map[string]int64{}
+	for i, name := range []string{"HQ", "DC"} {
+		res, err := db.Exec(`INSERT INTO sites(name) VALUES(?)`, name)
+		if err != nil {
+			t.Fatalf("insert site: %v", err)
+		}
+		siteIDs[name], _ = res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteIDs[name])
+		_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteIDs[name], fmt.Sprintf("10.%d.0.0/24", i))
+		_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, locked, cidr) VALUES(?, ?, ?, ?, ?, ?, ?)`, siteIDs[name], "default", 10, "users", 26, 1, fmt.Sprintf("10.%d.0.0/26", i))
+	}
+
+	for raw, want := range map[string]string{
+		"uplink_iface": "expected name=value",
+		"9lives=1":     "invalid variable name",
+		"a=1\na=2":     "set twice",
+		"a=" + strings.Repeat("x", maxTemplateVarValue+1): "longer than",
+	} {
+		if _, err := parseTemplateVars(raw); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("parse %q: expected %q, got %v", raw, want, err)
+		}
+	}
+	project, err := parseTemplateVars("# defaults\nuplink_iface = ae0\r\n\nmgmt_vlan=99\nbanner=Authorized use only\n")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if project["uplink_iface"] != "ae0" || project["banner"] != "Authorized use only" || len(project) != 3 {
+		t.Fatalf("parsed vars: %v", project)
+	}
+	if err := saveTemplateVars(db, projectID, 0, project); err != nil {
+		t.Fatalf("save project vars: %v", err)
+	}
+	if err := saveTemplateVars(db, projectID, siteIDs["DC"], map[string]string{"uplink_iface": "et-0/0/1"}); err != nil {
+		t.Fatalf("save site vars: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('other')`)
+	otherID, _ := res.LastInsertId()
+	if err := saveTemplateVars(db, otherID, siteIDs["DC"], map[string]string{"x": "1"}); err == nil {
+		t.Fatalf("saved vars of a site of another project")
+	}
+	meta, _ := getProjectMeta(db, projectID)
+	if meta.Vars["mgmt_vlan"] != "99" {
+		t.Fatalf("project vars: %v", meta.Vars)
+	}
+	scopes, err := templateVarScopes(db, projectID)
+	if err != nil || len(scopes) != 3 || scopes[0].SiteID != 0 || !strings.Contains(scopes[0].Text, "mgmt_vlan=99\n") {
+		t.Fatalf("scopes: %+v %v", scopes, err)
+	}
+
+	views, sites, proj, meta := loadGenerateInputs(db, projectID)
+	source := templateSource{Content: `{{.Vars.uplink_iface}}/{{or .Vars.loopback "lo0"}}{{range .Groups}} {{.Site}}:{{.Vars.uplink_iface}}:{{(index .Segments 0).Vars.mgmt_vlan}}{{end}}`, Source: "inline"}
+	out, err := generateFromSource(GenerateOptions{Template: "inline"}, source, views, sites, proj, meta)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if !strings.Contains(out.Output, "ae0/lo0 DC:et-0/0/1:99 HQ:ae0:99") {
+		t.Fatalf("output: %q", out.Output)
+	}
+	out, _ = generateFromSource(GenerateOptions{Template: "inline", SiteFilter: "DC"}, source, views, sites, proj, meta)
+	if !strings.Contains(out.Output, "et-0/0/1/lo0 DC:et-0/0/1:99") {
+		t.Fatalf("site output: %q", out.Output)
+	}
+
+	if err := saveTemplateVars(db, projectID, siteIDs["DC"], nil); err != nil {
+		t.Fatalf("clear site vars: %v", err)
+	}
+	sites, _ = listSites(db, projectID)
+	for _, site := range sites {
+		if len(site.Vars) != 0 {
+			t.Fatalf("site %s keeps vars: %v", site.Name, site.Vars)
+		}
+	}
+}
+
+func TestOwnerNotifications(t *testing.T) {
+	var hooked []notificationPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notificationPayload
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		hooked = append(hooked, p)
+	}))
+	defer srv.Close()
+	mailed := map[string]string{}
+	prevMail := sendNotificationMail
+	sendNotificationMail = func(to, subject, text string) error {
+		mailed[to] = text
+		return nil
+	}
+	defer func() { sendNotificationMail = prevMail }()
+
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "owners.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	siteIDs := map[string]int64{}
+	for name, pool := range map[string]string{"HQ": "10.0.0.0/24", "DC": "10.1.0.0/25"} {
+		res, err := db.Exec(`INSERT INTO sites(name) VALUES(?)`, name)
+		if err != nil {
+			t.Fatalf("insert site: %v", err)
+		}
+		siteIDs[name], _ = res.LastInsertId()
+		_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteIDs[name])
+		_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteIDs[name], pool)
+	}
+	for _, seg := range []struct {
+		site, name, cidr string
+		vlan             int
+	}{{"HQ", "users", "10.0.0.0/24", 10}, {"HQ", "voice", "10.0.0.0/25", 20}, {"DC", "servers", "10.1.0.0/25", 10}} {
+		res, err := db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, 'default', ?, ?, ?)`, siteIDs[seg.site], seg.vlan, seg.name, seg.cidr)
+		if err != nil {
+			t.Fatalf("insert segment: %v", err)
+		}
+		if seg.name == "voice" {
+			segID, _ := res.LastInsertId()
+			_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, owner_team, owner_email) VALUES(?, 'voip', 'voip@example.com')`, segID)
+		}
+	}
+	if err := saveSiteOwner(db, siteIDs["HQ"], "netops", "not an address"); err == nil {
+		t.Fatalf("accepted a bad owner email")
+	}
+	if err := saveSiteOwner(db, siteIDs["HQ"], " netops ", "netops@example.com"); err != nil {
+		t.Fatalf("save site owner: %v", err)
+	}
+
+	sites, _ := listSites(db, projectID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	_, conflicts := analyzeAll(segs, pools, sites, rules)
+	var overlap Conflict
+	for _, c := range conflicts {
+		if c.Kind == "OVERLAP" {
+			overlap = c
+		}
+	}
+	owners := conflictOwners(overlap, segs, pools, sites)
+	if len(owners) != 2 || owners[0].Label() != "netops <netops@example.com>" || owners[1].Label() != "voip <voip@example.com>" {
+		t.Fatalf("conflict owners of %+v: %v", overlap, owners)
+	}
+	gap := Conflict{Kind: "POOL_GAP", Detail: "pool 10.0.0.0/24 free block 10.0.0.128/25"}
+	if owners := conflictOwners(gap, segs, pools, sites); len(owners) != 1 || owners[0].Team != "netops" {
+		t.Fatalf("pool conflict owners: %v", owners)
+	}
+	report := buildCapacityReport(segs, pools, sites, 0, 0, 64)
+	for _, p := range report.Pools {
+		if !p.Warning || (p.Site == "HQ") != (p.Owner.Team == "netops") {
+			t.Fatalf("capacity pool: %+v", p)
+		}
+	}
+
+	routes, err := parseNotificationRoutes("netops: " + srv.URL + "/netops, netops@example.com\n# rest\n*: noc@example.com\n")
+	if err != nil || len(routes) != 3 {
+		t.Fatalf("parse routes: %v %v", routes, err)
+	}
+	if _, err := parseNotificationRoutes("netops: ftp://x"); err == nil {
+		t.Fatalf("accepted a route that is neither URL nor mail")
+	}
+	if err := saveNotificationRoutes(db, projectID, routes); err != nil {
+		t.Fatalf("save routes: %v", err)
+	}
+	if got, _ := listNotificationRoutes(db, projectID); formatNotificationRoutes(got) != "netops: "+srv.URL+"/netops, netops@example.com\n*: noc@example.com\n" {
+		t.Fatalf("routes: %q", formatNotificationRoutes(got))
+	}
+	if teams, _ := ownerTeams(db, projectID); strings.Join(notificationTeamsWithoutRoute(teams, routes), ",") != "voip" {
+		t.Fatalf("teams without route: %v", teams)
+	}
+
+	project := Project{ID: projectID, Name: "default"}
+	dry, err := sendProjectNotifications(db, project, true, time.Now())
+	if err != nil || dry.Sent != 0 || len(hooked) != 0 || len(dry.Deliveries) != 4 {
+		t.Fatalf("dry run: %+v %v", dry, err)
+	}
+	result, err := sendProjectNotifications(db, project, false, time.Now())
+	if err != nil || len(result.Errors) > 0 || result.Sent != result.Pending || result.Unrouted != 0 {
+		t.Fatalf("notify: %+v %v", result, err)
+	}
+	if len(hooked) != 1 || strings.Join(hooked[0].Teams, ",") != "netops" || len(hooked[0].Events) != 2 {
+		t.Fatalf("webhook: %+v", hooked)
+	}
+	if !strings.Contains(mailed["voip@example.com"], "OVERLAP") || !strings.Contains(mailed["noc@example.com"], "pool 10.1.0.0/25 at DC") || strings.Contains(mailed["noc@example.com"], "OVERLAP") {
+		t.Fatalf("mails: %v", mailed)
+	}
+	again, err := sendProjectNotifications(db, project, false, time.Now())
+	if err != nil || again.Pending != 0 || again.Sent != 0 || len(hooked) != 1 {
+		t.Fatalf("second run: %+v %v", again, err)
+	}
+	var voiceID int64
+	_ = db.QueryRow(`SELECT id FROM segments WHERE name='voice'`).Scan(&voiceID)
+	if err := deleteSegmentTx(db, voiceID); err != nil {
+		t.Fatalf("delete segment: %v", err)
+	}
+	if _, err := sendProjectNotifications(db, project, false, time.Now()); err != nil {
+		t.Fatalf("third run: %v", err)
+	}
+	var kept int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM notifications_sent WHERE project_id=? AND event_key LIKE 'conflict:%'`, projectID).Scan(&kept)
+	if kept != 0 {
+		t.Fatalf("resolved conflict still marked as sent")
+	}
+}
+
+func TestPoolSpaceViews(t *testing.T) {
+	sites := []Site{{ID: 1, Name: "HQ", ReservedRanges: sql.NullString{String: "10.0.0.0/28, 2001:db8:0:ff::/64, 10.9.0.0/24, bogus", Valid: true}}}
+	pools := []Pool{
+		{ID: 1, SiteID: 1, Site: "HQ", CIDR: "10.0.0.0/24", Family: "ipv4"},
+		{ID: 2, SiteID: 1, Site: "HQ", CIDR: "2001:db8::/48", Family: "ipv6"},
+	}
+	segs := []Segment{{ID: 1, SiteID: 1, Site: "HQ", Name: "users",
+		CIDR: sql.NullString{String: "10.0.0.64/26", Valid: true}, CIDRV6: sql.NullString{String: "2001:db8::/64", Valid: true}}}
+
+	byPool := capacityByPool(buildCapacityReport(segs, pools, sites, 0, 0, 64))
+	v4, v6 := byPool[1], byPool[2]
+	if strings.Join(v4.Reserved, ",") != "10.0.0.0/28" || strings.Join(v4.FreeBlocks, ",") != "10.0.0.128/25,10.0.0.32/27,10.0.0.16/28" || v4.Gaps != 2 {
+		t.Fatalf("v4 pool: %+v", v4)
+	}
+	if strings.Join(v6.Reserved, ",") != "2001:db8:0:ff::/64" || v6.Gaps != 2 ||
+		strings.Join(v6.FreeBlocks, ",") != "2001:db8:0:8000::/49,2001:db8:0:4000::/50,2001:db8:0:2000::/51" {
+		t.Fatalf("v6 pool: %+v", v6)
+	}
+	if got := bigRangeBlocks(bigRange{start: big.NewInt(1), end: big.NewInt(1 << 20)}, 108); len(got) != 0 {
+		t.Fatalf("range below one unit: %v", got)
+	}
+
+	reserved := siteReservedRanges(sites, pools)[1]
+	want := []ReservedRange{
+		{CIDR: "10.0.0.0/28", Family: "ipv4", Pool: "10.0.0.0/24"},
+		{CIDR: "2001:db8:0:ff::/64", Family: "ipv6", Pool: "2001:db8::/48"},
+		{CIDR: "10.9.0.0/24", Family: "ipv4"},
+		{CIDR: "bogus", Invalid: true},
+	}
+	if !slices.Equal(reserved, want) {
+		t.Fatalf("reserved ranges: %+v", reserved)
+	}
+}
+
+func TestDBMaintenance(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "vacuum.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE churn(v TEXT)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		_, _ = db.Exec(`INSERT INTO churn(v) VALUES(?)`, strings.Repeat("x", 4000))
+	}
+	_, _ = db.Exec(`DELETE FROM churn`)
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	run, err := runDBMaintenance(db, "manual", now)
+	if err != nil || !run.OK() || run.ID == 0 {
+		t.Fatalf("run: %+v %v", run, err)
+	}
+	if run.FreeBefore < 500_000 || run.SizeAfter >= run.SizeBefore-run.FreeBefore/2 {
+		t.Fatalf("vacuum did not shrink the file: %+v", run)
+	}
+	if !strings.HasPrefix(run.Summary(), "integrity ok, ") {
+		t.Fatalf("summary: %s", run.Summary())
+	}
+	if due, _ := dbMaintenanceDue(db, 24*time.Hour, now.Add(time.Hour)); due {
+		t.Fatalf("maintenance due an hour after a run")
+	}
+	if due, _ := dbMaintenanceDue(db, 24*time.Hour, now.Add(25*time.Hour)); !due {
+		t.Fatalf("maintenance not due after the interval")
+	}
+
+	dbMaintenanceMu.Lock()
+	_, err = runDBMaintenance(db, "scheduled", now)
+	dbMaintenanceMu.Unlock()
+	if !errors.Is(err, errDBMaintenanceRunning) {
+		t.Fatalf("overlapping run: %v", err)
+	}
+	for i := 0; i < dbMaintenanceKeepRuns; i++ {
+		if _, err := runDBMaintenance(db, "scheduled", now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	runs, err := listDBMaintenanceRuns(db)
+	if err != nil || len(runs) != dbMaintenanceKeepRuns || runs[0].Trigger != "scheduled" || runs[len(runs)-1].ID == run.ID {
+		t.Fatalf("kept runs: %d %v", len(runs), err)
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "idem.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	calls := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r := gin.New()
+	r.Use(idempotencyMiddleware(db, time.Hour))
+	r.POST("/things", func(c *gin.Context) {
+		calls++
+		c.Header("Location", "/things/"+itoa(calls))
+		c.JSON(201, gin.H{"id": calls})
+	})
+	r.POST("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(200, "done")
+	})
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set(idempotencyHeader, key)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("/things", "k1", `{"name":"a"}`)
+	replay := post("/things", "k1", `{"name":"a"}`)
+	if first.Code != 201 || replay.Code != 201 || calls != 1 || replay.Body.String() != first.Body.String() ||
+		replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("Location") != "/things/1" {
+		t.Fatalf("replay: %d %d calls=%d %q %v", first.Code, replay.Code, calls, replay.Body.String(), replay.Header())
+	}
+	if w := post("/things", "k1", `{"name":"b"}`); w.Code != 422 || calls != 1 {
+		t.Fatalf("reused key with another payload: %d calls=%d", w.Code, calls)
+	}
+	if w := post("/things", "big", strings.Repeat("x", maxIdempotentBody+1)); w.Code != 413 || calls != 1 {
+		t.Fatalf("oversized body: %d calls=%d", w.Code, calls)
+	}
+
+	_, _ = db.Exec(`UPDATE idempotency_keys SET created_at=? WHERE key='k1'`, time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339))
+	if w := post("/things", "k1", `{"name":"b"}`); w.Code != 201 || calls != 2 || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expired key should run again: %d calls=%d", w.Code, calls)
+	}
+	var keys int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM idempotency_keys`).Scan(&keys)
+	if keys != 1 {
+		t.Fatalf("expected the expired key to be purged and stored again, got %d rows", keys)
+	}
+
+	// a slow request only holds its own key
+	done := make(chan int)
+	go func() { done <- post("/slow", "k-slow", "").Code }()
+	<-started
+	other := make(chan int)
+	go func() { other <- post("/things", "k2", `{}`).Code }()
+	select {
+	case code := <-other:
+		if code != 201 {
+			t.Fatalf("other key: %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a request with another key waited for the slow one")
+	}
+	close(release)
+	if code := <-done; code != 200 {
+		t.Fatalf("slow request: %d", code)
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	cases := []struct {
+		cfg     TLSConfig
+		wantErr bool
+		scheme  string
+	}{
+		{TLSConfig{}, false, "http"},
+		{TLSConfig{CertFile: "c.pem", KeyFile: "k.pem"}, false, "https"},
+		{TLSConfig{CertFile: "c.pem"}, true, "http"},
+		{TLSConfig{KeyFile: "k.pem"}, true, "http"},
+		{TLSConfig{ACMEDomains: []string{"ipam.example"}}, false, "https"},
+		{TLSConfig{ACMEDomains: []string{"ipam.example"}, CertFile: "c.pem", KeyFile: "k.pem"}, true, "https"},
+	}
+	for i, tc := range cases {
+		if err := tc.cfg.Validate(); (err != nil) != tc.wantErr {
+			t.Fatalf("case %d validate: %v", i, err)
+		}
+		if got := tc.cfg.Scheme(); got != tc.scheme {
+			t.Fatalf("case %d scheme: %s", i, got)
+		}
+	}
+
+	redirects := []struct{ listen, host, target, want string }{
+		{":443", "ipam.example", "/sites?x=1", "https://ipam.example/sites?x=1"},
+		{":443", "ipam.example:80", "/", "https://ipam.example/"},
+		{":8443", "ipam.example:8080", "/a", "https://ipam.example:8443/a"},
+		{"[::]:8443", "[2001:db8::1]:8080", "/a", "https://[2001:db8::1]:8443/a"},
+		{":443", "[2001:db8::1]", "/", "https://[2001:db8::1]/"},
+	}
+	for _, tc := range redirects {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tc.target, nil)
+		req.Host = tc.host
+		httpsRedirectHandler(tc.listen).ServeHTTP(w, req)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.want {
+			t.Fatalf("redirect %s %s: %d %s", tc.listen, tc.host, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	r := gin.New()
+	r.Use(hstsMiddleware(600))
+	r.GET("/", func(c *gin.Context) { c.String(200, "ok") })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=600; includeSubDomains" {
+		t.Fatalf("hsts header: %q", got)
+	}
+}
+
+func TestDHCPPolicies(t *testing.T) {
+	policies := []DHCPPolicy{
+		{Tag: "pxe", Priority: 10, DhcpLeaseTime: sql.NullInt64{Int64: 3600, Valid: true}, DhcpBootFile: sql.NullString{String: "pxe.efi", Valid: true},
+			DhcpVendorOpts: sql.NullString{String: "option arch code 93 = unsigned integer 16;", Valid: true}},
+		{Tag: "voice", Priority: 20, DhcpLeaseTime: sql.NullInt64{Int64: 7200, Valid: true}, DhcpNextServer: sql.NullString{String: "10.0.0.5", Valid: true},
+			DhcpVendorOpts: sql.NullString{String: "option tftp-server-name \"tftp.example\";", Valid: true}},
+		{Tag: "iot", Priority: 30, DhcpLeaseTime: sql.NullInt64{Int64: 60, Valid: true}},
+	}
+	seg := Segment{Tags: sql.NullString{String: " Voice, PXE ,printers", Valid: true}}
+	matched := matchDHCPPolicies(seg, policies)
+	if strings.Join(dhcpPolicyTags(matched), ",") != "pxe,voice" {
+		t.Fatalf("matched policies: %v", dhcpPolicyTags(matched))
+	}
+	if got := matchDHCPPolicies(Segment{}, policies); len(got) != 0 {
+		t.Fatalf("untagged segment matched %v", dhcpPolicyTags(got))
+	}
+
+	base := DHCPOptions{LeaseTime: 86400, BootFile: "site.efi", VendorOptions: []string{"option site-opt 1;"}}
+	opts := applyDHCPPolicies(base, matched, "corp.example")
+	if opts.LeaseTime != 7200 || opts.BootFile != "pxe.efi" || opts.NextServer != "10.0.0.5" || len(opts.VendorOptions) != 3 || opts.VendorOptions[0] != "option site-opt 1;" {
+		t.Fatalf("applied options: %+v", opts)
+	}
+	if len(base.VendorOptions) != 1 {
+		t.Fatalf("base options changed: %+v", base)
+	}
+
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "policy.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	res, _ := db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, '', 10, 'phones', '10.0.10.0/24')`, siteID)
+	phonesID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, cidr) VALUES(?, '', 20, 'users', '10.0.20.0/24')`, siteID)
+	usersID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO segment_meta(segment_id, dhcp_enabled, tags) VALUES(?, 1, 'voice,pxe'), (?, 1, NULL)`, phonesID, usersID)
+	for _, p := range policies {
+		p.ProjectID = projectID
+		if _, err := saveDHCPPolicy(db, p); err != nil {
+			t.Fatalf("save policy %s: %v", p.Tag, err)
+		}
+	}
+	out, err := generateForProject(db, projectID, GenerateOptions{Template: "isc-dhcp", IncludeDHCP: true})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	phones, users, _ := strings.Cut(out.Output, "subnet 10.0.20.0")
+	for _, want := range []string{"default-lease-time 7200;", `filename "pxe.efi";`, "next-server 10.0.0.5;", `option tftp-server-name "tftp.example";`} {
+		if !strings.Contains(phones, want) {
+			t.Fatalf("tagged subnet missing %q:\n%s", want, out.Output)
+		}
+	}
+	if strings.Contains(users, "pxe.efi") || strings.Contains(users, "default-lease-time") {
+		t.Fatalf("untagged subnet got policy options:\n%s", out.Output)
+	}
+}
+
+func TestAuditNDJSONCursor(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "stream.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	projectID, _ := ensureDefaultProject(db)
+	for i := 0; i < 5; i++ {
+		if err := insertAuditRecord(db, auditRecord{ProjectID: projectID, Actor: "tester", Action: "update", EntityType: "segment",
+			EntityID: sql.NullInt64{Int64: int64(i + 1), Valid: true}}); err != nil {
+			t.Fatalf("insert audit: %v", err)
+		}
+	}
+	_, _ = db.Exec(`UPDATE audit_log SET created_at='2025-01-01T00:00:00Z' WHERE id <= 2`)
+	_, _ = db.Exec(`UPDATE audit_log SET created_at='2025-06-01T00:00:00Z' WHERE id > 2`)
+
+	r := gin.New()
+	r.GET("/export/audit/ndjson", func(c *gin.Context) {
+		cursor, err := parseAuditCursor(c)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+		if err := exportAuditNDJSON(c, db, projectID, cursor); err != nil {
+			c.String(500, err.Error())
+		}
+	})
+	stream := func(query string) (int, []int64, string) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/export/audit/ndjson?"+query, nil))
+		var ids []int64
+		dec := json.NewDecoder(w.Body)
+		for w.Code == 200 && dec.More() {
+			var row auditStreamRecord
+			if err := dec.Decode(&row); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			ids = append(ids, row.ID)
+		}
+		return w.Code, ids, w.Header().Get("X-Audit-Next-Since-Id")
+	}
+
+	if code, ids, next := stream("limit=2"); code != 200 || fmt.Sprint(ids) != "[1 2]" || next != "2" {
+		t.Fatalf("first page: %d %v next=%s", code, ids, next)
+	}
+	if code, ids, next := stream("since_id=2&limit=2"); code != 200 || fmt.Sprint(ids) != "[3 4]" || next != "4" {
+		t.Fatalf("second page: %d %v next=%s", code, ids, next)
+	}
+	if code, ids, next := stream("since_id=5"); code != 200 || len(ids) != 0 || next != "5" {
+		t.Fatalf("caught up: %d %v next=%s", code, ids, next)
+	}
+	if code, ids, next := stream("since_time=2025-03-01T00:00:00Z"); code != 200 || fmt.Sprint(ids) != "[3 4 5]" || next != "5" {
+		t.Fatalf("since_time: %d %v next=%s", code, ids, next)
+	}
+	for _, bad := range []string{"since_id=-1", "since_id=x", "since_time=yesterday", "limit=0", "limit=many"} {
+		if code, _, _ := stream(bad); code != 400 {
+			t.Fatalf("%s: expected 400, got %d", bad, code)
+		}
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?limit=999999", nil)
+	if cursor, err := parseAuditCursor(c); err != nil || cursor.Limit != auditStreamMaxLimit {
+		t.Fatalf("limit cap: %+v %v", cursor, err)
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	for raw, want := range map[string]string{"": "", "/": "", " ipam ": "/ipam", "/ipam/": "/ipam", "tools/ipam/": "/tools/ipam"} {
+		if got := normalizeBasePath(raw); got != want {
+			t.Fatalf("normalizeBasePath(%q) = %q", raw, got)
+		}
+	}
+
+	saved := basePath
+	defer func() { basePath = saved }()
+	basePath = ""
+	if withBase("/sites") != "/sites" || cookiePath() != "/" {
+		t.Fatalf("empty base: %s %s", withBase("/sites"), cookiePath())
+	}
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { _, _ = w.Write([]byte(req.URL.Path)) })
+	w := httptest.NewRecorder()
+	basePathHandler(inner).ServeHTTP(w, httptest.NewRequest("GET", "/sites", nil))
+	if w.Body.String() != "/sites" {
+		t.Fatalf("no base should pass through: %q", w.Body.String())
+	}
+
+	basePath = "/ipam"
+	if withBase("/sites?x_ok=1") != "/ipam/sites?x_ok=1" || withBase("https://example.com/") != "https://example.com/" || cookiePath() != "/ipam/" {
+		t.Fatalf("with base: %s %s %s", withBase("/sites?x_ok=1"), withBase("https://example.com/"), cookiePath())
+	}
+	h := basePathHandler(inner)
+	cases := []struct{ target, body, location string }{
+		{"/ipam/sites", "/sites", ""},
+		{"/ipam", "", "/ipam/"},
+		{"/ipam?project_id=2", "", "/ipam/?project_id=2"},
+		{"/sites", "404 page not found\n", ""},
+		{"/ipamx/sites", "404 page not found\n", ""},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tc.target, nil))
+		if tc.location != "" {
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tc.location {
+				t.Fatalf("%s: %d %s", tc.target, w.Code, w.Header().Get("Location"))
+			}
+		} else if w.Body.String() != tc.body {
+			t.Fatalf("%s: %d %q", tc.target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestNotificationMail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 test ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				received <- data.String()
+				reply("250 queued")
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 test")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	msg := notificationMailMessage("ipam@example.com", "net@example.com", "2 open\r\nBcc: victim@example.com", "line one\nline two")
+	if err := sendSMTPMail(ln.Addr().String(), nil, "ipam@example.com", "net@example.com", msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	got := <-received
+	if !strings.Contains(got, "Subject: 2 open  Bcc: victim@example.com\r\n") || strings.Contains(got, "\r\nBcc:") ||
+		!strings.Contains(got, "line one\r\nline two") {
+		t.Fatalf("message:\n%s", got)
+	}
+	if err := sendSMTPMail("127.0.0.1:1", nil, "a@example.com", "b@example.com", msg); err == nil {
+		t.Fatalf("expected an unreachable server to fail")
+	}
+}
+
+func TestArchiveGuard(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "archive.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	defaultID, err := ensureDefaultProject(db)
+	if err != nil {
+		t.Fatalf("default project: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name, archived) VALUES('Frozen', 1)`)
+	frozenID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, frozenID, siteID)
+	res, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name) VALUES(?, 'default', 10, 'users')`, siteID)
+	segID, _ := res.LastInsertId()
+
+	parsed := false
+	r := gin.New()
+	r.Use(archiveGuard(db, defaultID))
+	r.POST("/segments/update", func(c *gin.Context) { c.String(200, "ok") })
+	r.POST("/import/csv", func(c *gin.Context) {
+		parsed = c.Request.MultipartForm != nil
+		c.String(200, "ok")
+	})
+	post := func(path, contentType, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	form := "application/x-www-form-urlencoded"
+	if code := post("/segments/update", form, "segment_id="+itoa64(segID)+"&project_id="+itoa64(defaultID)); code != http.StatusConflict {
+		t.Fatalf("update of an archived project's segment: %d", code)
+	}
+	if code := post("/segments/update", form, "segment_id=999&project_id="+itoa64(defaultID)); code != 200 {
+		t.Fatalf("update resolved through the active project: %d", code)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("project_id", itoa64(frozenID))
+	_ = mw.Close()
+	if code := post("/import/csv", mw.FormDataContentType(), body.String()); code != 200 {
+		t.Fatalf("import into the active project: %d", code)
+	}
+	if parsed {
+		t.Fatalf("archive guard parsed the upload")
+	}
+}
+
+func TestAddressMap(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "map.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Map')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, "10.50.0.0/24")
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'default', 10, 'users', 26, '10.50.0.64/26')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, cidr) VALUES(?, 'default', 20, 'voice', 26, '10.50.0.192/26')`, siteID)
+
+	sites, _ := listSites(db, projectID)
+	segs, _ := listSegments(db, projectID)
+	pools, _ := listPools(db, projectID)
+	rules, _ := getProjectRules(db, projectID)
+	statuses, _ := analyzeAll(segs, pools, sites, rules)
+	project, _ := projectByID(db, projectID)
+	m := buildAddressMap(project, sites, pools, buildSegmentViews(segs, statuses, pools))
+
+	if len(m.Sites) != 1 || len(m.Sites[0].Pools) != 1 {
+		t.Fatalf("map layout: %+v", m)
+	}
+	pool := m.Sites[0].Pools[0]
+	if pool.CIDR != "10.50.0.0/24" || pool.Addresses != "256" || pool.Used != "128" || pool.Utilization != 50 {
+		t.Fatalf("pool usage: %+v", pool)
+	}
+	if len(pool.Blocks) != 2 || pool.Blocks[0].CIDR != "10.50.0.64/26" || pool.Blocks[1].CIDR != "10.50.0.192/26" {
+		t.Fatalf("used blocks: %+v", pool.Blocks)
+	}
+	var free [][2]float64
+	next := 0.0
+	for _, b := range pool.Blocks {
+		if b.Offset > next {
+			free = append(free, [2]float64{next, b.Offset})
+		}
+		next = b.Offset + b.Fraction
+	}
+	if next < 1 {
+		free = append(free, [2]float64{next, 1})
+	}
+	if want := [][2]float64{{0, 0.25}, {0.5, 0.75}}; !slices.Equal(free, want) {
+		t.Fatalf("free ranges: %v, want %v", free, want)
+	}
+
+	svg := string(renderAddressMapSVG(m))
+	for _, want := range []string{"<svg", "HQ", "10.50.0.0/24", "10.50.0.64/26", "10.50.0.192/26", "50.0% used"} {
+		if !strings.Contains(svg, want) {
+			t.Fatalf("svg lacks %q", want)
+		}
+	}
+}
+
+func TestGenerationHistory(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "history.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('History')`)
+	projectID, _ := res.LastInsertId()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/generate/download?template=cisco", nil)
+	sites := []Site{{ID: 1, Name: "HQ"}}
+	opts := GenerateOptions{Template: "cisco", IncludeVLAN: true}
+	var outputs []string
+	for _, vlan := range []int{10, 20} {
+		views := []SegmentView{{
+			Segment: Segment{ID: 1, SiteID: 1, Site: "HQ", VRF: "PROD", VLAN: vlan, Name: "users"},
+			CIDR:    "10.10.0.0/24",
+		}}
+		result, err := generateConfig(opts, views, sites, Project{ID: projectID, Name: "History"}, ProjectMeta{})
+		if err != nil {
+			t.Fatalf("generate: %v", err)
+		}
+		recordGenerationAudit(db, c, projectID, generationDownload, opts, result)
+		outputs = append(outputs, result.Output)
+	}
+	if outputs[0] == outputs[1] {
+		t.Fatalf("both generations rendered the same output")
+	}
+
+	history, err := listGenerations(db, projectID, 0)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("history: %d rows, %v", len(history), err)
+	}
+	if history[0].ID <= history[1].ID {
+		t.Fatalf("history not newest first: %d, %d", history[0].ID, history[1].ID)
+	}
+	for i, g := range history {
+		want := outputs[len(outputs)-1-i]
+		if g.Kind != generationDownload || g.Template != "cisco" || g.Checksum != checksumSHA256(want) || g.CreatedAt == "" {
+			t.Fatalf("history row %d: %+v", i, g)
+		}
+		stored, ok := generationByID(db, g.ID)
+		if !ok || stored.Content != want {
+			t.Fatalf("generation %d does not re-download what was produced", g.ID)
+		}
+	}
+	var audited int
+	_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE entity_type='generation' AND project_id=?`, projectID).Scan(&audited)
+	if audited != 2 {
+		t.Fatalf("audited generations: %d", audited)
+	}
+}
+
+func TestAllocationCheck(t *testing.T) {
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "check.sqlite")))
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer db.Close()
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	res, _ := db.Exec(`INSERT INTO projects(name) VALUES('Checked')`)
+	projectID, _ := res.LastInsertId()
+	res, _ = db.Exec(`INSERT INTO sites(name) VALUES('HQ')`)
+	siteID, _ := res.LastInsertId()
+	_, _ = db.Exec(`INSERT INTO project_sites(project_id, site_id) VALUES(?, ?)`, projectID, siteID)
+	_, _ = db.Exec(`INSERT INTO pools(site_id, cidr) VALUES(?, ?)`, siteID, "10.30.0.0/24")
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, prefix, locked, cidr) VALUES(?, 'MGMT', 20, 'mgmt', 26, 1, '10.30.0.64/26')`, siteID)
+	_, _ = db.Exec(`INSERT INTO segments(site_id, vrf, vlan, name, hosts) VALUES(?, 'PROD', 10, 'users', 60)`, siteID)
+
+	var proposed []Segment
+	rejected := &validatorError{Message: "rejected by validator: no"}
+	err = allocateProject(db, projectID, func(before, after []Segment) error {
+		// No write transaction may be open while an external check runs.
+		if _, err := db.Exec(`UPDATE projects SET name=name WHERE id=?`, projectID); err != nil {
+			t.Errorf("write during the check: %v", err)
+		}
+		proposed = after
+		return rejected
+	})
+	if err != rejected {
+		t.Fatalf("expected rejected allocation, got %v", err)
+	}
+	var users Segment
+	for _, s := range proposed {
+		if s.Name == "users" {
+			users = s
+		}
+	}
+	if !users.CIDR.Valid || !strings.HasPrefix(users.CIDR.String, "10.30.0.") {
+		t.Fatalf("check did not see the planned prefix: %+v", users.CIDR)
+	}
+	var stored sql.NullString
+	_ = db.QueryRow(`SELECT cidr FROM segments WHERE name='users'`).Scan(&stored)
+	if stored.Valid {
+		t.Fatalf("rejected allocation was written: %s", stored.String)
+	}
+
+	if err := allocateProject(db, projectID, func(before, after []Segment) error { return nil }); err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	_ = db.QueryRow(`SELECT cidr FROM segments WHERE name='users'`).Scan(&stored)
+	if stored.String != users.CIDR.String {
+		t.Fatalf("written prefix %q differs from the checked %q", stored.String, users.CIDR.String)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ProjectSummary backs /api/v1/projects/:id/summary: the numbers a
+// dashboard needs in one call.
+type ProjectSummary struct {
+	Project        summaryProject     `json:"project"`
+	GeneratedAt    string             `json:"generated_at"`
+	Sites          int                `json:"sites"`
+	Segments       summarySegments    `json:"segments"`
+	Pools          summaryPools       `json:"pools"`
+	Conflicts      summaryConflicts   `json:"conflicts"`
+	LastAllocation *summaryAuditEvent `json:"last_allocation"`
+	LastImport     *summaryAuditEvent `json:"last_import"`
+}
+
+type summaryProject struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	Archived bool   `json:"archived"`
+	Sandbox  bool   `json:"sandbox"`
+}
+
+type summarySegments struct {
+	Total       int            `json:"total"`
+	Allocated   int            `json:"allocated"`
+	Unallocated int            `json:"unallocated"`
+	Locked      int            `json:"locked"`
+	ByStatus    map[string]int `json:"by_status"`
+}
+
+type summaryUtilization struct {
+	Total       string `json:"total"`
+	Used        string `json:"used"`
+	Free        string `json:"free"`
+	Utilization string `json:"utilization"`
+}
+
+type summaryPool struct {
+	Site        string `json:"site"`
+	CIDR        string `json:"cidr"`
+	Family      string `json:"family"`
+	Tier        string `json:"tier,omitempty"`
+	Total       string `json:"total"`
+	Used        string `json:"used"`
+	Utilization string `json:"utilization"`
+}
+
+type summaryPools struct {
+	Total int                `json:"total"`
+	IPv4  summaryUtilization `json:"ipv4"`
+	IPv6  summaryUtilization `json:"ipv6"`
+	Items []summaryPool      `json:"items"`
+}
+
+type summaryConflicts struct {
+	Total   int            `json:"total"`
+	ByLevel map[string]int `json:"by_level"`
+	ByKind  map[string]int `json:"by_kind"`
+}
+
+type summaryAuditEvent struct {
+	At         string `json:"at"`
+	Actor      string `json:"actor"`
+	EntityType string `json:"entity_type"`
+}
+
+func buildProjectSummary(db *sql.DB, project Project) (ProjectSummary, error) {
+	sites, err := listSites(db, project.ID)
+	if err != nil {
+		return ProjectSummary{}, err
+	}
+	segs, err := listSegments(db, project.ID)
+	if err != nil {
+		return ProjectSummary{}, err
+	}
+	pools, err := listPools(db, project.ID)
+	if err != nil {
+		return ProjectSummary{}, err
+	}
+	rules, _ := getProjectRules(db, project.ID)
+	statuses, conflicts := analyzeAll(segs, pools, sites, rules)
+	storeConflictCounts(db, project.ID, conflicts)
+	capacity := buildCapacityReport(segs, pools, sites, 0, 0, 64)
+
+	out := ProjectSummary{
+		Project:     summaryProject{ID: project.ID, Name: project.Name, Archived: project.Archived, Sandbox: project.Sandbox},
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Sites:       len(sites),
+		Segments: summarySegments{
+			Total:    len(segs),
+			ByStatus: map[string]int{statusOK.Label(): 0, statusWarning.Label(): 0, statusConflict.Label(): 0},
+		},
+		Pools: summaryPools{
+			Total: len(pools),
+			IPv4:  summaryUtilization(capacity.SummaryV4),
+			IPv6:  summaryUtilization(capacity.SummaryV6),
+			Items: []summaryPool{},
+		},
+		Conflicts: summaryConflicts{Total: len(conflicts), ByLevel: map[string]int{}, ByKind: map[string]int{}},
+	}
+	for _, s := range segs {
+		if (s.CIDR.Valid && strings.TrimSpace(s.CIDR.String) != "") || (s.CIDRV6.Valid && strings.TrimSpace(s.CIDRV6.String) != "") {
+			out.Segments.Allocated++
+		} else {
+			out.Segments.Unallocated++
+		}
+		if s.Locked {
+			out.Segments.Locked++
+		}
+		out.Segments.ByStatus[statuses[s.ID].Level.Label()]++
+	}
+	for _, p := range capacity.Pools {
+		out.Pools.Items = append(out.Pools.Items, summaryPool{
+			Site: p.Site, CIDR: p.CIDR, Family: p.Family, Tier: p.Tier,
+			Total: p.Total, Used: p.Used, Utilization: p.Utilization,
+		})
+	}
+	for _, c := range conflicts {
+		out.Conflicts.ByLevel[c.Level]++
+		out.Conflicts.ByKind[c.Kind]++
+	}
+	if out.LastAllocation, err = lastAuditEvent(db, project.ID, "allocate"); err != nil {
+		return ProjectSummary{}, err
+	}
+	if out.LastImport, err = lastAuditEvent(db, project.ID, "import"); err != nil {
+		return ProjectSummary{}, err
+	}
+	return out, nil
+}
+
+func lastAuditEvent(db *sql.DB, projectID int64, action string) (*summaryAuditEvent, error) {
+	var ev summaryAuditEvent
+	err := db.QueryRow(`SELECT created_at, actor, entity_type FROM audit_log WHERE project_id=? AND action=? ORDER BY id DESC LIMIT 1`,
+		projectID, action).Scan(&ev.At, &ev.Actor, &ev.EntityType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
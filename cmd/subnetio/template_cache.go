@@ -0,0 +1,118 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// templateCacheSize bounds the number of template sources kept in memory.
+const templateCacheSize = 64
+
+// templateSourceCache is a read-through LRU cache for loadTemplateSource.
+type templateSourceCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+	hits    int64
+	misses  int64
+	resetAt time.Time
+}
+
+type templateCacheEntry struct {
+	name    string
+	source  templateSource
+	modTime time.Time
+	size    int64
+}
+
+// TemplateCacheStats is shown on the Templates page.
+type TemplateCacheStats struct {
+	Entries int
+	Max     int
+	Hits    int64
+	Misses  int64
+	ResetAt string
+}
+
+var templateSources = newTemplateSourceCache(templateCacheSize)
+
+func newTemplateSourceCache(max int) *templateSourceCache {
+	return &templateSourceCache{
+		max:     max,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+		resetAt: time.Now().UTC(),
+	}
+}
+
+// get returns the cached source of name when it was loaded from the same
+// origin and, for overrides, the file has not changed since.
+func (c *templateSourceCache) get(name, origin string, modTime time.Time, size int64) (templateSource, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if ok {
+		entry := el.Value.(*templateCacheEntry)
+		if entry.source.Source == origin && entry.modTime.Equal(modTime) && entry.size == size {
+			c.order.MoveToFront(el)
+			c.hits++
+			return entry.source, true
+		}
+	}
+	c.misses++
+	return templateSource{}, false
+}
+
+func (c *templateSourceCache) put(name string, source templateSource, modTime time.Time, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &templateCacheEntry{name: name, source: source, modTime: modTime, size: size}
+	if el, ok := c.entries[name]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[name] = c.order.PushFront(entry)
+	for c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*templateCacheEntry).name)
+	}
+}
+
+func (c *templateSourceCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.order.Remove(el)
+		delete(c.entries, name)
+	}
+}
+
+// Reset drops every cached source, e.g. after overrides were replaced on a
+// network share.
+func (c *templateSourceCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.hits = 0
+	c.misses = 0
+	c.resetAt = time.Now().UTC()
+}
+
+func (c *templateSourceCache) Stats() TemplateCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return TemplateCacheStats{
+		Entries: c.order.Len(),
+		Max:     c.max,
+		Hits:    c.hits,
+		Misses:  c.misses,
+		ResetAt: c.resetAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TemplateFixture struct {
+	ID        int64  `json:"id"`
+	Template  string `json:"template"`
+	Name      string `json:"name"`
+	Context   string `json:"context"`
+	Expected  string `json:"expected"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+type TemplateFixtureResult struct {
+	Fixture string `json:"fixture"`
+	Passed  bool   `json:"passed"`
+	Diff    string `json:"diff,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func listTemplateFixtures(db *sql.DB, template string) ([]TemplateFixture, error) {
+	query := `SELECT id, template, name, context, expected, COALESCE(updated_at, '') FROM template_fixtures`
+	var args []any
+	if template != "" {
+		query += ` WHERE template=?`
+		args = append(args, template)
+	}
+	rows, err := db.Query(query+` ORDER BY template, name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []TemplateFixture
+	for rows.Next() {
+		var f TemplateFixture
+		if err := rows.Scan(&f.ID, &f.Template, &f.Name, &f.Context, &f.Expected, &f.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+func templateFixtureByID(db *sql.DB, id int64) (TemplateFixture, bool) {
+	var f TemplateFixture
+	err := db.QueryRow(`SELECT id, template, name, context, expected, COALESCE(updated_at, '') FROM template_fixtures WHERE id=?`, id).
+		Scan(&f.ID, &f.Template, &f.Name, &f.Context, &f.Expected, &f.UpdatedAt)
+	return f, err == nil
+}
+
+func decodeFixtureContext(raw string) (TemplateContext, error) {
+	var ctx TemplateContext
+	if err := json.Unmarshal([]byte(raw), &ctx); err != nil {
+		return ctx, fmt.Errorf("invalid context JSON: %v", err)
+	}
+	return ctx, nil
+}
+
+// saveTemplateFixture adds the fixture or replaces the one of the same name.
+func saveTemplateFixture(db *sql.DB, f TemplateFixture) (TemplateFixture, error) {
+	name, err := normalizeTemplateName(f.Template)
+	if err != nil {
+		return f, err
+	}
+	f.Template = name
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" {
+		return f, fmt.Errorf("fixture name is required")
+	}
+	if strings.TrimSpace(f.Context) == "" {
+		return f, fmt.Errorf("fixture context is required")
+	}
+	if _, err := decodeFixtureContext(f.Context); err != nil {
+		return f, err
+	}
+	f.Expected = normalizeFixtureOutput(f.Expected)
+	f.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	_, err = db.Exec(`
+		INSERT INTO template_fixtures(template, name, context, expected, updated_at)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(template, name) DO UPDATE SET
+			context=excluded.context,
+			expected=excluded.expected,
+			updated_at=excluded.updated_at`,
+		f.Template, f.Name, f.Context, f.Expected, f.UpdatedAt,
+	)
+	if err != nil {
+		return f, err
+	}
+	_ = db.QueryRow(`SELECT id FROM template_fixtures WHERE template=? AND name=?`, f.Template, f.Name).Scan(&f.ID)
+	return f, nil
+}
+
+// captureTemplateFixture builds a fixture from the project's plan scoped by
+// opts, with what opts.Template renders for it now as the expected output.
+func captureTemplateFixture(db *sql.DB, projectID int64, opts GenerateOptions, fixtureName string) (TemplateFixture, error) {
+	name, err := normalizeTemplateName(opts.Template)
+	if err != nil {
+		return TemplateFixture{}, err
+	}
+	opts.Template = name
+	source, err := loadTemplateSource(name)
+	if err != nil {
+		return TemplateFixture{}, err
+	}
+	views, sites, project, meta := loadGenerateInputs(db, projectID)
+	ctx, ok := buildTemplateContext(opts, source, views, sites, project, meta)
+	if !ok {
+		return TemplateFixture{}, fmt.Errorf("nothing to render for this scope")
+	}
+	raw, err := json.MarshalIndent(ctx, "", "  ")
+	if err != nil {
+		return TemplateFixture{}, err
+	}
+	expected, err := renderTemplate(name, source.Content, ctx)
+	if err != nil {
+		return TemplateFixture{}, err
+	}
+	return TemplateFixture{Template: name, Name: fixtureName, Context: string(raw), Expected: expected}, nil
+}
+
+func deleteTemplateFixture(db *sql.DB, id int64) error {
+	_, err := db.Exec(`DELETE FROM template_fixtures WHERE id=?`, id)
+	return err
+}
+
+// normalizeFixtureOutput drops line-ending and trailing whitespace
+// differences, which renderTemplate trims as well.
+func normalizeFixtureOutput(raw string) string {
+	lines := splitLines(strings.TrimSpace(raw))
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runTemplateFixtures renders every fixture with content as the source of
+// template name.
+func runTemplateFixtures(name, content string, fixtures []TemplateFixture) []TemplateFixtureResult {
+	out := make([]TemplateFixtureResult, 0, len(fixtures))
+	for _, f := range fixtures {
+		result := TemplateFixtureResult{Fixture: f.Name}
+		ctx, err := decodeFixtureContext(f.Context)
+		if err != nil {
+			result.Error = err.Error()
+			out = append(out, result)
+			continue
+		}
+		rendered, err := renderTemplate(name, content, ctx)
+		if err != nil {
+			result.Error = err.Error()
+			out = append(out, result)
+			continue
+		}
+		rendered = normalizeFixtureOutput(rendered)
+		if rendered == f.Expected {
+			result.Passed = true
+		} else {
+			result.Diff = unifiedDiffLabeled("expected", "rendered", f.Expected, rendered)
+		}
+		out = append(out, result)
+	}
+	return out
+}
+
+// runStoredTemplateFixtures runs the fixtures of name against its current
+// source.
+func runStoredTemplateFixtures(db *sql.DB, name string) ([]TemplateFixtureResult, error) {
+	source, err := loadTemplateSource(name)
+	if err != nil {
+		return nil, err
+	}
+	fixtures, err := listTemplateFixtures(db, name)
+	if err != nil {
+		return nil, err
+	}
+	return runTemplateFixtures(name, source.Content, fixtures), nil
+}
+
+func fixtureFailures(results []TemplateFixtureResult) int {
+	n := 0
+	for _, r := range results {
+		if !r.Passed {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+const maxTemplateSubnets = 4096
+
+func templateIPFuncs() map[string]any {
+	return map[string]any{
+		"cidrHost":     cidrHost,
+		"cidrNetmask":  cidrNetmask,
+		"cidrSubnets":  cidrSubnets,
+		"wildcardMask": wildcardMask,
+		"ip6Compress":  ip6Compress,
+		"inCIDR":       inCIDR,
+		"offsetAddr":   offsetAddr,
+	}
+}
+
+func templatePrefix(v any) (netip.Prefix, error) {
+	switch t := v.(type) {
+	case netip.Prefix:
+		if !t.IsValid() {
+			return netip.Prefix{}, fmt.Errorf("invalid prefix")
+		}
+		return t.Masked(), nil
+	case string:
+		p, err := netip.ParsePrefix(strings.TrimSpace(t))
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid cidr %q", t)
+		}
+		return p.Masked(), nil
+	case fmt.Stringer:
+		return templatePrefix(t.String())
+	}
+	return netip.Prefix{}, fmt.Errorf("unsupported cidr value %v", v)
+}
+
+func templateAddr(v any) (netip.Addr, error) {
+	switch t := v.(type) {
+	case netip.Addr:
+		if !t.IsValid() {
+			return netip.Addr{}, fmt.Errorf("invalid address")
+		}
+		return t, nil
+	case netip.Prefix:
+		return t.Addr(), nil
+	case string:
+		raw := strings.TrimSpace(t)
+		if strings.Contains(raw, "/") {
+			p, err := netip.ParsePrefix(raw)
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("invalid address %q", t)
+			}
+			return p.Addr(), nil
+		}
+		a, err := netip.ParseAddr(raw)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid address %q", t)
+		}
+		return a, nil
+	case fmt.Stringer:
+		return templateAddr(t.String())
+	}
+	return netip.Addr{}, fmt.Errorf("unsupported address value %v", v)
+}
+
+func cidrHost(cidr any, n int) (string, error) {
+	p, err := templatePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	first, last := usableBounds(p)
+	var out *big.Int
+	switch {
+	case n > 0:
+		out = new(big.Int).Add(first, big.NewInt(int64(n-1)))
+	case n < 0:
+		out = new(big.Int).Add(last, big.NewInt(int64(n+1)))
+	default:
+		return "", fmt.Errorf("cidrHost: host index must not be 0")
+	}
+	if out.Cmp(first) < 0 || out.Cmp(last) > 0 {
+		return "", fmt.Errorf("cidrHost: host %d out of range for %s", n, p)
+	}
+	addr, ok := bigToAddr(out, addrBitLen(p.Addr()))
+	if !ok {
+		return "", fmt.Errorf("cidrHost: host %d out of range for %s", n, p)
+	}
+	return addr.String(), nil
+}
+
+func usableBounds(p netip.Prefix) (*big.Int, *big.Int) {
+	start := addrToBig(p.Addr())
+	last := new(big.Int).Sub(new(big.Int).Add(start, prefixSize(p)), big.NewInt(1))
+	if p.Addr().Is4() && p.Bits() < 31 {
+		return new(big.Int).Add(start, big.NewInt(1)), new(big.Int).Sub(last, big.NewInt(1))
+	}
+	if p.Addr().Is6() && p.Bits() < 127 {
+		return new(big.Int).Add(start, big.NewInt(1)), last
+	}
+	return start, last
+}
+
+func cidrNetmask(cidr any) (string, error) {
+	p, err := templatePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("cidrNetmask: %s is not IPv4", p)
+	}
+	return u32ToIPv4(ipv4Mask(p.Bits())).String(), nil
+}
+
+func wildcardMask(cidr any) (string, error) {
+	p, err := templatePrefix(cidr)
+	if err != nil {
+		return "", err
+	}
+	if !p.Addr().Is4() {
+		return "", fmt.Errorf("wildcardMask: %s is not IPv4", p)
+	}
+	return u32ToIPv4(^ipv4Mask(p.Bits())).String(), nil
+}
+
+func ipv4Mask(bits int) uint32 {
+	if bits <= 0 {
+		return 0
+	}
+	return ^uint32(0) << uint(32-bits)
+}
+
+func cidrSubnets(cidr any, newBits int) ([]string, error) {
+	p, err := templatePrefix(cidr)
+	if err != nil {
+		return nil, err
+	}
+	bitLen := addrBitLen(p.Addr())
+	target := p.Bits() + newBits
+	if newBits < 0 || target > bitLen {
+		return nil, fmt.Errorf("cidrSubnets: cannot split %s by %d bits", p, newBits)
+	}
+	if newBits > 30 || 1<<uint(newBits) > maxTemplateSubnets {
+		return nil, fmt.Errorf("cidrSubnets: more than %d subnets requested", maxTemplateSubnets)
+	}
+	count := 1 << uint(newBits)
+	step := new(big.Int).Lsh(big.NewInt(1), uint(bitLen-target))
+	cur := addrToBig(p.Addr())
+	out := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		addr, ok := bigToAddr(cur, bitLen)
+		if !ok {
+			break
+		}
+		out = append(out, netip.PrefixFrom(addr, target).String())
+		cur.Add(cur, step)
+	}
+	return out, nil
+}
+
+func ip6Compress(v any) (string, error) {
+	if p, ok := v.(netip.Prefix); ok {
+		return p.String(), nil
+	}
+	if s, ok := v.(string); ok && strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(strings.TrimSpace(s))
+		if err != nil {
+			return "", fmt.Errorf("invalid cidr %q", s)
+		}
+		return p.String(), nil
+	}
+	addr, err := templateAddr(v)
+	if err != nil {
+		return "", err
+	}
+	return addr.String(), nil
+}
+
+func inCIDR(addr any, cidr any) bool {
+	a, err := templateAddr(addr)
+	if err != nil {
+		return false
+	}
+	p, err := templatePrefix(cidr)
+	if err != nil {
+		return false
+	}
+	return p.Contains(a)
+}
+
+func offsetAddr(addr any, n int) (string, error) {
+	a, err := templateAddr(addr)
+	if err != nil {
+		return "", err
+	}
+	val := new(big.Int).Add(addrToBig(a), big.NewInt(int64(n)))
+	out, ok := bigToAddr(val, addrBitLen(a))
+	if !ok {
+		return "", fmt.Errorf("offsetAddr: %s%+d out of range", a, n)
+	}
+	return out.String(), nil
+}
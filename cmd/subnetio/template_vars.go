@@ -0,0 +1,182 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// templateVarName is what a variable may be called: usable as .Vars.name.
+var templateVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+const maxTemplateVarValue = 1024
+
+func mergeTemplateVars(base, override map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range override {
+		out[k] = v
+	}
+	return out
+}
+
+// buildSiteVars merges each site's variables over the project's, by site
+// name.
+func buildSiteVars(sites []Site, meta ProjectMeta) map[string]map[string]string {
+	out := make(map[string]map[string]string, len(sites))
+	for _, site := range sites {
+		out[site.Name] = mergeTemplateVars(meta.Vars, site.Vars)
+	}
+	return out
+}
+
+// parseTemplateVars reads name=value lines.
+func parseTemplateVars(raw string) (map[string]string, error) {
+	out := map[string]string{}
+	for i, line := range strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name=value", i+1)
+		}
+		if !templateVarName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: invalid variable name %q (letters, digits and _, not starting with a digit)", i+1, name)
+		}
+		if _, dup := out[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", i+1, name)
+		}
+		if len(value) > maxTemplateVarValue {
+			return nil, fmt.Errorf("line %d: value of %s is longer than %d bytes", i+1, name, maxTemplateVarValue)
+		}
+		out[name] = value
+	}
+	return out, nil
+}
+
+// formatTemplateVars writes variables as sorted name=value lines, the form
+// parseTemplateVars reads.
+func formatTemplateVars(vars map[string]string) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + vars[name] + "\n")
+	}
+	return b.String()
+}
+
+// saveTemplateVars replaces the variables of one scope: the project when
+// siteID is 0, else the site, which must belong to the project.
+func saveTemplateVars(db *sql.DB, projectID, siteID int64, vars map[string]string) error {
+	if projectID <= 0 {
+		return fmt.Errorf("project is required")
+	}
+	if siteID > 0 && projectIDBySite(db, siteID) != projectID {
+		return fmt.Errorf("site %d is not part of the project", siteID)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM template_vars WHERE project_id=? AND site_id=?`, projectID, siteID); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for name, value := range vars {
+		if _, err := tx.Exec(`INSERT INTO template_vars(project_id, site_id, name, value, updated_at) VALUES(?, ?, ?, ?, ?)`,
+			projectID, siteID, name, value, now); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func projectTemplateVars(db sqlConn, projectID int64) (map[string]string, error) {
+	rows, err := db.Query(`SELECT name, value FROM template_vars WHERE project_id=? AND site_id=0`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, rows.Err()
+}
+
+func attachSiteTemplateVars(db sqlConn, sites []Site) error {
+	if len(sites) == 0 {
+		return nil
+	}
+	index := make(map[int64]int, len(sites))
+	for i, site := range sites {
+		index[site.ID] = i
+	}
+	rows, err := db.Query(`SELECT site_id, name, value FROM template_vars WHERE site_id<>0`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			siteID      int64
+			name, value string
+		)
+		if err := rows.Scan(&siteID, &name, &value); err != nil {
+			return err
+		}
+		i, ok := index[siteID]
+		if !ok {
+			continue
+		}
+		if sites[i].Vars == nil {
+			sites[i].Vars = map[string]string{}
+		}
+		sites[i].Vars[name] = value
+	}
+	return rows.Err()
+}
+
+// TemplateVarScope is one editable scope on the variables page.
+type TemplateVarScope struct {
+	SiteID int64
+	Site   string
+	Vars   map[string]string
+	Text   string
+}
+
+func templateVarScopes(db *sql.DB, projectID int64) ([]TemplateVarScope, error) {
+	project, err := projectTemplateVars(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []TemplateVarScope{{Vars: project, Text: formatTemplateVars(project)}}
+	for _, site := range sites {
+		scopes = append(scopes, TemplateVarScope{SiteID: site.ID, Site: site.Name, Vars: site.Vars, Text: formatTemplateVars(site.Vars)})
+	}
+	return scopes, nil
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ticketKindJira       = "jira"
+	ticketKindServiceNow = "servicenow"
+
+	defaultTicketSummary = `{{.Project}}: {{.Kind}}{{if .Sites}} at {{join .Sites ", "}}{{end}}{{if .CIDRs}} ({{join .CIDRs ", "}}){{end}}`
+)
+
+// TicketIntegration creates tickets for conflicts in Jira or ServiceNow.
+type TicketIntegration struct {
+	ProjectID       int64  `json:"project_id"`
+	Kind            string `json:"kind"`
+	URL             string `json:"url"`
+	Board           string `json:"board"`
+	IssueType       string `json:"issue_type,omitempty"`
+	Username        string `json:"username,omitempty"`
+	TokenRef        string `json:"token_ref,omitempty"`
+	SummaryTemplate string `json:"summary_template,omitempty"`
+}
+
+// ConflictTicket links a conflict ID to the ticket created for it.
+type ConflictTicket struct {
+	ConflictID string
+	Kind       string
+	Detail     string
+	TicketKey  string
+	TicketURL  string
+	CreatedAt  string
+}
+
+// ticketContext is the data available to the summary template.
+type ticketContext struct {
+	Project  string
+	ID       string
+	Kind     string
+	Level    string
+	Detail   string
+	Sites    []string
+	Segments []string
+	CIDRs    []string
+}
+
+func normalizeTicketKind(raw string) string {
+	if strings.ToLower(strings.TrimSpace(raw)) == ticketKindServiceNow {
+		return ticketKindServiceNow
+	}
+	return ticketKindJira
+}
+
+func (t TicketIntegration) issueType() string {
+	if v := strings.TrimSpace(t.IssueType); v != "" {
+		return v
+	}
+	if t.Kind == ticketKindServiceNow {
+		return "incident"
+	}
+	return "Task"
+}
+
+func getTicketIntegration(db *sql.DB, projectID int64) (TicketIntegration, bool) {
+	t := TicketIntegration{ProjectID: projectID}
+	var issueType, username, token, summary sql.NullString
+	err := db.QueryRow(`
+		SELECT kind, url, board, issue_type, username, token_ref, summary_template
+		FROM ticket_integrations WHERE project_id=?`, projectID,
+	).Scan(&t.Kind, &t.URL, &t.Board, &issueType, &username, &token, &summary)
+	if err != nil {
+		return TicketIntegration{}, false
+	}
+	t.IssueType = issueType.String
+	t.Username = username.String
+	t.TokenRef = token.String
+	t.SummaryTemplate = summary.String
+	return t, true
+}
+
+func parseTicketSummary(raw string) (*template.Template, error) {
+	if strings.TrimSpace(raw) == "" {
+		raw = defaultTicketSummary
+	}
+	return template.New("summary").Funcs(template.FuncMap{"join": strings.Join}).Parse(raw)
+}
+
+func validateTicketIntegration(t TicketIntegration) error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid ticketing url %q", t.URL)
+	}
+	if t.Board == "" {
+		return fmt.Errorf("ticketing project/board is required")
+	}
+	if _, err := parseTicketSummary(t.SummaryTemplate); err != nil {
+		return fmt.Errorf("summary template: %w", err)
+	}
+	return validateSecretField("ticketing token", t.TokenRef)
+}
+
+func saveTicketIntegration(db *sql.DB, t TicketIntegration) error {
+	t.Kind = normalizeTicketKind(t.Kind)
+	t.URL = strings.TrimRight(strings.TrimSpace(t.URL), "/")
+	t.Board = strings.TrimSpace(t.Board)
+	t.IssueType = strings.TrimSpace(t.IssueType)
+	t.Username = strings.TrimSpace(t.Username)
+	t.TokenRef = strings.TrimSpace(t.TokenRef)
+	t.SummaryTemplate = strings.TrimSpace(t.SummaryTemplate)
+	if err := validateTicketIntegration(t); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO ticket_integrations(project_id, kind, url, board, issue_type, username, token_ref, summary_template)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET
+			kind=excluded.kind,
+			url=excluded.url,
+			board=excluded.board,
+			issue_type=excluded.issue_type,
+			username=excluded.username,
+			token_ref=excluded.token_ref,
+			summary_template=excluded.summary_template`,
+		t.ProjectID, t.Kind, t.URL, t.Board, nullStringToAny(t.IssueType), nullStringToAny(t.Username),
+		nullStringToAny(t.TokenRef), nullStringToAny(t.SummaryTemplate),
+	)
+	return err
+}
+
+func deleteTicketIntegration(db *sql.DB, projectID int64) error {
+	_, err := db.Exec(`DELETE FROM ticket_integrations WHERE project_id=?`, projectID)
+	return err
+}
+
+func listConflictTickets(db *sql.DB, projectID int64) ([]ConflictTicket, error) {
+	rows, err := db.Query(`
+		SELECT conflict_id, kind, detail, ticket_key, ticket_url, created_at
+		FROM conflict_tickets WHERE project_id=?
+		ORDER BY created_at DESC, id DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ConflictTicket
+	for rows.Next() {
+		var t ConflictTicket
+		var ticketURL sql.NullString
+		if err := rows.Scan(&t.ConflictID, &t.Kind, &t.Detail, &t.TicketKey, &ticketURL, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		t.TicketURL = ticketURL.String
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// splitConflictTickets maps tickets of current conflicts by conflict ID and
+// returns the others, whose conflicts are resolved.
+func splitConflictTickets(tickets []ConflictTicket, conflicts []Conflict) (map[string]ConflictTicket, []ConflictTicket) {
+	open := map[string]bool{}
+	for _, c := range conflicts {
+		open[c.ID()] = true
+	}
+	byID := map[string]ConflictTicket{}
+	var resolved []ConflictTicket
+	for _, t := range tickets {
+		if open[t.ConflictID] {
+			byID[t.ConflictID] = t
+			continue
+		}
+		resolved = append(resolved, t)
+	}
+	return byID, resolved
+}
+
+var (
+	conflictSitePattern    = regexp.MustCompile(`\bsites?=(\S+)`)
+	conflictSegmentPattern = regexp.MustCompile(`\bsegment (\S+)`)
+)
+
+// buildTicketContext picks the sites, segments and prefixes named in the
+// conflict detail.
+func buildTicketContext(project string, c Conflict, segs []Segment) ticketContext {
+	ctx := ticketContext{Project: project, ID: c.ID(), Kind: c.Kind, Level: c.Level, Detail: c.Detail}
+	seen := map[string]bool{}
+	add := func(list *[]string, value string) {
+		value = strings.Trim(value, ",:;()")
+		if value == "" || seen[value] {
+			return
+		}
+		seen[value] = true
+		*list = append(*list, value)
+	}
+	for _, m := range conflictSitePattern.FindAllStringSubmatch(c.Detail, -1) {
+		for _, site := range strings.Split(strings.TrimRight(m[1], ":"), ",") {
+			add(&ctx.Sites, site)
+		}
+	}
+	for _, field := range strings.Fields(c.Detail) {
+		field = strings.Trim(field, ",:;()")
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			field = field[i+1:]
+		}
+		if p, err := netip.ParsePrefix(field); err == nil {
+			add(&ctx.CIDRs, p.String())
+		}
+	}
+	sites := map[string]bool{}
+	for _, s := range ctx.Sites {
+		sites[s] = true
+	}
+	words := map[string]bool{}
+	for _, field := range strings.Fields(c.Detail) {
+		words[strings.Trim(field, ",:;()")] = true
+	}
+	for _, m := range conflictSegmentPattern.FindAllStringSubmatch(c.Detail, -1) {
+		words[strings.Trim(m[1], ",:;()")] = true
+	}
+	for _, s := range segs {
+		if len(sites) > 0 && !sites[s.Site] {
+			continue
+		}
+		if words[s.Name] {
+			add(&ctx.Segments, s.Name)
+		}
+	}
+	return ctx
+}
+
+func ticketDescription(ctx ticketContext) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subnetio conflict %s (%s, %s)\n\n%s\n", ctx.ID, ctx.Kind, ctx.Level, ctx.Detail)
+	if len(ctx.Sites) > 0 {
+		fmt.Fprintf(&b, "\nSites: %s", strings.Join(ctx.Sites, ", "))
+	}
+	if len(ctx.Segments) > 0 {
+		fmt.Fprintf(&b, "\nSegments: %s", strings.Join(ctx.Segments, ", "))
+	}
+	if len(ctx.CIDRs) > 0 {
+		fmt.Fprintf(&b, "\nCIDRs: %s", strings.Join(ctx.CIDRs, ", "))
+	}
+	return b.String()
+}
+
+// createTicket files the conflict and returns the ticket key and its browse
+// URL.
+func createTicket(t TicketIntegration, ctx ticketContext) (string, string, error) {
+	tmpl, err := parseTicketSummary(t.SummaryTemplate)
+	if err != nil {
+		return "", "", err
+	}
+	var summary bytes.Buffer
+	if err := tmpl.Execute(&summary, ctx); err != nil {
+		return "", "", fmt.Errorf("summary template: %w", err)
+	}
+	title := strings.TrimSpace(summary.String())
+	var payload any
+	endpoint := t.URL + "/rest/api/2/issue"
+	if t.Kind == ticketKindServiceNow {
+		endpoint = t.URL + "/api/now/table/" + url.PathEscape(t.issueType())
+		payload = map[string]string{
+			"short_description": title,
+			"description":       ticketDescription(ctx),
+			"assignment_group":  t.Board,
+			"correlation_id":    ctx.ID,
+		}
+	} else {
+		payload = map[string]any{"fields": map[string]any{
+			"project":     map[string]string{"key": t.Board},
+			"issuetype":   map[string]string{"name": t.issueType()},
+			"summary":     title,
+			"description": ticketDescription(ctx),
+			"labels":      []string{"subnetio", ctx.ID},
+		}}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if t.TokenRef != "" {
+		token, err := resolveSecret(t.TokenRef)
+		if err != nil {
+			return "", "", fmt.Errorf("ticketing token: %w", err)
+		}
+		if t.Username != "" {
+			req.SetBasicAuth(t.Username, token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", "", fmt.Errorf("%s: status %d: %s", t.Kind, resp.StatusCode, strings.TrimSpace(string(raw)))
+	}
+	if t.Kind == ticketKindServiceNow {
+		var out struct {
+			Result struct {
+				SysID  string `json:"sys_id"`
+				Number string `json:"number"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(raw, &out); err != nil || out.Result.SysID == "" {
+			return "", "", fmt.Errorf("servicenow: unexpected response")
+		}
+		key := out.Result.Number
+		if key == "" {
+			key = out.Result.SysID
+		}
+		link := t.URL + "/nav_to.do?uri=" + url.QueryEscape(t.issueType()+".do?sys_id="+out.Result.SysID)
+		return key, link, nil
+	}
+	var out struct {
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(raw, &out); err != nil || out.Key == "" {
+		return "", "", fmt.Errorf("jira: unexpected response")
+	}
+	return out.Key, t.URL + "/browse/" + out.Key, nil
+}
+
+func saveConflictTicket(db *sql.DB, projectID int64, c Conflict, key, link string) error {
+	_, err := db.Exec(`
+		INSERT INTO conflict_tickets(project_id, conflict_id, kind, detail, ticket_key, ticket_url, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		projectID, c.ID(), c.Kind, c.Detail, key, nullStringToAny(link), time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+func conflictTicketByID(db *sql.DB, projectID int64, conflictID string) (ConflictTicket, bool) {
+	var t ConflictTicket
+	var ticketURL sql.NullString
+	err := db.QueryRow(`
+		SELECT conflict_id, kind, detail, ticket_key, ticket_url, created_at
+		FROM conflict_tickets WHERE project_id=? AND conflict_id=?`, projectID, conflictID,
+	).Scan(&t.ConflictID, &t.Kind, &t.Detail, &t.TicketKey, &ticketURL, &t.CreatedAt)
+	if err != nil {
+		return ConflictTicket{}, false
+	}
+	t.TicketURL = ticketURL.String
+	return t, true
+}
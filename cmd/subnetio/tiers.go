@@ -0,0 +1,318 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PoolTier is a named tier of a project.
+type PoolTier struct {
+	ID          int64
+	ProjectID   int64
+	Name        string
+	Position    int
+	Description string
+	CreatedAt   string
+}
+
+// normalizeTierName lowercases a tier name the way the allocator compares
+// tiers (see poolTierValue).
+func normalizeTierName(raw string) string {
+	return strings.ToLower(strings.TrimSpace(raw))
+}
+
+func listPoolTiers(db sqlConn, projectID int64) ([]PoolTier, error) {
+	rows, err := db.Query(`
+		SELECT id, project_id, name, position, description, created_at
+		FROM pool_tiers
+		WHERE project_id=?
+		ORDER BY position, id`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []PoolTier
+	for rows.Next() {
+		var t PoolTier
+		if err := rows.Scan(&t.ID, &t.ProjectID, &t.Name, &t.Position, &t.Description, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func poolTierByID(db sqlConn, projectID, id int64) (PoolTier, bool) {
+	var t PoolTier
+	err := db.QueryRow(`
+		SELECT id, project_id, name, position, description, created_at
+		FROM pool_tiers
+		WHERE id=? AND project_id=?`, id, projectID,
+	).Scan(&t.ID, &t.ProjectID, &t.Name, &t.Position, &t.Description, &t.CreatedAt)
+	return t, err == nil
+}
+
+// savePoolTier adds a tier at the end of the project's list, or updates the
+// description of an existing tier of the same name.
+func savePoolTier(db *sql.DB, projectID int64, t PoolTier) (int64, error) {
+	name := normalizeTierName(t.Name)
+	if name == "" {
+		return 0, errors.New("tier name is required")
+	}
+	if strings.ContainsAny(name, " \t,:=") {
+		return 0, fmt.Errorf("invalid tier name %q", name)
+	}
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO pool_tiers(project_id, name, position, description, created_at)
+		VALUES(?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM pool_tiers WHERE project_id=?), ?, ?)
+		ON CONFLICT(project_id, name) DO UPDATE SET description=excluded.description
+		RETURNING id`,
+		projectID, name, projectID, strings.TrimSpace(t.Description), time.Now().UTC().Format(time.RFC3339),
+	).Scan(&id)
+	return id, err
+}
+
+// movePoolTier swaps a tier with its neighbour above (delta < 0) or below
+// (delta > 0) and renumbers the list from 1.
+func movePoolTier(db *sql.DB, projectID, id int64, delta int) error {
+	tiers, err := listPoolTiers(db, projectID)
+	if err != nil {
+		return err
+	}
+	from := -1
+	for i, t := range tiers {
+		if t.ID == id {
+			from = i
+		}
+	}
+	if from < 0 {
+		return errors.New("tier not found")
+	}
+	to := from + delta
+	if to < 0 || to >= len(tiers) || delta == 0 {
+		return nil
+	}
+	tiers[from], tiers[to] = tiers[to], tiers[from]
+	return renumberPoolTiers(db, tiers)
+}
+
+func renumberPoolTiers(db *sql.DB, tiers []PoolTier) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for i, t := range tiers {
+		if _, err := tx.Exec(`UPDATE pool_tiers SET position=? WHERE id=?`, i+1, t.ID); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// poolTierUsage counts the pools and segments of the project that name the
+// tier.
+func poolTierUsage(db sqlConn, projectID int64, name string) (int, int, error) {
+	var pools, segments int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM pools p
+		JOIN project_sites ps ON ps.site_id = p.site_id
+		WHERE ps.project_id=? AND LOWER(TRIM(p.tier))=?`, projectID, name).Scan(&pools); err != nil {
+		return 0, 0, err
+	}
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM segment_meta sm
+		JOIN segments s ON s.id = sm.segment_id
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		WHERE ps.project_id=? AND LOWER(TRIM(sm.pool_tier))=?`, projectID, name).Scan(&segments); err != nil {
+		return 0, 0, err
+	}
+	return pools, segments, nil
+}
+
+// deletePoolTier removes a tier no pool or segment refers to.
+func deletePoolTier(db *sql.DB, projectID, id int64) error {
+	t, ok := poolTierByID(db, projectID, id)
+	if !ok {
+		return errors.New("tier not found")
+	}
+	pools, segments, err := poolTierUsage(db, projectID, t.Name)
+	if err != nil {
+		return err
+	}
+	if pools > 0 || segments > 0 {
+		return fmt.Errorf("tier %s is in use by %d pools and %d segments", t.Name, pools, segments)
+	}
+	if _, err := db.Exec(`DELETE FROM pool_tiers WHERE id=?`, id); err != nil {
+		return err
+	}
+	tiers, err := listPoolTiers(db, projectID)
+	if err != nil {
+		return err
+	}
+	return renumberPoolTiers(db, tiers)
+}
+
+// checkPoolTierReference rejects a segment pool_tier the project does not
+// define.
+func checkPoolTierReference(db sqlConn, projectID int64, raw string) error {
+	name := normalizeTierName(raw)
+	if name == "" {
+		return nil
+	}
+	tiers, err := listPoolTiers(db, projectID)
+	if err != nil || len(tiers) == 0 {
+		return err
+	}
+	names := make([]string, 0, len(tiers))
+	for _, t := range tiers {
+		if t.Name == name {
+			return nil
+		}
+		names = append(names, t.Name)
+	}
+	return fmt.Errorf("unknown pool tier %q (defined: %s)", name, strings.Join(names, ", "))
+}
+
+// TierGroup heads a run of pools or segments of the same tier.
+type TierGroup struct {
+	Name        string
+	Description string
+	Defined     bool
+	Count       int
+}
+
+func (g TierGroup) Label() string {
+	if g.Name == "" {
+		return "no tier"
+	}
+	return g.Name
+}
+
+// tierLess orders tier names: the project's tiers in their order, then
+// undefined tiers by name, then the empty tier.
+func tierLess(tiers []PoolTier) func(a, b string) bool {
+	rank := make(map[string]int, len(tiers))
+	for i, t := range tiers {
+		rank[t.Name] = i
+	}
+	key := func(name string) (int, string) {
+		if name == "" {
+			return len(tiers) + 1, ""
+		}
+		if r, ok := rank[name]; ok {
+			return r, ""
+		}
+		return len(tiers), name
+	}
+	return func(a, b string) bool {
+		ra, na := key(a)
+		rb, nb := key(b)
+		if ra != rb {
+			return ra < rb
+		}
+		return na < nb
+	}
+}
+
+// groupByTier orders items by tier (see tierLess).
+func groupByTier[T any](items []T, tierOf func(T) string, tiers []PoolTier) ([]T, map[int]*TierGroup) {
+	less := tierLess(tiers)
+	out := make([]T, len(items))
+	copy(out, items)
+	sort.SliceStable(out, func(i, j int) bool { return less(tierOf(out[i]), tierOf(out[j])) })
+	desc := make(map[string]string, len(tiers))
+	for _, t := range tiers {
+		desc[t.Name] = t.Description
+	}
+	headers := map[int]*TierGroup{}
+	var current *TierGroup
+	for i, item := range out {
+		name := tierOf(item)
+		if current == nil || current.Name != name {
+			_, defined := desc[name]
+			current = &TierGroup{Name: name, Description: desc[name], Defined: defined}
+			headers[i] = current
+		}
+		current.Count++
+	}
+	return out, headers
+}
+
+func groupPoolsByTier(pools []Pool, tiers []PoolTier) ([]Pool, map[int]*TierGroup) {
+	return groupByTier(pools, poolTierValue, tiers)
+}
+
+func groupSegmentViewsByTier(views []SegmentView, tiers []PoolTier) ([]SegmentView, map[int]*TierGroup) {
+	return groupByTier(views, func(v SegmentView) string { return segmentTierValue(v.Segment) }, tiers)
+}
+
+// tierAPIGroup is one tier of GET /api/v1/projects/:id/tiers.
+type tierAPIGroup struct {
+	Name        string           `json:"name"`
+	Position    int              `json:"position,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Defined     bool             `json:"defined"`
+	Pools       []tierAPIPool    `json:"pools"`
+	Segments    []tierAPISegment `json:"segments"`
+}
+
+type tierAPIPool struct {
+	ID       int64  `json:"id"`
+	Site     string `json:"site"`
+	CIDR     string `json:"cidr"`
+	Family   string `json:"family"`
+	Kind     string `json:"kind"`
+	Priority int    `json:"priority"`
+}
+
+type tierAPISegment struct {
+	ID     int64  `json:"id"`
+	Site   string `json:"site"`
+	VRF    string `json:"vrf"`
+	VLAN   int    `json:"vlan"`
+	Name   string `json:"name"`
+	CIDR   string `json:"cidr,omitempty"`
+	CIDRV6 string `json:"cidr_v6,omitempty"`
+}
+
+// buildTierGroups lists the pools and segments of a project per tier, in tier
+// order.
+func buildTierGroups(tiers []PoolTier, pools []Pool, segs []Segment) []tierAPIGroup {
+	var out []tierAPIGroup
+	index := map[string]int{}
+	group := func(name string) *tierAPIGroup {
+		if i, ok := index[name]; ok {
+			return &out[i]
+		}
+		index[name] = len(out)
+		out = append(out, tierAPIGroup{Name: name, Pools: []tierAPIPool{}, Segments: []tierAPISegment{}})
+		return &out[len(out)-1]
+	}
+	for _, t := range tiers {
+		g := group(t.Name)
+		g.Position = t.Position
+		g.Description = t.Description
+		g.Defined = true
+	}
+	sortedPools, _ := groupPoolsByTier(pools, tiers)
+	for _, p := range sortedPools {
+		g := group(poolTierValue(p))
+		g.Pools = append(g.Pools, tierAPIPool{ID: p.ID, Site: p.Site, CIDR: p.CIDR, Family: normalizePoolFamily(p.Family), Kind: normalizePoolKind(p.Kind), Priority: p.Priority})
+	}
+	sortedSegs, _ := groupByTier(segs, segmentTierValue, tiers)
+	for _, s := range sortedSegs {
+		g := group(segmentTierValue(s))
+		g.Segments = append(g.Segments, tierAPISegment{ID: s.ID, Site: s.Site, VRF: s.VRF, VLAN: s.VLAN, Name: s.Name, CIDR: nullString(s.CIDR), CIDRV6: nullString(s.CIDRV6)})
+	}
+	less := tierLess(tiers)
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i].Name, out[j].Name) })
+	return out
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type segmentTombstone struct {
+	ProjectID int64
+	UID       string
+	Label     string
+}
+
+// recordSegmentTombstones writes tombstones for the segments matched by
+// where (a condition on s with one argument) before they are deleted.
+func recordSegmentTombstones(tx sqlConn, where string, arg any) error {
+	rows, err := tx.Query(`
+		SELECT ps.project_id, COALESCE(p.name, ''), st.name, s.vrf, s.vlan, s.name
+		FROM segments s
+		JOIN sites st ON st.id = s.site_id
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		LEFT JOIN projects p ON p.id = ps.project_id
+		WHERE `+where, arg)
+	if err != nil {
+		return err
+	}
+	var stones []segmentTombstone
+	for rows.Next() {
+		var (
+			projectID                    int64
+			projectName, site, vrf, name string
+			vlan                         int
+		)
+		if err := rows.Scan(&projectID, &projectName, &site, &vrf, &vlan, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		projectName = strings.TrimSpace(projectName)
+		if projectName == "" {
+			projectName = "Default"
+		}
+		stones = append(stones, segmentTombstone{
+			ProjectID: projectID,
+			UID:       stableID(planRowSegment, projectName, site, vrf, itoa(vlan), name),
+			Label:     fmt.Sprintf("%s/%s/%d/%s", site, vrf, vlan, name),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, t := range stones {
+		if _, err := tx.Exec(`
+			INSERT INTO segment_tombstones(project_id, uid, label, deleted_at)
+			VALUES(?, ?, ?, ?)
+			ON CONFLICT(project_id, uid) DO UPDATE SET label=excluded.label, deleted_at=excluded.deleted_at`,
+			t.ProjectID, t.UID, t.Label, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func segmentDeletedAt(db sqlConn, projectID int64, uid string) (time.Time, bool, error) {
+	var raw string
+	err := db.QueryRow(`SELECT deleted_at FROM segment_tombstones WHERE project_id=? AND uid=?`, projectID, uid).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	ts, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, false, nil
+	}
+	return ts, true, nil
+}
+
+// deletedSinceExport reports whether a segment row was deleted after the file
+// was exported.
+func (s *planImportState) deletedSinceExport(db sqlConn, projectID int64, row PlanRow) (time.Time, bool, error) {
+	if !s.policy.RespectDeletions || row.UID == "" {
+		return time.Time{}, false, nil
+	}
+	deletedAt, ok, err := segmentDeletedAt(db, projectID, row.UID)
+	if err != nil || !ok {
+		return time.Time{}, false, err
+	}
+	if exportedAt, known := s.exportedAt[projectID]; known && !deletedAt.After(exportedAt) {
+		return time.Time{}, false, nil
+	}
+	var id int64
+	err = db.QueryRow(`
+		SELECT s.id FROM segments s
+		JOIN sites st ON st.id = s.site_id
+		WHERE st.name=? AND s.vrf=? AND s.vlan=? AND s.name=?`,
+		row.Site, row.VRF, intValue(row.VLAN), row.Name).Scan(&id)
+	if err == nil {
+		return time.Time{}, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return time.Time{}, false, err
+	}
+	return deletedAt, true, nil
+}
+
+func stampPlanExport(bundle *PlanBundle, now time.Time) {
+	for i := range bundle.Rows {
+		if bundle.Rows[i].RowType == planRowMeta {
+			bundle.Rows[i].ExportedAt = now.UTC().Format(time.RFC3339)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Uniqueness rules catch names and CIDRs the schema allows but that are almost
+// always typos or double entries.
+const (
+	// UniqueSegmentNames is per VRF across the project's sites.
+	UniqueSegmentNames = "segment_names"
+	UniqueSiteNames    = "site_names"
+	UniquePoolCIDRs    = "pool_cidrs"
+)
+
+var uniquenessRuleKeys = []string{UniqueSegmentNames, UniqueSiteNames, UniquePoolCIDRs}
+
+// defaultUniqueness is used for new projects and for plan files without a
+// uniqueness column.
+const defaultUniqueness = UniqueSiteNames + "," + UniquePoolCIDRs
+
+const uniquenessNone = "none"
+
+// parseUniqueness reads a comma-separated list of rule keys; "none" turns
+// every rule off and an empty value means the defaults.
+func parseUniqueness(raw string) (map[string]bool, error) {
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	if raw == "" {
+		raw = defaultUniqueness
+	}
+	out := map[string]bool{}
+	if raw == uniquenessNone {
+		return out, nil
+	}
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		known := false
+		for _, key := range uniquenessRuleKeys {
+			if part == key {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown uniqueness rule %q (use %s or none)", part, strings.Join(uniquenessRuleKeys, ", "))
+		}
+		out[part] = true
+	}
+	return out, nil
+}
+
+func formatUniqueness(set map[string]bool) string {
+	var parts []string
+	for _, key := range uniquenessRuleKeys {
+		if set[key] {
+			parts = append(parts, key)
+		}
+	}
+	if len(parts) == 0 {
+		return uniquenessNone
+	}
+	return strings.Join(parts, ",")
+}
+
+func uniquenessFromKeys(keys []string) string {
+	set := map[string]bool{}
+	for _, key := range keys {
+		set[strings.ToLower(strings.TrimSpace(key))] = true
+	}
+	return formatUniqueness(set)
+}
+
+// Unique reports whether a uniqueness rule is on.
+func (r ProjectRules) Unique(key string) bool {
+	set, err := parseUniqueness(r.Uniqueness)
+	return err == nil && set[key]
+}
+
+func checkSiteNameUnique(db sqlConn, rules ProjectRules, name string) error {
+	if !rules.Unique(UniqueSiteNames) {
+		return nil
+	}
+	var existing string
+	err := db.QueryRow(`
+		SELECT name FROM sites
+		WHERE name=? COLLATE NOCASE AND NOT EXISTS (SELECT 1 FROM sites WHERE name=?)
+		LIMIT 1`, name, name).Scan(&existing)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("site name %q collides with existing site %q (site names are compared ignoring case)", name, existing)
+}
+
+// getOrCreateProjectSiteID is getOrCreateSiteID for sites imported into a
+// project: a new site name is checked against the project's rules first.
+func getOrCreateProjectSiteID(db sqlConn, projectID int64, name string) (int64, bool, error) {
+	rules, err := getProjectRules(db, projectID)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := checkSiteNameUnique(db, rules, name); err != nil {
+		return 0, false, err
+	}
+	return getOrCreateSiteID(db, name)
+}
+
+func checkPoolCIDRUnique(db sqlConn, rules ProjectRules, projectID int64, cidr string, exceptID int64) error {
+	if !rules.Unique(UniquePoolCIDRs) || projectID <= 0 {
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT p.cidr, s.name
+		FROM pools p
+		JOIN sites s ON s.id = p.site_id
+		JOIN project_sites ps ON ps.site_id = p.site_id
+		WHERE ps.project_id=? AND p.id<>?`, projectID, exceptID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var other, site string
+		if err := rows.Scan(&other, &site); err != nil {
+			return err
+		}
+		if p, err := netip.ParsePrefix(other); err == nil && p.Masked() == prefix.Masked() {
+			return fmt.Errorf("pool %s already exists in this project at site %s", prefix.Masked(), site)
+		}
+	}
+	return rows.Err()
+}
+
+func checkSegmentNameUnique(db sqlConn, rules ProjectRules, projectID int64, vrf, name string, exceptID int64) error {
+	if !rules.Unique(UniqueSegmentNames) || projectID <= 0 {
+		return nil
+	}
+	var site string
+	var vlan int
+	err := db.QueryRow(`
+		SELECT si.name, s.vlan
+		FROM segments s
+		JOIN sites si ON si.id = s.site_id
+		JOIN project_sites ps ON ps.site_id = s.site_id
+		WHERE ps.project_id=? AND s.vrf=? AND s.name=? COLLATE NOCASE AND s.id<>?
+		ORDER BY si.name, s.vlan
+		LIMIT 1`, projectID, strings.TrimSpace(vrf), strings.TrimSpace(name), exceptID).Scan(&site, &vlan)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	return fmt.Errorf("segment name %q is already used in VRF %s at site %s, VLAN %d", name, vrf, site, vlan)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxValidatorTimeout = 120
+
+type AllocationValidator struct {
+	ProjectID      int64  `json:"project_id"`
+	URL            string `json:"url"`
+	TokenRef       string `json:"token_ref,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds"`
+	FailOpen       bool   `json:"fail_open"`
+}
+
+type validatorRequest struct {
+	ProjectID int64                  `json:"project_id"`
+	Project   string                 `json:"project"`
+	Actor     string                 `json:"actor"`
+	Proposal  auditAllocationSummary `json:"proposal"`
+}
+
+type validatorResponse struct {
+	Allowed *bool  `json:"allowed"`
+	Message string `json:"message"`
+}
+
+// validatorError marks failures caused by the external validator, as opposed
+// to allocation errors, so they can be shown to the user instead of a 500.
+type validatorError struct {
+	Message string
+}
+
+func (e *validatorError) Error() string {
+	return e.Message
+}
+
+func getAllocationValidator(db *sql.DB, projectID int64) (AllocationValidator, bool) {
+	v := AllocationValidator{ProjectID: projectID}
+	var token sql.NullString
+	var failOpen int
+	err := db.QueryRow(`
+		SELECT url, token_ref, timeout_seconds, fail_open
+		FROM allocation_validators WHERE project_id=?`, projectID,
+	).Scan(&v.URL, &token, &v.TimeoutSeconds, &failOpen)
+	if err != nil {
+		return AllocationValidator{}, false
+	}
+	v.TokenRef = token.String
+	v.FailOpen = failOpen != 0
+	return v, true
+}
+
+func validateAllocationValidator(v AllocationValidator) error {
+	u, err := url.Parse(strings.TrimSpace(v.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid validator url %q", v.URL)
+	}
+	if v.TimeoutSeconds < 1 || v.TimeoutSeconds > maxValidatorTimeout {
+		return fmt.Errorf("validator timeout must be 1-%d seconds", maxValidatorTimeout)
+	}
+	return validateSecretField("validator token", v.TokenRef)
+}
+
+func saveAllocationValidator(db *sql.DB, v AllocationValidator) error {
+	v.URL = strings.TrimSpace(v.URL)
+	v.TokenRef = strings.TrimSpace(v.TokenRef)
+	if v.TimeoutSeconds == 0 {
+		v.TimeoutSeconds = 10
+	}
+	if err := validateAllocationValidator(v); err != nil {
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO allocation_validators(project_id, url, token_ref, timeout_seconds, fail_open)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET
+			url=excluded.url,
+			token_ref=excluded.token_ref,
+			timeout_seconds=excluded.timeout_seconds,
+			fail_open=excluded.fail_open`,
+		v.ProjectID, v.URL, nullStringToAny(v.TokenRef), v.TimeoutSeconds, boolToInt(v.FailOpen),
+	)
+	return err
+}
+
+func deleteAllocationValidator(db *sql.DB, projectID int64) error {
+	_, err := db.Exec(`DELETE FROM allocation_validators WHERE project_id=?`, projectID)
+	return err
+}
+
+// callAllocationValidator posts the proposed allocation.
+func callAllocationValidator(v AllocationValidator, payload validatorRequest) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, v.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.TokenRef != "" {
+		token, err := resolveSecret(v.TokenRef)
+		if err != nil {
+			return &validatorError{Message: "validator token: " + err.Error()}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: time.Duration(v.TimeoutSeconds) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		if v.FailOpen {
+			return nil
+		}
+		return &validatorError{Message: "validator unreachable: " + err.Error()}
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var out validatorResponse
+	_ = json.Unmarshal(raw, &out)
+	message := strings.TrimSpace(out.Message)
+	if message == "" && out.Allowed == nil {
+		message = strings.TrimSpace(string(raw))
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out.Allowed == nil || *out.Allowed {
+			return nil
+		}
+		if message == "" {
+			message = "allocation not allowed"
+		}
+		return &validatorError{Message: "rejected by validator: " + message}
+	}
+	if resp.StatusCode >= 500 && v.FailOpen {
+		return nil
+	}
+	if message == "" {
+		message = "status " + itoa(resp.StatusCode)
+	}
+	return &validatorError{Message: "rejected by validator: " + message}
+}
+
+func allocationValidatorCheck(v AllocationValidator, project Project, actor string) allocationCheck {
+	return func(before, after []Segment) error {
+		summary := buildAllocationSummary(before, after)
+		if len(summary.Changes) == 0 {
+			return nil
+		}
+		return callAllocationValidator(v, validatorRequest{
+			ProjectID: project.ID,
+			Project:   project.Name,
+			Actor:     actor,
+			Proposal:  summary,
+		})
+	}
+}
+
+func isValidatorError(err error) bool {
+	var verr *validatorError
+	return errors.As(err, &verr)
+}
@@ -10,9 +10,13 @@ import (
 )
 
 type Conflict struct {
-	Kind   string
-	Detail string
-	Level  string
+	Kind         string
+	Detail       string
+	Level        string
+	Alternatives []AllocationAlternative
+
+	segmentID int64
+	key       string
 }
 
 func prefixesOverlap(a, b netip.Prefix) bool {
@@ -74,7 +78,7 @@ func overlapsAny(p netip.Prefix, used []netip.Prefix) bool {
 func poolsBySite(db *sql.DB, siteID int64) ([]Pool, error) {
 	rows, err := db.Query(`
 		SELECT id, site_id, '' as site, cidr,
-			COALESCE(family, 'ipv4'), tier, COALESCE(priority, 0)
+			COALESCE(family, 'ipv4'), tier, COALESCE(priority, 0), vrf_shares, kind, state
 		FROM pools WHERE site_id=?
 		ORDER BY COALESCE(priority, 0), cidr`, siteID)
 	if err != nil {
@@ -84,7 +88,7 @@ func poolsBySite(db *sql.DB, siteID int64) ([]Pool, error) {
 	var out []Pool
 	for rows.Next() {
 		var p Pool
-		if err := rows.Scan(&p.ID, &p.SiteID, &p.Site, &p.CIDR, &p.Family, &p.Tier, &p.Priority); err != nil {
+		if err := rows.Scan(&p.ID, &p.SiteID, &p.Site, &p.CIDR, &p.Family, &p.Tier, &p.Priority, &p.VRFShares, &p.Kind, &p.State); err != nil {
 			return nil, err
 		}
 		out = append(out, p)
@@ -128,7 +132,7 @@ func segmentsBySite(db *sql.DB, siteID int64) ([]Segment, error) {
 	rows, err := db.Query(`
 		SELECT s.id, s.site_id, si.name, s.vrf, s.vlan, s.name, s.hosts, s.prefix, s.cidr,
 			s.prefix_v6, s.cidr_v6, s.locked,
-			sm.pool_tier
+			sm.pool_tier, s.multi_prefix, s.secondary_cidrs, s.kind, s.link_a, s.link_b
 		FROM segments s
 		JOIN sites si ON si.id = s.site_id
 		LEFT JOIN segment_meta sm ON sm.segment_id = s.id
@@ -146,6 +150,7 @@ func segmentsBySite(db *sql.DB, siteID int64) ([]Segment, error) {
 		if err := rows.Scan(
 			&seg.ID, &seg.SiteID, &seg.Site, &seg.VRF, &seg.VLAN, &seg.Name,
 			&seg.Hosts, &seg.Prefix, &seg.CIDR, &seg.PrefixV6, &seg.CIDRV6, &lockedInt, &seg.PoolTier,
+			&seg.MultiPrefix, &seg.SecondaryCIDRs, &seg.Kind, &seg.LinkA, &seg.LinkB,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,350 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// vrfShareDefault is the VRF name used for segments without a VRF.
+const vrfShareDefault = "default"
+
+// vrfShares caps how much of a pool each VRF may take, in percent of the pool
+// size.
+type vrfShares struct {
+	Entries []vrfShare
+	Other   int
+}
+
+type vrfShare struct {
+	VRF     string
+	Percent int
+}
+
+func vrfShareKey(vrf string) string {
+	vrf = strings.ToLower(strings.TrimSpace(vrf))
+	if vrf == "" {
+		return vrfShareDefault
+	}
+	return vrf
+}
+
+func parseVRFShares(raw string) (vrfShares, error) {
+	var out vrfShares
+	seen := map[string]bool{}
+	sum := 0
+	for _, part := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ';' }) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return vrfShares{}, fmt.Errorf("invalid VRF share %q: use VRF=percent", part)
+		}
+		percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+		if err != nil || percent < 1 || percent > 100 {
+			return vrfShares{}, fmt.Errorf("invalid VRF share %q: percent must be 1-100", part)
+		}
+		key := vrfShareKey(name)
+		if name == "*" {
+			key = "*"
+		}
+		if seen[key] {
+			return vrfShares{}, fmt.Errorf("VRF %s listed twice", name)
+		}
+		seen[key] = true
+		if key == "*" {
+			out.Other = percent
+			continue
+		}
+		sum += percent
+		out.Entries = append(out.Entries, vrfShare{VRF: name, Percent: percent})
+	}
+	if sum > 100 {
+		return vrfShares{}, fmt.Errorf("VRF shares add up to %d%%", sum)
+	}
+	return out, nil
+}
+
+func (s vrfShares) Empty() bool {
+	return len(s.Entries) == 0 && s.Other == 0
+}
+
+func (s vrfShares) String() string {
+	parts := make([]string, 0, len(s.Entries)+1)
+	for _, e := range s.Entries {
+		parts = append(parts, e.VRF+"="+strconv.Itoa(e.Percent))
+	}
+	if s.Other > 0 {
+		parts = append(parts, "*="+strconv.Itoa(s.Other))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// percentFor returns the share of vrf and false when the VRF is uncapped.
+func (s vrfShares) percentFor(vrf string) (int, bool) {
+	key := vrfShareKey(vrf)
+	for _, e := range s.Entries {
+		if vrfShareKey(e.VRF) == key {
+			return e.Percent, true
+		}
+	}
+	return s.Other, s.Other > 0
+}
+
+func poolVRFShares(p Pool) vrfShares {
+	if !p.VRFShares.Valid {
+		return vrfShares{}
+	}
+	shares, err := parseVRFShares(p.VRFShares.String)
+	if err != nil {
+		return vrfShares{}
+	}
+	return shares
+}
+
+func shareLimit(pool netip.Prefix, percent int) *big.Int {
+	limit := new(big.Int).Mul(prefixSize(pool), big.NewInt(int64(percent)))
+	return limit.Div(limit, big.NewInt(100))
+}
+
+// poolVRFUsage sums the addresses each VRF holds inside pool.
+func poolVRFUsage(pool netip.Prefix, segs []Segment, family string, lockedOnly bool) map[string]*big.Int {
+	out := map[string]*big.Int{}
+	for _, s := range segs {
+		if lockedOnly && !s.Locked {
+			continue
+		}
+		var prefixes []netip.Prefix
+		if cidr := segmentCIDRByFamily(s, family); cidr.Valid {
+			if p, err := netip.ParsePrefix(cidr.String); err == nil {
+				prefixes = append(prefixes, p)
+			}
+		}
+		if family == "ipv4" {
+			prefixes = append(prefixes, segmentSecondaryPrefixes(s)...)
+		}
+		for _, p := range prefixes {
+			if !pool.Contains(p.Addr()) || p.Bits() < pool.Bits() {
+				continue
+			}
+			key := vrfShareKey(s.VRF)
+			if out[key] == nil {
+				out[key] = big.NewInt(0)
+			}
+			out[key].Add(out[key], prefixSize(p))
+		}
+	}
+	return out
+}
+
+// vrfShareLedger enforces pool VRF shares during allocation.
+type vrfShareLedger struct {
+	pools map[int64]*poolShareState
+}
+
+type poolShareState struct {
+	prefix netip.Prefix
+	shares vrfShares
+	used   map[string]*big.Int
+}
+
+// newVRFShareLedger seeds the ledger with the locked segments, which keep
+// their prefixes through allocation.
+func newVRFShareLedger(items []poolItem, segs []Segment, family string) *vrfShareLedger {
+	var ledger *vrfShareLedger
+	for _, item := range items {
+		shares := poolVRFShares(item.Pool)
+		if shares.Empty() {
+			continue
+		}
+		if ledger == nil {
+			ledger = &vrfShareLedger{pools: map[int64]*poolShareState{}}
+		}
+		ledger.pools[item.Pool.ID] = &poolShareState{
+			prefix: item.Prefix,
+			shares: shares,
+			used:   poolVRFUsage(item.Prefix, segs, family, true),
+		}
+	}
+	return ledger
+}
+
+func (l *vrfShareLedger) state(items []poolItem, p netip.Prefix) *poolShareState {
+	if l == nil {
+		return nil
+	}
+	for _, item := range items {
+		if item.Prefix.Contains(p.Addr()) {
+			return l.pools[item.Pool.ID]
+		}
+	}
+	return nil
+}
+
+func (st *poolShareState) fits(vrf string, extra *big.Int) bool {
+	if st == nil {
+		return true
+	}
+	percent, capped := st.shares.percentFor(vrf)
+	if !capped {
+		return true
+	}
+	total := new(big.Int).Set(extra)
+	if used := st.used[vrfShareKey(vrf)]; used != nil {
+		total.Add(total, used)
+	}
+	return total.Cmp(shareLimit(st.prefix, percent)) <= 0
+}
+
+func (st *poolShareState) take(vrf string, p netip.Prefix) {
+	if st == nil {
+		return
+	}
+	key := vrfShareKey(vrf)
+	if st.used[key] == nil {
+		st.used[key] = big.NewInt(0)
+	}
+	st.used[key].Add(st.used[key], prefixSize(p))
+}
+
+func (l *vrfShareLedger) pool(item poolItem) *poolShareState {
+	if l == nil {
+		return nil
+	}
+	return l.pools[item.Pool.ID]
+}
+
+func (l *vrfShareLedger) fits(item poolItem, vrf string, p netip.Prefix) bool {
+	return l.pool(item).fits(vrf, prefixSize(p))
+}
+
+func (l *vrfShareLedger) take(item poolItem, vrf string, p netip.Prefix) {
+	l.pool(item).take(vrf, p)
+}
+
+func (l *vrfShareLedger) takeAll(items []poolItem, vrf string, parts []netip.Prefix) {
+	for _, p := range parts {
+		l.state(items, p).take(vrf, p)
+	}
+}
+
+// fitsAll checks a multi-prefix allocation, which may put several blocks
+// into the same pool.
+func (l *vrfShareLedger) fitsAll(items []poolItem, vrf string, parts []netip.Prefix) bool {
+	if l == nil {
+		return true
+	}
+	extra := map[*poolShareState]*big.Int{}
+	for _, p := range parts {
+		st := l.state(items, p)
+		if st == nil {
+			continue
+		}
+		if extra[st] == nil {
+			extra[st] = big.NewInt(0)
+		}
+		extra[st].Add(extra[st], prefixSize(p))
+	}
+	for st, n := range extra {
+		if !st.fits(vrf, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// shareDetail names the pools whose VRF share blocked the segment.
+func (l *vrfShareLedger) shareDetail(vrf string, blocked []poolItem) string {
+	if l == nil || len(blocked) == 0 {
+		return ""
+	}
+	var parts []string
+	for _, item := range blocked {
+		st := l.pools[item.Pool.ID]
+		percent, _ := st.shares.percentFor(vrf)
+		parts = append(parts, fmt.Sprintf("%d%% of %s", percent, item.Prefix))
+	}
+	return "; VRF " + vrfShareKey(vrf) + " share exhausted (" + strings.Join(parts, ", ") + ")"
+}
+
+// CapacityShare is the consumption of one VRF within a pool that has shares.
+type CapacityShare struct {
+	VRF         string
+	Share       string
+	Limit       string
+	Used        string
+	Utilization string
+	Over        bool
+}
+
+func buildCapacityShares(pool Pool, prefix netip.Prefix, segs []Segment, family string) []CapacityShare {
+	shares := poolVRFShares(pool)
+	if shares.Empty() {
+		return nil
+	}
+	usage := poolVRFUsage(prefix, segs, family, false)
+	var out []CapacityShare
+	listed := map[string]bool{}
+	add := func(name string, percent int, capped bool) {
+		used := usage[vrfShareKey(name)]
+		if used == nil {
+			used = big.NewInt(0)
+		}
+		row := CapacityShare{VRF: name, Used: formatBigInt(used), Share: "—", Limit: "—"}
+		if capped {
+			limit := shareLimit(prefix, percent)
+			row.Share = strconv.Itoa(percent) + "%"
+			row.Limit = formatBigInt(limit)
+			row.Utilization = ratioPercent(used, limit)
+			row.Over = used.Cmp(limit) > 0
+		}
+		out = append(out, row)
+	}
+	for _, e := range shares.Entries {
+		listed[vrfShareKey(e.VRF)] = true
+		add(e.VRF, e.Percent, true)
+	}
+	names := map[string]string{}
+	for _, s := range segs {
+		key := vrfShareKey(s.VRF)
+		if _, ok := names[key]; !ok && strings.TrimSpace(s.VRF) != "" {
+			names[key] = strings.TrimSpace(s.VRF)
+		}
+	}
+	var others []string
+	for key := range usage {
+		if !listed[key] {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	for _, key := range others {
+		name := names[key]
+		if name == "" {
+			name = key
+		}
+		add(name, shares.Other, shares.Other > 0)
+	}
+	return out
+}
+
+// vrfSharesValue normalizes form input for the vrf_shares column; blank input
+// clears the shares.
+func vrfSharesValue(raw string) (any, error) {
+	shares, err := parseVRFShares(raw)
+	if err != nil {
+		return nil, err
+	}
+	if shares.Empty() {
+		return nil, nil
+	}
+	return shares.String(), nil
+}
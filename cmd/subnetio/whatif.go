@@ -34,6 +34,15 @@ type WhatIfResult struct {
 	Unallocated    []PlanChange
 	Conflicts      []Conflict
 	Summary        string
+	PoolChange     *PoolChange
+	Outside        []PlanChange
+	Absorbed       bool
+}
+
+type PoolChange struct {
+	Pool    Pool
+	Action  string
+	NewCIDR string
 }
 
 func parseWhatIfSegment(c *gin.Context, sites []Site) (Segment, error) {
@@ -115,6 +124,137 @@ func runWhatIfPlan(existing []Segment, pools []Pool, sites []Site, extra Segment
 		result.ProposedCIDRV6 = p.String()
 	}
 
+	collectPlanChanges(&result, existing, planV4, planV6)
+
+	result.Summary = "changes: " + itoa(len(result.Changes)) + ", unallocated: " + itoa(len(result.Unallocated))
+	return result
+}
+
+func parsePoolWhatIf(c *gin.Context, pools []Pool) (PoolChange, error) {
+	poolID, _ := strconv.ParseInt(c.PostForm("pool_id"), 10, 64)
+	action := strings.TrimSpace(c.PostForm("whatif_pool_action"))
+	var pool Pool
+	found := false
+	for _, p := range pools {
+		if p.ID == poolID {
+			pool = p
+			found = true
+			break
+		}
+	}
+	if !found {
+		return PoolChange{}, errors.New("what-if: invalid pool")
+	}
+	change := PoolChange{Pool: pool, Action: action}
+	switch action {
+	case "remove":
+	case "resize":
+		raw := strings.TrimSpace(c.PostForm("whatif_pool_cidr"))
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return PoolChange{}, errors.New("what-if: invalid pool CIDR " + raw)
+		}
+		prefix = prefix.Masked()
+		if prefix.Addr().Is6() != (normalizePoolFamily(pool.Family) == "ipv6") {
+			return PoolChange{}, errors.New("what-if: pool family cannot change")
+		}
+		change.NewCIDR = prefix.String()
+	default:
+		return PoolChange{}, errors.New("what-if: action must be remove or resize")
+	}
+	return change, nil
+}
+
+func runPoolWhatIfPlan(existing []Segment, pools []Pool, sites []Site, change PoolChange, rules ProjectRules) WhatIfResult {
+	var planPools []Pool
+	for _, p := range pools {
+		if p.ID != change.Pool.ID {
+			planPools = append(planPools, p)
+			continue
+		}
+		if change.Action == "resize" {
+			p.CIDR = change.NewCIDR
+			planPools = append(planPools, p)
+		}
+	}
+
+	reservedV4, reservedV6, _ := buildReservedIndex(sites)
+	planV4, planV6, planConflicts := planAllocations(existing, planPools, reservedV4, reservedV6, rules)
+	plannedSegments := applyPlan(existing, planV4, planV6)
+	_, conflicts := analyzeAll(plannedSegments, planPools, sites, rules)
+
+	result := WhatIfResult{
+		PoolChange: &change,
+		Conflicts:  append(planConflicts, conflicts...),
+	}
+	collectPlanChanges(&result, existing, planV4, planV6)
+
+	lockedOutside := 0
+	for _, s := range existing {
+		if s.SiteID != change.Pool.SiteID {
+			continue
+		}
+		cidr := segmentCIDRByFamily(s, normalizePoolFamily(change.Pool.Family))
+		if !cidr.Valid {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr.String)
+		if err != nil {
+			continue
+		}
+		old, err := netip.ParsePrefix(change.Pool.CIDR)
+		if err != nil || !prefixWithin(old.Masked(), prefix) {
+			continue
+		}
+		if prefixCoveredByPools(prefix, s.SiteID, planPools) {
+			continue
+		}
+		outside := PlanChange{
+			Site: s.Site,
+			VRF:  s.VRF,
+			VLAN: s.VLAN,
+			Name: s.Name,
+		}
+		if prefix.Addr().Is6() {
+			outside.OldCIDRV6 = prefix.String()
+		} else {
+			outside.OldCIDR = prefix.String()
+		}
+		outside.Status = "outside"
+		if s.Locked {
+			outside.Status = "locked"
+			lockedOutside++
+		}
+		result.Outside = append(result.Outside, outside)
+	}
+
+	result.Absorbed = len(result.Unallocated) == 0 && lockedOutside == 0
+	absorbed := "no"
+	if result.Absorbed {
+		absorbed = "yes"
+	}
+	result.Summary = "outside: " + itoa(len(result.Outside)) + ", changes: " + itoa(len(result.Changes)) +
+		", unallocated: " + itoa(len(result.Unallocated)) + ", remaining pools absorb re-allocation: " + absorbed
+	return result
+}
+
+func prefixCoveredByPools(prefix netip.Prefix, siteID int64, pools []Pool) bool {
+	for _, p := range pools {
+		if p.SiteID != siteID {
+			continue
+		}
+		poolPrefix, err := netip.ParsePrefix(p.CIDR)
+		if err != nil {
+			continue
+		}
+		if prefixWithin(poolPrefix.Masked(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func collectPlanChanges(result *WhatIfResult, existing []Segment, planV4 map[int64]netip.Prefix, planV6 map[int64]netip.Prefix) {
 	for _, s := range existing {
 		oldCIDR := cidrString(s.CIDR)
 		oldCIDRV6 := cidrString(s.CIDRV6)
@@ -165,9 +305,6 @@ func runWhatIfPlan(existing []Segment, pools []Pool, sites []Site, extra Segment
 		}
 		return result.Changes[i].VLAN < result.Changes[j].VLAN
 	})
-
-	result.Summary = "changes: " + itoa(len(result.Changes)) + ", unallocated: " + itoa(len(result.Unallocated))
-	return result
 }
 
 func applyPlan(segs []Segment, planV4 map[int64]netip.Prefix, planV6 map[int64]netip.Prefix) []Segment {
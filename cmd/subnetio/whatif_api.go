@@ -0,0 +1,389 @@
+// Copyright (c) 2025 Berik Ashimov
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+// whatIfAPIMaxSegments bounds a POST /api/v1/whatif batch.
+const whatIfAPIMaxSegments = 200
+
+// whatIfAPIRequest is the body of POST /api/v1/whatif: one hypothetical
+// segment in "segment", a batch in "segments", or both.
+type whatIfAPIRequest struct {
+	ProjectID int64              `json:"project_id"`
+	Segment   *whatIfAPISegment  `json:"segment"`
+	Segments  []whatIfAPISegment `json:"segments"`
+}
+
+type whatIfAPISegment struct {
+	Site        string `json:"site"`
+	SiteID      int64  `json:"site_id"`
+	VRF         string `json:"vrf"`
+	VLAN        int    `json:"vlan"`
+	Name        string `json:"name"`
+	Hosts       int64  `json:"hosts"`
+	Prefix      int64  `json:"prefix"`
+	PrefixV6    int64  `json:"prefix_v6"`
+	Kind        string `json:"kind"`
+	PoolTier    string `json:"pool_tier"`
+	MultiPrefix bool   `json:"multi_prefix"`
+}
+
+type whatIfAPIResponse struct {
+	ProjectID    int64                `json:"project_id"`
+	Segments     []whatIfAPIPlanned   `json:"segments"`
+	Changes      []whatIfAPIChange    `json:"changes"`
+	Unallocated  []whatIfAPIChange    `json:"unallocated"`
+	Conflicts    []whatIfAPIConflict  `json:"conflicts"`
+	NewConflicts []whatIfAPIConflict  `json:"new_conflicts"`
+	Utilization  whatIfAPIUtilization `json:"utilization"`
+	Summary      string               `json:"summary"`
+}
+
+// whatIfAPIPlanned is the outcome for one hypothetical segment, in request
+// order.
+type whatIfAPIPlanned struct {
+	Site      string              `json:"site"`
+	VRF       string              `json:"vrf"`
+	VLAN      int                 `json:"vlan"`
+	Name      string              `json:"name"`
+	Kind      string              `json:"kind"`
+	Allocated bool                `json:"allocated"`
+	CIDR      string              `json:"cidr,omitempty"`
+	CIDRV6    string              `json:"cidr_v6,omitempty"`
+	Pool      *whatIfAPIPool      `json:"pool,omitempty"`
+	PoolV6    *whatIfAPIPool      `json:"pool_v6,omitempty"`
+	Conflicts []whatIfAPIConflict `json:"conflicts"`
+}
+
+type whatIfAPIPool struct {
+	ID     int64  `json:"id"`
+	CIDR   string `json:"cidr"`
+	Family string `json:"family"`
+	Kind   string `json:"kind"`
+	Tier   string `json:"tier,omitempty"`
+}
+
+// whatIfAPIChange is an existing segment the plan would move or leave
+// without a prefix.
+type whatIfAPIChange struct {
+	Site      string `json:"site"`
+	VRF       string `json:"vrf"`
+	VLAN      int    `json:"vlan"`
+	Name      string `json:"name"`
+	OldCIDR   string `json:"old_cidr,omitempty"`
+	NewCIDR   string `json:"new_cidr,omitempty"`
+	OldCIDRV6 string `json:"old_cidr_v6,omitempty"`
+	NewCIDRV6 string `json:"new_cidr_v6,omitempty"`
+	Status    string `json:"status"`
+}
+
+type whatIfAPIConflict struct {
+	ID           string                  `json:"id"`
+	Level        string                  `json:"level"`
+	Kind         string                  `json:"kind"`
+	Detail       string                  `json:"detail"`
+	Alternatives []AllocationAlternative `json:"alternatives,omitempty"`
+}
+
+// whatIfAPIUtilization compares pool usage now with usage after the plan.
+type whatIfAPIUtilization struct {
+	IPv4  whatIfAPIUsage       `json:"ipv4"`
+	IPv6  whatIfAPIUsage       `json:"ipv6"`
+	Pools []whatIfAPIPoolUsage `json:"pools"`
+}
+
+type whatIfAPIUsage struct {
+	Total             string `json:"total"`
+	UsedBefore        string `json:"used_before"`
+	UsedAfter         string `json:"used_after"`
+	Delta             string `json:"delta"`
+	UtilizationBefore string `json:"utilization_before"`
+	UtilizationAfter  string `json:"utilization_after"`
+}
+
+type whatIfAPIPoolUsage struct {
+	Site   string `json:"site"`
+	CIDR   string `json:"cidr"`
+	Family string `json:"family"`
+	whatIfAPIUsage
+}
+
+// requestSegments returns the hypothetical segments of the request as unsaved
+// segments with IDs -1, -2, ...
+func (r whatIfAPIRequest) requestSegments(sites []Site) ([]Segment, error) {
+	var raw []whatIfAPISegment
+	if r.Segment != nil {
+		raw = append(raw, *r.Segment)
+	}
+	raw = append(raw, r.Segments...)
+	if len(raw) == 0 {
+		return nil, errors.New("segment or segments is required")
+	}
+	if len(raw) > whatIfAPIMaxSegments {
+		return nil, fmt.Errorf("at most %d segments per request", whatIfAPIMaxSegments)
+	}
+	out := make([]Segment, 0, len(raw))
+	for i, s := range raw {
+		seg, err := s.segment(sites)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %w", i+1, err)
+		}
+		seg.ID = int64(-(i + 1))
+		out = append(out, seg)
+	}
+	return out, nil
+}
+
+func (s whatIfAPISegment) segment(sites []Site) (Segment, error) {
+	var site Site
+	for _, candidate := range sites {
+		if (s.SiteID > 0 && candidate.ID == s.SiteID) || (s.SiteID == 0 && strings.EqualFold(candidate.Name, strings.TrimSpace(s.Site))) {
+			site = candidate
+			break
+		}
+	}
+	if site.ID == 0 {
+		return Segment{}, errors.New("unknown site")
+	}
+	vrf := strings.TrimSpace(s.VRF)
+	name := strings.TrimSpace(s.Name)
+	if vrf == "" || name == "" || s.VLAN <= 0 {
+		return Segment{}, errors.New("site, vrf, vlan, and name are required")
+	}
+	if s.VLAN > 4094 {
+		return Segment{}, fmt.Errorf("invalid vlan %d (1-4094)", s.VLAN)
+	}
+	kind, err := parseSegmentKind(s.Kind)
+	if err != nil {
+		return Segment{}, err
+	}
+	var hosts, prefix, prefixV6 sql.NullInt64
+	if s.Hosts > 0 {
+		hosts = sql.NullInt64{Int64: s.Hosts, Valid: true}
+	}
+	if s.Prefix != 0 {
+		if s.Prefix < 1 || s.Prefix > 32 {
+			return Segment{}, fmt.Errorf("invalid prefix /%d", s.Prefix)
+		}
+		prefix = sql.NullInt64{Int64: s.Prefix, Valid: true}
+	}
+	if s.PrefixV6 != 0 {
+		if s.PrefixV6 < 1 || s.PrefixV6 > 128 {
+			return Segment{}, fmt.Errorf("invalid prefix_v6 /%d", s.PrefixV6)
+		}
+		prefixV6 = sql.NullInt64{Int64: s.PrefixV6, Valid: true}
+	}
+	if kind == SegmentKindLink {
+		if prefix, prefixV6, err = linkSizes(hosts, prefix, prefixV6); err != nil {
+			return Segment{}, err
+		}
+	} else if !hosts.Valid && !prefix.Valid && !prefixV6.Valid {
+		return Segment{}, errors.New("hosts or prefix required")
+	}
+	seg := Segment{
+		SiteID:      site.ID,
+		Site:        site.Name,
+		VRF:         vrf,
+		VLAN:        s.VLAN,
+		Name:        name,
+		Hosts:       hosts,
+		Prefix:      prefix,
+		PrefixV6:    prefixV6,
+		MultiPrefix: s.MultiPrefix && kind == SegmentKindLAN,
+		Kind:        kind,
+	}
+	if tier := normalizeTierName(s.PoolTier); tier != "" {
+		seg.PoolTier = sql.NullString{String: tier, Valid: true}
+	}
+	return seg, nil
+}
+
+// whatIfAPI plans the project with the hypothetical segments added.
+func whatIfAPI(db *sql.DB, projectID int64, req whatIfAPIRequest) (whatIfAPIResponse, error) {
+	sites, err := listSites(db, projectID)
+	if err != nil {
+		return whatIfAPIResponse{}, err
+	}
+	segs, err := listSegments(db, projectID)
+	if err != nil {
+		return whatIfAPIResponse{}, err
+	}
+	pools, err := listPools(db, projectID)
+	if err != nil {
+		return whatIfAPIResponse{}, err
+	}
+	rules, _ := getProjectRules(db, projectID)
+	extras, err := req.requestSegments(sites)
+	if err != nil {
+		return whatIfAPIResponse{}, err
+	}
+	for i, s := range extras {
+		if s.PoolTier.Valid {
+			if err := checkPoolTierReference(db, projectID, s.PoolTier.String); err != nil {
+				return whatIfAPIResponse{}, fmt.Errorf("segment %d: %w", i+1, err)
+			}
+		}
+	}
+	out := runWhatIfBatch(segs, pools, sites, extras, rules)
+	out.ProjectID = projectID
+	return out, nil
+}
+
+func runWhatIfBatch(existing []Segment, pools []Pool, sites []Site, extras []Segment, rules ProjectRules) whatIfAPIResponse {
+	reservedV4, reservedV6, _ := buildReservedIndex(sites)
+
+	baseV4, baseV6, baseConflicts := planAllocations(existing, pools, reservedV4, reservedV6, rules)
+	_, before := analyzeAll(applyPlan(existing, baseV4, baseV6), pools, sites, rules)
+	known := map[string]bool{}
+	for _, c := range append(baseConflicts, before...) {
+		known[c.ID()] = true
+	}
+
+	planSegments := append(append([]Segment{}, existing...), extras...)
+	planV4, planV6, planConflicts := planAllocations(planSegments, pools, reservedV4, reservedV6, rules)
+	planned := applyPlan(planSegments, planV4, planV6)
+	_, after := analyzeAll(planned, pools, sites, rules)
+	conflicts := sortConflicts(append(planConflicts, after...))
+
+	out := whatIfAPIResponse{
+		Segments:     []whatIfAPIPlanned{},
+		Changes:      []whatIfAPIChange{},
+		Unallocated:  []whatIfAPIChange{},
+		Conflicts:    []whatIfAPIConflict{},
+		NewConflicts: []whatIfAPIConflict{},
+	}
+	bySegment := map[int64][]whatIfAPIConflict{}
+	for _, c := range conflicts {
+		item := whatIfAPIConflict{ID: c.ID(), Level: c.Level, Kind: c.Kind, Detail: c.Detail, Alternatives: c.Alternatives}
+		out.Conflicts = append(out.Conflicts, item)
+		if !known[item.ID] {
+			out.NewConflicts = append(out.NewConflicts, item)
+		}
+		if c.segmentID < 0 {
+			bySegment[c.segmentID] = append(bySegment[c.segmentID], item)
+		}
+	}
+
+	allocated := 0
+	for _, s := range extras {
+		item := whatIfAPIPlanned{Site: s.Site, VRF: s.VRF, VLAN: s.VLAN, Name: s.Name, Kind: s.Kind, Conflicts: bySegment[s.ID]}
+		if item.Conflicts == nil {
+			item.Conflicts = []whatIfAPIConflict{}
+		}
+		if p, ok := planV4[s.ID]; ok {
+			item.CIDR = p.String()
+			item.Pool = whatIfPoolFor(p, s.SiteID, pools)
+		}
+		if p, ok := planV6[s.ID]; ok {
+			item.CIDRV6 = p.String()
+			item.PoolV6 = whatIfPoolFor(p, s.SiteID, pools)
+		}
+		item.Allocated = item.CIDR != "" || item.CIDRV6 != ""
+		if item.Allocated {
+			allocated++
+		}
+		out.Segments = append(out.Segments, item)
+	}
+
+	var result WhatIfResult
+	collectPlanChanges(&result, existing, planV4, planV6)
+	for _, ch := range result.Changes {
+		out.Changes = append(out.Changes, whatIfAPIChangeFrom(ch))
+	}
+	for _, ch := range result.Unallocated {
+		out.Unallocated = append(out.Unallocated, whatIfAPIChangeFrom(ch))
+	}
+
+	out.Utilization = whatIfUtilization(
+		buildCapacityReport(existing, pools, sites, 0, 0, 64),
+		buildCapacityReport(planned, pools, sites, 0, 0, 64),
+	)
+	out.Summary = "allocated: " + itoa(allocated) + "/" + itoa(len(extras)) +
+		", changes: " + itoa(len(out.Changes)) + ", unallocated: " + itoa(len(out.Unallocated)) +
+		", new conflicts: " + itoa(len(out.NewConflicts))
+	return out
+}
+
+func whatIfAPIChangeFrom(ch PlanChange) whatIfAPIChange {
+	return whatIfAPIChange{
+		Site: ch.Site, VRF: ch.VRF, VLAN: ch.VLAN, Name: ch.Name,
+		OldCIDR: ch.OldCIDR, NewCIDR: ch.NewCIDR, OldCIDRV6: ch.OldCIDRV6, NewCIDRV6: ch.NewCIDRV6,
+		Status: ch.Status,
+	}
+}
+
+// whatIfPoolFor finds the site pool of the prefix's family that holds it.
+func whatIfPoolFor(prefix netip.Prefix, siteID int64, pools []Pool) *whatIfAPIPool {
+	family := "ipv4"
+	if prefix.Addr().Is6() {
+		family = "ipv6"
+	}
+	for _, p := range pools {
+		if p.SiteID != siteID || normalizePoolFamily(p.Family) != family {
+			continue
+		}
+		poolPrefix, err := netip.ParsePrefix(strings.TrimSpace(p.CIDR))
+		if err != nil || !prefixWithin(poolPrefix.Masked(), prefix) {
+			continue
+		}
+		return &whatIfAPIPool{ID: p.ID, CIDR: poolPrefix.Masked().String(), Family: family, Kind: normalizePoolKind(p.Kind), Tier: poolTierValue(p)}
+	}
+	return nil
+}
+
+// whatIfUtilization pairs two capacity reports over the same pools.
+func whatIfUtilization(before, after CapacityReport) whatIfAPIUtilization {
+	out := whatIfAPIUtilization{
+		IPv4:  whatIfUsage(before.SummaryV4.Total, before.SummaryV4.Used, after.SummaryV4.Used, before.SummaryV4.Utilization, after.SummaryV4.Utilization),
+		IPv6:  whatIfUsage(before.SummaryV6.Total, before.SummaryV6.Used, after.SummaryV6.Used, before.SummaryV6.Utilization, after.SummaryV6.Utilization),
+		Pools: []whatIfAPIPoolUsage{},
+	}
+	for i, b := range before.Pools {
+		if i >= len(after.Pools) {
+			break
+		}
+		a := after.Pools[i]
+		if a.Used == b.Used {
+			continue
+		}
+		out.Pools = append(out.Pools, whatIfAPIPoolUsage{
+			Site: b.Site, CIDR: b.CIDR, Family: b.Family,
+			whatIfAPIUsage: whatIfUsage(b.Total, b.Used, a.Used, b.Utilization, a.Utilization),
+		})
+	}
+	return out
+}
+
+func whatIfUsage(total, usedBefore, usedAfter, utilBefore, utilAfter string) whatIfAPIUsage {
+	return whatIfAPIUsage{
+		Total:             total,
+		UsedBefore:        usedBefore,
+		UsedAfter:         usedAfter,
+		Delta:             capacityDelta(usedBefore, usedAfter),
+		UtilizationBefore: utilBefore,
+		UtilizationAfter:  utilAfter,
+	}
+}
+
+// capacityDelta subtracts two address counts as formatted by formatBigInt
+// and returns the signed difference in the same format.
+func capacityDelta(before, after string) string {
+	b, okB := new(big.Int).SetString(strings.ReplaceAll(before, "_", ""), 10)
+	a, okA := new(big.Int).SetString(strings.ReplaceAll(after, "_", ""), 10)
+	if !okB || !okA {
+		return ""
+	}
+	d := new(big.Int).Sub(a, b)
+	if d.Sign() < 0 {
+		return "-" + formatBigInt(d.Neg(d))
+	}
+	return "+" + formatBigInt(d)
+}